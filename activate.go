@@ -0,0 +1,77 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/urfave/cli"
+)
+
+var activateCommand = cli.Command{
+	Name:  "activate",
+	Usage: "stamp an identity on a template system container and execute its user defined process",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the template system
+container to be activated.
+
+EXAMPLE:
+To keep a pool of warm templates and activate one of them on demand:
+
+       # sysbox-runc create --template --bundle /mybundle pool-1
+       ...
+       # sysbox-runc activate --hostname fn-42 pool-1`,
+	Description: `The activate command stamps an identity on a template system container (see
+"create --template"), which is fully set up but whose init process is held
+before executing the user defined process, and then executes that process.
+
+The identity is the container's hostname (the template's, unless given) and
+machine ID (/etc/machine-id; random, unless given). The container's subid range
+can't be stamped: it's allocated when the template is created (as its user
+namespace is set up then), so each template in a pool gets its own.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "hostname of the container",
+		},
+		cli.StringFlag{
+			Name:  "machine-id",
+			Usage: "machine ID of the container (32 lowercase hex chars); random if not given",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		container, err := getContainer(context)
+		if err != nil {
+			return err
+		}
+		status, err := container.Status()
+		if err != nil {
+			return err
+		}
+		if status != libcontainer.Created {
+			return fmt.Errorf("cannot activate a container in the %s state", status)
+		}
+
+		notifySocket, err := notifySocketStart(context, os.Getenv("NOTIFY_SOCKET"), container.ID())
+		if err != nil {
+			return err
+		}
+		id := libcontainer.Identity{
+			Hostname:  context.String("hostname"),
+			MachineID: context.String("machine-id"),
+		}
+		if err := container.Activate(id); err != nil {
+			return err
+		}
+		if notifySocket != nil {
+			return notifySocket.waitForContainer(container)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,197 @@
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var cleanupCommand = cli.Command{
+	Name:  "cleanup",
+	Usage: "tear down the leftovers of dead system containers (e.g., after a host crash)",
+	ArgsUsage: `[<container-id>...]
+
+Where "<container-id>" is the name of a container to clean up; with --all, all
+the containers in the state root are checked.
+
+EXAMPLE:
+To clean up after a crash, run this command once the host boots (before the
+containers are restarted):
+
+       # sysbox-runc cleanup --all`,
+	Description: `The cleanup command tears down everything left behind by dead system
+containers (i.e., whose init process is gone, or whose pid now refers to
+another process, as its start time differs from the recorded one), and prints
+their IDs: their cgroups (including the child cgroups created in them), mounts,
+rootfs clones and state dirs, and their registrations with sysbox-mgr and
+sysbox-fs (which release the resources they hold for them, e.g., shiftfs marks
+and the dirs backing their special mounts). Live containers are left alone.
+
+State dirs without a state (i.e., of a create that was interrupted) are removed
+once older than the grace period (so that the creates in progress are left
+alone), after unregistering their containers.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "all, a",
+			Usage: "clean up all the dead containers in the state root",
+		},
+		cli.DurationFlag{
+			Name:  "grace",
+			Value: 10 * time.Minute,
+			Usage: "age from which a state dir without a state is considered abandoned",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "print the IDs of the containers to clean up, without cleaning them up",
+		},
+		cli.BoolFlag{
+			Name:  "sync",
+			Usage: "wait for sysbox-mgr to release the containers' resources, rather than letting it do it in the background (if its client supports that; the default one always waits)",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		ids := []string(context.Args())
+		if context.Bool("all") {
+			if len(ids) > 0 {
+				return errors.New("container IDs can't be given with --all")
+			}
+			var err error
+			if ids, err = stateDirs(context); err != nil {
+				return err
+			}
+		} else if len(ids) == 0 {
+			return errors.New("no container IDs given (or --all)")
+		}
+
+		var opts []func(*libcontainer.LinuxFactory) error
+		if context.Bool("sync") {
+			opts = append(opts, libcontainer.SysMgrSyncRelease)
+		}
+		factory, err := loadFactory(context, opts...)
+		if err != nil {
+			return err
+		}
+
+		var cleanupErr error
+		for _, id := range ids {
+			cleaned, err := cleanupContainer(context, factory, id)
+			if err != nil {
+				logrus.Errorf("failed to clean up container %s: %v", id, err)
+				cleanupErr = errors.New("failed to clean up some containers")
+				continue
+			}
+			if cleaned {
+				fmt.Println(id)
+			}
+		}
+		return cleanupErr
+	},
+}
+
+// stateDirs returns the IDs of the containers with a state dir in the state
+// root.
+func stateDirs(context *cli.Context) ([]string, error) {
+	root, err := factoryRoot(context)
+	if err != nil {
+		return nil, err
+	}
+	list, err := ioutil.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, item := range list {
+		if item.IsDir() {
+			ids = append(ids, item.Name())
+		}
+	}
+	return ids, nil
+}
+
+// cleanupContainer tears down the given container if it's dead, and returns
+// whether it did (or would, with --dry-run).
+func cleanupContainer(context *cli.Context, factory libcontainer.Factory, id string) (bool, error) {
+	container, err := factory.Load(id)
+	if err != nil {
+		if lerr, ok := err.(libcontainer.Error); ok && lerr.Code() == libcontainer.ContainerNotExists {
+			return cleanupStateDir(context, id)
+		}
+		return false, err
+	}
+
+	// the status accounts for the init process' start time, so a pid reused
+	// since the container died doesn't make it alive
+	status, err := container.Status()
+	if err != nil {
+		return false, err
+	}
+	if status != libcontainer.Stopped {
+		logrus.Debugf("container %s is %s; not cleaning it up", id, status)
+		return false, nil
+	}
+
+	logrus.Infof("container %s is dead; cleaning it up", id)
+	if context.Bool("dry-run") {
+		return true, nil
+	}
+	return true, container.Destroy()
+}
+
+// cleanupStateDir removes the given container's state dir, which has no
+// state, once it's older than the grace period; its container is unregistered
+// from sysbox-mgr and sysbox-fs first, as it may have been registered before
+// its create was interrupted.
+func cleanupStateDir(context *cli.Context, id string) (bool, error) {
+	path := filepath.Join(context.GlobalString("root"), id)
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, fmt.Errorf("container %s does not exist", id)
+		}
+		return false, err
+	}
+	if age := time.Since(fi.ModTime()); age < context.Duration("grace") {
+		logrus.Debugf("state dir of container %s has no state, but is only %s old; not cleaning it up", id, age.Round(time.Second))
+		return false, nil
+	}
+
+	logrus.Infof("state dir of container %s has no state; cleaning it up", id)
+	if context.Bool("dry-run") {
+		return true, nil
+	}
+
+	key, err := containerKey(id, context.GlobalString("root"))
+	if err != nil {
+		return false, err
+	}
+	if !context.GlobalBool("no-sysbox-fs") {
+		fs := sysbox.NewFs(key, true)
+		fs.Reg = true
+		if err := fs.Unregister(); err != nil && sysbox.GetIPCErrorKind(err) != sysbox.IPCNotFound {
+			return false, err
+		}
+	}
+	if !context.GlobalBool("no-sysbox-mgr") {
+		mgr := sysbox.NewMgr(key, true)
+		mgr.SyncRelease = context.Bool("sync")
+		if err := mgr.Unregister(); err != nil && sysbox.GetIPCErrorKind(err) != sysbox.IPCNotFound {
+			return false, err
+		}
+	}
+
+	return true, os.RemoveAll(path)
+}
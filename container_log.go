@@ -0,0 +1,89 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// defaultContainerLogDir is the default dir of the containers' log files (see
+// the --container-log-dir flag).
+const defaultContainerLogDir = "/var/log/sysbox-runc"
+
+// containerLogging is set once the logging of the command has been set up for
+// the container it operates on (see setupContainerLogging()).
+var containerLogging bool
+
+// containerLogHook adds the id of the container a command operates on to its
+// log records, and writes them to the container's log file, if any (see
+// syscont.LogFileAnnotation).
+type containerLogHook struct {
+	id   string
+	file *os.File
+}
+
+func (h *containerLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *containerLogHook) Fire(entry *logrus.Entry) error {
+	if _, ok := entry.Data["id"]; !ok {
+		entry.Data["id"] = h.id
+	}
+	if h.file == nil {
+		return nil
+	}
+	b, err := entry.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = h.file.Write(b)
+	return err
+}
+
+// setupContainerLogging sets up the logging of a command operating on the
+// container with the given id and annotations: its log records carry the
+// container's id, and its log level and file are those given by the
+// container's annotations (see syscont.LogLevelAnnotation), if any. The log
+// file is in the container log dir; it's not opened through a symlink.
+func setupContainerLogging(context *cli.Context, id string, annotations map[string]string) error {
+	if containerLogging {
+		return nil
+	}
+
+	level, ok, err := syscont.GetLogLevel(annotations)
+	if err != nil {
+		return err
+	}
+	path, err := syscont.GetLogFile(annotations)
+	if err != nil {
+		return err
+	}
+
+	hook := &containerLogHook{id: id}
+	if path != "" {
+		dir := context.GlobalString("container-log-dir")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create the container log dir: %w", err)
+		}
+		path = filepath.Join(dir, path)
+		hook.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_SYNC|unix.O_NOFOLLOW, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open the container's log file: %w", err)
+		}
+	}
+	if ok {
+		logrus.SetLevel(level)
+	}
+	logrus.AddHook(hook)
+	containerLogging = true
+
+	return nil
+}
@@ -0,0 +1,99 @@
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/opencontainers/runc/libsysbox/spectest"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+)
+
+// convertSpecCommand converts specs the way the spec conversion tests do (see
+// the spectest package), to generate the golden files of custom specs.
+var convertSpecCommand = cli.Command{
+	Name:  "convert-spec",
+	Usage: "convert a container specification as the spec conversion tests do",
+	ArgsUsage: `<spec>
+
+Where "<spec>" is the path to an OCI specification file (config.json), or "-"
+to read it from stdin.`,
+	Description: `The convert-spec command converts the given container spec to a sys
+container spec, as done by the spec conversion tests (spectest.Convert()):
+as when sysbox-runc creates a container with ID "` + spectest.ContainerID + `", except that no
+sysbox-mgr mounts are added and the rootfs is replaced by an empty dir. The
+result is meant for the golden files of fixtures checked by spectest.Check()
+(see spectest.CorpusAt()).
+
+With --reproducible, the spec is converted as the spec-convert command does.`,
+	Hidden: true,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "reproducible",
+			Usage: "convert the spec as the spec-convert command does",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "write the converted spec to the given file rather than stdout",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+
+		path := context.Args().First()
+
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+
+		var out []byte
+		if context.Bool("reproducible") {
+			out, err = syscont.ConvertSpecJSON(data, syscont.ConvertOpts{Reproducible: true})
+		} else {
+			out, err = convertSpecForTest(data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to convert spec %s: %v", path, err)
+		}
+
+		if output := context.String("output"); output != "" {
+			return ioutil.WriteFile(output, out, 0644)
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	},
+}
+
+// convertSpecForTest converts the given spec with spectest.Convert(), in the
+// format of the golden files.
+func convertSpecForTest(data []byte) ([]byte, error) {
+	var spec specs.Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse spec: %v", err)
+	}
+
+	conv, err := spectest.Convert(&spec)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := json.MarshalIndent(conv, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
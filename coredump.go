@@ -0,0 +1,306 @@
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+var coreDumpCommand = cli.Command{
+	Name:  "coredump",
+	Usage: "store a core dump in the core dump dir of the sys container it comes from (core_pattern handler)",
+	ArgsUsage: `<host-pid> <pid> <signal> <time> <exe>
+
+Where the arguments are those of the dumping process, as given by the kernel
+(see core(5)).
+
+EXAMPLE:
+To make sysbox-runc handle the host's core dumps:
+
+       # echo '|/usr/bin/sysbox-runc coredump %P %p %s %t %e' > /proc/sys/kernel/core_pattern`,
+	Description: `The coredump command is a core dump handler (see core(5)): it reads a core
+dump from its stdin and stores it in the core dump dir of the sys container the
+dumping process belongs to, if the container captures its core dumps (see the
+"io.nestybox.sysbox.core-dump" annotation). The core dump is owned by the
+dumping process' user, and named per the container's core_pattern.
+
+Core dumps of other processes are stored in the dir given by --default-dir, or
+discarded if it's not set.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "default-dir",
+			Usage: "dir for core dumps of processes outside of sys containers that capture their core dumps",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 5, minArgs); err != nil {
+			return err
+		}
+		args := context.Args()
+
+		hostPid, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid host pid %q", args[0])
+		}
+
+		// the exe name may contain spaces
+		vals := map[byte]string{
+			'P': args[0],
+			'p': args[1],
+			's': args[2],
+			't': args[3],
+			'e': strings.Join(args[4:], " "),
+		}
+
+		id, cd, err := coreDumpContainer(context, hostPid)
+		if err != nil {
+			return err
+		}
+		if cd == nil {
+			dir := context.String("default-dir")
+			if dir == "" {
+				logrus.Infof("discarding core dump of process %d (%s)", hostPid, vals['e'])
+				return nil
+			}
+			cd = &configs.CoreDump{Dir: dir, Pattern: "core.%e.%p.%t"}
+		}
+
+		uid, gid, err := procFsIds(hostPid)
+		if err != nil {
+			return err
+		}
+
+		name := expandCorePattern(filepath.Base(cd.Pattern), vals)
+		if err := storeCoreDump(os.Stdin, cd, name, uid, gid); err != nil {
+			return fmt.Errorf("discarding core dump of process %d (%s) of container %q: %v", hostPid, vals['e'], id, err)
+		}
+
+		logrus.Infof("stored core dump of process %d (%s) of container %q in %s", hostPid, vals['e'], id,
+			filepath.Join(cd.Dir, name))
+		return nil
+	},
+}
+
+// ioctl to get the parent of a user namespace (see ioctl_ns(2))
+const nsGetParent = 0xb702
+
+// coreDumpContainer returns the ID and core dump config of the running sys
+// container that captures the core dumps of the given (host) process, if any.
+// The process belongs to a container if its user-ns is the container's or a
+// descendant of it (e.g., that of a container inside the sys container).
+func coreDumpContainer(context *cli.Context, pid int) (string, *configs.CoreDump, error) {
+	userns, err := usernsAncestry(pid)
+	if err != nil {
+		return "", nil, err
+	}
+
+	factory, err := loadFactory(context)
+	if err != nil {
+		return "", nil, err
+	}
+	root, err := filepath.Abs(context.GlobalString("root"))
+	if err != nil {
+		return "", nil, err
+	}
+	list, err := ioutil.ReadDir(root)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, item := range list {
+		if !item.IsDir() {
+			continue
+		}
+		container, err := factory.Load(item.Name())
+		if err != nil {
+			continue
+		}
+		config := container.Config()
+		if config.CoreDump == nil {
+			continue
+		}
+		state, err := container.State()
+		if err != nil || state.InitProcessPid == 0 {
+			continue
+		}
+
+		var st unix.Stat_t
+		if err := unix.Stat(fmt.Sprintf("/proc/%d/ns/user", state.InitProcessPid), &st); err != nil {
+			continue
+		}
+		for _, ns := range userns {
+			if ns.Dev == st.Dev && ns.Ino == st.Ino {
+				return container.ID(), config.CoreDump, nil
+			}
+		}
+	}
+
+	return "", nil, nil
+}
+
+// usernsAncestry returns the user-ns of the given process and its ancestors, up
+// to (but excluding) the initial user-ns.
+func usernsAncestry(pid int) ([]unix.Stat_t, error) {
+	fd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/user", pid), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open user-ns of process %d: %v", pid, err)
+	}
+
+	ancestry := []unix.Stat_t{}
+	for {
+		var st unix.Stat_t
+		if err := unix.Fstat(fd, &st); err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+
+		// fails with EPERM for the initial user-ns, which has no parent
+		parent, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), nsGetParent, 0)
+		unix.Close(fd)
+		if errno == unix.EPERM {
+			return ancestry, nil
+		}
+		if errno != 0 {
+			return nil, fmt.Errorf("failed to get parent user-ns: %v", errno)
+		}
+
+		ancestry = append(ancestry, st)
+		fd = int(parent)
+	}
+}
+
+// procFsIds returns the (host) filesystem uid and gid of the given process.
+func procFsIds(pid int) (int, int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return -1, -1, err
+	}
+	defer f.Close()
+
+	uid, gid := -1, -1
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// e.g., "Uid:	1000	1000	1000	1000" (real, effective, saved, fs)
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 5 {
+			continue
+		}
+		switch fields[0] {
+		case "Uid:":
+			uid, err = strconv.Atoi(fields[4])
+		case "Gid:":
+			gid, err = strconv.Atoi(fields[4])
+		}
+		if err != nil {
+			return -1, -1, fmt.Errorf("failed to parse /proc/%d/status: %v", pid, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return -1, -1, err
+	}
+	if uid == -1 || gid == -1 {
+		return -1, -1, fmt.Errorf("failed to get uid/gid of process %d", pid)
+	}
+	return uid, gid, nil
+}
+
+// expandCorePattern expands the given core dump file name pattern with the
+// given values, indexed by specifier (e.g., 'p' for "%p"). As done by the
+// kernel, slashes in the values are replaced by '!', so the result is a plain
+// file name.
+func expandCorePattern(pattern string, vals map[byte]string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i == len(pattern)-1 {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		if pattern[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		b.WriteString(strings.Replace(vals[pattern[i]], "/", "!", -1))
+	}
+
+	name := b.String()
+	if name == "" || name == "." || name == ".." {
+		name = "core"
+	}
+	return name
+}
+
+// storeCoreDump stores the core dump read from r in the given file of the core
+// dump dir, owned by the given uid and gid. The core dump is discarded if it
+// exceeds the config's size limits. The file must not exist (the dir may be
+// writable from within the container, so existing files and symlinks are not
+// trusted).
+func storeCoreDump(r io.Reader, cd *configs.CoreDump, name string, uid, gid int) error {
+	limit := cd.MaxSize
+	if cd.MaxTotal > 0 {
+		used, err := dirUsage(cd.Dir)
+		if err != nil {
+			return err
+		}
+		avail := cd.MaxTotal - used
+		if avail <= 0 {
+			return fmt.Errorf("core dump dir %s is full (max total %d bytes)", cd.Dir, cd.MaxTotal)
+		}
+		if limit == 0 || avail < limit {
+			limit = avail
+		}
+	}
+
+	path := filepath.Join(cd.Dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|unix.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	n, err := io.Copy(f, r)
+	if err == nil && limit > 0 && n > limit {
+		err = fmt.Errorf("core dump exceeds %d bytes", limit)
+	}
+	if err == nil {
+		err = f.Chown(uid, gid)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// dirUsage returns the total size of the regular files in the given dir
+// (non-recursively).
+func dirUsage(dir string) (int64, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		if e.Mode().IsRegular() {
+			total += e.Size()
+		}
+	}
+	return total, nil
+}
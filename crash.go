@@ -0,0 +1,57 @@
+// +build linux
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Phases of the create path of the commands that create containers (create,
+// run, restore), as reported in crash records.
+const (
+	phaseSetup   = "setup"   // spec loading and patching
+	phasePrepare = "prepare" // sysbox registrations and spec conversion
+	phaseCreate  = "create"  // container creation
+	phaseStart   = "start"   // container start (or restore)
+)
+
+// reportCrash logs a crash record (as structured fields) if the given error is
+// due to a recovered panic (see sysbox.PanicError). The digest is that of the
+// container's spec as loaded, before it's modified (see specHash()), so that it
+// matches the crashes caused by the same spec, whatever the phase.
+func reportCrash(context *cli.Context, digest string, err error) {
+	var perr *sysbox.PanicError
+	if !errors.As(err, &perr) {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"command":  context.Command.Name,
+		"id":       context.Args().First(),
+		"phase":    perr.Phase,
+		"panic":    fmt.Sprintf("%v", perr.Value),
+		"specHash": digest,
+		"stack":    string(perr.Stack),
+	}).Error("crash record")
+}
+
+// specHash returns the SHA-256 digest of the given spec's JSON encoding, or an
+// empty string if there's no spec.
+func specHash(spec *specs.Spec) string {
+	if spec == nil {
+		return ""
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
-	"fmt"
 	"os"
+	"path/filepath"
 
+	"github.com/opencontainers/runc/libsysbox"
 	"github.com/opencontainers/runc/libsysbox/sysbox"
-	"github.com/opencontainers/runc/libsysbox/syscont"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/urfave/cli"
 )
@@ -26,7 +26,7 @@ The specification file includes an args parameter. The args parameter is used
 to specify command(s) that get run when the container is started. To change the
 command(s) that get executed on start, edit the args parameter of the spec. See
 "runc spec --help" for more explanation.`,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.StringFlag{
 			Name:  "bundle, b",
 			Value: "",
@@ -54,16 +54,67 @@ command(s) that get executed on start, edit the args parameter of the spec. See
 			Name:  "preserve-fds",
 			Usage: "Pass N additional file descriptors to the container (stdio + $LISTEN_FDS + N in total)",
 		},
-	},
-	Action: func(context *cli.Context) error {
+		cli.StringFlag{
+			Name:  "spec-patch",
+			Value: "",
+			Usage: "path to a JSON patch (RFC 6902) file to apply to the container spec before it's converted to a system container spec",
+		},
+		cli.BoolFlag{
+			Name:  "rootfs-clone",
+			Usage: "run the container on an overlayfs clone of the bundle's rootfs, so that many containers can be launched from the same bundle",
+		},
+		cli.BoolFlag{
+			Name:  "notify-proxy",
+			Usage: "give the container's payload a notify socket (NOTIFY_SOCKET), whose READY=1 marks the container as ready in its state",
+		},
+		cli.DurationFlag{
+			Name:  "bootstrap-timeout",
+			Usage: "bound the bootstrap of the container's init process (e.g., 30s); on expiry, it's killed and the create fails with diagnostics",
+		},
+		cli.BoolFlag{
+			Name:  "template",
+			Usage: "create a warm-start template, whose process is executed once it's activated (see the activate command) rather than started",
+		},
+		cli.StringFlag{
+			Name:  "uid-shift",
+			Value: "",
+			Usage: "uid shifting backends the container may use, in order of preference: a comma separated list of shiftfs, idmapped-mount and chown, or none",
+		},
+		cli.StringFlag{
+			Name:  "share-ipc",
+			Value: "",
+			Usage: "id of a peer system container whose ipc namespace (and user namespace) the container joins",
+		},
+		cli.StringFlag{
+			Name:  "share-uts",
+			Value: "",
+			Usage: "id of a peer system container whose uts namespace (and user namespace) the container joins",
+		},
+		cli.StringFlag{
+			Name:  "share-cgroup",
+			Value: "",
+			Usage: "id of a peer system container whose cgroup namespace (and user namespace) the container joins",
+		},
+	}, stdioFlags...),
+	Action: func(context *cli.Context) (err error) {
 		var (
-			err               error
-			spec              *specs.Spec
-			uidShiftSupported bool
-			uidShiftRootfs    bool
-			status            int
+			spec    *specs.Spec
+			digest  string
+			sysCont *libsysbox.SysContainer
+			status  int
 		)
 
+		// a panic is turned into an error, reported along with a crash record;
+		// the deferred cleanups below recover panics themselves, so that they
+		// undo the phase's work as on any other error
+		phase := phaseSetup
+		defer func() {
+			if r := recover(); r != nil {
+				err = sysbox.NewPanicError(phase, r)
+			}
+			reportCrash(context, digest, err)
+		}()
+
 		if err = checkArgs(context, 1, exactArgs); err != nil {
 			return err
 		}
@@ -71,56 +122,60 @@ command(s) that get executed on start, edit the args parameter of the spec. See
 			return err
 		}
 
-		spec, err = setupSpec(context)
-		if err != nil {
-			return err
+		// resolve the patch file path before setupSpec() changes to the bundle dir
+		patchFile := context.String("spec-patch")
+		if patchFile != "" {
+			if patchFile, err = filepath.Abs(patchFile); err != nil {
+				return err
+			}
 		}
 
-		if err = sysbox.CheckHostConfig(context, spec); err != nil {
+		spec, err = setupSpec(context)
+		if err != nil {
 			return err
 		}
 
-		id := context.Args().First()
-		sysMgr := sysbox.NewMgr(id, !context.GlobalBool("no-sysbox-mgr"))
-		sysFs := sysbox.NewFs(id, !context.GlobalBool("no-sysbox-fs"))
-
-		// register with sysMgr
-		if sysMgr.Enabled() {
-			if err = sysMgr.Register(spec); err != nil {
+		if patchFile != "" {
+			if spec, err = patchSpec(spec, patchFile); err != nil {
 				return err
 			}
-			defer func() {
-				if err != nil {
-					sysMgr.Unregister()
-				}
-			}()
 		}
+		digest = specHash(spec)
 
-		// Get sysbox-fs related configs
-		if sysFs.Enabled() {
-			if err = sysFs.GetConfig(); err != nil {
-				return err
-			}
+		if err = setupContainerLogging(context, context.Args().First(), spec.Annotations); err != nil {
+			return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+		}
+
+		if err = setSharedNamespaces(context, spec); err != nil {
+			return err
 		}
+		setRootfsClone(context, spec)
+		setNotifyProxy(context, spec)
+		setBootstrapTimeout(context, spec)
+		setTemplate(context, spec)
+		setUidShiftBackends(context, spec)
 
-		uidShiftSupported, uidShiftRootfs, err = syscont.ConvertSpec(context, sysMgr, sysFs, spec)
+		sysOpts, err := sysboxCreateOpts(context)
 		if err != nil {
-			return fmt.Errorf("error in the container spec: %v", err)
+			return err
 		}
 
-		// pre-register with sysFs
-		if sysFs.Enabled() {
-			if err = sysFs.PreRegister(spec.Linux.Namespaces); err != nil {
-				return err
-			}
-			defer func() {
-				if err != nil {
-					sysFs.Unregister()
-				}
-			}()
+		phase = phasePrepare
+		sysCont, err = libsysbox.PrepareSpec(spec, sysOpts)
+		if err != nil {
+			return err
 		}
+		phase = phaseStart
+		defer func() {
+			if r := recover(); r != nil {
+				err = sysbox.NewPanicError(phase, r)
+			}
+			if err != nil {
+				sysCont.Cleanup()
+			}
+		}()
 
-		status, err = startContainer(context, spec, CT_ACT_CREATE, nil, uidShiftSupported, uidShiftRootfs, sysMgr, sysFs)
+		status, err = startContainer(context, spec, CT_ACT_CREATE, nil, sysCont)
 		if err != nil {
 			return err
 		}
@@ -45,6 +45,10 @@ status of "ubuntu01" as "stopped" the following will delete resources held for
 			Name:  "force, f",
 			Usage: "Forcibly deletes the container if it is still running (uses SIGKILL)",
 		},
+		cli.BoolFlag{
+			Name:  "sync",
+			Usage: "wait for sysbox-mgr to release the container's resources (e.g., copy back the dirs backing its /var/lib/docker), rather than letting it do it in the background (if its client supports that; the default one always waits)",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 1, exactArgs); err != nil {
@@ -53,7 +57,11 @@ status of "ubuntu01" as "stopped" the following will delete resources held for
 
 		id := context.Args().First()
 		force := context.Bool("force")
-		container, err := getContainer(context)
+		var opts []func(*libcontainer.LinuxFactory) error
+		if context.Bool("sync") {
+			opts = append(opts, libcontainer.SysMgrSyncRelease)
+		}
+		container, err := getContainer(context, opts...)
 		if err != nil {
 			if lerr, ok := err.(libcontainer.Error); ok && lerr.Code() == libcontainer.ContainerNotExists {
 				// if there was an aborted start or something of the sort then the container's directory could exist but
@@ -3,10 +3,15 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -17,6 +22,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
 )
 
 var eventsCommand = cli.Command{
@@ -24,18 +30,73 @@ var eventsCommand = cli.Command{
 	Usage: "display container events such as OOM notifications, cpu, memory, and IO usage statistics",
 	ArgsUsage: `<container-id>
 
-Where "<container-id>" is the name for the instance of the container.`,
+Where "<container-id>" is the name for the instance of the container. With
+--watch, any number of container IDs may be given (none means all
+containers).`,
 	Description: `The events command displays information about the container. By default the
-information is displayed once every 5 seconds.`,
+information is displayed once every 5 seconds.
+
+The events output may be selected with --filter expressions (all of which must
+match):
+
+   type=<type>[,<type>...]
+      events of the given types (stats, oom, oom-kill, and with --watch, start
+      and exit).
+
+   cgroup=<glob>[,<glob>...]
+      stats of the container's inner cgroups (i.e., those created within it,
+      e.g., by its docker engine) whose path, relative to the container's
+      cgroup root, matches any of the globs (e.g., "/docker/*"); the stats of
+      the container as a whole are only output if a glob matches "/". Also
+      selects the oom-kill events of those cgroups.
+
+   <metric><op><value>
+      stats whose metric (memory, swap or pids) compares as given (>, >=, <
+      or <=) with the value: an amount (e.g., 512M) or a percentage of the
+      metric's limit (e.g., 90%). Other events are not affected.
+
+On cgroup v2, the stats include the pressure stall information (PSI) of the
+container's cgroup and of its child cgroup (its cgroup root, as seen inside it),
+or of the inner cgroup they're about.
+
+OOM kills are reported by oom-kill events, one per cgroup in which processes
+were killed (checked every interval): the container as a whole, or its inner
+cgroup the kills occurred in (as given by the event's cgroup), so that those of
+a nested workload are visible to the orchestrator.
+
+With --watch, the command watches the given containers (or all containers)
+until interrupted, including those created meanwhile (reported with a start
+event) and until they stop (reported with an exit event). With --listen, the
+events are served on a unix socket (one stream per client, from the time it
+connects) rather than printed, for alerting agents to consume.
+
+EXAMPLE:
+To get the stats of the containers whose memory usage is above 90% of their
+limit, checking every 10 seconds:
+
+       # sysbox-runc events --watch --interval 10s --filter 'memory>90%'`,
 	Flags: []cli.Flag{
 		cli.DurationFlag{Name: "interval", Value: 5 * time.Second, Usage: "set the stats collection interval"},
 		cli.BoolFlag{Name: "stats", Usage: "display the container's stats then exit"},
+		outputFlag(outputJSON, outputYAML),
+		cli.StringSliceFlag{Name: "filter", Usage: "display only the events matching the given expression (may be repeated)"},
+		cli.BoolFlag{Name: "watch", Usage: "watch the given containers (or all) until interrupted, including those created meanwhile"},
+		cli.StringFlag{Name: "listen", Usage: "serve the events on the given unix socket rather than printing them"},
 	},
 	Action: func(context *cli.Context) error {
-		if err := checkArgs(context, 1, exactArgs); err != nil {
+		watch := context.Bool("watch")
+		if watch {
+			if context.Bool("stats") {
+				return errors.New("--stats and --watch are mutually exclusive")
+			}
+		} else if err := checkArgs(context, 1, exactArgs); err != nil {
 			return err
 		}
-		container, err := getContainer(context)
+		format, err := outputFormat(context, outputJSON, outputYAML)
+		if err != nil {
+			return err
+		}
+		filter, err := parseEventFilters(context.StringSlice("filter"))
 		if err != nil {
 			return err
 		}
@@ -43,6 +104,28 @@ information is displayed once every 5 seconds.`,
 		if duration <= 0 {
 			return errors.New("duration interval must be greater than 0")
 		}
+
+		out, err := newEventOutput(format, filter, context.String("listen"))
+		if err != nil {
+			return err
+		}
+		defer out.close()
+
+		// when long-lived, stop cleanly (e.g., removing the socket)
+		var stop chan os.Signal
+		if watch || context.IsSet("listen") {
+			stop = make(chan os.Signal, 1)
+			signal.Notify(stop, unix.SIGINT, unix.SIGTERM)
+		}
+
+		if watch {
+			return watchEvents(context, out, duration, stop)
+		}
+
+		container, err := getContainer(context)
+		if err != nil {
+			return err
+		}
 		status, err := container.Status()
 		if err != nil {
 			return err
@@ -50,67 +133,312 @@ information is displayed once every 5 seconds.`,
 		if status == libcontainer.Stopped {
 			return fmt.Errorf("container with id %s is not running", container.ID())
 		}
-		var (
-			stats  = make(chan *libcontainer.Stats, 1)
-			events = make(chan *types.Event, 1024)
-			group  = &sync.WaitGroup{}
-		)
-		group.Add(1)
-		go func() {
-			defer group.Done()
-			enc := json.NewEncoder(os.Stdout)
-			for e := range events {
-				if err := enc.Encode(e); err != nil {
-					logrus.Error(err)
-				}
-			}
-		}()
 		if context.Bool("stats") {
 			s, err := container.Stats()
 			if err != nil {
 				return err
 			}
-			events <- &types.Event{Type: "stats", ID: container.ID(), Data: convertLibcontainerStats(s)}
-			close(events)
-			group.Wait()
+			out.emit(&types.Event{Type: "stats", ID: container.ID(), Data: convertLibcontainerStats(s)})
+			emitInnerCgroupStats(container, out)
 			return nil
 		}
-		go func() {
-			for range time.Tick(context.Duration("interval")) {
-				s, err := container.Stats()
-				if err != nil {
-					logrus.Error(err)
-					continue
+		return containerEvents(container, out, duration, false, stop)
+	},
+}
+
+// sysbox-runc: containerEvents outputs the events of the given container
+// until its cgroups are removed (or, if untilStopped, until it stops), or
+// until stopped by a signal.
+func containerEvents(container libcontainer.Container, out *eventOutput, interval time.Duration, untilStopped bool, stop <-chan os.Signal) error {
+	stats := make(chan *libcontainer.Stats, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var kills map[string]uint64
+		if out.filter.matchType("oom-kill") {
+			kills = emitOOMKills(container, out, nil)
+		}
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if untilStopped {
+				if status, err := container.Status(); err != nil || status == libcontainer.Stopped {
+					close(stats)
+					return
 				}
-				stats <- s
 			}
-		}()
-		n, err := container.NotifyOOM()
+			if out.filter.matchType("oom-kill") {
+				kills = emitOOMKills(container, out, kills)
+			}
+			if !out.filter.matchType("stats") {
+				continue
+			}
+			s, err := container.Stats()
+			if err != nil {
+				logrus.Error(err)
+				continue
+			}
+			select {
+			case stats <- s:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	n, err := container.NotifyOOM()
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case _, ok := <-n:
+			if !ok {
+				// the channel was closed because the container stopped and
+				// the cgroups no longer exist.
+				return nil
+			}
+			// this means an oom event was received
+			out.emit(&types.Event{Type: "oom", ID: container.ID()})
+		case s, ok := <-stats:
+			if !ok {
+				return nil
+			}
+			out.emit(&types.Event{Type: "stats", ID: container.ID(), Data: convertLibcontainerStats(s)})
+			emitInnerCgroupStats(container, out)
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// sysbox-runc: emitInnerCgroupStats outputs the stats of the container's inner
+// cgroups selected by the output's filter (if any).
+func emitInnerCgroupStats(container libcontainer.Container, out *eventOutput) {
+	if !out.filter.innerCgroups() {
+		return
+	}
+	cgroups, err := container.InnerCgroups()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	for _, cg := range cgroups {
+		if !out.filter.matchCgroup(cg) {
+			continue
+		}
+		s, err := container.InnerCgroupStats(cg)
 		if err != nil {
+			// the inner cgroup may be gone meanwhile
+			logrus.Debugf("getting stats of inner cgroup %s of container %s: %v", cg, container.ID(), err)
+			continue
+		}
+		out.emit(&types.Event{Type: "stats", ID: container.ID(), Cgroup: cg, Data: convertLibcontainerStats(s)})
+	}
+}
+
+// sysbox-runc: emitOOMKills outputs an "oom-kill" event for each cgroup of the
+// container (including its inner cgroups) whose oom kill count grew since the
+// given counts, and returns the current ones. Without previous counts (nil),
+// the current ones are only recorded.
+func emitOOMKills(container libcontainer.Container, out *eventOutput, prev map[string]uint64) map[string]uint64 {
+	kills, err := container.OOMKills()
+	if err != nil {
+		logrus.Debugf("getting oom kills of container %s: %v", container.ID(), err)
+		return prev
+	}
+	if prev == nil {
+		return kills
+	}
+
+	cgroups := make([]string, 0, len(kills))
+	for cg := range kills {
+		cgroups = append(cgroups, cg)
+	}
+	sort.Strings(cgroups)
+
+	for _, cg := range cgroups {
+		// a cgroup new since the previous counts has all its kills reported
+		n, p := kills[cg], prev[cg]
+		if n <= p {
+			continue
+		}
+		e := &types.Event{Type: "oom-kill", ID: container.ID(), OOMKill: &types.OOMKill{Count: n - p, Total: n}}
+		if cg != "/" {
+			e.Cgroup = cg
+		}
+		out.emit(e)
+	}
+	return kills
+}
+
+// sysbox-runc: watchEvents outputs the events of the containers given in the
+// command line (or all containers) until stopped by a signal, picking up the
+// containers as they're created, every interval.
+func watchEvents(context *cli.Context, out *eventOutput, interval time.Duration, stop <-chan os.Signal) error {
+	factory, err := loadFactory(context)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(context.GlobalString("root"))
+	if err != nil {
+		return err
+	}
+
+	ids := map[string]bool{}
+	for _, id := range context.Args() {
+		ids[id] = true
+	}
+
+	watched := map[string]bool{}
+	exited := make(chan string)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for first := true; ; first = false {
+		entries, err := ioutil.ReadDir(root)
+		if err != nil && !os.IsNotExist(err) {
 			return err
 		}
+		for _, e := range entries {
+			id := e.Name()
+			if !e.IsDir() || watched[id] || (len(ids) > 0 && !ids[id]) {
+				continue
+			}
+			container, err := factory.Load(id)
+			if err != nil {
+				continue
+			}
+			if status, err := container.Status(); err != nil || status == libcontainer.Stopped {
+				continue
+			}
+
+			watched[id] = true
+			if !first {
+				out.emit(&types.Event{Type: "start", ID: id})
+			}
+			go func() {
+				if err := containerEvents(container, out, interval, true, nil); err != nil {
+					logrus.Errorf("watching container %s: %v", container.ID(), err)
+				}
+				exited <- container.ID()
+			}()
+		}
+
+	wait:
 		for {
 			select {
-			case _, ok := <-n:
-				if ok {
-					// this means an oom event was received, if it is !ok then
-					// the channel was closed because the container stopped and
-					// the cgroups no longer exist.
-					events <- &types.Event{Type: "oom", ID: container.ID()}
-				} else {
-					n = nil
-				}
-			case s := <-stats:
-				events <- &types.Event{Type: "stats", ID: container.ID(), Data: convertLibcontainerStats(s)}
+			case id := <-exited:
+				delete(watched, id)
+				out.emit(&types.Event{Type: "exit", ID: id})
+			case <-ticker.C:
+				break wait
+			case <-stop:
+				return nil
 			}
-			if n == nil {
-				close(events)
-				break
+		}
+	}
+}
+
+// sysbox-runc: eventOutputTimeout is how long the events output waits for a
+// client of its socket to take an event; slower clients are dropped.
+const eventOutputTimeout = 5 * time.Second
+
+// sysbox-runc: eventOutput outputs the events that pass its filter, to stdout
+// or to the clients of its unix socket (see the --listen option).
+type eventOutput struct {
+	mu      sync.Mutex
+	format  string
+	filter  *eventFilter
+	l       net.Listener
+	clients map[net.Conn]bool
+}
+
+func newEventOutput(format string, filter *eventFilter, socket string) (*eventOutput, error) {
+	out := &eventOutput{format: format, filter: filter}
+	if socket == "" {
+		return out, nil
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	out.l = l
+	out.clients = make(map[net.Conn]bool)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				// closed
+				return
 			}
+			out.mu.Lock()
+			out.clients[conn] = true
+			out.mu.Unlock()
 		}
-		group.Wait()
-		return nil
-	},
+	}()
+
+	return out, nil
+}
+
+// emit outputs the given event, if it passes the filter.
+func (o *eventOutput) emit(e *types.Event) {
+	if !o.filter.match(e) {
+		return
+	}
+
+	var buf bytes.Buffer
+	// one YAML document per event
+	if o.format == outputYAML {
+		fmt.Fprintln(&buf, "---")
+	}
+	if err := writeOutput(&buf, o.format, e); err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.l == nil {
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			logrus.Error(err)
+		}
+		return
+	}
+
+	for conn := range o.clients {
+		conn.SetWriteDeadline(time.Now().Add(eventOutputTimeout))
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			logrus.Debugf("dropping events client: %v", err)
+			conn.Close()
+			delete(o.clients, conn)
+		}
+	}
+}
+
+// close closes the output's socket (removing it) and its clients.
+func (o *eventOutput) close() {
+	if o.l == nil {
+		return
+	}
+	o.l.Close()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for conn := range o.clients {
+		conn.Close()
+		delete(o.clients, conn)
+	}
 }
 
 func convertLibcontainerStats(ls *libcontainer.Stats) *types.Stats {
@@ -175,9 +503,30 @@ func convertLibcontainerStats(ls *libcontainer.Stats) *types.Stats {
 	}
 
 	s.NetworkInterfaces = ls.Interfaces
+
+	s.Pressure = convertPressure(cg.Pressure)
+	s.ChildPressure = convertPressure(ls.ChildPressure)
 	return &s
 }
 
+func convertPressure(p *cgroups.PressureStats) *types.Pressure {
+	if p == nil {
+		return nil
+	}
+	return &types.Pressure{
+		CPU:    convertPSI(p.CPU),
+		Memory: convertPSI(p.Memory),
+		IO:     convertPSI(p.IO),
+	}
+}
+
+func convertPSI(p *cgroups.PSIStats) *types.PSI {
+	if p == nil {
+		return nil
+	}
+	return &types.PSI{Some: types.PSIData(p.Some), Full: types.PSIData(p.Full)}
+}
+
 func convertHugtlb(c cgroups.HugetlbStats) types.Hugetlb {
 	return types.Hugetlb{
 		Usage:   c.Usage,
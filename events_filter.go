@@ -0,0 +1,240 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/opencontainers/runc/types"
+)
+
+// sysbox-runc: eventFilter selects the events output by the events command,
+// per its --filter expressions (all of which must match):
+//
+// "type=<type>[,<type>...]": events of the given types.
+//
+// "cgroup=<glob>[,<glob>...]": stats of the container's inner cgroups (i.e.,
+// those created within it, e.g., by its docker engine) whose path (relative to
+// the container's cgroup root, as seen inside it) matches any of the globs;
+// the stats of the container as a whole are only output if a glob matches "/".
+// Also selects the "oom-kill" events of those cgroups (which, without it, are
+// all output).
+//
+// "<metric><op><value>": stats events whose metric compares as given with the
+// value, where the metric is one of "memory", "swap" or "pids", the op one of
+// ">", ">=", "<" or "<=", and the value an amount (e.g., "512M" or "100") or a
+// percentage of the metric's limit (e.g., "90%"; never matches when
+// unlimited). Other events are not affected.
+type eventFilter struct {
+	types      map[string]bool
+	cgroups    []string
+	thresholds []eventThreshold
+}
+
+// eventThreshold is a "<metric><op><value>" filter expression.
+type eventThreshold struct {
+	expr    string
+	metric  string
+	op      string
+	value   uint64
+	percent bool
+}
+
+// eventMetrics returns the usage and limit of each metric of the given stats.
+var eventMetrics = map[string]func(s *types.Stats) (uint64, uint64){
+	"memory": func(s *types.Stats) (uint64, uint64) { return s.Memory.Usage.Usage, s.Memory.Usage.Limit },
+	"swap":   func(s *types.Stats) (uint64, uint64) { return s.Memory.Swap.Usage, s.Memory.Swap.Limit },
+	"pids":   func(s *types.Stats) (uint64, uint64) { return s.Pids.Current, s.Pids.Limit },
+}
+
+// eventFilterOps are the ops of the filter expressions, longest first.
+var eventFilterOps = []string{">=", "<=", ">", "<", "="}
+
+// parseEventFilters parses the given filter expressions.
+func parseEventFilters(exprs []string) (*eventFilter, error) {
+	f := &eventFilter{}
+
+	for _, expr := range exprs {
+		key, op, val := splitEventFilter(expr)
+		if op == "" || key == "" || val == "" {
+			return nil, fmt.Errorf("invalid filter %q (must be <key>=<value> or <metric><op><value>)", expr)
+		}
+
+		switch key {
+		case "type", "cgroup":
+			if op != "=" {
+				return nil, fmt.Errorf("invalid filter %q (%s filters must be %s=<value>)", expr, key, key)
+			}
+			for _, v := range strings.Split(val, ",") {
+				v = strings.TrimSpace(v)
+				if v == "" {
+					return nil, fmt.Errorf("invalid filter %q (empty value)", expr)
+				}
+				if key == "type" {
+					if f.types == nil {
+						f.types = make(map[string]bool)
+					}
+					f.types[v] = true
+					continue
+				}
+				glob := filepath.Clean("/" + v)
+				if _, err := filepath.Match(glob, "/"); err != nil {
+					return nil, fmt.Errorf("invalid filter %q: %v", expr, err)
+				}
+				f.cgroups = append(f.cgroups, glob)
+			}
+
+		default:
+			t, err := parseEventThreshold(expr, key, op, val)
+			if err != nil {
+				return nil, err
+			}
+			f.thresholds = append(f.thresholds, t)
+		}
+	}
+
+	return f, nil
+}
+
+// splitEventFilter splits the given filter expression at its op.
+func splitEventFilter(expr string) (key, op, val string) {
+	pos := -1
+	for _, o := range eventFilterOps {
+		if i := strings.Index(expr, o); i >= 0 && (pos < 0 || i < pos) {
+			pos = i
+			op = o
+		}
+	}
+	if pos < 0 {
+		return "", "", ""
+	}
+	return strings.TrimSpace(expr[:pos]), op, strings.TrimSpace(expr[pos+len(op):])
+}
+
+func parseEventThreshold(expr, metric, op, val string) (eventThreshold, error) {
+	t := eventThreshold{expr: expr, metric: metric, op: op}
+
+	if _, ok := eventMetrics[metric]; !ok {
+		return t, fmt.Errorf("invalid filter %q (unknown key %q; must be one of: type, cgroup, memory, swap, pids)", expr, metric)
+	}
+	if op == "=" {
+		return t, fmt.Errorf("invalid filter %q (%s filters must use one of: >, >=, <, <=)", expr, metric)
+	}
+
+	var err error
+	if strings.HasSuffix(val, "%") {
+		t.percent = true
+		t.value, err = strconv.ParseUint(strings.TrimSuffix(val, "%"), 10, 64)
+		if err == nil && t.value > 100 {
+			err = fmt.Errorf("percentage above 100")
+		}
+	} else if metric == "pids" {
+		t.value, err = strconv.ParseUint(val, 10, 64)
+	} else {
+		var v int64
+		v, err = units.RAMInBytes(val)
+		if err == nil && v < 0 {
+			err = fmt.Errorf("negative amount")
+		}
+		t.value = uint64(v)
+	}
+	if err != nil {
+		return t, fmt.Errorf("invalid filter %q: %v", expr, err)
+	}
+
+	return t, nil
+}
+
+// match returns true if the given stats pass the threshold.
+func (t eventThreshold) match(s *types.Stats) bool {
+	usage, limit := eventMetrics[t.metric](s)
+
+	if t.percent {
+		// unlimited
+		if limit == 0 || limit >= math.MaxInt64 {
+			return false
+		}
+		return compareEventValues(float64(usage)*100, t.op, float64(t.value)*float64(limit))
+	}
+	return compareEventValues(float64(usage), t.op, float64(t.value))
+}
+
+func compareEventValues(a float64, op string, b float64) bool {
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return false
+}
+
+// matchType returns true if events of the given type pass the filter.
+func (f *eventFilter) matchType(typ string) bool {
+	return f.types == nil || f.types[typ]
+}
+
+// matchCgroup returns true if the stats of the given cgroup of the container
+// ("/" for the container as a whole) pass the filter.
+func (f *eventFilter) matchCgroup(cgroup string) bool {
+	if len(f.cgroups) == 0 {
+		return cgroup == "/"
+	}
+	for _, glob := range f.cgroups {
+		if ok, _ := filepath.Match(glob, cgroup); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// innerCgroups returns true if the filter selects inner cgroups.
+func (f *eventFilter) innerCgroups() bool {
+	for _, glob := range f.cgroups {
+		if glob != "/" {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns true if the given event passes the filter.
+func (f *eventFilter) match(e *types.Event) bool {
+	if !f.matchType(e.Type) {
+		return false
+	}
+
+	cgroup := e.Cgroup
+	if cgroup == "" {
+		cgroup = "/"
+	}
+	if e.Type == "oom-kill" {
+		return len(f.cgroups) == 0 || f.matchCgroup(cgroup)
+	}
+	if e.Type != "stats" {
+		return true
+	}
+	if !f.matchCgroup(cgroup) {
+		return false
+	}
+
+	s, ok := e.Data.(*types.Stats)
+	if !ok || s == nil {
+		return len(f.thresholds) == 0
+	}
+	for _, t := range f.thresholds {
+		if !t.match(s) {
+			return false
+		}
+	}
+	return true
+}
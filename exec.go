@@ -4,16 +4,22 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
 
+	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/specconv"
+	"github.com/opencontainers/runc/libcontainer/user"
 	"github.com/opencontainers/runc/libcontainer/utils"
 	"github.com/opencontainers/runc/libsysbox/syscont"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
@@ -52,6 +58,14 @@ following will output a list of processes running in the container:
 			Name:  "user, u",
 			Usage: "UID (format: <uid>[:<gid>])",
 		},
+		cli.BoolFlag{
+			Name:  "as-admin",
+			Usage: "run the process as the container's root user, with all capabilities (as the container's init process when run as root)",
+		},
+		cli.StringFlag{
+			Name:  "as-user",
+			Usage: "run the process as the given container user (format: <user>[:<group>], by name or ID), with no capabilities (as a non-root init process)",
+		},
 		cli.Int64SliceFlag{
 			Name:  "additional-gids, g",
 			Usage: "additional gids",
@@ -79,13 +93,17 @@ following will output a list of processes running in the container:
 		},
 		cli.BoolFlag{
 			Name:  "no-new-privs",
-			Usage: "set the no new privileges value for the process",
+			Usage: "set the no new privileges value for the process (overrides the container's no-new-privileges policy)",
 		},
 		cli.StringSliceFlag{
 			Name:  "cap, c",
 			Value: &cli.StringSlice{},
 			Usage: "add a capability to the bounding set for the process",
 		},
+		cli.StringSliceFlag{
+			Name:  "rlimit",
+			Usage: "set an rlimit for the process, overriding the container's one of the same type (format: <type>=<soft>[:<hard>], e.g., nofile=65536)",
+		},
 		cli.BoolFlag{
 			Name:   "no-subreaper",
 			Usage:  "disable the use of the subreaper used to reap reparented processes",
@@ -95,6 +113,10 @@ following will output a list of processes running in the container:
 			Name:  "preserve-fds",
 			Usage: "Pass N additional file descriptors to the container (stdio + $LISTEN_FDS + N in total)",
 		},
+		cli.StringSliceFlag{
+			Name:  "cgroup",
+			Usage: "run the process in a cgroup of the container (format: <path> or <controller>[,<controller>...]:<path>; the path is relative to the container's cgroup root); defaults to the cgroups of the container's init process",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 1, minArgs); err != nil {
@@ -133,19 +155,38 @@ func execProcess(context *cli.Context) (int, error) {
 	if err != nil {
 		return -1, err
 	}
-	bundle := utils.SearchLabels(state.Config.Labels, "bundle")
-	p, err := getProcess(context, bundle)
+	bundle, annotations := utils.Annotations(state.Config.Labels)
+	noNewPrivsPolicy, err := syscont.GetNoNewPrivsPolicy(annotations)
 	if err != nil {
 		return -1, err
 	}
-
-	logLevel := "info"
-	if context.GlobalBool("debug") {
-		logLevel = "debug"
+	caps, err := syscont.GetCapsProfile(annotations)
+	if err != nil {
+		return -1, err
+	}
+	p, err := getProcess(context, bundle, &state.Config, noNewPrivsPolicy, caps)
+	if err != nil {
+		return -1, err
 	}
+	subCgroupPaths, err := parseCgroupPaths(context.StringSlice("cgroup"))
+	if err != nil {
+		return -1, err
+	}
+	// sysbox-runc: exec'd processes get the container's scheduling attributes,
+	// unless their process.json overrides them
+	var procSched *specconv.ProcessSched
+	if path != "" {
+		if procSched, err = loadProcessSched(path, false); err != nil {
+			return -1, err
+		}
+	}
+
+	// sysbox-runc: the container's log level may override the global one (see
+	// setupContainerLogging())
+	logLevel := logrus.GetLevel().String()
 
 	r := &runner{
-		enableSubreaper: false,
+		enableSubreaper: context.GlobalBool("subreaper") && !context.Bool("no-subreaper"),
 		shouldDestroy:   false,
 		container:       container,
 		consoleSocket:   context.String("console-socket"),
@@ -155,11 +196,43 @@ func execProcess(context *cli.Context) (int, error) {
 		init:            false,
 		preserveFDs:     context.Int("preserve-fds"),
 		logLevel:        logLevel,
+		subCgroupPaths:  subCgroupPaths,
+		procSched:       procSched,
 	}
 	return r.run(p)
 }
 
-func getProcess(context *cli.Context, bundle string) (*specs.Process, error) {
+// parseCgroupPaths parses the values of the --cgroup flag into a map of
+// cgroup paths per controller (see libcontainer.Process.SubCgroupPaths).
+func parseCgroupPaths(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	paths := make(map[string]string)
+	for _, arg := range args {
+		ctrls, path := "", arg
+		if i := strings.LastIndex(arg, ":"); i >= 0 {
+			ctrls, path = arg[:i], arg[i+1:]
+			if ctrls == "" {
+				return nil, fmt.Errorf("invalid --cgroup value %q: empty controller list", arg)
+			}
+		}
+		if path == "" {
+			return nil, fmt.Errorf("invalid --cgroup value %q: empty path", arg)
+		}
+		for _, ctrl := range strings.Split(ctrls, ",") {
+			if _, ok := paths[ctrl]; ok {
+				return nil, fmt.Errorf("invalid --cgroup value %q: duplicate controller %q", arg, ctrl)
+			}
+			paths[ctrl] = path
+		}
+	}
+
+	return paths, nil
+}
+
+func getProcess(context *cli.Context, bundle string, config *configs.Config, noNewPrivsPolicy syscont.NoNewPrivsPolicy, caps []string) (*specs.Process, error) {
 	if path := context.String("process"); path != "" {
 		f, err := os.Open(path)
 		if err != nil {
@@ -173,8 +246,11 @@ func getProcess(context *cli.Context, bundle string) (*specs.Process, error) {
 		if err := validateProcessSpec(&p); err != nil {
 			return nil, err
 		}
+		if err := applyExecPreset(context, &p, config); err != nil {
+			return nil, err
+		}
 		// sysbox-runc: convert the process spec for system containers
-		return &p, syscont.ConvertProcessSpec(&p)
+		return &p, syscont.ConvertProcessSpec(&p, noNewPrivsPolicy, caps, false)
 	}
 	// process via cli flags
 	if err := os.Chdir(bundle); err != nil {
@@ -205,6 +281,19 @@ func getProcess(context *cli.Context, bundle string) (*specs.Process, error) {
 			p.Capabilities.Ambient = append(p.Capabilities.Ambient, c)
 		}
 	}
+	for _, val := range context.StringSlice("rlimit") {
+		rlimit, err := parseRlimit(val)
+		if err != nil {
+			return nil, err
+		}
+		rlimits := []specs.POSIXRlimit{}
+		for _, rl := range p.Rlimits {
+			if rl.Type != rlimit.Type {
+				rlimits = append(rlimits, rl)
+			}
+		}
+		p.Rlimits = append(rlimits, rlimit)
+	}
 
 	// append the passed env variables
 	p.Env = append(p.Env, context.StringSlice("env")...)
@@ -214,9 +303,6 @@ func getProcess(context *cli.Context, bundle string) (*specs.Process, error) {
 	if context.IsSet("tty") {
 		p.Terminal = context.Bool("tty")
 	}
-	if context.IsSet("no-new-privs") {
-		p.NoNewPrivileges = context.Bool("no-new-privs")
-	}
 	// override the user, if passed
 	if context.String("user") != "" {
 		u := strings.SplitN(context.String("user"), ":", 2)
@@ -243,10 +329,80 @@ func getProcess(context *cli.Context, bundle string) (*specs.Process, error) {
 	if err := validateProcessSpec(p); err != nil {
 		return nil, err
 	}
+	if err := applyExecPreset(context, p, config); err != nil {
+		return nil, err
+	}
 
 	// sysbox-runc: convert the process spec for system containers
-	if err := syscont.ConvertProcessSpec(p); err != nil {
+	if err := syscont.ConvertProcessSpec(p, noNewPrivsPolicy, caps, false); err != nil {
 		return nil, err
 	}
+
+	// sysbox-runc: an explicit --no-new-privs overrides the container's
+	// noNewPrivileges policy
+	if context.IsSet("no-new-privs") {
+		if nnp := context.Bool("no-new-privs"); nnp != p.NoNewPrivileges {
+			logrus.Debugf("overriding the no-new-privileges policy of the sys container (%s) with --no-new-privs=%v",
+				noNewPrivsPolicy, nnp)
+			p.NoNewPrivileges = nnp
+		}
+	}
 	return p, nil
 }
+
+// applyExecPreset applies the exec preset selected via --as-admin or
+// --as-user, if any, to the given process. The preset sets the process' user,
+// resolved per the container's /etc/passwd and /etc/group (including its
+// supplementary groups), and checks that it's mapped by the container's user
+// namespace (otherwise it would show up as "nobody" within the container).
+// The process' capabilities are then computed per its user, as for the
+// container's init process (see syscont.ConvertProcessSpec()).
+func applyExecPreset(context *cli.Context, p *specs.Process, config *configs.Config) error {
+	userSpec := context.String("as-user")
+	if context.Bool("as-admin") {
+		if userSpec != "" {
+			return errors.New("--as-admin and --as-user are mutually exclusive")
+		}
+		userSpec = "0"
+	}
+	if userSpec == "" {
+		return nil
+	}
+	for _, flag := range []string{"user", "additional-gids", "cap"} {
+		if context.IsSet(flag) {
+			return fmt.Errorf("--%s can't be combined with --as-admin or --as-user", flag)
+		}
+	}
+
+	passwdPath, err := securejoin.SecureJoin(config.Rootfs, "/etc/passwd")
+	if err != nil {
+		return err
+	}
+	groupPath, err := securejoin.SecureJoin(config.Rootfs, "/etc/group")
+	if err != nil {
+		return err
+	}
+	execUser, err := user.GetExecUserPath(userSpec, &user.ExecUser{}, passwdPath, groupPath)
+	if err != nil {
+		return fmt.Errorf("failed to look up user %q in the container: %v", userSpec, err)
+	}
+
+	if _, err := config.HostUID(execUser.Uid); err != nil {
+		return fmt.Errorf("uid %d is not mapped by the container's user namespace: %v", execUser.Uid, err)
+	}
+	gids := append([]int{execUser.Gid}, execUser.Sgids...)
+	for _, gid := range gids {
+		if _, err := config.HostGID(gid); err != nil {
+			return fmt.Errorf("gid %d is not mapped by the container's user namespace: %v", gid, err)
+		}
+	}
+
+	p.User = specs.User{
+		UID: uint32(execUser.Uid),
+		GID: uint32(execUser.Gid),
+	}
+	for _, gid := range execUser.Sgids {
+		p.User.AdditionalGids = append(p.User.AdditionalGids, uint32(gid))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var refreshHostConfigsCommand = cli.Command{
+	Name:  "refresh-host-configs",
+	Usage: "update the host configs mounted in system containers with the host's current ones",
+	ArgsUsage: `[<container-id>...]
+
+Where "<container-id>" is the name for the instance of the container.
+
+EXAMPLE:
+To refresh all containers whenever the host's Kerberos config changes, run
+this command from a systemd path unit watching /etc/krb5.conf (or from a timer
+unit or cron job):
+
+       # sysbox-runc refresh-host-configs --all`,
+	Description: `The refresh-host-configs command updates the copies of the host configs mounted
+in the given system containers (see the "io.nestybox.sysbox.host-configs"
+annotation) with the host's current ones, and prints their IDs. The containers
+see the changes right away, as the copies are updated in place.
+
+Host configs that didn't exist when the container started aren't mounted in it
+(so they're not refreshed), and those removed from the host since are kept in
+the container as last seen.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "all, a",
+			Usage: "refresh all running, created and paused containers",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		var containers []libcontainer.Container
+		var err error
+
+		if context.Bool("all") {
+			if err := checkArgs(context, 0, exactArgs); err != nil {
+				return err
+			}
+			containers, err = liveContainers(context)
+		} else {
+			if err := checkArgs(context, 1, minArgs); err != nil {
+				return err
+			}
+			containers, err = loadContainers(context, context.Args())
+		}
+		if err != nil {
+			return err
+		}
+
+		var refreshErr error
+		for _, c := range containers {
+			if err := c.RefreshHostConfigs(); err != nil {
+				logrus.Errorf("failed to refresh the host configs of container %s: %v", c.ID(), err)
+				refreshErr = errors.New("failed to refresh some containers")
+				continue
+			}
+			fmt.Println(c.ID())
+		}
+		return refreshErr
+	},
+}
@@ -24,9 +24,11 @@ func init() {
 		}
 
 		err = logs.ConfigureLogging(logs.Config{
-			LogPipeFd: os.Getenv("_LIBCONTAINER_LOGPIPE"),
-			LogFormat: "json",
-			LogLevel:  logLevel,
+			LogPipeFd:   os.Getenv("_LIBCONTAINER_LOGPIPE"),
+			LogFormat:   "json",
+			LogLevel:    logLevel,
+			Phase:       "init",
+			ContainerID: os.Getenv("_LIBCONTAINER_CONTAINERID"),
 		})
 		if err != nil {
 			panic(fmt.Sprintf("libcontainer: failed to configure logging: %v", err))
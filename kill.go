@@ -3,14 +3,19 @@
 package main
 
 import (
-	"fmt"
-	"strconv"
-	"strings"
+	"errors"
+	"time"
 
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"golang.org/x/sys/unix"
 )
 
+// sigRtMin3 is SIGRTMIN+3, which makes systemd halt the system.
+const sigRtMin3 = unix.Signal(syscont.SigRtMin + 3)
+
 var killCommand = cli.Command{
 	Name:  "kill",
 	Usage: "kill sends the specified signal (default: SIGTERM) to the container's init process",
@@ -23,12 +28,30 @@ EXAMPLE:
 For example, if the container id is "ubuntu01" the following will send a "KILL"
 signal to the init process of the "ubuntu01" container:
 
-       # sysbox-runc kill ubuntu01 KILL`,
+       # sysbox-runc kill ubuntu01 KILL
+
+To stop a container running systemd, halting it gracefully (and killing it if
+it doesn't stop within 30 seconds):
+
+       # sysbox-runc kill --graceful --timeout 30s ubuntu01`,
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "all, a",
 			Usage: "send the specified signal to all processes inside the container",
 		},
+		cli.BoolFlag{
+			Name:  "graceful, g",
+			Usage: "stop the container: send the specified signal (default: SIGRTMIN+3, i.e., systemd's halt) to the init process and kill all processes if it doesn't exit within the timeout",
+		},
+		cli.DurationFlag{
+			Name:  "timeout, t",
+			Value: 10 * time.Second,
+			Usage: "with --graceful, time to wait for the init process to exit",
+		},
+		cli.StringFlag{
+			Name:  "cgroup",
+			Usage: "send the specified signal to all processes in the given cgroup of the container (relative to the container's cgroup root, e.g., /system.slice/foo.service)",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 1, minArgs); err != nil {
@@ -42,31 +65,68 @@ signal to the init process of the "ubuntu01" container:
 			return err
 		}
 
-		sigstr := context.Args().Get(1)
-		if sigstr == "" {
-			sigstr = "SIGTERM"
+		graceful := context.Bool("graceful")
+		cgroup := context.String("cgroup")
+		if cgroup != "" && (graceful || context.Bool("all")) {
+			return errors.New("--cgroup can't be used with --all or --graceful")
 		}
 
-		signal, err := parseSignal(sigstr)
-		if err != nil {
-			return err
+		signal := unix.SIGTERM
+		if graceful {
+			signal = sigRtMin3
+		}
+		if sigstr := context.Args().Get(1); sigstr != "" {
+			signal, err = syscont.ParseSignal(sigstr)
+			if err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case graceful:
+			return stopContainer(container, signal, context.Duration("timeout"))
+		case cgroup != "":
+			return container.SignalCgroup(signal, cgroup)
 		}
 		return container.Signal(signal, context.Bool("all"))
 	},
 }
 
-func parseSignal(rawSignal string) (unix.Signal, error) {
-	s, err := strconv.Atoi(rawSignal)
-	if err == nil {
-		return unix.Signal(s), nil
+// signalAndWait sends the given signal to the container's init process and
+// waits (up to the given timeout) for it to exit. It returns whether it exited.
+func signalAndWait(container libcontainer.Container, sig unix.Signal, timeout time.Duration) bool {
+	if err := container.Signal(sig, false); err != nil {
+		return false
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if err := container.Signal(unix.Signal(0), false); err != nil {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+// stopContainer stops the container gracefully: it sends the given signal to
+// its init process, and kills all its processes if init doesn't exit within
+// the given timeout.
+func stopContainer(container libcontainer.Container, sig unix.Signal, timeout time.Duration) error {
+	status, err := container.Status()
+	if err != nil {
+		return err
+	}
+	if status == libcontainer.Stopped {
+		return nil
 	}
-	sig := strings.ToUpper(rawSignal)
-	if !strings.HasPrefix(sig, "SIG") {
-		sig = "SIG" + sig
+	if status == libcontainer.Paused {
+		if err := container.Resume(); err != nil {
+			return err
+		}
 	}
-	signal := unix.SignalNum(sig)
-	if signal == 0 {
-		return -1, fmt.Errorf("unknown signal %q", rawSignal)
+	if signalAndWait(container, sig, timeout) {
+		return nil
 	}
-	return signal, nil
+	logrus.Infof("container %s didn't stop within %s; killing it", container.ID(), timeout)
+	return container.Signal(unix.SIGKILL, true)
 }
@@ -0,0 +1,136 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: machineIDFile is the container's machine ID file (see
+// machine-id(5)).
+const machineIDFile = "/etc/machine-id"
+
+var machineIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// sysbox-runc: Identity is the identity stamped on a template container when
+// it's activated (see Container.Activate()).
+type Identity struct {
+	// Hostname of the container; empty to keep the template's.
+	Hostname string
+
+	// MachineID of the container (32 lowercase hex chars, see machine-id(5));
+	// empty for a random one.
+	MachineID string
+}
+
+// sysbox-runc: NewMachineID returns a random machine ID.
+func NewMachineID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// sysbox-runc: Activate stamps the given identity on the container, which
+// must be a template (see configs.Config.Template) held before the exec of
+// its user defined process, and executes that process. The container's user
+// namespace (and thus its subid range) is set up when the template is created,
+// so it's not part of the identity.
+func (c *linuxContainer) Activate(id Identity) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status != Created {
+		return newGenericError(fmt.Errorf("container not created: %s", status), ContainerNotRunning)
+	}
+	if !c.config.Template {
+		return newGenericError(fmt.Errorf("container is not a template"), ConfigInvalid)
+	}
+
+	if id.MachineID == "" {
+		if id.MachineID, err = NewMachineID(); err != nil {
+			return newSystemErrorWithCause(err, "generating machine id")
+		}
+	}
+	if !machineIDRe.MatchString(id.MachineID) {
+		return newGenericError(fmt.Errorf("invalid machine id %q (must be 32 lowercase hex chars)", id.MachineID), ConfigInvalid)
+	}
+	if id.Hostname != "" {
+		if !c.config.Namespaces.Contains(configs.NEWUTS) {
+			return newGenericError(fmt.Errorf("container has no uts namespace to set its hostname in"), ConfigInvalid)
+		}
+		if c.config.Namespaces.PathOf(configs.NEWUTS) != "" {
+			return newGenericError(fmt.Errorf("container shares its uts namespace; can't set its hostname"), ConfigInvalid)
+		}
+	}
+
+	uid, err := c.config.HostRootUID()
+	if err != nil {
+		return err
+	}
+	gid, err := c.config.HostRootGID()
+	if err != nil {
+		return err
+	}
+
+	reqs := []opReq{
+		{
+			Op:        stampIdentity,
+			Hostname:  id.Hostname,
+			MachineID: id.MachineID,
+			Path:      machineIDFile,
+			Uid:       uid,
+			Gid:       gid,
+		},
+	}
+
+	if err := c.handleOp(stampIdentity, c.initProcess.pid(), reqs, syncVersion); err != nil {
+		return newSystemErrorWithCause(err, "stamping container identity")
+	}
+
+	if id.Hostname != "" {
+		c.config.Hostname = id.Hostname
+	}
+	c.config.Template = false
+	if _, err := c.updateState(nil); err != nil {
+		return err
+	}
+
+	return c.exec()
+}
+
+// sysbox-runc: doStampIdentity sets the hostname and writes the machine ID
+// file given by the stampIdentity request; it runs in the container's uts and
+// mount namespaces.
+func doStampIdentity(req opReq) error {
+	if req.Hostname != "" {
+		if err := unix.Sethostname([]byte(req.Hostname)); err != nil {
+			return fmt.Errorf("sethostname: %v", err)
+		}
+	}
+
+	// the template's init hasn't exec'd its process, but the rootfs comes
+	// from an image: don't follow a symlink in place of the file
+	f, err := os.OpenFile(req.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|unix.O_NOFOLLOW, 0444)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(req.MachineID + "\n"); err != nil {
+		return err
+	}
+	return f.Chown(req.Uid, req.Gid)
+}
@@ -0,0 +1,229 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: bootstrapWatchdog bounds the bootstrap of the container's init
+// process (i.e., its nsexec stages and the setup of the container up to the
+// exec of its process): once the timeout expires, it collects diagnostics
+// about the bootstrap and kills the init process' tree, so that the bootstrap
+// fails rather than hangs (e.g., on a mount that never completes). A nil
+// watchdog (i.e., no timeout) does nothing.
+type bootstrapWatchdog struct {
+	timeout time.Duration
+	pid     int       // the init process' first stage
+	pipe    io.Closer // the parent's end of the init pipe
+	timer   *time.Timer
+
+	mu         sync.Mutex
+	childPid   int
+	lastSync   syncType
+	pendingOps []opReq
+	stopped    bool
+	diag       string
+}
+
+// newBootstrapWatchdog starts the watchdog of the bootstrap of the init
+// process with the given pid and init pipe, or returns nil if there's no
+// timeout.
+func newBootstrapWatchdog(timeout time.Duration, pid int, pipe io.Closer) *bootstrapWatchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	w := &bootstrapWatchdog{
+		timeout: timeout,
+		pid:     pid,
+		pipe:    pipe,
+	}
+	w.timer = time.AfterFunc(timeout, w.expire)
+	return w
+}
+
+// setChildPid records the pid of the init process' last stage (i.e., the
+// container's init process).
+func (w *bootstrapWatchdog) setChildPid(pid int) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.childPid = pid
+	w.mu.Unlock()
+}
+
+// syncReceived records the last sync message received from the init process.
+func (w *bootstrapWatchdog) syncReceived(t syncType) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.lastSync = t
+	w.mu.Unlock()
+}
+
+// setPendingOps records the op requests of the init process being handled
+// (nil once handled).
+func (w *bootstrapWatchdog) setPendingOps(reqs []opReq) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.pendingOps = reqs
+	w.mu.Unlock()
+}
+
+// stop stops the watchdog; if it expired, it returns the given error of the
+// bootstrap along with the diagnostics.
+func (w *bootstrapWatchdog) stop(err error) error {
+	if w == nil {
+		return err
+	}
+	w.timer.Stop()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	if w.diag == "" || err == nil {
+		return err
+	}
+	return newSystemErrorWithCause(err, w.diag)
+}
+
+func (w *bootstrapWatchdog) expire() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+
+	pids := bootstrapPids(w.pid, w.childPid)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "bootstrap of the container's init process timed out after %s", w.timeout)
+	if w.lastSync != "" {
+		fmt.Fprintf(&b, "; last sync from init: %s", w.lastSync)
+	} else {
+		b.WriteString("; no sync from init")
+	}
+	if len(w.pendingOps) > 0 {
+		ops := []string{}
+		for _, req := range w.pendingOps {
+			ops = append(ops, describeOpReq(req))
+		}
+		fmt.Fprintf(&b, "; pending op requests: %s", strings.Join(ops, ", "))
+	}
+	for _, pid := range pids {
+		fmt.Fprintf(&b, "; %s", describeBootstrapPid(pid))
+	}
+	w.diag = b.String()
+	logrus.Error(w.diag)
+
+	for _, pid := range pids {
+		unix.Kill(pid, unix.SIGKILL)
+	}
+	// unblocks the parent if it's waiting on the pipe
+	w.pipe.Close()
+}
+
+// bootstrapPids returns the pids of the processes of the init process' tree
+// rooted at the given pids (the init process' first and last stages, as the
+// latter is reparented once its own parent exits).
+func bootstrapPids(roots ...int) []int {
+	pids := []int{}
+	seen := map[int]bool{}
+
+	var walk func(pid int)
+	walk = func(pid int) {
+		if pid <= 0 || seen[pid] {
+			return
+		}
+		seen[pid] = true
+		pids = append(pids, pid)
+
+		data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%d/children", pid, pid))
+		if err != nil {
+			return
+		}
+		for _, f := range strings.Fields(string(data)) {
+			if child, err := strconv.Atoi(f); err == nil {
+				walk(child)
+			}
+		}
+	}
+
+	for _, pid := range roots {
+		walk(pid)
+	}
+	return pids
+}
+
+// describeBootstrapPid describes the given process of the init process' tree:
+// its name, state, wait channel and kernel stack (each when available).
+func describeBootstrapPid(pid int) string {
+	desc := fmt.Sprintf("pid %d", pid)
+
+	if data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+		desc += fmt.Sprintf(" (%s)", strings.TrimSpace(string(data)))
+	}
+	if data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid)); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "State:") {
+				desc += " state " + strings.TrimSpace(strings.TrimPrefix(line, "State:"))
+				break
+			}
+		}
+	} else {
+		return desc + " gone"
+	}
+	if data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/wchan", pid)); err == nil && len(data) > 0 && string(data) != "0" {
+		desc += " wchan " + string(data)
+	}
+	if data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stack", pid)); err == nil {
+		frames := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			// e.g., "[<0>] do_wait+0x1a0/0x240"
+			if i := strings.Index(line, "] "); i >= 0 {
+				line = line[i+2:]
+			}
+			if line != "" {
+				frames = append(frames, line)
+			}
+		}
+		if len(frames) > 0 {
+			desc += " stack [" + strings.Join(frames, " < ") + "]"
+		}
+	}
+
+	return desc
+}
+
+// describeOpReq describes the given op request of the init process.
+func describeOpReq(req opReq) string {
+	switch req.Op {
+	case bind:
+		return fmt.Sprintf("bind %s on %s", req.Mount.Source, req.Mount.Destination)
+	case chown:
+		return fmt.Sprintf("chown %s", req.Path)
+	case umount:
+		return fmt.Sprintf("umount %s", req.Path)
+	case switchDockerDns:
+		return "switchDockerDns"
+	case seccompFd:
+		return "seccompFd"
+	case stampIdentity:
+		return "stampIdentity"
+	default:
+		return fmt.Sprintf("op %d", req.Op)
+	}
+}
@@ -0,0 +1,84 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBootstrapWatchdogNil(t *testing.T) {
+	w := newBootstrapWatchdog(0, os.Getpid(), nil)
+	if w != nil {
+		t.Fatal("expected no watchdog without a timeout")
+	}
+	w.setChildPid(1)
+	w.syncReceived(procReady)
+	w.setPendingOps([]opReq{{Op: chown, Path: "/"}})
+
+	err := errors.New("bootstrap failed")
+	if got := w.stop(err); got != err {
+		t.Fatalf("expected %v, got %v", err, got)
+	}
+}
+
+func TestBootstrapWatchdogStopped(t *testing.T) {
+	r, _, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	w := newBootstrapWatchdog(time.Hour, os.Getpid(), r)
+	if err := w.stop(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a stopped watchdog must not kill anything
+	w.expire()
+	if w.diag != "" {
+		t.Fatalf("expected no diagnostics, got %q", w.diag)
+	}
+}
+
+func TestBootstrapWatchdogExpire(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 100 & wait")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	watchdog := newBootstrapWatchdog(100*time.Millisecond, cmd.Process.Pid, r)
+	watchdog.syncReceived(procHooks)
+	watchdog.setPendingOps([]opReq{{Op: umount, Path: "/mnt"}})
+
+	// the watchdog kills the process' tree and closes the pipe
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("the watchdog didn't kill the process")
+	}
+	if _, err := r.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the pipe to be closed")
+	}
+
+	err = watchdog.stop(errors.New("reading from init pipe"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"timed out after 100ms", "last sync from init: procHooks", "umount /mnt", "(sh)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in error: %v", want, err)
+		}
+	}
+}
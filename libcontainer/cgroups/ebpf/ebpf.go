@@ -1,6 +1,8 @@
 package ebpf
 
 import (
+	"unsafe"
+
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/asm"
 	"github.com/pkg/errors"
@@ -12,7 +14,13 @@ import (
 // Requires the system to be running in cgroup2 unified-mode with kernel >= 4.15 .
 //
 // https://github.com/torvalds/linux/commit/ebc614f687369f9df99828572b1d85a7c2de3d92
-func LoadAttachCgroupDeviceFilter(insts asm.Instructions, license string, dirFD int) (func() error, error) {
+//
+// sysbox-runc: the program is attached with the given flags (either
+// BPF_F_ALLOW_MULTI, so that other programs can be attached to the cgroup and
+// its descendants, or 0, so that no other program can); the ID of the attached
+// program is returned, so that it can be detached later (see
+// DetachCgroupDeviceFilter()).
+func LoadAttachCgroupDeviceFilter(insts asm.Instructions, license string, dirFD int, flags uint32) (uint32, func() error, error) {
 	nilCloser := func() error {
 		return nil
 	}
@@ -30,16 +38,91 @@ func LoadAttachCgroupDeviceFilter(insts asm.Instructions, license string, dirFD
 	}
 	prog, err := ebpf.NewProgram(spec)
 	if err != nil {
-		return nilCloser, err
+		return 0, nilCloser, err
+	}
+	defer prog.Close()
+
+	if err := prog.Attach(dirFD, ebpf.AttachCGroupDevice, ebpf.AttachFlags(flags)); err != nil {
+		return 0, nilCloser, errors.Wrapf(err, "failed to call BPF_PROG_ATTACH (BPF_CGROUP_DEVICE, flags %#x)", flags)
 	}
-	if err := prog.Attach(dirFD, ebpf.AttachCGroupDevice, unix.BPF_F_ALLOW_MULTI); err != nil {
-		return nilCloser, errors.Wrap(err, "failed to call BPF_PROG_ATTACH (BPF_CGROUP_DEVICE, BPF_F_ALLOW_MULTI)")
+	id, err := progID(prog.FD())
+	if err != nil {
+		prog.Detach(dirFD, ebpf.AttachCGroupDevice, ebpf.AttachFlags(flags))
+		return 0, nilCloser, err
 	}
 	closer := func() error {
-		if err := prog.Detach(dirFD, ebpf.AttachCGroupDevice, unix.BPF_F_ALLOW_MULTI); err != nil {
-			return errors.Wrap(err, "failed to call BPF_PROG_DETACH (BPF_CGROUP_DEVICE, BPF_F_ALLOW_MULTI)")
-		}
+		return DetachCgroupDeviceFilter(dirFD, id)
+	}
+	return id, closer, nil
+}
+
+// sysbox-runc: DetachCgroupDeviceFilter detaches the device filter program
+// with the given ID from the given cgroup. It's a no-op if the program no
+// longer exists or is not attached to the cgroup.
+func DetachCgroupDeviceFilter(dirFD int, id uint32) error {
+	// union bpf_attr, for BPF_PROG_GET_FD_BY_ID
+	getFdAttr := struct {
+		progID    uint32
+		nextID    uint32
+		openFlags uint32
+	}{progID: id}
+
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_GET_FD_BY_ID,
+		uintptr(unsafe.Pointer(&getFdAttr)), unsafe.Sizeof(getFdAttr))
+	if errno == unix.ENOENT {
 		return nil
 	}
-	return closer, nil
+	if errno != 0 {
+		return errors.Wrapf(errno, "failed to call BPF_PROG_GET_FD_BY_ID (id %d)", id)
+	}
+	defer unix.Close(int(fd))
+
+	// union bpf_attr, for BPF_PROG_DETACH
+	detachAttr := struct {
+		targetFD    uint32
+		attachBpfFD uint32
+		attachType  uint32
+		attachFlags uint32
+	}{
+		targetFD:    uint32(dirFD),
+		attachBpfFD: uint32(fd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+	}
+
+	_, _, errno = unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_DETACH,
+		uintptr(unsafe.Pointer(&detachAttr)), unsafe.Sizeof(detachAttr))
+	if errno == unix.ENOENT {
+		return nil
+	}
+	if errno != 0 {
+		return errors.Wrapf(errno, "failed to call BPF_PROG_DETACH (BPF_CGROUP_DEVICE, id %d)", id)
+	}
+	return nil
+}
+
+// progID returns the ID of the eBPF program with the given fd.
+func progID(fd int) (uint32, error) {
+	// the head of struct bpf_prog_info
+	var info struct {
+		progType uint32
+		id       uint32
+	}
+
+	// union bpf_attr, for BPF_OBJ_GET_INFO_BY_FD
+	attr := struct {
+		bpfFD   uint32
+		infoLen uint32
+		info    uint64
+	}{
+		bpfFD:   uint32(fd),
+		infoLen: uint32(unsafe.Sizeof(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info))),
+	}
+
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET_INFO_BY_FD,
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return 0, errors.Wrap(errno, "failed to call BPF_OBJ_GET_INFO_BY_FD")
+	}
+	return info.id, nil
 }
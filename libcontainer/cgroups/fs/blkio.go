@@ -45,6 +45,11 @@ func (s *BlkioGroup) Set(path string, cgroup *configs.Cgroup) error {
 			return err
 		}
 	}
+
+	return setBlkioThrottle(path, cgroup)
+}
+
+func setBlkioThrottle(path string, cgroup *configs.Cgroup) error {
 	for _, td := range cgroup.Resources.BlkioThrottleReadBpsDevice {
 		if err := fscommon.WriteFile(path, "blkio.throttle.read_bps_device", td.String()); err != nil {
 			return err
@@ -69,6 +74,17 @@ func (s *BlkioGroup) Set(path string, cgroup *configs.Cgroup) error {
 	return nil
 }
 
+// sysbox-runc: setChildBlkioThrottle mirrors the blkio throttling settings of
+// the sys container's cgroup in its cgroup root (the child cgroup at path), so
+// they show up inside the container. Weights are not mirrored, as they are
+// relative to the cgroup's siblings (and the cgroup root has none).
+func setChildBlkioThrottle(path string, cgroup *configs.Cgroup) error {
+	if cgroup == nil || cgroup.Resources == nil {
+		return nil
+	}
+	return setBlkioThrottle(path, cgroup)
+}
+
 func (s *BlkioGroup) Clone(source, dest string) error {
 
 	if err := fscommon.WriteFile(source, "cgroup.clone_children", "1"); err != nil {
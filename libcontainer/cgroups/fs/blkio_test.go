@@ -849,3 +849,37 @@ func TestBlkioSetThrottleWriteIOpsDevice(t *testing.T) {
 		t.Fatal("Got the wrong value, set blkio.throttle.write_iops_device failed.")
 	}
 }
+
+func TestSetChildBlkioThrottle(t *testing.T) {
+	helper := NewCgroupTestUtil("blkio", t)
+	defer helper.cleanup()
+
+	helper.writeFileContents(map[string]string{
+		"blkio.weight":                     "500",
+		"blkio.throttle.write_iops_device": "",
+	})
+
+	td := configs.NewThrottleDevice(8, 0, 100)
+	helper.CgroupData.config.Resources.BlkioWeight = 200
+	helper.CgroupData.config.Resources.BlkioThrottleWriteIOPSDevice = []*configs.ThrottleDevice{td}
+	if err := setChildBlkioThrottle(helper.CgroupPath, helper.CgroupData.config); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := fscommon.GetCgroupParamString(helper.CgroupPath, "blkio.throttle.write_iops_device")
+	if err != nil {
+		t.Fatalf("Failed to parse blkio.throttle.write_iops_device - %s", err)
+	}
+	if value != td.String() {
+		t.Fatal("Got the wrong value, set blkio.throttle.write_iops_device failed.")
+	}
+
+	// weights are not mirrored
+	value, err = fscommon.GetCgroupParamString(helper.CgroupPath, "blkio.weight")
+	if err != nil {
+		t.Fatalf("Failed to parse blkio.weight - %s", err)
+	}
+	if value != "500" {
+		t.Fatal("Got the wrong value, blkio.weight was mirrored.")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/cgroups/fscommon"
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/pkg/errors"
 )
 
 type CpuGroup struct {
@@ -72,17 +73,78 @@ func (s *CpuGroup) Set(path string, cgroup *configs.Cgroup) error {
 			return fmt.Errorf("the minimum allowed cpu-shares is %d", sharesRead)
 		}
 	}
-	if cgroup.Resources.CpuPeriod != 0 {
-		if err := fscommon.WriteFile(path, "cpu.cfs_period_us", strconv.FormatUint(cgroup.Resources.CpuPeriod, 10)); err != nil {
+	if err := setCpuBandwidth(path, cgroup.Resources); err != nil {
+		return err
+	}
+	if err := setCpuIdle(path, cgroup.Resources); err != nil {
+		return err
+	}
+	return s.SetRtSched(path, cgroup)
+}
+
+func setCpuBandwidth(path string, r *configs.Resources) error {
+	if r.CpuPeriod != 0 {
+		if err := fscommon.WriteFile(path, "cpu.cfs_period_us", strconv.FormatUint(r.CpuPeriod, 10)); err != nil {
+			return err
+		}
+	}
+
+	// sysbox-runc: the burst can't exceed the quota, so it's set before the
+	// quota (in case the quota is lowered; errors are ignored, as the quota
+	// may be raised) and after it
+	burst := ""
+	if r.CpuBurst != nil {
+		burst = strconv.FormatUint(*r.CpuBurst, 10)
+		if r.CpuQuota != 0 {
+			_ = fscommon.WriteFile(path, "cpu.cfs_burst_us", burst)
+		}
+	}
+
+	if r.CpuQuota != 0 {
+		if err := fscommon.WriteFile(path, "cpu.cfs_quota_us", strconv.FormatInt(r.CpuQuota, 10)); err != nil {
 			return err
 		}
 	}
-	if cgroup.Resources.CpuQuota != 0 {
-		if err := fscommon.WriteFile(path, "cpu.cfs_quota_us", strconv.FormatInt(cgroup.Resources.CpuQuota, 10)); err != nil {
+
+	if burst != "" {
+		if err := fscommon.WriteFile(path, "cpu.cfs_burst_us", burst); err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				return errors.New("cpu.cfs_burst_us is not supported by the kernel")
+			}
 			return err
 		}
 	}
-	return s.SetRtSched(path, cgroup)
+	return nil
+}
+
+func setCpuIdle(path string, r *configs.Resources) error {
+	if r.CPUIdle == nil {
+		return nil
+	}
+	if err := fscommon.WriteFile(path, "cpu.idle", strconv.FormatInt(*r.CPUIdle, 10)); err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return errors.New("cpu.idle is not supported by the kernel")
+		}
+		return err
+	}
+	return nil
+}
+
+// sysbox-runc: setChildCpu mirrors the CPU burst and idle settings of the sys
+// container's cgroup in its cgroup root (the child cgroup at path). As the
+// burst only takes effect along with a quota, the CFS bandwidth settings are
+// mirrored along with it. It's a no-op if neither setting is set.
+func setChildCpu(path string, cgroup *configs.Cgroup) error {
+	if cgroup == nil || cgroup.Resources == nil {
+		return nil
+	}
+	r := cgroup.Resources
+	if r.CpuBurst != nil {
+		if err := setCpuBandwidth(path, r); err != nil {
+			return err
+		}
+	}
+	return setCpuIdle(path, r)
 }
 
 func (s *CpuGroup) GetStats(path string, stats *cgroups.Stats) error {
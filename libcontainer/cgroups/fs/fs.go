@@ -261,6 +261,40 @@ func (m *manager) CreateChildCgroup(config *configs.Config) error {
 		}
 	}
 
+	// sysbox-runc: keep the pids reserved for sysbox-runc's helper processes
+	// out of reach of the sys container's cgroup root (if requested)
+	if pidsPath := paths["pids"]; pidsPath != "" {
+		childPath := filepath.Join(pidsPath, cgroups.SyscontCgroupRoot)
+		if err := cgroups.SetChildPidsLimit(childPath, config); err != nil {
+			return err
+		}
+	}
+
+	// sysbox-runc: mirror the blkio throttling and the cpu burst and idle
+	// settings in the sys container's cgroup root
+	if err := SetChildResources(paths, config.Cgroups); err != nil {
+		return err
+	}
+
+	// sysbox-runc: split the pids budget between the init tree and the
+	// inner-runtime subtree (if requested)
+	if config.PidsSplit != nil {
+		if pidsPath := paths["pids"]; pidsPath != "" {
+			rootuid, err := config.HostRootUID()
+			if err != nil {
+				return err
+			}
+			rootgid, err := config.HostRootGID()
+			if err != nil {
+				return err
+			}
+			childPath := filepath.Join(pidsPath, cgroups.SyscontCgroupRoot)
+			if err := cgroups.CreatePidsSplit(childPath, config.PidsSplit, rootuid, rootgid); err != nil {
+				return err
+			}
+		}
+	}
+
 	m.childCgroupCreated = true
 	return nil
 }
@@ -397,6 +431,19 @@ func (m *manager) Set(container *configs.Config) error {
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+
+	// sysbox-runc: mirror the pids limit in the sys container's cgroup root
+	// (minus the pids reserve); this is done first, so an invalid limit is
+	// rejected before any change is made
+	if m.childCgroupCreated {
+		if pidsPath := m.paths["pids"]; pidsPath != "" {
+			childPath := filepath.Join(pidsPath, cgroups.SyscontCgroupRoot)
+			if err := cgroups.SetChildPidsLimit(childPath, container); err != nil {
+				return err
+			}
+		}
+	}
+
 	for _, sys := range subsystems {
 		path := m.paths[sys.Name()]
 		if err := sys.Set(path, container.Cgroups); err != nil {
@@ -415,6 +462,34 @@ func (m *manager) Set(container *configs.Config) error {
 		}
 	}
 
+	// sysbox-runc: mirror the blkio throttling and the cpu burst and idle
+	// settings in the sys container's cgroup root
+	if m.childCgroupCreated {
+		if err := SetChildResources(m.paths, container.Cgroups); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sysbox-runc: SetChildResources mirrors the resource settings that apply to
+// the sys container's cgroup root (i.e., the child cgroup under each of the
+// given controller paths), other than the pids limit (see
+// cgroups.SetChildPidsLimit()).
+func SetChildResources(paths map[string]string, cgroup *configs.Cgroup) error {
+	if blkioPath := paths["blkio"]; blkioPath != "" {
+		childPath := filepath.Join(blkioPath, cgroups.SyscontCgroupRoot)
+		if err := setChildBlkioThrottle(childPath, cgroup); err != nil {
+			return err
+		}
+	}
+	if cpuPath := paths["cpu"]; cpuPath != "" {
+		childPath := filepath.Join(cpuPath, cgroups.SyscontCgroupRoot)
+		if err := setChildCpu(childPath, cgroup); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -5,15 +5,18 @@ package fs2
 import (
 	"bufio"
 	"os"
+	"path/filepath"
 	"strconv"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/cgroups/fscommon"
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/pkg/errors"
 )
 
 func isCpuSet(cgroup *configs.Cgroup) bool {
-	return cgroup.Resources.CpuWeight != 0 || cgroup.Resources.CpuQuota != 0 || cgroup.Resources.CpuPeriod != 0
+	return cgroup.Resources.CpuWeight != 0 || cgroup.Resources.CpuQuota != 0 || cgroup.Resources.CpuPeriod != 0 ||
+		cgroup.Resources.CpuBurst != nil || cgroup.Resources.CPUIdle != nil
 }
 
 func setCpu(dirPath string, cgroup *configs.Cgroup) error {
@@ -29,6 +32,24 @@ func setCpu(dirPath string, cgroup *configs.Cgroup) error {
 		}
 	}
 
+	if err := setCpuMax(dirPath, r); err != nil {
+		return err
+	}
+	return setCpuIdle(dirPath, r)
+}
+
+func setCpuMax(dirPath string, r *configs.Resources) error {
+	// sysbox-runc: the burst can't exceed the quota, so it's set before the
+	// quota (in case the quota is lowered; errors are ignored, as the quota
+	// may be raised) and after it
+	burst := ""
+	if r.CpuBurst != nil {
+		burst = strconv.FormatUint(*r.CpuBurst, 10)
+		if r.CpuQuota != 0 || r.CpuPeriod != 0 {
+			_ = fscommon.WriteFile(dirPath, "cpu.max.burst", burst)
+		}
+	}
+
 	if r.CpuQuota != 0 || r.CpuPeriod != 0 {
 		str := "max"
 		if r.CpuQuota > 0 {
@@ -46,8 +67,60 @@ func setCpu(dirPath string, cgroup *configs.Cgroup) error {
 		}
 	}
 
+	if burst != "" {
+		if err := fscommon.WriteFile(dirPath, "cpu.max.burst", burst); err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				return errors.New("cpu.max.burst is not supported by the kernel")
+			}
+			return err
+		}
+	}
 	return nil
 }
+
+func setCpuIdle(dirPath string, r *configs.Resources) error {
+	if r.CPUIdle == nil {
+		return nil
+	}
+	if err := fscommon.WriteFile(dirPath, "cpu.idle", strconv.FormatInt(*r.CPUIdle, 10)); err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return errors.New("cpu.idle is not supported by the kernel")
+		}
+		return err
+	}
+	return nil
+}
+
+// sysbox-runc: SetChildCpu mirrors the CPU burst and idle settings of the sys
+// container's cgroup (at path) in its child cgroup (the "init.scope" leaf
+// where the sys container's init process lives), enabling the cpu controller
+// for it as needed. As the burst only takes effect along with a quota, the
+// CFS bandwidth settings (cpu.max) are mirrored along with it. It's a no-op if
+// neither setting is set, or if the child cgroup has not been created yet.
+func SetChildCpu(path string, cgroup *configs.Cgroup) error {
+	if cgroup == nil || cgroup.Resources == nil {
+		return nil
+	}
+	r := cgroup.Resources
+	if r.CpuBurst == nil && r.CPUIdle == nil {
+		return nil
+	}
+
+	leafPath := filepath.Join(path, "init.scope")
+	if _, err := os.Stat(leafPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := fscommon.WriteFile(path, "cgroup.subtree_control", "+cpu"); err != nil {
+		return errors.Wrapf(err, "failed to enable cpu controller in %s", path)
+	}
+	if r.CpuBurst != nil {
+		if err := setCpuMax(leafPath, r); err != nil {
+			return err
+		}
+	}
+	return setCpuIdle(leafPath, r)
+}
 func statCpu(dirPath string, stats *cgroups.Stats) error {
 	f, err := fscommon.OpenFile(dirPath, "cpu.stat", os.O_RDONLY)
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 
 	"github.com/opencontainers/runc/libcontainer/cgroups/fscommon"
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"golang.org/x/sys/unix"
 )
 
 func supportedControllers(cgroup *configs.Cgroup) (string, error) {
@@ -86,6 +87,15 @@ func CreateCgroupPath(path string, c *configs.Cgroup) (Err error) {
 	ctrs := strings.Fields(content)
 	res := "+" + strings.Join(ctrs, " +")
 
+	// sysbox-runc: serialize the creation of cgroup paths, as concurrent
+	// creations may share ancestors, which a failed creation removes if it
+	// created them (see below).
+	unlock, err := lockCgroupTree()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	elements := strings.Split(path, "/")
 	elements = elements[3:]
 	current := "/sys/fs"
@@ -151,3 +161,18 @@ func CreateCgroupPath(path string, c *configs.Cgroup) (Err error) {
 
 	return nil
 }
+
+// sysbox-runc: lockCgroupTree takes an exclusive lock on the cgroup v2 tree,
+// held by sysbox-runc instances while they create cgroup paths; it returns the
+// function that releases it.
+func lockCgroupTree() (func(), error) {
+	f, err := os.Open(UnifiedMountpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", UnifiedMountpoint, err)
+	}
+	return func() { f.Close() }, nil
+}
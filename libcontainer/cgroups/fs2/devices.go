@@ -54,21 +54,33 @@ func setDevices(dirPath string, cgroup *configs.Cgroup) error {
 		return errors.Errorf("cannot get dir FD for %s", dirPath)
 	}
 	defer unix.Close(dirFD)
-	// XXX: This code is currently incorrect when it comes to updating an
-	//      existing cgroup with new rules (new rulesets are just appended to
-	//      the program list because this uses BPF_F_ALLOW_MULTI). If we didn't
-	//      use BPF_F_ALLOW_MULTI we could actually atomically swap the
-	//      programs.
-	//
-	//      The real issue is that BPF_F_ALLOW_MULTI makes it hard to have a
-	//      race-free blacklist because it acts as a whitelist by default, and
-	//      having a deny-everything program cannot be overridden by other
-	//      programs. You could temporarily insert a deny-everything program
-	//      but that would result in spurrious failures during updates.
-	if _, err := ebpf.LoadAttachCgroupDeviceFilter(insts, license, dirFD); err != nil {
+
+	// sysbox-runc: with the "multi" policy, the device filter is attached with
+	// BPF_F_ALLOW_MULTI, so that container engines inside the sys container can
+	// attach their own device filters to the sys container's sub-cgroups. On
+	// updates, the new filter is attached before the previous one (which we
+	// know by ID) is detached; this way the device filters of others are left
+	// alone, and there's no window without a filter. With the "exclusive"
+	// policy, the new filter replaces the previous one.
+	flags := uint32(unix.BPF_F_ALLOW_MULTI)
+	if cgroup.DevicesPolicy == configs.DevicesPolicyExclusive {
+		flags = 0
+	}
+
+	id, _, err := ebpf.LoadAttachCgroupDeviceFilter(insts, license, dirFD, flags)
+	if err != nil {
 		if !canSkipEBPFError(cgroup) {
 			return err
 		}
+		return nil
+	}
+
+	if prevID := cgroup.DevicesProgID; prevID != 0 && prevID != id && flags != 0 {
+		if err := ebpf.DetachCgroupDeviceFilter(dirFD, prevID); err != nil {
+			return err
+		}
 	}
+	cgroup.DevicesProgID = id
+
 	return nil
 }
@@ -143,6 +143,12 @@ func (m *manager) GetStats() (*cgroups.Stats, error) {
 			errs = append(errs, err)
 		}
 	}
+	// sysbox-runc: pressure stall information (since kernel 4.20)
+	if p, err := StatPressure(m.dirPath); err != nil {
+		errs = append(errs, err)
+	} else {
+		st.Pressure = p
+	}
 	if len(errs) > 0 && !m.rootless {
 		return st, errors.Errorf("error while statting cgroup v2: %+v", errs)
 	}
@@ -180,14 +186,26 @@ func (m *manager) Set(container *configs.Config) error {
 	if err := setMemory(m.dirPath, container.Cgroups); err != nil {
 		return err
 	}
+	// sysbox-runc: memory QoS settings apply to the child cgroup too
+	if err := SetChildMemoryQoS(m.dirPath, container.Cgroups); err != nil {
+		return err
+	}
 	// io (since kernel 4.5)
 	if err := setIo(m.dirPath, container.Cgroups); err != nil {
 		return err
 	}
+	// sysbox-runc: io throttling applies to the child cgroup too
+	if err := SetChildIoMax(m.dirPath, container.Cgroups); err != nil {
+		return err
+	}
 	// cpu (since kernel 4.15)
 	if err := setCpu(m.dirPath, container.Cgroups); err != nil {
 		return err
 	}
+	// sysbox-runc: cpu burst and idle settings apply to the child cgroup too
+	if err := SetChildCpu(m.dirPath, container.Cgroups); err != nil {
+		return err
+	}
 	// devices (since kernel 4.15, pseudo-controller)
 	//
 	// When m.Rootless is true, errors from the device subsystem are ignored because it is really not expected to work.
@@ -346,6 +364,29 @@ func (m *manager) CreateChildCgroup(config *configs.Config) error {
 		}
 	}
 
+	// Split the pids budget between the init tree and the inner-runtime
+	// subtree (if requested)
+	if config.PidsSplit != nil {
+		if err := cgroups.CreatePidsSplit(path, config.PidsSplit, rootuid, rootgid); err != nil {
+			return err
+		}
+	}
+
+	// Apply the memory QoS settings to the leaf cgroup too
+	if err := SetChildMemoryQoS(path, config.Cgroups); err != nil {
+		return err
+	}
+
+	// Apply the io throttling to the leaf cgroup too
+	if err := SetChildIoMax(path, config.Cgroups); err != nil {
+		return err
+	}
+
+	// Apply the cpu burst and idle settings to the leaf cgroup too
+	if err := SetChildCpu(path, config.Cgroups); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -4,13 +4,16 @@ package fs2
 
 import (
 	"bufio"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/cgroups/fscommon"
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/pkg/errors"
 )
 
 func isIoSet(cgroup *configs.Cgroup) bool {
@@ -21,6 +24,13 @@ func isIoSet(cgroup *configs.Cgroup) bool {
 		len(cgroup.Resources.BlkioThrottleWriteIOPSDevice) > 0
 }
 
+func isIoMaxSet(cgroup *configs.Cgroup) bool {
+	return len(cgroup.Resources.BlkioThrottleReadBpsDevice) > 0 ||
+		len(cgroup.Resources.BlkioThrottleWriteBpsDevice) > 0 ||
+		len(cgroup.Resources.BlkioThrottleReadIOPSDevice) > 0 ||
+		len(cgroup.Resources.BlkioThrottleWriteIOPSDevice) > 0
+}
+
 func setIo(dirPath string, cgroup *configs.Cgroup) error {
 	if !isIoSet(cgroup) {
 		return nil
@@ -33,28 +43,57 @@ func setIo(dirPath string, cgroup *configs.Cgroup) error {
 			return err
 		}
 	}
-	for _, td := range cgroup.Resources.BlkioThrottleReadBpsDevice {
-		if err := fscommon.WriteFile(dirPath, "io.max", td.StringName("rbps")); err != nil {
-			return err
-		}
-	}
-	for _, td := range cgroup.Resources.BlkioThrottleWriteBpsDevice {
-		if err := fscommon.WriteFile(dirPath, "io.max", td.StringName("wbps")); err != nil {
-			return err
+
+	return setIoMax(dirPath, cgroup)
+}
+
+func setIoMax(dirPath string, cgroup *configs.Cgroup) error {
+	for _, throttle := range []struct {
+		key     string
+		devices []*configs.ThrottleDevice
+	}{
+		{"rbps", cgroup.Resources.BlkioThrottleReadBpsDevice},
+		{"wbps", cgroup.Resources.BlkioThrottleWriteBpsDevice},
+		{"riops", cgroup.Resources.BlkioThrottleReadIOPSDevice},
+		{"wiops", cgroup.Resources.BlkioThrottleWriteIOPSDevice},
+	} {
+		for _, td := range throttle.devices {
+			// sysbox-runc: a zero rate removes the limit (as with cgroup v1),
+			// rather than being ignored by the kernel
+			val := td.StringName(throttle.key)
+			if td.Rate == 0 {
+				val = fmt.Sprintf("%d:%d %s=max", td.Major, td.Minor, throttle.key)
+			}
+			if err := fscommon.WriteFile(dirPath, "io.max", val); err != nil {
+				return err
+			}
 		}
 	}
-	for _, td := range cgroup.Resources.BlkioThrottleReadIOPSDevice {
-		if err := fscommon.WriteFile(dirPath, "io.max", td.StringName("riops")); err != nil {
-			return err
-		}
+	return nil
+}
+
+// sysbox-runc: SetChildIoMax mirrors the io throttling settings (io.max) of
+// the sys container's cgroup (at path) in its child cgroup (the "init.scope"
+// leaf where the sys container's init process lives), enabling the io
+// controller for it as needed; the io controller then becomes available to
+// the sub-cgroups created inside the container too (e.g., by an inner
+// container engine). Weights (io.bfq.weight) are not mirrored, as they are
+// relative to the cgroup's siblings. It's a no-op if no throttling is set, or
+// if the child cgroup has not been created yet.
+func SetChildIoMax(path string, cgroup *configs.Cgroup) error {
+	if cgroup == nil || cgroup.Resources == nil || !isIoMaxSet(cgroup) {
+		return nil
 	}
-	for _, td := range cgroup.Resources.BlkioThrottleWriteIOPSDevice {
-		if err := fscommon.WriteFile(dirPath, "io.max", td.StringName("wiops")); err != nil {
-			return err
-		}
+
+	leafPath := filepath.Join(path, "init.scope")
+	if _, err := os.Stat(leafPath); os.IsNotExist(err) {
+		return nil
 	}
 
-	return nil
+	if err := fscommon.WriteFile(path, "cgroup.subtree_control", "+io"); err != nil {
+		return errors.Wrapf(err, "failed to enable io controller in %s", path)
+	}
+	return setIoMax(leafPath, cgroup)
 }
 
 func readCgroup2MapFile(dirPath string, name string) (map[string][]string, error) {
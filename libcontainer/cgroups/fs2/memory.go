@@ -5,6 +5,7 @@ package fs2
 import (
 	"bufio"
 	"os"
+	"path/filepath"
 	"strconv"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
@@ -32,7 +33,16 @@ func numToStr(value int64) (ret string) {
 
 func isMemorySet(cgroup *configs.Cgroup) bool {
 	return cgroup.Resources.MemoryReservation != 0 ||
-		cgroup.Resources.Memory != 0 || cgroup.Resources.MemorySwap != 0
+		cgroup.Resources.Memory != 0 || cgroup.Resources.MemorySwap != 0 ||
+		isMemoryQoSSet(cgroup)
+}
+
+// sysbox-runc: isMemoryQoSSet returns true if any of the memory QoS settings
+// (see setMemoryQoS()) is set.
+func isMemoryQoSSet(cgroup *configs.Cgroup) bool {
+	return cgroup.Resources.MemoryHigh != nil ||
+		cgroup.Resources.MemorySwapMax != nil ||
+		cgroup.Resources.MemoryZswapMax != nil
 }
 
 func setMemory(dirPath string, cgroup *configs.Cgroup) error {
@@ -69,9 +79,58 @@ func setMemory(dirPath string, cgroup *configs.Cgroup) error {
 		}
 	}
 
+	return setMemoryQoS(dirPath, cgroup)
+}
+
+// sysbox-runc: setMemoryQoS writes the memory QoS settings (memory.high,
+// memory.swap.max and memory.zswap.max) that are set to the given cgroup.
+func setMemoryQoS(dirPath string, cgroup *configs.Cgroup) error {
+	for _, s := range []struct {
+		file string
+		val  *int64
+	}{
+		{"memory.high", cgroup.Resources.MemoryHigh},
+		{"memory.swap.max", cgroup.Resources.MemorySwapMax},
+		{"memory.zswap.max", cgroup.Resources.MemoryZswapMax},
+	} {
+		if s.val == nil {
+			continue
+		}
+		val := "max"
+		if *s.val >= 0 {
+			val = strconv.FormatInt(*s.val, 10)
+		}
+		if err := fscommon.WriteFile(dirPath, s.file, val); err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				return errors.Errorf("%s is not supported by the kernel", s.file)
+			}
+			return err
+		}
+	}
 	return nil
 }
 
+// sysbox-runc: SetChildMemoryQoS mirrors the memory QoS settings of the sys
+// container's cgroup (at path) in its child cgroup (the "init.scope" leaf where
+// the sys container's init process lives), enabling the memory controller for
+// it as needed. It's a no-op if none of the settings is set, or if the child
+// cgroup has not been created yet.
+func SetChildMemoryQoS(path string, cgroup *configs.Cgroup) error {
+	if cgroup == nil || cgroup.Resources == nil || !isMemoryQoSSet(cgroup) {
+		return nil
+	}
+
+	leafPath := filepath.Join(path, "init.scope")
+	if _, err := os.Stat(leafPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := fscommon.WriteFile(path, "cgroup.subtree_control", "+memory"); err != nil {
+		return errors.Wrapf(err, "failed to enable memory controller in %s", path)
+	}
+	return setMemoryQoS(leafPath, cgroup)
+}
+
 func statMemory(dirPath string, stats *cgroups.Stats) error {
 	// Set stats from memory.stat.
 	statsFile, err := fscommon.OpenFile(dirPath, "memory.stat", os.O_RDONLY)
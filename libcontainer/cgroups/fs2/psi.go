@@ -0,0 +1,115 @@
+// +build linux
+
+package fs2
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/cgroups/fscommon"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: StatPressure returns the pressure stall information of the
+// cgroup in the given dir, or nil if the kernel lacks it (i.e., before kernel
+// 4.20, or with PSI disabled).
+func StatPressure(dirPath string) (*cgroups.PressureStats, error) {
+	p := &cgroups.PressureStats{}
+	found := false
+
+	for _, r := range []struct {
+		file string
+		psi  **cgroups.PSIStats
+	}{
+		{"cpu.pressure", &p.CPU},
+		{"memory.pressure", &p.Memory},
+		{"io.pressure", &p.IO},
+	} {
+		psi, err := statPSI(dirPath, r.file)
+		if err != nil {
+			return nil, err
+		}
+		if psi != nil {
+			*r.psi = psi
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// statPSI returns the pressure stall information in the given file of the
+// cgroup dir, or nil if the kernel lacks it.
+func statPSI(dirPath, file string) (*cgroups.PSIStats, error) {
+	f, err := fscommon.OpenFile(dirPath, file, unix.O_RDONLY)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	psi := &cgroups.PSIStats{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var data *cgroups.PSIData
+		switch fields[0] {
+		case "some":
+			data = &psi.Some
+		case "full":
+			data = &psi.Full
+		default:
+			continue
+		}
+		if err := parsePSIData(fields[1:], data); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", file)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		// PSI disabled at boot (psi=0) on kernels that keep the files
+		if errors.Is(err, unix.EOPNOTSUPP) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read %s", file)
+	}
+	return psi, nil
+}
+
+// parsePSIData parses the "<key>=<value>" fields of a line of a pressure file
+// (e.g., "avg10=0.00 avg60=0.00 avg300=0.00 total=0").
+func parsePSIData(fields []string, data *cgroups.PSIData) error {
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid field %q", field)
+		}
+		var err error
+		switch kv[0] {
+		case "avg10":
+			data.Avg10, err = strconv.ParseFloat(kv[1], 64)
+		case "avg60":
+			data.Avg60, err = strconv.ParseFloat(kv[1], 64)
+		case "avg300":
+			data.Avg300, err = strconv.ParseFloat(kv[1], 64)
+		case "total":
+			data.Total, err = strconv.ParseUint(kv[1], 10, 64)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid field %q: %v", field, err)
+		}
+	}
+	return nil
+}
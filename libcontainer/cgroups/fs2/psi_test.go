@@ -0,0 +1,65 @@
+// +build linux
+
+package fs2
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+)
+
+func TestStatPressure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// older kernels lack "full" for cpu; io.pressure is missing
+	files := map[string]string{
+		"cpu.pressure":    "some avg10=1.50 avg60=0.75 avg300=0.10 total=123456\n",
+		"memory.pressure": "some avg10=20.00 avg60=5.00 avg300=1.00 total=987654\nfull avg10=10.00 avg60=2.50 avg300=0.50 total=456789\n",
+	}
+	for file, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := StatPressure(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &cgroups.PressureStats{
+		CPU: &cgroups.PSIStats{
+			Some: cgroups.PSIData{Avg10: 1.5, Avg60: 0.75, Avg300: 0.1, Total: 123456},
+		},
+		Memory: &cgroups.PSIStats{
+			Some: cgroups.PSIData{Avg10: 20, Avg60: 5, Avg300: 1, Total: 987654},
+			Full: cgroups.PSIData{Avg10: 10, Avg60: 2.5, Avg300: 0.5, Total: 456789},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// no pressure files: the kernel lacks PSI
+	empty := filepath.Join(dir, "empty")
+	if err := os.Mkdir(empty, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := StatPressure(empty); err != nil || got != nil {
+		t.Errorf("got %+v (err %v), want nil", got, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "io.pressure"), []byte("some avg10=high\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StatPressure(dir); err == nil {
+		t.Error("expected error parsing an invalid io.pressure")
+	}
+}
@@ -0,0 +1,118 @@
+// +build linux
+
+package cgroups
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups/fscommon"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// sysbox-runc: CreatePidsSplit sets up the nested cgroups that split the sys
+// container's pids budget between its init tree and the inner-runtime subtree.
+//
+// root is the sys container's cgroup root (i.e., the cgroup that is delegated
+// to the container; for cgroup v1 this is the pids controller's
+// "syscont-cgroup-root"). The inner-runtime subtree is created under it and
+// owned by the container's root user, so the inner runtime (e.g., Docker)
+// can create its own sub-cgroups within it; the inner runtime's containers
+// then can't consume the pids reserved for the init tree.
+//
+// For cgroup v2, the init tree (i.e., the "init.scope" leaf cgroup) may be
+// limited too. For cgroup v1 the init tree is the sys container's cgroup root
+// itself (which contains the inner subtree), so only the inner subtree can be
+// limited.
+func CreatePidsSplit(root string, split *configs.PidsSplit, rootuid, rootgid int) error {
+	unified := IsCgroup2UnifiedMode()
+
+	if unified {
+		if err := fscommon.WriteFile(root, "cgroup.subtree_control", "+pids"); err != nil {
+			return fmt.Errorf("failed to enable pids controller in %s: %v", root, err)
+		}
+		if split.InitLimit > 0 {
+			initPath := filepath.Join(root, "init.scope")
+			if err := fscommon.WriteFile(initPath, "pids.max", strconv.FormatInt(split.InitLimit, 10)); err != nil {
+				return fmt.Errorf("failed to set pids limit on %s: %v", initPath, err)
+			}
+		}
+	} else if split.InitLimit > 0 {
+		return fmt.Errorf("a pids limit for the sys container's init tree requires cgroup v2")
+	}
+
+	innerPath := filepath.Join(root, split.InnerPath)
+	if err := os.MkdirAll(innerPath, 0755); err != nil {
+		return err
+	}
+	if err := fscommon.WriteFile(innerPath, "pids.max", strconv.FormatInt(split.InnerLimit, 10)); err != nil {
+		return fmt.Errorf("failed to set pids limit on %s: %v", innerPath, err)
+	}
+
+	// Delegate the inner subtree to the container's root user; for cgroup v2
+	// only a subset of the files is delegated (see cgroups(7)). In either case
+	// the subtree's pids.max stays owned by the host. Note that this guards
+	// against runaway inner workloads, not against a malicious container root
+	// (which controls the parent cgroup and can thus replace the subtree).
+	if err := os.Chown(innerPath, rootuid, rootgid); err != nil {
+		return fmt.Errorf("failed to change owner of cgroup %s", innerPath)
+	}
+	files, err := ioutil.ReadDir(innerPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		fname := file.Name()
+		if fname == "pids.max" {
+			continue
+		}
+		if unified &&
+			fname != "cgroup.procs" &&
+			fname != "cgroup.subtree_control" &&
+			fname != "cgroup.threads" {
+			continue
+		}
+		absFileName := filepath.Join(innerPath, fname)
+		if err := os.Chown(absFileName, rootuid, rootgid); err != nil {
+			return fmt.Errorf("failed to change owner for file %s", absFileName)
+		}
+	}
+
+	return nil
+}
+
+// sysbox-runc: SetChildPidsLimit sets the pids limit of the sys container's
+// cgroup root (see CreatePidsSplit()) to the container's pids limit minus the
+// pids reserved for sysbox-runc's helper processes (see
+// configs.Config.PidsReserve). This way the container's workloads can't use up
+// the reserve, which remains available in the container's cgroup (i.e., the
+// parent of the cgroup root). It's a no-op if there is no reserve (the
+// default; it's cgroup v1 only) or the pids limit is unchanged (e.g., on a
+// resource update that doesn't touch it).
+func SetChildPidsLimit(root string, config *configs.Config) error {
+	if config.PidsReserve == 0 || config.Cgroups == nil {
+		return nil
+	}
+
+	limit := config.Cgroups.Resources.PidsLimit
+	val := ""
+
+	switch {
+	case limit == 0:
+		return nil
+	case limit < 0:
+		val = "max"
+	case limit <= config.PidsReserve:
+		return fmt.Errorf("pids limit (%d) must be higher than the pids reserve (%d)", limit, config.PidsReserve)
+	default:
+		val = strconv.FormatInt(limit-config.PidsReserve, 10)
+	}
+
+	if err := fscommon.WriteFile(root, "pids.max", val); err != nil {
+		return fmt.Errorf("failed to set pids limit on %s: %v", root, err)
+	}
+	return nil
+}
@@ -146,6 +146,34 @@ type HugetlbStats struct {
 	Failcnt uint64 `json:"failcnt"`
 }
 
+// sysbox-runc: PSIData is a line ("some" or "full") of the pressure stall
+// information of a cgroup (see the kernel's Documentation/accounting/psi.rst).
+type PSIData struct {
+	// share of time (in percent) in which some (or all) tasks were stalled,
+	// over the last 10, 60 and 300 seconds
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	// total stall time.
+	// Units: microseconds.
+	Total uint64 `json:"total"`
+}
+
+// sysbox-runc: PSIStats is the pressure stall information of a cgroup for a
+// resource.
+type PSIStats struct {
+	Some PSIData `json:"some"`
+	Full PSIData `json:"full"`
+}
+
+// sysbox-runc: PressureStats is the pressure stall information of a cgroup
+// (cgroup v2 only; nil for the resources the kernel lacks it for).
+type PressureStats struct {
+	CPU    *PSIStats `json:"cpu,omitempty"`
+	Memory *PSIStats `json:"memory,omitempty"`
+	IO     *PSIStats `json:"io,omitempty"`
+}
+
 type Stats struct {
 	CpuStats    CpuStats    `json:"cpu_stats,omitempty"`
 	CPUSetStats CPUSetStats `json:"cpuset_stats,omitempty"`
@@ -154,6 +182,8 @@ type Stats struct {
 	BlkioStats  BlkioStats  `json:"blkio_stats,omitempty"`
 	// the map is in the format "size of hugepage: stats of the hugepage"
 	HugetlbStats map[string]HugetlbStats `json:"hugetlb_stats,omitempty"`
+	// sysbox-runc: pressure stall information (nil if the kernel lacks it)
+	Pressure *PressureStats `json:"pressure,omitempty"`
 }
 
 func NewStats() *Stats {
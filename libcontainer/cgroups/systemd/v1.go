@@ -371,6 +371,19 @@ func (m *legacyManager) Set(container *configs.Config) error {
 	if container.Cgroups.Resources.Unified != nil {
 		return cgroups.ErrV1NoUnified
 	}
+
+	// sysbox-runc: mirror the pids limit in the sys container's cgroup root
+	// (minus the pids reserve); this is done first, so an invalid limit is
+	// rejected before any change is made
+	if m.childCgroupCreated {
+		if pidsPath := m.paths["pids"]; pidsPath != "" {
+			childPath := filepath.Join(pidsPath, cgroups.SyscontCgroupRoot)
+			if err := cgroups.SetChildPidsLimit(childPath, container); err != nil {
+				return err
+			}
+		}
+	}
+
 	dbusConnection, err := getDbusConnection(false)
 	if err != nil {
 		return err
@@ -423,6 +436,14 @@ func (m *legacyManager) Set(container *configs.Config) error {
 		}
 	}
 
+	// sysbox-runc: mirror the blkio throttling and the cpu burst and idle
+	// settings in the sys container's cgroup root
+	if m.childCgroupCreated {
+		if err := fs.SetChildResources(m.paths, container.Cgroups); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
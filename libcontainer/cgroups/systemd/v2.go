@@ -197,6 +197,17 @@ func genV2ResourcesProperties(c *configs.Cgroup, conn *systemdDbus.Conn) ([]syst
 			newProp("MemorySwapMax", uint64(swap)))
 	}
 
+	// sysbox-runc: memory QoS settings (memory.zswap.max has no systemd
+	// property we can rely on; it's applied to cgroupfs by fs2.Set)
+	if r.MemoryHigh != nil {
+		properties = append(properties,
+			newProp("MemoryHigh", uint64(*r.MemoryHigh)))
+	}
+	if r.MemorySwapMax != nil {
+		properties = append(properties,
+			newProp("MemorySwapMax", uint64(*r.MemorySwapMax)))
+	}
+
 	if r.CpuWeight != 0 {
 		properties = append(properties,
 			newProp("CPUWeight", r.CpuWeight))
@@ -597,6 +608,29 @@ func (m *unifiedManager) CreateChildCgroup(config *configs.Config) error {
 		}
 	}
 
+	// Split the pids budget between the init tree and the inner-runtime
+	// subtree (if requested)
+	if config.PidsSplit != nil {
+		if err := cgroups.CreatePidsSplit(path, config.PidsSplit, rootuid, rootgid); err != nil {
+			return err
+		}
+	}
+
+	// Apply the memory QoS settings to the leaf cgroup too
+	if err := fs2.SetChildMemoryQoS(path, config.Cgroups); err != nil {
+		return err
+	}
+
+	// Apply the io throttling to the leaf cgroup too
+	if err := fs2.SetChildIoMax(path, config.Cgroups); err != nil {
+		return err
+	}
+
+	// Apply the cpu burst and idle settings to the leaf cgroup too
+	if err := fs2.SetChildCpu(path, config.Cgroups); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -13,6 +13,19 @@ const (
 	Thawed    FreezerState = "THAWED"
 )
 
+// sysbox-runc: policies for attaching the container's device filter (an eBPF
+// program) to its cgroup; cgroup v2 only.
+const (
+	// The device filter may be one of several attached to the container's
+	// cgroup and its descendants (e.g., by a container engine running inside
+	// the sys container); a device is accessible only if all of them allow it.
+	DevicesPolicyMulti = "multi"
+
+	// The device filter is the only one; no other device filters can be
+	// attached to the container's cgroup or its descendants.
+	DevicesPolicyExclusive = "exclusive"
+)
+
 type Cgroup struct {
 	// Deprecated, use Path instead
 	Name string `json:"name,omitempty"`
@@ -35,6 +48,16 @@ type Cgroup struct {
 	// Resources contains various cgroups settings to apply
 	*Resources
 
+	// sysbox-runc: DevicesPolicy is the policy for attaching the container's
+	// device filter to its cgroup (cgroup v2 only); defaults to
+	// DevicesPolicyMulti.
+	DevicesPolicy string `json:"devices_policy,omitempty"`
+
+	// sysbox-runc: DevicesProgID is the ID of the device filter attached to
+	// the container's cgroup (cgroup v2 only), so that it's replaced (rather
+	// than stacked upon) when the container's devices are updated.
+	DevicesProgID uint32 `json:"devices_prog_id,omitempty"`
+
 	// SystemdProps are any additional properties for systemd,
 	// derived from org.systemd.property.xxx annotations.
 	// Ignored unless systemd is used for managing cgroups.
@@ -131,6 +154,33 @@ type Resources struct {
 	// Unified is cgroupv2-only key-value map.
 	Unified map[string]string `json:"unified"`
 
+	// sysbox-runc: memory QoS settings (cgroup v2 only); they apply to the sys
+	// container's cgroup and its child cgroup. nil leaves the setting
+	// unchanged; -1 means no limit.
+
+	// Memory usage throttle limit (memory.high, in bytes)
+	MemoryHigh *int64 `json:"memory_high,omitempty"`
+
+	// Swap usage limit (memory.swap.max, in bytes); unlike MemorySwap, it does
+	// not include memory usage. Overrides the limit derived from MemorySwap.
+	MemorySwapMax *int64 `json:"memory_swap_max,omitempty"`
+
+	// Zswap pool size limit (memory.zswap.max, in bytes)
+	MemoryZswapMax *int64 `json:"memory_zswap_max,omitempty"`
+
+	// sysbox-runc: CPU burst and idle settings (cgroup v1 and v2); they apply
+	// to the sys container's cgroup and its child cgroup. nil leaves the
+	// setting unchanged.
+
+	// CPU burst (in usecs): CPU time the cgroup may accumulate while below its
+	// CFS quota, to exceed the quota later; can't exceed the quota
+	// (cpu.max.burst or cpu.cfs_burst_us; since kernel 5.14).
+	CpuBurst *uint64 `json:"cpu_burst,omitempty"`
+
+	// Whether the cgroup is scheduled as SCHED_IDLE relative to its siblings
+	// (cpu.idle: 0 or 1; since kernel 5.15).
+	CPUIdle *int64 `json:"cpu_idle,omitempty"`
+
 	// SkipDevices allows to skip configuring device permissions.
 	// Used by e.g. kubelet while creating a parent cgroup (kubepods)
 	// common for many containers.
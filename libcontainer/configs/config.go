@@ -78,12 +78,153 @@ type Syscall struct {
 	Args     []*Arg `json:"args"`
 }
 
+// UidShiftMethod is how the ownership of a container's rootfs or bind mount
+// source is shifted to the container's user-ID range.
+type UidShiftMethod string
+
+const (
+	UidShiftNone    UidShiftMethod = "none"
+	UidShiftShiftfs UidShiftMethod = "shiftfs"
+	UidShiftIdmap   UidShiftMethod = "idmapped-mount"
+	UidShiftFuse    UidShiftMethod = "fuse"
+	UidShiftChown   UidShiftMethod = "chown"
+)
+
+// UidShiftDecision records the uid shifting of the container's rootfs (whose
+// destination is "/") or of a bind mount source, along with the reason for
+// it (if any).
+type UidShiftDecision struct {
+	Source      string         `json:"source"`
+	Destination string         `json:"destination"`
+	Method      UidShiftMethod `json:"method"`
+	Reason      string         `json:"reason,omitempty"`
+}
+
 // ShiftfsMount describes a shiftfs mount point
 type ShiftfsMount struct {
 	Source   string
 	Readonly bool
 }
 
+// sysbox-runc: PidsSplit describes how the container's pids budget is split
+// between the sys container's init (service) tree and the subtree where the
+// inner container runtime (e.g., Docker) places its containers.
+type PidsSplit struct {
+	// Max number of pids for the init tree (cgroup v2 only); 0 means no limit
+	// other than the container's.
+	InitLimit int64 `json:"init_limit,omitempty"`
+
+	// Max number of pids for the inner-runtime subtree.
+	InnerLimit int64 `json:"inner_limit"`
+
+	// Path of the inner-runtime subtree, relative to the sys container's cgroup root.
+	InnerPath string `json:"inner_path"`
+}
+
+// sysbox-runc: KernelModules describes a restricted view of the host's kernel
+// modules (i.e., of /proc/modules and /sys/module) in the container.
+type KernelModules struct {
+	// Modules shown in the container (if loaded in the host); empty for an
+	// empty view.
+	Allowed []string `json:"allowed,omitempty"`
+}
+
+// sysbox-runc: HostConfigs describes host config files and dirs (e.g., of
+// Kerberos or SSSD) mounted read-only in the container, from copies that are
+// owned by the container's root user and refreshed on request.
+type HostConfigs struct {
+	// Host paths, mounted at the same paths in the container.
+	Paths []string `json:"paths,omitempty"`
+}
+
+// sysbox-runc: SSHKeys describes the SSH keys copied from the host into the
+// container's rootfs before it starts, owned by the container users they're
+// for.
+type SSHKeys struct {
+	// Host dir the keys are copied from (the SSH key store); the paths below
+	// are relative to it, and the keys are copied without following symlinks
+	// (so they can't be outside of it).
+	StoreDir string `json:"store_dir"`
+
+	// Dir holding the SSH host keys (ssh_host_*_key and their .pub files),
+	// copied to the container's /etc/ssh; "" if none.
+	HostKeysDir string `json:"host_keys_dir,omitempty"`
+
+	// Files copied to the authorized_keys of container users.
+	AuthorizedKeys []AuthorizedKeys `json:"authorized_keys,omitempty"`
+}
+
+// sysbox-runc: AuthorizedKeys is a file of the SSH key store copied to the
+// ~/.ssh/authorized_keys file of a container user.
+type AuthorizedKeys struct {
+	Path string `json:"path"`
+	User string `json:"user"`
+}
+
+// sysbox-runc: Scheduler is the scheduling policy and attributes of the
+// container's processes (see sched_setattr(2)), as in the process.scheduler
+// field of the OCI runtime spec (v1.2).
+type Scheduler struct {
+	// SCHED_OTHER, SCHED_FIFO, SCHED_RR, SCHED_BATCH, SCHED_IDLE or
+	// SCHED_DEADLINE
+	Policy string `json:"policy"`
+
+	// nice value, for SCHED_OTHER and SCHED_BATCH
+	Nice int32 `json:"nice,omitempty"`
+
+	// static priority, for SCHED_FIFO and SCHED_RR
+	Priority int32 `json:"priority,omitempty"`
+
+	// SCHED_FLAG_* flags
+	Flags []string `json:"flags,omitempty"`
+
+	// for SCHED_DEADLINE (in nanoseconds)
+	Runtime  uint64 `json:"runtime,omitempty"`
+	Deadline uint64 `json:"deadline,omitempty"`
+	Period   uint64 `json:"period,omitempty"`
+}
+
+// sysbox-runc: IOPriority is the I/O scheduling class and priority of the
+// container's processes (see ioprio_set(2)), as in the process.ioPriority
+// field of the OCI runtime spec (v1.2).
+type IOPriority struct {
+	// IOPRIO_CLASS_RT, IOPRIO_CLASS_BE or IOPRIO_CLASS_IDLE
+	Class string `json:"class"`
+
+	// 0 (highest) to 7 (lowest)
+	Priority int `json:"priority"`
+}
+
+// sysbox-runc: CoreDump describes the capture of the core dumps of the
+// container's processes into a host dir, by the sysbox-runc core dump handler
+// (see the "coredump" command).
+type CoreDump struct {
+	// Host dir where the core dumps are stored; it's mounted in the container.
+	Dir string `json:"dir"`
+
+	// Core dump file name pattern, as shown in the container's
+	// /proc/sys/kernel/core_pattern (relative to the dir's mountpoint).
+	Pattern string `json:"pattern"`
+
+	// Max size of a core dump (in bytes); larger ones are discarded. 0 means
+	// no limit.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// Max total size of the core dumps in the dir (in bytes); 0 means no limit.
+	MaxTotal int64 `json:"max_total,omitempty"`
+}
+
+// sysbox-runc: FsNotifyQuota limits the inotify and fanotify resources of
+// the container's processes, which sysbox-fs accounts for and enforces per
+// container (in addition to the host's per-user limits, which all containers
+// share). A zero value means no per-container limit.
+type FsNotifyQuota struct {
+	InotifyWatches   uint64 `json:"inotify_watches,omitempty"`
+	InotifyInstances uint64 `json:"inotify_instances,omitempty"`
+	FanotifyMarks    uint64 `json:"fanotify_marks,omitempty"`
+	FanotifyGroups   uint64 `json:"fanotify_groups,omitempty"`
+}
+
 // TODO Windows. Many of these fields should be factored out into those parts
 // which are common across platforms, and those which are platform specific.
 
@@ -222,9 +363,74 @@ type Config struct {
 	// UidShiftRootfs indicates if uid shifting is needed for the container's rootfs
 	UidShiftRootfs bool `json:"uid_shift_rootfs,omitempty"`
 
+	// UidShiftRootfsLazy indicates that, when uid shifting of the container's
+	// rootfs is done by chown'ing its files (i.e., without shiftfs), most of the
+	// rootfs is shifted in the background while the container runs.
+	UidShiftRootfsLazy bool `json:"uid_shift_rootfs_lazy,omitempty"`
+
+	// UidShiftRootfsChown indicates that uid shifting of the container's rootfs
+	// is done by chown'ing its files, even though shiftfs is supported (and
+	// used for the bind mounts).
+	UidShiftRootfsChown bool `json:"uid_shift_rootfs_chown,omitempty"`
+
+	// UidShiftNoIdmap indicates that ID-mapped mounts aren't used for uid
+	// shifting of the container's bind mounts.
+	UidShiftNoIdmap bool `json:"uid_shift_no_idmap,omitempty"`
+
+	// UidShiftFuseHelper is the FUSE program (bindfs) that uid-shifts the
+	// container's bind mounts whose filesystem doesn't support ID-mapped
+	// mounts (e.g., NFS); empty to leave them unshifted.
+	UidShiftFuseHelper string `json:"uid_shift_fuse_helper,omitempty"`
+
+	// SeccompKeeper indicates that the seccomp notification fds of the
+	// container's processes are held by a helper process, so that they can be
+	// re-sent to sysbox-fs if it restarts.
+	SeccompKeeper bool `json:"seccomp_keeper,omitempty"`
+
+	// Template indicates that the container is a warm-start template: its
+	// process is executed once an identity is stamped on it (see
+	// libcontainer.Container.Activate()), rather than by a plain start.
+	Template bool `json:"template,omitempty"`
+
+	// RootfsClone is the dir holding the overlayfs clone of the container's
+	// rootfs (see the rootfsclone package), if the rootfs is a clone.
+	RootfsClone string `json:"rootfs_clone,omitempty"`
+
+	// CoreDump configures the capture of the container's core dumps; nil if
+	// disabled.
+	CoreDump *CoreDump `json:"core_dump,omitempty"`
+
+	// KernelModules restricts the container's view of the host's kernel
+	// modules; nil if not restricted.
+	KernelModules *KernelModules `json:"kernel_modules,omitempty"`
+
+	// HostConfigs are host configs mounted in the container; nil if none.
+	HostConfigs *HostConfigs `json:"host_configs,omitempty"`
+
+	// SSHKeys are the SSH keys copied into the container; nil if none.
+	SSHKeys *SSHKeys `json:"ssh_keys,omitempty"`
+
+	// Scheduler and IOPriority are the scheduling attributes of the
+	// container's processes; nil to inherit them from sysbox-runc.
+	Scheduler  *Scheduler  `json:"scheduler,omitempty"`
+	IOPriority *IOPriority `json:"io_priority,omitempty"`
+
+	// SysboxFsSysctls are the non-namespaced sysctls whose values, as seen by
+	// the container, are set by sysbox-fs; nil if none.
+	SysboxFsSysctls map[string]string `json:"sysbox_fs_sysctls,omitempty"`
+
+	// FsNotifyQuota limits the container's inotify and fanotify resources;
+	// nil if not limited.
+	FsNotifyQuota *FsNotifyQuota `json:"fs_notify_quota,omitempty"`
+
 	// ShiftfsMounts is a list of directories on which shiftfs needs to be mounted
 	ShiftfsMounts []ShiftfsMount `json:"shiftfs_mounts,omitempty"`
 
+	// UidShiftReport records how the ownership of the container's rootfs and
+	// bind mount sources is shifted to its user-ID range; it's set up when the
+	// container's init process is started.
+	UidShiftReport []UidShiftDecision `json:"uid_shift_report,omitempty"`
+
 	// SwitchDockerDns indicates if the containers should change the IP address
 	// of Docker DNS hosts with localhost addresses.
 	SwitchDockerDns bool `json:"switch_docker_dns,omitempty"`
@@ -232,6 +438,16 @@ type Config struct {
 	// FsState slice is utilized to host file-system state (e.g. dir, file, softlinks,
 	// etc) to be created in container's rootfs during initialization.
 	FsState []FsEntry `json:"fs_state,omitempty"`
+
+	// PidsSplit, if set, splits the container's pids budget between the init
+	// tree and the inner-runtime subtree via nested cgroups.
+	PidsSplit *PidsSplit `json:"pids_split,omitempty"`
+
+	// PidsReserve is the number of pids (out of the container's pids limit)
+	// reserved for sysbox-runc's helper processes (e.g., those that set up
+	// processes exec'd into the container); the sys container's cgroup root is
+	// limited to the remaining pids (cgroup v1 only).
+	PidsReserve int64 `json:"pids_reserve,omitempty"`
 }
 
 type HookName string
@@ -254,6 +470,8 @@ const (
 	// CreateContainer commands MUST be called as part of the create operation after
 	// the runtime environment has been created but before the pivot_root has been executed.
 	// CreateContainer commands are called in the Container namespace.
+	// sysbox-runc: they're also called in the container's child cgroup (see
+	// cgroups.Manager.CreateChildCgroup()), as the container's processes.
 	CreateContainer = "createContainer"
 
 	// StartContainer commands MUST be called as part of the start operation and before
@@ -268,6 +486,12 @@ const (
 	// Poststop commands are executed after the container init process exits.
 	// Poststop commands are called in the Runtime Namespace.
 	Poststop = "poststop"
+
+	// sysbox-runc: SysboxPoststop commands are executed when the container is
+	// destroyed, after it's unregistered from sysbox-fs and sysbox-mgr (i.e.,
+	// once the resources they hold for it are released).
+	// SysboxPoststop commands are called in the Runtime Namespace.
+	SysboxPoststop = "sysboxPoststop"
 )
 
 type Capabilities struct {
@@ -293,6 +517,24 @@ func (hooks HookList) RunHooks(state *specs.State) error {
 	return nil
 }
 
+// sysbox-runc: RunSysboxHooks is like RunHooks, but the hooks that implement
+// SysboxHook also get the given sysbox state of the container.
+func (hooks HookList) RunSysboxHooks(state *specs.State, sysbox *SysboxState) error {
+	for i, h := range hooks {
+		var err error
+		if sh, ok := h.(SysboxHook); ok {
+			err = sh.RunWithSysboxState(state, sysbox)
+		} else {
+			err = h.Run(state)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Running hook #%d:", i)
+		}
+	}
+
+	return nil
+}
+
 func (hooks *Hooks) UnmarshalJSON(b []byte) error {
 	var state map[HookName][]CommandHook
 
@@ -329,14 +571,21 @@ func (hooks *Hooks) MarshalJSON() ([]byte, error) {
 		return serializableHooks
 	}
 
-	return json.Marshal(map[string]interface{}{
+	m := map[string]interface{}{
 		"prestart":        serialize((*hooks)[Prestart]),
 		"createRuntime":   serialize((*hooks)[CreateRuntime]),
 		"createContainer": serialize((*hooks)[CreateContainer]),
 		"startContainer":  serialize((*hooks)[StartContainer]),
 		"poststart":       serialize((*hooks)[Poststart]),
 		"poststop":        serialize((*hooks)[Poststop]),
-	})
+	}
+
+	// sysbox-runc: not an OCI hook; omitted when unused
+	if len((*hooks)[SysboxPoststop]) > 0 {
+		m[SysboxPoststop] = serialize((*hooks)[SysboxPoststop])
+	}
+
+	return json.Marshal(m)
 }
 
 type Hook interface {
@@ -344,6 +593,47 @@ type Hook interface {
 	Run(*specs.State) error
 }
 
+// sysbox-runc: SysboxHook is implemented by hooks that can take the sysbox
+// state of the container along with its OCI state (see RunSysboxHooks()).
+type SysboxHook interface {
+	// RunWithSysboxState executes the hook with the provided states.
+	RunWithSysboxState(*specs.State, *SysboxState) error
+}
+
+// sysbox-runc: SysboxState is the sysbox-specific state of a container, for
+// hooks that act on what sysbox set up for it (e.g., cleanup hooks that must
+// know how its rootfs was uid-shifted, or which subids it was allocated).
+type SysboxState struct {
+	// UidMappings and GidMappings are the container's user-ns ID mappings
+	// (i.e., its allocated subids).
+	UidMappings []IDMap `json:"uid_mappings,omitempty"`
+	GidMappings []IDMap `json:"gid_mappings,omitempty"`
+
+	// UidShift records how the container's rootfs and bind mounts were
+	// uid-shifted (see Config.UidShiftReport).
+	UidShift []UidShiftDecision `json:"uid_shift,omitempty"`
+
+	// IdmapDir is the host dir where the container's ID-mapped mounts are
+	// staged.
+	IdmapDir string `json:"idmap_dir,omitempty"`
+
+	// ShiftfsMounts are the dirs on which shiftfs was mounted for the
+	// container.
+	ShiftfsMounts []ShiftfsMount `json:"shiftfs_mounts,omitempty"`
+
+	// RootfsClone is the dir holding the overlayfs clone of the container's
+	// rootfs, if any.
+	RootfsClone string `json:"rootfs_clone,omitempty"`
+}
+
+// sysbox-runc: HookState is the state passed to the stdin of command hooks
+// run with the container's sysbox state: the OCI state, extended with a
+// "sysbox" object.
+type HookState struct {
+	*specs.State
+	Sysbox *SysboxState `json:"sysbox,omitempty"`
+}
+
 // NewFunctionHook will call the provided function when the hook is run.
 func NewFunctionHook(f func(*specs.State) error) FuncHook {
 	return FuncHook{
@@ -379,7 +669,17 @@ type CommandHook struct {
 }
 
 func (c Command) Run(s *specs.State) error {
-	b, err := json.Marshal(s)
+	return c.run(s)
+}
+
+// sysbox-runc: RunWithSysboxState runs the command with the given states (see
+// HookState) in its stdin.
+func (c Command) RunWithSysboxState(s *specs.State, sysbox *SysboxState) error {
+	return c.run(&HookState{State: s, Sysbox: sysbox})
+}
+
+func (c Command) run(state interface{}) error {
+	b, err := json.Marshal(state)
 	if err != nil {
 		return err
 	}
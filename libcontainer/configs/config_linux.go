@@ -1,6 +1,9 @@
 package configs
 
-import "fmt"
+import (
+	"fmt"
+	"unsafe"
+)
 
 // HostUID gets the translated uid for the process on host which could be
 // different when user namespaces are enabled.
@@ -59,3 +62,121 @@ func (c Config) hostIDFromMapping(containerID int, uMap []IDMap) (int, bool) {
 	}
 	return -1, false
 }
+
+// sysbox-runc: SchedAttr is the struct sched_attr of sched_setattr(2).
+type SchedAttr struct {
+	Size     uint32
+	Policy   uint32
+	Flags    uint64
+	Nice     int32
+	Priority uint32
+	Runtime  uint64
+	Deadline uint64
+	Period   uint64
+	UtilMin  uint32
+	UtilMax  uint32
+}
+
+var schedPolicies = map[string]uint32{
+	"SCHED_OTHER":    0,
+	"SCHED_FIFO":     1,
+	"SCHED_RR":       2,
+	"SCHED_BATCH":    3,
+	"SCHED_IDLE":     5,
+	"SCHED_DEADLINE": 6,
+}
+
+var schedFlags = map[string]uint64{
+	"SCHED_FLAG_RESET_ON_FORK":  0x01,
+	"SCHED_FLAG_RECLAIM":        0x02,
+	"SCHED_FLAG_DL_OVERRUN":     0x04,
+	"SCHED_FLAG_KEEP_POLICY":    0x08,
+	"SCHED_FLAG_KEEP_PARAMS":    0x10,
+	"SCHED_FLAG_UTIL_CLAMP_MIN": 0x20,
+	"SCHED_FLAG_UTIL_CLAMP_MAX": 0x40,
+}
+
+// sysbox-runc: ToSchedAttr converts the given scheduler to a sched_attr,
+// checking its attributes along the way.
+func ToSchedAttr(s *Scheduler) (*SchedAttr, error) {
+	policy, ok := schedPolicies[s.Policy]
+	if !ok {
+		return nil, fmt.Errorf("invalid scheduler policy %q", s.Policy)
+	}
+
+	var flags uint64
+	for _, f := range s.Flags {
+		flag, ok := schedFlags[f]
+		if !ok {
+			return nil, fmt.Errorf("invalid scheduler flag %q", f)
+		}
+		flags |= flag
+	}
+
+	if s.Nice < -20 || s.Nice > 19 {
+		return nil, fmt.Errorf("invalid scheduler nice value %d (must be within [-20, 19])", s.Nice)
+	}
+
+	switch s.Policy {
+	case "SCHED_FIFO", "SCHED_RR":
+		if s.Priority < 1 || s.Priority > 99 {
+			return nil, fmt.Errorf("invalid scheduler priority %d for %s (must be within [1, 99])", s.Priority, s.Policy)
+		}
+	default:
+		if s.Priority != 0 {
+			return nil, fmt.Errorf("scheduler priority can only be set for SCHED_FIFO and SCHED_RR")
+		}
+	}
+
+	if s.Policy == "SCHED_DEADLINE" {
+		if s.Runtime == 0 || s.Deadline == 0 {
+			return nil, fmt.Errorf("SCHED_DEADLINE requires the runtime and deadline attributes")
+		}
+		period := s.Period
+		if period == 0 {
+			period = s.Deadline
+		}
+		if s.Runtime > s.Deadline || s.Deadline > period {
+			return nil, fmt.Errorf("SCHED_DEADLINE requires runtime <= deadline <= period")
+		}
+	} else if s.Runtime != 0 || s.Deadline != 0 || s.Period != 0 {
+		return nil, fmt.Errorf("scheduler runtime, deadline and period can only be set for SCHED_DEADLINE")
+	}
+
+	attr := &SchedAttr{
+		Policy:   policy,
+		Flags:    flags,
+		Nice:     s.Nice,
+		Priority: uint32(s.Priority),
+		Runtime:  s.Runtime,
+		Deadline: s.Deadline,
+		Period:   s.Period,
+	}
+	attr.Size = uint32(unsafe.Sizeof(*attr))
+	return attr, nil
+}
+
+var ioprioClasses = map[string]int{
+	"IOPRIO_CLASS_RT":   1,
+	"IOPRIO_CLASS_BE":   2,
+	"IOPRIO_CLASS_IDLE": 3,
+}
+
+// sysbox-runc: ToIOPrio converts the given I/O priority to its ioprio_set(2)
+// value, checking it along the way.
+func ToIOPrio(p *IOPriority) (int, error) {
+	class, ok := ioprioClasses[p.Class]
+	if !ok {
+		return 0, fmt.Errorf("invalid I/O priority class %q", p.Class)
+	}
+	if p.Priority < 0 || p.Priority > 7 {
+		return 0, fmt.Errorf("invalid I/O priority %d (must be within [0, 7])", p.Priority)
+	}
+	return class<<13 | p.Priority, nil
+}
+
+// sysbox-runc: ShiftfsRootfs reports whether uid shifting of the container's
+// rootfs is done via shiftfs.
+func (c Config) ShiftfsRootfs() bool {
+	return c.UidShiftSupported && c.UidShiftRootfs && !c.UidShiftRootfsChown
+}
@@ -81,3 +81,45 @@ func TestHostRootGIDWithUSERNS(t *testing.T) {
 		t.Fatalf("expected gid 1000 with no USERNS but received %d", uid)
 	}
 }
+
+func TestToSchedAttr(t *testing.T) {
+	attr, err := ToSchedAttr(&Scheduler{
+		Policy:   "SCHED_DEADLINE",
+		Flags:    []string{"SCHED_FLAG_RESET_ON_FORK"},
+		Runtime:  1000,
+		Deadline: 2000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attr.Policy != 6 || attr.Flags != 0x01 || attr.Size != 56 {
+		t.Fatalf("unexpected sched attr %+v", attr)
+	}
+
+	invalid := []*Scheduler{
+		{Policy: "SCHED_RR", Priority: 100},
+		{Policy: "SCHED_OTHER", Priority: 1},
+		{Policy: "SCHED_OTHER", Nice: 20},
+		{Policy: "SCHED_OTHER", Flags: []string{"SCHED_FLAG_FOO"}},
+		{Policy: "SCHED_DEADLINE", Runtime: 3000, Deadline: 2000},
+		{Policy: "SCHED_BATCH", Runtime: 1000},
+	}
+	for _, s := range invalid {
+		if _, err := ToSchedAttr(s); err == nil {
+			t.Errorf("expected error for scheduler %+v", s)
+		}
+	}
+}
+
+func TestToIOPrio(t *testing.T) {
+	prio, err := ToIOPrio(&IOPriority{Class: "IOPRIO_CLASS_RT", Priority: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prio != 1<<13|4 {
+		t.Fatalf("expected ioprio %#x, got %#x", 1<<13|4, prio)
+	}
+	if _, err := ToIOPrio(&IOPriority{Class: "IOPRIO_CLASS_NONE"}); err == nil {
+		t.Fatal("expected error for an invalid I/O priority class")
+	}
+}
@@ -235,3 +235,87 @@ func TestCommandHookRunTimeout(t *testing.T) {
 		t.Error("Expected error to occur but it was nil")
 	}
 }
+
+func TestMarshalUnmarshalSysboxPoststopHooks(t *testing.T) {
+	hookCmd := configs.NewCommandHook(configs.Command{
+		Path: "/usr/bin/inventory",
+		Args: []string{"inventory", "remove"},
+	})
+
+	hook := configs.Hooks{
+		configs.Poststop:       configs.HookList{hookCmd},
+		configs.SysboxPoststop: configs.HookList{hookCmd},
+	}
+	hooks, err := hook.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	umMhook := configs.Hooks{}
+	if err := umMhook.UnmarshalJSON(hooks); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(umMhook, hook) {
+		t.Errorf("Expected hooks to be equal after mashaling -> unmarshaling them: %+v, %+v", umMhook, hook)
+	}
+}
+
+func TestRunSysboxHooks(t *testing.T) {
+	state := &specs.State{
+		Version: "1",
+		ID:      "1",
+		Status:  "stopped",
+		Bundle:  "/bundle",
+	}
+	sysbox := &configs.SysboxState{
+		UidMappings: []configs.IDMap{{ContainerID: 0, HostID: 165536, Size: 65536}},
+		GidMappings: []configs.IDMap{{ContainerID: 0, HostID: 165536, Size: 65536}},
+		UidShift: []configs.UidShiftDecision{
+			{Source: "/rootfs", Destination: "/", Method: configs.UidShiftIdmap},
+		},
+		IdmapDir: "/run/sysbox-runc/1/idmap",
+	}
+
+	dir, err := ioutil.TempDir("", "hooktest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := dir + "/state.json"
+	cmdHook := configs.NewCommandHook(configs.Command{
+		Path: "/bin/sh",
+		Args: []string{"/bin/sh", "-c", "cat > " + output},
+	})
+	var funcState *specs.State
+	fHook := configs.NewFunctionHook(func(s *specs.State) error {
+		funcState = s
+		return nil
+	})
+
+	if err := (configs.HookList{cmdHook, fHook}).RunSysboxHooks(state, sysbox); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		specs.State
+		Sysbox *configs.SysboxState `json:"sysbox"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got.State, state) {
+		t.Errorf("Expected state %+v to equal %+v", got.State, state)
+	}
+	if !reflect.DeepEqual(got.Sysbox, sysbox) {
+		t.Errorf("Expected sysbox state %+v to equal %+v", got.Sysbox, sysbox)
+	}
+
+	if !reflect.DeepEqual(funcState, state) {
+		t.Errorf("Expected state %+v to equal %+v", funcState, state)
+	}
+}
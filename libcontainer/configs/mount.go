@@ -39,4 +39,15 @@ type Mount struct {
 
 	// Bind mount source info
 	BindSrcInfo BindSrcInfo `json:"bind_src_info,omitempty"`
+
+	// sysbox-runc: RecAttr are the mount attributes set and cleared
+	// recursively on the mount (e.g., via the "rro" option); nil if none.
+	RecAttr *MountAttr `json:"rec_attr,omitempty"`
+}
+
+// sysbox-runc: MountAttr are mount attributes (MOUNT_ATTR_* flags, see
+// mount_setattr(2)) to set and clear.
+type MountAttr struct {
+	Set uint64 `json:"set,omitempty"`
+	Clr uint64 `json:"clr,omitempty"`
 }
@@ -48,6 +48,20 @@ type Network struct {
 	// Note: This is unsupported on some systems.
 	// Note: This does not apply to loopback interfaces.
 	HairpinMode bool `json:"hairpin_mode"`
+
+	// sysbox-runc: Parent is the host interface a macvlan or ipvlan interface
+	// is attached to.
+	Parent string `json:"parent,omitempty"`
+
+	// sysbox-runc: Mode is the mode of a macvlan interface ("bridge" (default),
+	// "vepa", "private" or "passthru") or of an ipvlan one ("l2" (default), "l3"
+	// or "l3s").
+	Mode string `json:"mode,omitempty"`
+
+	// sysbox-runc: Routes are static routes via the interface, set up along
+	// with the default ones given by Gateway and IPv6Gateway (their
+	// InterfaceName is ignored); the route gateways are optional.
+	Routes []*Route `json:"routes,omitempty"`
 }
 
 // Routes can be specified to create entries in the route table as the container is started
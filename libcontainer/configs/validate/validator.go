@@ -10,6 +10,7 @@ import (
 
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/intelrdt"
+	"github.com/opencontainers/runc/libsysbox/mountapi"
 	selinux "github.com/opencontainers/selinux/go-selinux"
 	"golang.org/x/sys/unix"
 )
@@ -50,6 +51,12 @@ func (v *ConfigValidator) Validate(config *configs.Config) error {
 	if err := v.intelrdt(config); err != nil {
 		return err
 	}
+	if err := v.mountOptions(config); err != nil {
+		return err
+	}
+	if err := v.scheduling(config); err != nil {
+		return err
+	}
 	if config.RootlessEUID {
 		if err := v.rootlessEUID(config); err != nil {
 			return err
@@ -131,6 +138,50 @@ func (v *ConfigValidator) cgroupnamespace(config *configs.Config) error {
 	return nil
 }
 
+// sysbox-runc: mountOptions validates that the kernel supports the mount
+// options that older kernels silently ignore (nosymfollow) or that need the
+// new mount API (the recursive ones, e.g., "rro").
+func (v *ConfigValidator) mountOptions(config *configs.Config) error {
+	for _, m := range config.Mounts {
+		if m.Flags&mountapi.MsNoSymFollow != 0 && !mountapi.NoSymFollowSupported() {
+			return fmt.Errorf("mount option nosymfollow on %s is not supported by the kernel (needs Linux 5.10+)", m.Destination)
+		}
+
+		if m.RecAttr == nil {
+			continue
+		}
+		if m.Flags&unix.MS_REMOUNT != 0 {
+			return fmt.Errorf("recursive mount options on %s can't be combined with remount", m.Destination)
+		}
+		if !mountapi.AttrSupported(0) {
+			return fmt.Errorf("recursive mount options on %s are not supported by the kernel (need Linux 5.12+)", m.Destination)
+		}
+		// the access time modes are supported along with mount_setattr(2)
+		attrs := (m.RecAttr.Set | m.RecAttr.Clr) &^ mountapi.AttrAtime
+		if !mountapi.AttrSupported(attrs) {
+			return fmt.Errorf("recursive mount options on %s (attributes %#x) are not supported by the kernel",
+				m.Destination, attrs)
+		}
+	}
+	return nil
+}
+
+// sysbox-runc: scheduling validates the scheduler and I/O priority attributes
+// of the container's processes.
+func (v *ConfigValidator) scheduling(config *configs.Config) error {
+	if config.Scheduler != nil {
+		if _, err := configs.ToSchedAttr(config.Scheduler); err != nil {
+			return err
+		}
+	}
+	if config.IOPriority != nil {
+		if _, err := configs.ToIOPrio(config.IOPriority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // sysctl validates that the specified sysctl keys are valid or not.
 // /proc/sys isn't completely namespaced and depending on which namespaces
 // are specified, a subset of sysctls are permitted.
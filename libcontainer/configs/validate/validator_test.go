@@ -8,6 +8,7 @@ import (
 
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/configs/validate"
+	"github.com/opencontainers/runc/libsysbox/mountapi"
 	"golang.org/x/sys/unix"
 )
 
@@ -317,3 +318,54 @@ func TestValidateSysctlWithoutNETNamespace(t *testing.T) {
 		t.Error("Expected error to occur but it was nil")
 	}
 }
+
+func TestValidateRecursiveMountOptionsWithRemount(t *testing.T) {
+	config := &configs.Config{
+		Rootfs: "/var",
+		Mounts: []*configs.Mount{
+			{
+				Source:      "tmpfs",
+				Destination: "/tmp",
+				Device:      "tmpfs",
+				Flags:       unix.MS_REMOUNT,
+				RecAttr:     &configs.MountAttr{Set: mountapi.AttrRdonly},
+			},
+		},
+	}
+
+	validator := validate.New()
+	if err := validator.Validate(config); err == nil {
+		t.Error("Expected error to occur but it was nil")
+	}
+}
+
+func TestValidateScheduling(t *testing.T) {
+	tests := []struct {
+		sched  *configs.Scheduler
+		ioprio *configs.IOPriority
+		valid  bool
+	}{
+		{&configs.Scheduler{Policy: "SCHED_OTHER", Nice: -5}, nil, true},
+		{&configs.Scheduler{Policy: "SCHED_FIFO", Priority: 10}, nil, true},
+		{&configs.Scheduler{Policy: "SCHED_FIFO"}, nil, false},
+		{&configs.Scheduler{Policy: "SCHED_FOO"}, nil, false},
+		{nil, &configs.IOPriority{Class: "IOPRIO_CLASS_BE", Priority: 2}, true},
+		{nil, &configs.IOPriority{Class: "IOPRIO_CLASS_BE", Priority: 8}, false},
+	}
+
+	validator := validate.New()
+	for _, test := range tests {
+		config := &configs.Config{
+			Rootfs:     "/var",
+			Scheduler:  test.sched,
+			IOPriority: test.ioprio,
+		}
+		err := validator.Validate(config)
+		if test.valid && err != nil {
+			t.Errorf("Expected no error for %+v/%+v, got %v", test.sched, test.ioprio, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Expected error for %+v/%+v, got nil", test.sched, test.ioprio)
+		}
+	}
+}
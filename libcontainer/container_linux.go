@@ -23,12 +23,16 @@ import (
 	securejoin "github.com/cyphar/filepath-securejoin"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/cgroups/fs2"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/intelrdt"
 	"github.com/opencontainers/runc/libcontainer/logs"
 	"github.com/opencontainers/runc/libcontainer/mount"
+	"github.com/opencontainers/runc/libcontainer/seccomp"
 	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/opencontainers/runc/libsysbox/extension"
+	"github.com/opencontainers/runc/libsysbox/rootfsclone"
 	"github.com/opencontainers/runc/libsysbox/shiftfs"
 	"github.com/opencontainers/runc/libsysbox/sysbox"
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -66,6 +70,8 @@ type linuxContainer struct {
 	created              time.Time
 	sysFs                *sysbox.Fs
 	sysMgr               *sysbox.Mgr
+	extensions           *extension.Set
+	stateIndex           bool
 }
 
 // State represents a running container's state
@@ -91,6 +97,10 @@ type State struct {
 	NamespacePaths map[configs.NamespaceType]string `json:"namespace_paths"`
 
 	// Container's standard descriptors (std{in,out,err}), needed for checkpoint and restore
+	//
+	// sysbox-runc: followed by the container's preserved fds (see
+	// Process.ExtraFiles), indexed by fd number; descriptors that could not be
+	// looked up are empty.
 	ExternalDescriptors []string `json:"external_descriptors,omitempty"`
 
 	// Intel RDT "resource control" filesystem path
@@ -101,6 +111,10 @@ type State struct {
 
 	// SysMgr contains info about resources obtained from sysbox-mgr
 	SysMgr sysbox.Mgr `json:"sys_mgr,omitempty"`
+
+	// sysbox-runc: BootID is the ID of the boot the container was created in
+	// (see bootID()).
+	BootID string `json:"boot_id,omitempty"`
 }
 
 // Container is a libcontainer container object.
@@ -157,6 +171,87 @@ type Container interface {
 	// errors:
 	// Systemerror - System error.
 	NotifyMemoryPressure(level PressureLevel) (<-chan struct{}, error)
+
+	// sysbox-runc: Unmount removes the mount at the given destination from the
+	// container's mount namespace and drops it from the container's config. The
+	// flags are passed to umount2(2) (e.g., MNT_DETACH, MNT_FORCE).
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ContainerNotRunning - Container not running or created,
+	// ConfigInvalid - The destination is not a removable container mount,
+	// Systemerror - System error.
+	Unmount(dest string, flags int) error
+
+	// sysbox-runc: SignalCgroup sends the given signal to all processes in the
+	// given cgroup (and its descendants) of the container. The cgroup path is
+	// relative to the container's cgroup root (as seen inside the container).
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ContainerNotRunning - Container not running or created,
+	// ConfigInvalid - The cgroup does not exist,
+	// Systemerror - System error.
+	SignalCgroup(s os.Signal, cgroup string) error
+
+	// sysbox-runc: InnerCgroups returns the cgroups created within the
+	// container (e.g., by its systemd or docker engine), as paths relative to
+	// the container's cgroup root (as seen inside the container), sorted.
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ContainerNotRunning - Container not running or created,
+	// Systemerror - System error.
+	InnerCgroups() ([]string, error)
+
+	// sysbox-runc: InnerCgroupStats returns the stats of the given inner
+	// cgroup of the container (see InnerCgroups()).
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ConfigInvalid - The cgroup does not exist,
+	// Systemerror - System error.
+	InnerCgroupStats(cgroup string) (*Stats, error)
+
+	// sysbox-runc: OOMKills returns the number of processes OOM killed in
+	// each cgroup of the container, by cgroup: "/" for the container's cgroup
+	// root, and the paths of its inner cgroups (see InnerCgroups()).
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ContainerNotRunning - Container not running or created,
+	// Systemerror - System error.
+	OOMKills() (map[string]uint64, error)
+
+	// sysbox-runc: ReconnectSysboxFs re-registers the container with
+	// sysbox-fs and re-sends it the seccomp notification fds of the
+	// container's processes, after sysbox-fs restarts.
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ContainerNotRunning - Container not running or created,
+	// Systemerror - System error.
+	ReconnectSysboxFs() error
+
+	// sysbox-runc: RefreshHostConfigs updates the copies of the host configs
+	// mounted in the container (see configs.HostConfigs) with the host's
+	// current ones.
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ContainerNotRunning - Container not running or created,
+	// Systemerror - System error.
+	RefreshHostConfigs() error
+
+	// sysbox-runc: Activate stamps the given identity on a template container
+	// (see configs.Config.Template) and executes its user defined process.
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ContainerNotRunning - Container not created,
+	// ConfigInvalid - The container is not a template, or the identity is invalid,
+	// Systemerror - System error.
+	Activate(id Identity) error
 }
 
 // ID returns the container's unique ID
@@ -213,6 +308,16 @@ func (c *linuxContainer) Stats() (*Stats, error) {
 	if stats.CgroupStats, err = c.cgroupManager.GetStats(); err != nil {
 		return stats, newSystemErrorWithCause(err, "getting container stats from cgroups")
 	}
+	// sysbox-runc: the container's processes are in its child cgroup (i.e.,
+	// its cgroup root, as seen inside it); get that one's pressure too
+	if cgroups.IsCgroup2UnifiedMode() {
+		child := c.cgroupManager.GetChildCgroupPaths()[""]
+		if child != "" && child != c.cgroupManager.Path("") {
+			if stats.ChildPressure, err = fs2.StatPressure(child); err != nil {
+				return stats, newSystemErrorWithCause(err, "getting the pressure of the container's child cgroup")
+			}
+		}
+	}
 	if c.intelRdtManager != nil {
 		if stats.IntelRdtStats, err = c.intelRdtManager.GetStats(); err != nil {
 			return stats, newSystemErrorWithCause(err, "getting container's Intel RDT stats")
@@ -241,12 +346,31 @@ func (c *linuxContainer) Set(config configs.Config) error {
 	if status == Stopped {
 		return newGenericError(errors.New("container not running"), ContainerNotRunning)
 	}
+	// sysbox-runc: update the container's inotify/fanotify quota in sysbox-fs
+	quotaChanged := !reflect.DeepEqual(config.FsNotifyQuota, c.config.FsNotifyQuota)
+	if quotaChanged {
+		if !c.sysFs.Enabled() {
+			return newGenericError(errors.New("can't set the inotify/fanotify quota: sysbox-fs is disabled"), ConfigInvalid)
+		}
+		if err := c.sysFs.SetNotifyQuota(config.FsNotifyQuota); err != nil {
+			return err
+		}
+	}
+	setBackQuota := func() {
+		if !quotaChanged {
+			return
+		}
+		if err := c.sysFs.SetNotifyQuota(c.config.FsNotifyQuota); err != nil {
+			logrus.Warnf("Setting back the inotify/fanotify quota failed due to error: %v, your state.json and actual configs might be inconsistent.", err)
+		}
+	}
 	if err := c.cgroupManager.Set(&config); err != nil {
 		logrus.Warnf("Setting cgroup configs failed due to error: %v", err)
 		// Set configs back
 		if err2 := c.cgroupManager.Set(c.config); err2 != nil {
 			logrus.Warnf("Setting back cgroup configs failed due to error: %v, your state.json and actual configs might be inconsistent.", err2)
 		}
+		setBackQuota()
 		return err
 	}
 	if c.intelRdtManager != nil {
@@ -258,9 +382,19 @@ func (c *linuxContainer) Set(config configs.Config) error {
 			if err2 := c.intelRdtManager.Set(c.config); err2 != nil {
 				logrus.Warnf("Setting back intelrdt configs failed due to error: %v, your state.json and actual configs might be inconsistent.", err2)
 			}
+			setBackQuota()
 			return err
 		}
 	}
+	// sysbox-runc: update the container's swap limits in sysbox-fs; they only
+	// affect what the container's /proc/swaps shows, so a failure isn't fatal
+	swapLimits := sysbox.NewFsSwapLimits(config.Cgroups.Resources)
+	if c.sysFs.Enabled() && c.sysFs.Reg &&
+		!reflect.DeepEqual(swapLimits, sysbox.NewFsSwapLimits(c.config.Cgroups.Resources)) {
+		if err := c.sysFs.SetSwapLimits(swapLimits); err != nil {
+			logrus.Warnf("Setting the swap limits in sysbox-fs failed due to error: %v", err)
+		}
+	}
 	// After config setting succeed, update config and states
 	c.config = &config
 	_, err = c.updateState(nil)
@@ -279,11 +413,37 @@ func (c *linuxContainer) Start(process *Process) error {
 		if err := c.createExecFifo(); err != nil {
 			return err
 		}
+		c.config.UidShiftReport = nil
+		c.reportRootfsUidShift()
+		if err := c.setupIdmappedMounts(); err != nil {
+			return err
+		}
 		if c.config.UidShiftSupported {
 			if err := c.setupShiftfsMarks(); err != nil {
 				return err
 			}
 		}
+		if c.config.UidShiftRootfs && !c.config.ShiftfsRootfs() {
+			if err := c.shiftRootfsOwnership(); err != nil {
+				return err
+			}
+		}
+		if err := c.completeUidShiftReport(); err != nil {
+			return err
+		}
+		// after the uid-shifting setup, which must skip its mount
+		if err := c.setupCoreDump(); err != nil {
+			return err
+		}
+		if err := c.setupKernelModules(); err != nil {
+			return err
+		}
+		if err := c.setupHostConfigs(); err != nil {
+			return err
+		}
+		if err := c.setupSSHKeys(); err != nil {
+			return err
+		}
 	}
 
 	if err := c.start(process); err != nil {
@@ -312,6 +472,11 @@ func (c *linuxContainer) Exec() error {
 }
 
 func (c *linuxContainer) exec() error {
+	// sysbox-runc: a template's process is executed once it's activated
+	if c.config.Template {
+		return newGenericError(fmt.Errorf("container is a template; activate it to start it"), ConfigInvalid)
+	}
+
 	path := filepath.Join(c.root, execFifoFilename)
 	pid := c.initProcess.pid()
 	blockingFifoOpenCh := awaitFifoOpen(path)
@@ -419,7 +584,7 @@ func (c *linuxContainer) start(process *Process) error {
 				return err
 			}
 
-			if err := c.config.Hooks[configs.Poststart].RunHooks(s); err != nil {
+			if err := c.config.Hooks[configs.Poststart].RunSysboxHooks(s, c.sysboxHookState()); err != nil {
 				if err := ignoreTerminateErrors(parent.terminate()); err != nil {
 					logrus.Warn(errorsf.Wrapf(err, "Running Poststart hook"))
 				}
@@ -484,6 +649,23 @@ func (c *linuxContainer) Signal(s os.Signal, all bool) error {
 	return newGenericError(errors.New("container not running"), ContainerNotRunning)
 }
 
+func (c *linuxContainer) SignalCgroup(s os.Signal, cgroup string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status != Running && status != Created && status != Paused {
+		return newGenericError(errors.New("container not running"), ContainerNotRunning)
+	}
+	paths, err := subCgroupPaths(c.cgroupManager.GetChildCgroupPaths(), map[string]string{"": cgroup})
+	if err != nil {
+		return newGenericError(err, ConfigInvalid)
+	}
+	return signalCgroupProcesses(c.cgroupManager, paths, s)
+}
+
 func (c *linuxContainer) createExecFifo() error {
 	rootuid, err := c.Config().HostRootUID()
 	if err != nil {
@@ -586,6 +768,7 @@ func (c *linuxContainer) commandTemplate(p *Process, childInitPipe *os.File, chi
 	cmd.Env = append(cmd.Env,
 		"_LIBCONTAINER_LOGPIPE="+strconv.Itoa(stdioFdCount+len(cmd.ExtraFiles)-1),
 		"_LIBCONTAINER_LOGLEVEL="+p.LogLevel,
+		"_LIBCONTAINER_CONTAINERID="+c.id,
 	)
 
 	// NOTE: when running a container with no PID namespace and the parent process spawning the container is
@@ -639,11 +822,15 @@ func (c *linuxContainer) newSetnsProcess(p *Process, cmd *exec.Cmd, messageSockP
 		return nil, err
 	}
 	// sysbox-runc: setns processes enter the child cgroup (i.e., the system
-	// container's cgroup root); this way they can't change the cgroup resources
-	// assigned to the system container itself.
-	return &setnsProcess{
+	// container's cgroup root) or a cgroup within it; this way they can't
+	// change the cgroup resources assigned to the system container itself.
+	cgroupPaths, err := c.setnsCgroupPaths(p, state.InitProcessPid)
+	if err != nil {
+		return nil, err
+	}
+	proc := &setnsProcess{
 		cmd:             cmd,
-		cgroupPaths:     c.cgroupManager.GetChildCgroupPaths(),
+		cgroupPaths:     cgroupPaths,
 		rootlessCgroups: c.config.RootlessCgroups,
 		intelRdtPath:    state.IntelRdtPath,
 		messageSockPair: messageSockPair,
@@ -653,7 +840,98 @@ func (c *linuxContainer) newSetnsProcess(p *Process, cmd *exec.Cmd, messageSockP
 		bootstrapData:   data,
 		initProcessPid:  state.InitProcessPid,
 		container:       c,
-	}, nil
+	}
+	// sysbox-runc: if pids are reserved for our helper processes, the setns
+	// process is set up within the reserve and enters the child cgroup right
+	// before exec'ing.
+	if c.config.PidsReserve > 0 && !cgroups.IsCgroup2UnifiedMode() {
+		proc.reserveCgroupPaths = c.cgroupManager.GetPaths()
+		proc.config.EnterCgroupLate = true
+	}
+	return proc, nil
+}
+
+// sysbox-runc: setnsCgroupPaths returns the cgroups the given setns process is
+// placed in: those given by the process (see Process.SubCgroupPaths) or, if
+// none, those of the container's init process (e.g., the ones the container's
+// systemd placed it in), so that the process is subject to the same resource
+// controls as the container's workloads. Cgroups outside of the system
+// container's cgroup root are never joined.
+func (c *linuxContainer) setnsCgroupPaths(p *Process, initPid int) (map[string]string, error) {
+	rootPaths := c.cgroupManager.GetChildCgroupPaths()
+
+	if len(p.SubCgroupPaths) > 0 {
+		return subCgroupPaths(rootPaths, p.SubCgroupPaths)
+	}
+
+	paths := make(map[string]string, len(rootPaths))
+	for ctrl, root := range rootPaths {
+		paths[ctrl] = root
+	}
+
+	initCg, err := cgroups.ParseCgroupFile(fmt.Sprintf("/proc/%d/cgroup", initPid))
+	if err != nil {
+		logrus.Debugf("failed to get cgroups of init process (%v); using the container's cgroup root", err)
+		return paths, nil
+	}
+
+	for ctrl, root := range rootPaths {
+		cgPath, ok := initCg[ctrl]
+		if !ok {
+			continue
+		}
+
+		var path string
+		if cgroups.IsCgroup2UnifiedMode() {
+			path = filepath.Join(fs2.UnifiedMountpoint, cgPath)
+		} else {
+			mnt, mntRoot, err := cgroups.FindCgroupMountpointAndRoot("", ctrl)
+			if err != nil {
+				continue
+			}
+			rel, err := filepath.Rel(mntRoot, cgPath)
+			if err != nil {
+				continue
+			}
+			path = filepath.Join(mnt, rel)
+		}
+
+		if path == root || strings.HasPrefix(path, root+"/") {
+			paths[ctrl] = path
+		}
+	}
+
+	return paths, nil
+}
+
+// sysbox-runc: subCgroupPaths returns the given cgroup paths, extended with
+// the given sub-cgroup paths (see Process.SubCgroupPaths); the sub-cgroups
+// must exist.
+func subCgroupPaths(rootPaths, subPaths map[string]string) (map[string]string, error) {
+	for ctrl := range subPaths {
+		if _, ok := rootPaths[ctrl]; ctrl != "" && !ok {
+			return nil, fmt.Errorf("unknown cgroup controller %q", ctrl)
+		}
+	}
+
+	paths := make(map[string]string, len(rootPaths))
+	for ctrl, root := range rootPaths {
+		sub, ok := subPaths[ctrl]
+		if !ok {
+			sub, ok = subPaths[""]
+		}
+		if !ok {
+			paths[ctrl] = root
+			continue
+		}
+		path := filepath.Join(root, filepath.Clean("/"+sub))
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("invalid cgroup %q: %w", sub, err)
+		}
+		paths[ctrl] = path
+	}
+
+	return paths, nil
 }
 
 // sysbox-runc: create a new helper process command to perform rootfs mount initialization
@@ -678,10 +956,30 @@ func (c *linuxContainer) initHelperCmdTemplate(p *Process, childInitPipe, childL
 	cmd.Env = append(cmd.Env,
 		"_LIBCONTAINER_LOGPIPE="+strconv.Itoa(stdioFdCount+len(cmd.ExtraFiles)-1),
 		"_LIBCONTAINER_LOGLEVEL="+p.LogLevel,
+		"_LIBCONTAINER_CONTAINERID="+c.id,
 	)
 	return cmd
 }
 
+// sysbox-runc: mergeRlimits returns the given base rlimits with those of the
+// same type replaced by (and the others followed by) the given overrides.
+func mergeRlimits(base, overrides []configs.Rlimit) []configs.Rlimit {
+	merged := []configs.Rlimit{}
+	for _, rl := range base {
+		overridden := false
+		for _, o := range overrides {
+			if o.Type == rl.Type {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, rl)
+		}
+	}
+	return append(merged, overrides...)
+}
+
 func (c *linuxContainer) newInitConfig(process *Process) *initConfig {
 	cfg := &initConfig{
 		Config:           c.config,
@@ -699,6 +997,8 @@ func (c *linuxContainer) newInitConfig(process *Process) *initConfig {
 		AppArmorProfile:  c.config.AppArmorProfile,
 		ProcessLabel:     c.config.ProcessLabel,
 		Rlimits:          c.config.Rlimits,
+		Scheduler:        c.config.Scheduler,
+		IOPriority:       c.config.IOPriority,
 	}
 	if process.NoNewPrivileges != nil {
 		cfg.NoNewPrivileges = *process.NoNewPrivileges
@@ -709,29 +1009,68 @@ func (c *linuxContainer) newInitConfig(process *Process) *initConfig {
 	if process.Label != "" {
 		cfg.ProcessLabel = process.Label
 	}
+	// sysbox-runc: the process rlimits override the container's ones of the
+	// same type only
 	if len(process.Rlimits) > 0 {
-		cfg.Rlimits = process.Rlimits
+		cfg.Rlimits = mergeRlimits(c.config.Rlimits, process.Rlimits)
+	}
+	if process.Scheduler != nil {
+		cfg.Scheduler = process.Scheduler
+	}
+	if process.IOPriority != nil {
+		cfg.IOPriority = process.IOPriority
 	}
 	cfg.CreateConsole = process.ConsoleSocket != nil
 	cfg.ConsoleWidth = process.ConsoleWidth
 	cfg.ConsoleHeight = process.ConsoleHeight
+	cfg.SyncVersion = syncVersion
+
+	// sysbox-runc: compile the seccomp filters here, so that they are reused
+	// across containers with identical profiles (see seccomp.Compile()).
+	cfg.SeccompProg = compileSeccomp(c.config.Seccomp)
+	if c.config.SeccompNotif != nil && len(c.config.SeccompNotif.Syscalls) > 0 {
+		cfg.SeccompNotifProg = compileSeccomp(c.config.SeccompNotif)
+	}
+
 	return cfg
 }
 
+// sysbox-runc: compileSeccomp returns the compiled program for the given
+// seccomp filter, or nil if it can't be compiled (in which case the container's
+// init process compiles the filter itself, and reports any errors).
+func compileSeccomp(config *configs.Seccomp) *seccomp.Program {
+	if config == nil {
+		return nil
+	}
+
+	prog, err := seccomp.Compile(config)
+	if err != nil {
+		logrus.Debugf("failed to precompile seccomp filter: %v", err)
+		return nil
+	}
+
+	hits, misses := seccomp.CacheStats()
+	logrus.Debugf("seccomp program cache: %d hits, %d misses", hits, misses)
+
+	return prog
+}
+
 func (c *linuxContainer) Destroy() error {
 	c.m.Lock()
 	defer c.m.Unlock()
 
 	err := c.state.destroy()
 
+	// sysbox-runc: a daemon that doesn't know the container (e.g., it lost
+	// its state when restarted) has nothing to release for it.
 	if c.sysFs.Enabled() {
-		if ferr := c.sysFs.Unregister(); err == nil {
+		if ferr := c.sysFs.Unregister(); err == nil && sysbox.GetIPCErrorKind(ferr) != sysbox.IPCNotFound {
 			err = ferr
 		}
 	}
 
 	if c.sysMgr.Enabled() {
-		if merr := c.sysMgr.Unregister(); err == nil {
+		if merr := c.sysMgr.Unregister(); err == nil && sysbox.GetIPCErrorKind(merr) != sysbox.IPCNotFound {
 			err = merr
 		}
 	} else {
@@ -742,9 +1081,37 @@ func (c *linuxContainer) Destroy() error {
 		}
 	}
 
+	if c.config.RootfsClone != "" {
+		if rerr := rootfsclone.Teardown(c.config.RootfsClone); err == nil {
+			err = rerr
+		}
+	}
+
+	if herr := c.runSysboxPoststopHooks(); err == nil {
+		err = herr
+	}
+
 	return err
 }
 
+// sysbox-runc: runSysboxPoststopHooks runs the container's SysboxPoststop
+// hooks; they must run after it's unregistered from the sysbox daemons (see
+// Destroy()).
+func (c *linuxContainer) runSysboxPoststopHooks() error {
+	hooks := c.config.Hooks[configs.SysboxPoststop]
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	s, err := c.currentOCIState()
+	if err != nil {
+		return err
+	}
+	s.Status = specs.StateStopped
+
+	return hooks.RunSysboxHooks(s, c.sysboxHookState())
+}
+
 func (c *linuxContainer) Pause() error {
 	c.m.Lock()
 	defer c.m.Unlock()
@@ -754,7 +1121,16 @@ func (c *linuxContainer) Pause() error {
 	}
 	switch status {
 	case Running, Created:
+		// sysbox-runc: no syscall trapped by sysbox-fs should be in flight
+		// when the container is frozen (best effort, see
+		// quiesceSyscallTraps())
+		if err := c.quiesceSyscallTraps(false); err != nil {
+			return err
+		}
 		if err := c.cgroupManager.Freeze(configs.Frozen); err != nil {
+			if uerr := c.unquiesceSyscallTraps(); uerr != nil {
+				logrus.Warn(uerr)
+			}
 			return err
 		}
 		if c.sysMgr.Enabled() {
@@ -779,6 +1155,9 @@ func (c *linuxContainer) Resume() error {
 	if status != Paused {
 		return newGenericError(fmt.Errorf("container not paused"), ContainerNotPaused)
 	}
+	if err := c.unquiesceSyscallTraps(); err != nil {
+		return err
+	}
 	if err := c.cgroupManager.Freeze(configs.Thawed); err != nil {
 		return err
 	}
@@ -807,6 +1186,106 @@ func (c *linuxContainer) NotifyMemoryPressure(level PressureLevel) (<-chan struc
 	return notifyMemoryPressure(c.cgroupManager.Path("memory"), level)
 }
 
+// The following are container paths that can't be unmounted as sysbox-runc
+// relies on them for the container's proper operation.
+var unmountBlackList = []string{"/proc", "/sys", "/dev"}
+
+// sysbox-runc: Unmount removes a mount from the container's mount namespace.
+func (c *linuxContainer) Unmount(dest string, flags int) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status != Running && status != Created {
+		return newGenericError(fmt.Errorf("container not running or created: %s", status), ContainerNotRunning)
+	}
+
+	if !filepath.IsAbs(dest) {
+		return newGenericError(fmt.Errorf("mount destination %s is not an absolute path", dest), ConfigInvalid)
+	}
+	dest = filepath.Clean(dest)
+
+	for _, p := range unmountBlackList {
+		if dest == p || strings.HasPrefix(dest, p+"/") {
+			return newGenericError(fmt.Errorf("mount at %s is required by sysbox and can't be removed", dest), ConfigInvalid)
+		}
+	}
+
+	idx := -1
+	mgrMounts := []string{}
+	for i, m := range c.config.Mounts {
+
+		// sysbox-runc: sysbox-mgr tracks the mounts it set up (or prepared
+		// the sources of) until the container is unregistered; those removed
+		// (along with the mounts above them, which a lazy unmount detaches)
+		// must be released with it.
+		if (m.Destination == dest || strings.HasPrefix(m.Destination, dest+"/")) && c.sysMgr.HasMount(m.Destination) {
+			if !c.sysMgr.CanReleaseMounts() {
+				return newGenericError(fmt.Errorf("mount at %s is managed by sysbox-mgr, which can't release it before the container is removed",
+					m.Destination), ConfigInvalid)
+			}
+			mgrMounts = append(mgrMounts, m.Destination)
+		}
+
+		if m.Destination == dest {
+			idx = i
+			continue
+		}
+
+		// Unless the unmount is lazy, the kernel would fail it with EBUSY if
+		// other mounts sit below it; report that in terms of the container
+		// config instead.
+		if flags&unix.MNT_DETACH == 0 && strings.HasPrefix(m.Destination, dest+"/") {
+			return newGenericError(fmt.Errorf("mount at %s has a submount at %s; unmount it first or use a lazy unmount",
+				dest, m.Destination), ConfigInvalid)
+		}
+	}
+
+	if idx == -1 {
+		return newGenericError(fmt.Errorf("no mount found at %s", dest), ConfigInvalid)
+	}
+
+	reqs := []opReq{
+		{
+			Op:    umount,
+			Path:  dest,
+			Flags: flags,
+		},
+	}
+
+	if err := c.handleOp(umount, c.initProcess.pid(), reqs, syncVersion); err != nil {
+		return newSystemErrorWithCausef(err, "unmounting %s", dest)
+	}
+
+	// Drop the mount (and any submounts removed along with it by a lazy
+	// unmount) from the config, so that later operations on the container
+	// (e.g., checkpoint, delete) don't act on it.
+	mounts := []*configs.Mount{}
+	for _, m := range c.config.Mounts {
+		if m.Destination == dest || strings.HasPrefix(m.Destination, dest+"/") {
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+	c.config.Mounts = mounts
+
+	// sysbox-runc: now that they're gone, have sysbox-mgr release the mounts
+	// it tracked; the state is saved either way, as the config no longer
+	// has them.
+	var releaseErr error
+	if len(mgrMounts) > 0 {
+		releaseErr = c.sysMgr.ReleaseMounts(mgrMounts)
+	}
+
+	if _, err = c.updateState(nil); err != nil {
+		return err
+	}
+	return releaseErr
+}
+
 var criuFeatures *criurpc.CriuFeatures
 
 func (c *linuxContainer) checkCriuFeatures(criuOpts *CriuOpts, rpcOpts *criurpc.CriuOpts, criuFeat *criurpc.CriuFeatures) error {
@@ -1264,6 +1743,20 @@ func (c *linuxContainer) Checkpoint(criuOpts *CriuOpts) error {
 		}
 	}
 
+	// sysbox-runc: criu freezes the container too (unless it's paused, in
+	// which case sysbox-fs was quiesced already); a task dumped while it
+	// waits on sysbox-fs would never get its reply once restored
+	if !c.sysFs.Quiesced {
+		if err := c.quiesceSyscallTraps(true); err != nil {
+			return err
+		}
+		defer func() {
+			if err := c.unquiesceSyscallTraps(); err != nil {
+				logrus.Warnf("checkpoint: %v", err)
+			}
+		}()
+	}
+
 	err = c.criuSwrk(nil, req, criuOpts, nil)
 	if err != nil {
 		return err
@@ -1519,6 +2012,11 @@ func (c *linuxContainer) Restore(process *Process, criuOpts *CriuOpts) error {
 	if err := json.Unmarshal(fdJSON, &fds); err != nil {
 		return err
 	}
+	// sysbox-runc: the descriptors include the container's preserved fds; only
+	// the standard ones are inherited on restore (the restored process' stdio).
+	if len(fds) > stdioFdCount {
+		fds = fds[:stdioFdCount]
+	}
 	for i := range fds {
 		if s := fds[i]; strings.Contains(s, "pipe:") {
 			inheritFd := new(criurpc.InheritFd)
@@ -1650,7 +2148,7 @@ func (c *linuxContainer) criuSwrk(process *Process, req *criurpc.CriuReq, opts *
 
 	var extFds []string
 	if process != nil {
-		extFds, err = getPipeFds(criuProcess.Pid)
+		extFds, err = getPipeFds(criuProcess.Pid, stdioFdCount)
 		if err != nil {
 			return err
 		}
@@ -1830,10 +2328,10 @@ func (c *linuxContainer) criuNotifications(resp *criurpc.CriuResp, process *Proc
 			}
 			s.Pid = int(notify.GetPid())
 
-			if err := c.config.Hooks[configs.Prestart].RunHooks(s); err != nil {
+			if err := c.config.Hooks[configs.Prestart].RunSysboxHooks(s, c.sysboxHookState()); err != nil {
 				return err
 			}
-			if err := c.config.Hooks[configs.CreateRuntime].RunHooks(s); err != nil {
+			if err := c.config.Hooks[configs.CreateRuntime].RunSysboxHooks(s, c.sysboxHookState()); err != nil {
 				return err
 			}
 		}
@@ -1910,6 +2408,7 @@ func (c *linuxContainer) updateState(process parentProcess) (*State, error) {
 	if err != nil {
 		return nil, err
 	}
+	c.indexState(state)
 	return state, nil
 }
 
@@ -2026,6 +2525,7 @@ func (c *linuxContainer) currentState() (*State, error) {
 		ExternalDescriptors: externalDescriptors,
 		SysMgr:              *c.sysMgr,
 		SysFs:               *c.sysFs,
+		BootID:              bootID(),
 	}
 
 	if pid > 0 {
@@ -2066,6 +2566,19 @@ func (c *linuxContainer) currentOCIState() (*specs.State, error) {
 	return state, nil
 }
 
+// sysbox-runc: sysboxHookState returns the sysbox state of the container
+// passed to its hooks.
+func (c *linuxContainer) sysboxHookState() *configs.SysboxState {
+	return &configs.SysboxState{
+		UidMappings:   c.config.UidMappings,
+		GidMappings:   c.config.GidMappings,
+		UidShift:      c.config.UidShiftReport,
+		IdmapDir:      c.idmapDir(),
+		ShiftfsMounts: c.config.ShiftfsMounts,
+		RootfsClone:   c.config.RootfsClone,
+	}
+}
+
 // orderNamespacePaths sorts namespace paths into a list of paths that we
 // can setns in order.
 func (c *linuxContainer) orderNamespacePaths(namespaces map[configs.NamespaceType]string) ([]string, error) {
@@ -2333,7 +2846,7 @@ func formatIDMappings(idMap []configs.IDMap) []byte {
 
 // sysbox-runc: handleReqOp handles requests from the container's init process for actions
 // that can't be done by it (e.g., due to lack of permissions, etc.).
-func (c *linuxContainer) handleReqOp(childPid int, reqs []opReq) error {
+func (c *linuxContainer) handleReqOp(childPid int, reqs []opReq, syncVersion int) error {
 
 	if len(reqs) == 0 {
 		return newSystemError(fmt.Errorf("no op requests!"))
@@ -2343,18 +2856,20 @@ func (c *linuxContainer) handleReqOp(childPid int, reqs []opReq) error {
 	// of the same type.
 	op := reqs[0].Op
 
-	if op != bind && op != switchDockerDns && op != chown {
+	if op != bind && op != switchDockerDns && op != chown && op != umount {
 		return newSystemError(fmt.Errorf("invalid opReq type %d", int(op)))
 	}
 
-	return c.handleOp(op, childPid, reqs)
+	return c.handleOp(op, childPid, reqs, syncVersion)
 }
 
 // sysbox-runc: handleOp dispatches a helpter process that enters one or more of
 // the container's namespaces and performs the given request. By virtue of only
 // entering a subset of the container's namespaces, the helper can bypass restrictions
 // that the container's init process would have in order to perform those same actions.
-func (c *linuxContainer) handleOp(op opReqType, childPid int, reqs []opReq) error {
+// The helper runs from the same binary as the container's init process, so it's
+// sent the requests with the sync protocol version used with it (see syncVersion).
+func (c *linuxContainer) handleOp(op opReqType, childPid int, reqs []opReq, syncVersion int) error {
 
 	// create the socket pairs for communication with the child
 	parentMsgPipe, childMsgPipe, err := utils.NewSockPair("initHelper")
@@ -2368,9 +2883,15 @@ func (c *linuxContainer) handleOp(op opReqType, childPid int, reqs []opReq) erro
 		return newSystemErrorWithCause(err, "Unable to create the initMount log pipe")
 	}
 
-	// create a new initMount command
-	initProc := c.initProcess.(*initProcess).process
+	// create a new initMount command; when the container was loaded from its
+	// state (i.e., it's not being created by us), there is no init Process
+	// object so we use the current log level.
+	initProc := &Process{LogLevel: logrus.GetLevel().String()}
+	if p, ok := c.initProcess.(*initProcess); ok {
+		initProc = p.process
+	}
 	cmd := c.initHelperCmdTemplate(initProc, childMsgPipe, childLogPipe)
+	cmd.Env = append(cmd.Env, "_LIBCONTAINER_SYNCVERSION="+strconv.Itoa(syncVersion))
 
 	// Log error messages from the initMount child process
 	go logs.ForwardLogs(parentLogPipe)
@@ -2395,6 +2916,10 @@ func (c *linuxContainer) handleOp(op opReqType, childPid int, reqs []opReq) erro
 		nsPath = fmt.Sprintf("net:/proc/%d/ns/net", childPid)
 	case chown:
 		nsPath = fmt.Sprintf("mnt:/proc/%d/ns/mnt", childPid)
+	case umount:
+		nsPath = fmt.Sprintf("mnt:/proc/%d/ns/mnt", childPid)
+	case stampIdentity:
+		nsPath = fmt.Sprintf("uts:/proc/%d/ns/uts,mnt:/proc/%d/ns/mnt", childPid, childPid)
 	}
 
 	namespaces := []string{nsPath}
@@ -2410,6 +2935,16 @@ func (c *linuxContainer) handleOp(op opReqType, childPid int, reqs []opReq) erro
 		return newSystemErrorWithCause(err, "copying initHelper bootstrap data to pipe")
 	}
 
+	// send the action requests to the grandchild right away (it reads them
+	// once it enters the go runtime), rather than after it's created, so that
+	// they're in the pipe by the time it's ready; nsexec only reads the
+	// bootstrap data from it.
+	if syncVersion >= syncVersionFramed {
+		if err := writeOpReqs(parentMsgPipe, reqs); err != nil {
+			return newSystemErrorWithCause(err, "writing init mount info to pipe")
+		}
+	}
+
 	// wait for parent process to exit
 	status, err := cmd.Process.Wait()
 	if err != nil {
@@ -2445,9 +2980,10 @@ func (c *linuxContainer) handleOp(op opReqType, childPid int, reqs []opReq) erro
 	}
 	cmd.Process = process
 
-	// send the action requests to the grandchild
-	if err := utils.WriteJSON(parentMsgPipe, reqs); err != nil {
-		return newSystemErrorWithCause(err, "writing init mount info to pipe")
+	if syncVersion < syncVersionFramed {
+		if err := utils.WriteJSON(parentMsgPipe, reqs); err != nil {
+			return newSystemErrorWithCause(err, "writing init mount info to pipe")
+		}
 	}
 
 	// wait for msg from the grandchild indicating that it's done
@@ -2479,7 +3015,7 @@ func (c *linuxContainer) handleOp(op opReqType, childPid int, reqs []opReq) erro
 // sysbox-fs to setup syscall trapping.
 func (c *linuxContainer) procSeccompInit(pid int, fd int32) error {
 	if c.sysFs.Enabled() {
-		if err := c.sysFs.SendSeccompInit(pid, c.id, fd); err != nil {
+		if err := c.sysFs.SendSeccompInit(pid, c.sysFs.Id, fd); err != nil {
 			return newSystemErrorWithCause(err, "sending seccomp fd to sysbox-fs")
 		}
 	}
@@ -2496,7 +3032,7 @@ func (c *linuxContainer) setupShiftfsMarks() error {
 		return nil
 	}
 
-	if config.UidShiftRootfs {
+	if config.ShiftfsRootfs() {
 		shiftfsMounts = append(shiftfsMounts, configs.ShiftfsMount{Source: config.Rootfs, Readonly: false})
 	}
 
@@ -2505,10 +3041,15 @@ func (c *linuxContainer) setupShiftfsMarks() error {
 	// behavior.
 
 	if c.sysMgr.Config.BindMountUidShift {
+		sysFsMountpoint := ""
+		if c.sysFs.Enabled() {
+			sysFsMountpoint = c.sysFs.Mountpoint
+		}
+
 		for _, m := range config.Mounts {
 			if m.Device == "bind" {
 
-				needShiftfs, err := needUidShiftOnBindSrc(m, config)
+				needShiftfs, err := needUidShiftOnBindSrc(m, config, sysFsMountpoint)
 				if err != nil {
 					return newSystemErrorWithCause(err, "checking uid shifting on bind source")
 				}
@@ -2531,9 +3072,23 @@ func (c *linuxContainer) setupShiftfsMarks() error {
 				}
 
 				if skipShiftfsBindSource(dir) {
+					c.reportUidShift(m.Source, m.Destination, configs.UidShiftNone, "special source")
 					continue
 				}
 
+				// shiftfs can't be mounted on network filesystems; bind sources
+				// on them are id-mapped instead (see setupIdmappedMounts()).
+				if strings.HasPrefix(m.Source, c.idmapDir()+"/") {
+					continue
+				}
+				if fsType, err := sysbox.NetworkFs(dir); err != nil {
+					return newSystemErrorWithCausef(err, "checking the filesystem of bind source %s", dir)
+				} else if fsType != "" {
+					continue
+				}
+
+				c.reportUidShift(m.Source, m.Destination, configs.UidShiftShiftfs, "")
+
 				duplicate := false
 				for _, sm := range shiftfsMounts {
 					if sm.Source == dir {
@@ -2574,7 +3129,7 @@ func (c *linuxContainer) setupShiftfsMarks() error {
 		// Replace the container's mounts that have shiftfs with the shiftfs
 		// markpoint allocated by sysbox-mgr.
 
-		if config.UidShiftRootfs {
+		if config.ShiftfsRootfs() {
 			config.Rootfs = shiftfsMarks[0].Source
 		}
 
@@ -2657,5 +3212,11 @@ func skipShiftfsBindSource(source string) bool {
 		return true
 	}
 
+	// Nor on the kernel's pseudo filesystems under /sys/kernel (e.g., configfs
+	// subtrees passed through to the container)
+	if strings.HasPrefix(source, "/sys/kernel/") {
+		return true
+	}
+
 	return false
 }
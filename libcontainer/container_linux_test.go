@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"syscall"
 	"testing"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
@@ -13,6 +15,7 @@ import (
 	"github.com/opencontainers/runc/libcontainer/intelrdt"
 	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"golang.org/x/sys/unix"
 )
 
 type mockCgroupManager struct {
@@ -88,6 +91,10 @@ func (m *mockCgroupManager) GetChildCgroupPaths() map[string]string {
 	return m.paths
 }
 
+func (m *mockCgroupManager) GetType() cgroups.CgroupType {
+	return cgroups.Cgroup_v1_fs
+}
+
 func (m *mockIntelRdtManager) Apply(pid int) error {
 	return nil
 }
@@ -417,3 +424,66 @@ func TestGetContainerStateAfterUpdate(t *testing.T) {
 		t.Fatalf("expected Memory to be 2048 but received %q", state.Config.Cgroups.Memory)
 	}
 }
+
+func TestGetPipeFds(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	rfd, wfd := int(r.Fd()), int(w.Fd())
+	w.Close()
+
+	n := rfd + 1
+	if wfd >= n {
+		n = wfd + 1
+	}
+	fds, err := getPipeFds(os.Getpid(), n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fds) != n {
+		t.Fatalf("expected %d fds; got %v", n, fds)
+	}
+	if want := fmt.Sprintf("pipe:[%d]", pipeIno(t, r)); fds[rfd] != want {
+		t.Errorf("expected fd %d to be %q; got %q", rfd, want, fds[rfd])
+	}
+	// the closed fd is reported as empty
+	if fds[wfd] != "" {
+		t.Errorf("expected closed fd %d to be empty; got %q", wfd, fds[wfd])
+	}
+}
+
+func pipeIno(t *testing.T, f *os.File) uint64 {
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Sys().(*syscall.Stat_t).Ino
+}
+
+func TestMergeRlimits(t *testing.T) {
+	base := []configs.Rlimit{
+		{Type: unix.RLIMIT_NOFILE, Hard: 1024, Soft: 1024},
+		{Type: unix.RLIMIT_NPROC, Hard: 100, Soft: 100},
+	}
+	overrides := []configs.Rlimit{
+		{Type: unix.RLIMIT_NOFILE, Hard: 65536, Soft: 4096},
+		{Type: unix.RLIMIT_CORE, Hard: 0, Soft: 0},
+	}
+
+	got := mergeRlimits(base, overrides)
+	want := []configs.Rlimit{
+		{Type: unix.RLIMIT_NPROC, Hard: 100, Soft: 100},
+		{Type: unix.RLIMIT_NOFILE, Hard: 65536, Soft: 4096},
+		{Type: unix.RLIMIT_CORE, Hard: 0, Soft: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want rlimits %+v, got %+v", want, got)
+	}
+
+	// the container's rlimits are left as is
+	if base[0].Hard != 1024 || len(base) != 2 {
+		t.Errorf("base rlimits modified: %+v", base)
+	}
+}
@@ -0,0 +1,61 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: file (in the container's state dir) shown as the container's
+// /proc/sys/kernel/core_pattern when its core dumps are captured.
+const corePatternFile = "core_pattern"
+
+// sysbox-runc: setupCoreDump makes the container's /proc/sys/kernel/core_pattern
+// show where its core dumps are stored when they are captured by sysbox-runc
+// (see configs.CoreDump), by bind-mounting a read-only file holding the core
+// dump pattern over it. The host's core_pattern (which sysbox-fs shows
+// otherwise) is that of the sysbox-runc core dump handler, which is
+// meaningless inside the container.
+func (c *linuxContainer) setupCoreDump() error {
+	cd := c.config.CoreDump
+	if cd == nil {
+		return nil
+	}
+
+	uid, err := c.config.HostRootUID()
+	if err != nil {
+		return err
+	}
+	gid, err := c.config.HostRootGID()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(c.root, corePatternFile)
+	if err := ioutil.WriteFile(path, []byte(cd.Pattern+"\n"), 0644); err != nil {
+		return newSystemErrorWithCause(err, "writing core pattern file")
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return newSystemErrorWithCause(err, "setting ownership of core pattern file")
+	}
+
+	c.config.Mounts = append(c.config.Mounts, &configs.Mount{
+		Source:           path,
+		Destination:      "/proc/sys/kernel/core_pattern",
+		Device:           "bind",
+		Flags:            unix.MS_BIND | unix.MS_RDONLY,
+		PropagationFlags: []int{unix.MS_PRIVATE},
+		BindSrcInfo: configs.BindSrcInfo{
+			IsDir: false,
+			Uid:   uint32(uid),
+			Gid:   uint32(gid),
+		},
+	})
+
+	return nil
+}
@@ -0,0 +1,21 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"github.com/opencontainers/runc/libsysbox/extension"
+)
+
+// sysbox-runc: runExtensions runs the container's extensions for the given
+// extension point (see package libsysbox/extension); a failing extension fails
+// the container's creation.
+func (c *linuxContainer) runExtensions(point extension.Point, req *extension.Request) error {
+	if !c.extensions.Has(point) {
+		return nil
+	}
+	req.ID = c.id
+	if err := c.extensions.Run(point, req); err != nil {
+		return newSystemErrorWithCausef(err, "running %s extensions", point)
+	}
+	return nil
+}
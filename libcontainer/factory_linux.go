@@ -22,6 +22,7 @@ import (
 	"github.com/opencontainers/runc/libcontainer/intelrdt"
 	"github.com/opencontainers/runc/libcontainer/utils"
 
+	"github.com/opencontainers/runc/libsysbox/extension"
 	"github.com/opencontainers/runc/libsysbox/sysbox"
 	"github.com/pkg/errors"
 
@@ -201,6 +202,24 @@ func SysMgr(sysMgr *sysbox.Mgr) func(*LinuxFactory) error {
 	}
 }
 
+// sysbox-runc: SysMgrSyncRelease configures a LinuxFactory to return loaded
+// containers that, when destroyed, wait for sysbox-mgr to release their
+// resources (see sysbox.Mgr.SyncRelease).
+func SysMgrSyncRelease(l *LinuxFactory) error {
+	l.SysMgrSyncRelease = true
+	return nil
+}
+
+// Extensions returns an option func that configures a LinuxFactory to return
+// containers that run the given sysbox-runc extensions (see package
+// libsysbox/extension) at their extension points.
+func Extensions(exts *extension.Set) func(*LinuxFactory) error {
+	return func(l *LinuxFactory) error {
+		l.Extensions = exts
+		return nil
+	}
+}
+
 // New returns a linux based container factory based in the root directory and
 // configures the factory with the provided option funcs.
 func New(root string, options ...func(*LinuxFactory) error) (Factory, error) {
@@ -273,6 +292,18 @@ type LinuxFactory struct {
 
 	// SysMgr is the object representing the sysbox-mgr
 	SysMgr *sysbox.Mgr
+
+	// sysbox-runc: wait for sysbox-mgr to release the resources of the loaded
+	// containers when they're destroyed
+	SysMgrSyncRelease bool
+
+	// Extensions are the sysbox-runc extensions run by the containers (may
+	// be nil)
+	Extensions *extension.Set
+
+	// sysbox-runc: keep the state index of the state root up to date (see
+	// StateIndex)
+	StateIndex bool
 }
 
 func (l *LinuxFactory) Create(id string, config *configs.Config) (Container, error) {
@@ -289,12 +320,16 @@ func (l *LinuxFactory) Create(id string, config *configs.Config) (Container, err
 	if err != nil {
 		return nil, err
 	}
-	if _, err := os.Stat(containerRoot); err == nil {
-		return nil, newGenericError(fmt.Errorf("container with id exists: %v", id), IdInUse)
-	} else if !os.IsNotExist(err) {
+	// sysbox-runc: claim the container's dir atomically (rather than checking
+	// that it doesn't exist first), so that of concurrent creations of
+	// containers with the same id, only one succeeds.
+	if err := os.MkdirAll(l.Root, 0711); err != nil {
 		return nil, newGenericError(err, SystemError)
 	}
-	if err := os.MkdirAll(containerRoot, 0711); err != nil {
+	if err := os.Mkdir(containerRoot, 0711); err != nil {
+		if os.IsExist(err) {
+			return nil, newGenericError(fmt.Errorf("container with id exists: %v", id), IdInUse)
+		}
 		return nil, newGenericError(err, SystemError)
 	}
 	if err := os.Chown(containerRoot, unix.Geteuid(), unix.Getegid()); err != nil {
@@ -312,6 +347,8 @@ func (l *LinuxFactory) Create(id string, config *configs.Config) (Container, err
 		cgroupManager: l.NewCgroupsManager(config.Cgroups, nil),
 		sysMgr:        l.SysMgr,
 		sysFs:         l.SysFs,
+		extensions:    l.Extensions,
+		stateIndex:    l.StateIndex,
 	}
 	if l.NewIntelRdtManager != nil {
 		c.intelRdtManager = l.NewIntelRdtManager(config, id, "")
@@ -336,11 +373,17 @@ func (l *LinuxFactory) Load(id string) (Container, error) {
 	if err != nil {
 		return nil, err
 	}
-	r := &nonChildProcess{
+	var r parentProcess = &nonChildProcess{
 		processPid:       state.InitProcessPid,
 		processStartTime: state.InitProcessStartTime,
 		fds:              state.ExternalDescriptors,
 	}
+	// sysbox-runc: the init process of a container from a previous boot is
+	// gone, even if its pid and start time match a process of this one (see
+	// bootID())
+	if state.BootID != "" && state.BootID != bootID() {
+		r = nil
+	}
 	c := &linuxContainer{
 		initProcess:          r,
 		initProcessStartTime: state.InitProcessStartTime,
@@ -356,6 +399,7 @@ func (l *LinuxFactory) Load(id string) (Container, error) {
 		created:              state.Created,
 		sysFs:                &state.SysFs,
 		sysMgr:               &state.SysMgr,
+		stateIndex:           l.StateIndex,
 	}
 	if l.NewIntelRdtManager != nil {
 		c.intelRdtManager = l.NewIntelRdtManager(&state.Config, id, state.IntelRdtPath)
@@ -363,6 +407,7 @@ func (l *LinuxFactory) Load(id string) (Container, error) {
 	if l.NewIntelRdtManager != nil {
 		c.intelRdtManager = l.NewIntelRdtManager(&state.Config, id, state.IntelRdtPath)
 	}
+	c.sysMgr.SyncRelease = l.SysMgrSyncRelease
 	c.state = &loadedState{c: c}
 	if err := c.refreshState(); err != nil {
 		return nil, err
@@ -377,6 +422,23 @@ func (l *LinuxFactory) Type() string {
 // StartInitialization loads a container by opening the pipe fd from the parent to read the configuration and state
 // This is a low level implementation detail of the reexec and should not be consumed externally
 func (l *LinuxFactory) StartInitialization() (err error) {
+	// sysbox-runc: the init binary also runs the background rootfs ownership
+	// shifting worker (see startRootfsShiftWorker()).
+	if req := os.Getenv(rootfsShiftEnv); req != "" {
+		os.Exit(runRootfsShiftWorker(req))
+	}
+
+	// sysbox-runc: it also holds the user-ns for ID-mapped mounts (see
+	// newIdmapUserns()).
+	if os.Getenv(usernsHolderEnv) != "" {
+		os.Exit(runUsernsHolder())
+	}
+
+	// sysbox-runc: and the seccomp fd keeper (see startSeccompKeeper()).
+	if sock := os.Getenv(seccompKeeperEnv); sock != "" {
+		os.Exit(runSeccompKeeper(sock))
+	}
+
 	// Get the INITPIPE.
 	envInitPipe := os.Getenv("_LIBCONTAINER_INITPIPE")
 	pipefd, err := strconv.Atoi(envInitPipe)
@@ -414,7 +476,7 @@ func (l *LinuxFactory) StartInitialization() (err error) {
 	defer func() {
 		// We have an error during the initialization of the container's init,
 		// send it back to the parent process in the form of an initError.
-		if werr := utils.WriteJSON(pipe, syncT{procError}); werr != nil {
+		if werr := utils.WriteJSON(pipe, syncT{Type: procError}); werr != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return
 		}
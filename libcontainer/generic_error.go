@@ -83,6 +83,12 @@ func (e *genericError) Error() string {
 	return fmt.Sprintf("%s:%d: %s caused: %s", frame.File, frame.Line, e.Cause, e.Message)
 }
 
+// sysbox-runc: allows callers to look for the underlying error (e.g., via
+// errors.As()).
+func (e *genericError) Unwrap() error {
+	return e.Err
+}
+
 func (e *genericError) Code() ErrorCode {
 	return e.ECode
 }
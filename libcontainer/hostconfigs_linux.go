@@ -0,0 +1,242 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: dir (in the container's state dir) holding the copies of the
+// host configs mounted in the container (see configs.HostConfigs), at the same
+// paths as in the host.
+const hostConfigsDir = "host-configs"
+
+// sysbox-runc: setupHostConfigs mounts the host configs (see
+// configs.HostConfigs) read-only in the container, from copies owned by the
+// container's root user. Host paths that don't exist are skipped.
+func (c *linuxContainer) setupHostConfigs() error {
+	hc := c.config.HostConfigs
+	if hc == nil {
+		return nil
+	}
+
+	uid, err := c.config.HostRootUID()
+	if err != nil {
+		return err
+	}
+	gid, err := c.config.HostRootGID()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(c.root, hostConfigsDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return newSystemErrorWithCause(err, "removing host configs dir")
+	}
+
+	for _, path := range hc.Paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				logrus.Debugf("host config %s not found; not mounting it", path)
+				continue
+			}
+			return newSystemErrorWithCausef(err, "checking host config %s", path)
+		}
+
+		dst := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return newSystemErrorWithCause(err, "creating host configs dir")
+		}
+		if err := syncHostConfig(path, dst, uid, gid); err != nil {
+			return newSystemErrorWithCausef(err, "copying host config %s", path)
+		}
+
+		c.config.Mounts = append(c.config.Mounts, &configs.Mount{
+			Source:           dst,
+			Destination:      path,
+			Device:           "bind",
+			Flags:            unix.MS_BIND | unix.MS_REC | unix.MS_RDONLY,
+			PropagationFlags: []int{unix.MS_PRIVATE},
+			BindSrcInfo:      configs.BindSrcInfo{IsDir: fi.IsDir(), Uid: uint32(uid), Gid: uint32(gid)},
+		})
+	}
+
+	return nil
+}
+
+// sysbox-runc: RefreshHostConfigs updates the copies of the host configs
+// mounted in the container with the host's current ones.
+func (c *linuxContainer) RefreshHostConfigs() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status != Running && status != Created && status != Paused {
+		return newGenericError(errors.New("container not running"), ContainerNotRunning)
+	}
+	if c.config.HostConfigs == nil {
+		return nil
+	}
+
+	uid, err := c.config.HostRootUID()
+	if err != nil {
+		return err
+	}
+	gid, err := c.config.HostRootGID()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(c.root, hostConfigsDir)
+	for _, m := range c.config.Mounts {
+		if !strings.HasPrefix(m.Source, dir+"/") {
+			continue
+		}
+		// a host config removed from the host is kept in the container (its
+		// mount can't be removed), as last seen
+		if _, err := os.Stat(m.Destination); os.IsNotExist(err) {
+			logrus.Warnf("host config %s not found; keeping its last copy in container %s", m.Destination, c.id)
+			continue
+		}
+		if err := syncHostConfig(m.Destination, m.Source, uid, gid); err != nil {
+			return newSystemErrorWithCausef(err, "refreshing host config %s", m.Destination)
+		}
+	}
+
+	return nil
+}
+
+// syncHostConfig makes dst a copy of the host config at src (a file or dir
+// tree), owned by the given uid and gid; symlinks are followed (dangling ones,
+// and files other than regular ones, are skipped). Changed files are rewritten
+// in place, as the container sees them through bind mounts of their inodes.
+func syncHostConfig(src, dst string, uid, gid int) error {
+	return syncHostConfigTree(src, dst, uid, gid, map[hostConfigDir]bool{})
+}
+
+// hostConfigDir identifies a host config dir by its inode.
+type hostConfigDir struct {
+	dev uint64
+	ino uint64
+}
+
+// syncHostConfigTree does syncHostConfig; dirs holds the src dirs being
+// copied (i.e., src's ancestors), to break symlink loops.
+func syncHostConfigTree(src, dst string, uid, gid int, dirs map[hostConfigDir]bool) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return syncHostConfigFile(src, dst, fi.Mode().Perm(), uid, gid)
+	}
+
+	st := fi.Sys().(*syscall.Stat_t)
+	key := hostConfigDir{uint64(st.Dev), st.Ino}
+	if dirs[key] {
+		logrus.Debugf("skipping symlink loop at host config %s", src)
+		return nil
+	}
+	dirs[key] = true
+	defer delete(dirs, key)
+
+	if err := os.Mkdir(dst, fi.Mode().Perm()); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := os.Chmod(dst, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Lchown(dst, uid, gid); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	copied := map[string]bool{}
+	for _, e := range entries {
+		s := filepath.Join(src, e.Name())
+		d := filepath.Join(dst, e.Name())
+
+		sfi, err := os.Stat(s)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if !sfi.IsDir() && !sfi.Mode().IsRegular() {
+			continue
+		}
+
+		// replace the copy if it changed type
+		if dfi, err := os.Lstat(d); err == nil && dfi.IsDir() != sfi.IsDir() {
+			if err := os.RemoveAll(d); err != nil {
+				return err
+			}
+		}
+
+		if err := syncHostConfigTree(s, d, uid, gid, dirs); err != nil {
+			return err
+		}
+		copied[e.Name()] = true
+	}
+
+	// remove what's gone from the host
+	entries, err = ioutil.ReadDir(dst)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !copied[e.Name()] {
+			if err := os.RemoveAll(filepath.Join(dst, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func syncHostConfigFile(src, dst string, perm os.FileMode, uid, gid int) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	if old, err := ioutil.ReadFile(dst); err != nil || !bytes.Equal(old, data) {
+		f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.Chmod(dst, perm); err != nil {
+		return err
+	}
+	return os.Lchown(dst, uid, gid)
+}
@@ -0,0 +1,131 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestSyncHostConfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hostconfigs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src")
+	dst := filepath.Join(tmp, "dst")
+	uid, gid := os.Getuid(), os.Getgid()
+
+	write := func(path, data string, perm os.FileMode) {
+		if err := ioutil.WriteFile(path, []byte(data), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(path, want string, perm os.FileMode) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s: want %q, got %q", path, want, data)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != perm {
+			t.Errorf("%s: want mode %v, got %v", path, perm, fi.Mode().Perm())
+		}
+	}
+	list := func(dir string) []string {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// a dir with a file, a subdir, a symlink to a file out of the dir, a
+	// dangling symlink and a symlink loop
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(src, "a.conf"), "a", 0600)
+	write(filepath.Join(src, "sub", "b.conf"), "b", 0644)
+	write(filepath.Join(tmp, "ca.pem"), "ca", 0644)
+	for link, target := range map[string]string{
+		"ca.pem":   filepath.Join(tmp, "ca.pem"),
+		"dangling": filepath.Join(tmp, "nothing"),
+		"sub/loop": src,
+	} {
+		if err := os.Symlink(target, filepath.Join(src, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := syncHostConfig(src, dst, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+
+	check(filepath.Join(dst, "a.conf"), "a", 0600)
+	check(filepath.Join(dst, "sub", "b.conf"), "b", 0644)
+	check(filepath.Join(dst, "ca.pem"), "ca", 0644)
+	if fi, err := os.Lstat(filepath.Join(dst, "ca.pem")); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("symlink not copied as a regular file: %v, %v", fi, err)
+	}
+	if got := list(dst); len(got) != 3 {
+		t.Errorf("want a.conf, ca.pem and sub copied, got %v", got)
+	}
+
+	// changes are synced in place
+	fi, err := os.Stat(filepath.Join(dst, "a.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(src, "a.conf"), "a2", 0600)
+	if err := os.Remove(filepath.Join(src, "sub", "b.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(src, "ca.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(src, "ca.pem"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syncHostConfig(src, dst, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+
+	check(filepath.Join(dst, "a.conf"), "a2", 0600)
+	fi2, err := os.Stat(filepath.Join(dst, "a.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(fi, fi2) {
+		t.Errorf("a.conf not updated in place")
+	}
+	if got := list(filepath.Join(dst, "sub")); len(got) != 0 {
+		t.Errorf("want sub emptied, got %v", got)
+	}
+	if fi, err := os.Stat(filepath.Join(dst, "ca.pem")); err != nil || !fi.IsDir() {
+		t.Errorf("ca.pem not replaced by a dir: %v, %v", fi, err)
+	}
+
+	// a single file
+	write(filepath.Join(tmp, "krb5.conf"), "krb5", 0640)
+	if err := syncHostConfig(filepath.Join(tmp, "krb5.conf"), filepath.Join(tmp, "krb5.copy"), uid, gid); err != nil {
+		t.Fatal(err)
+	}
+	check(filepath.Join(tmp, "krb5.copy"), "krb5", 0640)
+}
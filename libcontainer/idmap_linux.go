@@ -0,0 +1,276 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/mount"
+	"github.com/opencontainers/runc/libsysbox/idmap"
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: env var that makes the init binary hold a user-ns with the
+// container's ID mappings (see newIdmapUserns()).
+const usernsHolderEnv = "_LIBCONTAINER_USERNS_HOLDER"
+
+// idmapDir returns the dir where the container's ID-mapped mounts are staged.
+func (c *linuxContainer) idmapDir() string {
+	return filepath.Join(c.root, "idmap")
+}
+
+// sysbox-runc: newIdmapUserns creates a user-ns with the container's ID
+// mappings, for use in ID-mapped mounts (the container's own user-ns does not
+// exist yet when they are set up). The user-ns is held by a child process (the
+// init binary) until the returned cleanup func is called.
+func (c *linuxContainer) newIdmapUserns() (*os.File, func(), error) {
+	cmd := exec.Command(c.initPath, c.initArgs[1:]...)
+	cmd.Args[0] = c.initArgs[0]
+	cmd.Env = []string{usernsHolderEnv + "=1"}
+
+	if len(c.config.UidMappings) == 0 || len(c.config.GidMappings) == 0 {
+		return nil, nil, errors.New("container has no user-ns ID mappings")
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}
+	for _, m := range c.config.UidMappings {
+		cmd.SysProcAttr.UidMappings = append(cmd.SysProcAttr.UidMappings,
+			syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	for _, m := range c.config.GidMappings {
+		cmd.SysProcAttr.GidMappings = append(cmd.SysProcAttr.GidMappings,
+			syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+
+	// the child holds the user-ns until its stdin is closed
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func() {
+		stdin.Close()
+		cmd.Wait()
+	}
+
+	usernsFile, err := os.Open(filepath.Join("/proc", strconv.Itoa(cmd.Process.Pid), "ns", "user"))
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	return usernsFile, cleanup, nil
+}
+
+// runUsernsHolder runs the user-ns holder (see newIdmapUserns()); it returns
+// once its stdin is closed.
+func runUsernsHolder() int {
+	io.Copy(ioutil.Discard, os.Stdin)
+	return 0
+}
+
+// sysbox-runc: sets up ID-mapped mounts for the container's bind mounts whose
+// sources are on network filesystems (e.g., NFS, CIFS): shiftfs can't be
+// mounted on top of them, and chown'ing them is not acceptable. Each ID-mapped
+// mount is staged under the container's state dir and replaces the source of
+// the bind mount. Most network filesystems (NFS and CIFS included) don't
+// support ID-mapped mounts though; their mounts are uid-shifted by the host's
+// FUSE helper instead (see setupFuseMount()), if configured, or else left as
+// is (i.e., not uid-shifted). The outcome for each mount is logged, and
+// recorded in the container's uid shift report.
+func (c *linuxContainer) setupIdmappedMounts() error {
+	config := c.config
+
+	if !c.sysMgr.Config.BindMountUidShift {
+		return nil
+	}
+
+	sysFsMountpoint := ""
+	if c.sysFs.Enabled() {
+		sysFsMountpoint = c.sysFs.Mountpoint
+	}
+
+	var netMounts []*configs.Mount
+	var netFsTypes []string
+
+	for _, m := range config.Mounts {
+		if m.Device != "bind" {
+			continue
+		}
+
+		fsType, err := sysbox.NetworkFs(m.Source)
+		if err != nil {
+			return newSystemErrorWithCausef(err, "checking the filesystem of bind source %s", m.Source)
+		}
+		if fsType == "" {
+			continue
+		}
+
+		needShift, err := needUidShiftOnBindSrc(m, config, sysFsMountpoint)
+		if err != nil {
+			return newSystemErrorWithCause(err, "checking uid shifting on bind source")
+		}
+		if !needShift {
+			logrus.Infof("bind mount %s -> %s (%s): uid shifting not required", m.Source, m.Destination, fsType)
+			c.reportUidShift(m.Source, m.Destination, configs.UidShiftNone, "not required")
+			continue
+		}
+
+		if config.UidShiftNoIdmap {
+			logrus.Infof("bind mount %s -> %s (%s): not uid-shifted; id-mapped mounts disabled", m.Source, m.Destination, fsType)
+			c.reportUidShift(m.Source, m.Destination, configs.UidShiftNone, "id-mapped mounts disabled")
+			continue
+		}
+
+		netMounts = append(netMounts, m)
+		netFsTypes = append(netFsTypes, fsType)
+	}
+
+	if len(netMounts) == 0 {
+		return nil
+	}
+
+	usernsFile, cleanup, err := c.newIdmapUserns()
+	if err != nil {
+		return newSystemErrorWithCause(err, "creating user-ns for id-mapped mounts")
+	}
+	defer cleanup()
+	defer usernsFile.Close()
+
+	if err := os.MkdirAll(c.idmapDir(), 0711); err != nil {
+		return newSystemErrorWithCause(err, "creating id-mapped mounts dir")
+	}
+
+	for i, m := range netMounts {
+		target := filepath.Join(c.idmapDir(), strconv.Itoa(i))
+
+		if m.BindSrcInfo.IsDir {
+			err = os.Mkdir(target, 0711)
+		} else {
+			err = ioutil.WriteFile(target, nil, 0600)
+		}
+		if err != nil {
+			return newSystemErrorWithCausef(err, "creating id-mapped mount target %s", target)
+		}
+
+		err = idmap.Mount(m.Source, target, int(usernsFile.Fd()))
+		if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL) {
+			if config.UidShiftFuseHelper != "" {
+				if err := c.setupFuseMount(m.Source, target); err != nil {
+					return newSystemErrorWithCausef(err, "setting up FUSE uid-shifting mount for bind source %s", m.Source)
+				}
+				logrus.Infof("bind mount %s -> %s (%s): uid-shifted via FUSE; id-mapped mounts not supported", m.Source, m.Destination, netFsTypes[i])
+				c.reportUidShift(m.Source, m.Destination, configs.UidShiftFuse,
+					fmt.Sprintf("id-mapped mounts not supported on %s", netFsTypes[i]))
+				m.Source = target
+				continue
+			}
+
+			// files on the mount will show up as owned by nobody:nogroup
+			// inside the container
+			logrus.Warnf("bind mount %s -> %s (%s): not uid-shifted; id-mapped mounts not supported (%v), and no FUSE helper configured",
+				m.Source, m.Destination, netFsTypes[i], err)
+			c.reportUidShift(m.Source, m.Destination, configs.UidShiftNone,
+				fmt.Sprintf("id-mapped mounts not supported on %s, and no FUSE helper configured", netFsTypes[i]))
+			continue
+		}
+		if err != nil {
+			return newSystemErrorWithCausef(err, "setting up id-mapped mount for bind source %s", m.Source)
+		}
+
+		logrus.Infof("bind mount %s -> %s (%s): uid-shifted via id-mapped mount", m.Source, m.Destination, netFsTypes[i])
+		c.reportUidShift(m.Source, m.Destination, configs.UidShiftIdmap, fmt.Sprintf("source on %s", netFsTypes[i]))
+		m.Source = target
+	}
+
+	return nil
+}
+
+// sysbox-runc: setupFuseMount mounts source on target via the host's FUSE
+// helper (see configs.Config.UidShiftFuseHelper), such that the files under
+// it are uid-shifted to the container's ID range.
+func (c *linuxContainer) setupFuseMount(source, target string) error {
+	args, err := fuseHelperArgs(source, target, c.config.UidMappings, c.config.GidMappings)
+	if err != nil {
+		return err
+	}
+
+	// the helper daemonizes once the mount is up; it exits when the mount
+	// is unmounted (see teardownIdmappedMounts())
+	if out, err := exec.Command(c.config.UidShiftFuseHelper, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", c.config.UidShiftFuseHelper, err, strings.TrimSpace(string(out)))
+	}
+
+	mounted, err := mount.Mounted(target)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		return fmt.Errorf("%s did not mount %s", c.config.UidShiftFuseHelper, target)
+	}
+	return nil
+}
+
+// fuseHelperArgs returns the args of the FUSE helper (bindfs) that mounts
+// source on target, uid-shifted per the given ID mappings. Only a single
+// mapping range starting at container ID 0 (as sys containers get) can be
+// expressed as the helper's ID offsets.
+func fuseHelperArgs(source, target string, uidMappings, gidMappings []configs.IDMap) ([]string, error) {
+	if len(uidMappings) != 1 || len(gidMappings) != 1 ||
+		uidMappings[0].ContainerID != 0 || gidMappings[0].ContainerID != 0 {
+		return nil, errors.New("the FUSE helper requires a single ID mapping range starting at container ID 0")
+	}
+
+	return []string{
+		"--uid-offset=" + strconv.Itoa(uidMappings[0].HostID),
+		"--gid-offset=" + strconv.Itoa(gidMappings[0].HostID),
+		"-o", "allow_other",
+		source, target,
+	}, nil
+}
+
+// sysbox-runc: teardownIdmappedMounts unmounts the container's ID-mapped (and
+// FUSE) mounts (see setupIdmappedMounts()). It must succeed before the
+// container's state dir is removed, as the mounts expose the (network) bind
+// sources.
+func (c *linuxContainer) teardownIdmappedMounts() error {
+	dir := c.idmapDir()
+
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		target := filepath.Join(dir, e.Name())
+
+		mounted, err := mount.Mounted(target)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			if err := unix.Unmount(target, unix.MNT_DETACH); err != nil {
+				return fmt.Errorf("failed to unmount id-mapped mount at %s: %v", target, err)
+			}
+		}
+	}
+
+	return nil
+}
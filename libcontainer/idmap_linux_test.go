@@ -0,0 +1,96 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/mount"
+	"golang.org/x/sys/unix"
+)
+
+func TestFuseHelperArgs(t *testing.T) {
+	uidMap := []configs.IDMap{{ContainerID: 0, HostID: 165536, Size: 65536}}
+	gidMap := []configs.IDMap{{ContainerID: 0, HostID: 231072, Size: 65536}}
+
+	args, err := fuseHelperArgs("/mnt/nfs", "/run/sysbox/c1/idmap/0", uidMap, gidMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"--uid-offset=165536", "--gid-offset=231072", "-o", "allow_other", "/mnt/nfs", "/run/sysbox/c1/idmap/0"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("want args %v, got %v", want, args)
+	}
+
+	// mappings that can't be expressed as ID offsets
+	for _, m := range [][]configs.IDMap{
+		nil,
+		{{ContainerID: 1, HostID: 165536, Size: 65536}},
+		{{ContainerID: 0, HostID: 165536, Size: 1000}, {ContainerID: 1000, HostID: 300000, Size: 1000}},
+	} {
+		if _, err := fuseHelperArgs("/mnt/nfs", "/tmp/t", m, gidMap); err == nil {
+			t.Errorf("uid mappings %v: expected error", m)
+		}
+	}
+}
+
+func TestSetupFuseMount(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "fusemount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	source := filepath.Join(tmp, "source")
+	target := filepath.Join(tmp, "target")
+	for _, dir := range []string{source, target} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// fake helpers: one that fails, and one that bind-mounts the source (its
+	// 5th arg) on the target (its 6th)
+	helper := func(name, script string) string {
+		path := filepath.Join(tmp, name)
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	c := &linuxContainer{
+		config: &configs.Config{
+			UidMappings: []configs.IDMap{{ContainerID: 0, HostID: 165536, Size: 65536}},
+			GidMappings: []configs.IDMap{{ContainerID: 0, HostID: 165536, Size: 65536}},
+		},
+	}
+
+	c.config.UidShiftFuseHelper = helper("failing", "echo 'fuse: device not found' >&2; exit 1")
+	if err := c.setupFuseMount(source, target); err == nil || !strings.Contains(err.Error(), "fuse: device not found") {
+		t.Errorf("want the helper's error, got %v", err)
+	}
+
+	c.config.UidShiftFuseHelper = helper("nomount", "exit 0")
+	if err := c.setupFuseMount(source, target); err == nil {
+		t.Error("expected error for a helper that mounts nothing")
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	c.config.UidShiftFuseHelper = helper("bind", `mount --bind "$5" "$6"`)
+	if err := c.setupFuseMount(source, target); err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Unmount(target, unix.MNT_DETACH)
+	if mounted, err := mount.Mounted(target); err != nil || !mounted {
+		t.Errorf("target not mounted (err: %v)", err)
+	}
+}
@@ -10,8 +10,11 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -49,6 +52,10 @@ type network struct {
 	// TempVethPeerName is a unique temporary veth peer name that was placed into
 	// the container's namespace.
 	TempVethPeerName string `json:"temp_veth_peer_name"`
+
+	// sysbox-runc: TempIfaceName is the unique temporary name of a macvlan or
+	// ipvlan interface placed into the container's namespace.
+	TempIfaceName string `json:"temp_iface_name,omitempty"`
 }
 
 // initConfig is used for transferring parameters from Exec() to Init()
@@ -67,12 +74,28 @@ type initConfig struct {
 	PassedFilesCount int                   `json:"passed_files_count"`
 	ContainerId      string                `json:"containerid"`
 	Rlimits          []configs.Rlimit      `json:"rlimits"`
+	Scheduler        *configs.Scheduler    `json:"scheduler,omitempty"`
+	IOPriority       *configs.IOPriority   `json:"io_priority,omitempty"`
 	CreateConsole    bool                  `json:"create_console"`
 	ConsoleWidth     uint16                `json:"console_width"`
 	ConsoleHeight    uint16                `json:"console_height"`
 	RootlessEUID     bool                  `json:"rootless_euid,omitempty"`
 	RootlessCgroups  bool                  `json:"rootless_cgroups,omitempty"`
 	SpecState        *specs.State          `json:"spec_state,omitempty"`
+
+	// sysbox-runc: seccomp filters precompiled by the parent runc (nil if
+	// compilation failed, in which case the init process compiles them).
+	SeccompProg      *seccomp.Program `json:"seccomp_prog,omitempty"`
+	SeccompNotifProg *seccomp.Program `json:"seccomp_notif_prog,omitempty"`
+
+	// sysbox-runc: the (setns) process starts in the container's cgroup, out of
+	// the sys container's cgroup root, and asks the parent runc to move it there
+	// right before exec'ing (see setnsProcess.reserveCgroupPaths).
+	EnterCgroupLate bool `json:"enter_cgroup_late,omitempty"`
+
+	// sysbox-runc: the parent runc's sync protocol version (see syncVersion);
+	// in the child, the version agreed on.
+	SyncVersion int `json:"sync_version,omitempty"`
 }
 
 type initer interface {
@@ -85,6 +108,15 @@ func newContainerInit(t initType, pipe *os.File, consoleSocket *os.File, fifoFd
 		if err := json.NewDecoder(pipe).Decode(&config); err != nil {
 			return nil, err
 		}
+		// sysbox-runc: agree on the sync protocol version with the parent runc
+		// (one that predates versions expects none to be sent).
+		peerVersion := config.SyncVersion
+		config.SyncVersion = negotiateSyncVersion(peerVersion)
+		if peerVersion >= syncVersionFramed {
+			if err := writeSyncVersion(pipe, config.SyncVersion); err != nil {
+				return nil, err
+			}
+		}
 		if err := populateProcessEnvironment(config.Env); err != nil {
 			return nil, err
 		}
@@ -105,8 +137,19 @@ func newContainerInit(t initType, pipe *os.File, consoleSocket *os.File, fifoFd
 			}, nil
 		}
 	} else if t == initMount {
-		var reqs []opReq
-		if err := json.NewDecoder(pipe).Decode(&reqs); err != nil {
+		// sysbox-runc: the parent runc sets the sync protocol version used
+		// with the helper (none if it predates versions).
+		var (
+			reqs []opReq
+			err  error
+		)
+		version, _ := strconv.Atoi(os.Getenv("_LIBCONTAINER_SYNCVERSION"))
+		if negotiateSyncVersion(version) >= syncVersionFramed {
+			reqs, err = readOpReqs(pipe)
+		} else {
+			err = json.NewDecoder(pipe).Decode(&reqs)
+		}
+		if err != nil {
 			return nil, err
 		}
 		return &linuxRootfsInit{
@@ -133,6 +176,21 @@ func populateProcessEnvironment(env []string) error {
 	return nil
 }
 
+// sysbox-runc: seccompBeforeCapsDrop returns true if the seccomp filters must
+// be loaded before the process drops its caps (in finalizeNamespace()), as
+// loading them requires CAP_SYS_ADMIN when noNewPrivileges is not set. The
+// process caps, if given, take precedence over the container's.
+func seccompBeforeCapsDrop(config *initConfig) bool {
+	if config.NoNewPrivileges {
+		return false
+	}
+	caps := config.Config.Capabilities
+	if config.Capabilities != nil {
+		caps = config.Capabilities
+	}
+	return caps == nil || !utils.StringSliceContains(caps.Effective, "CAP_SYS_ADMIN")
+}
+
 // finalizeNamespace drops the caps, sets the correct user
 // and working dir, and closes any leaked file descriptors
 // before executing the command inside the namespace
@@ -186,20 +244,21 @@ func finalizeNamespace(config *initConfig) error {
 	return nil
 }
 
-// setupConsole sets up the console from inside the container, and sends the
-// master pty fd to the config.Pipe (using cmsg). This is done to ensure that
-// consoles are scoped to a container properly (see runc#814 and the many
-// issues related to that). This has to be run *after* we've pivoted to the new
-// rootfs (and the users' configuration is entirely set up).
+// setupConsole sets up the console from inside the container, makes it the
+// controlling terminal of the process, and sends the master pty fd to the
+// config.Pipe (using cmsg). This is done to ensure that consoles are scoped to
+// a container properly (see runc#814 and the many issues related to that).
+// This has to be run *after* we've pivoted to the new rootfs (and the users'
+// configuration is entirely set up).
 func setupConsole(socket *os.File, config *initConfig, mount bool) error {
 	defer socket.Close()
 	// At this point, /dev/ptmx points to something that we would expect. We
 	// used to change the owner of the slave path, but since the /dev/pts mount
 	// can have gid=X set (at the users' option). So touching the owner of the
 	// slave PTY is not necessary, as the kernel will handle that for us. Note
-	// however, that setupUser (specifically fixStdioPermissions) *will* change
-	// the UID owner of the console to be the user the process will run as (so
-	// they can actually control their console).
+	// however, that chownConsole (sysbox-runc: rather than fixStdioPermissions)
+	// *will* change the UID owner of the console to be the user the process
+	// will run as (so they can actually control their console).
 
 	pty, slavePath, err := console.NewPty()
 	if err != nil {
@@ -209,6 +268,10 @@ func setupConsole(socket *os.File, config *initConfig, mount bool) error {
 	// After we return from here, we don't need the console anymore.
 	defer pty.Close()
 
+	if err := chownConsole(slavePath, config); err != nil {
+		return err
+	}
+
 	if config.ConsoleHeight != 0 && config.ConsoleWidth != 0 {
 		err = pty.Resize(console.WinSize{
 			Height: config.ConsoleHeight,
@@ -226,12 +289,40 @@ func setupConsole(socket *os.File, config *initConfig, mount bool) error {
 			return err
 		}
 	}
+	// Now, dup over all the things.
+	if err := dupStdio(slavePath); err != nil {
+		return err
+	}
+	// sysbox-runc: the console must be our controlling terminal by the time
+	// its master is handed out, as whoever receives it (e.g., a shim, for
+	// detached processes) may resize it right away, and the kernel only
+	// signals that (SIGWINCH) to the foreground process group of the
+	// terminal.
+	if err := system.Setctty(); err != nil {
+		return errors.Wrap(err, "setctty")
+	}
 	// While we can access console.master, using the API is a good idea.
-	if err := utils.SendFd(socket, pty.Name(), pty.Fd()); err != nil {
+	return utils.SendFd(socket, pty.Name(), pty.Fd())
+}
+
+// sysbox-runc: chownConsole chowns the given console to the process' user, as
+// fixStdioPermissions() would, but before the console is handed out. And since
+// the console was created in the container's devpts (i.e., it's owned by the
+// container's root), failing to chown it is an error rather than skipped. The
+// group is left as is (see setupConsole()).
+func chownConsole(slavePath string, config *initConfig) error {
+	execUser, err := lookupExecUser(config)
+	if err != nil {
 		return err
 	}
-	// Now, dup over all the things.
-	return dupStdio(slavePath)
+	// setupUser() reports unmapped users
+	if _, err := config.Config.HostUID(execUser.Uid); err != nil {
+		return nil
+	}
+	if err := unix.Chown(slavePath, execUser.Uid, -1); err != nil {
+		return &os.PathError{Op: "chown", Path: slavePath, Err: err}
+	}
+	return nil
 }
 
 // syncParentReady sends to the given pipe a JSON payload which indicates that
@@ -265,22 +356,56 @@ func syncParentHooks(pipe io.ReadWriter) error {
 // sys container's init process; this is useful in cases where the container's init process
 // can't do the operation because it may not have appropriate permissions.
 // See sync.go for the sync sequence.
-func syncParentDoOp(reqs []opReq, pipe io.ReadWriter) error {
-	if err := writeSync(pipe, reqOp); err != nil {
+func syncParentDoOp(reqs []opReq, pipe io.ReadWriter, syncVersion int) error {
+	ops := &opPipeline{pipe: pipe, version: syncVersion}
+	if err := ops.send(reqs); err != nil {
 		return err
 	}
-	if err := readSync(pipe, sendOpInfo); err != nil {
+	return ops.wait()
+}
+
+// sysbox-runc: opPipeline sends op requests to the parent runc without
+// waiting for each of them to be done, so that requests that don't depend on
+// each other cost a single round-trip with the parent; the parent does them
+// in order. With sync protocol version syncVersionLegacy, each request is
+// waited for as it's sent.
+type opPipeline struct {
+	pipe    io.ReadWriter
+	version int
+	pending int
+}
+
+// send sends the given requests (which must be of the same type) to the
+// parent runc.
+func (p *opPipeline) send(reqs []opReq) error {
+	if err := writeSync(p.pipe, reqOp); err != nil {
 		return err
 	}
-	if err := utils.WriteJSON(pipe, reqs); err != nil {
-		return err
+
+	if p.version < syncVersionFramed {
+		if err := readSync(p.pipe, sendOpInfo); err != nil {
+			return err
+		}
+		if err := utils.WriteJSON(p.pipe, reqs); err != nil {
+			return err
+		}
+		return readSync(p.pipe, opDone)
 	}
-	if err := readSync(pipe, opDone); err != nil {
+
+	if err := writeOpReqs(p.pipe, reqs); err != nil {
 		return err
 	}
+	p.pending++
 	return nil
 }
 
+// wait waits for the parent runc to be done with the requests sent.
+func (p *opPipeline) wait() error {
+	n := p.pending
+	p.pending = 0
+	return readSyncs(p.pipe, opDone, n)
+}
+
 // sysbox-runc:
 // syncParentSeccompFd sends a seccomp notification file-descriptor to the parent runc.
 func syncParentSeccompFd(fd int32, pipe *os.File) error {
@@ -318,8 +443,8 @@ func syncParentRootfsReady(pipe *os.File) error {
 	return readSync(pipe, rootfsReadyAck)
 }
 
-// setupUser changes the groups, gid, and uid for the user inside the container
-func setupUser(config *initConfig) error {
+// lookupExecUser looks up the user the process runs as inside the container.
+func lookupExecUser(config *initConfig) (*user.ExecUser, error) {
 	// Set up defaults.
 	defaultExecUser := user.ExecUser{
 		Uid:  0,
@@ -329,15 +454,25 @@ func setupUser(config *initConfig) error {
 
 	passwdPath, err := user.GetPasswdPath()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	groupPath, err := user.GetGroupPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return user.GetExecUserPath(config.User, &defaultExecUser, passwdPath, groupPath)
+}
+
+// setupUser changes the groups, gid, and uid for the user inside the container
+func setupUser(config *initConfig) error {
+	execUser, err := lookupExecUser(config)
 	if err != nil {
 		return err
 	}
 
-	execUser, err := user.GetExecUserPath(config.User, &defaultExecUser, passwdPath, groupPath)
+	groupPath, err := user.GetGroupPath()
 	if err != nil {
 		return err
 	}
@@ -509,6 +644,37 @@ func setupRlimits(limits []configs.Rlimit, pid int) error {
 	return nil
 }
 
+// sysbox-runc: setupScheduling applies the given scheduling attributes to the
+// process with the given pid. Like the rlimits, this must be done from the
+// parent runc, as raising the priorities requires CAP_SYS_NICE in the initial
+// user-ns.
+func setupScheduling(sched *configs.Scheduler, ioprio *configs.IOPriority, pid int) error {
+	if sched != nil {
+		attr, err := configs.ToSchedAttr(sched)
+		if err != nil {
+			return err
+		}
+		_, _, errno := unix.Syscall(unix.SYS_SCHED_SETATTR, uintptr(pid), uintptr(unsafe.Pointer(attr)), 0)
+		if errno != 0 {
+			return fmt.Errorf("error setting scheduler attributes: %v", errno)
+		}
+	}
+	if ioprio != nil {
+		prio, err := configs.ToIOPrio(ioprio)
+		if err != nil {
+			return err
+		}
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio))
+		if errno != 0 {
+			return fmt.Errorf("error setting I/O priority: %v", errno)
+		}
+	}
+	return nil
+}
+
+// ioprio_set(2) "which" argument for a single process
+const ioprioWhoProcess = 1
+
 const _P_PID = 1
 
 //nolint:structcheck,unused
@@ -555,11 +721,41 @@ func isNoChildren(err error) bool {
 // For all other signals it will check if the process is ready to report its
 // exit status and only if it is will a wait be performed.
 func signalAllProcesses(m cgroups.Manager, s os.Signal) error {
+	return signalProcesses(m, m.GetAllPids, s)
+}
+
+// sysbox-runc: signalCgroupProcesses is like signalAllProcesses, but signals
+// only the processes in the given cgroup paths (i.e., a sub-cgroup of the
+// container, with a path per controller) and their descendants.
+func signalCgroupProcesses(m cgroups.Manager, paths map[string]string, s os.Signal) error {
+	getPids := func() ([]int, error) {
+		seen := map[int]bool{}
+		pids := []int{}
+		for _, path := range paths {
+			cgPids, err := cgroups.GetAllPids(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, pid := range cgPids {
+				if !seen[pid] {
+					seen[pid] = true
+					pids = append(pids, pid)
+				}
+			}
+		}
+		return pids, nil
+	}
+	return signalProcesses(m, getPids, s)
+}
+
+// signalProcesses signals the processes returned by getPids, with the
+// container's cgroup (per the given manager) frozen meanwhile.
+func signalProcesses(m cgroups.Manager, getPids func() ([]int, error), s os.Signal) error {
 	var procs []*os.Process
 	if err := m.Freeze(configs.Frozen); err != nil {
 		logrus.Warn(err)
 	}
-	pids, err := m.GetAllPids()
+	pids, err := getPids()
 	if err != nil {
 		if err := m.Freeze(configs.Thawed); err != nil {
 			logrus.Warn(err)
@@ -620,13 +816,161 @@ func signalAllProcesses(m cgroups.Manager, s os.Signal) error {
 	return nil
 }
 
+// sysbox-runc: killAllProcesses kills all the processes in the container's
+// cgroup, including those in its child cgroup (see
+// cgroups.Manager.CreateChildCgroup()) and below, with the cgroup frozen
+// meanwhile. It's used when the container shares the host's pid ns, where the
+// processes don't die along with the container's init.
+//
+// It races with the processes exiting and the cgroup being removed (e.g., by
+// systemd once it's empty), so processes already gone (or zombies) and a
+// removed cgroup are not errors. As processes may fork while the cgroup can't
+// be frozen, it looks for processes again (up to killRetries times) until
+// there are none left; it returns an error if some are.
+func killAllProcesses(m cgroups.Manager) error {
+	return killProcesses(m, func() ([]int, error) {
+		return containerCgroupPids(m)
+	})
+}
+
+const (
+	killRetries    = 10
+	killRetryDelay = 20 * time.Millisecond
+)
+
+// killProcesses kills the processes returned by getPids, as described in
+// killAllProcesses().
+func killProcesses(m cgroups.Manager, getPids func() ([]int, error)) error {
+	subreaper, err := system.GetSubreaper()
+	if err != nil {
+		// see signalProcesses()
+		subreaper = 0
+	}
+
+	// reap reaps the given process if it has exited and is our child (see
+	// signalProcesses() on why not if we are a subreaper); it's reaped by
+	// whoever is its parent otherwise.
+	reap := func(pid int) {
+		if subreaper == 0 {
+			var ws unix.WaitStatus
+			_, _ = unix.Wait4(pid, &ws, unix.WNOHANG, nil)
+		}
+	}
+
+	var left []int
+	for i := 0; i < killRetries; i++ {
+		if i > 0 {
+			time.Sleep(killRetryDelay)
+		}
+
+		frozen := true
+		if err := m.Freeze(configs.Frozen); err != nil {
+			logrus.Debugf("killing container processes without freezing them: %v", err)
+			frozen = false
+		}
+
+		pids, err := getPids()
+
+		left = left[:0]
+		if err == nil {
+			for _, pid := range pids {
+				if !processAlive(pid) {
+					reap(pid)
+					continue
+				}
+				if err := unix.Kill(pid, unix.SIGKILL); err != nil && err != unix.ESRCH {
+					logrus.Warnf("killing process %d: %v", pid, err)
+				}
+				left = append(left, pid)
+			}
+		}
+
+		if frozen {
+			if err := m.Freeze(configs.Thawed); err != nil && m.Exists() {
+				logrus.Warn(err)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, pid := range left {
+			reap(pid)
+		}
+
+		if len(left) == 0 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("processes %v still in the container's cgroup after killing them %d times", left, killRetries)
+}
+
+// processAlive returns true if the process with the given pid exists and is
+// not a zombie.
+func processAlive(pid int) bool {
+	stat, err := system.Stat(pid)
+	if err != nil {
+		return false
+	}
+	return stat.State != system.Zombie && stat.State != system.Dead
+}
+
+// containerCgroupPids returns the pids in the container's cgroup and all its
+// sub-cgroups (including the child cgroup). Unlike cgroups.GetAllPids(),
+// cgroups removed meanwhile are skipped rather than failing it.
+func containerCgroupPids(m cgroups.Manager) ([]int, error) {
+	paths := m.GetPaths()
+	path := paths["devices"]
+	if cgroups.IsCgroup2UnifiedMode() {
+		path = paths[""]
+	}
+	if path == "" {
+		return m.GetAllPids()
+	}
+
+	var pids []int
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		dirPids, err := cgroups.GetPids(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		pids = append(pids, dirPids...)
+		return nil
+	})
+
+	return pids, err
+}
+
+// loadSeccomp loads the given seccomp filter, using the program precompiled by
+// the parent runc if present.
+func loadSeccomp(config *configs.Seccomp, prog *seccomp.Program) (int32, error) {
+	if prog != nil {
+		return seccomp.LoadProgram(prog)
+	}
+	return seccomp.LoadSeccomp(config)
+}
+
 // setupSyscallTraps sets up syscall trapping for the calling process, using seccomp.
 func setupSyscallTraps(config *initConfig, pipe *os.File) error {
 
 	// Load the seccomp notification filter here (for syscall trapping inside the container)
 	if config.Config.SeccompNotif != nil && len(config.Config.SeccompNotif.Syscalls) > 0 {
 
-		fd, err := seccomp.LoadSeccomp(config.Config.SeccompNotif)
+		fd, err := loadSeccomp(config.Config.SeccompNotif, config.SeccompNotifProg)
 		if err != nil {
 			return newSystemErrorWithCause(err, "loading seccomp notification rules")
 		}
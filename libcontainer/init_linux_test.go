@@ -0,0 +1,163 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/containerd/console"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"golang.org/x/sys/unix"
+)
+
+func TestContainerCgroupPids(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the container's cgroup, its child cgroup and a sub-cgroup of it
+	for path, procs := range map[string]string{
+		"":                        "10\n",
+		"syscont-cgroup-root":     "20\n21\n",
+		"syscont-cgroup-root/sub": "30\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, path, "cgroup.procs"), []byte(procs), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a sub-cgroup removed meanwhile (i.e., with no procs file)
+	if err := os.Mkdir(filepath.Join(dir, "gone"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockCgroupManager{paths: map[string]string{"devices": dir, "": dir}}
+	pids, err := containerCgroupPids(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(pids)
+	if len(pids) != 4 || pids[0] != 10 || pids[1] != 20 || pids[2] != 21 || pids[3] != 30 {
+		t.Errorf("got pids %v, want [10 20 21 30]", pids)
+	}
+
+	// a removed cgroup has no pids
+	m.paths = map[string]string{"devices": filepath.Join(dir, "none"), "": filepath.Join(dir, "none")}
+	pids, err = containerCgroupPids(m)
+	if err != nil || len(pids) != 0 {
+		t.Errorf("got pids %v, err %v for a removed cgroup", pids, err)
+	}
+}
+
+func TestKillProcesses(t *testing.T) {
+	var pids []int
+	for i := 0; i < 3; i++ {
+		cmd := exec.Command("sleep", "100")
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		pids = append(pids, cmd.Process.Pid)
+	}
+
+	// pids that are already gone are fine
+	getPids := func() ([]int, error) {
+		return append([]int{1 << 22}, pids...), nil
+	}
+
+	if err := killProcesses(&mockCgroupManager{}, getPids); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pid := range pids {
+		if err := unix.Kill(pid, 0); err != unix.ESRCH {
+			t.Errorf("process %d not killed and reaped (%v)", pid, err)
+		}
+	}
+}
+
+func TestSeccompBeforeCapsDrop(t *testing.T) {
+	admin := &configs.Capabilities{Effective: []string{"CAP_CHOWN", "CAP_SYS_ADMIN"}}
+	noAdmin := &configs.Capabilities{Effective: []string{"CAP_CHOWN"}}
+
+	tests := []struct {
+		nnp                bool
+		procCaps, cntrCaps *configs.Capabilities
+		want               bool
+	}{
+		{false, nil, admin, false},
+		{false, nil, noAdmin, true},
+		{false, nil, nil, true},
+		// the process caps take precedence
+		{false, admin, noAdmin, false},
+		{false, noAdmin, admin, true},
+		// with noNewPrivileges, the filters can be loaded without CAP_SYS_ADMIN
+		{true, noAdmin, noAdmin, false},
+		{true, nil, noAdmin, false},
+	}
+
+	for i, test := range tests {
+		config := &initConfig{
+			NoNewPrivileges: test.nnp,
+			Capabilities:    test.procCaps,
+			Config:          &configs.Config{Capabilities: test.cntrCaps},
+		}
+		if got := seccompBeforeCapsDrop(config); got != test.want {
+			t.Errorf("test %d: want %v, got %v", i, test.want, got)
+		}
+	}
+}
+
+func TestChownConsole(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	userns := &configs.Config{
+		Namespaces:  configs.Namespaces{{Type: configs.NEWUSER}},
+		UidMappings: []configs.IDMap{{ContainerID: 0, HostID: 100000, Size: 1000}},
+	}
+
+	tests := []struct {
+		user   string
+		config *configs.Config
+		want   int
+	}{
+		{"", &configs.Config{}, 0},
+		{"1000:100", &configs.Config{}, 1000},
+		{"1000", &configs.Config{}, 1000},
+		// unmapped users are left to setupUser()
+		{"1000", userns, 0},
+	}
+
+	for i, test := range tests {
+		pty, slavePath, err := console.NewPty()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config := &initConfig{User: test.user, Config: test.config}
+		if err := chownConsole(slavePath, config); err != nil {
+			t.Errorf("test %d: %v", i, err)
+		}
+
+		var st unix.Stat_t
+		if err := unix.Stat(slavePath, &st); err != nil {
+			pty.Close()
+			t.Fatal(err)
+		}
+		if int(st.Uid) != test.want {
+			t.Errorf("test %d: want console owner %d, got %d", i, test.want, st.Uid)
+		}
+
+		pty.Close()
+	}
+}
@@ -0,0 +1,189 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/cgroups/fs"
+	"github.com/opencontainers/runc/libcontainer/cgroups/fs2"
+)
+
+// sysbox-runc: controllers whose hierarchy is walked to find the inner cgroups
+// of a container on cgroup v1, by preference (they're the ones the inner
+// cgroup managers, e.g., systemd and docker, always create cgroups in).
+var innerCgroupCtrls = []string{"memory", "pids", "cpu"}
+
+// sysbox-runc: InnerCgroups returns the cgroups created within the container
+// (e.g., by its systemd or docker engine), as paths relative to the
+// container's cgroup root (as seen inside the container), sorted.
+func (c *linuxContainer) InnerCgroups() ([]string, error) {
+	status, err := c.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status != Running && status != Created && status != Paused {
+		return nil, newGenericError(errors.New("container not running"), ContainerNotRunning)
+	}
+
+	rootPaths := c.cgroupManager.GetChildCgroupPaths()
+	root, ok := rootPaths[""]
+	for _, ctrl := range innerCgroupCtrls {
+		if ok {
+			break
+		}
+		root, ok = rootPaths[ctrl]
+	}
+	if !ok {
+		return nil, newGenericError(errors.New("no cgroup to find the inner cgroups in"), SystemError)
+	}
+
+	inner, err := listInnerCgroups(root)
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "listing inner cgroups")
+	}
+	return inner, nil
+}
+
+// sysbox-runc: InnerCgroupStats returns the stats of the given inner cgroup of
+// the container (see InnerCgroups()).
+func (c *linuxContainer) InnerCgroupStats(cgroup string) (*Stats, error) {
+	paths, err := innerCgroupPaths(c.cgroupManager.GetChildCgroupPaths(), cgroup)
+	if err != nil {
+		return nil, newGenericError(err, ConfigInvalid)
+	}
+
+	var m cgroups.Manager
+	if cgroups.IsCgroup2UnifiedMode() {
+		m, err = fs2.NewManager(c.config.Cgroups, paths[""], c.config.RootlessCgroups)
+		if err != nil {
+			return nil, newSystemErrorWithCause(err, "getting inner cgroup stats")
+		}
+	} else {
+		m = fs.NewManager(c.config.Cgroups, paths, c.config.RootlessCgroups)
+	}
+
+	stats := &Stats{}
+	if stats.CgroupStats, err = m.GetStats(); err != nil {
+		return nil, newSystemErrorWithCausef(err, "getting stats of inner cgroup %s", cgroup)
+	}
+	return stats, nil
+}
+
+// sysbox-runc: OOMKills returns the number of processes OOM killed in each
+// cgroup of the container: "/" for its cgroup root and, for its inner cgroups,
+// their paths (see InnerCgroups()). Where the kernel provides them (cgroup v2
+// on kernels 5.2+, cgroup v1), the counts are local to each cgroup, so that
+// the kills are attributed to the (nested) cgroup they occurred in; otherwise
+// they include those of the cgroup's descendants.
+func (c *linuxContainer) OOMKills() (map[string]uint64, error) {
+	status, err := c.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status != Running && status != Created && status != Paused {
+		return nil, newGenericError(errors.New("container not running"), ContainerNotRunning)
+	}
+
+	rootPaths := c.cgroupManager.GetChildCgroupPaths()
+	root, ok := rootPaths[""]
+	if !ok {
+		root, ok = rootPaths["memory"]
+	}
+	if !ok {
+		return nil, newGenericError(errors.New("no memory cgroup to get the oom kills from"), SystemError)
+	}
+
+	kills, err := readOOMKills(root)
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "getting oom kills")
+	}
+	return kills, nil
+}
+
+// oomKillFiles are the files with the oom kill count of a cgroup, by
+// preference: local to the cgroup on cgroup v2, hierarchical on cgroup v2
+// kernels lacking the local one, and cgroup v1's (local).
+var oomKillFiles = []string{"memory.events.local", "memory.events", "memory.oom_control"}
+
+// readOOMKills returns the oom kill count of the given cgroup dir ("/") and of
+// the cgroups below it. Cgroups lacking the count (e.g., without the memory
+// controller) or removed meanwhile are skipped.
+func readOOMKills(root string) (map[string]uint64, error) {
+	inner, err := listInnerCgroups(root)
+	if err != nil {
+		return nil, err
+	}
+
+	kills := make(map[string]uint64, len(inner)+1)
+	for _, cg := range append([]string{"/"}, inner...) {
+		dir := filepath.Join(root, cg)
+		for _, file := range oomKillFiles {
+			n, err := getValueFromCgroup(filepath.Join(dir, file), "oom_kill")
+			if err != nil {
+				continue
+			}
+			kills[cg] = uint64(n)
+			break
+		}
+	}
+	return kills, nil
+}
+
+// listInnerCgroups returns the cgroups below the given cgroup dir, relative to
+// it (as absolute paths, e.g., "/docker/<id>"). Cgroups removed while walking
+// are skipped.
+func listInnerCgroups(root string) ([]string, error) {
+	inner := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path != root {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() || path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		inner = append(inner, "/"+rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(inner)
+	return inner, nil
+}
+
+// innerCgroupPaths returns the paths of the given inner cgroup, per
+// controller, given those of the container's cgroup root. On cgroup v1, the
+// controllers lacking the cgroup are skipped (inner cgroup managers need not
+// create their cgroups in all of them).
+func innerCgroupPaths(rootPaths map[string]string, cgroup string) (map[string]string, error) {
+	sub := filepath.Clean("/" + cgroup)
+	if sub == "/" {
+		return nil, fmt.Errorf("invalid inner cgroup %q", cgroup)
+	}
+
+	paths := make(map[string]string, len(rootPaths))
+	for ctrl, root := range rootPaths {
+		path := filepath.Join(root, sub)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		paths[ctrl] = path
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("invalid inner cgroup %q: not found", cgroup)
+	}
+	return paths, nil
+}
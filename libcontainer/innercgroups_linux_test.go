@@ -0,0 +1,113 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListInnerCgroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, path := range []string{"init.scope", "docker/c1", "docker/c2", "system.slice"} {
+		if err := os.MkdirAll(filepath.Join(dir, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker", "cgroup.procs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := listInnerCgroups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/docker", "/docker/c1", "/docker/c2", "/init.scope", "/system.slice"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := listInnerCgroups(filepath.Join(dir, "none")); err == nil {
+		t.Error("expected error listing a missing cgroup")
+	}
+}
+
+func TestInnerCgroupPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the inner cgroup is only in the memory hierarchy
+	rootPaths := map[string]string{
+		"memory": filepath.Join(dir, "memory"),
+		"pids":   filepath.Join(dir, "pids"),
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "memory", "docker", "c1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "pids"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := innerCgroupPaths(rootPaths, "docker/c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"memory": filepath.Join(dir, "memory", "docker", "c1")}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+
+	for _, cgroup := range []string{"/", "", "../..", "docker/none"} {
+		if _, err := innerCgroupPaths(rootPaths, cgroup); err == nil {
+			t.Errorf("expected error for inner cgroup %q", cgroup)
+		}
+	}
+}
+
+func TestReadOOMKills(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the local count is preferred; cgroups without a count are skipped
+	files := map[string]string{
+		"memory.events":                 "low 0\nhigh 0\nmax 4\noom 3\noom_kill 3\n",
+		"memory.events.local":           "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n",
+		"docker/memory.events":          "oom 3\noom_kill 3\n",
+		"docker/c1/memory.events":       "oom 2\noom_kill 2\n",
+		"docker/c1/memory.events.local": "oom 2\noom_kill 2\n",
+		"docker/c2/memory.oom_control":  "oom_kill_disable 0\nunder_oom 0\noom_kill 1\n",
+		"init.scope/cgroup.procs":       "1\n",
+	}
+	for path, data := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := readOOMKills(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint64{"/": 0, "/docker": 3, "/docker/c1": 2, "/docker/c2": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
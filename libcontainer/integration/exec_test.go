@@ -1311,6 +1311,84 @@ func TestHook(t *testing.T) {
 	}
 }
 
+// sysbox-runc: the hooks see the container's init process in its child cgroup
+// (the cgroup root seen from within the container), with cgroup v1 and v2.
+func TestHookCgroup(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+
+	rootfs, err := newRootfs()
+	ok(t, err)
+	defer remove(rootfs)
+
+	config := newTemplateConfig(&tParam{rootfs: rootfs})
+
+	var prestartCgroup string
+	config.Hooks = configs.Hooks{
+		configs.Prestart: configs.HookList{
+			configs.NewFunctionHook(func(s *specs.State) error {
+				data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", s.Pid))
+				prestartCgroup = string(data)
+				return err
+			}),
+		},
+		configs.CreateContainer: configs.HookList{
+			configs.NewCommandHook(configs.Command{
+				Path: "/bin/sh",
+				Args: []string{"/bin/sh", "-c", "cat /proc/self/cgroup > ./createContainer"},
+			}),
+		},
+		configs.StartContainer: configs.HookList{
+			configs.NewCommandHook(configs.Command{
+				Path: "/bin/sh",
+				Args: []string{"/bin/sh", "-c", "cat /proc/self/cgroup > /startContainer"},
+			}),
+		},
+	}
+
+	container, err := newContainerWithName("test", config)
+	ok(t, err)
+	defer container.Destroy()
+
+	var stdout bytes.Buffer
+	pconfig := libcontainer.Process{
+		Cwd:    "/",
+		Args:   []string{"cat", "/proc/self/cgroup"},
+		Env:    standardEnvironment,
+		Stdout: &stdout,
+		Init:   true,
+	}
+	err = container.Run(&pconfig)
+	ok(t, err)
+	waitProcess(&pconfig, t)
+
+	want := stdout.String()
+	for _, hook := range []string{"createContainer", "startContainer"} {
+		got, err := ioutil.ReadFile(filepath.Join(rootfs, hook))
+		ok(t, err)
+		if string(got) != want {
+			t.Errorf("%s hook: expected cgroups %q, got %q", hook, want, got)
+		}
+	}
+
+	// the prestart hook sees the host's view of the init process' cgroups
+	host := strings.Split(strings.TrimSpace(prestartCgroup), "\n")
+	cont := strings.Split(strings.TrimSpace(want), "\n")
+	if len(host) != len(cont) {
+		t.Fatalf("prestart hook: expected cgroups matching %q, got %q", want, prestartCgroup)
+	}
+	for i := range cont {
+		// <id>:<controllers>:<path>, with the path relative to the cgroup ns root
+		// within the container
+		c := strings.SplitN(cont[i], ":", 3)
+		h := strings.SplitN(host[i], ":", 3)
+		if len(c) != 3 || len(h) != 3 || c[1] != h[1] || !strings.HasSuffix(h[2], strings.TrimSuffix(c[2], "/")) {
+			t.Errorf("prestart hook: expected cgroup matching %q, got %q", cont[i], host[i])
+		}
+	}
+}
+
 func TestSTDIOPermissions(t *testing.T) {
 	if testing.Short() {
 		return
@@ -0,0 +1,145 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: file and dir (in the container's state dir) shown as the
+// container's /proc/modules and /sys/module when its view of the host's kernel
+// modules is restricted.
+const (
+	procModulesFile = "modules"
+	sysModuleDir    = "sys-module"
+)
+
+// sysbox-runc: setupKernelModules restricts the container's view of the host's
+// kernel modules (see configs.KernelModules), by bind-mounting over its
+// /proc/modules a read-only file holding the host's /proc/modules entries of
+// the allowed modules, and over its /sys/module a read-only dir holding the
+// host's /sys/module dirs of those modules (bind-mounted read-only too).
+func (c *linuxContainer) setupKernelModules() error {
+	km := c.config.KernelModules
+	if km == nil {
+		return nil
+	}
+
+	uid, err := c.config.HostRootUID()
+	if err != nil {
+		return err
+	}
+	gid, err := c.config.HostRootGID()
+	if err != nil {
+		return err
+	}
+
+	allowed := map[string]bool{}
+	for _, m := range km.Allowed {
+		allowed[m] = true
+	}
+
+	data, err := ioutil.ReadFile("/proc/modules")
+	if err != nil {
+		return newSystemErrorWithCause(err, "reading /proc/modules")
+	}
+
+	var modules bytes.Buffer
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 0 && allowed[fields[0]] {
+			modules.WriteString(s.Text() + "\n")
+		}
+	}
+
+	path := filepath.Join(c.root, procModulesFile)
+	if err := ioutil.WriteFile(path, modules.Bytes(), 0444); err != nil {
+		return newSystemErrorWithCause(err, "writing modules file")
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return newSystemErrorWithCause(err, "setting ownership of modules file")
+	}
+
+	dir := filepath.Join(c.root, sysModuleDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return newSystemErrorWithCause(err, "removing module dir")
+	}
+	if err := os.Mkdir(dir, 0555); err != nil {
+		return newSystemErrorWithCause(err, "creating module dir")
+	}
+	if err := os.Chown(dir, uid, gid); err != nil {
+		return newSystemErrorWithCause(err, "setting ownership of module dir")
+	}
+
+	bindInfo := configs.BindSrcInfo{IsDir: false, Uid: uint32(uid), Gid: uint32(gid)}
+	dirInfo := configs.BindSrcInfo{IsDir: true, Uid: uint32(uid), Gid: uint32(gid)}
+
+	mounts := []*configs.Mount{
+		{
+			Source:           path,
+			Destination:      "/proc/modules",
+			Device:           "bind",
+			Flags:            unix.MS_BIND | unix.MS_RDONLY,
+			PropagationFlags: []int{unix.MS_PRIVATE},
+			BindSrcInfo:      bindInfo,
+		},
+		{
+			Source:           dir,
+			Destination:      "/sys/module",
+			Device:           "bind",
+			Flags:            unix.MS_BIND | unix.MS_RDONLY,
+			PropagationFlags: []int{unix.MS_PRIVATE},
+			BindSrcInfo:      dirInfo,
+		},
+	}
+
+	// Modules built into the kernel have a /sys/module dir too (but no
+	// /proc/modules entry).
+	for _, m := range km.Allowed {
+		src := filepath.Join("/sys/module", m)
+		var st unix.Stat_t
+		if err := unix.Stat(src, &st); err != nil {
+			if err == unix.ENOENT {
+				continue
+			}
+			return newSystemErrorWithCause(err, "checking module dir")
+		}
+
+		if err := os.Mkdir(filepath.Join(dir, m), 0555); err != nil {
+			return newSystemErrorWithCause(err, "creating module dir")
+		}
+
+		mounts = append(mounts, &configs.Mount{
+			Source:           src,
+			Destination:      src,
+			Device:           "bind",
+			Flags:            unix.MS_BIND | unix.MS_REC | unix.MS_RDONLY,
+			PropagationFlags: []int{unix.MS_PRIVATE},
+			BindSrcInfo:      configs.BindSrcInfo{IsDir: true, Uid: st.Uid, Gid: st.Gid},
+		})
+	}
+
+	// The mounts must precede any other mount under /sys/module (e.g., the
+	// sysbox-fs mounts of module parameters), which would be hidden otherwise.
+	idx := len(c.config.Mounts)
+	for i, m := range c.config.Mounts {
+		if strings.HasPrefix(filepath.Clean(m.Destination), "/sys/module/") {
+			idx = i
+			break
+		}
+	}
+
+	rest := append(mounts, c.config.Mounts[idx:]...)
+	c.config.Mounts = append(c.config.Mounts[:idx], rest...)
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -24,6 +25,45 @@ type Config struct {
 	LogFormat   string
 	LogFilePath string
 	LogPipeFd   string
+
+	// sysbox-runc: Phase and ContainerID are added to the log records of a
+	// child process sent through the log pipe (see ForwardLogs()).
+	Phase       string
+	ContainerID string
+}
+
+// sysbox-runc: fields of the log records the container's child processes (its
+// nsexec stages and its init and helper processes) send through the log pipe:
+// each record is a JSON object on a line of its own, with its level, message,
+// phase (e.g., "nsexec-1" or "init") and container id, plus any other field
+// the child logs (see ForwardLogs()).
+const (
+	LevelField = "level"
+	MsgField   = "msg"
+	TimeField  = "time"
+	PhaseField = "phase"
+	IDField    = "id"
+)
+
+// sysbox-runc: childHook adds the phase and container id to the log records
+// of a child process.
+type childHook struct {
+	phase string
+	id    string
+}
+
+func (h *childHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *childHook) Fire(entry *logrus.Entry) error {
+	if h.phase != "" {
+		entry.Data[PhaseField] = h.phase
+	}
+	if h.id != "" {
+		entry.Data[IDField] = h.id
+	}
+	return nil
 }
 
 func ForwardLogs(logPipe io.Reader) {
@@ -43,24 +83,39 @@ func ForwardLogs(logPipe io.Reader) {
 	}
 }
 
+// processEntry re-emits the given log record of a child process through
+// logrus, with its level, time and fields.
 func processEntry(text []byte) {
-	type jsonLog struct {
-		Level string `json:"level"`
-		Msg   string `json:"msg"`
-	}
-
-	var jl jsonLog
-	if err := json.Unmarshal(text, &jl); err != nil {
+	var fields logrus.Fields
+	if err := json.Unmarshal(text, &fields); err != nil {
 		logrus.Errorf("failed to decode %q to json: %+v", text, err)
 		return
 	}
 
-	lvl, err := logrus.ParseLevel(jl.Level)
+	level, _ := fields[LevelField].(string)
+	lvl, err := logrus.ParseLevel(level)
 	if err != nil {
-		logrus.Errorf("failed to parse log level %q: %v\n", jl.Level, err)
+		logrus.Errorf("failed to parse log level %q: %v\n", level, err)
 		return
 	}
-	logrus.StandardLogger().Logf(lvl, jl.Msg)
+	// the child's panic must not panic us
+	if lvl == logrus.PanicLevel {
+		lvl = logrus.FatalLevel
+	}
+	msg, _ := fields[MsgField].(string)
+
+	entry := logrus.NewEntry(logrus.StandardLogger())
+	if ts, ok := fields[TimeField].(string); ok {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			entry = entry.WithTime(t)
+		}
+	}
+	for _, f := range []string{LevelField, MsgField, TimeField} {
+		delete(fields, f)
+	}
+
+	// unlike Logf, the message isn't taken as a format
+	entry.WithFields(fields).Log(lvl, msg)
 }
 
 func ConfigureLogging(config Config) error {
@@ -80,6 +135,10 @@ func ConfigureLogging(config Config) error {
 			return fmt.Errorf("failed to convert _LIBCONTAINER_LOGPIPE environment variable value %q to int: %v", config.LogPipeFd, err)
 		}
 		logrus.SetOutput(os.NewFile(uintptr(logPipeFdInt), "logpipe"))
+
+		if config.Phase != "" || config.ContainerID != "" {
+			logrus.AddHook(&childHook{phase: config.Phase, id: config.ContainerID})
+		}
 	} else if config.LogFilePath != "" {
 		f, err := os.OpenFile(config.LogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_SYNC, 0644)
 		if err != nil {
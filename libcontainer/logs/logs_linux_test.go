@@ -158,3 +158,32 @@ func truncateLogFile(t *testing.T, logFile string) {
 		t.Fatalf("failed to truncate log file: %v", err)
 	}
 }
+
+func TestLogForwardingPreservesFields(t *testing.T) {
+	logW, logFile, _ := runLogForwarding(t)
+	defer os.Remove(logFile)
+	defer logW.Close()
+
+	logToLogWriter(t, logW, `{"level":"warning","msg":"100% kitten","phase":"nsexec-1","id":"c1","error":"no puppy"}`)
+
+	logFileContent := waitForLogContent(t, logFile)
+	for _, s := range []string{`"level":"warning"`, `"msg":"100% kitten"`, `"phase":"nsexec-1"`, `"id":"c1"`, `"error":"no puppy"`} {
+		if !strings.Contains(logFileContent, s) {
+			t.Fatalf("%q does not contain %s", logFileContent, s)
+		}
+	}
+}
+
+func TestLogForwardingDropsRecordsBelowLevel(t *testing.T) {
+	logW, logFile, _ := runLogForwarding(t)
+	defer os.Remove(logFile)
+	defer logW.Close()
+
+	logToLogWriter(t, logW, `{"level":"debug","msg":"kitten"}`)
+	logToLogWriter(t, logW, `{"level":"info","msg":"puppy"}`)
+
+	logFileContent := waitForLogContent(t, logFile)
+	if strings.Contains(logFileContent, "kitten") || !strings.Contains(logFileContent, "puppy") {
+		t.Fatalf("%q: expected puppy without kitten", logFileContent)
+	}
+}
@@ -4,8 +4,11 @@ package libcontainer
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"path/filepath"
 	"strconv"
 
@@ -16,6 +19,8 @@ import (
 
 var strategies = map[string]networkStrategy{
 	"loopback": &loopback{},
+	"macvlan":  &macvlan{},
+	"ipvlan":   &ipvlan{},
 }
 
 // networkStrategy represents a specific network configuration for
@@ -101,3 +106,198 @@ func (l *loopback) attach(n *configs.Network) (err error) {
 func (l *loopback) detach(n *configs.Network) (err error) {
 	return nil
 }
+
+// sysbox-runc: macvlan is a network strategy that attaches the container to
+// the network of a host interface (its parent) via a macvlan interface, i.e.,
+// with its own MAC address.
+type macvlan struct {
+}
+
+var macvlanModes = map[string]netlink.MacvlanMode{
+	"":         netlink.MACVLAN_MODE_BRIDGE,
+	"bridge":   netlink.MACVLAN_MODE_BRIDGE,
+	"vepa":     netlink.MACVLAN_MODE_VEPA,
+	"private":  netlink.MACVLAN_MODE_PRIVATE,
+	"passthru": netlink.MACVLAN_MODE_PASSTHRU,
+}
+
+func (v *macvlan) create(n *network, nspid int) error {
+	mode, ok := macvlanModes[n.Mode]
+	if !ok {
+		return fmt.Errorf("invalid macvlan mode %q", n.Mode)
+	}
+	attrs, err := subIfaceAttrs(n)
+	if err != nil {
+		return err
+	}
+	if n.MacAddress != "" {
+		if attrs.HardwareAddr, err = net.ParseMAC(n.MacAddress); err != nil {
+			return err
+		}
+	}
+	return createSubIface(n, &netlink.Macvlan{LinkAttrs: attrs, Mode: mode}, nspid)
+}
+
+func (v *macvlan) initialize(n *network) error {
+	return initSubIface(n)
+}
+
+func (v *macvlan) attach(n *configs.Network) error {
+	return nil
+}
+
+func (v *macvlan) detach(n *configs.Network) error {
+	return nil
+}
+
+// sysbox-runc: ipvlan is a network strategy that attaches the container to
+// the network of a host interface (its parent) via an ipvlan interface, i.e.,
+// sharing the parent's MAC address (for networks that limit the MAC addresses
+// per port).
+type ipvlan struct {
+}
+
+var ipvlanModes = map[string]netlink.IPVlanMode{
+	"":    netlink.IPVLAN_MODE_L2,
+	"l2":  netlink.IPVLAN_MODE_L2,
+	"l3":  netlink.IPVLAN_MODE_L3,
+	"l3s": netlink.IPVLAN_MODE_L3S,
+}
+
+func (v *ipvlan) create(n *network, nspid int) error {
+	mode, ok := ipvlanModes[n.Mode]
+	if !ok {
+		return fmt.Errorf("invalid ipvlan mode %q", n.Mode)
+	}
+	if n.MacAddress != "" {
+		return fmt.Errorf("ipvlan interface %s can't have a MAC address (it's the parent's)", n.Name)
+	}
+	attrs, err := subIfaceAttrs(n)
+	if err != nil {
+		return err
+	}
+	return createSubIface(n, &netlink.IPVlan{LinkAttrs: attrs, Mode: mode}, nspid)
+}
+
+func (v *ipvlan) initialize(n *network) error {
+	return initSubIface(n)
+}
+
+func (v *ipvlan) attach(n *configs.Network) error {
+	return nil
+}
+
+func (v *ipvlan) detach(n *configs.Network) error {
+	return nil
+}
+
+// subIfaceAttrs returns the link attributes of the macvlan or ipvlan interface
+// of the given network, which gets a unique temporary name, as it's created
+// in the host's network namespace.
+func subIfaceAttrs(n *network) (netlink.LinkAttrs, error) {
+	attrs := netlink.NewLinkAttrs()
+
+	if n.Name == "" {
+		return attrs, fmt.Errorf("%s network has no interface name", n.Type)
+	}
+	if n.Parent == "" {
+		return attrs, fmt.Errorf("%s interface %s has no parent interface", n.Type, n.Name)
+	}
+	parent, err := netlink.LinkByName(n.Parent)
+	if err != nil {
+		return attrs, fmt.Errorf("looking up parent interface %s of %s: %v", n.Parent, n.Name, err)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return attrs, err
+	}
+	n.TempIfaceName = "sbx" + hex.EncodeToString(suffix)
+
+	attrs.Name = n.TempIfaceName
+	attrs.ParentIndex = parent.Attrs().Index
+	attrs.MTU = n.Mtu
+	if n.TxQueueLen > 0 {
+		attrs.TxQLen = n.TxQueueLen
+	}
+	return attrs, nil
+}
+
+// createSubIface creates the given macvlan or ipvlan interface and moves it
+// into the network namespace of the process with the given pid.
+func createSubIface(n *network, link netlink.Link, nspid int) error {
+	if err := netlink.LinkAdd(link); err != nil {
+		return fmt.Errorf("creating %s interface %s: %v", n.Type, n.Name, err)
+	}
+	if err := netlink.LinkSetNsPid(link, nspid); err != nil {
+		netlink.LinkDel(link)
+		return fmt.Errorf("moving %s interface %s into the container: %v", n.Type, n.Name, err)
+	}
+	return nil
+}
+
+// initSubIface renames the given network's macvlan or ipvlan interface to its
+// name in the container, and sets up its addresses and routes. It must be
+// called in the container's network namespace.
+func initSubIface(n *network) error {
+	link, err := netlink.LinkByName(n.TempIfaceName)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetName(link, n.Name); err != nil {
+		return err
+	}
+
+	for _, addr := range []string{n.Address, n.IPv6Address} {
+		if addr == "" {
+			continue
+		}
+		a, err := netlink.ParseAddr(addr)
+		if err != nil {
+			return fmt.Errorf("invalid address %q for interface %s: %v", addr, n.Name, err)
+		}
+		if err := netlink.AddrAdd(link, a); err != nil {
+			return fmt.Errorf("adding address %s to interface %s: %v", addr, n.Name, err)
+		}
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return err
+	}
+
+	routes := n.Routes
+	for _, gw := range []struct{ dst, gw string }{
+		{"0.0.0.0/0", n.Gateway},
+		{"::/0", n.IPv6Gateway},
+	} {
+		if gw.gw != "" {
+			routes = append(routes, &configs.Route{Destination: gw.dst, Gateway: gw.gw})
+		}
+	}
+	for _, r := range routes {
+		route := &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Scope:     netlink.SCOPE_UNIVERSE,
+		}
+		if _, route.Dst, err = net.ParseCIDR(r.Destination); err != nil {
+			return fmt.Errorf("invalid route destination %q for interface %s", r.Destination, n.Name)
+		}
+		if r.Gateway != "" {
+			if route.Gw = net.ParseIP(r.Gateway); route.Gw == nil {
+				return fmt.Errorf("invalid route gateway %q for interface %s", r.Gateway, n.Name)
+			}
+		} else {
+			route.Scope = netlink.SCOPE_LINK
+		}
+		if r.Source != "" {
+			if route.Src = net.ParseIP(r.Source); route.Src == nil {
+				return fmt.Errorf("invalid route source %q for interface %s", r.Source, n.Name)
+			}
+		}
+		if err := netlink.RouteAdd(route); err != nil {
+			return fmt.Errorf("adding route to %s via interface %s: %v", r.Destination, n.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -110,6 +110,13 @@ struct nlconfig_t {
 
 static int logfd = -1;
 
+/*
+ * sysbox-runc: the container id and the nsexec stage, sent along with each log
+ * record (see libcontainer/logs).
+ */
+static char *logid = "";
+static const char *logphase = "nsexec-0";
+
 /*
  * List of netlink message types sent to us as part of bootstrapping the init.
  * These constants are defined in libcontainer/message_linux.go.
@@ -152,9 +159,46 @@ int setns(int fd, int nstype)
 }
 #endif
 
+/*
+ * sysbox-runc: json_escape copies src into dst (of the given size) escaped as
+ * the contents of a JSON string, truncating it if needed.
+ */
+static void json_escape(char *dst, size_t size, const char *src)
+{
+	size_t n = 0;
+
+	for (; *src != '\0'; src++) {
+		unsigned char c = *src;
+		char esc[7];
+		size_t len;
+
+		if (c == '"' || c == '\\') {
+			esc[0] = '\\';
+			esc[1] = c;
+			esc[2] = '\0';
+		} else if (c < 0x20) {
+			snprintf(esc, sizeof(esc), "\\u%04x", c);
+		} else {
+			esc[0] = c;
+			esc[1] = '\0';
+		}
+
+		len = strlen(esc);
+		if (n + len >= size)
+			break;
+		memcpy(dst + n, esc, len);
+		n += len;
+	}
+	dst[n] = '\0';
+}
+
 static void write_log_with_info(const char *level, const char *function, int line, const char *format, ...)
 {
 	char message[1024] = {};
+	char escaped[2048] = {};
+	char id[1024] = {};
+	char record[PIPE_BUF];
+	int len;
 
 	va_list args;
 
@@ -165,7 +209,22 @@ static void write_log_with_info(const char *level, const char *function, int lin
 	if (vsnprintf(message, sizeof(message), format, args) < 0)
 		goto done;
 
-	dprintf(logfd, "{\"level\":\"%s\", \"msg\": \"%s:%d %s\"}\n", level, function, line, message);
+	json_escape(escaped, sizeof(escaped), message);
+	json_escape(id, sizeof(id), logid);
+
+	/*
+	 * sysbox-runc: the record is sent with a single write(2) of at most
+	 * PIPE_BUF bytes, so that it's not interleaved with those of the other
+	 * processes writing to the log pipe.
+	 */
+	len = snprintf(record, sizeof(record),
+		       "{\"level\":\"%s\",\"msg\":\"%s:%d %s\",\"phase\":\"%s\",\"id\":\"%s\"}\n",
+		       level, function, line, escaped, logphase, id);
+	if (len < 0 || len >= sizeof(record))
+		goto done;
+
+	if (write(logfd, record, len) < 0)
+		goto done;
 done:
 	va_end(args);
 }
@@ -408,6 +467,10 @@ static void setup_logpipe(void)
 		/* It is too early to use bail */
 		exit(1);
 	}
+
+	logid = getenv("_LIBCONTAINER_CONTAINERID");
+	if (logid == NULL)
+		logid = "";
 }
 
 /* Returns the clone(2) flag for a namespace, given the name of a namespace. */
@@ -926,6 +989,7 @@ void nsexec(void)
 
 			/* For debugging. */
 			prctl(PR_SET_NAME, (unsigned long)"runc:[1:CHILD]", 0, 0, 0);
+			logphase = "nsexec-1";
 
 			/*
 			 * We need to setns first. We cannot do this earlier (in stage 0)
@@ -1141,6 +1205,7 @@ void nsexec(void)
 
 			/* For debugging. */
 			prctl(PR_SET_NAME, (unsigned long)"runc:[2:INIT]", 0, 0, 0);
+			logphase = "nsexec-2";
 
 			/*
 			 * sysbox-runc: set the oom score adjustment to the
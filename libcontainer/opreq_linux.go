@@ -0,0 +1,245 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// sysbox-runc: op requests (see opReq) are sent to the parent sysbox-runc,
+// and by it to the helper process that performs them, as a length-prefixed
+// binary frame:
+//
+//   uint32 payload length | uint32 number of requests | request ...
+//
+// with each request's fields encoded in order (strings as a uint32 length
+// followed by their bytes, integers as int64, all big-endian). This is much
+// cheaper to encode and decode than JSON, which matters as container creation
+// may send many requests (e.g., one per bind mount).
+//
+// With sync protocol version syncVersionLegacy (see syncVersion), they are
+// sent as JSON instead.
+
+// maxOpReqFrame is the max size of an op request frame's payload; a larger one
+// is taken as a corrupt frame.
+const maxOpReqFrame = 64 << 20
+
+type opReqEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *opReqEncoder) putUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *opReqEncoder) putInt(v int) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(int64(v)))
+	e.buf.Write(b[:])
+}
+
+func (e *opReqEncoder) putString(s string) {
+	e.putUint32(uint32(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *opReqEncoder) putMount(m *configs.Mount) {
+	e.putString(m.Source)
+	e.putString(m.Destination)
+	e.putString(m.Device)
+	e.putInt(m.Flags)
+	e.putUint32(uint32(len(m.PropagationFlags)))
+	for _, f := range m.PropagationFlags {
+		e.putInt(f)
+	}
+	e.putString(m.Data)
+	e.putString(m.Relabel)
+	e.putInt(m.Extensions)
+	if m.BindSrcInfo.IsDir {
+		e.buf.WriteByte(1)
+	} else {
+		e.buf.WriteByte(0)
+	}
+	e.putUint32(m.BindSrcInfo.Uid)
+	e.putUint32(m.BindSrcInfo.Gid)
+}
+
+func (e *opReqEncoder) putReq(req *opReq) {
+	e.putInt(int(req.Op))
+	e.putString(req.Rootfs)
+	e.putMount(&req.Mount)
+	e.putString(req.Label)
+	e.putString(req.OldDns)
+	e.putString(req.NewDns)
+	e.putString(req.Path)
+	e.putInt(req.Uid)
+	e.putInt(req.Gid)
+	e.putInt(req.Flags)
+	e.putString(req.Hostname)
+	e.putString(req.MachineID)
+}
+
+var errShortOpReqFrame = errors.New("op request frame is truncated")
+
+type opReqDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *opReqDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.data) < n {
+		d.err = errShortOpReqFrame
+		d.data = nil
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *opReqDecoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *opReqDecoder) int() int {
+	if b := d.next(8); b != nil {
+		return int(int64(binary.BigEndian.Uint64(b)))
+	}
+	return 0
+}
+
+func (d *opReqDecoder) string() string {
+	n := d.uint32()
+	if b := d.next(int(n)); b != nil {
+		return string(b)
+	}
+	return ""
+}
+
+func (d *opReqDecoder) mount(m *configs.Mount) {
+	m.Source = d.string()
+	m.Destination = d.string()
+	m.Device = d.string()
+	m.Flags = d.int()
+	if n := d.uint32(); n > 0 {
+		if int(n) > len(d.data)/8 {
+			d.err = errShortOpReqFrame
+			return
+		}
+		m.PropagationFlags = make([]int, n)
+		for i := range m.PropagationFlags {
+			m.PropagationFlags[i] = d.int()
+		}
+	}
+	m.Data = d.string()
+	m.Relabel = d.string()
+	m.Extensions = d.int()
+	if b := d.next(1); b != nil {
+		m.BindSrcInfo.IsDir = b[0] != 0
+	}
+	m.BindSrcInfo.Uid = d.uint32()
+	m.BindSrcInfo.Gid = d.uint32()
+}
+
+func (d *opReqDecoder) req(req *opReq) {
+	req.Op = opReqType(d.int())
+	req.Rootfs = d.string()
+	d.mount(&req.Mount)
+	req.Label = d.string()
+	req.OldDns = d.string()
+	req.NewDns = d.string()
+	req.Path = d.string()
+	req.Uid = d.int()
+	req.Gid = d.int()
+	req.Flags = d.int()
+	req.Hostname = d.string()
+	req.MachineID = d.string()
+}
+
+// writeOpReqs writes the given op requests to the given writer as a single
+// frame (with a single write, so that frames written concurrently to a pipe or
+// socket don't interleave).
+func writeOpReqs(w io.Writer, reqs []opReq) error {
+	var e opReqEncoder
+	e.putUint32(0) // payload length, set below
+	e.putUint32(uint32(len(reqs)))
+	for i := range reqs {
+		e.putReq(&reqs[i])
+	}
+
+	frame := e.buf.Bytes()
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// readOpReqs reads a frame of op requests (see writeOpReqs) from the given
+// reader.
+func readOpReqs(r io.Reader) ([]opReq, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading op request frame: %w", err)
+	}
+
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size > maxOpReqFrame {
+		return nil, fmt.Errorf("op request frame too large (%d bytes)", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("reading op request frame: %w", err)
+	}
+
+	d := &opReqDecoder{data: payload}
+	n := d.uint32()
+	if d.err == nil && int(n) > len(d.data) {
+		return nil, errShortOpReqFrame
+	}
+
+	reqs := make([]opReq, n)
+	for i := range reqs {
+		d.req(&reqs[i])
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.data) > 0 {
+		return nil, fmt.Errorf("op request frame has %d trailing bytes", len(d.data))
+	}
+
+	return reqs, nil
+}
+
+// recvOpReqs receives the op requests following the given reqOp sync from the
+// child, with the given sync protocol version.
+func recvOpReqs(sync *syncT, pipe io.Writer, version int) ([]opReq, error) {
+	if version >= syncVersionFramed {
+		return readOpReqs(sync.payload)
+	}
+
+	if err := writeSync(pipe, sendOpInfo); err != nil {
+		return nil, err
+	}
+	var reqs []opReq
+	if err := json.NewDecoder(sync.payload).Decode(&reqs); err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
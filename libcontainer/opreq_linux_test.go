@@ -0,0 +1,220 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"golang.org/x/sys/unix"
+)
+
+func TestOpReqsRoundTrip(t *testing.T) {
+	reqs := []opReq{
+		{
+			Op:     bind,
+			Rootfs: "/var/lib/rootfs",
+			Mount: configs.Mount{
+				Source:           "/src",
+				Destination:      "/dst",
+				Device:           "bind",
+				Flags:            unix.MS_BIND | unix.MS_REC,
+				PropagationFlags: []int{unix.MS_PRIVATE, unix.MS_SLAVE},
+				Data:             "mode=755",
+				Relabel:          "z",
+				Extensions:       1,
+				BindSrcInfo:      configs.BindSrcInfo{IsDir: true, Uid: 165536, Gid: 165536},
+			},
+			Label: "system_u:object_r:container_file_t:s0",
+		},
+		{
+			Op:     chown,
+			Rootfs: "/var/lib/rootfs",
+			Path:   "proc",
+			Uid:    165536,
+			Gid:    -1,
+		},
+		{
+			Op:     switchDockerDns,
+			OldDns: "127.0.0.11",
+			NewDns: "172.20.0.1",
+		},
+		{
+			Op:    umount,
+			Path:  "/mnt",
+			Flags: unix.MNT_DETACH,
+		},
+		{
+			Op:        stampIdentity,
+			Path:      "/etc/machine-id",
+			Uid:       165536,
+			Gid:       165536,
+			Hostname:  "runner-7",
+			MachineID: "0123456789abcdef0123456789abcdef",
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeOpReqs(&buf, reqs); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeOpReqs(&buf, reqs[1:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readOpReqs(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, reqs) {
+		t.Fatalf("got %+v, want %+v", got, reqs)
+	}
+
+	got, err = readOpReqs(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, reqs[1:2]) {
+		t.Fatalf("got %+v, want %+v", got, reqs[1:2])
+	}
+
+	if _, err := readOpReqs(&buf); err == nil {
+		t.Fatal("expected error reading from an empty pipe")
+	}
+}
+
+func TestOpReqsCorruptFrame(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeOpReqs(&buf, []opReq{{Op: chown, Path: "proc"}}); err != nil {
+		t.Fatal(err)
+	}
+	frame := buf.Bytes()
+
+	// truncated frame
+	if _, err := readOpReqs(bytes.NewReader(frame[:len(frame)-1])); err == nil {
+		t.Fatal("expected error reading a truncated frame")
+	}
+
+	// frame whose payload is shorter than its requests
+	short := append([]byte{}, frame...)
+	short[3] -= 4
+	if _, err := readOpReqs(bytes.NewReader(short[:len(short)-4])); err != errShortOpReqFrame {
+		t.Fatalf("expected %v, got %v", errShortOpReqFrame, err)
+	}
+
+	// frame claiming more requests than it has
+	many := append([]byte{}, frame...)
+	many[7] = 0xff
+	if _, err := readOpReqs(bytes.NewReader(many)); err == nil {
+		t.Fatal("expected error reading a frame with a bad request count")
+	}
+}
+
+func TestParseSyncPayload(t *testing.T) {
+	reqs := []opReq{{Op: chown, Path: "proc", Uid: 1000, Gid: 1000}}
+
+	// the child sends several requests (pipelined) followed by another sync
+	var buf bytes.Buffer
+	for i := 0; i < 3; i++ {
+		if err := writeSync(&buf, reqOp); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeOpReqs(&buf, reqs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeSync(&buf, procReady); err != nil {
+		t.Fatal(err)
+	}
+
+	var ops, ready int
+	err := parseSync(io.Reader(&buf), func(sync *syncT) error {
+		switch sync.Type {
+		case reqOp:
+			got, err := readOpReqs(sync.payload)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(got, reqs) {
+				t.Errorf("got %+v, want %+v", got, reqs)
+			}
+			ops++
+		case procReady:
+			ready++
+		default:
+			t.Errorf("unexpected sync %q", sync.Type)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ops != 3 || ready != 1 {
+		t.Fatalf("got %d reqOp and %d procReady syncs, want 3 and 1", ops, ready)
+	}
+}
+
+func TestReadSyncs(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; i < 3; i++ {
+		if err := writeSync(&buf, opDone); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := readSyncs(&buf, opDone, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := readSyncs(&buf, opDone, 1); err == nil {
+		t.Fatal("expected error reading past the syncs sent")
+	}
+}
+
+func TestNegotiateSyncVersion(t *testing.T) {
+	for peer, want := range map[int]int{
+		0:                 syncVersionLegacy,
+		syncVersionLegacy: syncVersionLegacy,
+		syncVersionFramed: syncVersionFramed,
+		syncVersion + 1:   syncVersion,
+	} {
+		if got := negotiateSyncVersion(peer); got != want {
+			t.Errorf("negotiateSyncVersion(%d) = %d, want %d", peer, got, want)
+		}
+	}
+}
+
+func TestCheckSyncVersion(t *testing.T) {
+	for _, v := range []int{0, syncVersion + 1} {
+		if _, err := checkSyncVersion(&syncT{Type: procVersion, Version: v}); err == nil {
+			t.Errorf("expected error checking version %d", v)
+		}
+	}
+	if v, err := checkSyncVersion(&syncT{Type: procVersion, Version: syncVersionLegacy}); err != nil || v != syncVersionLegacy {
+		t.Errorf("checkSyncVersion(%d) = %d, %v", syncVersionLegacy, v, err)
+	}
+}
+
+func TestRecvOpReqsLegacy(t *testing.T) {
+	reqs := []opReq{{Op: umount, Path: "/mnt", Flags: unix.MNT_DETACH}}
+
+	// a legacy child sends the reqs as JSON once it gets sendOpInfo
+	var payload bytes.Buffer
+	if err := utils.WriteJSON(&payload, reqs); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	got, err := recvOpReqs(&syncT{Type: reqOp, payload: &payload}, &out, syncVersionLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, reqs) {
+		t.Fatalf("got %+v, want %+v", got, reqs)
+	}
+	if err := readSync(&out, sendOpInfo); err != nil {
+		t.Fatal(err)
+	}
+}
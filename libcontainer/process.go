@@ -5,6 +5,7 @@ import (
 	"io"
 	"math"
 	"os"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
 )
@@ -69,15 +70,32 @@ type Process struct {
 	// If Rlimits are not set, the container will inherit rlimits from the parent process
 	Rlimits []configs.Rlimit
 
+	// sysbox-runc: Scheduler and IOPriority specify the scheduling attributes
+	// of the process; if nil, those of the container apply.
+	Scheduler  *configs.Scheduler
+	IOPriority *configs.IOPriority
+
 	// ConsoleSocket provides the masterfd console.
 	ConsoleSocket *os.File
 
 	// Init specifies whether the process is the first process in the container.
 	Init bool
 
+	// sysbox-runc: SubCgroupPaths specifies the cgroups a non-init process is
+	// placed in, as paths relative to the system container's cgroup root, per
+	// controller (the "" key applies to all controllers, and is the only one
+	// for cgroup v2). If not set, the process joins the cgroups of the
+	// container's init process.
+	SubCgroupPaths map[string]string
+
 	ops processOperations
 
 	LogLevel string
+
+	// sysbox-runc: BootstrapTimeout bounds the bootstrap of the container's
+	// init process (see bootstrapWatchdog); zero means no bound. It's ignored
+	// for non-init processes.
+	BootstrapTimeout time.Duration
 }
 
 // Wait waits for the process to exit.
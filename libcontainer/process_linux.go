@@ -22,6 +22,7 @@ import (
 	"github.com/opencontainers/runc/libcontainer/logs"
 	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/opencontainers/runc/libsysbox/extension"
 	"github.com/opencontainers/runc/libsysbox/sysbox"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -77,6 +78,12 @@ type setnsProcess struct {
 	bootstrapData   io.Reader
 	initProcessPid  int
 	container       *linuxContainer
+
+	// sysbox-runc: if set, the cgroups the process is placed in while it's set
+	// up (i.e., the container's cgroups, which hold the pids reserved for
+	// sysbox-runc's helper processes); the process moves to cgroupPaths right
+	// before exec'ing, so it's not starved by the container's workloads.
+	reserveCgroupPaths map[string]string
 }
 
 func (p *setnsProcess) startTime() (uint64, error) {
@@ -117,7 +124,11 @@ func (p *setnsProcess) start() (retErr error) {
 	if err := p.execSetns(); err != nil {
 		return newSystemErrorWithCause(err, "executing setns process")
 	}
-	if len(p.cgroupPaths) > 0 {
+	if len(p.reserveCgroupPaths) > 0 {
+		if err := cgroups.EnterPid(p.reserveCgroupPaths, p.pid()); err != nil {
+			return newSystemErrorWithCausef(err, "adding pid %d to cgroups", p.pid())
+		}
+	} else if len(p.cgroupPaths) > 0 {
 		if err := cgroups.EnterPid(p.cgroupPaths, p.pid()); err != nil && !p.rootlessCgroups {
 			// On cgroup v2 + nesting + domain controllers, EnterPid may fail with EBUSY.
 			// https://github.com/opencontainers/runc/issues/2356#issuecomment-621277643
@@ -154,12 +165,22 @@ func (p *setnsProcess) start() (retErr error) {
 	if err := setupRlimits(p.config.Rlimits, p.pid()); err != nil {
 		return newSystemErrorWithCause(err, "setting rlimits for process")
 	}
+	if err := setupScheduling(p.config.Scheduler, p.config.IOPriority, p.pid()); err != nil {
+		return newSystemErrorWithCause(err, "setting scheduling attributes for process")
+	}
 	if err := utils.WriteJSON(p.messageSockPair.parent, p.config); err != nil {
 		return newSystemErrorWithCause(err, "writing config to pipe")
 	}
 
 	ierr := parseSync(p.messageSockPair.parent, func(sync *syncT) error {
 		switch sync.Type {
+		case procVersion:
+			// The version only matters for op requests, which setns
+			// processes don't do.
+			if _, err := checkSyncVersion(sync); err != nil {
+				return newSystemError(err)
+			}
+
 		case procReady:
 			// This shouldn't happen.
 			panic("unexpected procReady in setns")
@@ -183,10 +204,23 @@ func (p *setnsProcess) start() (retErr error) {
 			if err := p.container.procSeccompInit(p.pid(), fd); err != nil {
 				return newSystemErrorWithCausef(err, "processing seccomp fd")
 			}
+			if p.container.sysFs.Enabled() && p.container.config.SeccompKeeper {
+				if err := p.container.keepSeccompFd(p.pid(), fd); err != nil {
+					logrus.Warnf("failed to hand seccomp fd to the seccomp fd keeper: %v", err)
+				}
+			}
 			if err := writeSync(p.messageSockPair.parent, procFdDone); err != nil {
 				return newSystemErrorWithCause(err, "writing syncT 'procFdDone'")
 			}
 
+		case procEnterCgroup:
+			if err := cgroups.EnterPid(p.cgroupPaths, p.pid()); err != nil {
+				return newSystemErrorWithCausef(err, "adding pid %d to cgroups", p.pid())
+			}
+			if err := writeSync(p.messageSockPair.parent, procEnterCgroupDone); err != nil {
+				return newSystemErrorWithCause(err, "writing syncT 'procEnterCgroupDone'")
+			}
+
 		default:
 			return newSystemError(errors.New("invalid JSON payload from child"))
 		}
@@ -347,7 +381,13 @@ func (p *initProcess) start() (retErr error) {
 		p.process.ops = nil
 		return newSystemErrorWithCause(err, "starting init process command")
 	}
+
+	// sysbox-runc: bound the bootstrap of the init process; the bootstrap's
+	// error (if any) gets the diagnostics collected on expiry.
+	watchdog := newBootstrapWatchdog(p.process.BootstrapTimeout, p.pid(), p.messageSockPair.parent)
+
 	defer func() {
+		retErr = watchdog.stop(retErr)
 		if retErr != nil {
 			// terminate the process to ensure we can remove cgroups
 			if err := ignoreTerminateErrors(p.terminate()); err != nil {
@@ -403,11 +443,15 @@ func (p *initProcess) start() (retErr error) {
 	if err != nil {
 		return newSystemErrorWithCause(err, "getting the final child's pid from pipe")
 	}
+	watchdog.setChildPid(childPid)
 
 	// Save the standard descriptor names before the container process
 	// can potentially move them (e.g., via dup2()).  If we don't do this now,
 	// we won't know at checkpoint time which file descriptor to look up.
-	fds, err := getPipeFds(childPid)
+	//
+	// sysbox-runc: this includes the preserved fds (i.e., the process' extra
+	// files), which follow the standard descriptors.
+	fds, err := getPipeFds(childPid, stdioFdCount+len(p.process.ExtraFiles))
 	if err != nil {
 		return newSystemErrorWithCausef(err, "getting pipe fds for pid %d", childPid)
 	}
@@ -439,6 +483,22 @@ func (p *initProcess) start() (retErr error) {
 		return newSystemErrorWithCause(err, "waiting for our first child to exit")
 	}
 
+	// sysbox-runc: place the system container's init process in the child
+	// cgroup (cgroup v2). It must be done once the init process has created its
+	// cgroup ns (done by now, as our first child exits after that), so that the
+	// cgroup ns is rooted at the container's cgroup rather than at the child
+	// cgroup. As with cgroup v1, it's done before the init process sets up the
+	// container's rootfs, so that all hooks (and the processes they spawn within
+	// the container) see the init process in the child cgroup.
+	if cgType == cgroups.Cgroup_v2_fs || cgType == cgroups.Cgroup_v2_systemd {
+		if err := p.manager.CreateChildCgroup(p.config.Config); err != nil {
+			return newSystemErrorWithCause(err, "creating container child cgroup")
+		}
+		if err := p.manager.ApplyChildCgroup(childPid); err != nil {
+			return newSystemErrorWithCause(err, "applying cgroup configuration for process")
+		}
+	}
+
 	if err := p.createNetworkInterfaces(); err != nil {
 		return newSystemErrorWithCause(err, "creating network interfaces")
 	}
@@ -456,14 +516,30 @@ func (p *initProcess) start() (retErr error) {
 		sentResume bool
 	)
 
+	// sysbox-runc: the sync protocol version used with the child; it's
+	// syncVersionLegacy unless the child tells otherwise (see syncVersion).
+	childSyncVersion := syncVersionLegacy
+
 	ierr := parseSync(p.messageSockPair.parent, func(sync *syncT) error {
+		watchdog.syncReceived(sync.Type)
+
 		switch sync.Type {
+		case procVersion:
+			v, err := checkSyncVersion(sync)
+			if err != nil {
+				return newSystemError(err)
+			}
+			childSyncVersion = v
+
 		case procReady:
 			// set rlimits, this has to be done here because we lose permissions
 			// to raise the limits once we enter a user-namespace
 			if err := setupRlimits(p.config.Rlimits, p.pid()); err != nil {
 				return newSystemErrorWithCause(err, "setting rlimits for ready process")
 			}
+			if err := setupScheduling(p.config.Scheduler, p.config.IOPriority, p.pid()); err != nil {
+				return newSystemErrorWithCause(err, "setting scheduling attributes for ready process")
+			}
 			// call prestart and CreateRuntime hooks
 			if !p.config.Config.Namespaces.Contains(configs.NEWNS) {
 				if p.intelRdtManager != nil {
@@ -482,10 +558,10 @@ func (p *initProcess) start() (retErr error) {
 					s.Status = specs.StateCreating
 					hooks := p.config.Config.Hooks
 
-					if err := hooks[configs.Prestart].RunHooks(s); err != nil {
+					if err := hooks[configs.Prestart].RunSysboxHooks(s, p.container.sysboxHookState()); err != nil {
 						return err
 					}
-					if err := hooks[configs.CreateRuntime].RunHooks(s); err != nil {
+					if err := hooks[configs.CreateRuntime].RunSysboxHooks(s, p.container.sysboxHookState()); err != nil {
 						return err
 					}
 				}
@@ -519,14 +595,12 @@ func (p *initProcess) start() (retErr error) {
 			sentRun = true
 
 		case rootfsReady:
-			// Setup cgroup v2 child cgroup
-			if cgType == cgroups.Cgroup_v2_fs || cgType == cgroups.Cgroup_v2_systemd {
-				if err := p.manager.CreateChildCgroup(p.config.Config); err != nil {
-					return newSystemErrorWithCause(err, "creating container child cgroup")
-				}
-				if err := p.manager.ApplyChildCgroup(childPid); err != nil {
-					return newSystemErrorWithCause(err, "applying cgroup configuration for process")
-				}
+			// Run the post-mount-setup extensions.
+			if err := p.container.runExtensions(extension.PostMountSetup, &extension.Request{
+				Pid:    childPid,
+				Rootfs: p.config.Config.Rootfs,
+			}); err != nil {
+				return err
 			}
 			// Register container with sysbox-fs.
 			if err = p.registerWithSysboxfs(childPid); err != nil {
@@ -553,10 +627,10 @@ func (p *initProcess) start() (retErr error) {
 				s.Status = specs.StateCreating
 				hooks := p.config.Config.Hooks
 
-				if err := hooks[configs.Prestart].RunHooks(s); err != nil {
+				if err := hooks[configs.Prestart].RunSysboxHooks(s, p.container.sysboxHookState()); err != nil {
 					return err
 				}
-				if err := hooks[configs.CreateRuntime].RunHooks(s); err != nil {
+				if err := hooks[configs.CreateRuntime].RunSysboxHooks(s, p.container.sysboxHookState()); err != nil {
 					return err
 				}
 			}
@@ -567,16 +641,15 @@ func (p *initProcess) start() (retErr error) {
 			sentResume = true
 
 		case reqOp:
-			var reqs []opReq
-			if err := writeSync(p.messageSockPair.parent, sendOpInfo); err != nil {
-				return newSystemErrorWithCause(err, "writing syncT 'sendOpInfo'")
-			}
-			if err := json.NewDecoder(p.messageSockPair.parent).Decode(&reqs); err != nil {
+			reqs, err := recvOpReqs(sync, p.messageSockPair.parent, childSyncVersion)
+			if err != nil {
 				return newSystemErrorWithCause(err, "receiving / decoding reqOp'")
 			}
-			if err := p.container.handleReqOp(childPid, reqs); err != nil {
+			watchdog.setPendingOps(reqs)
+			if err := p.container.handleReqOp(childPid, reqs, childSyncVersion); err != nil {
 				return newSystemErrorWithCausef(err, "handleReqOp")
 			}
+			watchdog.setPendingOps(nil)
 			if err := writeSync(p.messageSockPair.parent, opDone); err != nil {
 				return newSystemErrorWithCause(err, "writing syncT 'opDone'")
 			}
@@ -592,6 +665,11 @@ func (p *initProcess) start() (retErr error) {
 			if err := p.container.procSeccompInit(childPid, fd); err != nil {
 				return newSystemErrorWithCausef(err, "processing seccomp fd")
 			}
+			if p.container.sysFs.Enabled() && p.container.config.SeccompKeeper {
+				if err := p.container.startSeccompKeeper(childPid, fd); err != nil {
+					logrus.Warnf("failed to start the seccomp fd keeper: %v", err)
+				}
+			}
 			if err := writeSync(p.messageSockPair.parent, procFdDone); err != nil {
 				return newSystemErrorWithCause(err, "writing syncT 'procFdDone'")
 			}
@@ -633,7 +711,36 @@ func (p *initProcess) registerWithSysboxfs(childPid int) error {
 	}
 
 	c := p.container
+	info := c.sysFsRegInfo(childPid)
+
+	if err := c.runExtensions(extension.PreRegisterSysboxFs, &extension.Request{
+		Pid: childPid,
+		SysboxFs: &extension.SysboxFsInfo{
+			Hostname:      info.Hostname,
+			Uid:           info.Uid,
+			Gid:           info.Gid,
+			IdSize:        info.IdSize,
+			ProcRoPaths:   info.ProcRoPaths,
+			ProcMaskPaths: info.ProcMaskPaths,
+		},
+	}); err != nil {
+		return err
+	}
+
+	// Launch registration process.
+	if err := sysFs.Register(info); err != nil {
+		return newSystemErrorWithCause(err, "registering with sysbox-fs")
+	}
+	if err := sysFs.SetSysctls(c.config.SysboxFsSysctls); err != nil {
+		return newSystemErrorWithCause(err, "setting sysctls in sysbox-fs")
+	}
+
+	return nil
+}
 
+// sysbox-runc: sysFsRegInfo returns the info the container is registered with
+// in sysbox-fs, given the pid of its init process.
+func (c *linuxContainer) sysFsRegInfo(initPid int) *sysbox.FsRegInfo {
 	procRoPaths := []string{}
 	for _, p := range c.config.ReadonlyPaths {
 		if strings.HasPrefix(p, "/proc") {
@@ -648,29 +755,26 @@ func (p *initProcess) registerWithSysboxfs(childPid int) error {
 		}
 	}
 
-	info := &sysbox.FsRegInfo{
+	return &sysbox.FsRegInfo{
 		Hostname:      c.config.Hostname,
-		Pid:           childPid,
+		Pid:           initPid,
 		Uid:           c.config.UidMappings[0].HostID,
 		Gid:           c.config.GidMappings[0].HostID,
 		IdSize:        c.config.UidMappings[0].Size,
 		ProcRoPaths:   procRoPaths,
 		ProcMaskPaths: procMaskPaths,
+		NotifyQuota:   c.config.FsNotifyQuota,
+		SwapLimits:    sysbox.NewFsSwapLimits(c.config.Cgroups.Resources),
 	}
-
-	// Launch registration process.
-	if err := sysFs.Register(info); err != nil {
-		return newSystemErrorWithCause(err, "registering with sysbox-fs")
-	}
-
-	return nil
 }
 
 func (p *initProcess) wait() (*os.ProcessState, error) {
 	err := p.cmd.Wait()
 	// we should kill all processes in cgroup when init is died if we use host PID namespace
 	if p.sharePidns {
-		signalAllProcesses(p.manager, unix.SIGKILL)
+		if err := killAllProcesses(p.manager); err != nil {
+			logrus.Warn(err)
+		}
 	}
 	return p.cmd.ProcessState, err
 }
@@ -768,23 +872,45 @@ func (p *initProcess) setupDevSubdir() error {
 	return nil
 }
 
-func getPipeFds(pid int) ([]string, error) {
-	fds := make([]string, 3)
+// getPipeFds returns the targets of the given process' first n descriptors
+// (i.e., its stdio and preserved fds), as shown in /proc/<pid>/fd (e.g.,
+// "pipe:[1234]").
+//
+// sysbox-runc: the process' open descriptors are listed via /proc/<pid>/fdinfo,
+// so descriptors the process no longer has (e.g., it moved them via dup2()
+// already) are reported as empty rather than failing. The same goes for
+// descriptors whose target can't be read (e.g., for rootless containers and
+// other non-dumpable processes, or if the fd symlink is not valid, which can
+// happen in certain particularly unlucky mount namespace setups); if we can't
+// get the target of a particular descriptor, there's not much we can do.
+func getPipeFds(pid, n int) ([]string, error) {
+	fds := make([]string, n)
+
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+	dir, err := os.Open(filepath.Join(procDir, "fdinfo"))
+	if err != nil {
+		if os.IsPermission(err) {
+			return fds, nil
+		}
+		return fds, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return fds, err
+	}
 
-	dirPath := filepath.Join("/proc", strconv.Itoa(pid), "/fd")
-	for i := 0; i < 3; i++ {
-		// XXX: This breaks if the path is not a valid symlink (which can
-		//      happen in certain particularly unlucky mount namespace setups).
-		f := filepath.Join(dirPath, strconv.Itoa(i))
-		target, err := os.Readlink(f)
+	for _, name := range names {
+		i, err := strconv.Atoi(name)
+		if err != nil || i < 0 || i >= n {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(procDir, "fd", name))
 		if err != nil {
-			// Ignore permission errors, for rootless containers and other
-			// non-dumpable processes. if we can't get the fd for a particular
-			// file, there's not much we can do.
-			if os.IsPermission(err) {
-				continue
+			if !os.IsPermission(err) && !os.IsNotExist(err) {
+				logrus.Warnf("failed to get target of fd %d of pid %d: %v", i, pid, err)
 			}
-			return fds, err
+			continue
 		}
 		fds[i] = target
 	}
@@ -0,0 +1,100 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sysbox-runc: how long freezing the container waits for its syscalls trapped
+// by sysbox-fs to complete, when sysbox-fs can't be quiesced.
+const seccompDrainTimeout = 2 * time.Second
+
+// sysbox-runc: quiesceSyscallTraps tries to make sure that none of the
+// container's syscalls trapped by sysbox-fs (via seccomp notifications) is in
+// flight when the container is frozen; otherwise, freezing interrupts the
+// trapped syscalls while sysbox-fs handles them, and the tasks may get stuck
+// once thawed.
+//
+// It quiesces sysbox-fs (see sysbox.Fs.Quiesce()) if it supports it. Otherwise
+// it can only wait (up to a timeout) until none of the container's tasks waits
+// on a seccomp notification, which doesn't stop new ones; if some still do
+// after the timeout, it fails if strict is set, or else the container is
+// frozen anyway.
+func (c *linuxContainer) quiesceSyscallTraps(strict bool) error {
+	if !c.sysFs.Enabled() || c.sysFs.Quiesced {
+		return nil
+	}
+	if err := c.sysFs.Quiesce(); err != nil {
+		return err
+	}
+	if c.sysFs.Quiesced {
+		// persist it, as the container may be resumed by another sysbox-runc
+		_, err := c.updateState(nil)
+		return err
+	}
+	if waiting := c.drainSeccompNotifs(seccompDrainTimeout); waiting > 0 {
+		if strict {
+			return newGenericError(fmt.Errorf("%d task(s) of the container still wait on sysbox-fs after %s (and sysbox-fs can't be quiesced)",
+				waiting, seccompDrainTimeout), SystemError)
+		}
+		logrus.Warnf("container %s: %d task(s) still waiting on sysbox-fs after %s; freezing anyway", c.id, waiting, seccompDrainTimeout)
+	}
+	return nil
+}
+
+// sysbox-runc: unquiesceSyscallTraps undoes quiesceSyscallTraps(); it must be
+// called before the container is thawed.
+func (c *linuxContainer) unquiesceSyscallTraps() error {
+	if !c.sysFs.Enabled() || !c.sysFs.Quiesced {
+		return nil
+	}
+	if err := c.sysFs.Unquiesce(); err != nil {
+		return err
+	}
+	_, err := c.updateState(nil)
+	return err
+}
+
+// sysbox-runc: drainSeccompNotifs waits (up to the given timeout) until none
+// of the container's tasks waits on a seccomp notification, and returns the
+// number of those that still do.
+func (c *linuxContainer) drainSeccompNotifs(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		pids, err := c.cgroupManager.GetAllPids()
+		if err != nil {
+			logrus.Debugf("failed to get the container's pids: %v", err)
+			return 0
+		}
+		waiting := seccompWaiters(pids)
+		if waiting == 0 || time.Now().After(deadline) {
+			return waiting
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// seccompWaiters returns the number of tasks of the given processes that wait
+// on a seccomp notification, as told by their wchan.
+func seccompWaiters(pids []int) int {
+	n := 0
+	for _, pid := range pids {
+		tasks, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+		if err != nil {
+			continue
+		}
+		for _, t := range tasks {
+			wchan, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%s/wchan", pid, t.Name()))
+			if err == nil && strings.HasPrefix(string(wchan), "seccomp_do_user_notification") {
+				n++
+			}
+		}
+	}
+	return n
+}
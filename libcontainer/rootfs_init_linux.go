@@ -270,6 +270,11 @@ func (l *linuxRootfsInit) Init() error {
 				}
 			}
 
+			// sysbox-runc: apply the recursive mount attributes (e.g., "rro")
+			if err := setRecAttr(m, m.Destination); err != nil {
+				return newSystemErrorWithCausef(err, "setting recursive mount attributes of %s", m.Destination)
+			}
+
 			// Apply label
 			if m.Relabel != "" {
 				if err := label.Validate(m.Relabel); err != nil {
@@ -303,6 +308,20 @@ func (l *linuxRootfsInit) Init() error {
 			}
 		}
 
+	case umount:
+		// The container has already pivoted into its rootfs, so after entering
+		// its mount-ns the request paths resolve relative to the container's root.
+		for _, req := range l.reqs {
+			if err := unix.Unmount(req.Path, req.Flags); err != nil {
+				return newSystemErrorWithCausef(err, "failed to unmount %s", req.Path)
+			}
+		}
+
+	case stampIdentity:
+		if err := doStampIdentity(l.reqs[0]); err != nil {
+			return newSystemErrorWithCause(err, "stamping container identity")
+		}
+
 	default:
 		return newSystemError(fmt.Errorf("invalid init type"))
 	}
@@ -30,7 +30,7 @@ import (
 	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/opencontainers/runc/libcontainer/utils"
 	libcontainerUtils "github.com/opencontainers/runc/libcontainer/utils"
-	"github.com/opencontainers/runc/libsysbox/syscont"
+	"github.com/opencontainers/runc/libsysbox/mountapi"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/opencontainers/selinux/go-selinux/label"
@@ -64,7 +64,7 @@ func prepareRootfs(pipe io.ReadWriter, iConfig *initConfig) (err error) {
 		return newSystemErrorWithCause(err, "effecting rootfs mount")
 	}
 
-	if err := doMounts(config, pipe); err != nil {
+	if err := doMounts(config, pipe, iConfig.SyncVersion); err != nil {
 		return newSystemErrorWithCause(err, "setting up rootfs mounts")
 	}
 
@@ -427,12 +427,19 @@ func mountToRootfs(m *configs.Mount, rootfs, mountLabel string, enableCgroupns b
 				return err
 			}
 		}
-		return nil
+		// sysbox-runc: likewise for the recursive mount attributes
+		return setRecAttr(m, dest)
 	case "cgroup":
+		var err error
 		if cgroups.IsCgroup2UnifiedMode() {
-			return mountCgroupV2(m, rootfs, mountLabel, enableCgroupns)
+			err = mountCgroupV2(m, rootfs, mountLabel, enableCgroupns)
+		} else {
+			err = mountCgroupV1(m, rootfs, mountLabel, enableCgroupns, pipe)
+		}
+		if err != nil {
+			return err
 		}
-		return mountCgroupV1(m, rootfs, mountLabel, enableCgroupns, pipe)
+		return setRecAttr(m, m.Destination)
 	default:
 		// ensure that the destination of the mount is resolved of symlinks at mount time because
 		// any previous mounts can invalidate the next mount's destination.
@@ -445,7 +452,7 @@ func mountToRootfs(m *configs.Mount, rootfs, mountLabel string, enableCgroupns b
 	}
 }
 
-func doBindMounts(config *configs.Config, pipe io.ReadWriter) error {
+func doBindMounts(config *configs.Config, ops *opPipeline) error {
 
 	// sysbox-runc: the sys container's init process is in a dedicated
 	// user-ns, so it may not have search permission to the bind mount
@@ -460,8 +467,10 @@ func doBindMounts(config *configs.Config, pipe io.ReadWriter) error {
 	// Also, to avoid sending too many requests to our parent
 	// sysbox-runc, we group bind mounts and send a bulk request, with
 	// one exception: when a bind mount depends on a prior one, we must
-	// ask the parent sysbox-runc to perform the prior ones before we
-	// can prepare the bind destination and perform the current one.
+	// ask the parent sysbox-runc to perform the prior ones (and wait
+	// for it) before we can prepare the bind destination and perform
+	// the current one. The last request is not waited for here (see
+	// doMounts).
 
 	mntReqs := []opReq{}
 
@@ -487,11 +496,14 @@ func doBindMounts(config *configs.Config, pipe io.ReadWriter) error {
 		// runc to actually do the prior mount(s).
 		if mntDependsOnPrior {
 			if len(mntReqs) > 0 {
-				if err := syncParentDoOp(mntReqs, pipe); err != nil {
+				if err := ops.send(mntReqs); err != nil {
 					return newSystemErrorWithCause(err, "syncing with parent runc to perform bind mounts")
 				}
 				mntReqs = mntReqs[:0]
 			}
+			if err := ops.wait(); err != nil {
+				return newSystemErrorWithCause(err, "syncing with parent runc to perform bind mounts")
+			}
 		}
 
 		if err := prepareBindDest(m, config.Rootfs, false); err != nil {
@@ -509,7 +521,7 @@ func doBindMounts(config *configs.Config, pipe io.ReadWriter) error {
 	}
 
 	if len(mntReqs) > 0 {
-		if err := syncParentDoOp(mntReqs, pipe); err != nil {
+		if err := ops.send(mntReqs); err != nil {
 			return newSystemErrorWithCause(err, "syncing with parent runc to perform bind mounts")
 		}
 	}
@@ -517,7 +529,7 @@ func doBindMounts(config *configs.Config, pipe io.ReadWriter) error {
 	return nil
 }
 
-func chownMounts(config *configs.Config, pipe io.ReadWriter, chownList []string) error {
+func chownMounts(config *configs.Config, ops *opPipeline, chownList []string) error {
 	chownReqs := []opReq{}
 
 	if config.UidMappings != nil && config.GidMappings != nil {
@@ -534,7 +546,7 @@ func chownMounts(config *configs.Config, pipe io.ReadWriter, chownList []string)
 	}
 
 	if len(chownReqs) > 0 {
-		if err := syncParentDoOp(chownReqs, pipe); err != nil {
+		if err := ops.send(chownReqs); err != nil {
 			return newSystemErrorWithCause(err, "syncing with parent runc to chown mounts")
 		}
 	}
@@ -1029,6 +1041,27 @@ func writeSystemProperty(key, value string) error {
 	return ioutil.WriteFile(path.Join("/proc/sys", keyPath), []byte(value), 0644)
 }
 
+// sysbox-runc: statfsFlags maps the statfs(2) flags of a mount to its mount(2)
+// flags (their values differ for some of them).
+var statfsFlags = []struct {
+	st int
+	ms int
+}{
+	{unix.ST_RDONLY, unix.MS_RDONLY},
+	{unix.ST_NOSUID, unix.MS_NOSUID},
+	{unix.ST_NODEV, unix.MS_NODEV},
+	{unix.ST_NOEXEC, unix.MS_NOEXEC},
+	{unix.ST_SYNCHRONOUS, unix.MS_SYNCHRONOUS},
+	{unix.ST_MANDLOCK, unix.MS_MANDLOCK},
+	{unix.ST_NOATIME, unix.MS_NOATIME},
+	{unix.ST_NODIRATIME, unix.MS_NODIRATIME},
+	{unix.ST_RELATIME, unix.MS_RELATIME},
+	{mountapi.StNoSymFollow, mountapi.MsNoSymFollow},
+}
+
+// sysbox-runc: atimeFlags are the mount(2) flags that set the access time mode.
+const atimeFlags = unix.MS_NOATIME | unix.MS_RELATIME | unix.MS_STRICTATIME
+
 func remount(m *configs.Mount) error {
 	flags := uintptr(m.Flags | unix.MS_REMOUNT)
 
@@ -1037,7 +1070,19 @@ func remount(m *configs.Mount) error {
 	if err := unix.Statfs(m.Destination, &s); err != nil {
 		return &os.PathError{Op: "statfs", Path: m.Destination, Err: err}
 	}
-	flags |= uintptr(s.Flags)
+
+	// sysbox-runc: translate the statfs flags (or else, e.g., ST_NOSYMFOLLOW
+	// is taken as MS_MOVE), and keep the access time mode only if not being
+	// changed (or else, e.g., "relatime" is lost to an original "noatime")
+	for _, f := range statfsFlags {
+		if int(s.Flags)&f.st == 0 {
+			continue
+		}
+		if f.ms&atimeFlags != 0 && m.Flags&atimeFlags != 0 {
+			continue
+		}
+		flags |= uintptr(f.ms)
+	}
 
 	if err := unix.Mount("", m.Destination, "", flags, ""); err != nil {
 		return fmt.Errorf("failed to remount %s with flags %#x", m.Destination, int(flags))
@@ -1064,19 +1109,51 @@ func mountPropagate(m *configs.Mount, mountLabel string) error {
 		flags &= ^unix.MS_RDONLY
 	}
 
+	// sysbox-runc: with recursive mount attributes, mount via the new mount
+	// API if possible, so that they're applied along with the mount (tmpfs
+	// mounts get them later, as they're written to first).
+	if m.RecAttr != nil && m.Device != "tmpfs" && mountapi.FsopenSupported() {
+		if _, err := mountapi.Attrs(flags, m.RecAttr.Set, m.RecAttr.Clr); err == nil {
+			if err := mountapi.Mount(m.Source, dest, m.Device, flags, data, m.RecAttr.Set, m.RecAttr.Clr); err != nil {
+				return err
+			}
+			return mountPropagation(m, dest)
+		}
+	}
+
 	if err := unix.Mount(m.Source, dest, m.Device, uintptr(flags), data); err != nil {
 		return err
 	}
 
+	if m.Device != "tmpfs" {
+		if err := setRecAttr(m, dest); err != nil {
+			return err
+		}
+	}
+
+	return mountPropagation(m, dest)
+}
+
+// sysbox-runc: mountPropagation sets the propagation flags of the given mount,
+// mounted at dest.
+func mountPropagation(m *configs.Mount, dest string) error {
 	for _, pflag := range m.PropagationFlags {
 		if err := unix.Mount("", dest, "", uintptr(pflag), ""); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
+// sysbox-runc: setRecAttr sets the recursive mount attributes (if any) of the
+// given mount, mounted at dest.
+func setRecAttr(m *configs.Mount, dest string) error {
+	if m.RecAttr == nil {
+		return nil
+	}
+	return mountapi.SetAttr(dest, m.RecAttr.Set, m.RecAttr.Clr, true)
+}
+
 func mountNewCgroup(m *configs.Mount) error {
 	var (
 		data   = m.Data
@@ -1093,7 +1170,7 @@ func mountNewCgroup(m *configs.Mount) error {
 }
 
 // sysbox-runc: doMounts sets up all of the container's mounts as specified in the given config.
-func doMounts(config *configs.Config, pipe io.ReadWriter) error {
+func doMounts(config *configs.Config, pipe io.ReadWriter, syncVersion int) error {
 
 	chownList := []string{}
 
@@ -1120,14 +1197,22 @@ func doMounts(config *configs.Config, pipe io.ReadWriter) error {
 		}
 	}
 
-	if err := doBindMounts(config, pipe); err != nil {
+	// The bind mount and chown requests are pipelined; wait for the parent
+	// runc to be done with them.
+	ops := &opPipeline{pipe: pipe, version: syncVersion}
+
+	if err := doBindMounts(config, ops); err != nil {
 		return err
 	}
 
-	if err := chownMounts(config, pipe, chownList); err != nil {
+	if err := chownMounts(config, ops, chownList); err != nil {
 		return err
 	}
 
+	if err := ops.wait(); err != nil {
+		return newSystemErrorWithCause(err, "syncing with parent runc to perform bind mounts and chowns")
+	}
+
 	return nil
 }
 
@@ -1145,11 +1230,12 @@ func validateCwd(rootfs string) error {
 }
 
 // needUidShiftOnBindSrc checks if uid/gid shifting on the given bind mount source path is
-// required to run the system container.
-func needUidShiftOnBindSrc(mount *configs.Mount, config *configs.Config) (bool, error) {
+// required to run the system container. sysFsMountpoint is the container's sysbox-fs
+// mountpoint (empty if sysbox-fs is not in use).
+func needUidShiftOnBindSrc(mount *configs.Mount, config *configs.Config, sysFsMountpoint string) (bool, error) {
 
 	// sysbox-fs handles uid(gid) shifting itself, so no need for mounting shiftfs on top
-	if strings.HasPrefix(mount.Source, syscont.SysboxFsDir+"/") {
+	if sysFsMountpoint != "" && strings.HasPrefix(mount.Source, sysFsMountpoint+"/") {
 		return false, nil
 	}
 
@@ -1289,7 +1375,7 @@ func getDefaultRoute() (string, error) {
 // Switches the IP address of the Docker DNS nameserver inside the container
 // when it has localhost address (e.g., 127.0.0.11). This avoids DNS resolution
 // problems with inner Docker containers. See Sysbox issue #679.
-func switchDockerDnsIP(config *configs.Config, pipe io.ReadWriter) error {
+func switchDockerDnsIP(config *configs.Config, pipe io.ReadWriter, syncVersion int) error {
 
 	// Docker places a DNS resolver in containers deployed on custom bridge networks
 	dockerDns := "127.0.0.11"
@@ -1331,7 +1417,7 @@ func switchDockerDnsIP(config *configs.Config, pipe io.ReadWriter) error {
 		},
 	}
 
-	if err := syncParentDoOp(reqs, pipe); err != nil {
+	if err := syncParentDoOp(reqs, pipe, syncVersion); err != nil {
 		return newSystemErrorWithCause(err, "syncing with parent runc to switch DNS IP")
 	}
 
@@ -0,0 +1,155 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/opencontainers/runc/libsysbox/idshift"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: env var that makes the init binary run the background rootfs
+// ownership shifting worker (see startRootfsShiftWorker()).
+const rootfsShiftEnv = "_LIBCONTAINER_ROOTFS_SHIFT"
+
+// Rootfs subtrees that are shifted before the container starts when the rootfs
+// is shifted lazily, as the container typically writes to them early on; the
+// rest of the rootfs is shifted in the background.
+var lazyShiftSubtrees = []string{"/etc", "/home", "/root", "/run", "/tmp", "/var"}
+
+// rootfsShiftReq is the request passed to the rootfs shifting worker.
+type rootfsShiftReq struct {
+	Rootfs  string `json:"rootfs"`
+	UidTo   uint32 `json:"uid_to"`
+	GidTo   uint32 `json:"gid_to"`
+	UidSize uint32 `json:"uid_size"`
+	GidSize uint32 `json:"gid_size"`
+}
+
+// sysbox-runc: shifts the ownership of the container's rootfs to the id range
+// of the container's user-ns by chown'ing its files; used when the host lacks
+// shiftfs. If the config requests a lazy shift, only the subtrees in
+// lazyShiftSubtrees are shifted here, and the rest is left to sysbox-mgr or to
+// a background worker.
+func (c *linuxContainer) shiftRootfsOwnership() error {
+	req := &rootfsShiftReq{Rootfs: c.config.Rootfs}
+
+	for _, m := range c.config.UidMappings {
+		if m.ContainerID == 0 {
+			req.UidTo, req.UidSize = uint32(m.HostID), uint32(m.Size)
+			break
+		}
+	}
+	for _, m := range c.config.GidMappings {
+		if m.ContainerID == 0 {
+			req.GidTo, req.GidSize = uint32(m.HostID), uint32(m.Size)
+			break
+		}
+	}
+
+	start := time.Now()
+
+	if !c.config.UidShiftRootfsLazy {
+		if err := idshift.ShiftTree(req.Rootfs, req.UidTo, req.GidTo,
+			req.UidSize, req.GidSize, runtime.NumCPU()); err != nil {
+			return newSystemErrorWithCause(err, "shifting the ownership of the container's rootfs")
+		}
+		logrus.Debugf("shifted ownership of rootfs %s to %d:%d in %v", req.Rootfs, req.UidTo, req.GidTo, time.Since(start))
+		return nil
+	}
+
+	if err := idshift.ShiftSubtrees(req.Rootfs, lazyShiftSubtrees, req.UidTo, req.GidTo,
+		req.UidSize, req.GidSize, runtime.NumCPU()); err != nil {
+		return newSystemErrorWithCause(err, "shifting the ownership of the container's rootfs")
+	}
+
+	logrus.Debugf("shifted ownership of rootfs %s subtrees %v to %d:%d in %v; shifting the rest in the background",
+		req.Rootfs, lazyShiftSubtrees, req.UidTo, req.GidTo, time.Since(start))
+
+	// sysbox-mgr completes the shift if it can, as it tracks the container's
+	// resources; otherwise a worker of our own does
+	if c.sysMgr.Enabled() {
+		handedOver, err := c.sysMgr.ShiftRootfs(req.Rootfs, req.UidTo, req.GidTo, req.UidSize, req.GidSize)
+		if err != nil {
+			return newSystemErrorWithCause(err, "handing the rootfs ownership shift over to sysbox-mgr")
+		}
+		if handedOver {
+			return nil
+		}
+	}
+
+	if err := c.startRootfsShiftWorker(req); err != nil {
+		return newSystemErrorWithCause(err, "starting the rootfs ownership shifting worker")
+	}
+	return nil
+}
+
+// startRootfsShiftWorker starts a detached process (the init binary) that
+// completes the given rootfs shift in the background (see
+// idshift.ShiftTreeLive()). The worker outlives sysbox-runc; if it's
+// interrupted, or can't complete the shift, the shift is resumed the next time
+// the container starts.
+func (c *linuxContainer) startRootfsShiftWorker(req *rootfsShiftReq) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(c.initPath, c.initArgs[1:]...)
+	cmd.Args[0] = c.initArgs[0]
+	cmd.Env = []string{
+		rootfsShiftEnv + "=" + string(data),
+		"_LIBCONTAINER_LOGLEVEL=" + logrus.GetLevel().String(),
+	}
+
+	// Log to the same file as sysbox-runc, if any; the worker's stdio is
+	// /dev/null, as the container engine may wait for sysbox-runc's stdio to
+	// be closed.
+	if f, ok := logrus.StandardLogger().Out.(*os.File); ok && f != os.Stdout && f != os.Stderr {
+		cmd.ExtraFiles = []*os.File{f}
+		cmd.Env = append(cmd.Env, "_LIBCONTAINER_LOGPIPE=3")
+	}
+
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// runRootfsShiftWorker runs the background rootfs shifting worker for the given
+// request (see startRootfsShiftWorker()); it returns the worker's exit code.
+func runRootfsShiftWorker(reqData string) int {
+	var req rootfsShiftReq
+
+	if err := json.Unmarshal([]byte(reqData), &req); err != nil {
+		logrus.Errorf("rootfs shift worker: invalid request: %v", err)
+		return 1
+	}
+
+	// the init binary limits itself to a single CPU; also, yield to the
+	// container's workloads
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	if err := unix.Setpriority(unix.PRIO_PROCESS, 0, 10); err != nil {
+		logrus.Debugf("rootfs shift worker: failed to lower priority: %v", err)
+	}
+
+	start := time.Now()
+
+	if err := idshift.ShiftTreeLive(req.Rootfs, req.UidTo, req.GidTo,
+		req.UidSize, req.GidSize, runtime.NumCPU()); err != nil {
+		logrus.Errorf("rootfs shift worker: failed to shift the ownership of rootfs %s: %v", req.Rootfs, err)
+		return 1
+	}
+
+	logrus.Debugf("rootfs shift worker: shifted ownership of rootfs %s to %d:%d in %v",
+		req.Rootfs, req.UidTo, req.GidTo, time.Since(start))
+	return 0
+}
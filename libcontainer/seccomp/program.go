@@ -0,0 +1,13 @@
+package seccomp
+
+// sysbox-runc: seccomp filters are compiled by the parent runc process (rather
+// than by the container's init process) and cached, so that containers with
+// identical seccomp profiles don't each pay the cost of compiling the (large)
+// sys container filter when sysbox-runc is embedded in a long-lived process.
+
+// Program is a compiled seccomp filter, i.e., a BPF program in the native
+// sock_filter layout, ready to be loaded into the kernel (see LoadProgram()).
+type Program struct {
+	Bpf    []byte `json:"bpf"`
+	Notify bool   `json:"notify,omitempty"` // the filter has seccomp notify actions
+}
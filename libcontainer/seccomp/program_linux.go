@@ -0,0 +1,136 @@
+// +build linux
+
+package seccomp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"golang.org/x/sys/unix"
+)
+
+// seccomp(2) operation and flags
+const (
+	seccompSetModeFilter         = 1
+	seccompFilterFlagNewListener = 1 << 3
+)
+
+// max number of compiled programs kept in the cache
+const programCacheSize = 64
+
+type programCache struct {
+	mu     sync.Mutex
+	progs  map[string]*Program
+	keys   []string // in insertion order, for eviction
+	hits   uint64
+	misses uint64
+}
+
+var progCache = newProgramCache()
+
+func newProgramCache() *programCache {
+	return &programCache{
+		progs: make(map[string]*Program),
+	}
+}
+
+// Compile compiles the given seccomp config into a BPF program. Compiled
+// programs are cached by digest of the config and reused for identical configs
+// within the calling process (see CacheStats()); the returned program is thus
+// shared and must not be modified.
+func Compile(config *configs.Seccomp) (*Program, error) {
+	return progCache.get(config, compile)
+}
+
+// CacheStats returns the number of hits and misses of the compiled seccomp
+// program cache.
+func CacheStats() (hits, misses uint64) {
+	progCache.mu.Lock()
+	defer progCache.mu.Unlock()
+	return progCache.hits, progCache.misses
+}
+
+func (c *programCache) get(config *configs.Seccomp, compileFn func(*configs.Seccomp) (*Program, error)) (*Program, error) {
+	if config == nil {
+		return nil, errors.New("cannot compile seccomp filter - nil config passed")
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+
+	// compilation is done with the lock held, so that concurrent requests for
+	// the same config compile it only once
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if prog, ok := c.progs[key]; ok {
+		c.hits++
+		return prog, nil
+	}
+	c.misses++
+
+	prog, err := compileFn(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.keys) >= programCacheSize {
+		delete(c.progs, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+	c.progs[key] = prog
+	c.keys = append(c.keys, key)
+
+	return prog, nil
+}
+
+// LoadProgram loads the given compiled seccomp filter into the kernel. If the
+// filter contains a seccomp notify action, returns a file descriptor that can
+// be used by a tracer process to retrieve such notifications from the kernel.
+func LoadProgram(prog *Program) (int32, error) {
+	insnSize := int(unsafe.Sizeof(unix.SockFilter{}))
+
+	if prog == nil || len(prog.Bpf) == 0 || len(prog.Bpf)%insnSize != 0 {
+		return -1, errors.New("cannot load seccomp filter - invalid program")
+	}
+
+	n := len(prog.Bpf) / insnSize
+	if n > 0xffff {
+		return -1, fmt.Errorf("cannot load seccomp filter - program too long (%d instructions)", n)
+	}
+
+	filter := make([]unix.SockFilter, n)
+	copy((*[1 << 24]byte)(unsafe.Pointer(&filter[0]))[:len(prog.Bpf)], prog.Bpf)
+
+	fprog := unix.SockFprog{
+		Len:    uint16(n),
+		Filter: &filter[0],
+	}
+
+	var flags uintptr
+	if prog.Notify {
+		flags |= seccompFilterFlagNewListener
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, flags, uintptr(unsafe.Pointer(&fprog)))
+	runtime.KeepAlive(filter)
+	if errno != 0 {
+		return -1, fmt.Errorf("error loading seccomp filter into kernel: %s", errno)
+	}
+
+	if !prog.Notify {
+		return -1, nil
+	}
+	return int32(fd), nil
+}
@@ -0,0 +1,97 @@
+// +build linux
+
+package seccomp
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestProgramCache(t *testing.T) {
+	c := newProgramCache()
+	compiled := 0
+
+	compileFn := func(config *configs.Seccomp) (*Program, error) {
+		compiled++
+		return &Program{Bpf: []byte{byte(config.DefaultAction)}}, nil
+	}
+
+	cfgA := &configs.Seccomp{
+		DefaultAction: configs.Errno,
+		Syscalls:      []*configs.Syscall{{Name: "mount", Action: configs.Allow}},
+	}
+	cfgB := &configs.Seccomp{
+		DefaultAction: configs.Errno,
+		Syscalls:      []*configs.Syscall{{Name: "umount2", Action: configs.Allow}},
+	}
+
+	progA, err := c.get(cfgA, compileFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an identical (but distinct) config must hit the cache
+	cfgACopy := *cfgA
+	prog, err := c.get(&cfgACopy, compileFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog != progA {
+		t.Errorf("expected cached program for identical config")
+	}
+
+	if _, err := c.get(cfgB, compileFn); err != nil {
+		t.Fatal(err)
+	}
+
+	if compiled != 2 || c.hits != 1 || c.misses != 2 {
+		t.Errorf("unexpected cache stats: compiled = %d, hits = %d, misses = %d", compiled, c.hits, c.misses)
+	}
+
+	// compile errors are not cached
+	failFn := func(config *configs.Seccomp) (*Program, error) {
+		return nil, fmt.Errorf("compile error")
+	}
+	cfgC := &configs.Seccomp{DefaultAction: configs.Kill}
+	if _, err := c.get(cfgC, failFn); err == nil {
+		t.Errorf("expected compile error")
+	}
+	if _, err := c.get(cfgC, compileFn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProgramCacheEviction(t *testing.T) {
+	c := newProgramCache()
+
+	compileFn := func(config *configs.Seccomp) (*Program, error) {
+		return &Program{}, nil
+	}
+
+	for i := 0; i < programCacheSize+1; i++ {
+		cfg := &configs.Seccomp{
+			Syscalls: []*configs.Syscall{{Name: fmt.Sprintf("syscall%d", i)}},
+		}
+		if _, err := c.get(cfg, compileFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(c.progs) != programCacheSize || len(c.keys) != programCacheSize {
+		t.Errorf("cache not bounded: %d programs, %d keys", len(c.progs), len(c.keys))
+	}
+
+	// the oldest entry was evicted
+	cfg := &configs.Seccomp{
+		Syscalls: []*configs.Syscall{{Name: "syscall0"}},
+	}
+	misses := c.misses
+	if _, err := c.get(cfg, compileFn); err != nil {
+		t.Fatal(err)
+	}
+	if c.misses != misses+1 {
+		t.Errorf("expected cache miss on evicted entry")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 
@@ -36,70 +38,111 @@ const (
 func LoadSeccomp(config *configs.Seccomp) (int32, error) {
 	var notifyFd libseccomp.ScmpFd
 
+	filter, notify, err := newFilter(config)
+	if err != nil {
+		return -1, err
+	}
+
+	if err = filter.Load(); err != nil {
+		return -1, fmt.Errorf("error loading seccomp filter into kernel: %s", err)
+	}
+
+	// If the filter contains a notify action, get the notification file-descriptor
+	if notify {
+		fd, err := filter.GetNotifFd()
+		if err != nil {
+			return -1, fmt.Errorf("error getting filter notification fd: %s", err)
+		}
+		notifyFd = fd
+	}
+
+	return int32(notifyFd), nil
+}
+
+// newFilter creates the libseccomp filter for the given seccomp config; the
+// returned boolean indicates if the filter contains a seccomp notify action.
+func newFilter(config *configs.Seccomp) (*libseccomp.ScmpFilter, bool, error) {
 	if config == nil {
-		return -1, errors.New("cannot initialize Seccomp - nil config passed")
+		return nil, false, errors.New("cannot initialize Seccomp - nil config passed")
 	}
 
 	defaultAction, err := getAction(config.DefaultAction, nil)
 	if err != nil {
-		return -1, errors.New("error initializing seccomp - invalid default action")
+		return nil, false, errors.New("error initializing seccomp - invalid default action")
 	}
 
 	filter, err := libseccomp.NewFilter(defaultAction)
 	if err != nil {
-		return -1, fmt.Errorf("error creating filter: %s", err)
+		return nil, false, fmt.Errorf("error creating filter: %s", err)
 	}
 
 	// Add extra architectures
 	for _, arch := range config.Architectures {
 		scmpArch, err := libseccomp.GetArchFromString(arch)
 		if err != nil {
-			return -1, fmt.Errorf("error validating Seccomp architecture: %s", err)
+			return nil, false, fmt.Errorf("error validating Seccomp architecture: %s", err)
 		}
 
 		if err := filter.AddArch(scmpArch); err != nil {
-			return -1, fmt.Errorf("error adding architecture to seccomp filter: %s", err)
+			return nil, false, fmt.Errorf("error adding architecture to seccomp filter: %s", err)
 		}
 	}
 
 	// Unset no new privs bit (i.e., libseccomp won't touch it when loading the filter)
 	if err := filter.SetNoNewPrivsBit(false); err != nil {
-		return -1, fmt.Errorf("error setting no new privileges: %s", err)
+		return nil, false, fmt.Errorf("error setting no new privileges: %s", err)
 	}
 
 	// Add a rule for each syscall
 	notify := false
 	for _, call := range config.Syscalls {
 		if call == nil {
-			return -1, errors.New("encountered nil syscall while initializing Seccomp")
+			return nil, false, errors.New("encountered nil syscall while initializing Seccomp")
 		}
 
 		if call.Action == configs.Notify && notify == false {
 			if err := prepNotify(filter); err != nil {
-				return -1, fmt.Errorf("error preparing seccomp notifications: %s", err)
+				return nil, false, fmt.Errorf("error preparing seccomp notifications: %s", err)
 			}
 			notify = true
 		}
 
 		if err = matchCall(filter, call); err != nil {
-			return -1, err
+			return nil, false, err
 		}
 	}
 
-	if err = filter.Load(); err != nil {
-		return -1, fmt.Errorf("error loading seccomp filter into kernel: %s", err)
+	return filter, notify, nil
+}
+
+// compile builds the seccomp filter for the given config and exports it as a
+// BPF program (see Compile()).
+func compile(config *configs.Seccomp) (*Program, error) {
+	filter, notify, err := newFilter(config)
+	if err != nil {
+		return nil, err
 	}
+	defer filter.Release()
 
-	// If the filter contains a notify action, get the notification file-descriptor
-	if notify {
-		fd, err := filter.GetNotifFd()
-		if err != nil {
-			return -1, fmt.Errorf("error getting filter notification fd: %s", err)
-		}
-		notifyFd = fd
+	fd, err := unix.MemfdCreate("seccomp-bpf", unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("error creating memfd for seccomp filter export: %s", err)
 	}
+	f := os.NewFile(uintptr(fd), "seccomp-bpf")
+	defer f.Close()
 
-	return int32(notifyFd), nil
+	if err := filter.ExportBPF(f); err != nil {
+		return nil, fmt.Errorf("error exporting seccomp filter: %s", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	bpf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading exported seccomp filter: %s", err)
+	}
+
+	return &Program{Bpf: bpf, Notify: notify}, nil
 }
 
 // IsEnabled returns if the kernel has been configured to support seccomp.
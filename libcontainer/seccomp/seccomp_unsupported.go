@@ -18,6 +18,11 @@ func LoadSeccomp(config *configs.Seccomp) (int32, error) {
 	return -1, nil
 }
 
+// compile fails because seccomp is not supported.
+func compile(config *configs.Seccomp) (*Program, error) {
+	return nil, ErrSeccompNotEnabled
+}
+
 // IsEnabled returns false, because it is not supported.
 func IsEnabled() bool {
 	return false
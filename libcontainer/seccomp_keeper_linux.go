@@ -0,0 +1,425 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: env vars that make the init binary run the seccomp fd keeper
+// (see startSeccompKeeper()): the path of its socket, and the pid of the
+// container's init process.
+const (
+	seccompKeeperEnv    = "_LIBCONTAINER_SECCOMP_KEEPER"
+	seccompKeeperPidEnv = "_LIBCONTAINER_SECCOMP_KEEPER_PID"
+)
+
+// sysbox-runc: socket of the seccomp fd keeper, in the container's state dir.
+const seccompKeeperSock = "seccomp-keeper.sock"
+
+// sysbox-runc: how often the seccomp fd keeper checks for fds that are no
+// longer in use.
+const seccompKeeperCheckInterval = time.Second
+
+// seccompKeeperMsg is a message of the seccomp fd keeper's protocol (over a
+// seqpacket socket). An "add" request carries the seccomp fd of the given
+// process; a "get" request is replied to with one message per fd kept
+// (carrying the fd), followed by one with a zero pid.
+type seccompKeeperMsg struct {
+	Op  string `json:"op,omitempty"`
+	Pid int    `json:"pid"`
+}
+
+// keptSeccompFd is a seccomp notification fd held by the seccomp fd keeper.
+type keptSeccompFd struct {
+	pid int
+	fd  int
+}
+
+func (c *linuxContainer) seccompKeeperSockPath() string {
+	return filepath.Join(c.root, seccompKeeperSock)
+}
+
+// sysbox-runc: startSeccompKeeper starts a detached process (the init binary)
+// that holds a copy of the seccomp notification fds of the container's
+// processes (starting with the given one of its init process), so that they
+// can be re-sent to sysbox-fs if it restarts (see ReconnectSysboxFs()). The
+// kernel fails the trapped syscalls once all copies of a notification fd are
+// closed, and a new one can't be obtained for the running processes.
+//
+// This changes what happens while sysbox-fs is down: the trapped syscalls
+// block until the container is reconnected, rather than fail. So the keeper is
+// only started if the container asks for it (see
+// configs.Config.SeccompKeeper).
+//
+// The keeper exits once the container's init process is gone, or its state
+// dir removed.
+func (c *linuxContainer) startSeccompKeeper(pid int, fd int32) error {
+	sock := c.seccompKeeperSockPath()
+	if err := os.Remove(sock); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: sock, Net: "unixpacket"})
+	if err != nil {
+		return err
+	}
+	// the keeper removes the socket on exit
+	l.SetUnlinkOnClose(false)
+	lf, err := l.File()
+	l.Close()
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+
+	sfd, err := unix.Dup(int(fd))
+	if err != nil {
+		os.Remove(sock)
+		return err
+	}
+	sf := os.NewFile(uintptr(sfd), "seccomp")
+	defer sf.Close()
+
+	cmd := exec.Command(c.initPath, c.initArgs[1:]...)
+	cmd.Args[0] = c.initArgs[0]
+	cmd.Env = []string{
+		seccompKeeperEnv + "=" + sock,
+		seccompKeeperPidEnv + "=" + strconv.Itoa(pid),
+		"_LIBCONTAINER_LOGLEVEL=" + logrus.GetLevel().String(),
+	}
+
+	// the keeper gets the seccomp fd and the socket as fds 3 and 4
+	cmd.ExtraFiles = []*os.File{sf, lf}
+
+	// log to the same file as sysbox-runc, if any (see startRootfsShiftWorker())
+	if f, ok := logrus.StandardLogger().Out.(*os.File); ok && f != os.Stdout && f != os.Stderr {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+		cmd.Env = append(cmd.Env, "_LIBCONTAINER_LOGPIPE=5")
+	}
+
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		os.Remove(sock)
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// sysbox-runc: keepSeccompFd hands the seccomp notification fd of the given
+// process (e.g., one exec'd into the container) to the container's seccomp fd
+// keeper.
+func (c *linuxContainer) keepSeccompFd(pid int, fd int32) error {
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: c.seccompKeeperSockPath(), Net: "unixpacket"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	data, err := json.Marshal(&seccompKeeperMsg{Op: "add", Pid: pid})
+	if err != nil {
+		return err
+	}
+	if _, _, err := conn.WriteMsgUnix(data, unix.UnixRights(int(fd)), nil); err != nil {
+		return err
+	}
+
+	// wait for the keeper to close the connection, so that the fd is kept by
+	// the time this returns
+	buf := make([]byte, 1)
+	conn.Read(buf)
+	return nil
+}
+
+// sysbox-runc: keptSeccompFds returns copies of the seccomp notification fds
+// held by the container's seccomp fd keeper; the caller must close them.
+func (c *linuxContainer) keptSeccompFds() ([]keptSeccompFd, error) {
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: c.seccompKeeperSockPath(), Net: "unixpacket"})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	data, err := json.Marshal(&seccompKeeperMsg{Op: "get"})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(data); err != nil {
+		return nil, err
+	}
+
+	kept := []keptSeccompFd{}
+	closeAll := func() {
+		for _, k := range kept {
+			unix.Close(k.fd)
+		}
+	}
+
+	buf := make([]byte, 256)
+	oob := make([]byte, unix.CmsgSpace(4))
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		var msg seccompKeeperMsg
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("invalid seccomp fd keeper reply: %w", err)
+		}
+		if msg.Pid == 0 {
+			return kept, nil
+		}
+		fd, err := parseRightsFd(oob[:oobn])
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		kept = append(kept, keptSeccompFd{pid: msg.Pid, fd: fd})
+	}
+}
+
+// parseRightsFd returns the fd passed in the given SCM_RIGHTS control message.
+func parseRightsFd(oob []byte) (int, error) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil || len(msgs) != 1 {
+		return -1, fmt.Errorf("parsing socket control msg failed: %v", err)
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return -1, fmt.Errorf("parsing unix rights msg failed: %v", err)
+	}
+	return fds[0], nil
+}
+
+// seccompKeeper is the state of the seccomp fd keeper process.
+type seccompKeeper struct {
+	sock    string
+	initPid int
+	mu      sync.Mutex
+	fds     map[int]int // pid -> seccomp fd
+}
+
+// runSeccompKeeper runs the seccomp fd keeper (see startSeccompKeeper()); it
+// returns the keeper's exit code.
+func runSeccompKeeper(sock string) int {
+	initPid, err := strconv.Atoi(os.Getenv(seccompKeeperPidEnv))
+	if err != nil {
+		logrus.Errorf("seccomp fd keeper: invalid init pid: %v", err)
+		return 1
+	}
+
+	l, err := net.FileListener(os.NewFile(4, "seccomp-keeper-sock"))
+	if err != nil {
+		logrus.Errorf("seccomp fd keeper: %v", err)
+		return 1
+	}
+	defer func() {
+		l.Close()
+		os.Remove(sock)
+	}()
+
+	k := &seccompKeeper{
+		sock:    sock,
+		initPid: initPid,
+		fds:     map[int]int{initPid: 3},
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go k.serve(conn.(*net.UnixConn))
+		}
+	}()
+
+	for range time.Tick(seccompKeeperCheckInterval) {
+		if !k.check() {
+			logrus.Debugf("seccomp fd keeper: container init %d gone; exiting", initPid)
+			return 0
+		}
+	}
+	return 0
+}
+
+// check closes the kept fds that are no longer in use (i.e., no process uses
+// their seccomp filter), and returns false if the keeper should exit.
+func (k *seccompKeeper) check() bool {
+	if _, err := os.Stat(k.sock); err != nil {
+		return false
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	pfds := []unix.PollFd{}
+	pids := []int{}
+	for pid, fd := range k.fds {
+		pfds = append(pfds, unix.PollFd{Fd: int32(fd)})
+		pids = append(pids, pid)
+	}
+	if _, err := unix.Poll(pfds, 0); err != nil && err != unix.EINTR {
+		logrus.Warnf("seccomp fd keeper: poll: %v", err)
+		return true
+	}
+
+	for i, pfd := range pfds {
+		pid := pids[i]
+		// the kernel reports POLLHUP once the filter has no users (5.8+); on
+		// older kernels, go by the existence of the init process (the fds of
+		// other processes are kept until the container is gone, as their
+		// children may still use the filter)
+		gone := pfd.Revents&(unix.POLLHUP|unix.POLLERR|unix.POLLNVAL) != 0 ||
+			(pid == k.initPid && unix.Kill(pid, 0) == unix.ESRCH)
+		if !gone {
+			continue
+		}
+		if pid == k.initPid {
+			return false
+		}
+		unix.Close(k.fds[pid])
+		delete(k.fds, pid)
+	}
+	return true
+}
+
+func (k *seccompKeeper) serve(conn *net.UnixConn) {
+	defer conn.Close()
+
+	buf := make([]byte, 256)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return
+	}
+	var msg seccompKeeperMsg
+	if err := json.Unmarshal(buf[:n], &msg); err != nil {
+		logrus.Warnf("seccomp fd keeper: invalid request: %v", err)
+		return
+	}
+
+	switch msg.Op {
+	case "add":
+		fd, err := parseRightsFd(oob[:oobn])
+		if err != nil || msg.Pid <= 0 {
+			logrus.Warnf("seccomp fd keeper: invalid add request (pid %d): %v", msg.Pid, err)
+			return
+		}
+		k.mu.Lock()
+		if old, ok := k.fds[msg.Pid]; ok {
+			unix.Close(old)
+		}
+		k.fds[msg.Pid] = fd
+		k.mu.Unlock()
+
+	case "get":
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		for pid, fd := range k.fds {
+			data, _ := json.Marshal(&seccompKeeperMsg{Pid: pid})
+			if _, _, err := conn.WriteMsgUnix(data, unix.UnixRights(fd), nil); err != nil {
+				return
+			}
+		}
+		data, _ := json.Marshal(&seccompKeeperMsg{})
+		conn.Write(data)
+
+	default:
+		logrus.Warnf("seccomp fd keeper: invalid request %q", msg.Op)
+	}
+}
+
+// sysbox-runc: ReconnectSysboxFs re-registers the container with sysbox-fs and
+// re-sends it the seccomp notification fds of the container's processes (kept
+// by the seccomp fd keeper), e.g., after sysbox-fs restarts and loses its
+// state, so that the container's trapped syscalls are handled again.
+func (c *linuxContainer) ReconnectSysboxFs() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status != Running && status != Created && status != Paused {
+		return newGenericError(errors.New("container not running"), ContainerNotRunning)
+	}
+	if !c.sysFs.Enabled() {
+		return nil
+	}
+	if !c.config.SeccompKeeper {
+		return newGenericError(errors.New("container has no seccomp fd keeper (see the global --seccomp-keeper option)"), ConfigInvalid)
+	}
+
+	// get the fds first, as nothing can be done without them
+	kept, err := c.keptSeccompFds()
+	if err != nil {
+		return newSystemErrorWithCause(err, "getting seccomp fds from the seccomp fd keeper")
+	}
+	defer func() {
+		for _, k := range kept {
+			unix.Close(k.fd)
+		}
+	}()
+
+	// sysbox-fs may have been upgraded
+	if err := c.sysFs.Negotiate(); err != nil {
+		return err
+	}
+
+	var namespaces []specs.LinuxNamespace
+	if path := c.config.Namespaces.PathOf(configs.NEWNET); path != "" {
+		namespaces = append(namespaces, specs.LinuxNamespace{Type: specs.NetworkNamespace, Path: path})
+	}
+
+	quiesced := c.sysFs.Quiesced
+	c.sysFs.PreReg = false
+	c.sysFs.Reg = false
+	c.sysFs.Quiesced = false
+
+	if err := c.sysFs.PreRegister(namespaces); err != nil {
+		return newSystemErrorWithCause(err, "pre-registering with sysbox-fs")
+	}
+	if err := c.sysFs.Register(c.sysFsRegInfo(c.initProcess.pid())); err != nil {
+		return newSystemErrorWithCause(err, "registering with sysbox-fs")
+	}
+	if err := c.sysFs.SetSysctls(c.config.SysboxFsSysctls); err != nil {
+		return newSystemErrorWithCause(err, "setting sysctls in sysbox-fs")
+	}
+	if err := c.sysFs.SendCreationTime(c.created); err != nil {
+		return newSystemErrorWithCause(err, "sending creation timestamp to sysbox-fs")
+	}
+
+	for _, k := range kept {
+		if err := c.sysFs.SendSeccompInit(k.pid, c.sysFs.Id, int32(k.fd)); err != nil {
+			return newSystemErrorWithCausef(err, "sending seccomp fd of pid %d to sysbox-fs", k.pid)
+		}
+	}
+
+	// the container was quiesced before it was frozen
+	if quiesced {
+		if err := c.sysFs.Quiesce(); err != nil {
+			return err
+		}
+	}
+
+	_, err = c.updateState(nil)
+	return err
+}
@@ -8,9 +8,7 @@ import (
 
 	"github.com/opencontainers/runc/libcontainer/apparmor"
 	"github.com/opencontainers/runc/libcontainer/keys"
-	"github.com/opencontainers/runc/libcontainer/seccomp"
 	"github.com/opencontainers/runc/libcontainer/system"
-	"github.com/opencontainers/runc/libcontainer/utils"
 	"github.com/opencontainers/selinux/go-selinux"
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
@@ -52,9 +50,6 @@ func (l *linuxSetnsInit) Init() error {
 		if err := setupConsole(l.consoleSocket, l.config, false); err != nil {
 			return err
 		}
-		if err := system.Setctty(); err != nil {
-			return err
-		}
 	}
 	if l.config.NoNewPrivileges {
 		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
@@ -75,9 +70,7 @@ func (l *linuxSetnsInit) Init() error {
 	seccompNotifDone := false
 	seccompFiltDone := false
 
-	if !l.config.NoNewPrivileges &&
-		(l.config.Capabilities != nil && !utils.StringSliceContains(l.config.Capabilities.Effective, "CAP_SYS_ADMIN")) ||
-		(l.config.Config.Capabilities != nil && !utils.StringSliceContains(l.config.Config.Capabilities.Effective, "CAP_SYS_ADMIN")) {
+	if seccompBeforeCapsDrop(l.config) {
 
 		if l.config.Config.SeccompNotif != nil {
 			if err := setupSyscallTraps(l.config, l.pipe); err != nil {
@@ -87,7 +80,7 @@ func (l *linuxSetnsInit) Init() error {
 		}
 
 		if l.config.Config.Seccomp != nil {
-			if _, err := seccomp.LoadSeccomp(l.config.Config.Seccomp); err != nil {
+			if _, err := loadSeccomp(l.config.Config.Seccomp, l.config.SeccompProg); err != nil {
 				return newSystemErrorWithCause(err, "loading seccomp filtering rules")
 			}
 			seccompFiltDone = true
@@ -101,6 +94,18 @@ func (l *linuxSetnsInit) Init() error {
 		return err
 	}
 
+	// sysbox-runc: we are done with the setup that may need the pids reserved
+	// for sysbox-runc (see setnsProcess.reserveCgroupPaths), so move to the sys
+	// container's cgroup root.
+	if l.config.EnterCgroupLate {
+		if err := writeSync(l.pipe, procEnterCgroup); err != nil {
+			return err
+		}
+		if err := readSync(l.pipe, procEnterCgroupDone); err != nil {
+			return newSystemErrorWithCause(err, "entering the container's cgroup")
+		}
+	}
+
 	// Set seccomp as close to execve as possible, so as few syscalls take
 	// place afterward (reducing the amount of syscalls that users need to
 	// enable in their seccomp profiles).
@@ -110,7 +115,7 @@ func (l *linuxSetnsInit) Init() error {
 		}
 	}
 	if l.config.Config.Seccomp != nil && !seccompFiltDone {
-		if _, err := seccomp.LoadSeccomp(l.config.Config.Seccomp); err != nil {
+		if _, err := loadSeccomp(l.config.Config.Seccomp, l.config.SeccompProg); err != nil {
 			return newSystemErrorWithCause(err, "loading seccomp filtering rules")
 		}
 	}
@@ -5,11 +5,13 @@
 package specconv
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -21,6 +23,7 @@ import (
 	"github.com/opencontainers/runc/libcontainer/devices"
 	"github.com/opencontainers/runc/libcontainer/seccomp"
 	libcontainerUtils "github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/opencontainers/runc/libsysbox/mountapi"
 	"github.com/opencontainers/runtime-spec/specs-go"
 
 	"golang.org/x/sys/unix"
@@ -263,6 +266,30 @@ func CreateLibcontainerConfig(opts *CreateOpts) (*configs.Config, error) {
 
 	config.Cgroups = c
 
+	// sysbox-runc: pids reserved for sysbox-runc's helper processes; the rest of
+	// the pids budget is split between the sys container's init tree and the
+	// inner-runtime subtree
+	config.PidsReserve, err = createPidsReserve(spec, c.Resources.PidsLimit)
+	if err != nil {
+		return nil, err
+	}
+	config.PidsSplit, err = createPidsSplit(spec, c.Resources.PidsLimit-config.PidsReserve)
+	if err != nil {
+		return nil, err
+	}
+
+	// sysbox-runc: policy for attaching the device filter (cgroup v2)
+	c.DevicesPolicy, err = devicesPolicy(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// sysbox-runc: rootfs uid shifting mode (when done without shiftfs)
+	config.UidShiftRootfsLazy, err = rootfsShiftLazy(spec)
+	if err != nil {
+		return nil, err
+	}
+
 	// set linux-specific config
 	if spec.Linux != nil {
 		var exists bool
@@ -339,7 +366,7 @@ func CreateLibcontainerConfig(opts *CreateOpts) (*configs.Config, error) {
 }
 
 func createLibcontainerMount(cwd string, m specs.Mount) (*configs.Mount, error) {
-	flags, pgflags, data, ext := parseMountOptions(m.Options)
+	flags, pgflags, data, ext, recAttr := parseMountOptions(m.Options)
 	source := m.Source
 	device := m.Type
 	if flags&unix.MS_BIND != 0 {
@@ -389,6 +416,7 @@ func createLibcontainerMount(cwd string, m specs.Mount) (*configs.Mount, error)
 		PropagationFlags: pgflags,
 		Extensions:       ext,
 		BindSrcInfo:      bindSrcInfo,
+		RecAttr:          recAttr,
 	}, nil
 }
 
@@ -666,6 +694,14 @@ func CreateCgroupConfig(opts *CreateOpts, defaultDevs []*devices.Device) (*confi
 				for k, v := range r.Unified {
 					c.Resources.Unified[k] = v
 				}
+				// sysbox-runc: memory QoS and cpu burst and idle settings get
+				// their own fields
+				if err := MemoryQoSFromUnified(c.Resources); err != nil {
+					return nil, err
+				}
+				if err := CPUFromUnified(c.Resources); err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
@@ -677,6 +713,238 @@ func CreateCgroupConfig(opts *CreateOpts, defaultDevs []*devices.Device) (*confi
 	return c, nil
 }
 
+// sysbox-runc: MemoryQoSFromUnified moves the memory QoS settings (i.e.,
+// memory.high, memory.swap.max and memory.zswap.max) from the given resources'
+// unified (cgroup v2) map to their own fields, so that they are applied to the
+// sys container's child cgroup too. Values are numbers of bytes, or "max" (or
+// -1) for no limit.
+func MemoryQoSFromUnified(r *configs.Resources) error {
+	for _, s := range []struct {
+		key  string
+		dest **int64
+	}{
+		{"memory.high", &r.MemoryHigh},
+		{"memory.swap.max", &r.MemorySwapMax},
+		{"memory.zswap.max", &r.MemoryZswapMax},
+	} {
+		val, ok := r.Unified[s.key]
+		if !ok {
+			continue
+		}
+		if !cgroups.IsCgroup2UnifiedMode() {
+			return fmt.Errorf("unified resource %q requires cgroup v2", s.key)
+		}
+
+		n := int64(-1)
+		if val != "max" && val != "-1" {
+			var err error
+			n, err = strconv.ParseInt(val, 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("unified resource %q: invalid value %q (must be a number of bytes or \"max\")", s.key, val)
+			}
+		}
+		*s.dest = &n
+		delete(r.Unified, s.key)
+	}
+	return nil
+}
+
+// sysbox-runc: CPUFromUnified moves the cpu burst and idle settings (i.e.,
+// cpu.max.burst, in usecs, and cpu.idle, 0 or 1) from the given resources'
+// unified map to their own fields, so that they are applied to the sys
+// container's child cgroup too. As the runtime spec has no fields for them,
+// these unified keys are accepted on cgroup v1 as well (where they map to
+// cpu.cfs_burst_us and cpu.idle); the unified map is cleared if they were its
+// only keys.
+func CPUFromUnified(r *configs.Resources) error {
+	if val, ok := r.Unified["cpu.max.burst"]; ok {
+		burst, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("unified resource %q: invalid value %q (must be a number of usecs)", "cpu.max.burst", val)
+		}
+		r.CpuBurst = &burst
+		delete(r.Unified, "cpu.max.burst")
+	}
+
+	if val, ok := r.Unified["cpu.idle"]; ok {
+		if val != "0" && val != "1" {
+			return fmt.Errorf("unified resource %q: invalid value %q (must be 0 or 1)", "cpu.idle", val)
+		}
+		idle := int64(0)
+		if val == "1" {
+			idle = 1
+		}
+		r.CPUIdle = &idle
+		delete(r.Unified, "cpu.idle")
+	}
+
+	if len(r.Unified) == 0 {
+		r.Unified = nil
+	}
+	return nil
+}
+
+// sysbox-runc: RootfsShiftAnnotation selects how the ownership of the sys
+// container's rootfs is shifted when the host lacks shiftfs:
+//
+// sync (default)  the whole rootfs is chown'ed before the container starts
+// lazy            only the rootfs subtrees the container typically writes to
+//                 early on (e.g., /etc, /var) are chown'ed before the container
+//                 starts; the rest is chown'ed in the background
+//
+// With "lazy", large images start faster, but files outside of those subtrees
+// may show up as owned by "nobody" in the container for a while.
+const RootfsShiftAnnotation = "io.nestybox.sysbox.rootfs-shift"
+
+func rootfsShiftLazy(spec *specs.Spec) (bool, error) {
+	switch val := spec.Annotations[RootfsShiftAnnotation]; val {
+	case "", "sync":
+		return false, nil
+	case "lazy":
+		return true, nil
+	default:
+		return false, fmt.Errorf("annotation %s: invalid value %q (must be \"sync\" or \"lazy\")", RootfsShiftAnnotation, val)
+	}
+}
+
+// sysbox-runc: PidsSplitAnnotation configures the split of the sys container's
+// pids budget; its value is a comma-separated list of "key=value" pairs:
+//
+// inner=<N>         pids limit for the inner-runtime subtree (required)
+// inner-cgroup=<p>  inner-runtime subtree, relative to the sys container's
+//                   cgroup root (defaults to "docker")
+// init=<N>          pids limit for the sys container's init tree (cgroup v2 only)
+//
+// E.g., "inner=4096,init=512".
+const PidsSplitAnnotation = "io.nestybox.sysbox.pids-split"
+
+const defaultPidsSplitInnerPath = "docker"
+
+func createPidsSplit(spec *specs.Spec, pidsLimit int64) (*configs.PidsSplit, error) {
+	val, ok := spec.Annotations[PidsSplitAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	split := &configs.PidsSplit{
+		InnerPath: defaultPidsSplitInnerPath,
+	}
+
+	for _, opt := range strings.Split(val, ",") {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("annotation %s: invalid option %q", PidsSplitAnnotation, opt)
+		}
+		key, v := kv[0], kv[1]
+
+		switch key {
+		case "inner", "init":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("annotation %s: invalid %s pids limit %q", PidsSplitAnnotation, key, v)
+			}
+			if key == "inner" {
+				split.InnerLimit = n
+			} else {
+				split.InitLimit = n
+			}
+		case "inner-cgroup":
+			if v == "" || strings.Contains(v, "/") || v == "." || v == ".." || v == "init.scope" {
+				return nil, fmt.Errorf("annotation %s: invalid inner cgroup %q (must be a single path component other than \"init.scope\")", PidsSplitAnnotation, v)
+			}
+			split.InnerPath = v
+		default:
+			return nil, fmt.Errorf("annotation %s: unknown option %q", PidsSplitAnnotation, key)
+		}
+	}
+
+	if split.InnerLimit == 0 {
+		return nil, fmt.Errorf("annotation %s: missing the inner pids limit", PidsSplitAnnotation)
+	}
+	if split.InitLimit > 0 && !cgroups.IsCgroup2UnifiedMode() {
+		return nil, fmt.Errorf("annotation %s: a pids limit for the init tree requires cgroup v2", PidsSplitAnnotation)
+	}
+
+	// The split must leave room for the init tree within the container's
+	// overall pids limit (if any).
+	if pidsLimit > 0 {
+		if split.InnerLimit >= pidsLimit {
+			return nil, fmt.Errorf("annotation %s: inner pids limit (%d) must be lower than the container's pids limit (%d)",
+				PidsSplitAnnotation, split.InnerLimit, pidsLimit)
+		}
+		if split.InitLimit > pidsLimit {
+			return nil, fmt.Errorf("annotation %s: init pids limit (%d) exceeds the container's pids limit (%d)",
+				PidsSplitAnnotation, split.InitLimit, pidsLimit)
+		}
+	}
+
+	return split, nil
+}
+
+// sysbox-runc: PidsReserveAnnotation sets the number of pids (out of the
+// container's pids limit) reserved for sysbox-runc's helper processes, so that
+// workloads in the sys container (e.g., an inner Docker) can't starve them
+// (e.g., causing "runc exec" to fail). The reserve lowers the pids limit of
+// the workloads, so there's none by default. It only applies to containers
+// with a pids limit, and it's only supported on cgroup v1 (the annotation is
+// rejected on cgroup v2).
+const PidsReserveAnnotation = "io.nestybox.sysbox.pids-reserve"
+
+func createPidsReserve(spec *specs.Spec, pidsLimit int64) (int64, error) {
+	val, ok := spec.Annotations[PidsReserveAnnotation]
+	if !ok {
+		return 0, nil
+	}
+
+	reserve, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || reserve < 0 {
+		return 0, fmt.Errorf("annotation %s: invalid value %q (must be a non-negative integer)", PidsReserveAnnotation, val)
+	}
+	if reserve == 0 || pidsLimit <= 0 {
+		return 0, nil
+	}
+	if cgroups.IsCgroup2UnifiedMode() {
+		return 0, fmt.Errorf("annotation %s: a pids reserve requires cgroup v1", PidsReserveAnnotation)
+	}
+	if reserve >= pidsLimit {
+		return 0, fmt.Errorf("annotation %s: pids reserve (%d) must be lower than the container's pids limit (%d)",
+			PidsReserveAnnotation, reserve, pidsLimit)
+	}
+
+	return reserve, nil
+}
+
+// sysbox-runc: DevicesPolicyAnnotation sets the policy for attaching the sys
+// container's device filter to its cgroup, on cgroup v2 (where device access
+// is controlled by eBPF programs attached to cgroups):
+//
+// multi      other device filters may be attached to the sys container's
+//            cgroup and its descendants; e.g., by a container engine inside
+//            the sys container, for its containers (the default)
+// exclusive  no other device filters may be attached; container engines inside
+//            the sys container (as well as the systemd cgroup driver) fail to
+//            set up device access for their containers
+const DevicesPolicyAnnotation = "io.nestybox.sysbox.devices-policy"
+
+func devicesPolicy(spec *specs.Spec) (string, error) {
+	val, ok := spec.Annotations[DevicesPolicyAnnotation]
+	if !ok {
+		return "", nil
+	}
+
+	switch val {
+	case configs.DevicesPolicyMulti, configs.DevicesPolicyExclusive:
+	default:
+		return "", fmt.Errorf("annotation %s: invalid value %q (must be %q or %q)",
+			DevicesPolicyAnnotation, val, configs.DevicesPolicyMulti, configs.DevicesPolicyExclusive)
+	}
+	if !cgroups.IsCgroup2UnifiedMode() {
+		return "", fmt.Errorf("annotation %s: a devices policy requires cgroup v2", DevicesPolicyAnnotation)
+	}
+
+	return val, nil
+}
+
 func stringToCgroupDeviceRune(s string) (devices.Type, error) {
 	switch s {
 	case "a":
@@ -793,12 +1061,16 @@ func setupUserNamespace(spec *specs.Spec, config *configs.Config) error {
 
 // parseMountOptions parses the string and returns the flags, propagation
 // flags and any mount data that it contains.
-func parseMountOptions(options []string) (int, []int, string, int) {
+//
+// sysbox-runc: it also returns the recursive mount attributes (e.g., of the
+// "rro" option), or nil if there are none.
+func parseMountOptions(options []string) (int, []int, string, int, *configs.MountAttr) {
 	var (
 		flag     int
 		pgflag   []int
 		data     []string
 		extFlags int
+		recAttr  *configs.MountAttr
 	)
 	flags := map[string]struct {
 		clear bool
@@ -828,6 +1100,7 @@ func parseMountOptions(options []string) (int, []int, string, int) {
 		"norelatime":    {true, unix.MS_RELATIME},
 		"nostrictatime": {true, unix.MS_STRICTATIME},
 		"nosuid":        {false, unix.MS_NOSUID},
+		"nosymfollow":   {false, mountapi.MsNoSymFollow},
 		"rbind":         {false, unix.MS_BIND | unix.MS_REC},
 		"relatime":      {false, unix.MS_RELATIME},
 		"remount":       {false, unix.MS_REMOUNT},
@@ -836,6 +1109,7 @@ func parseMountOptions(options []string) (int, []int, string, int) {
 		"silent":        {false, unix.MS_SILENT},
 		"strictatime":   {false, unix.MS_STRICTATIME},
 		"suid":          {true, unix.MS_NOSUID},
+		"symfollow":     {true, mountapi.MsNoSymFollow},
 		"sync":          {false, unix.MS_SYNCHRONOUS},
 	}
 	propagationFlags := map[string]int{
@@ -848,6 +1122,32 @@ func parseMountOptions(options []string) (int, []int, string, int) {
 		"rslave":      unix.MS_SLAVE | unix.MS_REC,
 		"runbindable": unix.MS_UNBINDABLE | unix.MS_REC,
 	}
+	// sysbox-runc: recursive mount attributes, applied via mount_setattr(2);
+	// the access time modes replace each other ("ratime" etc. restore the
+	// default, relatime).
+	recAttrFlags := map[string]struct {
+		clear bool
+		attr  uint64
+	}{
+		"rro":            {false, mountapi.AttrRdonly},
+		"rrw":            {true, mountapi.AttrRdonly},
+		"rnosuid":        {false, mountapi.AttrNosuid},
+		"rsuid":          {true, mountapi.AttrNosuid},
+		"rnodev":         {false, mountapi.AttrNodev},
+		"rdev":           {true, mountapi.AttrNodev},
+		"rnoexec":        {false, mountapi.AttrNoexec},
+		"rexec":          {true, mountapi.AttrNoexec},
+		"rnodiratime":    {false, mountapi.AttrNodiratime},
+		"rdiratime":      {true, mountapi.AttrNodiratime},
+		"rrelatime":      {false, mountapi.AttrRelatime},
+		"rnorelatime":    {true, mountapi.AttrRelatime},
+		"rnoatime":       {false, mountapi.AttrNoatime},
+		"ratime":         {true, mountapi.AttrNoatime},
+		"rstrictatime":   {false, mountapi.AttrStrictatime},
+		"rnostrictatime": {true, mountapi.AttrStrictatime},
+		"rnosymfollow":   {false, mountapi.AttrNosymfollow},
+		"rsymfollow":     {true, mountapi.AttrNosymfollow},
+	}
 	extensionFlags := map[string]struct {
 		clear bool
 		flag  int
@@ -866,6 +1166,24 @@ func parseMountOptions(options []string) (int, []int, string, int) {
 			}
 		} else if f, exists := propagationFlags[o]; exists && f != 0 {
 			pgflag = append(pgflag, f)
+		} else if f, exists := recAttrFlags[o]; exists {
+			if recAttr == nil {
+				recAttr = &configs.MountAttr{}
+			}
+			// access time modes are values of the AttrAtime field
+			if f.attr&^mountapi.AttrAtime == 0 {
+				recAttr.Clr |= mountapi.AttrAtime
+				recAttr.Set &^= mountapi.AttrAtime
+				if !f.clear {
+					recAttr.Set |= f.attr
+				}
+			} else if f.clear {
+				recAttr.Clr |= f.attr
+				recAttr.Set &^= f.attr
+			} else {
+				recAttr.Set |= f.attr
+				recAttr.Clr &^= f.attr
+			}
 		} else if f, exists := extensionFlags[o]; exists && f.flag != 0 {
 			if f.clear {
 				extFlags &= ^f.flag
@@ -876,7 +1194,7 @@ func parseMountOptions(options []string) (int, []int, string, int) {
 			data = append(data, o)
 		}
 	}
-	return flag, pgflag, strings.Join(data, ","), extFlags
+	return flag, pgflag, strings.Join(data, ","), extFlags, recAttr
 }
 
 func SetupSeccomp(config *specs.LinuxSeccomp) (*configs.Seccomp, error) {
@@ -989,3 +1307,41 @@ func createCommandHook(h specs.Hook) configs.Command {
 	}
 	return cmd
 }
+
+// sysbox-runc: CreateHookList converts the given OCI hooks into command hooks
+// (e.g., for hooks given outside of the spec's hooks).
+func CreateHookList(hooks []specs.Hook) configs.HookList {
+	var list configs.HookList
+	for _, h := range hooks {
+		list = append(list, configs.NewCommandHook(createCommandHook(h)))
+	}
+	return list
+}
+
+// sysbox-runc: ProcessSched holds the scheduling attributes of a spec process,
+// i.e., the process.scheduler and process.ioPriority fields added in v1.2 of
+// the OCI runtime spec (which the vendored spec types predate).
+type ProcessSched struct {
+	Scheduler  *configs.Scheduler  `json:"scheduler,omitempty"`
+	IOPriority *configs.IOPriority `json:"ioPriority,omitempty"`
+}
+
+// sysbox-runc: ParseProcessSched parses the scheduling attributes of the given
+// JSON spec process, checking them along the way.
+func ParseProcessSched(data []byte) (*ProcessSched, error) {
+	var ps ProcessSched
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return nil, fmt.Errorf("invalid process scheduling attributes: %v", err)
+	}
+	if ps.Scheduler != nil {
+		if _, err := configs.ToSchedAttr(ps.Scheduler); err != nil {
+			return nil, err
+		}
+	}
+	if ps.IOPriority != nil {
+		if _, err := configs.ToIOPrio(ps.IOPriority); err != nil {
+			return nil, err
+		}
+	}
+	return &ps, nil
+}
@@ -8,9 +8,11 @@ import (
 	"testing"
 
 	dbus "github.com/godbus/dbus/v5"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/configs/validate"
 	"github.com/opencontainers/runc/libcontainer/devices"
+	"github.com/opencontainers/runc/libsysbox/mountapi"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"golang.org/x/sys/unix"
 )
@@ -752,3 +754,274 @@ func TestCreateDevices(t *testing.T) {
 		t.Errorf("device /dev/ram0 not found in config devices; got %v", conf.Devices)
 	}
 }
+
+func TestCreatePidsSplit(t *testing.T) {
+	spec := &specs.Spec{}
+
+	split, err := createPidsSplit(spec, 0)
+	if err != nil || split != nil {
+		t.Fatalf("expected no pids split without annotation; got %v, %v", split, err)
+	}
+
+	spec.Annotations = map[string]string{
+		PidsSplitAnnotation: "inner=4096, inner-cgroup=kubepods",
+	}
+	split, err = createPidsSplit(spec, 8192)
+	if err != nil {
+		t.Fatalf("createPidsSplit failed: %v", err)
+	}
+	if split.InnerLimit != 4096 || split.InnerPath != "kubepods" || split.InitLimit != 0 {
+		t.Errorf("unexpected pids split: %+v", split)
+	}
+
+	spec.Annotations[PidsSplitAnnotation] = "inner=100"
+	split, err = createPidsSplit(spec, 0)
+	if err != nil {
+		t.Fatalf("createPidsSplit failed: %v", err)
+	}
+	if split.InnerPath != defaultPidsSplitInnerPath {
+		t.Errorf("expected default inner cgroup %q; got %q", defaultPidsSplitInnerPath, split.InnerPath)
+	}
+
+	invalid := []string{
+		"",
+		"init",
+		"inner=0",
+		"inner=abc",
+		"inner-cgroup=docker",
+		"inner=100,inner-cgroup=../foo",
+		"inner=100,inner-cgroup=init.scope",
+		"inner=100,bogus=1",
+		"inner=8192",
+	}
+	for _, val := range invalid {
+		spec.Annotations[PidsSplitAnnotation] = val
+		if _, err := createPidsSplit(spec, 8192); err == nil {
+			t.Errorf("expected error for annotation value %q", val)
+		}
+	}
+}
+
+func TestMemoryQoSFromUnified(t *testing.T) {
+	if !cgroups.IsCgroup2UnifiedMode() {
+		t.Skip("memory QoS settings require cgroup v2")
+	}
+
+	r := &configs.Resources{
+		Unified: map[string]string{
+			"memory.high":     "1073741824",
+			"memory.swap.max": "max",
+			"memory.min":      "1024",
+		},
+	}
+	if err := MemoryQoSFromUnified(r); err != nil {
+		t.Fatalf("MemoryQoSFromUnified failed: %v", err)
+	}
+	if r.MemoryHigh == nil || *r.MemoryHigh != 1073741824 {
+		t.Errorf("expected memory high 1073741824; got %v", r.MemoryHigh)
+	}
+	if r.MemorySwapMax == nil || *r.MemorySwapMax != -1 {
+		t.Errorf("expected unlimited swap max; got %v", r.MemorySwapMax)
+	}
+	if r.MemoryZswapMax != nil {
+		t.Errorf("expected no zswap max; got %v", *r.MemoryZswapMax)
+	}
+	if len(r.Unified) != 1 || r.Unified["memory.min"] != "1024" {
+		t.Errorf("expected only memory.min to remain in unified map; got %v", r.Unified)
+	}
+
+	for _, val := range []string{"", "-2", "1G", "abc"} {
+		r := &configs.Resources{Unified: map[string]string{"memory.zswap.max": val}}
+		if err := MemoryQoSFromUnified(r); err == nil {
+			t.Errorf("expected error for value %q", val)
+		}
+	}
+}
+
+func TestCPUFromUnified(t *testing.T) {
+	r := &configs.Resources{
+		Unified: map[string]string{
+			"cpu.max.burst": "20000",
+			"cpu.idle":      "1",
+		},
+	}
+	if err := CPUFromUnified(r); err != nil {
+		t.Fatalf("CPUFromUnified failed: %v", err)
+	}
+	if r.CpuBurst == nil || *r.CpuBurst != 20000 {
+		t.Errorf("expected cpu burst 20000; got %v", r.CpuBurst)
+	}
+	if r.CPUIdle == nil || *r.CPUIdle != 1 {
+		t.Errorf("expected cpu idle 1; got %v", r.CPUIdle)
+	}
+	if r.Unified != nil {
+		t.Errorf("expected empty unified map to be cleared; got %v", r.Unified)
+	}
+
+	r = &configs.Resources{Unified: map[string]string{"cpu.idle": "0", "cpu.weight": "50"}}
+	if err := CPUFromUnified(r); err != nil {
+		t.Fatalf("CPUFromUnified failed: %v", err)
+	}
+	if r.CpuBurst != nil || r.CPUIdle == nil || *r.CPUIdle != 0 {
+		t.Errorf("expected only cpu idle 0; got burst %v, idle %v", r.CpuBurst, r.CPUIdle)
+	}
+	if len(r.Unified) != 1 || r.Unified["cpu.weight"] != "50" {
+		t.Errorf("expected only cpu.weight to remain in unified map; got %v", r.Unified)
+	}
+
+	for _, val := range []string{"", "-1", "max", "2"} {
+		r := &configs.Resources{Unified: map[string]string{"cpu.idle": val, "cpu.max.burst": val}}
+		if err := CPUFromUnified(r); err == nil {
+			t.Errorf("expected error for value %q", val)
+		}
+	}
+}
+
+func TestCreatePidsReserve(t *testing.T) {
+	if cgroups.IsCgroup2UnifiedMode() {
+		t.Skip("pids reserve requires cgroup v1")
+	}
+
+	spec := &specs.Spec{}
+
+	// opt-in
+	for _, limit := range []int64{0, 20, 4096} {
+		reserve, err := createPidsReserve(spec, limit)
+		if err != nil || reserve != 0 {
+			t.Errorf("pids limit %d: expected no pids reserve by default; got %d, %v", limit, reserve, err)
+		}
+	}
+
+	spec.Annotations = map[string]string{PidsReserveAnnotation: "100"}
+	reserve, err := createPidsReserve(spec, 4096)
+	if err != nil || reserve != 100 {
+		t.Errorf("expected pids reserve 100; got %d, %v", reserve, err)
+	}
+	reserve, err = createPidsReserve(spec, 0)
+	if err != nil || reserve != 0 {
+		t.Errorf("expected no pids reserve without a pids limit; got %d, %v", reserve, err)
+	}
+
+	spec.Annotations[PidsReserveAnnotation] = "0"
+	reserve, err = createPidsReserve(spec, 4096)
+	if err != nil || reserve != 0 {
+		t.Errorf("expected no pids reserve; got %d, %v", reserve, err)
+	}
+
+	invalid := []string{"", "-1", "abc", "4096", "5000"}
+	for _, val := range invalid {
+		spec.Annotations[PidsReserveAnnotation] = val
+		if _, err := createPidsReserve(spec, 4096); err == nil {
+			t.Errorf("expected error for annotation value %q", val)
+		}
+	}
+}
+
+func TestDevicesPolicy(t *testing.T) {
+	spec := &specs.Spec{}
+
+	policy, err := devicesPolicy(spec)
+	if err != nil || policy != "" {
+		t.Fatalf("expected default devices policy without annotation; got %q, %v", policy, err)
+	}
+
+	spec.Annotations = map[string]string{DevicesPolicyAnnotation: "bogus"}
+	if _, err := devicesPolicy(spec); err == nil {
+		t.Fatal("expected error for invalid devices policy")
+	}
+
+	for _, val := range []string{configs.DevicesPolicyMulti, configs.DevicesPolicyExclusive} {
+		spec.Annotations[DevicesPolicyAnnotation] = val
+		policy, err = devicesPolicy(spec)
+		if !cgroups.IsCgroup2UnifiedMode() {
+			if err == nil {
+				t.Errorf("expected error for devices policy %q on cgroup v1", val)
+			}
+			continue
+		}
+		if err != nil || policy != val {
+			t.Errorf("expected devices policy %q; got %q, %v", val, policy, err)
+		}
+	}
+}
+
+func TestRootfsShiftLazy(t *testing.T) {
+	spec := &specs.Spec{}
+
+	lazy, err := rootfsShiftLazy(spec)
+	if err != nil || lazy {
+		t.Fatalf("expected sync rootfs shift without annotation; got %v, %v", lazy, err)
+	}
+
+	spec.Annotations = map[string]string{RootfsShiftAnnotation: "lazy"}
+	lazy, err = rootfsShiftLazy(spec)
+	if err != nil || !lazy {
+		t.Fatalf("expected lazy rootfs shift; got %v, %v", lazy, err)
+	}
+
+	spec.Annotations[RootfsShiftAnnotation] = "bogus"
+	if _, err := rootfsShiftLazy(spec); err == nil {
+		t.Errorf("expected error for invalid annotation value")
+	}
+}
+
+func TestParseMountOptionsNewFlags(t *testing.T) {
+	flags, _, data, _, recAttr := parseMountOptions([]string{"nosymfollow", "noatime", "size=1m"})
+	if flags != mountapi.MsNoSymFollow|unix.MS_NOATIME || data != "size=1m" || recAttr != nil {
+		t.Errorf("unexpected flags %#x, data %q, rec attrs %+v", flags, data, recAttr)
+	}
+
+	flags, _, _, _, _ = parseMountOptions([]string{"nosymfollow", "symfollow"})
+	if flags != 0 {
+		t.Errorf("expected symfollow to clear nosymfollow; got flags %#x", flags)
+	}
+
+	tests := []struct {
+		options []string
+		want    configs.MountAttr
+	}{
+		{[]string{"rro", "rnosuid"}, configs.MountAttr{Set: mountapi.AttrRdonly | mountapi.AttrNosuid}},
+		// the last option wins
+		{[]string{"rro", "rrw"}, configs.MountAttr{Clr: mountapi.AttrRdonly}},
+		{[]string{"rnosymfollow", "rnodiratime"}, configs.MountAttr{Set: mountapi.AttrNosymfollow | mountapi.AttrNodiratime}},
+		// access time modes replace each other
+		{[]string{"rnoatime", "rstrictatime"}, configs.MountAttr{Set: mountapi.AttrStrictatime, Clr: mountapi.AttrAtime}},
+		{[]string{"rnoatime", "ratime"}, configs.MountAttr{Clr: mountapi.AttrAtime}},
+	}
+
+	for _, test := range tests {
+		_, _, data, _, recAttr := parseMountOptions(test.options)
+		if data != "" || recAttr == nil || *recAttr != test.want {
+			t.Errorf("parseMountOptions(%v): want rec attrs %+v, got %+v (data %q)", test.options, test.want, recAttr, data)
+		}
+	}
+}
+
+func TestParseProcessSched(t *testing.T) {
+	ps, err := ParseProcessSched([]byte(`{
+		"args": ["sh"],
+		"scheduler": {"policy": "SCHED_RR", "priority": 5},
+		"ioPriority": {"class": "IOPRIO_CLASS_IDLE", "priority": 7}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Scheduler == nil || ps.Scheduler.Policy != "SCHED_RR" || ps.Scheduler.Priority != 5 {
+		t.Errorf("unexpected scheduler %+v", ps.Scheduler)
+	}
+	if ps.IOPriority == nil || ps.IOPriority.Class != "IOPRIO_CLASS_IDLE" || ps.IOPriority.Priority != 7 {
+		t.Errorf("unexpected I/O priority %+v", ps.IOPriority)
+	}
+
+	ps, err = ParseProcessSched([]byte(`{"args": ["sh"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Scheduler != nil || ps.IOPriority != nil {
+		t.Errorf("expected no scheduling attributes, got %+v", ps)
+	}
+
+	if _, err := ParseProcessSched([]byte(`{"scheduler": {"policy": "SCHED_RR"}}`)); err == nil {
+		t.Error("expected error for SCHED_RR without a priority")
+	}
+}
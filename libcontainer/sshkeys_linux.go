@@ -0,0 +1,280 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/user"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: container dir where the SSH host keys are copied to (see
+// configs.SSHKeys).
+const sshHostKeysDir = "/etc/ssh"
+
+// sysbox-runc: sshKeysOwner returns the owner that files for the given
+// container user and group must have in the container's rootfs (as seen from
+// the host).
+type sshKeysOwner func(uid, gid int) (int, int, error)
+
+// sysbox-runc: setupSSHKeys copies the SSH keys from the host into the
+// container's rootfs (see configs.SSHKeys). It must be called once the rootfs
+// ownership is set up (see shiftRootfsOwnership()), so that the files get the
+// right owners.
+func (c *linuxContainer) setupSSHKeys() error {
+	keys := c.config.SSHKeys
+	if keys == nil {
+		return nil
+	}
+
+	owner := c.sshKeysOwner
+
+	if keys.HostKeysDir != "" {
+		if err := copySSHHostKeys(c.config.Rootfs, keys.StoreDir, keys.HostKeysDir, owner); err != nil {
+			return newSystemErrorWithCausef(err, "copying SSH host keys from %s", keys.HostKeysDir)
+		}
+	}
+
+	for _, ak := range keys.AuthorizedKeys {
+		if err := copyAuthorizedKeys(c.config.Rootfs, keys.StoreDir, ak, owner); err != nil {
+			return newSystemErrorWithCausef(err, "copying SSH authorized keys %s for user %s", ak.Path, ak.User)
+		}
+	}
+
+	return nil
+}
+
+// sysbox-runc: sshKeysOwner is the container's sshKeysOwner: with shiftfs on
+// the rootfs, the container's IDs are kept as is (shiftfs maps them);
+// otherwise they're mapped to the host IDs of the container's user namespace.
+func (c *linuxContainer) sshKeysOwner(uid, gid int) (int, int, error) {
+	if c.config.ShiftfsRootfs() {
+		return uid, gid, nil
+	}
+	hostUid, err := c.config.HostUID(uid)
+	if err != nil {
+		return -1, -1, err
+	}
+	hostGid, err := c.config.HostGID(gid)
+	if err != nil {
+		return -1, -1, err
+	}
+	return hostUid, hostGid, nil
+}
+
+// openInKeyStore opens the given path (relative to the given SSH key store
+// dir) read-only, without following symlinks in any of its components, so
+// that it can't refer to a host file outside of the store.
+func openInKeyStore(store, path string) (*os.File, error) {
+	fd, err := unix.Open(store, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: store, Err: err}
+	}
+
+	for _, name := range strings.Split(filepath.Clean(path), "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		if name == ".." {
+			unix.Close(fd)
+			return nil, fmt.Errorf("%s is outside of the SSH key store %s", path, store)
+		}
+		next, err := unix.Openat(fd, name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		unix.Close(fd)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: filepath.Join(store, path), Err: err}
+		}
+		fd = next
+	}
+
+	return os.NewFile(uintptr(fd), filepath.Join(store, path)), nil
+}
+
+// readKeyStoreFile returns the contents of the given regular file of the given
+// dir of the SSH key store; symlinks are not followed.
+func readKeyStoreFile(dir *os.File, name string) ([]byte, error) {
+	fd, err := unix.Openat(int(dir.Fd()), name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filepath.Join(dir.Name(), name), Err: err}
+	}
+	f := os.NewFile(uintptr(fd), filepath.Join(dir.Name(), name))
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", f.Name())
+	}
+	return ioutil.ReadAll(f)
+}
+
+// copySSHHostKeys copies the SSH host keys (ssh_host_*_key files and their
+// .pub files) in the given dir of the given SSH key store to the
+// sshHostKeysDir of the given rootfs, owned by the container's root user; it
+// fails if there are none.
+func copySSHHostKeys(rootfs, store, src string, owner sshKeysOwner) error {
+	dir, err := openInKeyStore(store, src)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+
+	uid, gid, err := owner(0, 0)
+	if err != nil {
+		return err
+	}
+
+	dst, err := securejoin.SecureJoin(rootfs, sshHostKeysDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	copied := 0
+	for _, name := range names {
+		if !strings.HasPrefix(name, "ssh_host_") {
+			continue
+		}
+
+		var perm os.FileMode
+		switch {
+		case strings.HasSuffix(name, "_key"):
+			perm = 0600
+		case strings.HasSuffix(name, ".pub"):
+			perm = 0644
+		default:
+			continue
+		}
+
+		var st unix.Stat_t
+		if err := unix.Fstatat(int(dir.Fd()), name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return err
+		}
+		if st.Mode&unix.S_IFMT != unix.S_IFREG {
+			logrus.Debugf("skipping SSH host key %s (not a regular file)", filepath.Join(dir.Name(), name))
+			continue
+		}
+
+		data, err := readKeyStoreFile(dir, name)
+		if err != nil {
+			return err
+		}
+		if err := writeSSHKeyFile(filepath.Join(dst, name), data, perm, uid, gid); err != nil {
+			return err
+		}
+		copied++
+	}
+
+	if copied == 0 {
+		return fmt.Errorf("no SSH host keys (ssh_host_*_key files) found")
+	}
+
+	return nil
+}
+
+// copyAuthorizedKeys copies the given file of the given SSH key store to the
+// ~/.ssh/authorized_keys file of the given user of the container with the
+// given rootfs, owned by the user and its primary group. The user's home dir
+// must exist.
+func copyAuthorizedKeys(rootfs, store string, ak configs.AuthorizedKeys, owner sshKeysOwner) error {
+	dir, err := openInKeyStore(store, filepath.Dir(ak.Path))
+	if err != nil {
+		return err
+	}
+	data, err := readKeyStoreFile(dir, filepath.Base(ak.Path))
+	dir.Close()
+	if err != nil {
+		return err
+	}
+
+	passwdPath, err := securejoin.SecureJoin(rootfs, "/etc/passwd")
+	if err != nil {
+		return err
+	}
+	users, err := user.ParsePasswdFileFilter(passwdPath, func(u user.User) bool {
+		return u.Name == ak.User
+	})
+	if err != nil {
+		return fmt.Errorf("reading the container's /etc/passwd: %v", err)
+	}
+	if len(users) == 0 {
+		return fmt.Errorf("user not found in the container's /etc/passwd")
+	}
+	u := users[0]
+	if u.Home == "" {
+		return fmt.Errorf("user has no home dir")
+	}
+
+	uid, gid, err := owner(u.Uid, u.Gid)
+	if err != nil {
+		return err
+	}
+
+	home, err := securejoin.SecureJoin(rootfs, u.Home)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(home); err != nil || !fi.IsDir() {
+		return fmt.Errorf("home dir %s not found in the container", u.Home)
+	}
+
+	// sshd ignores the keys if ~/.ssh is writable by others than the user
+	sshDir := filepath.Join(home, ".ssh")
+	if err := os.Mkdir(sshDir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	fi, err := os.Lstat(sshDir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s/.ssh in the container is not a dir", u.Home)
+	}
+	if err := os.Chmod(sshDir, 0700); err != nil {
+		return err
+	}
+	if err := os.Lchown(sshDir, uid, gid); err != nil {
+		return err
+	}
+
+	return writeSSHKeyFile(filepath.Join(sshDir, "authorized_keys"), data, 0600, uid, gid)
+}
+
+// writeSSHKeyFile writes the given SSH key file with the given mode and
+// owner; a symlink at path is not followed (it fails instead).
+func writeSSHKeyFile(path string, data []byte, perm os.FileMode, uid, gid int) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|unix.O_NOFOLLOW, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the file may exist (with other mode and owner) in the container image
+	if err := f.Chown(uid, gid); err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,142 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestCopySSHKeys(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "sshkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	rootfs := filepath.Join(tmp, "rootfs")
+	store := filepath.Join(tmp, "store")
+	keys := filepath.Join(store, "keys")
+	uid, gid := os.Getuid(), os.Getgid()
+
+	// files are owned by the current user (i.e., as if the container's IDs
+	// were all mapped to it)
+	owner := func(int, int) (int, int, error) { return uid, gid, nil }
+
+	write := func(path, data string, perm os.FileMode) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(path, want string, perm os.FileMode) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s: want %q, got %q", path, want, data)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != perm {
+			t.Errorf("%s: want mode %v, got %v", path, perm, fi.Mode().Perm())
+		}
+	}
+
+	write(filepath.Join(rootfs, "etc/passwd"),
+		"root:x:0:0:root:/root:/bin/sh\ndev:x:1000:1000::/home/dev:/bin/sh\nnohome:x:1001:1001::/home/nohome:/bin/sh\n", 0644)
+	if err := os.MkdirAll(filepath.Join(rootfs, "home/dev"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// host keys: the private ones get mode 0600 (even if readable on the
+	// host), the public ones 0644; other files are skipped
+	write(filepath.Join(keys, "ssh_host_ed25519_key"), "priv", 0644)
+	write(filepath.Join(keys, "ssh_host_ed25519_key.pub"), "pub", 0600)
+	write(filepath.Join(keys, "README"), "", 0644)
+
+	// an existing key in the image is overwritten
+	write(filepath.Join(rootfs, "etc/ssh/ssh_host_ed25519_key"), "old", 0666)
+
+	if err := copySSHHostKeys(rootfs, store, "keys", owner); err != nil {
+		t.Fatal(err)
+	}
+	check(filepath.Join(rootfs, "etc/ssh/ssh_host_ed25519_key"), "priv", 0600)
+	check(filepath.Join(rootfs, "etc/ssh/ssh_host_ed25519_key.pub"), "pub", 0644)
+	if _, err := os.Stat(filepath.Join(rootfs, "etc/ssh/README")); !os.IsNotExist(err) {
+		t.Errorf("non-key file copied: %v", err)
+	}
+
+	if err := copySSHHostKeys(rootfs, store, "nokeys", owner); err == nil {
+		t.Error("expected error copying host keys from a missing dir")
+	}
+	write(filepath.Join(store, "empty", "README"), "", 0644)
+	if err := copySSHHostKeys(rootfs, store, "empty", owner); err == nil {
+		t.Error("expected error copying host keys from a dir without keys")
+	}
+
+	// keys outside of the store (via symlinks or "..") are not copied
+	if err := os.Symlink(keys, filepath.Join(store, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := copySSHHostKeys(rootfs, store, "link", owner); err == nil {
+		t.Error("expected error copying host keys through a symlink")
+	}
+	if err := copySSHHostKeys(rootfs, keys, "../keys", owner); err == nil {
+		t.Error("expected error copying host keys from outside of the store")
+	}
+	write(filepath.Join(tmp, "secret"), "secret", 0600)
+	if err := os.Symlink(filepath.Join(tmp, "secret"), filepath.Join(store, "secret.pub")); err != nil {
+		t.Fatal(err)
+	}
+	ak := configs.AuthorizedKeys{Path: "secret.pub", User: "root"}
+	if err := copyAuthorizedKeys(rootfs, store, ak, owner); err == nil {
+		t.Error("expected error copying authorized keys through a symlink")
+	}
+
+	// authorized keys
+	write(filepath.Join(store, "users", "dev.pub"), "ssh-ed25519 AAAA dev", 0644)
+
+	ak = configs.AuthorizedKeys{Path: "users/dev.pub", User: "dev"}
+	if err := copyAuthorizedKeys(rootfs, store, ak, owner); err != nil {
+		t.Fatal(err)
+	}
+	check(filepath.Join(rootfs, "home/dev/.ssh/authorized_keys"), "ssh-ed25519 AAAA dev", 0600)
+	fi, err := os.Stat(filepath.Join(rootfs, "home/dev/.ssh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Errorf("~/.ssh: want mode 0700, got %v", fi.Mode().Perm())
+	}
+
+	// a symlink planted in the image is not followed
+	if err := os.Remove(filepath.Join(rootfs, "home/dev/.ssh/authorized_keys")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(tmp, "target"), filepath.Join(rootfs, "home/dev/.ssh/authorized_keys")); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyAuthorizedKeys(rootfs, store, ak, owner); err == nil {
+		t.Error("expected error writing authorized keys through a symlink")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "target")); !os.IsNotExist(err) {
+		t.Errorf("symlink followed: %v", err)
+	}
+
+	for _, user := range []string{"nobody", "nohome"} {
+		ak := configs.AuthorizedKeys{Path: "users/dev.pub", User: user}
+		if err := copyAuthorizedKeys(rootfs, store, ak, owner); err == nil {
+			t.Errorf("expected error copying authorized keys for user %s", user)
+		}
+	}
+}
@@ -3,18 +3,19 @@
 package libcontainer
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
 	"strconv"
+	"strings"
 
 	"github.com/nestybox/sysbox-runc/libcontainer/mount"
 	"github.com/opencontainers/runc/libcontainer/apparmor"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/keys"
-	"github.com/opencontainers/runc/libcontainer/seccomp"
 	"github.com/opencontainers/runc/libcontainer/system"
-	"github.com/opencontainers/runc/libcontainer/utils"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/opencontainers/selinux/go-selinux"
 	"github.com/pkg/errors"
@@ -38,6 +39,8 @@ const (
 	switchDockerDns
 	seccompFd
 	chown
+	umount
+	stampIdentity
 )
 
 type opReq struct {
@@ -52,10 +55,17 @@ type opReq struct {
 	OldDns string `json:"olddns"`
 	NewDns string `json:"newdns"`
 
-	// chown
+	// chown, umount
 	Path string `json:"path"`
 	Uid  int    `json:"uid"`
 	Gid  int    `json:"gid"`
+
+	// umount
+	Flags int `json:"flags"`
+
+	// stampIdentity (along with Path, Uid and Gid for the machine-id file)
+	Hostname  string `json:"hostname"`
+	MachineID string `json:"machineid"`
 }
 
 func (l *linuxStandardInit) getSessionRingParams() (string, uint32, uint32) {
@@ -89,6 +99,14 @@ func (l *linuxStandardInit) Init() error {
 		return err
 	}
 
+	// sysbox-runc: a joined cgroup ns must be rooted at (or above) the
+	// container's cgroup, or the container's cgroupfs mounts won't show it.
+	if l.config.Config.Namespaces.PathOf(configs.NEWCGROUP) != "" {
+		if err := validateCgroupnsRoot(); err != nil {
+			return newSystemErrorWithCause(err, "validating cgroup namespace")
+		}
+	}
+
 	// initialises the labeling system
 	selinux.GetEnabled()
 	if err := prepareRootfs(l.pipe, l.config); err != nil {
@@ -132,9 +150,6 @@ func (l *linuxStandardInit) Init() error {
 		if err := setupConsole(l.consoleSocket, l.config, true); err != nil {
 			return err
 		}
-		if err := system.Setctty(); err != nil {
-			return errors.Wrap(err, "setctty")
-		}
 	}
 
 	// Finish the rootfs setup.
@@ -185,7 +200,7 @@ func (l *linuxStandardInit) Init() error {
 	// notification, must all execute after the container has been properly
 	// registered with sysbox-fs.
 	if l.config.Config.SwitchDockerDns {
-		if err := switchDockerDnsIP(l.config.Config, l.pipe); err != nil {
+		if err := switchDockerDnsIP(l.config.Config, l.pipe, l.config.SyncVersion); err != nil {
 			return errors.Wrap(err, "switching Docker DNS")
 		}
 	}
@@ -226,9 +241,7 @@ func (l *linuxStandardInit) Init() error {
 	seccompNotifDone := false
 	seccompFiltDone := false
 
-	if !l.config.NoNewPrivileges &&
-		(l.config.Capabilities != nil && !utils.StringSliceContains(l.config.Capabilities.Effective, "CAP_SYS_ADMIN")) ||
-		(l.config.Config.Capabilities != nil && !utils.StringSliceContains(l.config.Config.Capabilities.Effective, "CAP_SYS_ADMIN")) {
+	if seccompBeforeCapsDrop(l.config) {
 
 		if l.config.Config.SeccompNotif != nil {
 			if err := setupSyscallTraps(l.config, l.pipe); err != nil {
@@ -238,7 +251,7 @@ func (l *linuxStandardInit) Init() error {
 		}
 
 		if l.config.Config.Seccomp != nil {
-			if _, err := seccomp.LoadSeccomp(l.config.Config.Seccomp); err != nil {
+			if _, err := loadSeccomp(l.config.Config.Seccomp, l.config.SeccompProg); err != nil {
 				return newSystemErrorWithCause(err, "loading seccomp filtering rules")
 			}
 			seccompFiltDone = true
@@ -307,7 +320,7 @@ func (l *linuxStandardInit) Init() error {
 	// syscalls take place afterward (reducing the amount of syscalls that users need to
 	// enable in their seccomp profiles).
 	if l.config.Config.Seccomp != nil && !seccompFiltDone {
-		if _, err := seccomp.LoadSeccomp(l.config.Config.Seccomp); err != nil {
+		if _, err := loadSeccomp(l.config.Config.Seccomp, l.config.SeccompProg); err != nil {
 			return newSystemErrorWithCause(err, "loading seccomp filtering rules")
 		}
 	}
@@ -324,3 +337,19 @@ func (l *linuxStandardInit) Init() error {
 	}
 	return nil
 }
+
+// sysbox-runc: validateCgroupnsRoot checks that the calling process' cgroups
+// are within the root of its cgroup ns; cgroups outside of it show up as
+// relative to it (i.e., starting with "/..") in /proc/self/cgroup.
+func validateCgroupnsRoot() error {
+	paths, err := cgroups.ParseCgroupFile("/proc/self/cgroup")
+	if err != nil {
+		return err
+	}
+	for subsys, path := range paths {
+		if path == "/.." || strings.HasPrefix(path, "/../") {
+			return fmt.Errorf("the container's cgroup (%s) is outside the root of the cgroup namespace it joined (subsystem %q)", path, subsys)
+		}
+	}
+	return nil
+}
@@ -42,7 +42,7 @@ type containerState interface {
 func destroy(c *linuxContainer) error {
 	if !c.config.Namespaces.Contains(configs.NEWPID) ||
 		c.config.Namespaces.PathOf(configs.NEWPID) != "" {
-		if err := signalAllProcesses(c.cgroupManager, unix.SIGKILL); err != nil {
+		if err := killAllProcesses(c.cgroupManager); err != nil {
 			logrus.Warn(err)
 		}
 	}
@@ -52,9 +52,16 @@ func destroy(c *linuxContainer) error {
 			err = ierr
 		}
 	}
-	if rerr := os.RemoveAll(c.root); err == nil {
+	// sysbox-runc: the container's id-mapped mounts must be unmounted before
+	// its state dir is removed, or their (network) sources would be removed too.
+	if uerr := c.teardownIdmappedMounts(); uerr != nil {
+		if err == nil {
+			err = uerr
+		}
+	} else if rerr := os.RemoveAll(c.root); err == nil {
 		err = rerr
 	}
+	c.unindexState()
 	c.initProcess = nil
 	if herr := runPoststopHooks(c); err == nil {
 		err = herr
@@ -76,7 +83,7 @@ func runPoststopHooks(c *linuxContainer) error {
 	}
 	s.Status = specs.StateStopped
 
-	if err := hooks[configs.Poststop].RunHooks(s); err != nil {
+	if err := hooks[configs.Poststop].RunSysboxHooks(s, c.sysboxHookState()); err != nil {
 		return err
 	}
 
@@ -0,0 +1,262 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/opencontainers/runc/libcontainer/cgroups/fs"
+	"github.com/opencontainers/runc/libcontainer/cgroups/fscommon"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: stateIndexFilename is the state index of a state root (see
+// StateIndex); it's a file, so it's skipped when the root is scanned for
+// containers.
+const stateIndexFilename = "index.json"
+
+// sysbox-runc: IndexEntry is the summary of a container's state kept in the
+// state index of its state root, which is enough to list the container
+// without loading its state.
+type IndexEntry struct {
+	ID                   string            `json:"id"`
+	Version              string            `json:"version"`
+	Labels               []string          `json:"labels,omitempty"`
+	Rootfs               string            `json:"rootfs"`
+	Created              time.Time         `json:"created"`
+	InitProcessPid       int               `json:"init_process_pid"`
+	InitProcessStartTime uint64            `json:"init_process_start"`
+	BootID               string            `json:"boot_id,omitempty"`
+	CgroupPaths          map[string]string `json:"cgroup_paths,omitempty"`
+
+	// StateModTime and StateSize are those of the state file the entry was
+	// taken from; an entry is only valid for that state file (see
+	// IndexEntry.Status()).
+	StateModTime time.Time `json:"state_mod_time"`
+	StateSize    int64     `json:"state_size"`
+}
+
+// sysbox-runc: StateIndex configures a LinuxFactory to return containers that
+// keep their entry in the state index of the state root up to date, so that
+// listing thousands of containers doesn't load each of their states.
+//
+// The index is only a cache: its entries are checked against the state files
+// they were taken from, so that containers whose entry is missing or outdated
+// (e.g., as it was updated by a sysbox-runc without the index, or its update
+// failed) are loaded instead.
+func StateIndex(l *LinuxFactory) error {
+	l.StateIndex = true
+	return nil
+}
+
+// sysbox-runc: ReadStateIndex returns the entries of the state index of the
+// given state root, by container ID, or nil if it has no index.
+func ReadStateIndex(root string) (map[string]IndexEntry, error) {
+	f, err := os.Open(filepath.Join(root, stateIndexFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_SH); err != nil {
+		return nil, err
+	}
+
+	entries := map[string]IndexEntry{}
+	if err := json.NewDecoder(f).Decode(&entries); err != nil {
+		// e.g., it was being written when the host crashed
+		logrus.Warnf("ignoring invalid state index of %s: %v", root, err)
+		return nil, nil
+	}
+	return entries, nil
+}
+
+// updateStateIndex applies the given update to the entries of the state index
+// of the given state root.
+func updateStateIndex(root string, update func(map[string]IndexEntry)) error {
+	f, err := os.OpenFile(filepath.Join(root, stateIndexFilename), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// concurrent sysbox-runc instances update the index; its readers take a
+	// shared lock, so it's rewritten in place
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	entries := map[string]IndexEntry{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			// the containers whose entries are lost are loaded instead
+			logrus.Warnf("resetting invalid state index of %s: %v", root, err)
+			entries = map[string]IndexEntry{}
+		}
+	}
+
+	update(entries)
+
+	if data, err = json.Marshal(entries); err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(data, 0)
+	return err
+}
+
+// indexState records the given state of the container (just saved) in the
+// state index of its state root, if it keeps one. Failures are not fatal: the
+// container's entry is dropped, so that it's loaded instead.
+func (c *linuxContainer) indexState(s *State) {
+	if !c.stateIndex {
+		return
+	}
+	root := filepath.Dir(c.root)
+
+	fi, err := os.Stat(filepath.Join(c.root, stateFilename))
+	if err == nil {
+		entry := IndexEntry{
+			ID:                   s.ID,
+			Version:              s.Config.Version,
+			Labels:               s.Config.Labels,
+			Rootfs:               s.Config.Rootfs,
+			Created:              s.Created,
+			InitProcessPid:       s.InitProcessPid,
+			InitProcessStartTime: s.InitProcessStartTime,
+			BootID:               s.BootID,
+			CgroupPaths:          s.CgroupPaths,
+			StateModTime:         fi.ModTime(),
+			StateSize:            fi.Size(),
+		}
+		err = updateStateIndex(root, func(entries map[string]IndexEntry) {
+			entries[c.id] = entry
+		})
+		if err == nil {
+			return
+		}
+	}
+
+	logrus.Warnf("failed to index the state of container %s: %v", c.id, err)
+	c.unindexState()
+}
+
+// unindexState removes the container's entry from the state index of its
+// state root, if it keeps one.
+func (c *linuxContainer) unindexState() {
+	if !c.stateIndex {
+		return
+	}
+	err := updateStateIndex(filepath.Dir(c.root), func(entries map[string]IndexEntry) {
+		delete(entries, c.id)
+	})
+	if err != nil {
+		logrus.Warnf("failed to remove container %s from the state index: %v", c.id, err)
+	}
+}
+
+// sysbox-runc: Status returns the status of the container with the given
+// entry in the state index of the given state root, as Container.Status()
+// does, or false if the entry is outdated (i.e., the container must be loaded
+// to get its status).
+func (e *IndexEntry) Status(root string) (Status, bool) {
+	fi, err := os.Stat(filepath.Join(root, e.ID, stateFilename))
+	if err != nil || !fi.ModTime().Equal(e.StateModTime) || fi.Size() != e.StateSize {
+		return Stopped, false
+	}
+
+	paused, err := indexedPaused(e.CgroupPaths)
+	if err != nil {
+		return Stopped, false
+	}
+	if paused {
+		return Paused, true
+	}
+
+	if e.BootID != "" && e.BootID != bootID() {
+		return Stopped, true
+	}
+	stat, err := system.Stat(e.InitProcessPid)
+	if err != nil {
+		return Stopped, true
+	}
+	if stat.StartTime != e.InitProcessStartTime || stat.State == system.Zombie || stat.State == system.Dead {
+		return Stopped, true
+	}
+	if _, err := os.Stat(filepath.Join(root, e.ID, execFifoFilename)); err == nil {
+		return Created, true
+	}
+	return Running, true
+}
+
+// indexedPaused returns whether the cgroup with the given paths is frozen.
+func indexedPaused(paths map[string]string) (bool, error) {
+	var (
+		state configs.FreezerState
+		err   error
+	)
+	if cgroups.IsCgroup2UnifiedMode() {
+		if paths[""] == "" {
+			return false, nil
+		}
+		var freeze string
+		freeze, err = fscommon.ReadFile(paths[""], "cgroup.freeze")
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		state = configs.Thawed
+		if strings.TrimSpace(freeze) == "1" {
+			state = configs.Frozen
+		}
+	} else {
+		if paths["freezer"] == "" {
+			return false, nil
+		}
+		state, err = (&fs.FreezerGroup{}).GetState(paths["freezer"])
+	}
+	if err != nil {
+		return false, err
+	}
+	return state == configs.Frozen, nil
+}
+
+var (
+	bootIDOnce sync.Once
+	bootIDVal  string
+)
+
+// sysbox-runc: bootID returns the ID of the current boot (empty if unknown),
+// which is recorded in the containers' state: the init process of a container
+// from a previous boot is gone, even if its pid and start time match those of
+// a process of the current boot (i.e., with a state root that survives
+// reboots).
+func bootID() string {
+	bootIDOnce.Do(func() {
+		data, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+		if err != nil {
+			logrus.Debugf("failed to read the boot id: %v", err)
+			return
+		}
+		bootIDVal = strings.TrimSpace(string(data))
+	})
+	return bootIDVal
+}
@@ -0,0 +1,130 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+func TestStateIndex(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestStateIndex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	entries, err := ReadStateIndex(root)
+	if err != nil || entries != nil {
+		t.Fatalf("expected no index, got %v (err = %v)", entries, err)
+	}
+
+	c := &linuxContainer{
+		id:         "test",
+		root:       filepath.Join(root, "test"),
+		stateIndex: true,
+	}
+	if err := os.Mkdir(c.root, 0700); err != nil {
+		t.Fatal(err)
+	}
+	stateFile := filepath.Join(c.root, stateFilename)
+	if err := ioutil.WriteFile(stateFile, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the test process plays the container's init process
+	stat, err := system.Stat(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.indexState(&State{
+		BaseState: BaseState{
+			ID:                   c.id,
+			InitProcessPid:       os.Getpid(),
+			InitProcessStartTime: stat.StartTime,
+		},
+		BootID: bootID(),
+	})
+
+	entries, err = ReadStateIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := entries[c.id]
+	if !ok {
+		t.Fatalf("container not indexed: %v", entries)
+	}
+
+	if status, valid := entry.Status(root); !valid || status != Running {
+		t.Errorf("expected a valid running status, got %s (valid = %v)", status, valid)
+	}
+
+	fifo := filepath.Join(c.root, execFifoFilename)
+	if err := ioutil.WriteFile(fifo, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if status, valid := entry.Status(root); !valid || status != Created {
+		t.Errorf("expected a valid created status, got %s (valid = %v)", status, valid)
+	}
+	os.Remove(fifo)
+
+	// a container from a previous boot is gone
+	prevBoot := entry
+	prevBoot.BootID = "previous-boot"
+	if status, valid := prevBoot.Status(root); !valid || status != Stopped {
+		t.Errorf("expected a valid stopped status for a previous boot, got %s (valid = %v)", status, valid)
+	}
+
+	// an entry taken from another state file is outdated
+	if err := ioutil.WriteFile(stateFile, []byte(`{"id":"test"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, valid := entry.Status(root); valid {
+		t.Error("expected the entry of an updated state to be outdated")
+	}
+
+	c.unindexState()
+	entries, err = ReadStateIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entries[c.id]; ok {
+		t.Errorf("container still indexed: %v", entries)
+	}
+}
+
+func TestStateIndexInvalid(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestStateIndexInvalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// e.g., it was being written when the host crashed
+	if err := ioutil.WriteFile(filepath.Join(root, stateIndexFilename), []byte(`{"test":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadStateIndex(root)
+	if err != nil || entries != nil {
+		t.Fatalf("expected the invalid index to be ignored, got %v (err = %v)", entries, err)
+	}
+
+	err = updateStateIndex(root, func(entries map[string]IndexEntry) {
+		entries["test"] = IndexEntry{ID: "test"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = ReadStateIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries["test"].ID != "test" {
+		t.Errorf("expected the index to be reset, got %v", entries)
+	}
+}
@@ -10,4 +10,7 @@ type Stats struct {
 	Interfaces    []*types.NetworkInterface
 	CgroupStats   *cgroups.Stats
 	IntelRdtStats *intelrdt.Stats
+	// sysbox-runc: pressure stall information of the container's child cgroup
+	// (see CgroupStats.Pressure for that of the container's cgroup)
+	ChildPressure *cgroups.PressureStats
 }
@@ -1,10 +1,12 @@
 package libcontainer
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 
 	"github.com/opencontainers/runc/libcontainer/utils"
 )
@@ -17,11 +19,20 @@ type syncType string
 //
 // [  child  ] <-> [   parent   ]
 //
-// opReq        -->
-//              <-- sendOpInfo
-// [send(info)] --> [recv(info)]
+// procVersion  --> [use the version]
+//
+// (sent first, and only if the parent's version in the initConfig is
+// syncVersionFramed or later; see syncVersion)
+//
+// reqOp        -->
+// [send(reqs)] --> [recv(reqs), do ops]
 //              <-- opDone
 //
+// (the child may send several reqOp before reading their opDone, which are
+// sent in order; the reqs are sent as a binary frame, see writeOpReqs; with
+// syncVersionLegacy, the reqs are sent as JSON, after the parent replies to
+// the reqOp with sendOpInfo, and one reqOp at a time)
+//
 // procHooks    --> [run hooks]
 //              <-- procResume
 //
@@ -36,6 +47,9 @@ type syncType string
 // [send(fd)]   --> [recv(fd)]
 //              <-- procFdDone
 //
+// procEnterCgroup     --> [move process to child cgroup]
+//                     <-- procEnterCgroupDone
+//
 
 const (
 	procError  syncType = "procError"
@@ -44,6 +58,8 @@ const (
 	procHooks  syncType = "procHooks"
 	procResume syncType = "procResume"
 
+	procVersion syncType = "procVersion"
+
 	reqOp      syncType = "reqOp"
 	sendOpInfo syncType = "sendOpInfo"
 	opDone     syncType = "opDone"
@@ -54,41 +70,109 @@ const (
 
 	rootfsReady    syncType = "rootfsReady"
 	rootfsReadyAck syncType = "rootfsReadyAck"
+
+	procEnterCgroup     syncType = "procEnterCgroup"
+	procEnterCgroupDone syncType = "procEnterCgroupDone"
+)
+
+// sysbox-runc: versions of the sync protocol between the parent runc and its
+// children (the container's init and exec processes, and the helpers doing
+// op requests). The children run from the factory's init path, which isn't
+// necessarily the parent's binary (e.g., when it's replaced by an upgrade), so
+// the parent and child agree on the lower of their versions: the parent sends
+// its version in the initConfig, and the child (if the parent has one) replies
+// with the version used in a procVersion sync. A parent or child that predates
+// versions (i.e., that sends or expects none) is at syncVersionLegacy.
+const (
+	// syncVersionLegacy sends op requests as JSON, one at a time.
+	syncVersionLegacy = 1
+
+	// syncVersionFramed adds the version handshake, and sends op requests as
+	// binary frames, pipelined.
+	syncVersionFramed = 2
+
+	// syncVersion is the version of this binary.
+	syncVersion = syncVersionFramed
 )
 
+// negotiateSyncVersion returns the sync protocol version to use with a peer at
+// the given version (0 if it has none).
+func negotiateSyncVersion(peer int) int {
+	if peer < syncVersionLegacy {
+		return syncVersionLegacy
+	}
+	if peer > syncVersion {
+		return syncVersion
+	}
+	return peer
+}
+
 type syncT struct {
 	Type syncType `json:"type"`
+
+	// sysbox-runc: sync protocol version (procVersion only).
+	Version int `json:"version,omitempty"`
+
+	// sysbox-runc: reader of the binary payload that follows the sync, if
+	// any (e.g., the op requests following reqOp); set by parseSync.
+	payload io.Reader
 }
 
 // writeSync is used to write to a synchronisation pipe. An error is returned
 // if there was a problem writing the payload.
 func writeSync(pipe io.Writer, sync syncType) error {
-	return utils.WriteJSON(pipe, syncT{sync})
+	return utils.WriteJSON(pipe, syncT{Type: sync})
+}
+
+// sysbox-runc: writeSyncVersion is used by the child to tell the parent the
+// sync protocol version used.
+func writeSyncVersion(pipe io.Writer, version int) error {
+	return utils.WriteJSON(pipe, syncT{Type: procVersion, Version: version})
+}
+
+// sysbox-runc: checkSyncVersion checks the version in a procVersion sync
+// received from the child, and returns it.
+func checkSyncVersion(sync *syncT) (int, error) {
+	if sync.Version < syncVersionLegacy || sync.Version > syncVersion {
+		return 0, fmt.Errorf("unsupported sync protocol version %d from child (supported: %d to %d)",
+			sync.Version, syncVersionLegacy, syncVersion)
+	}
+	return sync.Version, nil
 }
 
 // readSync is used to read from a synchronisation pipe. An error is returned
 // if we got a genericError, the pipe was closed, or we got an unexpected flag.
 func readSync(pipe io.Reader, expected syncType) error {
-	var procSync syncT
-	if err := json.NewDecoder(pipe).Decode(&procSync); err != nil {
-		if err == io.EOF {
-			return errors.New("parent closed synchronisation channel")
-		}
-		return fmt.Errorf("failed reading error from parent: %v", err)
-	}
-
-	if procSync.Type == procError {
-		var ierr genericError
+	return readSyncs(pipe, expected, 1)
+}
 
-		if err := json.NewDecoder(pipe).Decode(&ierr); err != nil {
+// sysbox-runc: readSyncs is like readSync, but reads the given number of
+// syncs (all of the expected type). They must be read with a single decoder,
+// as it may buffer more than one of them.
+func readSyncs(pipe io.Reader, expected syncType, n int) error {
+	dec := json.NewDecoder(pipe)
+	for i := 0; i < n; i++ {
+		var procSync syncT
+		if err := dec.Decode(&procSync); err != nil {
+			if err == io.EOF {
+				return errors.New("parent closed synchronisation channel")
+			}
 			return fmt.Errorf("failed reading error from parent: %v", err)
 		}
 
-		return &ierr
-	}
+		if procSync.Type == procError {
+			var ierr genericError
 
-	if procSync.Type != expected {
-		return errors.New("invalid synchronisation flag from parent")
+			if err := dec.Decode(&ierr); err != nil {
+				return fmt.Errorf("failed reading error from parent: %v", err)
+			}
+
+			return &ierr
+		}
+
+		if procSync.Type != expected {
+			return errors.New("invalid synchronisation flag from parent")
+		}
 	}
 	return nil
 }
@@ -119,9 +203,22 @@ func parseSync(pipe io.Reader, fn func(*syncT) error) error {
 			panic("No error following JSON procError payload.")
 		}
 
+		// sysbox-runc: the decoder may have buffered part of the payload that
+		// follows the sync (if any), so the payload is read from what it
+		// buffered and then from the pipe, and the decoder is restarted past
+		// what was read.
+		buffered, err := ioutil.ReadAll(dec.Buffered())
+		if err != nil {
+			return err
+		}
+		rest := bytes.NewReader(buffered)
+		sync.payload = io.MultiReader(rest, pipe)
+
 		if err := fn(&sync); err != nil {
 			return err
 		}
+
+		dec = json.NewDecoder(io.MultiReader(rest, pipe))
 	}
 	return nil
 }
@@ -0,0 +1,92 @@
+// +build linux
+
+package libcontainer
+
+import (
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/sirupsen/logrus"
+)
+
+// sysbox-runc: reportUidShift records the uid shifting decision for the given
+// bind mount source (or rootfs, whose destination is "/") in the container's
+// uid shift report (see configs.Config.UidShiftReport).
+func (c *linuxContainer) reportUidShift(src, dest string, method configs.UidShiftMethod, reason string) {
+	c.config.UidShiftReport = append(c.config.UidShiftReport, configs.UidShiftDecision{
+		Source:      src,
+		Destination: dest,
+		Method:      method,
+		Reason:      reason,
+	})
+}
+
+// sysbox-runc: reportRootfsUidShift records the uid shifting decision for the
+// container's rootfs; it must be called before the uid shifting is set up, as
+// that may replace the rootfs path.
+func (c *linuxContainer) reportRootfsUidShift() {
+	config := c.config
+
+	switch {
+	case !config.UidShiftRootfs:
+		c.reportUidShift(config.Rootfs, "/", configs.UidShiftNone, "not required")
+	case config.ShiftfsRootfs():
+		c.reportUidShift(config.Rootfs, "/", configs.UidShiftShiftfs, "")
+	default:
+		reason := "shiftfs not supported or disabled"
+		if config.UidShiftRootfsChown {
+			reason = "chown preferred over shiftfs"
+		}
+		if config.UidShiftRootfsLazy {
+			reason += "; shifted lazily"
+		}
+		c.reportUidShift(config.Rootfs, "/", configs.UidShiftChown, reason)
+	}
+}
+
+// sysbox-runc: completeUidShiftReport records the uid shifting decisions for
+// the bind mounts left unshifted by the uid shifting setup (which records
+// the others), and logs the report.
+func (c *linuxContainer) completeUidShiftReport() error {
+	config := c.config
+
+	reported := map[string]bool{}
+	for _, d := range config.UidShiftReport {
+		reported[d.Destination] = true
+	}
+
+	sysFsMountpoint := ""
+	if c.sysFs.Enabled() {
+		sysFsMountpoint = c.sysFs.Mountpoint
+	}
+
+	for _, m := range config.Mounts {
+		if m.Device != "bind" || reported[m.Destination] {
+			continue
+		}
+
+		needShift, err := needUidShiftOnBindSrc(m, config, sysFsMountpoint)
+		if err != nil {
+			return newSystemErrorWithCause(err, "checking uid shifting on bind source")
+		}
+
+		reason := "not required"
+		if needShift {
+			if !c.sysMgr.Config.BindMountUidShift {
+				reason = "bind mount uid shifting disabled in sysbox-mgr"
+			} else {
+				reason = "shiftfs not supported or disabled"
+			}
+		}
+		c.reportUidShift(m.Source, m.Destination, configs.UidShiftNone, reason)
+	}
+
+	for _, d := range config.UidShiftReport {
+		logrus.WithFields(logrus.Fields{
+			"source":      d.Source,
+			"destination": d.Destination,
+			"method":      d.Method,
+			"reason":      d.Reason,
+		}).Debug("uid shift decision")
+	}
+
+	return nil
+}
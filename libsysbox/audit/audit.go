@@ -0,0 +1,321 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+// Package audit records the changes sysbox-runc makes to the spec of each
+// system container it creates (i.e., what the spec conversion grants or
+// removes, compared to the spec given by the container engine), and writes
+// them to an audit sink (a file, syslog or the systemd journal).
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Change operations.
+const (
+	OpAdd     = "add"     // field only in the new spec
+	OpRemove  = "remove"  // field only in the old spec
+	OpReplace = "replace" // field whose value differs
+)
+
+// Change is a change to a spec field.
+type Change struct {
+	Op string `json:"op"`
+	// the field's jq path (e.g., ".process.cwd"); list elements are
+	// compared by position
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// String returns the change as a line of the form "- <path>: <old>",
+// "+ <path>: <new>" or "~ <path>: <old> -> <new>".
+func (c Change) String() string {
+	switch c.Op {
+	case OpRemove:
+		return fmt.Sprintf("- %s: %s", c.Path, jsonString(c.Old))
+	case OpAdd:
+		return fmt.Sprintf("+ %s: %s", c.Path, jsonString(c.New))
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Path, jsonString(c.Old), jsonString(c.New))
+}
+
+// Changes returns the changes from spec a to spec b, sorted by field.
+func Changes(a, b *specs.Spec) ([]Change, error) {
+	va, err := genericJSON(a)
+	if err != nil {
+		return nil, err
+	}
+	vb, err := genericJSON(b)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	diffValues("", va, vb, &changes)
+	return changes, nil
+}
+
+// genericJSON returns the JSON representation of v as generic values (maps,
+// slices, and scalars).
+func genericJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var g interface{}
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func diffValues(path string, a, b interface{}, changes *[]Change) {
+	switch va := a.(type) {
+
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := []string{}
+		for k := range va {
+			keys = append(keys, k)
+		}
+		for k := range vb {
+			if _, ok := va[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p := path + "." + k
+			ea, okA := va[k]
+			eb, okB := vb[k]
+			switch {
+			case !okB:
+				*changes = append(*changes, Change{Op: OpRemove, Path: p, Old: ea})
+			case !okA:
+				*changes = append(*changes, Change{Op: OpAdd, Path: p, New: eb})
+			default:
+				diffValues(p, ea, eb, changes)
+			}
+		}
+		return
+
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(va) || i < len(vb); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(vb):
+				*changes = append(*changes, Change{Op: OpRemove, Path: p, Old: va[i]})
+			case i >= len(va):
+				*changes = append(*changes, Change{Op: OpAdd, Path: p, New: vb[i]})
+			default:
+				diffValues(p, va[i], vb[i], changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		if path == "" {
+			path = "."
+		}
+		*changes = append(*changes, Change{Op: OpReplace, Path: path, Old: a, New: b})
+	}
+}
+
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+// Summary is the security-relevant gist of the changes to a spec.
+type Summary struct {
+	// capabilities added to any of the process' capability sets
+	CapsAdded []string `json:"capsAdded,omitempty"`
+
+	// syscalls allowed by the new seccomp profile but not by the old one
+	SyscallsAllowed []string `json:"syscallsAllowed,omitempty"`
+
+	// paths no longer masked or read-only
+	MaskedPathsExposed   []string `json:"maskedPathsExposed,omitempty"`
+	ReadonlyPathsExposed []string `json:"readonlyPathsExposed,omitempty"`
+
+	// destinations of the mounts added or removed
+	MountsAdded   []string `json:"mountsAdded,omitempty"`
+	MountsRemoved []string `json:"mountsRemoved,omitempty"`
+}
+
+// Summarize returns the summary of the changes from spec a to spec b.
+func Summarize(a, b *specs.Spec) Summary {
+	var s Summary
+
+	s.CapsAdded = added(caps(a), caps(b))
+	s.SyscallsAllowed = syscallsAllowed(a, b)
+
+	if a.Linux != nil {
+		var masked, readonly []string
+		if b.Linux != nil {
+			masked, readonly = b.Linux.MaskedPaths, b.Linux.ReadonlyPaths
+		}
+		s.MaskedPathsExposed = added(set(masked), set(a.Linux.MaskedPaths))
+		s.ReadonlyPathsExposed = added(set(readonly), set(a.Linux.ReadonlyPaths))
+	}
+
+	s.MountsAdded = added(mounts(a), mounts(b))
+	s.MountsRemoved = added(mounts(b), mounts(a))
+
+	return s
+}
+
+// added returns the (sorted) elements of set b not in set a.
+func added(a, b map[string]bool) []string {
+	var out []string
+	for e := range b {
+		if !a[e] {
+			out = append(out, e)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func set(elems []string) map[string]bool {
+	s := make(map[string]bool, len(elems))
+	for _, e := range elems {
+		s[e] = true
+	}
+	return s
+}
+
+func caps(spec *specs.Spec) map[string]bool {
+	s := map[string]bool{}
+	if spec.Process == nil || spec.Process.Capabilities == nil {
+		return s
+	}
+	c := spec.Process.Capabilities
+	for _, set := range [][]string{c.Bounding, c.Effective, c.Inheritable, c.Permitted, c.Ambient} {
+		for _, cap := range set {
+			s[cap] = true
+		}
+	}
+	return s
+}
+
+// syscallsAllowed returns the syscalls allowed by the seccomp profile of spec
+// b but not by that of spec a: those added to the allow rules of a whitelist
+// profile, or removed from the deny rules of a blacklist one.
+func syscallsAllowed(a, b *specs.Spec) []string {
+	sa, sb := seccompProfile(a), seccompProfile(b)
+	if sb == nil {
+		return nil
+	}
+	if sb.DefaultAction == specs.ActAllow || sb.DefaultAction == specs.ActLog {
+		return added(syscallRules(sb, false), syscallRules(sa, false))
+	}
+	return added(syscallRules(sa, true), syscallRules(sb, true))
+}
+
+func seccompProfile(spec *specs.Spec) *specs.LinuxSeccomp {
+	if spec.Linux == nil {
+		return nil
+	}
+	return spec.Linux.Seccomp
+}
+
+// syscallRules returns the syscalls named in the allow rules (or, if !allow,
+// the deny rules) of the given seccomp profile.
+func syscallRules(seccomp *specs.LinuxSeccomp, allow bool) map[string]bool {
+	s := map[string]bool{}
+	if seccomp == nil {
+		return s
+	}
+	for _, sc := range seccomp.Syscalls {
+		isAllow := sc.Action == specs.ActAllow || sc.Action == specs.ActLog
+		if isAllow != allow {
+			continue
+		}
+		for _, name := range sc.Names {
+			s[name] = true
+		}
+	}
+	return s
+}
+
+func mounts(spec *specs.Spec) map[string]bool {
+	s := map[string]bool{}
+	for _, m := range spec.Mounts {
+		s[m.Destination] = true
+	}
+	return s
+}
+
+// Record is the audit record of a system container's creation.
+type Record struct {
+	Time    time.Time `json:"time"`
+	ID      string    `json:"id"`
+	Summary Summary   `json:"summary"`
+	Changes []Change  `json:"changes"`
+}
+
+// NewRecord returns the audit record of the creation of the container with the
+// given ID, given its spec before (a) and after (b) its conversion.
+func NewRecord(id string, a, b *specs.Spec) (*Record, error) {
+	changes, err := Changes(a, b)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{
+		Time:    time.Now().UTC(),
+		ID:      id,
+		Summary: Summarize(a, b),
+		Changes: changes,
+	}, nil
+}
+
+// CopySpec returns a deep copy of the given spec (e.g., to record it before
+// its conversion).
+func CopySpec(spec *specs.Spec) (*specs.Spec, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var c specs.Spec
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,192 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func testSpecs() (*specs.Spec, *specs.Spec) {
+	a := &specs.Spec{
+		Process: &specs.Process{
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  []string{"CAP_CHOWN", "CAP_KILL"},
+				Effective: []string{"CAP_CHOWN"},
+			},
+		},
+		Mounts: []specs.Mount{{Destination: "/proc"}, {Destination: "/dev/shm"}},
+		Linux: &specs.Linux{
+			MaskedPaths:   []string{"/proc/kcore", "/sys/firmware"},
+			ReadonlyPaths: []string{"/proc/sys", "/proc/bus"},
+			Seccomp: &specs.LinuxSeccomp{
+				DefaultAction: specs.ActErrno,
+				Syscalls: []specs.LinuxSyscall{
+					{Names: []string{"read", "write"}, Action: specs.ActAllow},
+				},
+			},
+		},
+	}
+
+	b := &specs.Spec{
+		Process: &specs.Process{
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  []string{"CAP_CHOWN", "CAP_KILL", "CAP_SYS_ADMIN"},
+				Effective: []string{"CAP_CHOWN", "CAP_KILL", "CAP_SYS_ADMIN"},
+			},
+		},
+		Mounts: []specs.Mount{{Destination: "/proc"}, {Destination: "/sys"}},
+		Linux: &specs.Linux{
+			MaskedPaths:   []string{"/proc/kcore"},
+			ReadonlyPaths: []string{"/proc/bus"},
+			Seccomp: &specs.LinuxSeccomp{
+				DefaultAction: specs.ActErrno,
+				Syscalls: []specs.LinuxSyscall{
+					{Names: []string{"read", "write", "mount"}, Action: specs.ActAllow},
+					{Names: []string{"umount2"}, Action: specs.ActAllow},
+				},
+			},
+		},
+	}
+
+	return a, b
+}
+
+func TestSummarize(t *testing.T) {
+	a, b := testSpecs()
+
+	want := Summary{
+		CapsAdded:            []string{"CAP_SYS_ADMIN"},
+		SyscallsAllowed:      []string{"mount", "umount2"},
+		MaskedPathsExposed:   []string{"/sys/firmware"},
+		ReadonlyPathsExposed: []string{"/proc/sys"},
+		MountsAdded:          []string{"/sys"},
+		MountsRemoved:        []string{"/dev/shm"},
+	}
+	if got := Summarize(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("Summarize() = %+v; want %+v", got, want)
+	}
+
+	if got := Summarize(a, a); !reflect.DeepEqual(got, Summary{}) {
+		t.Errorf("Summarize() on equal specs = %+v; want an empty summary", got)
+	}
+}
+
+func TestSummarizeBlacklist(t *testing.T) {
+	a := &specs.Spec{Linux: &specs.Linux{Seccomp: &specs.LinuxSeccomp{
+		DefaultAction: specs.ActAllow,
+		Syscalls: []specs.LinuxSyscall{
+			{Names: []string{"mount", "kexec_load"}, Action: specs.ActErrno},
+		},
+	}}}
+	b := &specs.Spec{Linux: &specs.Linux{Seccomp: &specs.LinuxSeccomp{
+		DefaultAction: specs.ActAllow,
+		Syscalls: []specs.LinuxSyscall{
+			{Names: []string{"kexec_load"}, Action: specs.ActErrno},
+		},
+	}}}
+
+	if got := Summarize(a, b).SyscallsAllowed; !reflect.DeepEqual(got, []string{"mount"}) {
+		t.Errorf("SyscallsAllowed = %v; want [mount]", got)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink, err := NewSink("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := testSpecs()
+	for _, id := range []string{"c1", "c2"} {
+		rec, err := NewRecord(id, a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("audit log mode = %o; want 600", fi.Mode().Perm())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ids := []string{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.Changes) == 0 || len(rec.Summary.CapsAdded) != 1 {
+			t.Errorf("unexpected record %+v", rec)
+		}
+		ids = append(ids, rec.ID)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"c1", "c2"}) {
+		t.Errorf("got records of %v; want [c1 c2]", ids)
+	}
+}
+
+func TestNewSinkInvalid(t *testing.T) {
+	for _, spec := range []string{"", "file:", "file:relative/path", "bogus"} {
+		if _, err := NewSink(spec); err == nil {
+			t.Errorf("expected error for audit sink %q", spec)
+		}
+	}
+}
+
+func TestCopySpec(t *testing.T) {
+	a, _ := testSpecs()
+	c, err := CopySpec(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Process.Capabilities.Bounding[0] = "CAP_SYS_ADMIN"
+	if a.Process.Capabilities.Bounding[0] != "CAP_CHOWN" {
+		t.Error("CopySpec() returned a shallow copy")
+	}
+}
@@ -0,0 +1,115 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+)
+
+// Sink is where audit records are written to.
+type Sink interface {
+	Write(rec *Record) error
+}
+
+// NewSink returns the audit sink given by the spec, one of:
+//
+// "file:<path>": the file at the given path, to which records are appended as
+// JSON lines (it's created, with mode 0600, if needed).
+//
+// "syslog": the syslog daemon (auth facility, info priority), as JSON.
+//
+// "journald": the systemd journal; the record (as JSON) is in the
+// SYSBOX_AUDIT_RECORD field, the container ID in SYSBOX_CONTAINER_ID.
+func NewSink(spec string) (Sink, error) {
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("invalid audit sink %q: the file path must be absolute", spec)
+		}
+		return fileSink(path), nil
+	case spec == "syslog":
+		return syslogSink{}, nil
+	case spec == "journald":
+		if !journal.Enabled() {
+			return nil, fmt.Errorf("invalid audit sink %q: the systemd journal is not available", spec)
+		}
+		return journalSink{}, nil
+	}
+	return nil, fmt.Errorf("invalid audit sink %q (must be file:<path>, syslog or journald)", spec)
+}
+
+type fileSink string
+
+func (path fileSink) Write(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(string(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	// a single write, so that records of concurrent creations don't interleave
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type syslogSink struct{}
+
+func (syslogSink) Write(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_INFO, "sysbox-runc")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return w.Info(string(data))
+}
+
+type journalSink struct{}
+
+func (journalSink) Write(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("sysbox-runc: spec of container %s converted (%d changes)", rec.ID, len(rec.Changes))
+	return journal.Send(msg, journal.PriInfo, map[string]string{
+		"SYSLOG_IDENTIFIER":   "sysbox-runc",
+		"SYSBOX_CONTAINER_ID": rec.ID,
+		"SYSBOX_AUDIT_RECORD": string(data),
+	})
+}
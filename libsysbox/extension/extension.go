@@ -0,0 +1,321 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+// Package extension implements sysbox-runc's extension points: well-defined
+// points in the creation of a system container at which user-provided
+// extensions run, so that sysbox-runc's behavior can be customized without
+// forking it.
+//
+// The extensions are loaded from a dir (see Load()) holding a subdir per
+// extension point, whose entries are the extensions run at that point, in name
+// order. An extension is either:
+//
+// An executable: it gets a Request (as JSON) on its stdin and replies with a
+// Response (as JSON, or nothing) on its stdout; it fails by exiting with a
+// non-zero status (its stderr is then included in the error).
+//
+// A Go plugin (a file named "*.so"): it must export a function named
+// PluginSymbol, of type func([]byte) ([]byte, error), which gets the Request
+// and returns the Response (both as JSON). Go plugins must be built with the
+// same Go version and dependency versions as sysbox-runc.
+//
+// A failing extension fails the container's creation.
+package extension
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Point is an extension point.
+type Point string
+
+const (
+	// PreConvertSpec runs before the container's spec is converted to a
+	// system container spec (i.e., on the spec as given by the container
+	// engine). It gets the spec, and may return a modified one, which replaces
+	// it (and is passed to the next extension).
+	PreConvertSpec Point = "pre-convert-spec"
+
+	// PostMountSetup runs once the container's rootfs and mounts are set up,
+	// before its init process runs. It gets the pid of the container's init
+	// process and the path of its rootfs.
+	PostMountSetup Point = "post-mount-setup"
+
+	// PreRegisterSysboxFs runs right before the container is registered with
+	// sysbox-fs (if used). It gets the pid of the container's init process
+	// and the registration info.
+	PreRegisterSysboxFs Point = "pre-register-sysboxfs"
+)
+
+// Points are the extension points.
+var Points = []Point{PreConvertSpec, PostMountSetup, PreRegisterSysboxFs}
+
+// Version is the version of the Request / Response contract; it's bumped on
+// incompatible changes.
+const Version = 1
+
+// PluginSymbol is the symbol that Go plugin extensions must export.
+const PluginSymbol = "RunExtension"
+
+// DefaultDir is the default extensions dir.
+const DefaultDir = "/etc/sysbox-runc/extensions"
+
+// DefaultTimeout is the default time executable extensions have to complete.
+const DefaultTimeout = 30 * time.Second
+
+// Request is what an extension gets.
+type Request struct {
+	Version int    `json:"version"`
+	Point   Point  `json:"point"`
+	ID      string `json:"id"`
+
+	// PreConvertSpec
+	Spec *specs.Spec `json:"spec,omitempty"`
+
+	// PostMountSetup and PreRegisterSysboxFs
+	Pid int `json:"pid,omitempty"`
+
+	// PostMountSetup
+	Rootfs string `json:"rootfs,omitempty"`
+
+	// PreRegisterSysboxFs
+	SysboxFs *SysboxFsInfo `json:"sysboxFs,omitempty"`
+}
+
+// SysboxFsInfo is the info the container is registered with in sysbox-fs.
+type SysboxFsInfo struct {
+	Hostname      string   `json:"hostname"`
+	Uid           int      `json:"uid"`
+	Gid           int      `json:"gid"`
+	IdSize        int      `json:"idSize"`
+	ProcRoPaths   []string `json:"procRoPaths,omitempty"`
+	ProcMaskPaths []string `json:"procMaskPaths,omitempty"`
+}
+
+// Response is what an extension replies with.
+type Response struct {
+	// PreConvertSpec: the modified spec (if nil, the spec is unchanged)
+	Spec *specs.Spec `json:"spec,omitempty"`
+}
+
+// extension is a loaded extension.
+type extension struct {
+	name string
+	run  func(req []byte) ([]byte, error)
+}
+
+// Set is a set of extensions. A nil Set has no extensions.
+type Set struct {
+	exts map[Point][]*extension
+}
+
+// Load loads the extensions in the given dir; if it doesn't exist, the set is
+// empty. Entries of the dir other than the extension points' subdirs are
+// ignored (with a warning), as are hidden files in them.
+func Load(dir string, timeout time.Duration) (*Set, error) {
+	s := &Set{exts: make(map[Point][]*extension)}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("reading extensions dir: %v", err)
+	}
+
+	for _, e := range entries {
+		point := Point(e.Name())
+		if !validPoint(point) || !e.IsDir() {
+			logrus.Warnf("ignoring %s in extensions dir %s (extension points are: %s)",
+				e.Name(), dir, pointNames())
+			continue
+		}
+
+		pointDir := filepath.Join(dir, e.Name())
+		files, err := ioutil.ReadDir(pointDir)
+		if err != nil {
+			return nil, fmt.Errorf("reading extensions dir: %v", err)
+		}
+
+		// ReadDir sorts by name
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), ".") || f.IsDir() {
+				continue
+			}
+			path := filepath.Join(pointDir, f.Name())
+
+			var run func([]byte) ([]byte, error)
+			if strings.HasSuffix(f.Name(), ".so") {
+				run, err = loadPlugin(path)
+				if err != nil {
+					return nil, fmt.Errorf("loading extension %s: %v", path, err)
+				}
+			} else {
+				if f.Mode().Perm()&0111 == 0 {
+					return nil, fmt.Errorf("loading extension %s: not executable", path)
+				}
+				run = execRunner(path, timeout)
+			}
+
+			s.exts[point] = append(s.exts[point], &extension{
+				name: filepath.Join(e.Name(), f.Name()),
+				run:  run,
+			})
+		}
+	}
+
+	return s, nil
+}
+
+func validPoint(point Point) bool {
+	for _, p := range Points {
+		if p == point {
+			return true
+		}
+	}
+	return false
+}
+
+func pointNames() string {
+	names := []string{}
+	for _, p := range Points {
+		names = append(names, string(p))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Has returns true if the set has extensions for the given point.
+func (s *Set) Has(point Point) bool {
+	return s != nil && len(s.exts[point]) > 0
+}
+
+// Run runs the extensions for the given point, in order, with the given
+// request; for PreConvertSpec, each extension gets the spec as modified by the
+// previous ones, and the request's spec is updated with the final one.
+func (s *Set) Run(point Point, req *Request) error {
+	if !s.Has(point) {
+		return nil
+	}
+
+	req.Version = Version
+	req.Point = point
+
+	for _, ext := range s.exts[point] {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		logrus.Debugf("running extension %s for container %s", ext.name, req.ID)
+
+		out, err := ext.run(data)
+		if err != nil {
+			return fmt.Errorf("extension %s: %v", ext.name, err)
+		}
+
+		if len(bytes.TrimSpace(out)) == 0 {
+			continue
+		}
+
+		var resp Response
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return fmt.Errorf("extension %s: invalid response: %v", ext.name, err)
+		}
+		if resp.Spec != nil {
+			if point != PreConvertSpec {
+				return fmt.Errorf("extension %s: the spec can't be modified at %s", ext.name, point)
+			}
+			req.Spec = resp.Spec
+		}
+	}
+
+	return nil
+}
+
+// RunPreConvertSpec runs the PreConvertSpec extensions for the container with
+// the given ID and spec; the spec is updated in place.
+func (s *Set) RunPreConvertSpec(id string, spec *specs.Spec) error {
+	if !s.Has(PreConvertSpec) {
+		return nil
+	}
+
+	req := &Request{ID: id, Spec: spec}
+	if err := s.Run(PreConvertSpec, req); err != nil {
+		return err
+	}
+	if req.Spec != spec {
+		*spec = *req.Spec
+	}
+	return nil
+}
+
+// execRunner returns the runner of the executable extension at the given path.
+// The extension runs in its own process group, which is killed if it doesn't
+// complete within the given timeout (if any).
+func execRunner(path string, timeout time.Duration) func([]byte) ([]byte, error) {
+	return func(req []byte) ([]byte, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(path)
+		cmd.Stdin = bytes.NewReader(req)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+
+		// kill the whole group, as descendants left behind would hold the
+		// output pipes open (and thus Wait() would block on them)
+		var timedOut int32
+		if timeout > 0 {
+			timer := time.AfterFunc(timeout, func() {
+				atomic.StoreInt32(&timedOut, 1)
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			})
+			defer timer.Stop()
+		}
+
+		if err := cmd.Wait(); err != nil {
+			if atomic.LoadInt32(&timedOut) == 1 {
+				return nil, fmt.Errorf("timed out after %v", timeout)
+			}
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("%v: %s", err, msg)
+			}
+			return nil, err
+		}
+
+		return stdout.Bytes(), nil
+	}
+}
@@ -0,0 +1,146 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package extension
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func writeExtension(t *testing.T, dir string, point Point, name, script string) {
+	t.Helper()
+	pointDir := filepath.Join(dir, string(point))
+	if err := os.MkdirAll(pointDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pointDir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadMissingDir(t *testing.T) {
+	s, err := Load("/nonexistent/extensions", DefaultTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range Points {
+		if s.Has(p) {
+			t.Errorf("empty set has extensions for %s", p)
+		}
+	}
+
+	// a nil set has no extensions either
+	var nilSet *Set
+	if nilSet.Has(PreConvertSpec) {
+		t.Error("nil set has extensions")
+	}
+	spec := &specs.Spec{Hostname: "foo"}
+	if err := nilSet.RunPreConvertSpec("c1", spec); err != nil || spec.Hostname != "foo" {
+		t.Errorf("RunPreConvertSpec on nil set: %v, %+v", err, spec)
+	}
+}
+
+func TestLoadNotExecutable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extension")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeExtension(t, dir, PostMountSetup, "ext", "exit 0")
+	if err := os.Chmod(filepath.Join(dir, string(PostMountSetup), "ext"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir, DefaultTimeout); err == nil {
+		t.Fatal("expected error loading a non-executable extension")
+	}
+}
+
+func TestRunPreConvertSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extension")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the extensions run in name order, each on the previous one's spec (the
+	// request's other fields are ignored in the response)
+	writeExtension(t, dir, PreConvertSpec, "10-hostname",
+		`sed 's/"hostname":"[^"]*"/"hostname":"ext"/'`)
+	writeExtension(t, dir, PreConvertSpec, "20-check",
+		`grep -q '"hostname":"ext"' || { echo "hostname not set" >&2; exit 1; }`)
+	writeExtension(t, dir, PreConvertSpec, ".hidden", "exit 1")
+	writeExtension(t, dir, PostMountSetup, "ext", "exit 1")
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Load(dir, DefaultTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Has(PreConvertSpec) || !s.Has(PostMountSetup) || s.Has(PreRegisterSysboxFs) {
+		t.Fatalf("unexpected extensions loaded: %+v", s.exts)
+	}
+
+	spec := &specs.Spec{Version: "1.0.2", Hostname: "foo"}
+	if err := s.RunPreConvertSpec("c1", spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Hostname != "ext" || spec.Version != "1.0.2" {
+		t.Fatalf("spec not updated by the extensions: %+v", spec)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extension")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeExtension(t, dir, PostMountSetup, "fail", "echo oops >&2; exit 3")
+	writeExtension(t, dir, PreRegisterSysboxFs, "spec", `echo '{"spec":{"hostname":"x"}}'`)
+	writeExtension(t, dir, PreConvertSpec, "slow", "sleep 5 & sleep 5")
+
+	s, err := Load(dir, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Run(PostMountSetup, &Request{ID: "c1", Pid: 1, Rootfs: "/"})
+	if err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("expected the extension's stderr in the error, got %v", err)
+	}
+
+	// only pre-convert-spec extensions may modify the spec
+	err = s.Run(PreRegisterSysboxFs, &Request{ID: "c1", SysboxFs: &SysboxFsInfo{}})
+	if err == nil {
+		t.Error("expected error modifying the spec at pre-register-sysboxfs")
+	}
+
+	err = s.RunPreConvertSpec("c1", &specs.Spec{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux,cgo
+
+package extension
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// loadPlugin loads the Go plugin extension at the given path.
+func loadPlugin(path string) (func([]byte) ([]byte, error), error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	run, ok := sym.(func([]byte) ([]byte, error))
+	if !ok {
+		return nil, fmt.Errorf("symbol %s is a %T, not a func([]byte) ([]byte, error)", PluginSymbol, sym)
+	}
+	return run, nil
+}
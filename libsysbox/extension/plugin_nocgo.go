@@ -0,0 +1,26 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux,!cgo
+
+package extension
+
+import "errors"
+
+// loadPlugin fails, as Go plugins require cgo.
+func loadPlugin(path string) (func([]byte) ([]byte, error), error) {
+	return nil, errors.New("Go plugin extensions are not supported (sysbox-runc was built without cgo)")
+}
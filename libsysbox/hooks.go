@@ -0,0 +1,79 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package libsysbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// DefaultSysboxHooksDir is the default dir of the host's sysbox hooks.
+const DefaultSysboxHooksDir = "/etc/sysbox-runc/hooks"
+
+// sysboxPoststopDir is the subdir of the sysbox hooks dir holding the poststop
+// hooks.
+const sysboxPoststopDir = "poststop"
+
+// sysboxHookTimeout is the time (in seconds) a sysbox hook has to complete.
+const sysboxHookTimeout = 30
+
+// loadPoststopHooks returns the sysbox poststop hooks in the given sysbox
+// hooks dir: the executables in its "poststop" subdir, in name order (hidden
+// files are ignored). They're run when a container is deleted, after it's
+// unregistered from sysbox-fs and sysbox-mgr, and get the container's state
+// extended with its sysbox state (see configs.HookState). As they run as the
+// host's root, they're only taken from the host's config, never from the
+// container's spec. If the dir doesn't exist, there are none.
+func loadPoststopHooks(dir string) ([]specs.Hook, error) {
+	var hooks []specs.Hook
+
+	hookDir := filepath.Join(dir, sysboxPoststopDir)
+	files, err := ioutil.ReadDir(hookDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading sysbox hooks dir: %v", err)
+	}
+
+	// ReadDir sorts by name
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") || f.IsDir() {
+			continue
+		}
+		path := filepath.Join(hookDir, f.Name())
+		if f.Mode().Perm()&0111 == 0 {
+			return nil, fmt.Errorf("loading sysbox hook %s: not executable", path)
+		}
+
+		timeout := sysboxHookTimeout
+		hooks = append(hooks, specs.Hook{
+			Path:    path,
+			Args:    []string{path},
+			Timeout: &timeout,
+		})
+	}
+
+	return hooks, nil
+}
@@ -0,0 +1,96 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+// Package idmap creates ID-mapped mounts (Linux 5.12+), which shift the
+// ownership of the files under a mount per the ID mappings of a user-ns,
+// without changing the files themselves.
+package idmap
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Syscalls and flags of the new mount API; not all of them are in our version
+// of x/sys/unix. The syscall numbers are the same on all archs.
+const (
+	sysOpenTree     = 428
+	sysMoveMount    = 429
+	sysMountSetattr = 442
+
+	openTreeClone       = 0x1
+	moveMountFEmptyPath = 0x4
+	mountAttrIdmap      = 0x100000
+)
+
+// atFdcwd is unix.AT_FDCWD as a variable, as the (negative) constant can't be
+// converted to uintptr.
+var atFdcwd = unix.AT_FDCWD
+
+type mountAttr struct {
+	attrSet     uint64
+	attrClr     uint64
+	propagation uint64
+	usernsFd    uint64
+}
+
+// Mount creates an ID-mapped bind mount of source on target, with the ID
+// mappings of the user-ns referred to by usernsFd. Mounts under source are not
+// included. Fails with ENOSYS if the kernel doesn't support ID-mapped mounts,
+// and EINVAL if the source's filesystem doesn't.
+func Mount(source, target string, usernsFd int) error {
+	srcPtr, err := unix.BytePtrFromString(source)
+	if err != nil {
+		return err
+	}
+
+	fd, _, errno := unix.Syscall(sysOpenTree, uintptr(atFdcwd), uintptr(unsafe.Pointer(srcPtr)),
+		uintptr(openTreeClone|unix.O_CLOEXEC))
+	if errno != 0 {
+		return fmt.Errorf("failed to clone mount %s: %w", source, errno)
+	}
+	defer unix.Close(int(fd))
+
+	attr := mountAttr{
+		attrSet:  mountAttrIdmap,
+		usernsFd: uint64(usernsFd),
+	}
+
+	empty, _ := unix.BytePtrFromString("")
+
+	_, _, errno = unix.Syscall6(sysMountSetattr, fd, uintptr(unsafe.Pointer(empty)),
+		uintptr(unix.AT_EMPTY_PATH), uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to id-map mount %s: %w", source, errno)
+	}
+
+	dstPtr, err := unix.BytePtrFromString(target)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno = unix.Syscall6(sysMoveMount, fd, uintptr(unsafe.Pointer(empty)),
+		uintptr(atFdcwd), uintptr(unsafe.Pointer(dstPtr)), moveMountFEmptyPath, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to move id-mapped mount of %s to %s: %w", source, target, errno)
+	}
+
+	return nil
+}
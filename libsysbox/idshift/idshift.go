@@ -0,0 +1,602 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package idshift shifts the ownership of a directory tree (e.g., a sys
+// container's rootfs) by chown'ing its files. It's used for uid shifting when
+// the host lacks shiftfs.
+//
+// The tree is walked by a pool of workers, and the shift in progress is
+// recorded in an extended attribute on the tree's root directory, so that a
+// shift interrupted midway (e.g., by a crash of sysbox-runc) is resumed rather
+// than redone on the next attempt.
+//
+// Since a shift may complete in the background while the container runs (see
+// ShiftSubtrees()), the walk never follows symlinks: files are operated on
+// relative to their (verified) parent directory.
+package idshift
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// markerXattr is the extended attribute on the tree's root directory that
+// records the tree's shift.
+const markerXattr = "trusted.sysbox.idshift"
+
+// number of directory entries read at a time by each worker
+const readBatch = 1024
+
+// maximum number of walks of a tree in use (see ShiftTreeLive()) that may
+// still find files to shift, before giving up on completing its shift
+const maxLiveWalks = 4
+
+// afterWalk, if set, is called after each walk of a tree (for testing)
+var afterWalk func(dir string, walk int)
+
+// IDShift describes an ownership shift: uids in [UidFrom, UidFrom+UidSize) are
+// moved to [UidTo, UidTo+UidSize), and likewise for gids. Ids outside of the
+// "from" ranges are left untouched.
+type IDShift struct {
+	UidFrom uint32 `json:"uidFrom"`
+	UidTo   uint32 `json:"uidTo"`
+	UidSize uint32 `json:"uidSize"`
+	GidFrom uint32 `json:"gidFrom"`
+	GidTo   uint32 `json:"gidTo"`
+	GidSize uint32 `json:"gidSize"`
+}
+
+// Marker is the shift record stored on a tree's root directory.
+type Marker struct {
+	Shift IDShift `json:"shift"`
+	Done  bool    `json:"done"`
+}
+
+// ReadMarker returns the shift record for the tree rooted at dir, or nil if the
+// tree has never been shifted.
+func ReadMarker(dir string) (*Marker, error) {
+	buf := make([]byte, 256)
+	sz, err := unix.Lgetxattr(dir, markerXattr, buf)
+	if err == unix.ENODATA || err == unix.ENOTSUP {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read xattr %s on %s: %v", markerXattr, dir, err)
+	}
+
+	var m Marker
+	if err := json.Unmarshal(buf[:sz], &m); err != nil {
+		return nil, fmt.Errorf("invalid xattr %s on %s: %v", markerXattr, dir, err)
+	}
+	return &m, nil
+}
+
+func writeMarker(dir string, m *Marker) error {
+	val, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := unix.Lsetxattr(dir, markerXattr, val, 0); err != nil {
+		return fmt.Errorf("failed to set xattr %s on %s: %v", markerXattr, dir, err)
+	}
+	return nil
+}
+
+// overlaps checks if the "from" and "to" ranges of a shift overlap; if so, the
+// shift can't be resumed safely since shifted and unshifted ids can't be told
+// apart.
+func (s IDShift) overlaps() bool {
+	return (s.UidFrom < s.UidTo+s.UidSize && s.UidTo < s.UidFrom+s.UidSize) ||
+		(s.GidFrom < s.GidTo+s.GidSize && s.GidTo < s.GidFrom+s.GidSize)
+}
+
+func (s IDShift) isNull() bool {
+	return s.UidFrom == s.UidTo && s.GidFrom == s.GidTo
+}
+
+// lockTree takes an exclusive lock on the tree rooted at dir, so that a
+// single shift runs on it at a time; it returns the locked directory.
+func lockTree(dir string) (*os.File, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", dir, err)
+	}
+	return f, nil
+}
+
+// pendingShift returns the shift needed for the tree rooted at dir to have its
+// ids [0, uidSize) and [0, gidSize) map to [uidTo, uidTo+uidSize) and
+// [gidTo, gidTo+gidSize) respectively. If the tree was previously shifted to
+// another range, the shift is relative to that range. A previous shift that's
+// incomplete is resumed if it's to the same range, or completed otherwise.
+func pendingShift(dir string, uidTo, gidTo, uidSize, gidSize uint32, workers int) (IDShift, error) {
+	var uidFrom, gidFrom uint32
+
+	m, err := ReadMarker(dir)
+	if err != nil {
+		return IDShift{}, err
+	}
+
+	if m != nil {
+		if !m.Done {
+			if m.Shift.UidTo == uidTo && m.Shift.GidTo == gidTo {
+				return m.Shift, nil
+			}
+			if err := shiftTree(dir, m.Shift, nil, workers, false); err != nil {
+				return IDShift{}, err
+			}
+		}
+		uidFrom = m.Shift.UidTo
+		gidFrom = m.Shift.GidTo
+	}
+
+	return IDShift{
+		UidFrom: uidFrom,
+		UidTo:   uidTo,
+		UidSize: uidSize,
+		GidFrom: gidFrom,
+		GidTo:   gidTo,
+		GidSize: gidSize,
+	}, nil
+}
+
+// ShiftTree shifts the ownership of the tree rooted at dir such that ids
+// [0, uidSize) and [0, gidSize) map to [uidTo, uidTo+uidSize) and
+// [gidTo, gidTo+gidSize) respectively (see pendingShift()). The tree is walked
+// with the given number of workers; the walk does not cross mount points.
+func ShiftTree(dir string, uidTo, gidTo, uidSize, gidSize uint32, workers int) error {
+	lock, err := lockTree(dir)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	shift, err := pendingShift(dir, uidTo, gidTo, uidSize, gidSize, workers)
+	if err != nil {
+		return err
+	}
+	if shift.isNull() {
+		return nil
+	}
+
+	return shiftTree(dir, shift, nil, workers, false)
+}
+
+// ShiftTreeLive is like ShiftTree(), but for a tree that's in use while it's
+// shifted (e.g., the rootfs of a running container, see ShiftSubtrees()). A
+// walk may miss files moved meanwhile (e.g., renamed from a directory not
+// walked yet into one already walked), so the tree is walked again until a
+// walk finds nothing left to shift; only then is the shift marked done. It
+// fails (leaving the shift incomplete, to be resumed by a later call) if files
+// keep turning up after maxLiveWalks walks.
+func ShiftTreeLive(dir string, uidTo, gidTo, uidSize, gidSize uint32, workers int) error {
+	lock, err := lockTree(dir)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	shift, err := pendingShift(dir, uidTo, gidTo, uidSize, gidSize, workers)
+	if err != nil {
+		return err
+	}
+	if shift.isNull() {
+		return nil
+	}
+
+	return shiftTree(dir, shift, nil, workers, true)
+}
+
+// ShiftSubtrees is like ShiftTree(), but only shifts the given subtrees of the
+// tree rooted at dir (paths relative to dir), along with their ancestors. The
+// tree's shift is left incomplete, to be completed by a later call to
+// ShiftTreeLive() (e.g., in the background, while the container runs). Subtrees
+// that don't exist, or whose path contains symlinks, are skipped.
+func ShiftSubtrees(dir string, subtrees []string, uidTo, gidTo, uidSize, gidSize uint32, workers int) error {
+	lock, err := lockTree(dir)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	shift, err := pendingShift(dir, uidTo, gidTo, uidSize, gidSize, workers)
+	if err != nil {
+		return err
+	}
+	if shift.isNull() {
+		return nil
+	}
+
+	if subtrees == nil {
+		subtrees = []string{}
+	}
+	return shiftTree(dir, shift, subtrees, workers, false)
+}
+
+// shiftTree shifts the ownership of the tree rooted at dir; if subtrees is
+// non-nil, only the given subtrees (and their ancestors) are shifted and the
+// tree's shift is left incomplete. If live is set, the tree is walked again
+// until a walk shifts nothing (see ShiftTreeLive()).
+func shiftTree(dir string, shift IDShift, subtrees []string, workers int, live bool) error {
+	if shift.overlaps() {
+		return fmt.Errorf("can't shift ids on %s: the source and target id ranges overlap (%+v)", dir, shift)
+	}
+
+	if err := writeMarker(dir, &Marker{Shift: shift, Done: false}); err != nil {
+		return err
+	}
+
+	var st unix.Stat_t
+	if err := unix.Lstat(dir, &st); err != nil {
+		return fmt.Errorf("failed to stat %s: %v", dir, err)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	for walks := 1; ; walks++ {
+		shifted, err := walkTree(dir, &st, shift, subtrees, workers)
+		if err != nil {
+			return err
+		}
+		if afterWalk != nil {
+			afterWalk(dir, walks)
+		}
+		if !live || shifted == 0 {
+			break
+		}
+		if walks == maxLiveWalks {
+			return fmt.Errorf("failed to complete the shift of %s: %d files still needed shifting on walk %d",
+				dir, shifted, walks)
+		}
+	}
+
+	// The root directory is shifted last, as its ownership indicates whether
+	// the tree needs shifting when it carries no marker (see
+	// sysbox.CheckUidShifting()).
+	if _, err := shiftEntry(unix.AT_FDCWD, "", dir, &st, shift); err != nil {
+		return err
+	}
+
+	if subtrees != nil {
+		return nil
+	}
+
+	return writeMarker(dir, &Marker{Shift: shift, Done: true})
+}
+
+// walkTree walks the tree rooted at dir (whose stat is st), or the given
+// subtrees of it if subtrees is non-nil, shifting the ownership of its files
+// (but not of dir itself); it returns the number of files shifted.
+func walkTree(dir string, st *unix.Stat_t, shift IDShift, subtrees []string, workers int) (uint64, error) {
+	w := &walker{
+		shift: shift,
+		dev:   st.Dev,
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	if subtrees == nil {
+		w.push(walkDir{path: dir, ino: st.Ino})
+	} else {
+		for _, s := range subtrees {
+			if err := w.pushSubtree(dir, s); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run()
+		}()
+	}
+	wg.Wait()
+
+	return atomic.LoadUint64(&w.shifted), w.err
+}
+
+// walkDir is a directory pending a walk; its inode is checked when it's
+// opened, to detect that the directory was replaced (e.g., by a symlink
+// pointing out of the tree).
+type walkDir struct {
+	path string
+	ino  uint64
+}
+
+// walker holds the queue of directories whose entries are pending a shift;
+// it's shared by all workers.
+type walker struct {
+	shift   IDShift
+	dev     uint64
+	shifted uint64 // files shifted (atomic)
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	dirs    []walkDir
+	pending int // dirs queued or being processed
+	err     error
+}
+
+func (w *walker) push(dir walkDir) {
+	w.mu.Lock()
+	w.dirs = append(w.dirs, dir)
+	w.pending++
+	w.cond.Signal()
+	w.mu.Unlock()
+}
+
+// pushSubtree shifts the ancestors of the given subtree of root (but not root
+// itself), and queues the subtree for a walk.
+func (w *walker) pushSubtree(root, subtree string) error {
+	fd, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", root, err)
+	}
+
+	path := root
+	for _, name := range strings.Split(filepath.Clean("/"+subtree), "/") {
+		if name == "" {
+			continue
+		}
+
+		var st unix.Stat_t
+		err := unix.Fstatat(fd, name, &st, unix.AT_SYMLINK_NOFOLLOW)
+		if err == nil && (st.Mode&unix.S_IFMT != unix.S_IFDIR || st.Dev != w.dev) {
+			err = unix.ENOTDIR
+		}
+		if err == nil {
+			err = w.shiftEntry(fd, procFdPath(fd), name, &st)
+		}
+
+		var next int
+		if err == nil {
+			next, err = unix.Openat(fd, name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		}
+		unix.Close(fd)
+
+		if err == unix.ENOENT || err == unix.ENOTDIR || err == unix.ELOOP {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to shift %s: %v", filepath.Join(path, name), err)
+		}
+
+		fd = next
+		path = filepath.Join(path, name)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return err
+	}
+	if path != root {
+		w.push(walkDir{path: path, ino: st.Ino})
+	}
+	return nil
+}
+
+// pop returns the next directory to process; it returns false once all
+// directories have been processed or an error occurred.
+func (w *walker) pop() (walkDir, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(w.dirs) == 0 && w.pending > 0 && w.err == nil {
+		w.cond.Wait()
+	}
+	if len(w.dirs) == 0 || w.err != nil {
+		return walkDir{}, false
+	}
+
+	// LIFO, to walk depth-first and thus keep the queue short
+	dir := w.dirs[len(w.dirs)-1]
+	w.dirs = w.dirs[:len(w.dirs)-1]
+	return dir, true
+}
+
+func (w *walker) done(err error) {
+	w.mu.Lock()
+	w.pending--
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.pending == 0 || w.err != nil {
+		w.cond.Broadcast()
+	}
+	w.mu.Unlock()
+}
+
+func (w *walker) run() {
+	for {
+		dir, ok := w.pop()
+		if !ok {
+			return
+		}
+		w.done(w.shiftDir(dir))
+	}
+}
+
+// shiftDir shifts the entries of the given directory and queues its
+// subdirectories.
+func (w *walker) shiftDir(dir walkDir) error {
+	fd, err := unix.Open(dir.path, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err == unix.ENOENT || err == unix.ENOTDIR || err == unix.ELOOP {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", dir.path, err)
+	}
+	f := os.NewFile(uintptr(fd), dir.path)
+	defer f.Close()
+
+	// skip the dir if it's no longer the one found during the walk
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("failed to stat %s: %v", dir.path, err)
+	}
+	if st.Dev != w.dev || st.Ino != dir.ino {
+		return nil
+	}
+
+	procDir := procFdPath(fd)
+
+	for {
+		names, err := f.Readdirnames(readBatch)
+		for _, name := range names {
+			var st unix.Stat_t
+			if err := unix.Fstatat(fd, name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+				if err == unix.ENOENT {
+					continue
+				}
+				return fmt.Errorf("failed to stat %s: %v", filepath.Join(dir.path, name), err)
+			}
+
+			// don't cross into other filesystems
+			if st.Dev != w.dev {
+				continue
+			}
+
+			if err := w.shiftEntry(fd, procDir, name, &st); err != nil {
+				return err
+			}
+
+			if st.Mode&unix.S_IFMT == unix.S_IFDIR {
+				w.push(walkDir{path: filepath.Join(dir.path, name), ino: st.Ino})
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read dir %s: %v", dir.path, err)
+		}
+	}
+}
+
+// shiftEntry shifts the ownership of the given file (see shiftEntry()), and
+// counts it if it was shifted.
+func (w *walker) shiftEntry(dirFd int, dirPath, name string, st *unix.Stat_t) error {
+	shifted, err := shiftEntry(dirFd, dirPath, name, st, w.shift)
+	if shifted {
+		atomic.AddUint64(&w.shifted, 1)
+	}
+	return err
+}
+
+func procFdPath(fd int) string {
+	return fmt.Sprintf("/proc/self/fd/%d", fd)
+}
+
+// shiftId returns the shifted id, and whether it changed.
+func shiftId(id, from, to, size uint32) (uint32, bool) {
+	if id >= from && id-from < size {
+		return id - from + to, true
+	}
+	return id, false
+}
+
+// shiftEntry shifts the ownership of the given file (name, relative to the
+// directory open at dirFd, whose path is dirPath), preserving its setuid /
+// setgid bits and file capabilities (which chown clears). It returns whether
+// the file was shifted.
+func shiftEntry(dirFd int, dirPath, name string, st *unix.Stat_t, shift IDShift) (bool, error) {
+	uid, uidChanged := shiftId(st.Uid, shift.UidFrom, shift.UidTo, shift.UidSize)
+	gid, gidChanged := shiftId(st.Gid, shift.GidFrom, shift.GidTo, shift.GidSize)
+
+	if !uidChanged && !gidChanged {
+		return false, nil
+	}
+
+	path := filepath.Join(dirPath, name)
+	isReg := st.Mode&unix.S_IFMT == unix.S_IFREG
+
+	var caps []byte
+	if isReg {
+		buf := make([]byte, 64)
+		sz, err := unix.Lgetxattr(path, "security.capability", buf)
+		if err == nil {
+			caps = buf[:sz]
+		}
+	}
+
+	if err := unix.Fchownat(dirFd, name, int(uid), int(gid), unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		if err == unix.ENOENT {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to chown %s to %d:%d: %v", path, uid, gid, err)
+	}
+
+	if isReg && (caps != nil || st.Mode&(unix.S_ISUID|unix.S_ISGID) != 0) {
+		if err := restoreAttrs(dirFd, name, st, caps); err != nil {
+			return true, fmt.Errorf("failed to restore mode / capabilities on %s: %v", path, err)
+		}
+	}
+
+	return true, nil
+}
+
+// restoreAttrs restores the setuid / setgid bits and file capabilities of the
+// given regular file.
+func restoreAttrs(dirFd int, name string, st *unix.Stat_t, caps []byte) error {
+	fd, err := unix.Openat(dirFd, name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err == unix.ENOENT || err == unix.ELOOP {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	// skip the file if it was replaced since it was chown'ed
+	var fst unix.Stat_t
+	if err := unix.Fstat(fd, &fst); err != nil {
+		return err
+	}
+	if fst.Dev != st.Dev || fst.Ino != st.Ino {
+		return nil
+	}
+
+	if st.Mode&(unix.S_ISUID|unix.S_ISGID) != 0 {
+		if err := unix.Fchmod(fd, st.Mode&07777); err != nil {
+			return err
+		}
+	}
+
+	if caps != nil {
+		if err := unix.Fsetxattr(fd, "security.capability", caps, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
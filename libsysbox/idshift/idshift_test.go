@@ -0,0 +1,317 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package idshift
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// setupTree creates a tree with files owned by various ids (relative to the
+// container's user-ns), plus a hard link and a setuid file.
+func setupTree(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "idshift")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]int{
+		"a":         0,
+		"b/c":       1000,
+		"b/d/e":     0,
+		"b/d/f/g/h": 65535,
+	}
+
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("many/file%d", i)] = i
+	}
+
+	for name, id := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Lchown(path, id, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Link(filepath.Join(dir, "b/c"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "a"), 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a", filepath.Join(dir, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func checkTree(t *testing.T, dir string, uidOffset, gidOffset uint32) {
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		var st unix.Stat_t
+		if err := unix.Lstat(path, &st); err != nil {
+			return err
+		}
+
+		var wantId uint32
+		switch filepath.Base(path) {
+		case "c", "link":
+			wantId = 1000
+		case "h":
+			wantId = 65535
+		default:
+			if filepath.Base(filepath.Dir(path)) == "many" {
+				fmt.Sscanf(filepath.Base(path), "file%d", &wantId)
+			}
+		}
+
+		if st.Uid != wantId+uidOffset || st.Gid != wantId+gidOffset {
+			t.Errorf("%s: want owner %d:%d, got %d:%d", path, wantId+uidOffset, wantId+gidOffset, st.Uid, st.Gid)
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSetuid == 0 {
+		t.Errorf("setuid bit of %s not preserved", filepath.Join(dir, "a"))
+	}
+}
+
+func TestShiftTree(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir := setupTree(t)
+	defer os.RemoveAll(dir)
+
+	if err := ShiftTree(dir, 100000, 200000, 65536, 65536, 4); err != nil {
+		t.Fatalf("ShiftTree() failed: %v", err)
+	}
+	checkTree(t, dir, 100000, 200000)
+
+	m, err := ReadMarker(dir)
+	if err != nil || m == nil || !m.Done {
+		t.Fatalf("ReadMarker(): unexpected marker %+v (err = %v)", m, err)
+	}
+
+	// shifting again to the same range is a no-op
+	if err := ShiftTree(dir, 100000, 200000, 65536, 65536, 4); err != nil {
+		t.Fatalf("ShiftTree() failed: %v", err)
+	}
+	checkTree(t, dir, 100000, 200000)
+
+	// shift to another range
+	if err := ShiftTree(dir, 300000, 300000, 65536, 65536, 4); err != nil {
+		t.Fatalf("ShiftTree() failed: %v", err)
+	}
+	checkTree(t, dir, 300000, 300000)
+}
+
+func TestShiftTreeResume(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir := setupTree(t)
+	defer os.RemoveAll(dir)
+
+	// simulate a shift interrupted midway
+	shift := IDShift{UidFrom: 0, UidTo: 100000, UidSize: 65536, GidFrom: 0, GidTo: 100000, GidSize: 65536}
+	if err := writeMarker(dir, &Marker{Shift: shift, Done: false}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b/c", "many/file3"} {
+		var st unix.Stat_t
+		if err := unix.Lstat(filepath.Join(dir, name), &st); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := shiftEntry(unix.AT_FDCWD, "", filepath.Join(dir, name), &st, shift); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ShiftTree(dir, 100000, 100000, 65536, 65536, 4); err != nil {
+		t.Fatalf("ShiftTree() failed: %v", err)
+	}
+	checkTree(t, dir, 100000, 100000)
+}
+
+func TestShiftTreeOverlap(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir := setupTree(t)
+	defer os.RemoveAll(dir)
+
+	if err := ShiftTree(dir, 1000, 1000, 65536, 65536, 4); err == nil {
+		t.Errorf("ShiftTree(): expected failure on overlapping id ranges")
+	}
+}
+
+func TestShiftSubtrees(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir := setupTree(t)
+	defer os.RemoveAll(dir)
+
+	// a symlink pointing out of the tree must not be followed
+	outside, err := ioutil.TempDir("", "idshift-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	if err := ioutil.WriteFile(filepath.Join(outside, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "evil")); err != nil {
+		t.Fatal(err)
+	}
+
+	subtrees := []string{"/b/d", "evil", "/nonexistent"}
+	if err := ShiftSubtrees(dir, subtrees, 100000, 100000, 65536, 65536, 4); err != nil {
+		t.Fatalf("ShiftSubtrees() failed: %v", err)
+	}
+
+	m, err := ReadMarker(dir)
+	if err != nil || m == nil || m.Done {
+		t.Fatalf("ReadMarker(): unexpected marker %+v (err = %v)", m, err)
+	}
+
+	for _, name := range []string{"", "b", "b/d", "b/d/e", "b/d/f/g/h"} {
+		var st unix.Stat_t
+		if err := unix.Lstat(filepath.Join(dir, name), &st); err != nil {
+			t.Fatal(err)
+		}
+		if st.Uid < 100000 {
+			t.Errorf("%s not shifted by ShiftSubtrees()", filepath.Join(dir, name))
+		}
+	}
+
+	var st unix.Stat_t
+	if err := unix.Lstat(filepath.Join(dir, "b/c"), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Uid != 1000 {
+		t.Errorf("%s shifted by ShiftSubtrees()", filepath.Join(dir, "b/c"))
+	}
+
+	// complete the shift
+	if err := ShiftTree(dir, 100000, 100000, 65536, 65536, 4); err != nil {
+		t.Fatalf("ShiftTree() failed: %v", err)
+	}
+	checkTree(t, dir, 100000, 100000)
+
+	if err := unix.Lstat(filepath.Join(outside, "file"), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Uid != 0 || st.Gid != 0 {
+		t.Errorf("file outside of the tree was shifted")
+	}
+}
+
+func TestShiftTreeLive(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir := setupTree(t)
+	defer os.RemoveAll(dir)
+
+	// an unshifted file moved into a walked dir during the walk (e.g., from
+	// one not walked yet) is caught by a later walk
+	moved := filepath.Join(dir, "b/moved")
+	afterWalk = func(dir string, walk int) {
+		if walk == 1 {
+			if err := ioutil.WriteFile(moved, []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	defer func() { afterWalk = nil }()
+
+	if err := ShiftTreeLive(dir, 100000, 100000, 65536, 65536, 4); err != nil {
+		t.Fatalf("ShiftTreeLive() failed: %v", err)
+	}
+	checkTree(t, dir, 100000, 100000)
+
+	var st unix.Stat_t
+	if err := unix.Lstat(moved, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Uid != 100000 || st.Gid != 100000 {
+		t.Errorf("%s not shifted by ShiftTreeLive()", moved)
+	}
+
+	m, err := ReadMarker(dir)
+	if err != nil || m == nil || !m.Done {
+		t.Fatalf("ReadMarker(): unexpected marker %+v (err = %v)", m, err)
+	}
+}
+
+func TestShiftTreeLiveIncomplete(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir := setupTree(t)
+	defer os.RemoveAll(dir)
+
+	// files that keep turning up leave the shift incomplete
+	afterWalk = func(dir string, walk int) {
+		name := filepath.Join(dir, fmt.Sprintf("b/moved%d", walk))
+		if err := ioutil.WriteFile(name, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() { afterWalk = nil }()
+
+	if err := ShiftTreeLive(dir, 100000, 100000, 65536, 65536, 4); err == nil {
+		t.Fatalf("ShiftTreeLive(): expected failure")
+	}
+
+	m, err := ReadMarker(dir)
+	if err != nil || m == nil || m.Done {
+		t.Fatalf("ReadMarker(): unexpected marker %+v (err = %v)", m, err)
+	}
+}
@@ -0,0 +1,1097 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+// Package libsysbox exposes the sysbox-runc system container creation logic
+// (spec conversion, sysbox-mgr / sysbox-fs registration, and libcontainer
+// factory setup) as a Go API, so that other programs can embed the runtime
+// without invoking the sysbox-runc binary.
+//
+// As with libcontainer, the embedding program must re-exec itself as the
+// container init process: it must import the nsenter package and handle the
+// "init" argument by calling libcontainer.New("").StartInitialization() (see
+// sysbox-runc's init.go). Also, relative paths in the spec (e.g., the rootfs)
+// are resolved against the current working directory, which is expected to be
+// the bundle directory.
+//
+// Errors returned by the API carry a sysbox.ErrorCode (see
+// sysbox.GetErrorCode()). Those due to failed requests to sysbox-mgr or
+// sysbox-fs also carry a *sysbox.IPCError, whose Kind tells whether the
+// request may succeed if retried (see sysbox.IsTemporary()), e.g., while the
+// daemon restarts.
+package libsysbox
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nestybox/sysbox-libs/dockerUtils"
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/specconv"
+	"github.com/opencontainers/runc/libsysbox/audit"
+	"github.com/opencontainers/runc/libsysbox/extension"
+	"github.com/opencontainers/runc/libsysbox/rootfsclone"
+	"github.com/opencontainers/runc/libsysbox/snapshot"
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// CreateOpts holds the options for creating a system container.
+type CreateOpts struct {
+	// Container ID; must be unique on the host.
+	ID string
+
+	// Root directory for storage of container state (e.g., "/run/sysbox-runc").
+	Root string
+
+	// Clients for sysbox-mgr and sysbox-fs; if nil, the corresponding daemon
+	// is not used. Use sysbox.NewMgrWithClient() / sysbox.NewFsWithClient()
+	// to inject custom clients (e.g., to select among multiple sysbox-fs
+	// instances, such as one per tenant; the container's sysbox-fs mounts are
+	// set up under the mountpoint reported by its client). The container is
+	// registered with the daemons under the ID they're created with, which
+	// should be its key (see ContainerKey()) if multiple state roots are used.
+	Mgr *sysbox.Mgr
+	Fs  *sysbox.Fs
+
+	// Skip the host kernel version check.
+	NoKernelCheck bool
+
+	// Dir where sysbox-mgr keeps the dirs backing the container's special
+	// mounts; defaults to DefaultMgrDataDir. If DiskCheck is set, its
+	// filesystem's free space is checked at creation (along with the rootfs',
+	// if it's chown'ed); if it's on btrfs or zfs, sysbox-mgr is asked to back
+	// the container's /var/lib/docker with a subvolume or dataset.
+	MgrDataDir string
+	DiskCheck  bool
+
+	// Dir under which rootfs clones are created for containers that request
+	// them (see syscont.RootfsCloneAnnotation); defaults to
+	// DefaultRootfsCloneDir. Must not be on overlayfs.
+	RootfsCloneDir string
+
+	// Host dirs under which containers may place the writable layer of their
+	// rootfs clone (see syscont.UpperDirAnnotation); if empty, containers
+	// can't choose it.
+	UpperDirs []string
+
+	// Host dirs under which the snapshots containers may be seeded from are
+	// (see syscont.SeedSnapshotAnnotation); if empty, containers can't be
+	// seeded.
+	SnapshotDirs []string
+
+	// Host allowlist of the containers that may request kernel tracing
+	// passthrough (see syscont.KernelTracingAllowed()); defaults to
+	// DefaultKernelTracingAllowlist.
+	KernelTracingAllowlist string
+
+	// Host allowlist of the host dirs containers may share (see
+	// syscont.SharedMountsAllowed()); defaults to DefaultSharedMountsAllowlist.
+	SharedMountsAllowlist string
+
+	// Host allowlist of the configfs subtrees containers may get from the
+	// host (see syscont.ConfigfsPassthroughAllowed()); defaults to
+	// DefaultConfigfsAllowlist.
+	ConfigfsAllowlist string
+
+	// Dir under which the dirs of the containers that capture their core
+	// dumps are created (see syscont.CoreDumpAnnotation), one per container
+	// key; defaults to DefaultCoreDumpDir.
+	CoreDumpDir string
+
+	// Dir the SSH keys copied into the containers are taken from (see
+	// syscont.SSHHostKeysAnnotation); defaults to DefaultSSHKeysDir.
+	SSHKeysDir string
+
+	UseSystemdCgroup bool
+	RootlessCgroups  bool
+	NoPivotRoot      bool
+	NoNewKeyring     bool
+
+	// Uid shifting backends the container may use (see
+	// syscont.ApplyUidShiftBackends()); nil means all.
+	UidShiftBackends []syscont.UidShiftBackend
+
+	// Hold the seccomp notification fds of the container's processes in a
+	// helper process, so that the container can be reconnected to sysbox-fs
+	// if it restarts (see the fs-reconnect command).
+	SeccompKeeper bool
+
+	// FUSE program (bindfs) that uid-shifts the bind mounts whose filesystem
+	// doesn't support ID-mapped mounts (e.g., NFS, CIFS); empty to leave them
+	// unshifted.
+	IdmapFuseHelper string
+
+	// Extensions run at the container's extension points (see package
+	// extension); may be nil.
+	Extensions *extension.Set
+
+	// Dir of the host's sysbox hooks, run with the container's sysbox state
+	// (see loadPoststopHooks()); defaults to DefaultSysboxHooksDir.
+	SysboxHooksDir string
+
+	// Sink the changes made to the container's spec are recorded to (see
+	// package audit); may be nil. The container isn't created if they can't
+	// be recorded.
+	Audit audit.Sink
+
+	// Scheduling attributes of the container's processes, from the spec's
+	// process.scheduler and process.ioPriority fields (see
+	// specconv.ProcessSched); may be nil.
+	Scheduler  *configs.Scheduler
+	IOPriority *configs.IOPriority
+
+	// Options passed to the libcontainer factory (e.g., the cgroup manager);
+	// the sysbox-mgr and sysbox-fs options are added by this package.
+	FactoryOpts []func(*libcontainer.LinuxFactory) error
+}
+
+// DefaultRootfsCloneDir is the default dir for rootfs clones.
+const DefaultRootfsCloneDir = "/var/lib/sysbox-runc/rootfs-clone"
+
+// DefaultMgrDataDir is the default sysbox-mgr data dir.
+const DefaultMgrDataDir = "/var/lib/sysbox"
+
+// DefaultKernelTracingAllowlist is the default kernel tracing allowlist.
+const DefaultKernelTracingAllowlist = "/etc/sysbox-runc/kernel-tracing.allow"
+
+// DefaultSharedMountsAllowlist is the default shared mounts allowlist.
+const DefaultSharedMountsAllowlist = "/etc/sysbox-runc/shared-mounts.allow"
+
+// DefaultConfigfsAllowlist is the default configfs passthrough allowlist.
+const DefaultConfigfsAllowlist = "/etc/sysbox-runc/configfs-passthrough.allow"
+
+// DefaultCoreDumpDir is the default base dir for the containers' core dumps.
+const DefaultCoreDumpDir = "/var/lib/sysbox-runc/coredump"
+
+// DefaultSSHKeysDir is the default SSH key store.
+const DefaultSSHKeysDir = "/etc/sysbox-runc/ssh-keys"
+
+// ContainerKey returns the ID under which the container with the given ID is
+// registered with sysbox-mgr and sysbox-fs. Container IDs are only unique
+// within a state root (e.g., per container engine or tenant), so the key of a
+// container whose state root isn't the default one includes the root (as a
+// hash): "<id>@<hash>". IDs can't contain '@', so keys don't collide either
+// with those of containers in the default root (which are their IDs).
+//
+// The root must be given as an absolute path with symlinks resolved, so that
+// the key doesn't depend on the path the root was given by; an empty root
+// means the default one.
+func ContainerKey(id, root string) string {
+	if root == "" {
+		return id
+	}
+	sum := sha256.Sum256([]byte(root))
+	return fmt.Sprintf("%s@%x", id, sum[:6])
+}
+
+// SysContainer is a system container whose spec has been converted and that
+// has been registered with sysbox-mgr and pre-registered with sysbox-fs, but
+// for which no libcontainer container has been created yet.
+type SysContainer struct {
+	ID                string
+	Mgr               *sysbox.Mgr
+	Fs                *sysbox.Fs
+	UidShiftSupported bool
+	UidShiftRootfs    bool
+
+	opts        CreateOpts
+	rootfsClone string
+	seedDir     string
+	seed        *snapshot.Manifest
+	template    bool
+	poststop    []specs.Hook
+	coreDump    *configs.CoreDump
+	kernelMods  *configs.KernelModules
+	hostConfigs *configs.HostConfigs
+	sshKeys     *configs.SSHKeys
+	networks    []*configs.Network
+	fsSysctls   map[string]string
+	fsNotify    *configs.FsNotifyQuota
+
+	uidShiftRootfsChown bool
+	uidShiftNoIdmap     bool
+}
+
+// PrepareSpec checks the host, registers the container with sysbox-mgr and
+// sysbox-fs, and converts the given spec (in place) to a system container
+// spec. On failure, all registrations are undone. On success, the caller must
+// either create the container via NewContainer() or call Cleanup().
+//
+// A panic (e.g., in the spec conversion) is returned as a sysbox.PanicError,
+// after undoing the registrations as on any other failure.
+func PrepareSpec(spec *specs.Spec, opts CreateOpts) (_ *SysContainer, retErr error) {
+	var err error
+
+	if opts.ID == "" {
+		return nil, fmt.Errorf("container id cannot be empty")
+	}
+
+	if err = sysbox.CheckHostConfig(spec, !opts.NoKernelCheck); err != nil {
+		return nil, err
+	}
+
+	if err = joinPeerNamespaces(spec, opts); err != nil {
+		return nil, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+
+	sc := newSysContainer(opts)
+
+	// these are set up by sysbox-mgr as the spec is converted
+	for _, a := range []string{syscont.VolumesAnnotation, syscont.EtcFilesCopyAnnotation, syscont.ContainerdSnapshottersAnnotation, syscont.SeedSnapshotAnnotation} {
+		if !sc.Mgr.Enabled() && spec.Annotations[a] != "" {
+			return nil, &sysbox.Error{
+				Code: sysbox.ErrInvalidSpec,
+				Err:  fmt.Errorf("annotation %s requires sysbox-mgr", a),
+			}
+		}
+	}
+
+	// kernel tracing passthrough is up to the host's admin; this isn't done as
+	// the spec is converted, as the conversion must not depend on the host
+	if err = sc.checkKernelTracing(spec); err != nil {
+		return nil, err
+	}
+	if err = sc.checkSharedMounts(spec); err != nil {
+		return nil, err
+	}
+	if err = sc.checkConfigfs(spec); err != nil {
+		return nil, err
+	}
+
+	// the deferred cleanups below only act on errors, so this one (which runs
+	// after them) must undo everything on a panic
+	defer func() {
+		if r := recover(); r != nil {
+			sc.Cleanup()
+			retErr = sysbox.NewPanicError("prepare", r)
+		}
+	}()
+
+	var origSpec *specs.Spec
+	if opts.Audit != nil {
+		if origSpec, err = audit.CopySpec(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = opts.Extensions.RunPreConvertSpec(opts.ID, spec); err != nil {
+		return nil, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+
+	if opts.UidShiftBackends != nil {
+		if err = syscont.ApplyUidShiftBackends(spec, opts.UidShiftBackends); err != nil {
+			return nil, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+		}
+	}
+
+	if err = sc.setupRootfsClone(spec); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			sc.teardownRootfsClone()
+		}
+	}()
+
+	// register with sysMgr
+	if sc.Mgr.Enabled() {
+		if err = sc.Mgr.Negotiate(); err != nil {
+			return nil, err
+		}
+		if err = sc.Mgr.Register(spec); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				sc.Mgr.Unregister()
+			}
+		}()
+	}
+
+	// Get sysbox-fs related configs
+	if sc.Fs.Enabled() {
+		if err = sc.Fs.Negotiate(); err != nil {
+			return nil, err
+		}
+		if err = sc.Fs.GetConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	sc.UidShiftSupported, sc.UidShiftRootfs, err = syscont.ConvertSpec(sc.Mgr, sc.Fs, spec)
+	if err != nil {
+		// errors that carry their own code (e.g., lack of disk space) aren't
+		// spec errors
+		if sysbox.GetErrorCode(err) == sysbox.ErrUnknown {
+			err = &sysbox.Error{
+				Code: sysbox.ErrInvalidSpec,
+				Err:  fmt.Errorf("error in the container spec: %w", err),
+			}
+		}
+		return nil, err
+	}
+
+	if err = sc.seedVolumes(spec); err != nil {
+		return nil, err
+	}
+
+	if err = sc.setupUidShiftBackends(spec); err != nil {
+		return nil, err
+	}
+
+	if err = sc.setupCoreDump(spec); err != nil {
+		return nil, err
+	}
+
+	if err = sc.setupKernelModules(spec); err != nil {
+		return nil, err
+	}
+
+	if err = sc.setupHostConfigs(spec); err != nil {
+		return nil, err
+	}
+
+	if err = sc.setupNetworks(spec); err != nil {
+		return nil, err
+	}
+
+	if err = sc.setupSSHKeys(spec); err != nil {
+		return nil, err
+	}
+
+	if err = sc.setupFsSysctls(spec); err != nil {
+		return nil, err
+	}
+
+	if err = sc.setupFsNotifyQuota(spec); err != nil {
+		return nil, err
+	}
+
+	if sc.template, err = syscont.GetTemplate(spec.Annotations); err != nil {
+		return nil, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+
+	hooksDir := opts.SysboxHooksDir
+	if hooksDir == "" {
+		hooksDir = DefaultSysboxHooksDir
+	}
+	if sc.poststop, err = loadPoststopHooks(hooksDir); err != nil {
+		return nil, &sysbox.Error{Code: sysbox.ErrInvalidConfig, Err: err}
+	}
+
+	if opts.Audit != nil {
+		if err = RecordSpecChanges(opts.Audit, opts.ID, origSpec, spec); err != nil {
+			return nil, err
+		}
+	}
+
+	// pre-register with sysFs
+	if sc.Fs.Enabled() {
+		if err = sc.Fs.PreRegister(spec.Linux.Namespaces); err != nil {
+			return nil, err
+		}
+	}
+
+	return sc, nil
+}
+
+// RecordSpecChanges writes the audit record of the changes made to the spec of
+// the container with the given ID (from origSpec to spec) to the given sink.
+func RecordSpecChanges(sink audit.Sink, id string, origSpec, spec *specs.Spec) error {
+	rec, err := audit.NewRecord(id, origSpec, spec)
+	if err == nil {
+		err = sink.Write(rec)
+	}
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrSystem, Err: fmt.Errorf("recording spec changes to the audit sink: %w", err)}
+	}
+	return nil
+}
+
+// NewSysContainer returns a SysContainer for the given options without doing
+// any host checks, registrations or spec conversions; it's meant for callers
+// that must perform these steps themselves (e.g., container restore).
+func NewSysContainer(opts CreateOpts, uidShiftSupported, uidShiftRootfs bool) *SysContainer {
+	sc := newSysContainer(opts)
+	sc.UidShiftSupported = uidShiftSupported
+	sc.UidShiftRootfs = uidShiftRootfs
+	return sc
+}
+
+func newSysContainer(opts CreateOpts) *SysContainer {
+	sysMgr := opts.Mgr
+	if sysMgr == nil {
+		sysMgr = sysbox.NewMgr(opts.ID, false)
+	}
+	if sysMgr.DataDir == "" {
+		sysMgr.DataDir = opts.MgrDataDir
+		if sysMgr.DataDir == "" {
+			sysMgr.DataDir = DefaultMgrDataDir
+		}
+	}
+	sysMgr.DiskCheck = sysMgr.DiskCheck || opts.DiskCheck
+	sysFs := opts.Fs
+	if sysFs == nil {
+		sysFs = sysbox.NewFs(opts.ID, false)
+	}
+	return &SysContainer{
+		ID:   opts.ID,
+		Mgr:  sysMgr,
+		Fs:   sysFs,
+		opts: opts,
+	}
+}
+
+// key returns the container's key (see ContainerKey()), i.e., the ID it's
+// registered with sysbox-mgr under.
+func (sc *SysContainer) key() string {
+	if sc.Mgr.Id != "" {
+		return sc.Mgr.Id
+	}
+	return sc.ID
+}
+
+// Cleanup unregisters the container from sysbox-mgr and sysbox-fs. It must be
+// called if the container creation fails after PrepareSpec() succeeded.
+func (sc *SysContainer) Cleanup() {
+	sc.Fs.Unregister()
+	if sc.Mgr.Enabled() {
+		sc.Mgr.Unregister()
+	}
+	sc.teardownRootfsClone()
+}
+
+// setupRootfsClone replaces the spec's rootfs with a clone of it, if the spec
+// requests so (explicitly, by relocating the rootfs' writable layer, or by
+// seeding it from a snapshot).
+func (sc *SysContainer) setupRootfsClone(spec *specs.Spec) error {
+	clone, err := syscont.GetRootfsClone(spec.Annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	upperDir, err := syscont.GetUpperDir(spec.Annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	seedDir, err := syscont.GetSeedSnapshot(spec.Annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if !clone && upperDir == "" && seedDir == "" {
+		return nil
+	}
+
+	// the pre-mounted rootfs is ID-mapped by the snapshotter; a clone of it
+	// would not be
+	premounted, err := syscont.GetRootfsPremounted(spec.Annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if premounted {
+		return &sysbox.Error{
+			Code: sysbox.ErrInvalidSpec,
+			Err: fmt.Errorf("a rootfs clone (annotations %s, %s, %s) is not supported with a pre-mounted rootfs (annotation %s)",
+				syscont.RootfsCloneAnnotation, syscont.UpperDirAnnotation, syscont.SeedSnapshotAnnotation, syscont.RootfsPremountedAnnotation),
+		}
+	}
+
+	baseDir := sc.opts.RootfsCloneDir
+	if baseDir == "" {
+		baseDir = DefaultRootfsCloneDir
+	}
+	if upperDir != "" {
+		if baseDir, err = checkUpperDir(upperDir, sc.opts.UpperDirs); err != nil {
+			return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+		}
+	}
+	baseDir, err = filepath.Abs(baseDir)
+	if err != nil {
+		return err
+	}
+
+	if spec.Root == nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: fmt.Errorf("spec has no rootfs to clone")}
+	}
+	rootfs, err := filepath.Abs(spec.Root.Path)
+	if err != nil {
+		return err
+	}
+
+	// Clones are keyed like the container in sysbox-mgr, so that containers
+	// with the same id in different state roots don't share one. A clone
+	// left over by a container that wasn't properly destroyed is removed; one
+	// of an existing container is not, and neither is a dir that isn't a
+	// clone (see rootfsclone.Teardown()). The owner recorded in the clone is
+	// checked too, as the default root (whose containers are keyed by id
+	// alone) may have changed since the clone was set up.
+	owner, err := filepath.Abs(filepath.Join(sc.opts.Root, sc.ID))
+	if err != nil {
+		return err
+	}
+	cloneDir := filepath.Join(baseDir, sc.key())
+	if _, err := os.Lstat(cloneDir); err == nil {
+		cloneOwner, err := rootfsclone.Owner(cloneDir)
+		if err != nil {
+			return err
+		}
+		for _, stateDir := range []string{owner, cloneOwner} {
+			if stateDir == "" {
+				continue
+			}
+			if _, err := os.Stat(stateDir); err == nil {
+				return &sysbox.Error{
+					Code: sysbox.ErrContainerIdInUse,
+					Err:  fmt.Errorf("rootfs clone %s is in use by container %s", cloneDir, stateDir),
+				}
+			}
+		}
+		logrus.Warnf("removing stale rootfs clone %s", cloneDir)
+		if err := rootfsclone.Teardown(cloneDir); err != nil {
+			return err
+		}
+	}
+
+	var seed func(upper string) error
+	if seedDir != "" {
+		if seedDir, err = checkSnapshotDir(seedDir, sc.opts.SnapshotDirs); err != nil {
+			return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+		}
+		if sc.seed, err = snapshot.Load(seedDir); err != nil {
+			return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: fmt.Errorf("annotation %s: %v", syscont.SeedSnapshotAnnotation, err)}
+		}
+		sc.seedDir = seedDir
+		seed = func(upper string) error {
+			return snapshot.SeedRootfs(seedDir, upper)
+		}
+	}
+
+	merged, err := rootfsclone.SetupSeeded(rootfs, cloneDir, owner, seed)
+	if err != nil {
+		return err
+	}
+
+	logrus.Debugf("using clone %s of rootfs %s", merged, rootfs)
+	if seedDir != "" {
+		logrus.Debugf("seeded rootfs clone %s from snapshot %s", merged, seedDir)
+	}
+
+	sc.rootfsClone = cloneDir
+	spec.Root.Path = merged
+	return nil
+}
+
+// seedVolumes seeds the host dirs that sysbox-mgr backs the container's
+// special dirs and volumes with from the snapshot the container is seeded
+// from, if any (see syscont.SeedSnapshotAnnotation). Snapshot dirs mounted
+// otherwise by the spec are skipped.
+func (sc *SysContainer) seedVolumes(spec *specs.Spec) error {
+	if sc.seed == nil || len(sc.seed.Volumes) == 0 {
+		return nil
+	}
+
+	ids := snapshot.IDMap{
+		UidHost: spec.Linux.UIDMappings[0].HostID,
+		UidSize: spec.Linux.UIDMappings[0].Size,
+		GidHost: spec.Linux.GIDMappings[0].HostID,
+		GidSize: spec.Linux.GIDMappings[0].Size,
+	}
+
+	for _, vol := range sc.seed.Volumes {
+		src := ""
+		for _, m := range spec.Mounts {
+			if filepath.Clean(m.Destination) == vol.Dest {
+				src = m.Source
+			}
+		}
+		if src == "" || sc.Mgr.DataDir == "" || !strings.HasPrefix(src, sc.Mgr.DataDir+"/") {
+			logrus.Warnf("not seeding %s from snapshot %s: not backed by sysbox-mgr", vol.Dest, sc.seedDir)
+			continue
+		}
+		if err := snapshot.SeedVolume(sc.seedDir, vol, src, ids); err != nil {
+			return fmt.Errorf("failed to seed %s from snapshot %s: %v", vol.Dest, sc.seedDir, err)
+		}
+		logrus.Debugf("seeded %s (%s) from snapshot %s", vol.Dest, src, sc.seedDir)
+	}
+
+	return nil
+}
+
+// checkUpperDir checks that the given dir can hold the writable layer of a
+// container's rootfs (see syscont.UpperDirAnnotation), and is allowed by the
+// host, and returns its path with symlinks resolved.
+func checkUpperDir(dir string, allowed []string) (string, error) {
+	// the container's rootfs clone is created (and torn down) in the dir, so
+	// it must be one the host allows
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("annotation %s: %v", syscont.UpperDirAnnotation, err)
+	}
+	if !underDirs(resolved, allowed) {
+		return "", fmt.Errorf("annotation %s: %s is not under the host's upper dirs (see --upper-dirs)",
+			syscont.UpperDirAnnotation, dir)
+	}
+
+	fi, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("annotation %s: %v", syscont.UpperDirAnnotation, err)
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("annotation %s: %s is not a directory", syscont.UpperDirAnnotation, dir)
+	}
+
+	// overlayfs does not support upper layers on network filesystems
+	fsType, err := sysbox.NetworkFs(resolved)
+	if err != nil {
+		return "", fmt.Errorf("annotation %s: %v", syscont.UpperDirAnnotation, err)
+	}
+	if fsType != "" {
+		return "", fmt.Errorf("annotation %s: %s is on a network filesystem (%s); it must be on local storage",
+			syscont.UpperDirAnnotation, dir, fsType)
+	}
+
+	return resolved, nil
+}
+
+// checkSnapshotDir checks that the given snapshot dir (see
+// syscont.SeedSnapshotAnnotation) is allowed by the host, and returns its path
+// with symlinks resolved.
+func checkSnapshotDir(dir string, allowed []string) (string, error) {
+	// the snapshot's files are copied into the container's host dirs, so it
+	// must be one the host allows
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("annotation %s: %v", syscont.SeedSnapshotAnnotation, err)
+	}
+	if !underDirs(resolved, allowed) {
+		return "", fmt.Errorf("annotation %s: %s is not under the host's snapshot dirs (see --snapshot-dirs)",
+			syscont.SeedSnapshotAnnotation, dir)
+	}
+	return resolved, nil
+}
+
+// underDirs returns true if the given path (absolute, with symlinks resolved)
+// is one of the given dirs, or under one of them.
+func underDirs(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		dir = filepath.Clean(dir)
+		if path == dir || strings.HasPrefix(path, dir+"/") || dir == "/" {
+			return true
+		}
+	}
+	return false
+}
+
+func (sc *SysContainer) teardownRootfsClone() {
+	if sc.rootfsClone == "" {
+		return
+	}
+	if err := rootfsclone.Teardown(sc.rootfsClone); err != nil {
+		logrus.Warnf("failed to remove rootfs clone %s: %v", sc.rootfsClone, err)
+	}
+	sc.rootfsClone = ""
+}
+
+// checkKernelTracing checks that the host's kernel tracing allowlist allows
+// the container the kernel tracing passthrough it requests, if any (see
+// syscont.KernelTracingAnnotation).
+func (sc *SysContainer) checkKernelTracing(spec *specs.Spec) error {
+	mode, err := syscont.GetKernelTracing(spec.Annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+
+	allowlist := sc.opts.KernelTracingAllowlist
+	if allowlist == "" {
+		allowlist = DefaultKernelTracingAllowlist
+	}
+	return syscont.KernelTracingAllowed(allowlist, sc.ID, mode)
+}
+
+// checkSharedMounts checks that the host's shared mounts allowlist allows the
+// container the shared mounts it requests, if any (see
+// syscont.SharedMountsAnnotation).
+func (sc *SysContainer) checkSharedMounts(spec *specs.Spec) error {
+	allowlist := sc.opts.SharedMountsAllowlist
+	if allowlist == "" {
+		allowlist = DefaultSharedMountsAllowlist
+	}
+	return syscont.SharedMountsAllowed(allowlist, sc.ID, spec.Annotations)
+}
+
+// checkConfigfs checks that the host's configfs passthrough allowlist allows
+// the container the configfs subtrees it requests, if any (see
+// syscont.ConfigfsAnnotation).
+func (sc *SysContainer) checkConfigfs(spec *specs.Spec) error {
+	allowlist := sc.opts.ConfigfsAllowlist
+	if allowlist == "" {
+		allowlist = DefaultConfigfsAllowlist
+	}
+	return syscont.ConfigfsPassthroughAllowed(allowlist, sc.ID, spec.Annotations)
+}
+
+// setupUidShiftBackends sets up the uid shifting backends the container uses,
+// as selected by the spec (see syscont.UidShiftBackendsAnnotation) among those
+// available: whether its rootfs is chown'ed even though shiftfs is available, and
+// whether ID-mapped mounts are disabled. Must be called after the spec is
+// converted, which checks the backends against the host.
+func (sc *SysContainer) setupUidShiftBackends(spec *specs.Spec) error {
+	backends, err := syscont.GetUidShiftBackends(spec.Annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+
+	if sc.UidShiftRootfs && sc.UidShiftSupported {
+		b, err := syscont.RootfsUidShiftBackend(backends, true)
+		if err != nil {
+			return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+		}
+		sc.uidShiftRootfsChown = b == syscont.UidShiftChown
+	}
+	sc.uidShiftNoIdmap = !syscont.HasUidShiftBackend(backends, syscont.UidShiftIdmap)
+
+	return nil
+}
+
+// setupCoreDump sets up the capture of the container's core dumps, if the spec
+// requests so (see syscont.CoreDumpAnnotation): they are stored in a dir of
+// the container (by key) under the core dump base dir, which is created if
+// needed, owned by the container's root user, and mounted in the container.
+// Must be called after the spec is converted, as it needs the container's
+// user-ns ID mappings.
+func (sc *SysContainer) setupCoreDump(spec *specs.Spec) error {
+	cd, err := syscont.GetCoreDump(spec.Annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if cd == nil {
+		return nil
+	}
+
+	for _, m := range spec.Mounts {
+		if filepath.Clean(m.Destination) == syscont.CoreDumpMountpoint {
+			return &sysbox.Error{
+				Code: sysbox.ErrInvalidSpec,
+				Err: fmt.Errorf("annotation %s: the spec has a mount at %s, where core dumps are stored",
+					syscont.CoreDumpAnnotation, syscont.CoreDumpMountpoint),
+			}
+		}
+	}
+
+	uid, gid := -1, -1
+	for _, m := range spec.Linux.UIDMappings {
+		if m.ContainerID == 0 {
+			uid = int(m.HostID)
+		}
+	}
+	for _, m := range spec.Linux.GIDMappings {
+		if m.ContainerID == 0 {
+			gid = int(m.HostID)
+		}
+	}
+
+	baseDir := sc.opts.CoreDumpDir
+	if baseDir == "" {
+		baseDir = DefaultCoreDumpDir
+	}
+	if cd.Dir, err = createCoreDumpDir(baseDir, sc.key(), uid, gid); err != nil {
+		return fmt.Errorf("failed to create core dump dir: %v", err)
+	}
+
+	spec.Mounts = append(spec.Mounts, specs.Mount{
+		Source:      cd.Dir,
+		Destination: syscont.CoreDumpMountpoint,
+		Type:        "bind",
+		Options:     []string{"rbind", "rprivate"},
+	})
+
+	logrus.Debugf("capturing core dumps in %s (max size %d bytes, max total %d bytes)", cd.Dir, cd.MaxSize, cd.MaxTotal)
+
+	sc.coreDump = cd
+	return nil
+}
+
+// createCoreDumpDir creates the core dump dir of the container with the given
+// key under the given base dir (itself created if needed), owned by the given
+// uid and gid, and returns its path. The dir of a previous container with the
+// same key is reused; as the container may have written to it, it's only
+// chown'ed if it's a dir (not a symlink to one).
+func createCoreDumpDir(baseDir, key string, uid, gid int) (string, error) {
+	baseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(baseDir, 0711); err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(baseDir, key)
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return "", fmt.Errorf("open %s: %v", dir, err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Fchown(fd, uid, gid); err != nil {
+		return "", fmt.Errorf("chown %s: %v", dir, err)
+	}
+	return dir, nil
+}
+
+// setupKernelModules sets up the container's restricted view of the host's
+// kernel modules, if the spec requests so (see
+// syscont.KernelModulesAnnotation). The view itself is set up by libcontainer
+// when the container starts; here the spec's mounts on hidden modules are
+// removed.
+func (sc *SysContainer) setupKernelModules(spec *specs.Spec) error {
+	km, err := syscont.GetKernelModules(spec.Annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if km == nil {
+		return nil
+	}
+
+	syscont.CfgKernelModuleMounts(spec, km)
+
+	logrus.Debugf("restricting kernel modules view to %v", km.Allowed)
+
+	sc.kernelMods = km
+	return nil
+}
+
+// setupHostConfigs sets up the mounts of host configs in the container, if
+// the spec requests so (see syscont.HostConfigsAnnotation). The mounts are set
+// up by libcontainer when the container starts.
+func (sc *SysContainer) setupHostConfigs(spec *specs.Spec) error {
+	hc, err := syscont.GetHostConfigs(spec)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if hc == nil {
+		return nil
+	}
+
+	logrus.Debugf("mounting host configs %v", hc.Paths)
+
+	sc.hostConfigs = hc
+	return nil
+}
+
+// setupSSHKeys sets up the copy of SSH keys from the host's SSH key store into
+// the container, if the spec requests so (see syscont.SSHHostKeysAnnotation
+// and syscont.SSHAuthorizedKeysAnnotation). The keys are copied by
+// libcontainer when the container starts.
+func (sc *SysContainer) setupSSHKeys(spec *specs.Spec) error {
+	keys, err := syscont.GetSSHKeys(spec)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if keys == nil {
+		return nil
+	}
+
+	keys.StoreDir = sc.opts.SSHKeysDir
+	if keys.StoreDir == "" {
+		keys.StoreDir = DefaultSSHKeysDir
+	}
+	if keys.StoreDir, err = filepath.Abs(keys.StoreDir); err != nil {
+		return err
+	}
+
+	logrus.Debugf("copying SSH keys from %s into the container (host keys dir %q, authorized keys %+v)",
+		keys.StoreDir, keys.HostKeysDir, keys.AuthorizedKeys)
+
+	sc.sshKeys = keys
+	return nil
+}
+
+// setupNetworks sets up the container's macvlan and ipvlan interfaces, if the
+// spec requests so (see syscont.NetworksAnnotation). The interfaces are
+// created by libcontainer when the container starts.
+func (sc *SysContainer) setupNetworks(spec *specs.Spec) error {
+	networks, err := syscont.GetNetworks(spec)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	for _, n := range networks {
+		logrus.Debugf("attaching %s interface %s to host interface %s", n.Type, n.Name, n.Parent)
+	}
+
+	sc.networks = networks
+	return nil
+}
+
+// setupFsSysctls sets up the non-namespaced sysctls of the container's presets
+// (see syscont.PresetAnnotation), whose values sysbox-fs sets once the
+// container registers with it.
+func (sc *SysContainer) setupFsSysctls(spec *specs.Spec) error {
+	sysctls, err := syscont.GetFsSysctls(spec)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if len(sysctls) == 0 {
+		return nil
+	}
+	if !sc.Fs.Enabled() {
+		return &sysbox.Error{
+			Code: sysbox.ErrInvalidSpec,
+			Err:  fmt.Errorf("annotation %s: the non-namespaced sysctls of the presets require sysbox-fs", syscont.PresetAnnotation),
+		}
+	}
+	if err := sc.Fs.CheckFeature(sysbox.FeatFsSysctls); err != nil {
+		return err
+	}
+
+	sc.fsSysctls = sysctls
+	return nil
+}
+
+// setupFsNotifyQuota sets up the container's inotify and fanotify quota, if the
+// spec requests so (see syscont.FsNotifyQuotaAnnotation); it's sent to
+// sysbox-fs when the container registers with it.
+func (sc *SysContainer) setupFsNotifyQuota(spec *specs.Spec) error {
+	q, err := syscont.GetFsNotifyQuota(spec.Annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if q != nil && !sc.Fs.Enabled() {
+		return &sysbox.Error{
+			Code: sysbox.ErrInvalidSpec,
+			Err:  fmt.Errorf("annotation %s requires sysbox-fs", syscont.FsNotifyQuotaAnnotation),
+		}
+	}
+
+	sc.fsNotify = q
+	return nil
+}
+
+// NewContainer creates the libcontainer container for the given (already
+// converted) system container spec. The container's init process is not
+// started; use the returned container's Run() or Start() methods for that.
+func (sc *SysContainer) NewContainer(spec *specs.Spec) (libcontainer.Container, error) {
+
+	switchDockerDns := false
+	if sc.Mgr.Enabled() && sc.Mgr.Config.AliasDns {
+		var err error
+		switchDockerDns, err = dockerUtils.ContainerIsDocker(sc.ID, spec.Root.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config, err := specconv.CreateLibcontainerConfig(&specconv.CreateOpts{
+		CgroupName:        sc.ID,
+		UseSystemdCgroup:  sc.opts.UseSystemdCgroup,
+		NoPivotRoot:       sc.opts.NoPivotRoot,
+		NoNewKeyring:      sc.opts.NoNewKeyring,
+		Spec:              spec,
+		RootlessEUID:      os.Geteuid() != 0,
+		RootlessCgroups:   sc.opts.RootlessCgroups,
+		UidShiftSupported: sc.UidShiftSupported,
+		UidShiftRootfs:    sc.UidShiftRootfs,
+		SwitchDockerDns:   switchDockerDns,
+	})
+	if err != nil {
+		return nil, &sysbox.Error{Code: sysbox.ErrInvalidConfig, Err: err}
+	}
+
+	// For container's proper operation, collect from sysbox-mgr fsState to be
+	// added to container's rootfs.
+	if sc.Mgr.Enabled() {
+		state, err := sc.Mgr.ReqFsState(config.Rootfs)
+		if err != nil {
+			return nil, err
+		}
+		config.FsState = state
+	}
+
+	config.RootfsClone = sc.rootfsClone
+	config.Template = sc.template
+	if len(sc.poststop) > 0 {
+		config.Hooks[configs.SysboxPoststop] = specconv.CreateHookList(sc.poststop)
+	}
+	config.CoreDump = sc.coreDump
+	config.KernelModules = sc.kernelMods
+	config.HostConfigs = sc.hostConfigs
+	config.SSHKeys = sc.sshKeys
+	config.Networks = append(config.Networks, sc.networks...)
+	config.SysboxFsSysctls = sc.fsSysctls
+	config.FsNotifyQuota = sc.fsNotify
+	config.UidShiftRootfsChown = sc.uidShiftRootfsChown
+	config.UidShiftNoIdmap = sc.uidShiftNoIdmap
+	config.UidShiftFuseHelper = sc.opts.IdmapFuseHelper
+	config.SeccompKeeper = sc.opts.SeccompKeeper
+	config.Scheduler = sc.opts.Scheduler
+	config.IOPriority = sc.opts.IOPriority
+
+	// setup sys container syscall trapping
+	if sc.Fs.Enabled() {
+		clockPolicy, err := syscont.GetClockSettimePolicy(spec.Annotations)
+		if err != nil {
+			return nil, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+		}
+		mountFastPaths, err := syscont.GetMountFastPaths(spec.Annotations)
+		if err != nil {
+			return nil, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+		}
+		if err := syscont.AddSyscallTraps(config, clockPolicy, mountFastPaths); err != nil {
+			return nil, err
+		}
+	}
+
+	factoryOpts := make([]func(*libcontainer.LinuxFactory) error, 0, len(sc.opts.FactoryOpts)+3)
+	factoryOpts = append(factoryOpts, sc.opts.FactoryOpts...)
+	factoryOpts = append(factoryOpts, libcontainer.SysFs(sc.Fs), libcontainer.SysMgr(sc.Mgr),
+		libcontainer.Extensions(sc.opts.Extensions))
+
+	factory, err := libcontainer.New(sc.opts.Root, factoryOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return factory.Create(sc.ID, config)
+}
+
+// CreateSysContainer converts the given spec to a system container spec and
+// creates the corresponding libcontainer container (see PrepareSpec() and
+// NewContainer()).
+func CreateSysContainer(spec *specs.Spec, opts CreateOpts) (libcontainer.Container, error) {
+	sc, err := PrepareSpec(spec, opts)
+	if err != nil {
+		return nil, err
+	}
+	container, err := sc.NewContainer(spec)
+	if err != nil {
+		sc.Cleanup()
+		return nil, err
+	}
+	return container, nil
+}
@@ -0,0 +1,410 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+// Package mountapi translates mount options to the new mount API (fsopen(2),
+// fsconfig(2), fsmount(2), move_mount(2) and mount_setattr(2)), for the
+// options that the legacy mount(2) path can't apply (e.g., recursive mount
+// attributes), and detects the kernel's support for each of them.
+package mountapi
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Syscalls of the new mount API; not all of them are in our version of
+// x/sys/unix. The syscall numbers are the same on all archs.
+const (
+	sysOpenTree     = 428
+	sysMoveMount    = 429
+	sysFsopen       = 430
+	sysFsconfig     = 431
+	sysFsmount      = 432
+	sysMountSetattr = 442
+
+	openTreeClone       = 0x1
+	moveMountFEmptyPath = 0x4
+	fsopenCloexec       = 0x1
+	fsmountCloexec      = 0x1
+	atRecursive         = 0x8000
+
+	fsconfigSetFlag   = 0
+	fsconfigSetString = 1
+	fsconfigCmdCreate = 6
+)
+
+// MsNoSymFollow is the mount(2) flag for the "nosymfollow" option (Linux
+// 5.10+); older kernels silently ignore it. StNoSymFollow is its statfs(2)
+// flag.
+const (
+	MsNoSymFollow = 0x100
+	StNoSymFollow = 0x2000
+)
+
+// Mount attributes (the MOUNT_ATTR_* flags of fsmount(2) and
+// mount_setattr(2)).
+const (
+	AttrRdonly      = 0x1
+	AttrNosuid      = 0x2
+	AttrNodev       = 0x4
+	AttrNoexec      = 0x8
+	AttrAtime       = 0x70 // mask of the access time modes below
+	AttrRelatime    = 0x0
+	AttrNoatime     = 0x10
+	AttrStrictatime = 0x20
+	AttrNodiratime  = 0x80
+	AttrNosymfollow = 0x200000 // Linux 5.10+
+)
+
+// attrFlags are the mount(2) flags that translate to mount attributes.
+var attrFlags = []struct {
+	flag int
+	attr uint64
+}{
+	{unix.MS_RDONLY, AttrRdonly},
+	{unix.MS_NOSUID, AttrNosuid},
+	{unix.MS_NODEV, AttrNodev},
+	{unix.MS_NOEXEC, AttrNoexec},
+	{unix.MS_NODIRATIME, AttrNodiratime},
+	{MsNoSymFollow, AttrNosymfollow},
+}
+
+// sbFlags are the mount(2) flags that translate to superblock flags of the
+// new mount API, set via fsconfig(2).
+var sbFlags = []struct {
+	flag int
+	name string
+}{
+	{unix.MS_SYNCHRONOUS, "sync"},
+	{unix.MS_DIRSYNC, "dirsync"},
+	{unix.MS_LAZYTIME, "lazytime"},
+	{unix.MS_MANDLOCK, "mand"},
+	{unix.MS_POSIXACL, "posixacl"},
+}
+
+// Attrs returns the mount attributes given by the mount(2) flags, updated
+// with the given ones to set and clear (e.g., of recursive mount options). It
+// fails for flags that have no equivalent in the new mount API.
+func Attrs(flags int, set, clr uint64) (uint64, error) {
+	var attrs uint64
+	rest := flags
+
+	for _, f := range attrFlags {
+		if flags&f.flag != 0 {
+			attrs |= f.attr
+		}
+		rest &^= f.flag
+	}
+	for _, f := range sbFlags {
+		rest &^= f.flag
+	}
+
+	// the kernel picks noatime over strictatime over relatime
+	switch {
+	case flags&unix.MS_NOATIME != 0:
+		attrs |= AttrNoatime
+	case flags&unix.MS_STRICTATIME != 0:
+		attrs |= AttrStrictatime
+	}
+	rest &^= unix.MS_NOATIME | unix.MS_STRICTATIME | unix.MS_RELATIME
+
+	if rest != 0 {
+		return 0, fmt.Errorf("mount flags %#x are not supported by the new mount API", rest)
+	}
+
+	// an access time mode replaces the current one
+	if set&AttrAtime != 0 || clr&AttrAtime != 0 {
+		attrs &^= AttrAtime
+	}
+	attrs &^= clr
+	attrs |= set
+
+	return attrs, nil
+}
+
+var (
+	fsopenOnce      sync.Once
+	fsopenSupported bool
+
+	attrMu        sync.Mutex
+	attrSupported = map[uint64]bool{}
+
+	noSymFollowOnce      sync.Once
+	noSymFollowSupported bool
+)
+
+// FsopenSupported returns true if the kernel supports fsopen(2) and friends
+// (Linux 5.2+).
+func FsopenSupported() bool {
+	fsopenOnce.Do(func() {
+		fd, err := fsopen("tmpfs")
+		if err == nil {
+			unix.Close(fd)
+			fsopenSupported = true
+		}
+	})
+	return fsopenSupported
+}
+
+// AttrSupported returns true if the kernel supports mount_setattr(2) (Linux
+// 5.12+) and the given mount attributes. It's probed on a detached clone of
+// the root mount, so it needs CAP_SYS_ADMIN.
+func AttrSupported(attrs uint64) bool {
+	attrMu.Lock()
+	defer attrMu.Unlock()
+
+	if ok, found := attrSupported[attrs]; found {
+		return ok
+	}
+
+	ok := false
+	fd, err := openTree("/")
+	if err == nil {
+		ok = mountSetattr(fd, "", unix.AT_EMPTY_PATH, attrs, 0) == nil
+		unix.Close(fd)
+	}
+
+	attrSupported[attrs] = ok
+	return ok
+}
+
+// NoSymFollowSupported returns true if the kernel supports MsNoSymFollow in
+// mount(2) (Linux 5.10+).
+func NoSymFollowSupported() bool {
+	noSymFollowOnce.Do(func() {
+		var uts unix.Utsname
+		if err := unix.Uname(&uts); err != nil {
+			return
+		}
+		var major, minor int
+		if _, err := fmt.Sscanf(string(uts.Release[:]), "%d.%d", &major, &minor); err != nil {
+			return
+		}
+		noSymFollowSupported = major > 5 || (major == 5 && minor >= 10)
+	})
+	return noSymFollowSupported
+}
+
+// SetAttr sets and clears the given mount attributes on the mount at path
+// (and, if recursive, on the mounts under it).
+func SetAttr(path string, set, clr uint64, recursive bool) error {
+	flags := 0
+	if recursive {
+		flags = atRecursive
+	}
+	if err := mountSetattr(unix.AT_FDCWD, path, flags, set, clr); err != nil {
+		return fmt.Errorf("failed to set mount attributes %#x (clearing %#x) on %s: %w", set, clr, path, err)
+	}
+	return nil
+}
+
+// Mount mounts a filesystem of the given type and source on target via the
+// new mount API, with the given mount(2) flags and data (a comma separated
+// list of options), and the given mount attributes to set and clear on top of
+// the flags. Unlike mount(2), the attributes are applied atomically with the
+// mount, and unknown data options fail (with the filesystem's error message).
+func Mount(source, target, fstype string, flags int, data string, set, clr uint64) error {
+	attrs, err := Attrs(flags, set, clr)
+	if err != nil {
+		return err
+	}
+
+	fsfd, err := fsopen(fstype)
+	if err != nil {
+		return fmt.Errorf("fsopen %s: %w", fstype, err)
+	}
+	defer unix.Close(fsfd)
+
+	if source != "" {
+		if err := fsconfig(fsfd, fsconfigSetString, "source", source); err != nil {
+			return fsError(fsfd, "source="+source, err)
+		}
+	}
+
+	for _, f := range sbFlags {
+		if flags&f.flag != 0 {
+			if err := fsconfig(fsfd, fsconfigSetFlag, f.name, ""); err != nil {
+				return fsError(fsfd, f.name, err)
+			}
+		}
+	}
+
+	for _, opt := range splitData(data) {
+		key, val := opt, ""
+		cmd := fsconfigSetFlag
+		if i := strings.Index(opt, "="); i >= 0 {
+			key, val = opt[:i], strings.Trim(opt[i+1:], `"`)
+			cmd = fsconfigSetString
+		}
+		if err := fsconfig(fsfd, cmd, key, val); err != nil {
+			return fsError(fsfd, opt, err)
+		}
+	}
+
+	if err := fsconfig(fsfd, fsconfigCmdCreate, "", ""); err != nil {
+		return fsError(fsfd, "", err)
+	}
+
+	mfd, _, errno := unix.Syscall(sysFsmount, uintptr(fsfd), fsmountCloexec, uintptr(attrs))
+	if errno != 0 {
+		return fmt.Errorf("fsmount %s with attributes %#x: %w", fstype, attrs, errno)
+	}
+	defer unix.Close(int(mfd))
+
+	return moveMount(int(mfd), target)
+}
+
+// splitData splits the given mount data at its commas, except those within
+// double quotes (e.g., in SELinux contexts).
+func splitData(data string) []string {
+	var opts []string
+	quoted := false
+	start := 0
+
+	for i, c := range data {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				if i > start {
+					opts = append(opts, data[start:i])
+				}
+				start = i + 1
+			}
+		}
+	}
+	if start < len(data) {
+		opts = append(opts, data[start:])
+	}
+	return opts
+}
+
+// fsError returns the error for the given failed fsconfig(2) call on the
+// filesystem context fsfd, with the context's error messages (if any).
+func fsError(fsfd int, opt string, err error) error {
+	var msgs []string
+	buf := make([]byte, 4096)
+	for {
+		n, rerr := unix.Read(fsfd, buf)
+		if rerr != nil || n <= 0 {
+			break
+		}
+		msgs = append(msgs, strings.TrimSpace(string(buf[:n])))
+	}
+
+	what := "creating the filesystem"
+	if opt != "" {
+		what = fmt.Sprintf("mount option %q", opt)
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("%s: %w (%s)", what, err, strings.Join(msgs, "; "))
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
+
+func fsopen(fstype string) (int, error) {
+	p, err := unix.BytePtrFromString(fstype)
+	if err != nil {
+		return -1, err
+	}
+	fd, _, errno := unix.Syscall(sysFsopen, uintptr(unsafe.Pointer(p)), fsopenCloexec, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+func fsconfig(fsfd int, cmd int, key, val string) error {
+	var keyPtr, valPtr *byte
+	var err error
+
+	if key != "" {
+		if keyPtr, err = unix.BytePtrFromString(key); err != nil {
+			return err
+		}
+	}
+	if cmd == fsconfigSetString {
+		if valPtr, err = unix.BytePtrFromString(val); err != nil {
+			return err
+		}
+	}
+
+	_, _, errno := unix.Syscall6(sysFsconfig, uintptr(fsfd), uintptr(cmd),
+		uintptr(unsafe.Pointer(keyPtr)), uintptr(unsafe.Pointer(valPtr)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func openTree(path string) (int, error) {
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return -1, err
+	}
+	fd, _, errno := unix.Syscall(sysOpenTree, uintptr(atFdcwd), uintptr(unsafe.Pointer(p)),
+		uintptr(openTreeClone|unix.O_CLOEXEC))
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// atFdcwd is unix.AT_FDCWD as a variable, as the (negative) constant can't be
+// converted to uintptr.
+var atFdcwd = unix.AT_FDCWD
+
+type mountAttr struct {
+	attrSet     uint64
+	attrClr     uint64
+	propagation uint64
+	usernsFd    uint64
+}
+
+func mountSetattr(dirfd int, path string, flags int, set, clr uint64) error {
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attr := mountAttr{attrSet: set, attrClr: clr}
+	_, _, errno := unix.Syscall6(sysMountSetattr, uintptr(dirfd), uintptr(unsafe.Pointer(p)),
+		uintptr(flags), uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func moveMount(fd int, target string) error {
+	empty, _ := unix.BytePtrFromString("")
+	dst, err := unix.BytePtrFromString(target)
+	if err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall6(sysMoveMount, uintptr(fd), uintptr(unsafe.Pointer(empty)),
+		uintptr(atFdcwd), uintptr(unsafe.Pointer(dst)), moveMountFEmptyPath, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to move mount to %s: %w", target, errno)
+	}
+	return nil
+}
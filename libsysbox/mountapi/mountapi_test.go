@@ -0,0 +1,125 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package mountapi
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAttrs(t *testing.T) {
+	tests := []struct {
+		flags    int
+		set, clr uint64
+		want     uint64
+		wantErr  bool
+	}{
+		{0, 0, 0, AttrRelatime, false},
+		{unix.MS_RDONLY | unix.MS_NOSUID | unix.MS_NODEV | MsNoSymFollow, 0, 0,
+			AttrRdonly | AttrNosuid | AttrNodev | AttrNosymfollow, false},
+		{unix.MS_NOATIME | unix.MS_STRICTATIME, 0, 0, AttrNoatime, false},
+		{unix.MS_SYNCHRONOUS | unix.MS_LAZYTIME, 0, 0, 0, false},
+
+		// the recursive attributes override the flags
+		{unix.MS_RDONLY | unix.MS_NOEXEC, AttrNosuid, AttrRdonly, AttrNoexec | AttrNosuid, false},
+		{unix.MS_NOATIME, AttrStrictatime, AttrAtime, AttrStrictatime, false},
+		{unix.MS_NOATIME, 0, AttrAtime, AttrRelatime, false},
+
+		{unix.MS_REMOUNT, 0, 0, 0, true},
+		{unix.MS_I_VERSION, 0, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := Attrs(test.flags, test.set, test.clr)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Attrs(%#x, %#x, %#x): want err = %v, got %v", test.flags, test.set, test.clr, test.wantErr, err)
+			continue
+		}
+		if err == nil && got != test.want {
+			t.Errorf("Attrs(%#x, %#x, %#x): want %#x, got %#x", test.flags, test.set, test.clr, test.want, got)
+		}
+	}
+}
+
+func TestSplitData(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+	}{
+		{"", nil},
+		{"size=1m", []string{"size=1m"}},
+		{"size=1m,,nr_inodes=10,", []string{"size=1m", "nr_inodes=10"}},
+		{`mode=755,context="system_u:object_r:container_file_t:s0:c1,c2"`,
+			[]string{"mode=755", `context="system_u:object_r:container_file_t:s0:c1,c2"`}},
+	}
+
+	for _, test := range tests {
+		if got := splitData(test.data); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitData(%q): want %q, got %q", test.data, test.want, got)
+		}
+	}
+}
+
+func TestMount(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root")
+	}
+	if !FsopenSupported() {
+		t.Skip("the kernel doesn't support the new mount API")
+	}
+
+	dir, err := ioutil.TempDir("", "mountapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := Mount("tmpfs", dir, "tmpfs", unix.MS_NOEXEC, "size=1m,mode=700", AttrNosuid|AttrRdonly, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Unmount(dir, unix.MNT_DETACH)
+
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		t.Fatal(err)
+	}
+	want := int64(unix.ST_NOEXEC | unix.ST_NOSUID | unix.ST_RDONLY)
+	if int64(st.Flags)&want != want {
+		t.Errorf("want mount flags %#x, got %#x", want, st.Flags)
+	}
+
+	if err := SetAttr(dir, 0, AttrRdonly, true); err != nil {
+		if !AttrSupported(0) {
+			t.Skip("the kernel doesn't support mount_setattr")
+		}
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/f", nil, 0644); err != nil {
+		t.Errorf("mount still read-only: %v", err)
+	}
+
+	// unknown options fail, rather than being ignored
+	err = Mount("tmpfs", dir, "tmpfs", 0, "bogus=1", 0, 0)
+	if err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("expected error mounting with an unknown option, got %v", err)
+	}
+}
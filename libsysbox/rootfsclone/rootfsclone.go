@@ -0,0 +1,209 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package rootfsclone clones a container's rootfs via overlayfs: the rootfs is
+// the (shared, never written) lower layer, and each clone gets its own upper
+// layer. This way many sys containers can be launched from the same bundle
+// without preparing (e.g., uid-shifting) the bundle's rootfs for each of them.
+package rootfsclone
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runc/libcontainer/mount"
+	"golang.org/x/sys/unix"
+)
+
+// overlayfs magic number (see statfs(2))
+const overlayfsMagic = 0x794c7630
+
+// Clone dir layout
+const (
+	upperDir  = "upper"
+	workDir   = "work"
+	mergedDir = "merged"
+
+	// markerFile marks the dirs created by Setup(), so that Teardown() never
+	// removes a dir it didn't create (e.g., one that happens to be at the
+	// clone dir's path). It holds the clone's owner (see SetupSeeded()).
+	markerFile = ".sysbox-rootfs-clone"
+)
+
+// Merged returns the path of the cloned rootfs in the given clone dir.
+func Merged(cloneDir string) string {
+	return filepath.Join(cloneDir, mergedDir)
+}
+
+// Upper returns the path of the upper layer of the clone in the given clone
+// dir, which holds the clone's changes to the rootfs.
+func Upper(cloneDir string) string {
+	return filepath.Join(cloneDir, upperDir)
+}
+
+// Setup clones the given rootfs in cloneDir (which must not exist, nor be on
+// overlayfs) and returns the path of the cloned rootfs.
+func Setup(rootfs, cloneDir string) (string, error) {
+	return SetupSeeded(rootfs, cloneDir, "", nil)
+}
+
+// SetupSeeded is like Setup(), but records the given owner of the clone (see
+// Owner()), and the clone's upper layer is populated by the given func (if not
+// nil) before it's mounted (e.g., from a snapshot, see the snapshot package).
+func SetupSeeded(rootfs, cloneDir, owner string, seed func(upper string) error) (_ string, err error) {
+	var st unix.Stat_t
+
+	if _, err := os.Stat(cloneDir); err == nil {
+		return "", fmt.Errorf("rootfs clone dir %s already exists", cloneDir)
+	}
+
+	if err := unix.Stat(rootfs, &st); err != nil {
+		return "", fmt.Errorf("failed to stat rootfs %s: %v", rootfs, err)
+	}
+
+	if onOverlayfs(filepath.Dir(cloneDir)) {
+		return "", fmt.Errorf("rootfs clone dir %s can't be on overlayfs", cloneDir)
+	}
+
+	upper := Upper(cloneDir)
+	work := filepath.Join(cloneDir, workDir)
+	merged := Merged(cloneDir)
+
+	// the container's init process must be able to traverse the clone dir
+	// to reach the rootfs
+	if err := os.MkdirAll(filepath.Dir(cloneDir), 0711); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(cloneDir, 0711); err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(cloneDir)
+		}
+	}()
+
+	if err := ioutil.WriteFile(filepath.Join(cloneDir, markerFile), []byte(owner), 0600); err != nil {
+		return "", err
+	}
+
+	for _, dir := range []string{upper, work, merged} {
+		if err := os.Mkdir(dir, 0711); err != nil {
+			return "", err
+		}
+	}
+
+	// the attributes of the overlayfs root dir are those of the upper dir
+	if err := os.Chown(upper, int(st.Uid), int(st.Gid)); err != nil {
+		return "", err
+	}
+	if err := unix.Chmod(upper, st.Mode&07777); err != nil {
+		return "", fmt.Errorf("failed to chmod %s: %v", upper, err)
+	}
+
+	if seed != nil {
+		if err := seed(upper); err != nil {
+			return "", fmt.Errorf("failed to seed rootfs clone %s: %v", cloneDir, err)
+		}
+	}
+
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", rootfs, upper, work)
+
+	// With metacopy, changing a file's ownership (e.g., when shifting the
+	// rootfs uids) copies up only its metadata; fall back to a regular mount
+	// if the kernel does not support it.
+	err = unix.Mount("overlay", merged, "overlay", 0, opts+",metacopy=on")
+	if err == unix.EINVAL {
+		err = unix.Mount("overlay", merged, "overlay", 0, opts)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to mount overlayfs clone of rootfs %s at %s: %v", rootfs, merged, err)
+	}
+
+	return merged, nil
+}
+
+// Owner returns the owner recorded for the rootfs clone in cloneDir (empty if
+// none was). It fails if cloneDir isn't a rootfs clone dir.
+func Owner(cloneDir string) (string, error) {
+	marker := filepath.Join(cloneDir, markerFile)
+	if fi, err := os.Lstat(marker); err != nil || !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a rootfs clone dir", cloneDir)
+	}
+	owner, err := ioutil.ReadFile(marker)
+	if err != nil {
+		return "", err
+	}
+	return string(owner), nil
+}
+
+// onOverlayfs returns true if the given path, or its closest existing parent,
+// is on overlayfs.
+func onOverlayfs(path string) bool {
+	var st unix.Statfs_t
+
+	for {
+		err := unix.Statfs(path, &st)
+		if err == nil {
+			return st.Type == overlayfsMagic
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
+	}
+}
+
+// Teardown unmounts and removes the rootfs clone in cloneDir, if any. It fails
+// if cloneDir exists but wasn't created by Setup().
+func Teardown(cloneDir string) error {
+	merged := Merged(cloneDir)
+
+	if _, err := os.Lstat(cloneDir); os.IsNotExist(err) {
+		return nil
+	}
+	if fi, err := os.Lstat(filepath.Join(cloneDir, markerFile)); err != nil || !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a rootfs clone dir; not removing it", cloneDir)
+	}
+
+	mounted, err := mount.MountedWithFs(merged, "overlay")
+	if err != nil {
+		return err
+	}
+	if mounted {
+		if err := unix.Unmount(merged, unix.MNT_DETACH); err != nil {
+			return fmt.Errorf("failed to unmount rootfs clone at %s: %v", merged, err)
+		}
+	}
+
+	// merged is removed non-recursively, so that the rootfs (the overlayfs
+	// lower layer) can't be accidentally removed through it
+	for _, dir := range []string{upperDir, workDir} {
+		if err := os.RemoveAll(filepath.Join(cloneDir, dir)); err != nil {
+			return err
+		}
+	}
+	for _, path := range []string{merged, filepath.Join(cloneDir, markerFile), cloneDir} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package rootfsclone
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetupTeardown(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "rootfsclone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "etc/hostname"), []byte("lower"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var clones []string
+
+	for _, name := range []string{"clone1", "clone2"} {
+		cloneDir := filepath.Join(dir, name)
+
+		merged, err := Setup(rootfs, cloneDir)
+		if err != nil {
+			t.Skipf("Setup() failed (overlayfs not supported?): %v", err)
+		}
+		defer Teardown(cloneDir)
+
+		if err := ioutil.WriteFile(filepath.Join(merged, "etc/hostname"), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		clones = append(clones, cloneDir)
+	}
+
+	// each clone sees its own writes; the rootfs is untouched
+	for i, cloneDir := range clones {
+		data, err := ioutil.ReadFile(filepath.Join(Merged(cloneDir), "etc/hostname"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != filepath.Base(clones[i]) {
+			t.Errorf("clone %s: want %q, got %q", cloneDir, filepath.Base(clones[i]), data)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(rootfs, "etc/hostname"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "lower" {
+		t.Errorf("rootfs modified by its clones: got %q", data)
+	}
+
+	for _, cloneDir := range clones {
+		if err := Teardown(cloneDir); err != nil {
+			t.Fatalf("Teardown() failed: %v", err)
+		}
+		if _, err := os.Stat(cloneDir); !os.IsNotExist(err) {
+			t.Errorf("clone dir %s not removed", cloneDir)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(rootfs, "etc/hostname")); err != nil {
+		t.Errorf("rootfs removed by Teardown(): %v", err)
+	}
+}
+
+func TestSetupSeeded(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "rootfsclone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "etc/hostname"), []byte("lower"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cloneDir := filepath.Join(dir, "clone")
+	seed := func(upper string) error {
+		if err := os.Mkdir(filepath.Join(upper, "etc"), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(upper, "etc/hostname"), []byte("seeded"), 0644)
+	}
+
+	merged, err := SetupSeeded(rootfs, cloneDir, "/run/sysbox-runc/ctr", seed)
+	if err != nil {
+		t.Skipf("SetupSeeded() failed (overlayfs not supported?): %v", err)
+	}
+	defer Teardown(cloneDir)
+
+	owner, err := Owner(cloneDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != "/run/sysbox-runc/ctr" {
+		t.Errorf("Owner(): want %q, got %q", "/run/sysbox-runc/ctr", owner)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(merged, "etc/hostname"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "seeded" {
+		t.Errorf("want %q, got %q", "seeded", data)
+	}
+}
+
+func TestTeardownNotClone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rootfsclone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a dir at a clone dir's path that wasn't created by Setup() (e.g., host
+	// data) is left alone
+	data := filepath.Join(dir, "data")
+	if err := os.MkdirAll(filepath.Join(data, upperDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(data, upperDir, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Owner(data); err == nil {
+		t.Error("expected error getting the owner of a dir that is not a rootfs clone")
+	}
+	if err := Teardown(data); err == nil {
+		t.Error("expected error tearing down a dir that is not a rootfs clone")
+	}
+	if _, err := os.Stat(filepath.Join(data, upperDir, "file")); err != nil {
+		t.Errorf("Teardown() removed a dir that is not a rootfs clone: %v", err)
+	}
+
+	if err := Teardown(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("Teardown() of a missing clone dir failed: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package libsysbox
+
+import (
+	"fmt"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// namespaces that can be shared with a peer container
+var sharedNsTypes = map[specs.LinuxNamespaceType]configs.NamespaceType{
+	specs.IPCNamespace:    configs.NEWIPC,
+	specs.UTSNamespace:    configs.NEWUTS,
+	specs.CgroupNamespace: configs.NEWCGROUP,
+}
+
+// joinPeerNamespaces sets up the spec so that the container joins the ipc, uts
+// and cgroup namespaces of the peer containers given by the spec's share-ns
+// annotation (see syscont.ShareNsAnnotation).
+//
+// A namespace is owned by the user-ns in which it was created, and only
+// processes with capabilities in that user-ns can manage it (e.g., set the
+// hostname). Thus the container must also join the peer's user-ns: if the spec
+// doesn't set a user-ns path, the peer's user-ns and ID mappings are used;
+// otherwise the spec's user-ns must be the peer's. When joining the peer's uts
+// namespace, the spec's hostname is ignored. When joining the peer's cgroup
+// namespace, the container's cgroup must be within the peer's (e.g., via the
+// spec's cgroups path), as the peer's cgroup namespace is rooted at its cgroup.
+func joinPeerNamespaces(spec *specs.Spec, opts CreateOpts) error {
+	shared, err := syscont.GetSharedNamespaces(spec.Annotations)
+	if err != nil || len(shared) == 0 {
+		return err
+	}
+
+	factory, err := libcontainer.New(opts.Root)
+	if err != nil {
+		return err
+	}
+
+	for nsType, peerId := range shared {
+		if peerId == opts.ID {
+			return fmt.Errorf("container can't share its %s namespace with itself", nsType)
+		}
+
+		peer, err := factory.Load(peerId)
+		if err != nil {
+			return fmt.Errorf("failed to load peer container %s: %w", peerId, err)
+		}
+
+		status, err := peer.Status()
+		if err != nil {
+			return fmt.Errorf("failed to get the status of peer container %s: %w", peerId, err)
+		}
+		if status == libcontainer.Stopped {
+			return fmt.Errorf("can't join the %s namespace of peer container %s: container is stopped", nsType, peerId)
+		}
+
+		state, err := peer.State()
+		if err != nil {
+			return fmt.Errorf("failed to get the state of peer container %s: %w", peerId, err)
+		}
+
+		if err := joinPeerUserns(spec, peerId, state); err != nil {
+			return fmt.Errorf("can't join the %s namespace of peer container %s: %w", nsType, peerId, err)
+		}
+
+		nsPath := state.NamespacePaths[sharedNsTypes[nsType]]
+		if nsPath == "" {
+			return fmt.Errorf("peer container %s has no %s namespace", peerId, nsType)
+		}
+
+		setNsPath(spec, nsType, nsPath)
+
+		// the hostname is owned by the peer; don't override it
+		if nsType == specs.UTSNamespace && spec.Hostname != "" {
+			logrus.Debugf("ignoring hostname %q as the container joins the uts namespace of peer container %s", spec.Hostname, peerId)
+			spec.Hostname = ""
+		}
+
+		logrus.Debugf("joining the %s namespace of peer container %s (%s)", nsType, peerId, nsPath)
+	}
+
+	return nil
+}
+
+// joinPeerUserns sets up the spec so that the container joins the user-ns of
+// the given peer container, or verifies that it already does.
+func joinPeerUserns(spec *specs.Spec, peerId string, state *libcontainer.State) error {
+	peerUserns := state.NamespacePaths[configs.NEWUSER]
+	if peerUserns == "" {
+		return fmt.Errorf("peer container %s has no user namespace", peerId)
+	}
+
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.UserNamespace && ns.Path != "" {
+			same, err := sameNamespace(ns.Path, peerUserns)
+			if err != nil {
+				return err
+			}
+			if !same {
+				return fmt.Errorf("the container's user namespace (%s) differs from the peer's (%s)", ns.Path, peerUserns)
+			}
+			return nil
+		}
+	}
+
+	uidMappings := specIDMappings(state.Config.UidMappings)
+	gidMappings := specIDMappings(state.Config.GidMappings)
+
+	if len(spec.Linux.UIDMappings) == 0 && len(spec.Linux.GIDMappings) == 0 {
+		spec.Linux.UIDMappings = uidMappings
+		spec.Linux.GIDMappings = gidMappings
+	} else if !equalIDMappings(spec.Linux.UIDMappings, uidMappings) ||
+		!equalIDMappings(spec.Linux.GIDMappings, gidMappings) {
+		return fmt.Errorf("the container's user namespace ID mappings differ from the peer's")
+	}
+
+	setNsPath(spec, specs.UserNamespace, peerUserns)
+	return nil
+}
+
+// setNsPath sets the path of the given namespace in the spec, adding the
+// namespace if it's not in the spec.
+func setNsPath(spec *specs.Spec, nsType specs.LinuxNamespaceType, path string) {
+	for i, ns := range spec.Linux.Namespaces {
+		if ns.Type == nsType {
+			spec.Linux.Namespaces[i].Path = path
+			return
+		}
+	}
+	spec.Linux.Namespaces = append(spec.Linux.Namespaces, specs.LinuxNamespace{Type: nsType, Path: path})
+}
+
+// sameNamespace returns true if the given namespace paths refer to the same
+// namespace.
+func sameNamespace(path1, path2 string) (bool, error) {
+	var st1, st2 unix.Stat_t
+
+	if err := unix.Stat(path1, &st1); err != nil {
+		return false, fmt.Errorf("unable to stat %q: %s", path1, err)
+	}
+	if err := unix.Stat(path2, &st2); err != nil {
+		return false, fmt.Errorf("unable to stat %q: %s", path2, err)
+	}
+
+	return st1.Dev == st2.Dev && st1.Ino == st2.Ino, nil
+}
+
+func specIDMappings(idMaps []configs.IDMap) []specs.LinuxIDMapping {
+	mappings := make([]specs.LinuxIDMapping, 0, len(idMaps))
+	for _, m := range idMaps {
+		mappings = append(mappings, specs.LinuxIDMapping{
+			ContainerID: uint32(m.ContainerID),
+			HostID:      uint32(m.HostID),
+			Size:        uint32(m.Size),
+		})
+	}
+	return mappings
+}
+
+func equalIDMappings(a, b []specs.LinuxIDMapping) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,660 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package snapshot captures the writable state of a sys container, i.e., the
+// changes to its rootfs and the host dirs sysbox-mgr backs its special dirs
+// and volumes with (e.g., its docker image cache or kubelet state), so that
+// it can seed new sys containers (e.g., pre-warmed CI runners).
+//
+// A snapshot is a dir (or a tar archive of it) holding:
+//
+//	snapshot.json   the manifest (see Manifest), written last
+//	rootfs/         the container's rootfs changes (or its whole rootfs)
+//	volumes/<n>/    the dirs backed by sysbox-mgr (see Manifest.Volumes)
+//
+// Files in a snapshot have the ownership seen inside the container (rather
+// than on the host), so that it can seed containers with other ID mappings.
+package snapshot
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ManifestFile is the snapshot's manifest file.
+const ManifestFile = "snapshot.json"
+
+// Snapshot layout
+const (
+	rootfsDir  = "rootfs"
+	volumesDir = "volumes"
+)
+
+// idshiftMarkerXattr records the ownership shift of a rootfs (see the idshift
+// package); it describes the source container's rootfs, so it's not captured.
+const idshiftMarkerXattr = "trusted.sysbox.idshift"
+
+// Volume is a dir of the container backed by sysbox-mgr.
+type Volume struct {
+	Dest string `json:"dest"` // path in the container
+	Dir  string `json:"dir"`  // path in the snapshot
+}
+
+// Manifest describes a snapshot.
+type Manifest struct {
+	Container string    `json:"container"`
+	Created   time.Time `json:"created"`
+
+	// RootfsDelta indicates that the snapshot's rootfs only holds the
+	// container's changes to its rootfs (i.e., the overlayfs upper layer of a
+	// rootfs clone, see the rootfsclone package), which only make sense on
+	// top of the same rootfs; otherwise it holds the whole rootfs.
+	RootfsDelta bool `json:"rootfsDelta"`
+
+	Volumes []Volume `json:"volumes,omitempty"`
+}
+
+// IDMap maps the container's uids and gids [0, Size) to host ids
+// [Host, Host+Size).
+type IDMap struct {
+	UidHost uint32
+	UidSize uint32
+	GidHost uint32
+	GidSize uint32
+}
+
+// Source is the writable state of a container.
+type Source struct {
+	ID string
+
+	// Rootfs is the container's rootfs, or its overlayfs upper layer if
+	// RootfsDelta is set.
+	Rootfs      string
+	RootfsDelta bool
+
+	// Volumes maps the container dirs backed by sysbox-mgr to their host
+	// dirs.
+	Volumes map[string]string
+
+	IDs IDMap
+}
+
+// CaptureDir captures a snapshot of the given container state into dir, which
+// must not exist. The container must be quiesced (e.g., paused) meanwhile.
+func CaptureDir(src Source, dir string) (err error) {
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	return capture(src, &dirWriter{root: dir})
+}
+
+// CaptureTar is like CaptureDir(), but writes the snapshot as a tar archive.
+func CaptureTar(src Source, w io.Writer) error {
+	return capture(src, &tarWriter{tw: tar.NewWriter(w)})
+}
+
+func capture(src Source, w writer) error {
+	toContainer := func(uid, gid uint32) (uint32, uint32) {
+		return unshiftID(uid, src.IDs.UidHost, src.IDs.UidSize), unshiftID(gid, src.IDs.GidHost, src.IDs.GidSize)
+	}
+
+	m := Manifest{
+		Container:   src.ID,
+		Created:     time.Now().UTC(),
+		RootfsDelta: src.RootfsDelta,
+	}
+
+	if err := copyTree(w, src.Rootfs, rootfsDir, toContainer); err != nil {
+		return fmt.Errorf("failed to capture rootfs %s: %v", src.Rootfs, err)
+	}
+
+	dests := []string{}
+	for dest := range src.Volumes {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+
+	if len(dests) > 0 {
+		if err := w.add(volumesDir, newDirEntry()); err != nil {
+			return err
+		}
+	}
+	for i, dest := range dests {
+		vol := Volume{Dest: dest, Dir: path.Join(volumesDir, strconv.Itoa(i))}
+		if err := copyTree(w, src.Volumes[dest], vol.Dir, toContainer); err != nil {
+			return fmt.Errorf("failed to capture %s (at %s): %v", src.Volumes[dest], dest, err)
+		}
+		m.Volumes = append(m.Volumes, vol)
+	}
+
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := w.addFile(ManifestFile, data); err != nil {
+		return err
+	}
+
+	return w.close()
+}
+
+// Load returns the manifest of the snapshot in dir. The manifest's volumes
+// must be dirs of the snapshot (reached without following symlinks), as
+// they're copied into the host dirs of the containers seeded from it.
+func Load(dir string) (*Manifest, error) {
+	f, err := openInSnapshot(dir, ManifestFile, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s is not a snapshot dir (or an incomplete one)", dir)
+		}
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid snapshot manifest %s: %v", filepath.Join(dir, ManifestFile), err)
+	}
+
+	for _, vol := range m.Volumes {
+		if !path.IsAbs(vol.Dest) {
+			return nil, fmt.Errorf("invalid snapshot manifest %s: volume dest %q is not an absolute path",
+				filepath.Join(dir, ManifestFile), vol.Dest)
+		}
+		if path.Clean(vol.Dir) != vol.Dir || path.Dir(vol.Dir) != volumesDir {
+			return nil, fmt.Errorf("invalid snapshot manifest %s: volume dir %q is not in the snapshot's %s dir",
+				filepath.Join(dir, ManifestFile), vol.Dir, volumesDir)
+		}
+		vf, err := openInSnapshot(dir, vol.Dir, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot volume dir %s: %v", vol.Dir, err)
+		}
+		vf.Close()
+	}
+
+	return m, nil
+}
+
+// SeedRootfs copies the rootfs of the snapshot in snapDir into dir (e.g., the
+// upper layer of a rootfs clone). The files keep the snapshot's ownership, as
+// they're shifted along with the rest of the container's rootfs.
+func SeedRootfs(snapDir, dir string) error {
+	keep := func(uid, gid uint32) (uint32, uint32) { return uid, gid }
+
+	return seed(snapDir, rootfsDir, dir, keep)
+}
+
+// SeedVolume copies the given volume of the snapshot in snapDir into dir
+// (i.e., the host dir backing it), with its ownership mapped to the host per
+// the given container ID mappings.
+func SeedVolume(snapDir string, vol Volume, dir string, ids IDMap) error {
+	toHost := func(uid, gid uint32) (uint32, uint32) {
+		return shiftID(uid, ids.UidHost, ids.UidSize), shiftID(gid, ids.GidHost, ids.GidSize)
+	}
+
+	return seed(snapDir, vol.Dir, dir, toHost)
+}
+
+// seed copies the given dir of the snapshot in snapDir (a slash-separated
+// path relative to it) into dir. The snapshot's dir is opened without
+// following symlinks, and copied through its fd, so that it can't refer to a
+// host dir outside of the snapshot.
+func seed(snapDir, name, dir string, ids func(uid, gid uint32) (uint32, uint32)) error {
+	src, err := openInSnapshot(snapDir, name, true)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w := &dirWriter{root: dir}
+	if err := copyTree(w, fmt.Sprintf("/proc/self/fd/%d/.", src.Fd()), ".", ids); err != nil {
+		return err
+	}
+	return w.close()
+}
+
+// openInSnapshot opens the given file of the snapshot in snapDir (a
+// slash-separated path relative to it) without following symlinks, so that it
+// can't refer to a host file outside of the snapshot; it must be a dir if
+// isDir is set, and a regular file otherwise.
+func openInSnapshot(snapDir, name string, isDir bool) (*os.File, error) {
+	fd, err := unix.Open(snapDir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: snapDir, Err: err}
+	}
+
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "" || elem == "." {
+			continue
+		}
+		if elem == ".." {
+			unix.Close(fd)
+			return nil, fmt.Errorf("%s is outside of the snapshot %s", name, snapDir)
+		}
+		next, err := unix.Openat(fd, elem, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+		unix.Close(fd)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: filepath.Join(snapDir, name), Err: err}
+		}
+		fd = next
+	}
+
+	f := os.NewFile(uintptr(fd), filepath.Join(snapDir, filepath.FromSlash(name)))
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if isDir && !fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a directory", f.Name())
+	}
+	if !isDir && !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a regular file", f.Name())
+	}
+	return f, nil
+}
+
+// unshiftID maps a host id in [host, host+size) to the container; other ids
+// (e.g., those written to the container's rootfs through shiftfs) are kept.
+func unshiftID(id, host, size uint32) uint32 {
+	if id >= host && id-host < size {
+		return id - host
+	}
+	return id
+}
+
+// shiftID maps a container id in [0, size) to the host; other ids are kept.
+func shiftID(id, host, size uint32) uint32 {
+	if id < size {
+		return id + host
+	}
+	return id
+}
+
+// entry is a file copied to a snapshot (or from it).
+type entry struct {
+	src      string         // path of the file
+	st       syscall.Stat_t // its stat
+	link     string         // symlink target
+	hardlink string         // name of an earlier hard link to it, if any
+	xattrs   map[string][]byte
+	uid, gid uint32 // its owner in the copy
+}
+
+func newDirEntry() *entry {
+	e := &entry{}
+	e.st.Mode = unix.S_IFDIR | 0700
+	now := time.Now()
+	e.st.Mtim = syscall.NsecToTimespec(now.UnixNano())
+	return e
+}
+
+// writer writes the files of a snapshot (or seeds a dir from one).
+type writer interface {
+	// add adds the given file with the given name (a slash-separated path
+	// relative to the snapshot root); dirs are added before their contents.
+	add(name string, e *entry) error
+
+	// addFile adds a regular file with the given contents.
+	addFile(name string, data []byte) error
+
+	close() error
+}
+
+type devIno struct {
+	dev uint64
+	ino uint64
+}
+
+// copyTree adds the tree rooted at root to w, under the given name, mapping
+// the ownership of its files with ids. Symlinks aren't followed; sockets are
+// skipped.
+func copyTree(w writer, root, name string, ids func(uid, gid uint32) (uint32, uint32)) error {
+	links := map[devIno]string{}
+
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		e := &entry{src: p, st: *fi.Sys().(*syscall.Stat_t)}
+		entryName := path.Join(name, filepath.ToSlash(rel))
+
+		switch e.st.Mode & unix.S_IFMT {
+		case unix.S_IFSOCK:
+			return nil
+		case unix.S_IFLNK:
+			if e.link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case unix.S_IFDIR:
+		default:
+			if e.st.Nlink > 1 {
+				key := devIno{uint64(e.st.Dev), uint64(e.st.Ino)}
+				if first, ok := links[key]; ok {
+					e.hardlink = first
+					return w.add(entryName, e)
+				}
+				links[key] = entryName
+			}
+		}
+
+		e.uid, e.gid = ids(e.st.Uid, e.st.Gid)
+		if e.xattrs, err = readXattrs(p); err != nil {
+			return err
+		}
+
+		return w.add(entryName, e)
+	})
+}
+
+// readXattrs returns the extended attributes of the given file (not following
+// symlinks).
+func readXattrs(p string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(p, nil)
+	if err != nil {
+		if err == unix.ENOTSUP {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list xattrs of %s: %v", p, err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(p, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list xattrs of %s: %v", p, err)
+	}
+
+	xattrs := map[string][]byte{}
+	for _, name := range splitNull(buf[:size]) {
+		if name == idshiftMarkerXattr {
+			continue
+		}
+		vsize, err := unix.Lgetxattr(p, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get xattr %s of %s: %v", name, p, err)
+		}
+		val := make([]byte, vsize)
+		vsize, err = unix.Lgetxattr(p, name, val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get xattr %s of %s: %v", name, p, err)
+		}
+		xattrs[name] = val[:vsize]
+	}
+	return xattrs, nil
+}
+
+func splitNull(buf []byte) []string {
+	names := []string{}
+	start := 0
+	for i, b := range buf {
+		if b == 0 {
+			if i > start {
+				names = append(names, string(buf[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return names
+}
+
+// dirWriter copies the files to a dir. Files already in it are replaced
+// (except dirs by dirs, which are merged).
+type dirWriter struct {
+	root string
+
+	// dirs added, whose mode and times are set once their contents are in
+	dirs []dirAttrs
+}
+
+type dirAttrs struct {
+	path string
+	mode uint32
+	mtim syscall.Timespec
+}
+
+func (w *dirWriter) add(name string, e *entry) error {
+	p := filepath.Join(w.root, filepath.FromSlash(name))
+	ftype := e.st.Mode & unix.S_IFMT
+
+	if err := w.clear(p, ftype == unix.S_IFDIR); err != nil {
+		return err
+	}
+
+	if e.hardlink != "" {
+		return os.Link(filepath.Join(w.root, filepath.FromSlash(e.hardlink)), p)
+	}
+
+	var err error
+	switch ftype {
+	case unix.S_IFDIR:
+		if err = os.Mkdir(p, 0700); os.IsExist(err) {
+			err = nil
+		}
+	case unix.S_IFREG:
+		err = copyFile(e.src, p)
+	case unix.S_IFLNK:
+		err = os.Symlink(e.link, p)
+	default:
+		err = unix.Mknod(p, e.st.Mode, int(e.st.Rdev))
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Lchown(p, int(e.uid), int(e.gid)); err != nil {
+		return err
+	}
+	for name, val := range e.xattrs {
+		if err := unix.Lsetxattr(p, name, val, 0); err != nil {
+			return fmt.Errorf("failed to set xattr %s on %s: %v", name, p, err)
+		}
+	}
+
+	switch ftype {
+	case unix.S_IFDIR:
+		w.dirs = append(w.dirs, dirAttrs{p, e.st.Mode & 07777, e.st.Mtim})
+		return nil
+	case unix.S_IFLNK:
+	default:
+		// after the chown, which clears the setuid & setgid bits
+		if err := unix.Chmod(p, e.st.Mode&07777); err != nil {
+			return fmt.Errorf("failed to chmod %s: %v", p, err)
+		}
+	}
+	return setTimes(p, e.st.Mtim)
+}
+
+// clear removes whatever is at the given path, unless it's a dir and a dir is
+// to be added there.
+func (w *dirWriter) clear(p string, isDir bool) error {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if isDir && fi.IsDir() {
+		return nil
+	}
+	return os.RemoveAll(p)
+}
+
+func (w *dirWriter) addFile(name string, data []byte) error {
+	return ioutil.WriteFile(filepath.Join(w.root, filepath.FromSlash(name)), data, 0600)
+}
+
+func (w *dirWriter) close() error {
+	// children before their parents
+	for i := len(w.dirs) - 1; i >= 0; i-- {
+		d := w.dirs[i]
+		if err := unix.Chmod(d.path, d.mode); err != nil {
+			return fmt.Errorf("failed to chmod %s: %v", d.path, err)
+		}
+		if err := setTimes(d.path, d.mtim); err != nil {
+			return err
+		}
+	}
+	w.dirs = nil
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.OpenFile(src, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func setTimes(p string, mtim syscall.Timespec) error {
+	ts := []unix.Timespec{unix.Timespec(mtim), unix.Timespec(mtim)}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, p, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("failed to set the times of %s: %v", p, err)
+	}
+	return nil
+}
+
+// tarWriter writes the files to a tar archive (in PAX format, with the
+// extended attributes as "SCHILY.xattr." records).
+type tarWriter struct {
+	tw *tar.Writer
+}
+
+func (w *tarWriter) add(name string, e *entry) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(e.st.Mode & 07777),
+		Uid:     int(e.uid),
+		Gid:     int(e.gid),
+		ModTime: time.Unix(e.st.Mtim.Unix()),
+		Format:  tar.FormatPAX,
+	}
+
+	if e.hardlink != "" {
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = e.hardlink
+		return w.tw.WriteHeader(hdr)
+	}
+
+	switch e.st.Mode & unix.S_IFMT {
+	case unix.S_IFDIR:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case unix.S_IFREG:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = e.st.Size
+	case unix.S_IFLNK:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = e.link
+	case unix.S_IFCHR:
+		hdr.Typeflag = tar.TypeChar
+	case unix.S_IFBLK:
+		hdr.Typeflag = tar.TypeBlock
+	case unix.S_IFIFO:
+		hdr.Typeflag = tar.TypeFifo
+	}
+	if hdr.Typeflag == tar.TypeChar || hdr.Typeflag == tar.TypeBlock {
+		hdr.Devmajor = int64(unix.Major(uint64(e.st.Rdev)))
+		hdr.Devminor = int64(unix.Minor(uint64(e.st.Rdev)))
+	}
+
+	if len(e.xattrs) > 0 {
+		hdr.PAXRecords = map[string]string{}
+		for name, val := range e.xattrs {
+			hdr.PAXRecords["SCHILY.xattr."+name] = string(val)
+		}
+	}
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	f, err := os.OpenFile(e.src, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the file can't change size, as the container is quiesced
+	_, err = io.CopyN(w.tw, f, hdr.Size)
+	return err
+}
+
+func (w *tarWriter) addFile(name string, data []byte) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+		Format:   tar.FormatPAX,
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := w.tw.Write(data)
+	return err
+}
+
+func (w *tarWriter) close() error {
+	return w.tw.Close()
+}
@@ -0,0 +1,342 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestShiftID(t *testing.T) {
+	tests := []struct {
+		id, host, size uint32
+		unshifted      uint32
+	}{
+		{100000, 100000, 65536, 0},
+		{100999, 100000, 65536, 999},
+		{165536, 100000, 65536, 165536},
+		{5, 100000, 65536, 5},
+	}
+
+	for _, test := range tests {
+		if got := unshiftID(test.id, test.host, test.size); got != test.unshifted {
+			t.Errorf("unshiftID(%d, %d, %d): want %d, got %d", test.id, test.host, test.size, test.unshifted, got)
+		}
+	}
+
+	if got := shiftID(999, 200000, 65536); got != 200999 {
+		t.Errorf("shiftID(999, 200000, 65536): want 200999, got %d", got)
+	}
+	if got := shiftID(65536, 200000, 65536); got != 65536 {
+		t.Errorf("shiftID(65536, 200000, 65536): want 65536, got %d", got)
+	}
+}
+
+// testSource creates the writable state of a container whose ids are mapped
+// to host ids 100000-165535.
+func testSource(t *testing.T, dir string) Source {
+	rootfs := filepath.Join(dir, "upper")
+	docker := filepath.Join(dir, "docker")
+
+	for _, d := range []string{filepath.Join(rootfs, "etc"), filepath.Join(docker, "image")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "etc/hostname"), []byte("runner"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(rootfs, "etc/hostname"), filepath.Join(rootfs, "etc/hostname.bak")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("hostname", filepath.Join(rootfs, "etc/name")); err != nil {
+		t.Fatal(err)
+	}
+	// overlayfs whiteout
+	if err := unix.Mknod(filepath.Join(rootfs, "etc/motd"), unix.S_IFCHR, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(docker, "image/repositories.json"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{rootfs, filepath.Join(rootfs, "etc"), filepath.Join(rootfs, "etc/hostname")} {
+		if err := os.Lchown(p, 100000, 100000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Lchown(filepath.Join(docker, "image/repositories.json"), 101000, 101000); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unix.Lsetxattr(rootfs, idshiftMarkerXattr, []byte("{}"), 0); err != nil {
+		t.Skipf("trusted xattrs not supported: %v", err)
+	}
+	if err := unix.Lsetxattr(filepath.Join(rootfs, "etc"), "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	return Source{
+		ID:          "c1",
+		Rootfs:      rootfs,
+		RootfsDelta: true,
+		Volumes:     map[string]string{"/var/lib/docker": docker},
+		IDs:         IDMap{UidHost: 100000, UidSize: 65536, GidHost: 100000, GidSize: 65536},
+	}
+}
+
+func owner(t *testing.T, p string) (uint32, uint32) {
+	var st unix.Stat_t
+	if err := unix.Lstat(p, &st); err != nil {
+		t.Fatal(err)
+	}
+	return st.Uid, st.Gid
+}
+
+func TestCaptureDirAndSeed(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := testSource(t, filepath.Join(dir, "src"))
+	snapDir := filepath.Join(dir, "snap")
+
+	if err := CaptureDir(src, snapDir); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Load(snapDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Volume{{Dest: "/var/lib/docker", Dir: "volumes/0"}}
+	if m.Container != "c1" || !m.RootfsDelta || !reflect.DeepEqual(m.Volumes, want) {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+
+	// the snapshot has the container's ownership
+	if uid, gid := owner(t, filepath.Join(snapDir, "rootfs/etc/hostname")); uid != 0 || gid != 0 {
+		t.Errorf("rootfs/etc/hostname: want owner 0:0, got %d:%d", uid, gid)
+	}
+	if uid, _ := owner(t, filepath.Join(snapDir, "volumes/0/image/repositories.json")); uid != 1000 {
+		t.Errorf("volumes/0/image/repositories.json: want owner 1000, got %d", uid)
+	}
+
+	var st1, st2 unix.Stat_t
+	if err := unix.Stat(filepath.Join(snapDir, "rootfs/etc/hostname"), &st1); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Stat(filepath.Join(snapDir, "rootfs/etc/hostname.bak"), &st2); err != nil {
+		t.Fatal(err)
+	}
+	if st1.Ino != st2.Ino {
+		t.Errorf("hard link not preserved")
+	}
+	if err := unix.Lstat(filepath.Join(snapDir, "rootfs/etc/motd"), &st1); err != nil || st1.Mode&unix.S_IFMT != unix.S_IFCHR {
+		t.Errorf("whiteout not preserved (err %v)", err)
+	}
+	if link, err := os.Readlink(filepath.Join(snapDir, "rootfs/etc/name")); err != nil || link != "hostname" {
+		t.Errorf("symlink not preserved: got %q (err %v)", link, err)
+	}
+
+	buf := make([]byte, 16)
+	if n, err := unix.Lgetxattr(filepath.Join(snapDir, "rootfs/etc"), "trusted.overlay.opaque", buf); err != nil || string(buf[:n]) != "y" {
+		t.Errorf("opaque xattr not preserved (err %v)", err)
+	}
+	if _, err := unix.Lgetxattr(filepath.Join(snapDir, "rootfs"), idshiftMarkerXattr, buf); err != unix.ENODATA {
+		t.Errorf("idshift marker captured (err %v)", err)
+	}
+
+	// seed a container whose ids are mapped to host ids 200000-265535
+	upper := filepath.Join(dir, "upper2")
+	docker := filepath.Join(dir, "docker2")
+	for _, d := range []string{upper, docker} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SeedRootfs(snapDir, upper); err != nil {
+		t.Fatal(err)
+	}
+	if err := SeedVolume(snapDir, m.Volumes[0], docker, IDMap{200000, 65536, 200000, 65536}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(upper, "etc/hostname"))
+	if err != nil || string(data) != "runner" {
+		t.Errorf("seeded etc/hostname: got %q (err %v)", data, err)
+	}
+	if uid, _ := owner(t, filepath.Join(upper, "etc/hostname")); uid != 0 {
+		t.Errorf("seeded etc/hostname: want owner 0, got %d", uid)
+	}
+	if uid, gid := owner(t, filepath.Join(docker, "image/repositories.json")); uid != 201000 || gid != 201000 {
+		t.Errorf("seeded image/repositories.json: want owner 201000:201000, got %d:%d", uid, gid)
+	}
+	fi, err := os.Stat(filepath.Join(docker, "image/repositories.json"))
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("seeded image/repositories.json: want mode 0600, got %v (err %v)", fi.Mode(), err)
+	}
+}
+
+func TestCaptureTar(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := testSource(t, dir)
+
+	var buf bytes.Buffer
+	if err := CaptureTar(src, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+
+		switch hdr.Name {
+		case "rootfs/etc/hostname":
+			if hdr.Uid != 0 || hdr.Size != int64(len("runner")) {
+				t.Errorf("%s: want uid 0 and size %d, got %d and %d", hdr.Name, len("runner"), hdr.Uid, hdr.Size)
+			}
+		case "rootfs/etc/hostname.bak":
+			if hdr.Typeflag != tar.TypeLink || hdr.Linkname != "rootfs/etc/hostname" {
+				t.Errorf("%s: want a hard link to rootfs/etc/hostname, got type %c to %q", hdr.Name, hdr.Typeflag, hdr.Linkname)
+			}
+		case "rootfs/etc/":
+			if hdr.PAXRecords["SCHILY.xattr.trusted.overlay.opaque"] != "y" {
+				t.Errorf("%s: opaque xattr not preserved", hdr.Name)
+			}
+		case "volumes/0/image/repositories.json":
+			if hdr.Uid != 1000 {
+				t.Errorf("%s: want uid 1000, got %d", hdr.Name, hdr.Uid)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	want := []string{
+		"rootfs/",
+		"rootfs/etc/",
+		"rootfs/etc/hostname",
+		"rootfs/etc/hostname.bak",
+		"rootfs/etc/motd",
+		"rootfs/etc/name",
+		ManifestFile,
+		"volumes/",
+		"volumes/0/",
+		"volumes/0/image/",
+		"volumes/0/image/repositories.json",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("want entries %v, got %v", want, names)
+	}
+}
+
+func TestLoadConfined(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outside := filepath.Join(dir, "outside")
+	snapDir := filepath.Join(dir, "snap")
+	for _, d := range []string{outside, snapDir, filepath.Join(snapDir, "volumes/0")} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(snapDir, "volumes/1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(snapDir, "rootfs")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		manifest string
+		wantErr  bool
+	}{
+		{`{"volumes": [{"dest": "/var/lib/docker", "dir": "volumes/0"}]}`, false},
+		{`{"volumes": [{"dest": "/var/lib/docker", "dir": "volumes/1"}]}`, true},
+		{`{"volumes": [{"dest": "/var/lib/docker", "dir": "../outside"}]}`, true},
+		{`{"volumes": [{"dest": "/var/lib/docker", "dir": "volumes/0/../../../outside"}]}`, true},
+		{`{"volumes": [{"dest": "/var/lib/docker", "dir": "/volumes/0"}]}`, true},
+		{`{"volumes": [{"dest": "/var/lib/docker", "dir": "volumes/2"}]}`, true},
+		{`{"volumes": [{"dest": "var/lib/docker", "dir": "volumes/0"}]}`, true},
+	}
+
+	for _, test := range tests {
+		if err := ioutil.WriteFile(filepath.Join(snapDir, ManifestFile), []byte(test.manifest), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(snapDir); (err != nil) != test.wantErr {
+			t.Errorf("Load() with manifest %s: want err = %v, got %v", test.manifest, test.wantErr, err)
+		}
+	}
+
+	// the manifest itself must not be a symlink
+	manifest := filepath.Join(outside, ManifestFile)
+	if err := ioutil.WriteFile(manifest, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(snapDir, ManifestFile))
+	if err := os.Symlink(manifest, filepath.Join(snapDir, ManifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(snapDir); err == nil {
+		t.Errorf("Load() with a symlinked manifest: want error")
+	}
+
+	// nor can the seeded dirs be
+	if err := SeedRootfs(snapDir, filepath.Join(dir, "upper")); err == nil {
+		t.Errorf("SeedRootfs() with a symlinked rootfs: want error")
+	}
+	if err := SeedVolume(snapDir, Volume{Dest: "/var/lib/docker", Dir: "volumes/1"}, filepath.Join(dir, "docker"), IDMap{}); err == nil {
+		t.Errorf("SeedVolume() with a symlinked volume dir: want error")
+	}
+}
@@ -0,0 +1,39 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spectest
+
+import (
+	"github.com/opencontainers/runc/libsysbox/audit"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Diff returns the differences between the given specs, one per line. Each line
+// starts with "-" for a field only in a, "+" for a field only in b, or "~" for
+// a field whose value differs, followed by the field's jq path and value(s), as
+// in `~ .process.cwd: "/" -> "/root"`. List elements are compared by position.
+func Diff(a, b *specs.Spec) ([]string, error) {
+	changes, err := audit.Changes(a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := []string{}
+	for _, c := range changes {
+		diffs = append(diffs, c.String())
+	}
+	return diffs, nil
+}
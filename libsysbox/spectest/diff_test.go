@@ -0,0 +1,73 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spectest
+
+import (
+	"reflect"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestDiff(t *testing.T) {
+	a := &specs.Spec{
+		Version:  "1.0.2",
+		Hostname: "test",
+		Process: &specs.Process{
+			Args: []string{"sh", "-c", "true"},
+			Cwd:  "/",
+		},
+		Linux: &specs.Linux{
+			MaskedPaths: []string{"/proc/kcore", "/proc/keys"},
+		},
+	}
+
+	b := &specs.Spec{
+		Version: "1.0.2",
+		Process: &specs.Process{
+			Args: []string{"sh", "-c", "true"},
+			Cwd:  "/root",
+		},
+		Linux: &specs.Linux{
+			MaskedPaths: []string{"/proc/kcore"},
+			Namespaces:  []specs.LinuxNamespace{{Type: specs.UserNamespace}},
+		},
+	}
+
+	want := []string{
+		`- .hostname: "test"`,
+		`- .linux.maskedPaths[1]: "/proc/keys"`,
+		`+ .linux.namespaces: [{"type":"user"}]`,
+		`~ .process.cwd: "/" -> "/root"`,
+	}
+
+	got, err := Diff(a, b)
+	if err != nil {
+		t.Fatalf("Diff() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %q; want %q", got, want)
+	}
+
+	got, err = Diff(a, a)
+	if err != nil {
+		t.Fatalf("Diff() failed: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Diff() on equal specs = %q; want no diffs", got)
+	}
+}
@@ -0,0 +1,229 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package spectest provides a versioned corpus of container specs as generated
+// by common container engines (docker, containerd CRI, nerdctl, podman), along
+// with their conversion to sys container specs (golden files). It's meant for
+// checking that changes to the spec conversion logic (see the syscont package)
+// have no unintended effects; see Check().
+package spectest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// CorpusVersion is the version of the spec corpus. It's bumped when fixtures
+// are added or changed because container engines changed the specs they
+// generate; golden files are updated within a version when the conversion
+// logic changes intentionally.
+const CorpusVersion = "v1"
+
+// ContainerID is the container ID used when converting specs (it shows up in
+// the sysbox-fs mount sources).
+const ContainerID = "spectest"
+
+// UpdateEnv is the env var that, when set to a non-empty value, makes Check()
+// rewrite the golden files rather than compare against them.
+const UpdateEnv = "SYSBOX_SPECTEST_UPDATE"
+
+// Fixture file names
+const (
+	inputFile  = "config.json"
+	goldenFile = "converted.json"
+)
+
+// Fixture is an entry in the spec corpus.
+type Fixture struct {
+	Name   string // container engine and scenario (e.g., "docker")
+	Input  string // path of the spec generated by the container engine
+	Golden string // path of the spec converted by sysbox-runc
+}
+
+// T is the subset of testing.TB used by Check().
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// CorpusDir returns the dir of the spec corpus for CorpusVersion.
+func CorpusDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata", CorpusVersion)
+}
+
+// Corpus returns the fixtures in the spec corpus, sorted by name.
+func Corpus() ([]Fixture, error) {
+	return CorpusAt(CorpusDir())
+}
+
+// CorpusAt returns the fixtures in the given dir, sorted by name; it has the
+// layout of the spec corpus (a subdir per fixture, holding its config.json and
+// converted.json), so that users may check the conversion of their own specs
+// with Check().
+func CorpusAt(dir string) ([]Fixture, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := []Fixture{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		fixtures = append(fixtures, Fixture{
+			Name:   e.Name(),
+			Input:  filepath.Join(dir, e.Name(), inputFile),
+			Golden: filepath.Join(dir, e.Name(), goldenFile),
+		})
+	}
+
+	sort.Slice(fixtures, func(i, j int) bool { return fixtures[i].Name < fixtures[j].Name })
+	return fixtures, nil
+}
+
+// LoadSpec reads the spec at the given path.
+func LoadSpec(path string) (*specs.Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec specs.Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse spec %s: %v", path, err)
+	}
+	return &spec, nil
+}
+
+// WriteSpec writes the given spec to the given path, in the format of the
+// golden files.
+func WriteSpec(path string, spec *specs.Spec) error {
+	data, err := json.MarshalIndent(spec, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// UidShiftBackends are the uid shifting backends the specs are converted with
+// (see Convert()), unless they select theirs.
+const UidShiftBackends = "shiftfs,idmapped-mount,chown"
+
+// Convert returns the conversion of the given spec to a sys container spec, as
+// done by sysbox-runc when creating a container with ID ContainerID, using
+// sysbox-fs at its default mountpoint and no sysbox-mgr (whose mounts depend
+// on the host's state). The spec's rootfs is replaced by an empty temporary
+// dir during the conversion, so the result doesn't depend on the host either;
+// as it needs uid shifting, chown'ing it is enabled (unless the spec selects
+// its uid shifting backends), so that it doesn't depend on shiftfs.
+func Convert(spec *specs.Spec) (*specs.Spec, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var conv specs.Spec
+	if err := json.Unmarshal(data, &conv); err != nil {
+		return nil, err
+	}
+
+	if conv.Root == nil {
+		return nil, fmt.Errorf("spec has no rootfs")
+	}
+
+	rootfs, err := ioutil.TempDir("", "spectest-rootfs")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(rootfs)
+
+	rootfsPath := conv.Root.Path
+	conv.Root.Path = rootfs
+
+	mgr := sysbox.NewMgr(ContainerID, false)
+	fs := sysbox.NewFs(ContainerID, true)
+
+	_, selected := conv.Annotations[syscont.UidShiftBackendsAnnotation]
+	if !selected {
+		if conv.Annotations == nil {
+			conv.Annotations = make(map[string]string)
+		}
+		conv.Annotations[syscont.UidShiftBackendsAnnotation] = UidShiftBackends
+	}
+
+	if _, _, err := syscont.ConvertSpec(mgr, fs, &conv); err != nil {
+		return nil, err
+	}
+
+	if !selected {
+		delete(conv.Annotations, syscont.UidShiftBackendsAnnotation)
+		if len(conv.Annotations) == 0 && spec.Annotations == nil {
+			conv.Annotations = nil
+		}
+	}
+	conv.Root.Path = rootfsPath
+	return &conv, nil
+}
+
+// Check converts the input spec of the given fixture and compares the result
+// against the fixture's golden file, reporting any differences through t. If
+// UpdateEnv is set, the golden file is rewritten instead.
+func Check(t T, fx Fixture) {
+	t.Helper()
+
+	input, err := LoadSpec(fx.Input)
+	if err != nil {
+		t.Fatalf("%s: %v", fx.Name, err)
+	}
+
+	got, err := Convert(input)
+	if err != nil {
+		t.Fatalf("%s: failed to convert spec %s: %v", fx.Name, fx.Input, err)
+	}
+
+	if os.Getenv(UpdateEnv) != "" {
+		if err := WriteSpec(fx.Golden, got); err != nil {
+			t.Fatalf("%s: failed to update golden file: %v", fx.Name, err)
+		}
+		return
+	}
+
+	want, err := LoadSpec(fx.Golden)
+	if err != nil {
+		t.Fatalf("%s: %v", fx.Name, err)
+	}
+
+	diffs, err := Diff(want, got)
+	if err != nil {
+		t.Fatalf("%s: %v", fx.Name, err)
+	}
+	if len(diffs) > 0 {
+		t.Errorf("%s: converted spec differs from golden file %s (set %s=1 to update it):\n%s",
+			fx.Name, fx.Golden, UpdateEnv, strings.Join(diffs, "\n"))
+	}
+}
@@ -0,0 +1,60 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sysbox
+
+import (
+	ipcLib "github.com/nestybox/sysbox-ipc/sysboxMgrLib"
+	"golang.org/x/sys/unix"
+)
+
+// BackingStore is the kind of host storage backing a special mount of the
+// container (see NativeMountReqInfo).
+type BackingStore string
+
+const (
+	BackingStoreDir         BackingStore = "dir"
+	BackingStoreBtrfsSubvol BackingStore = "btrfs-subvolume"
+	BackingStoreZfsDataset  BackingStore = "zfs-dataset"
+)
+
+// Magic numbers of the filesystems with native backing stores (see statfs(2)).
+var nativeBackingFsMagic = map[int64]BackingStore{
+	0x9123683e: BackingStoreBtrfsSubvol,
+	0x2fc12fc1: BackingStoreZfsDataset,
+}
+
+// nativeBackingKinds are the special mounts that get a native backing store,
+// as the container's inner Docker can then use its btrfs or zfs storage
+// driver.
+var nativeBackingKinds = map[ipcLib.MntKind]bool{
+	ipcLib.MntVarLibDocker: true,
+}
+
+// NativeBackingStore returns the native backing store for the filesystem the
+// given path is on, or BackingStoreDir if it has none.
+func NativeBackingStore(path string) (BackingStore, error) {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", err
+	}
+
+	if store, ok := nativeBackingFsMagic[int64(st.Type)]; ok {
+		return store, nil
+	}
+	return BackingStoreDir, nil
+}
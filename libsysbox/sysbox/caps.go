@@ -0,0 +1,191 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Version & feature negotiation with sysbox-mgr and sysbox-fs
+
+package sysbox
+
+import (
+	"fmt"
+)
+
+// Feature is a sysbox-mgr or sysbox-fs feature used by sysbox-runc.
+type Feature string
+
+// sysbox-mgr features
+const (
+	FeatMgrPrepMounts         Feature = "prep-mounts"
+	FeatMgrShiftfsMark        Feature = "shiftfs-mark"
+	FeatMgrFsState            Feature = "fs-state"
+	FeatMgrPause              Feature = "pause"
+	FeatMgrVolumes            Feature = "volumes"
+	FeatMgrFileCopies         Feature = "file-copies"
+	FeatMgrNativeBackingStore Feature = "native-backing-store"
+	FeatMgrAsyncRelease       Feature = "async-release"
+	FeatMgrRootfsShift        Feature = "rootfs-shift"
+	FeatMgrPrepFingerprint    Feature = "prep-fingerprint"
+	FeatMgrMountRelease       Feature = "mount-release"
+)
+
+// sysbox-fs features
+const (
+	FeatFsMountpoint    Feature = "mountpoint"
+	FeatFsSeccompTracer Feature = "seccomp-tracer"
+	FeatFsQuiesce       Feature = "quiesce"
+	FeatFsSysctls       Feature = "sysctls"
+	FeatFsNotifyQuota   Feature = "fsnotify-quota"
+	FeatFsSwapLimits    Feature = "swap-limits"
+	FeatFsConfigfs      Feature = "configfs"
+	FeatFsClock         Feature = "clock-emulation"
+)
+
+var allMgrFeatures = []Feature{FeatMgrPrepMounts, FeatMgrShiftfsMark, FeatMgrFsState, FeatMgrPause, FeatMgrVolumes, FeatMgrFileCopies, FeatMgrNativeBackingStore, FeatMgrAsyncRelease, FeatMgrRootfsShift, FeatMgrPrepFingerprint, FeatMgrMountRelease}
+var allFsFeatures = []Feature{FeatFsMountpoint, FeatFsSeccompTracer, FeatFsQuiesce, FeatFsSysctls, FeatFsNotifyQuota, FeatFsSwapLimits, FeatFsConfigfs, FeatFsClock}
+
+// clientFeatures are the features that take an optional interface of the
+// MgrClient or FsClient (besides the daemon's support). The default gRPC
+// clients implement none of them, as sysbox-mgr and sysbox-fs don't export
+// these requests yet; so these features are only available with clients that
+// do (see NewMgrWithClient() and NewFsWithClient()).
+var clientFeatures = map[Feature]func(client interface{}) bool{
+	FeatMgrVolumes:            func(c interface{}) bool { _, ok := c.(VolumeProvider); return ok },
+	FeatMgrFileCopies:         func(c interface{}) bool { _, ok := c.(FileCopier); return ok },
+	FeatMgrNativeBackingStore: func(c interface{}) bool { _, ok := c.(NativeMountRequester); return ok },
+	FeatMgrAsyncRelease:       func(c interface{}) bool { _, ok := c.(AsyncReleaser); return ok },
+	FeatMgrRootfsShift:        func(c interface{}) bool { _, ok := c.(RootfsShifter); return ok },
+	FeatMgrPrepFingerprint:    func(c interface{}) bool { _, ok := c.(MountPrepFingerprinter); return ok },
+	FeatMgrMountRelease:       func(c interface{}) bool { _, ok := c.(MountReleaser); return ok },
+	FeatFsQuiesce:             func(c interface{}) bool { _, ok := c.(FsQuiescer); return ok },
+	FeatFsSysctls:             func(c interface{}) bool { _, ok := c.(FsSysctlSetter); return ok },
+	FeatFsNotifyQuota:         func(c interface{}) bool { _, ok := c.(FsNotifyQuotaSetter); return ok },
+	FeatFsSwapLimits:          func(c interface{}) bool { _, ok := c.(FsSwapSetter); return ok },
+}
+
+// versionUnknown is the version of daemons that don't report one.
+const versionUnknown = "unknown"
+
+// Capabilities describes the version and features of sysbox-mgr or sysbox-fs;
+// it's negotiated when the container is created, and persisted in the
+// container's state.
+type Capabilities struct {
+	Version  string    `json:"version"`
+	Features []Feature `json:"features"`
+}
+
+// CapabilitiesReporter may be implemented by a MgrClient or FsClient to report
+// the version & features of the daemon it talks to (see clientFeatures).
+// Daemons behind clients that don't implement it are assumed to support all
+// features.
+type CapabilitiesReporter interface {
+	Capabilities() (*Capabilities, error)
+}
+
+// Has returns true if the given feature is supported. A nil Capabilities
+// (e.g., in the state of a container created by an older sysbox-runc)
+// supports all features.
+func (c *Capabilities) Has(feat Feature) bool {
+	if c == nil {
+		return true
+	}
+	for _, f := range c.Features {
+		if f == feat {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error (an IPCError of kind IPCVersionMismatch, with the
+// given code) if the given feature is not supported by the given daemon.
+func (c *Capabilities) check(code ErrorCode, daemon string, feat Feature) error {
+	if c.Has(feat) {
+		return nil
+	}
+	return &Error{
+		Code: code,
+		Err: &IPCError{
+			Kind: IPCVersionMismatch,
+			Err:  fmt.Errorf("%s %s lacks feature %q required by this container", daemon, c.Version, feat),
+		},
+	}
+}
+
+// negotiateCaps gets the capabilities of the daemon behind the given client;
+// features the client itself can't request (see clientFeatures) are left out.
+func negotiateCaps(client interface{}, allFeatures []Feature) (*Capabilities, error) {
+	caps := &Capabilities{
+		Version:  versionUnknown,
+		Features: allFeatures,
+	}
+
+	if reporter, ok := client.(CapabilitiesReporter); ok {
+		reported, err := reporter.Capabilities()
+		if err != nil {
+			return nil, err
+		}
+		if reported.Version != "" {
+			caps.Version = reported.Version
+		}
+		caps.Features = reported.Features
+	}
+
+	features := []Feature{}
+	for _, f := range caps.Features {
+		if supported, ok := clientFeatures[f]; ok && !supported(client) {
+			continue
+		}
+		features = append(features, f)
+	}
+	caps.Features = features
+
+	return caps, nil
+}
+
+// Negotiate gets the version & features of sysbox-mgr; it must be called
+// before registering the container.
+func (mgr *Mgr) Negotiate() error {
+	caps, err := negotiateCaps(mgr.ipc(), allMgrFeatures)
+	if err != nil {
+		return newIPCError(ErrMgr, err, "failed to get the version & features of sysbox-mgr")
+	}
+	mgr.Caps = caps
+	return nil
+}
+
+// Negotiate gets the version & features of sysbox-fs; it must be called
+// before registering the container. Fails if sysbox-fs lacks features that
+// all sys containers require.
+func (fs *Fs) Negotiate() error {
+	caps, err := negotiateCaps(fs.ipc(), allFsFeatures)
+	if err != nil {
+		return newIPCError(ErrFs, err, "failed to get the version & features of sysbox-fs")
+	}
+
+	// syscall trapping (and thus the seccomp tracer) is required by all sys
+	// containers
+	if err := caps.check(ErrFs, "sysbox-fs", FeatFsSeccompTracer); err != nil {
+		return err
+	}
+
+	fs.Caps = caps
+	return nil
+}
+
+// CheckFeature returns an error if sysbox-fs (or its client) lacks the given
+// feature; it's meant for features the container explicitly requests.
+func (fs *Fs) CheckFeature(feat Feature) error {
+	return fs.Caps.check(ErrFs, "sysbox-fs", feat)
+}
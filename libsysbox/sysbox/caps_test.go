@@ -0,0 +1,89 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sysbox
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// volumeClient is a MgrClient that supports volumes.
+type volumeClient struct {
+	MgrClient
+}
+
+func (volumeClient) ReqVolumes(id, rootfs string, uid, gid uint32, shiftUids bool, vols []MgrVolume) ([]specs.Mount, error) {
+	return nil, nil
+}
+
+// reportingClient is a MgrClient that supports volumes and reports the given
+// capabilities.
+type reportingClient struct {
+	volumeClient
+	caps *Capabilities
+}
+
+func (c reportingClient) Capabilities() (*Capabilities, error) {
+	return c.caps, nil
+}
+
+func TestNegotiateCaps(t *testing.T) {
+
+	// the default client can't request the optional features
+	mgr := NewMgr("c1", true)
+	if err := mgr.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	for feat := range clientFeatures {
+		if mgr.Caps.Has(feat) {
+			t.Errorf("default client: feature %q negotiated", feat)
+		}
+	}
+	if !mgr.Caps.Has(FeatMgrPrepMounts) {
+		t.Errorf("default client: feature %q not negotiated", FeatMgrPrepMounts)
+	}
+
+	mgr = NewMgrWithClient("c1", volumeClient{})
+	if err := mgr.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	if !mgr.Caps.Has(FeatMgrVolumes) || mgr.Caps.Has(FeatMgrFileCopies) {
+		t.Errorf("volume client: want feature %q only, got %v", FeatMgrVolumes, mgr.Caps.Features)
+	}
+
+	// features reported by the daemon but not supported by the client are
+	// left out
+	mgr = NewMgrWithClient("c1", reportingClient{
+		caps: &Capabilities{Version: "0.7.0", Features: []Feature{FeatMgrVolumes, FeatMgrAsyncRelease}},
+	})
+	if err := mgr.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.Caps.Version != "0.7.0" || !mgr.Caps.Has(FeatMgrVolumes) || mgr.Caps.Has(FeatMgrAsyncRelease) {
+		t.Errorf("reporting client: want version 0.7.0 and feature %q only, got %+v", FeatMgrVolumes, mgr.Caps)
+	}
+
+	// explicitly requested features fail loudly
+	fs := NewFs("c1", true)
+	if err := fs.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.CheckFeature(FeatFsSysctls); GetErrorCode(err) != ErrFs {
+		t.Errorf("want error %s, got %v", ErrFs, err)
+	}
+}
@@ -0,0 +1,241 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sysbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"syscall"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorCode is a stable identifier for a class of sysbox-runc errors; it's
+// reported to container engines along with the error message (see the
+// "--log-format json" option), so existing codes must never be renamed.
+type ErrorCode string
+
+const (
+	ErrUnknown           ErrorCode = "SYSBOX_ERR_UNKNOWN"
+	ErrInvalidArgs       ErrorCode = "SYSBOX_ERR_INVALID_ARGS"
+	ErrInvalidSpec       ErrorCode = "SYSBOX_ERR_INVALID_SPEC"
+	ErrInvalidConfig     ErrorCode = "SYSBOX_ERR_INVALID_CONFIG"
+	ErrKernelUnsupported ErrorCode = "SYSBOX_ERR_KERNEL_UNSUPPORTED"
+	ErrUsernsDisabled    ErrorCode = "SYSBOX_ERR_USERNS_DISABLED"
+	ErrShiftfsMissing    ErrorCode = "SYSBOX_ERR_SHIFTFS_MISSING"
+	ErrSubidExhausted    ErrorCode = "SYSBOX_ERR_SUBID_EXHAUSTED"
+	ErrNoDiskSpace       ErrorCode = "SYSBOX_ERR_NO_DISK_SPACE"
+	ErrMgr               ErrorCode = "SYSBOX_ERR_MGR"
+	ErrFs                ErrorCode = "SYSBOX_ERR_FS"
+	ErrContainerNotFound ErrorCode = "SYSBOX_ERR_CONTAINER_NOT_FOUND"
+	ErrContainerIdInUse  ErrorCode = "SYSBOX_ERR_CONTAINER_ID_IN_USE"
+	ErrContainerBadState ErrorCode = "SYSBOX_ERR_CONTAINER_BAD_STATE"
+	ErrSystem            ErrorCode = "SYSBOX_ERR_SYSTEM"
+	ErrPanic             ErrorCode = "SYSBOX_ERR_PANIC"
+)
+
+// Error is an error carrying a sysbox error code.
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError returns an error with the given code and formatted message.
+func newError(code ErrorCode, format string, a ...interface{}) error {
+	return &Error{
+		Code: code,
+		Err:  fmt.Errorf(format, a...),
+	}
+}
+
+// GetErrorCode returns the code of the innermost sysbox error in the given
+// error chain, or ErrUnknown if there's none.
+func GetErrorCode(err error) ErrorCode {
+	code := ErrUnknown
+	for err != nil {
+		var serr *Error
+		if !errors.As(err, &serr) {
+			break
+		}
+		code = serr.Code
+		err = serr.Err
+	}
+	return code
+}
+
+// IPCErrorKind classifies the failures of requests to sysbox-mgr and
+// sysbox-fs, so that callers can tell whether retrying may help.
+type IPCErrorKind string
+
+const (
+	// IPCTemporary means the daemon is unreachable or busy (e.g., it's
+	// restarting, or the request timed out); the request may succeed if
+	// retried.
+	IPCTemporary IPCErrorKind = "temporary"
+
+	// IPCVersionMismatch means the daemon lacks a feature or request required
+	// by the container; retrying won't help until the daemon is upgraded.
+	IPCVersionMismatch IPCErrorKind = "version-mismatch"
+
+	// IPCNotFound means the daemon doesn't know the container (e.g., it was
+	// already unregistered, or the daemon lost its state).
+	IPCNotFound IPCErrorKind = "not-found"
+
+	// IPCExhausted means the daemon ran out of a resource (e.g., subids);
+	// the request may succeed once other containers release it.
+	IPCExhausted IPCErrorKind = "exhausted"
+
+	// IPCFailed means any other failure; retrying won't help.
+	IPCFailed IPCErrorKind = "failed"
+)
+
+// IPCError reports a failed request to sysbox-mgr or sysbox-fs. The Mgr and
+// Fs methods return it wrapped in an Error (with code ErrMgr or ErrFs, or
+// ErrSubidExhausted if sysbox-mgr ran out of subids); use errors.As() to get it, or IsTemporary().
+//
+// MgrClient and FsClient implementations may return IPCErrors to classify
+// their failures; other errors are classified per their type (e.g., a refused
+// connection or a timeout is temporary) or, for the default gRPC clients, per
+// their gRPC status code.
+type IPCError struct {
+	Kind IPCErrorKind
+	Err  error
+}
+
+func (e *IPCError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *IPCError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *IPCError) Temporary() bool {
+	return e.Kind == IPCTemporary
+}
+
+// IsTemporary reports whether the given error is due to a failed request to
+// sysbox-mgr or sysbox-fs that may succeed if retried (see IPCError).
+func IsTemporary(err error) bool {
+	var ierr *IPCError
+	return errors.As(err, &ierr) && ierr.Temporary()
+}
+
+// GetIPCErrorKind returns the kind of the failed request to sysbox-mgr or
+// sysbox-fs the given error is due to, or "" if it's not due to one.
+func GetIPCErrorKind(err error) IPCErrorKind {
+	var ierr *IPCError
+	if errors.As(err, &ierr) {
+		return ierr.Kind
+	}
+	return ""
+}
+
+// newIPCError returns an error with the given code for a request to sysbox-mgr
+// or sysbox-fs that failed with the given cause; the message is the formatted
+// one followed by the cause's.
+func newIPCError(code ErrorCode, cause error, format string, a ...interface{}) error {
+	return &Error{
+		Code: code,
+		Err: &IPCError{
+			Kind: ipcErrorKind(cause),
+			Err:  fmt.Errorf(format+": %w", append(a, cause)...),
+		},
+	}
+}
+
+// ipcErrorKind classifies the given IPC failure (see IPCError).
+func ipcErrorKind(err error) IPCErrorKind {
+	var ierr *IPCError
+	if errors.As(err, &ierr) {
+		return ierr.Kind
+	}
+
+	var nerr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nerr) && nerr.Timeout()) {
+		return IPCTemporary
+	}
+
+	// the daemon's socket is missing or not served (e.g., the daemon is
+	// restarting), or the connection dropped
+	for _, errno := range []syscall.Errno{syscall.ENOENT, syscall.ECONNREFUSED, syscall.ECONNRESET,
+		syscall.EPIPE, syscall.EAGAIN, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return IPCTemporary
+		}
+	}
+
+	// gRPC errors carry their status code (status.FromError() doesn't look
+	// into wrapped errors, so find the gRPC error first)
+	var gerr interface {
+		error
+		GRPCStatus() *status.Status
+	}
+	if !errors.As(err, &gerr) {
+		return IPCFailed
+	}
+	st, _ := status.FromError(gerr)
+	switch st.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+		return IPCTemporary
+	case codes.ResourceExhausted:
+		return IPCExhausted
+	case codes.NotFound:
+		return IPCNotFound
+	case codes.Unimplemented:
+		return IPCVersionMismatch
+	}
+
+	return IPCFailed
+}
+
+// PanicError reports a panic recovered in the given phase of a container
+// operation (e.g., "prepare" for the spec conversion); by then, the resources
+// allocated for the container have been released as on any other failure.
+type PanicError struct {
+	Phase string
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("internal error: panic during container %s: %v", e.Phase, e.Value)
+}
+
+// NewPanicError returns an error (with code ErrPanic) for the given value
+// recovered from a panic in the given phase. It must be called from the
+// deferred function that recovered it, so that the error carries the stack
+// trace of the panic.
+func NewPanicError(phase string, r interface{}) error {
+	return &Error{
+		Code: ErrPanic,
+		Err:  &PanicError{Phase: phase, Value: r, Stack: debug.Stack()},
+	}
+}
@@ -24,7 +24,9 @@ import (
 
 	"github.com/nestybox/sysbox-ipc/sysboxFsGrpc"
 	unixIpc "github.com/nestybox/sysbox-ipc/unix"
+	"github.com/opencontainers/runc/libcontainer/configs"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
 )
 
 // FsRegInfo contains info about a sys container registered with sysbox-fs
@@ -36,14 +38,142 @@ type FsRegInfo struct {
 	IdSize        int
 	ProcRoPaths   []string
 	ProcMaskPaths []string
+	NotifyQuota   *configs.FsNotifyQuota
+	SwapLimits    *FsSwapLimits
+}
+
+// FsSwapLimits are a container's memory and swap cgroup limits, from which
+// sysbox-fs derives the swap shown in the container's /proc/swaps. The fields
+// are as in configs.Resources.
+type FsSwapLimits struct {
+	Memory     int64
+	MemorySwap int64
+	SwapMax    *int64
+}
+
+// NewFsSwapLimits returns the swap limits given by the given cgroup resources,
+// or nil if they set no memory or swap limit.
+func NewFsSwapLimits(r *configs.Resources) *FsSwapLimits {
+	if r == nil || (r.Memory == 0 && r.MemorySwap == 0 && r.MemorySwapMax == nil) {
+		return nil
+	}
+	return &FsSwapLimits{
+		Memory:     r.Memory,
+		MemorySwap: r.MemorySwap,
+		SwapMax:    r.MemorySwapMax,
+	}
+}
+
+// FsClient is the interface through which Fs talks to sysbox-fs. Programs
+// embedding sysbox-runc may provide their own implementation via
+// NewFsWithClient().
+type FsClient interface {
+	GetMountpoint() (string, error)
+	PreRegister(data *sysboxFsGrpc.ContainerData) error
+	Register(data *sysboxFsGrpc.ContainerData) error
+	Update(data *sysboxFsGrpc.ContainerData) error
+	Unregister(data *sysboxFsGrpc.ContainerData) error
+	SendSeccompInit(pid int32, id string, seccompFd int32) error
+}
+
+// FsQuiescer may be implemented by an FsClient whose sysbox-fs supports
+// quiescing the handling of a container's syscall traps, so that the
+// container can be frozen (paused or checkpointed) with no seccomp
+// notification in flight (see clientFeatures).
+type FsQuiescer interface {
+	// Quiesce makes sysbox-fs stop receiving the container's seccomp
+	// notifications (new ones stay queued in the kernel), and returns once
+	// it has replied to those it had received (i.e., it acks the drain).
+	Quiesce(id string) error
+
+	// Unquiesce makes sysbox-fs receive the container's seccomp
+	// notifications again.
+	Unquiesce(id string) error
+}
+
+// FsSysctlSetter may be implemented by an FsClient whose sysbox-fs supports
+// setting the values of non-namespaced sysctls as seen by a container (via the
+// /proc/sys it emulates), independently of the host's (see clientFeatures).
+type FsSysctlSetter interface {
+	// SetSysctls sets the values of the given sysctls (e.g.,
+	// "fs.inotify.max_user_watches") for the given container.
+	SetSysctls(id string, sysctls map[string]string) error
+}
+
+// FsNotifyQuotaSetter may be implemented by an FsClient whose sysbox-fs
+// supports per-container inotify and fanotify quotas (see
+// configs.FsNotifyQuota, and clientFeatures).
+type FsNotifyQuotaSetter interface {
+	// SetNotifyQuota sets (or replaces) the given container's quota; a nil
+	// quota removes it.
+	SetNotifyQuota(id string, quota *configs.FsNotifyQuota) error
+}
+
+// FsSwapSetter may be implemented by an FsClient whose sysbox-fs supports
+// showing a container's swap limits in its /proc/swaps (rather than the
+// host's swap; see clientFeatures).
+type FsSwapSetter interface {
+	// SetSwapLimits sets (or replaces) the given container's swap limits; nil
+	// limits remove them.
+	SetSwapLimits(id string, limits *FsSwapLimits) error
+}
+
+// grpcFsClient is the default FsClient; it talks to the sysbox-fs daemon over
+// gRPC (and over a unix socket for the seccomp tracer).
+type grpcFsClient struct{}
+
+func (grpcFsClient) GetMountpoint() (string, error) {
+	return sysboxFsGrpc.GetMountpoint()
+}
+
+func (grpcFsClient) PreRegister(data *sysboxFsGrpc.ContainerData) error {
+	return sysboxFsGrpc.SendContainerPreRegistration(data)
+}
+
+func (grpcFsClient) Register(data *sysboxFsGrpc.ContainerData) error {
+	return sysboxFsGrpc.SendContainerRegistration(data)
+}
+
+func (grpcFsClient) Update(data *sysboxFsGrpc.ContainerData) error {
+	return sysboxFsGrpc.SendContainerUpdate(data)
+}
+
+func (grpcFsClient) Unregister(data *sysboxFsGrpc.ContainerData) error {
+	return sysboxFsGrpc.SendContainerUnregistration(data)
+}
+
+func (grpcFsClient) SendSeccompInit(pid int32, id string, seccompFd int32) error {
+
+	// TODO: Think about a better location for this one.
+	const seccompTracerSockAddr = "/run/sysbox/sysfs-seccomp.sock"
+
+	conn, err := unixIpc.Connect(seccompTracerSockAddr)
+	if err != nil {
+		return newIPCError(ErrFs, err, "Unable to establish connection with seccomp-tracer")
+	}
+
+	if err = unixIpc.SendSeccompInitMsg(conn, pid, id, seccompFd); err != nil {
+		return newIPCError(ErrFs, err, "Unable to send message to seccomp-tracer")
+	}
+
+	if err = unixIpc.RecvSeccompInitAckMsg(conn); err != nil {
+		return newIPCError(ErrFs, err, "Unable to receive expected seccomp-notif-ack message")
+	}
+
+	return nil
 }
 
 type Fs struct {
 	Active     bool
-	Id         string // container-id
-	PreReg     bool   // indicates if the container was pre-registered with sysbox-fs
-	Reg        bool   // indicates if sys container was registered with sysbox-fs
-	Mountpoint string // sysbox-fs FUSE mountpoint
+	Id         string   // container-id
+	PreReg     bool     // indicates if the container was pre-registered with sysbox-fs
+	Reg        bool     // indicates if sys container was registered with sysbox-fs
+	Mountpoint string   // sysbox-fs FUSE mountpoint
+	Quiesced   bool     // indicates if sysbox-fs was quiesced for the container (see Quiesce())
+	client     FsClient // nil means the default (gRPC) client
+
+	// sysbox-fs version & features (see Negotiate())
+	Caps *Capabilities `json:"caps,omitempty"`
 }
 
 func NewFs(id string, enable bool) *Fs {
@@ -53,15 +183,39 @@ func NewFs(id string, enable bool) *Fs {
 	}
 }
 
+// NewFsWithClient returns an enabled Fs that talks to sysbox-fs through the
+// given client.
+func NewFsWithClient(id string, client FsClient) *Fs {
+	fs := NewFs(id, true)
+	fs.client = client
+	return fs
+}
+
 func (fs *Fs) Enabled() bool {
 	return fs.Active
 }
 
+// ipc returns the client used to talk to sysbox-fs; an Fs loaded from the
+// container's state has no client set, so it uses the default one.
+func (fs *Fs) ipc() FsClient {
+	if fs.client == nil {
+		return grpcFsClient{}
+	}
+	return fs.client
+}
+
 func (fs *Fs) GetConfig() error {
 
-	mp, err := sysboxFsGrpc.GetMountpoint()
+	// without this feature, the default mountpoint is used (see
+	// syscont.SysboxFsMounts())
+	if !fs.Caps.Has(FeatFsMountpoint) {
+		logrus.Debugf("sysbox-fs %s lacks feature %q; assuming the default mountpoint", fs.Caps.Version, FeatFsMountpoint)
+		return nil
+	}
+
+	mp, err := fs.ipc().GetMountpoint()
 	if err != nil {
-		return fmt.Errorf("failed to get config from sysbox-fs: %v", err)
+		return newIPCError(ErrFs, err, "failed to get config from sysbox-fs")
 	}
 
 	fs.Mountpoint = mp
@@ -88,8 +242,8 @@ func (fs *Fs) PreRegister(linuxNamespaces []specs.LinuxNamespace) error {
 		}
 	}
 
-	if err := sysboxFsGrpc.SendContainerPreRegistration(data); err != nil {
-		return fmt.Errorf("failed to pre-register with sysbox-fs: %v", err)
+	if err := fs.ipc().PreRegister(data); err != nil {
+		return newIPCError(ErrFs, err, "failed to pre-register with sysbox-fs")
 	}
 
 	fs.PreReg = true
@@ -120,12 +274,91 @@ func (fs *Fs) Register(info *FsRegInfo) error {
 		ProcMaskPaths: info.ProcMaskPaths,
 	}
 
-	if err := sysboxFsGrpc.SendContainerRegistration(data); err != nil {
-		return fmt.Errorf("failed to register with sysbox-fs: %v", err)
+	if err := fs.ipc().Register(data); err != nil {
+		return newIPCError(ErrFs, err, "failed to register with sysbox-fs")
 	}
 
 	fs.Reg = true
 
+	// the quota and swap limits aren't part of the registration data
+	// sysbox-fs takes, so they're sent right after
+	if info.NotifyQuota != nil {
+		if err := fs.SetNotifyQuota(info.NotifyQuota); err != nil {
+			return err
+		}
+	}
+	if info.SwapLimits != nil {
+		if err := fs.SetSwapLimits(info.SwapLimits); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetNotifyQuota sets the registered container's inotify and fanotify quota
+// in sysbox-fs (see FsNotifyQuotaSetter); a nil quota removes it. Fails if
+// sysbox-fs (or its client) doesn't support it.
+func (fs *Fs) SetNotifyQuota(quota *configs.FsNotifyQuota) error {
+	if !fs.Reg {
+		return fmt.Errorf("must register container %v before", fs.Id)
+	}
+	if err := fs.Caps.check(ErrFs, "sysbox-fs", FeatFsNotifyQuota); err != nil {
+		return err
+	}
+	s, ok := fs.ipc().(FsNotifyQuotaSetter)
+	if !ok {
+		return newError(ErrFs, "sysbox-fs client can't set inotify/fanotify quotas")
+	}
+	if err := s.SetNotifyQuota(fs.Id, quota); err != nil {
+		return newIPCError(ErrFs, err, "failed to set the inotify/fanotify quota in sysbox-fs")
+	}
+	return nil
+}
+
+// SetSwapLimits sets the registered container's swap limits in sysbox-fs (see
+// FsSwapSetter); nil limits remove them. If sysbox-fs (or its client) doesn't
+// support it, it's skipped, and the container's /proc/swaps shows the host's
+// swap (as before).
+func (fs *Fs) SetSwapLimits(limits *FsSwapLimits) error {
+	if !fs.Reg {
+		return fmt.Errorf("must register container %v before", fs.Id)
+	}
+	if !fs.Caps.Has(FeatFsSwapLimits) {
+		logrus.Debugf("sysbox-fs %s lacks feature %q; not setting swap limits", fs.Caps.Version, FeatFsSwapLimits)
+		return nil
+	}
+	s, ok := fs.ipc().(FsSwapSetter)
+	if !ok {
+		logrus.Debugf("sysbox-fs client can't set swap limits; not setting them")
+		return nil
+	}
+	if err := s.SetSwapLimits(fs.Id, limits); err != nil {
+		return newIPCError(ErrFs, err, "failed to set swap limits in sysbox-fs")
+	}
+	return nil
+}
+
+// SetSysctls sets the values of the given non-namespaced sysctls as seen by the
+// registered container (see FsSysctlSetter). Fails if sysbox-fs (or its
+// client) doesn't support it.
+func (fs *Fs) SetSysctls(sysctls map[string]string) error {
+	if len(sysctls) == 0 {
+		return nil
+	}
+	if !fs.Reg {
+		return fmt.Errorf("must register container %v before", fs.Id)
+	}
+	if err := fs.CheckFeature(FeatFsSysctls); err != nil {
+		return err
+	}
+	s, ok := fs.ipc().(FsSysctlSetter)
+	if !ok {
+		return newError(ErrFs, "sysbox-fs client can't set sysctls")
+	}
+	if err := s.SetSysctls(fs.Id, sysctls); err != nil {
+		return newIPCError(ErrFs, err, "failed to set sysctls in sysbox-fs")
+	}
 	return nil
 }
 
@@ -138,8 +371,8 @@ func (fs *Fs) SendCreationTime(t time.Time) error {
 		Id:    fs.Id,
 		Ctime: t,
 	}
-	if err := sysboxFsGrpc.SendContainerUpdate(data); err != nil {
-		return fmt.Errorf("failed to send creation time to sysbox-fs: %v", err)
+	if err := fs.ipc().Update(data); err != nil {
+		return newIPCError(ErrFs, err, "failed to send creation time to sysbox-fs")
 	}
 	return nil
 }
@@ -147,23 +380,46 @@ func (fs *Fs) SendCreationTime(t time.Time) error {
 // Sends the seccomp-notification fd to sysbox-fs (tracer) to setup syscall
 // trapping and waits for its response (ack).
 func (fs *Fs) SendSeccompInit(pid int, id string, seccompFd int32) error {
-
-	// TODO: Think about a better location for this one.
-	const seccompTracerSockAddr = "/run/sysbox/sysfs-seccomp.sock"
-
-	conn, err := unixIpc.Connect(seccompTracerSockAddr)
-	if err != nil {
-		return fmt.Errorf("Unable to establish connection with seccomp-tracer: %v\n", err)
+	if err := fs.Caps.check(ErrFs, "sysbox-fs", FeatFsSeccompTracer); err != nil {
+		return err
 	}
+	return fs.ipc().SendSeccompInit(int32(pid), id, seccompFd)
+}
 
-	if err = unixIpc.SendSeccompInitMsg(conn, int32(pid), id, seccompFd); err != nil {
-		return fmt.Errorf("Unable to send message to seccomp-tracer: %v\n", err)
+// Quiesce quiesces sysbox-fs' handling of the container's syscall traps (see
+// FsQuiescer), before the container is frozen. It's skipped if sysbox-fs (or
+// its client) doesn't support it; the Quiesced field tells if it was done.
+func (fs *Fs) Quiesce() error {
+	if !fs.Caps.Has(FeatFsQuiesce) {
+		logrus.Debugf("sysbox-fs %s lacks feature %q; not quiescing it", fs.Caps.Version, FeatFsQuiesce)
+		return nil
 	}
-
-	if err = unixIpc.RecvSeccompInitAckMsg(conn); err != nil {
-		return fmt.Errorf("Unable to receive expected seccomp-notif-ack message: %v\n", err)
+	q, ok := fs.ipc().(FsQuiescer)
+	if !ok {
+		logrus.Debugf("sysbox-fs client can't quiesce sysbox-fs; not quiescing it")
+		return nil
 	}
+	if err := q.Quiesce(fs.Id); err != nil {
+		return newIPCError(ErrFs, err, "failed to quiesce sysbox-fs")
+	}
+	fs.Quiesced = true
+	return nil
+}
 
+// Unquiesce undoes Quiesce(), before the container is thawed; it's a no-op if
+// sysbox-fs wasn't quiesced.
+func (fs *Fs) Unquiesce() error {
+	if !fs.Quiesced {
+		return nil
+	}
+	q, ok := fs.ipc().(FsQuiescer)
+	if !ok {
+		return newError(ErrFs, "sysbox-fs client can't unquiesce sysbox-fs")
+	}
+	if err := q.Unquiesce(fs.Id); err != nil {
+		return newIPCError(ErrFs, err, "failed to unquiesce sysbox-fs")
+	}
+	fs.Quiesced = false
 	return nil
 }
 
@@ -173,8 +429,8 @@ func (fs *Fs) Unregister() error {
 		data := &sysboxFsGrpc.ContainerData{
 			Id: fs.Id,
 		}
-		if err := sysboxFsGrpc.SendContainerUnregistration(data); err != nil {
-			return fmt.Errorf("failed to unregister with sysbox-fs: %v", err)
+		if err := fs.ipc().Unregister(data); err != nil {
+			return newIPCError(ErrFs, err, "failed to unregister with sysbox-fs")
 		}
 		fs.PreReg = false
 		fs.Reg = false
@@ -20,17 +20,245 @@ package sysbox
 
 import (
 	"fmt"
+	"os"
+	"syscall"
 
 	"github.com/nestybox/sysbox-ipc/sysboxMgrGrpc"
 	ipcLib "github.com/nestybox/sysbox-ipc/sysboxMgrLib"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
 )
 
+// MgrClient is the interface through which Mgr talks to sysbox-mgr. Programs
+// embedding sysbox-runc may provide their own implementation (e.g., a fake
+// sysbox-mgr for testing) via NewMgrWithClient().
+type MgrClient interface {
+	Register(regInfo *ipcLib.RegistrationInfo) (*ipcLib.ContainerConfig, error)
+	Update(updateInfo *ipcLib.UpdateInfo) error
+	Unregister(id string) error
+	SubidAlloc(id string, size uint64) (uint32, uint32, error)
+	PrepMounts(id string, uid, gid uint32, prepList []ipcLib.MountPrepInfo) error
+	ReqMounts(id, rootfs string, uid, gid uint32, shiftUids bool, reqList []ipcLib.MountReqInfo) ([]specs.Mount, error)
+	ReqShiftfsMark(id string, mounts []configs.ShiftfsMount) ([]configs.ShiftfsMount, error)
+	ReqFsState(id, rootfs string) ([]configs.FsEntry, error)
+	Pause(id string) error
+}
+
+// SharedMountVerifier may be implemented by a MgrClient to have sysbox-mgr
+// verify that the sources of a container's shared mounts are immutable (see
+// syscont.SharedMountsAnnotation); e.g., sysbox-mgr may check the contents of
+// the host dirs against the digests it recorded when they were populated.
+// With clients that don't implement it (such as the default gRPC client),
+// sysbox-runc checks the sources itself (see CheckSharedMountSource()).
+type SharedMountVerifier interface {
+	VerifySharedMounts(id string, sources []string) error
+}
+
+// MgrVolume is a volume of a container (see syscont.VolumesAnnotation): a dir
+// in the container backed by a host dir that sysbox-mgr manages, owned by the
+// container's root user.
+type MgrVolume struct {
+	// Path of the volume in the container
+	Dest string `json:"dest"`
+
+	// Max size of the volume's contents (in bytes); 0 means no limit.
+	Size int64 `json:"size,omitempty"`
+
+	// Keep the volume's host dir when the container is removed, for the next
+	// container with the same id (e.g., a restarted one).
+	Persist bool `json:"persist,omitempty"`
+
+	// Backing store for the volume's host dir (see NativeMountRequester);
+	// empty means a plain dir.
+	BackingStore BackingStore `json:"backingStore,omitempty"`
+}
+
+// VolumeProvider may be implemented by a MgrClient whose sysbox-mgr supports
+// volumes on arbitrary container paths (besides its special dirs, such as
+// /var/lib/docker; see clientFeatures).
+type VolumeProvider interface {
+	// ReqVolumes sets up the host dirs backing the given volumes (as
+	// ReqMounts() does for the special dirs), and returns their mounts.
+	ReqVolumes(id, rootfs string, uid, gid uint32, shiftUids bool, vols []MgrVolume) ([]specs.Mount, error)
+}
+
+// FileCopier may be implemented by a MgrClient whose sysbox-mgr supports
+// per-container copies of host files (e.g., the /etc/resolv.conf a container
+// engine bind-mounts into containers), so that they can be owned by the
+// container's root user (see clientFeatures).
+type FileCopier interface {
+	// CopyFiles copies the given host files into files owned by the given
+	// uid & gid, which are removed along with the container, and returns their
+	// paths (in the same order).
+	CopyFiles(id string, uid, gid uint32, srcs []string) ([]string, error)
+}
+
+// AsyncReleaser may be implemented by a MgrClient whose sysbox-mgr can release
+// a container's resources in the background (e.g., copy back and remove the
+// host dirs backing its special mounts, which may take minutes for a large
+// /var/lib/docker), so that deleting the container doesn't wait for it (see
+// clientFeatures).
+type AsyncReleaser interface {
+	// UnregisterAsync is like Unregister(), but returns once sysbox-mgr has
+	// taken over the release of the container's resources.
+	UnregisterAsync(id string) error
+}
+
+// RootfsShifter may be implemented by a MgrClient whose sysbox-mgr can complete
+// the lazy uid shift of a container's rootfs in the background (see
+// idshift.ShiftTreeLive()), so that the shift is tracked along with the
+// container's other resources (e.g., it's stopped when the container is
+// unregistered, and resumed if sysbox-mgr restarts) rather than left to a
+// detached process (see clientFeatures).
+type RootfsShifter interface {
+	// ShiftRootfs returns once sysbox-mgr has taken over the shift of the
+	// given rootfs, such that its ids [0, uidSize) and [0, gidSize) map to
+	// [uidTo, uidTo+uidSize) and [gidTo, gidTo+gidSize) respectively.
+	ShiftRootfs(id, rootfs string, uidTo, gidTo, uidSize, gidSize uint32) error
+}
+
+// NativeMountReqInfo is a request for a special mount (see
+// MgrClient.ReqMounts()), along with the backing store sysbox-mgr should
+// create for it.
+type NativeMountReqInfo struct {
+	ipcLib.MountReqInfo
+
+	// Backing store for the mount's host dir; BackingStoreDir means a plain
+	// dir, as with ReqMounts().
+	BackingStore BackingStore
+}
+
+// NativeMountRequester may be implemented by a MgrClient whose sysbox-mgr can
+// back the container's special dirs with a dedicated btrfs subvolume or zfs
+// dataset when its data dir is on such a filesystem, so that the container's
+// inner Docker can use its native (btrfs or zfs) storage driver rather than
+// overlayfs (see clientFeatures).
+type NativeMountRequester interface {
+	// ReqNativeMounts is like ReqMounts(), but creates the requested backing
+	// stores for the mounts.
+	ReqNativeMounts(id, rootfs string, uid, gid uint32, shiftUids bool, reqList []NativeMountReqInfo) ([]specs.Mount, error)
+}
+
+// MountReleaser may be implemented by a MgrClient whose sysbox-mgr can release
+// some of a container's mounts before the container is unregistered, such as
+// those hot-removed with the "umount" command (see clientFeatures).
+type MountReleaser interface {
+	// ReleaseMounts releases the container mounts at the given destinations
+	// (e.g., reverts the ownership of their sources, or removes the host dirs
+	// backing them), as sysbox-mgr does for all of them when the container is
+	// unregistered.
+	ReleaseMounts(id string, dests []string) error
+}
+
+// FingerprintedMountPrep is a mount source prep request (see
+// MgrClient.PrepMounts()), along with the fingerprint of the source (see
+// MountPrepFingerprint()).
+type FingerprintedMountPrep struct {
+	ipcLib.MountPrepInfo
+
+	// Fingerprint of the source, or empty if it couldn't be taken.
+	Fingerprint string
+}
+
+// MountPrepFingerprinter may be implemented by a MgrClient whose sysbox-mgr can
+// skip the recursive chown of mount sources that are already prepared, so that
+// repeated starts of containers using the same source (e.g., a large volume
+// backing /var/lib/docker) with the same uid & gid don't chown it every time
+// (see clientFeatures).
+type MountPrepFingerprinter interface {
+	// PrepMountsFingerprinted is like PrepMounts(), but sysbox-mgr skips the
+	// chown of each source whose fingerprint matches the one it recorded
+	// (with MountPrepFingerprint()) right after it last prepared the source
+	// for the same uid & gid. Either way, sysbox-mgr records the container's
+	// use of the source, so that it reverts it when the container is
+	// unregistered.
+	PrepMountsFingerprinted(id string, uid, gid uint32, prepList []FingerprintedMountPrep) error
+}
+
+// MountPrepFingerprint returns the fingerprint of the given mount source as
+// prepared for the given uid & gid (see MountPrepFingerprinter), or an empty
+// string if the source can't be stat'ed. It covers the identity of the source
+// dir and its ctime, which changes when the dir's ownership changes (e.g., by a
+// prep for another uid or a revert by sysbox-mgr); changes made by the host
+// deeper in the source tree are not covered, so sysbox-mgr must track them
+// itself before skipping a chown.
+func MountPrepFingerprint(source string, uid, gid uint32) string {
+	var st syscall.Stat_t
+
+	if err := syscall.Stat(source, &st); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d:%d:%d:%d", st.Dev, st.Ino, st.Ctim.Nano(), uid, gid)
+}
+
+// grpcMgrClient is the default MgrClient; it talks to the sysbox-mgr daemon over gRPC.
+type grpcMgrClient struct{}
+
+func (grpcMgrClient) Register(regInfo *ipcLib.RegistrationInfo) (*ipcLib.ContainerConfig, error) {
+	return sysboxMgrGrpc.Register(regInfo)
+}
+
+func (grpcMgrClient) Update(updateInfo *ipcLib.UpdateInfo) error {
+	return sysboxMgrGrpc.Update(updateInfo)
+}
+
+func (grpcMgrClient) Unregister(id string) error {
+	return sysboxMgrGrpc.Unregister(id)
+}
+
+func (grpcMgrClient) SubidAlloc(id string, size uint64) (uint32, uint32, error) {
+	return sysboxMgrGrpc.SubidAlloc(id, size)
+}
+
+func (grpcMgrClient) PrepMounts(id string, uid, gid uint32, prepList []ipcLib.MountPrepInfo) error {
+	return sysboxMgrGrpc.PrepMounts(id, uid, gid, prepList)
+}
+
+func (grpcMgrClient) ReqMounts(id, rootfs string, uid, gid uint32, shiftUids bool, reqList []ipcLib.MountReqInfo) ([]specs.Mount, error) {
+	return sysboxMgrGrpc.ReqMounts(id, rootfs, uid, gid, shiftUids, reqList)
+}
+
+func (grpcMgrClient) ReqShiftfsMark(id string, mounts []configs.ShiftfsMount) ([]configs.ShiftfsMount, error) {
+	return sysboxMgrGrpc.ReqShiftfsMark(id, mounts)
+}
+
+func (grpcMgrClient) ReqFsState(id, rootfs string) ([]configs.FsEntry, error) {
+	return sysboxMgrGrpc.ReqFsState(id, rootfs)
+}
+
+func (grpcMgrClient) Pause(id string) error {
+	return sysboxMgrGrpc.Pause(id)
+}
+
 type Mgr struct {
 	Active bool
 	Id     string                  // container-id
 	Config *ipcLib.ContainerConfig // sysbox-mgr mandated container config
+	client MgrClient               // nil means the default (gRPC) client
+
+	// Host dir under which sysbox-mgr keeps the dirs backing the container's
+	// special mounts (e.g., /var/lib/sysbox); if set, the disk space needed
+	// to create the container is checked before the mounts are requested
+	// (if DiskCheck is set, as the check walks the rootfs), and its
+	// filesystem decides whether the mounts get native backing stores (see
+	// NativeMountRequester).
+	DataDir   string
+	DiskCheck bool
+
+	// sysbox-mgr version & features (see Negotiate())
+	Caps *Capabilities `json:"caps,omitempty"`
+
+	// Destinations of the container mounts that sysbox-mgr set up or
+	// prepared the sources of (see HasMount()); it tracks them until the
+	// container is unregistered, so they can't be removed before unless
+	// sysbox-mgr releases them (see ReleaseMounts()).
+	Mounts []string `json:"mounts,omitempty"`
+
+	// Wait for sysbox-mgr to release the container's resources when
+	// unregistering it, even if it can do it in the background (see
+	// AsyncReleaser).
+	SyncRelease bool `json:"-"`
 }
 
 func NewMgr(id string, enable bool) *Mgr {
@@ -44,10 +272,75 @@ func NewMgr(id string, enable bool) *Mgr {
 	}
 }
 
+// NewMgrWithClient returns an enabled Mgr that talks to sysbox-mgr through the
+// given client.
+func NewMgrWithClient(id string, client MgrClient) *Mgr {
+	mgr := NewMgr(id, true)
+	mgr.client = client
+	return mgr
+}
+
 func (mgr *Mgr) Enabled() bool {
 	return mgr.Active
 }
 
+// HasMount returns true if the container mount at the given destination was
+// set up, or had its source prepared, by sysbox-mgr.
+func (mgr *Mgr) HasMount(dest string) bool {
+	for _, m := range mgr.Mounts {
+		if m == dest {
+			return true
+		}
+	}
+	return false
+}
+
+// CanReleaseMounts reports whether sysbox-mgr (and its client) can release
+// container mounts before the container is unregistered (see MountReleaser).
+func (mgr *Mgr) CanReleaseMounts() bool {
+	_, ok := mgr.ipc().(MountReleaser)
+	return ok && mgr.Caps.Has(FeatMgrMountRelease)
+}
+
+// ReleaseMounts requests sysbox-mgr to release the container mounts at the
+// given destinations (see MountReleaser), which are then no longer tracked
+// (see HasMount()); fails if sysbox-mgr (or its client) doesn't support it.
+func (mgr *Mgr) ReleaseMounts(dests []string) error {
+	if err := mgr.Caps.check(ErrMgr, "sysbox-mgr", FeatMgrMountRelease); err != nil {
+		return err
+	}
+	r, ok := mgr.ipc().(MountReleaser)
+	if !ok {
+		return newError(ErrMgr, "sysbox-mgr client doesn't support releasing mounts")
+	}
+
+	if err := r.ReleaseMounts(mgr.Id, dests); err != nil {
+		return newIPCError(ErrMgr, err, "failed to release mounts with sysbox-mgr")
+	}
+
+	released := make(map[string]bool, len(dests))
+	for _, d := range dests {
+		released[d] = true
+	}
+	mounts := []string{}
+	for _, m := range mgr.Mounts {
+		if !released[m] {
+			mounts = append(mounts, m)
+		}
+	}
+	mgr.Mounts = mounts
+	return nil
+}
+
+// ipc returns the client used to talk to sysbox-mgr; a Mgr loaded from the
+// container's state has no client set, so it uses the default one.
+func (mgr *Mgr) ipc() MgrClient {
+	if mgr.client == nil {
+		return grpcMgrClient{}
+	}
+	return mgr.client
+}
+
 // Registers the container with sysbox-mgr. If successful, stores the
 // sysbox configuration tokens for sysbox-runc in mgr.Config
 func (mgr *Mgr) Register(spec *specs.Spec) error {
@@ -71,9 +364,9 @@ func (mgr *Mgr) Register(spec *specs.Spec) error {
 		GidMappings: spec.Linux.GIDMappings,
 	}
 
-	config, err := sysboxMgrGrpc.Register(regInfo)
+	config, err := mgr.ipc().Register(regInfo)
 	if err != nil {
-		return fmt.Errorf("failed to register with sysbox-mgr: %v", err)
+		return newIPCError(ErrMgr, err, "failed to register with sysbox-mgr")
 	}
 
 	mgr.Config = config
@@ -91,68 +384,294 @@ func (mgr *Mgr) Update(userns, netns string, uidMappings, gidMappings []specs.Li
 		GidMappings: gidMappings,
 	}
 
-	if err := sysboxMgrGrpc.Update(updateInfo); err != nil {
-		return fmt.Errorf("failed to update container info with sysbox-mgr: %v", err)
+	if err := mgr.ipc().Update(updateInfo); err != nil {
+		return newIPCError(ErrMgr, err, "failed to update container info with sysbox-mgr")
 	}
 	return nil
 }
 
-// Unregisters the container with sysbox-mgr.
+// Unregisters the container with sysbox-mgr. Unless SyncRelease is set,
+// sysbox-mgr releases the container's resources in the background, if it
+// (and its client) supports it (see AsyncReleaser).
 func (mgr *Mgr) Unregister() error {
-	if err := sysboxMgrGrpc.Unregister(mgr.Id); err != nil {
-		return fmt.Errorf("failed to unregister with sysbox-mgr: %v", err)
+	if r, ok := mgr.ipc().(AsyncReleaser); ok && !mgr.SyncRelease && mgr.Caps.Has(FeatMgrAsyncRelease) {
+		if err := r.UnregisterAsync(mgr.Id); err != nil {
+			return newIPCError(ErrMgr, err, "failed to unregister with sysbox-mgr")
+		}
+		logrus.Debugf("sysbox-mgr releases the resources of container %s in the background", mgr.Id)
+		return nil
+	}
+
+	if err := mgr.ipc().Unregister(mgr.Id); err != nil {
+		return newIPCError(ErrMgr, err, "failed to unregister with sysbox-mgr")
 	}
 	return nil
 }
 
 // ReqSubid requests sysbox-mgr to allocate uid & gids for the container user-ns.
 func (mgr *Mgr) ReqSubid(size uint32) (uint32, uint32, error) {
-	uid, gid, err := sysboxMgrGrpc.SubidAlloc(mgr.Id, uint64(size))
+	uid, gid, err := mgr.ipc().SubidAlloc(mgr.Id, uint64(size))
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to request subid from sysbox-mgr: %v", err)
+		// sysbox-mgr reports running out of subids with status
+		// ResourceExhausted; any other failure is just a failed request
+		code := ErrMgr
+		if ipcErrorKind(err) == IPCExhausted {
+			code = ErrSubidExhausted
+		}
+		return 0, 0, newIPCError(code, err, "failed to request subid from sysbox-mgr")
 	}
 	return uid, gid, nil
 }
 
+// ShiftRootfs hands the completion of the lazy uid shift of the container's
+// rootfs over to sysbox-mgr, if it (and its client) supports it (see
+// RootfsShifter); it returns false if it doesn't, in which case the caller
+// must complete the shift.
+func (mgr *Mgr) ShiftRootfs(rootfs string, uidTo, gidTo, uidSize, gidSize uint32) (bool, error) {
+	s, ok := mgr.ipc().(RootfsShifter)
+	if !ok || !mgr.Caps.Has(FeatMgrRootfsShift) {
+		return false, nil
+	}
+	if err := s.ShiftRootfs(mgr.Id, rootfs, uidTo, gidTo, uidSize, gidSize); err != nil {
+		return false, newIPCError(ErrMgr, err, "failed to request the rootfs shift from sysbox-mgr")
+	}
+	return true, nil
+}
+
 // PrepMounts sends a request to sysbox-mgr for prepare the given  container mounts; all paths must be absolute.
+// The request is always sent, even for sources prepared before for the same
+// uid & gid: sysbox-mgr tracks the sources it prepares for each container (to
+// revert them when the container is unregistered). If sysbox-mgr (and its
+// client) support it, the sources' fingerprints are sent along, so that
+// sysbox-mgr skips the chown of those still prepared (see
+// MountPrepFingerprinter).
 func (mgr *Mgr) PrepMounts(uid, gid uint32, prepList []ipcLib.MountPrepInfo) error {
-	if err := sysboxMgrGrpc.PrepMounts(mgr.Id, uid, gid, prepList); err != nil {
-		return fmt.Errorf("failed to request mount source preps from sysbox-mgr: %v", err)
+	if err := mgr.Caps.check(ErrMgr, "sysbox-mgr", FeatMgrPrepMounts); err != nil {
+		return err
+	}
+
+	if f, ok := mgr.ipc().(MountPrepFingerprinter); ok && mgr.Caps.Has(FeatMgrPrepFingerprint) {
+		fpList := make([]FingerprintedMountPrep, 0, len(prepList))
+		for _, info := range prepList {
+			fpList = append(fpList, FingerprintedMountPrep{
+				MountPrepInfo: info,
+				Fingerprint:   MountPrepFingerprint(info.Source, uid, gid),
+			})
+		}
+		if err := f.PrepMountsFingerprinted(mgr.Id, uid, gid, fpList); err != nil {
+			return newIPCError(ErrMgr, err, "failed to request mount source preps from sysbox-mgr")
+		}
+		return nil
+	}
+
+	if err := mgr.ipc().PrepMounts(mgr.Id, uid, gid, prepList); err != nil {
+		return newIPCError(ErrMgr, err, "failed to request mount source preps from sysbox-mgr")
 	}
 	return nil
 }
 
 // ReqMounts sends a request to sysbox-mgr for container mounts; all paths must be absolute.
+// If sysbox-mgr's data dir is on btrfs or zfs, the mounts that benefit from it
+// (see nativeBackingKinds) get a native backing store, provided sysbox-mgr
+// supports it (see NativeMountRequester).
 func (mgr *Mgr) ReqMounts(rootfs string, uid, gid uint32, shiftUids bool, reqList []ipcLib.MountReqInfo) ([]specs.Mount, error) {
-	mounts, err := sysboxMgrGrpc.ReqMounts(mgr.Id, rootfs, uid, gid, shiftUids, reqList)
+	if nativeList := mgr.nativeMountReqs(reqList); nativeList != nil {
+		r := mgr.ipc().(NativeMountRequester)
+		mounts, err := r.ReqNativeMounts(mgr.Id, rootfs, uid, gid, shiftUids, nativeList)
+		if err != nil {
+			return nil, newIPCError(ErrMgr, err, "failed to request mounts from sysbox-mgr")
+		}
+		return mounts, nil
+	}
+
+	mounts, err := mgr.ipc().ReqMounts(mgr.Id, rootfs, uid, gid, shiftUids, reqList)
+	if err != nil {
+		return nil, newIPCError(ErrMgr, err, "failed to request mounts from sysbox-mgr")
+	}
+	return mounts, nil
+}
+
+// nativeMountReqs returns the given mount requests along with their backing
+// stores, or nil if none needs a native one or sysbox-mgr (or its client)
+// can't set them up.
+func (mgr *Mgr) nativeMountReqs(reqList []ipcLib.MountReqInfo) []NativeMountReqInfo {
+	if mgr.DataDir == "" {
+		return nil
+	}
+
+	store, err := NativeBackingStore(mgr.DataDir)
+	if err != nil {
+		logrus.Debugf("sysbox-mgr data dir %s: %v; not requesting native backing stores", mgr.DataDir, err)
+		return nil
+	}
+	if store == BackingStoreDir {
+		return nil
+	}
+
+	nativeList := []NativeMountReqInfo{}
+	native := false
+	for _, info := range reqList {
+		req := NativeMountReqInfo{MountReqInfo: info, BackingStore: BackingStoreDir}
+		if nativeBackingKinds[info.Kind] {
+			req.BackingStore = store
+			native = true
+		}
+		nativeList = append(nativeList, req)
+	}
+	if !native {
+		return nil
+	}
+
+	if !mgr.Caps.Has(FeatMgrNativeBackingStore) {
+		logrus.Debugf("sysbox-mgr %s lacks feature %q; backing the special dirs with plain dirs", mgr.Caps.Version, FeatMgrNativeBackingStore)
+		return nil
+	}
+	if _, ok := mgr.ipc().(NativeMountRequester); !ok {
+		logrus.Debugf("sysbox-mgr client doesn't support native backing stores; backing the special dirs with plain dirs")
+		return nil
+	}
+
+	return nativeList
+}
+
+// HasVolumes reports whether sysbox-mgr (and its client) support volumes (see
+// VolumeProvider).
+func (mgr *Mgr) HasVolumes() bool {
+	_, ok := mgr.ipc().(VolumeProvider)
+	return ok && mgr.Caps.Has(FeatMgrVolumes)
+}
+
+// ReqVolumes sends a request to sysbox-mgr for the given container volumes (see
+// VolumeProvider); fails if sysbox-mgr (or its client) doesn't support them.
+func (mgr *Mgr) ReqVolumes(rootfs string, uid, gid uint32, shiftUids bool, vols []MgrVolume) ([]specs.Mount, error) {
+	if err := mgr.Caps.check(ErrMgr, "sysbox-mgr", FeatMgrVolumes); err != nil {
+		return nil, err
+	}
+	for _, v := range vols {
+		if v.BackingStore != "" && v.BackingStore != BackingStoreDir {
+			if err := mgr.Caps.check(ErrMgr, "sysbox-mgr", FeatMgrNativeBackingStore); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	p, ok := mgr.ipc().(VolumeProvider)
+	if !ok {
+		return nil, newError(ErrMgr, "sysbox-mgr client doesn't support volumes")
+	}
+
+	mounts, err := p.ReqVolumes(mgr.Id, rootfs, uid, gid, shiftUids, vols)
 	if err != nil {
-		return nil, fmt.Errorf("failed to request mounts from sysbox-mgr: %v", err)
+		return nil, newIPCError(ErrMgr, err, "failed to request volumes from sysbox-mgr")
 	}
 	return mounts, nil
 }
 
+// CopyFiles requests sysbox-mgr per-container copies of the given host files
+// (see FileCopier); fails if sysbox-mgr (or its client) doesn't support them.
+func (mgr *Mgr) CopyFiles(uid, gid uint32, srcs []string) ([]string, error) {
+	if err := mgr.Caps.check(ErrMgr, "sysbox-mgr", FeatMgrFileCopies); err != nil {
+		return nil, err
+	}
+	c, ok := mgr.ipc().(FileCopier)
+	if !ok {
+		return nil, newError(ErrMgr, "sysbox-mgr client doesn't support file copies")
+	}
+
+	copies, err := c.CopyFiles(mgr.Id, uid, gid, srcs)
+	if err != nil {
+		return nil, newIPCError(ErrMgr, err, "failed to request file copies from sysbox-mgr")
+	}
+	if len(copies) != len(srcs) {
+		return nil, newError(ErrMgr, "sysbox-mgr returned %d file copies for %d files", len(copies), len(srcs))
+	}
+	return copies, nil
+}
+
 // ReqShiftfsMark sends a request to sysbox-mgr to mark shiftfs on the given dirs; all paths must be absolute.
 func (mgr *Mgr) ReqShiftfsMark(mounts []configs.ShiftfsMount) ([]configs.ShiftfsMount, error) {
-	resp, err := sysboxMgrGrpc.ReqShiftfsMark(mgr.Id, mounts)
+	if err := mgr.Caps.check(ErrMgr, "sysbox-mgr", FeatMgrShiftfsMark); err != nil {
+		return nil, err
+	}
+
+	resp, err := mgr.ipc().ReqShiftfsMark(mgr.Id, mounts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to request shiftfs marking to sysbox-mgr: %v", err)
+		return nil, newIPCError(ErrMgr, err, "failed to request shiftfs marking to sysbox-mgr")
 	}
 	return resp, nil
 }
 
-// ReqFsState sends a request to sysbox-mgr for container's rootfs state.
+// ReqFsState sends a request to sysbox-mgr for container's rootfs state; if
+// sysbox-mgr lacks this feature, no state is returned.
 func (mgr *Mgr) ReqFsState(rootfs string) ([]configs.FsEntry, error) {
-	state, err := sysboxMgrGrpc.ReqFsState(mgr.Id, rootfs)
+	if !mgr.Caps.Has(FeatMgrFsState) {
+		logrus.Warnf("sysbox-mgr %s lacks feature %q; the container's rootfs state won't be set up", mgr.Caps.Version, FeatMgrFsState)
+		return nil, nil
+	}
+
+	state, err := mgr.ipc().ReqFsState(mgr.Id, rootfs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to request fsState from sysbox-mgr: %v", err)
+		return nil, newIPCError(ErrMgr, err, "failed to request fsState from sysbox-mgr")
 	}
 
 	return state, nil
 }
 
+// Pause notifies sysbox-mgr that the container is paused; skipped if sysbox-mgr
+// lacks this feature.
 func (mgr *Mgr) Pause() error {
-	if err := sysboxMgrGrpc.Pause(mgr.Id); err != nil {
-		return fmt.Errorf("failed to notify pause to sysbox-mgr: %v", err)
+	if !mgr.Caps.Has(FeatMgrPause) {
+		logrus.Debugf("sysbox-mgr %s lacks feature %q; not notifying the pause", mgr.Caps.Version, FeatMgrPause)
+		return nil
+	}
+	if err := mgr.ipc().Pause(mgr.Id); err != nil {
+		return newIPCError(ErrMgr, err, "failed to notify pause to sysbox-mgr")
+	}
+	return nil
+}
+
+// VerifySharedMounts checks that the given shared mount sources are immutable,
+// via sysbox-mgr if it supports it (see SharedMountVerifier), or else via
+// CheckSharedMountSource(). It's done even if sysbox-mgr is disabled.
+func (mgr *Mgr) VerifySharedMounts(sources []string) error {
+	if verifier, ok := mgr.ipc().(SharedMountVerifier); ok && mgr.Enabled() {
+		if err := verifier.VerifySharedMounts(mgr.Id, sources); err != nil {
+			return newIPCError(ErrMgr, err, "sysbox-mgr failed to verify shared mounts")
+		}
+		return nil
+	}
+
+	for _, src := range sources {
+		if err := CheckSharedMountSource(src); err != nil {
+			return newError(ErrInvalidSpec, "%v", err)
+		}
+	}
+	return nil
+}
+
+// CheckSharedMountSource checks that the given shared mount source is a dir
+// that sys containers can't modify, even if they remount it read-write: it
+// must be owned by the host's root (which is never mapped into sys
+// containers) and not writable by group or others. Only the dir itself is
+// checked (rather than the whole tree, which may be large); the host is
+// trusted to populate it accordingly.
+func CheckSharedMountSource(src string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("shared mount source: %v", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("shared mount source %s is not a directory", src)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("failed to get owner of shared mount source %s", src)
+	}
+	if st.Uid != 0 || st.Gid != 0 {
+		return fmt.Errorf("shared mount source %s must be owned by root:root (is owned by %d:%d)", src, st.Uid, st.Gid)
+	}
+	if fi.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("shared mount source %s must not be writable by group or others (mode %#o)", src, fi.Mode().Perm())
 	}
 	return nil
 }
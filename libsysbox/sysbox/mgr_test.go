@@ -0,0 +1,299 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sysbox
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	ipcLib "github.com/nestybox/sysbox-ipc/sysboxMgrLib"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// prepMountsClient is a MgrClient that records the mount preps requested.
+type prepMountsClient struct {
+	MgrClient
+	preps [][]ipcLib.MountPrepInfo
+	err   error
+}
+
+func (c *prepMountsClient) PrepMounts(id string, uid, gid uint32, prepList []ipcLib.MountPrepInfo) error {
+	c.preps = append(c.preps, prepList)
+	return c.err
+}
+
+func TestPrepMounts(t *testing.T) {
+	client := &prepMountsClient{}
+	mgr := NewMgrWithClient("c1", client)
+
+	prepList := []ipcLib.MountPrepInfo{
+		{Source: "/var/lib/docker-vol", Exclusive: true},
+		{Source: "/var/lib/cache", Exclusive: false},
+	}
+
+	// sysbox-mgr gets every request, even for sources prepared before for the
+	// same uid & gid, as it tracks them per container
+	for i := 0; i < 2; i++ {
+		if err := mgr.PrepMounts(165536, 165536, prepList); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(client.preps) != 2 {
+		t.Fatalf("want 2 prep requests, got %d", len(client.preps))
+	}
+	for _, got := range client.preps {
+		if !reflect.DeepEqual(got, prepList) {
+			t.Errorf("want prep list %+v, got %+v", prepList, got)
+		}
+	}
+
+	client.err = errors.New("chown failed")
+	if err := mgr.PrepMounts(165536, 165536, prepList); GetErrorCode(err) != ErrMgr {
+		t.Errorf("want error %s, got %v", ErrMgr, err)
+	}
+
+	// a sysbox-mgr without the feature gets no request
+	client.preps = nil
+	mgr.Caps = &Capabilities{Version: "0.1.0"}
+	if err := mgr.PrepMounts(165536, 165536, prepList); GetErrorCode(err) != ErrMgr || len(client.preps) != 0 {
+		t.Errorf("want error %s and no request, got %v (%d requests)", ErrMgr, err, len(client.preps))
+	}
+}
+
+// fingerprintClient is a MgrClient that records the fingerprinted mount preps
+// requested.
+type fingerprintClient struct {
+	prepMountsClient
+	fpPreps [][]FingerprintedMountPrep
+}
+
+func (c *fingerprintClient) PrepMountsFingerprinted(id string, uid, gid uint32, prepList []FingerprintedMountPrep) error {
+	c.fpPreps = append(c.fpPreps, prepList)
+	return c.err
+}
+
+func TestPrepMountsFingerprinted(t *testing.T) {
+	src, err := ioutil.TempDir("", "prep-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	client := &fingerprintClient{}
+	mgr := NewMgrWithClient("c1", client)
+	if err := mgr.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+
+	prepList := []ipcLib.MountPrepInfo{
+		{Source: src, Exclusive: true},
+		{Source: "/nonexistent", Exclusive: false},
+	}
+
+	// every request is still sent, with the sources' fingerprints
+	for i := 0; i < 2; i++ {
+		if err := mgr.PrepMounts(165536, 165536, prepList); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(client.fpPreps) != 2 || len(client.preps) != 0 {
+		t.Fatalf("want 2 fingerprinted prep requests, got %d (and %d plain ones)", len(client.fpPreps), len(client.preps))
+	}
+
+	want := MountPrepFingerprint(src, 165536, 165536)
+	if want == "" {
+		t.Fatalf("no fingerprint for %s", src)
+	}
+	for _, got := range client.fpPreps {
+		if got[0].Fingerprint != want || got[0].MountPrepInfo != prepList[0] {
+			t.Errorf("want prep %+v with fingerprint %q, got %+v", prepList[0], want, got[0])
+		}
+		if got[1].Fingerprint != "" {
+			t.Errorf("want no fingerprint for %s, got %q", prepList[1].Source, got[1].Fingerprint)
+		}
+	}
+
+	// the fingerprint changes with the uid & gid, and with the source's ownership
+	if MountPrepFingerprint(src, 231072, 231072) == want {
+		t.Errorf("fingerprint of %s unchanged for another uid & gid", src)
+	}
+	if os.Geteuid() == 0 {
+		time.Sleep(10 * time.Millisecond)
+		if err := os.Chown(src, 165536, 165536); err != nil {
+			t.Fatal(err)
+		}
+		if MountPrepFingerprint(src, 165536, 165536) == want {
+			t.Errorf("fingerprint of %s unchanged after chown", src)
+		}
+	}
+
+	// a sysbox-mgr without the feature gets plain requests
+	mgr.Caps = &Capabilities{Version: "0.1.0", Features: []Feature{FeatMgrPrepMounts}}
+	if err := mgr.PrepMounts(165536, 165536, prepList); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.fpPreps) != 2 || len(client.preps) != 1 {
+		t.Errorf("want 1 plain prep request, got %d (and %d fingerprinted ones)", len(client.preps), len(client.fpPreps)-2)
+	}
+}
+
+// releaseClient is a MgrClient that records the mount releases requested.
+type releaseClient struct {
+	MgrClient
+	released []string
+	err      error
+}
+
+func (c *releaseClient) ReleaseMounts(id string, dests []string) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.released = append(c.released, dests...)
+	return nil
+}
+
+func TestReleaseMounts(t *testing.T) {
+	client := &releaseClient{}
+	mgr := NewMgrWithClient("c1", client)
+	if err := mgr.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Mounts = []string{"/var/lib/docker", "/var/lib/docker/volumes", "/var/lib/k0s"}
+
+	if !mgr.CanReleaseMounts() {
+		t.Fatal("client supports mount releases, but CanReleaseMounts() is false")
+	}
+
+	// a failed release leaves the mounts tracked
+	client.err = errors.New("copy back failed")
+	if err := mgr.ReleaseMounts([]string{"/var/lib/docker"}); GetErrorCode(err) != ErrMgr {
+		t.Errorf("want error %s, got %v", ErrMgr, err)
+	}
+	if !mgr.HasMount("/var/lib/docker") {
+		t.Errorf("mount at /var/lib/docker no longer tracked after a failed release")
+	}
+
+	client.err = nil
+	dests := []string{"/var/lib/docker", "/var/lib/docker/volumes"}
+	if err := mgr.ReleaseMounts(dests); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(client.released, dests) {
+		t.Errorf("want released mounts %v, got %v", dests, client.released)
+	}
+	if want := []string{"/var/lib/k0s"}; !reflect.DeepEqual(mgr.Mounts, want) {
+		t.Errorf("want tracked mounts %v, got %v", want, mgr.Mounts)
+	}
+
+	// the default client can't release mounts
+	mgr = NewMgr("c1", true)
+	if err := mgr.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Mounts = []string{"/var/lib/docker"}
+	if mgr.CanReleaseMounts() {
+		t.Errorf("default client: CanReleaseMounts() is true")
+	}
+	if err := mgr.ReleaseMounts(mgr.Mounts); GetErrorCode(err) != ErrMgr || !mgr.HasMount("/var/lib/docker") {
+		t.Errorf("default client: want error %s and the mount still tracked, got %v (mounts %v)", ErrMgr, err, mgr.Mounts)
+	}
+}
+
+// subidClient is a MgrClient that fails subid requests with the given error.
+type subidClient struct {
+	MgrClient
+	err error
+}
+
+func (c subidClient) SubidAlloc(id string, size uint64) (uint32, uint32, error) {
+	return 0, 0, c.err
+}
+
+func TestReqSubidErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		code ErrorCode
+		kind IPCErrorKind
+	}{
+		{status.Error(codes.ResourceExhausted, "no subids left"), ErrSubidExhausted, IPCExhausted},
+		{fmt.Errorf("alloc: %w", status.Error(codes.ResourceExhausted, "no subids left")), ErrSubidExhausted, IPCExhausted},
+		{status.Error(codes.Unavailable, "connection refused"), ErrMgr, IPCTemporary},
+		{status.Error(codes.Internal, "oops"), ErrMgr, IPCFailed},
+		{syscall.ECONNREFUSED, ErrMgr, IPCTemporary},
+		{errors.New("oops"), ErrMgr, IPCFailed},
+		// the status code isn't parsed out of messages
+		{errors.New("rpc error: code = ResourceExhausted desc = no subids left"), ErrMgr, IPCFailed},
+	}
+
+	for _, test := range tests {
+		mgr := NewMgrWithClient("c1", subidClient{err: test.err})
+		_, _, err := mgr.ReqSubid(65536)
+		if code := GetErrorCode(err); code != test.code {
+			t.Errorf("%v: want error %s, got %s", test.err, test.code, code)
+		}
+		if kind := GetIPCErrorKind(err); kind != test.kind {
+			t.Errorf("%v: want kind %s, got %s", test.err, test.kind, kind)
+		}
+	}
+}
+
+// rootfsShiftClient is a MgrClient that records the rootfs shifts requested.
+type rootfsShiftClient struct {
+	MgrClient
+	rootfs []string
+	err    error
+}
+
+func (c *rootfsShiftClient) ShiftRootfs(id, rootfs string, uidTo, gidTo, uidSize, gidSize uint32) error {
+	c.rootfs = append(c.rootfs, rootfs)
+	return c.err
+}
+
+func TestShiftRootfs(t *testing.T) {
+	client := &rootfsShiftClient{}
+	mgr := NewMgrWithClient("c1", client)
+
+	handedOver, err := mgr.ShiftRootfs("/rootfs", 165536, 165536, 65536, 65536)
+	if err != nil || !handedOver || len(client.rootfs) != 1 {
+		t.Errorf("want shift handed over, got %v, %v (%d requests)", handedOver, err, len(client.rootfs))
+	}
+
+	client.err = errors.New("busy")
+	if _, err := mgr.ShiftRootfs("/rootfs", 165536, 165536, 65536, 65536); GetErrorCode(err) != ErrMgr {
+		t.Errorf("want error %s, got %v", ErrMgr, err)
+	}
+
+	// the caller shifts the rootfs if sysbox-mgr (or its client) can't
+	client.rootfs = nil
+	mgr.Caps = &Capabilities{Version: "0.1.0"}
+	handedOver, err = mgr.ShiftRootfs("/rootfs", 165536, 165536, 65536, 65536)
+	if err != nil || handedOver || len(client.rootfs) != 0 {
+		t.Errorf("want shift not handed over, got %v, %v (%d requests)", handedOver, err, len(client.rootfs))
+	}
+
+	mgr = NewMgrWithClient("c1", subidClient{})
+	if handedOver, err := mgr.ShiftRootfs("/rootfs", 165536, 165536, 65536, 65536); err != nil || handedOver {
+		t.Errorf("want shift not handed over, got %v, %v", handedOver, err)
+	}
+}
@@ -0,0 +1,46 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sysbox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Magic numbers of network filesystems (see statfs(2)); shiftfs can't be
+// mounted on top of them, and chown'ing them is usually not acceptable.
+var networkFsMagic = map[int64]string{
+	0x6969:     "nfs",
+	0x517B:     "smb",
+	0xFF534D42: "cifs",
+	0xFE534D42: "smb2",
+	0x00c36400: "ceph",
+	0x01021997: "9p",
+	0x6B414653: "afs",
+	0x65735546: "fuse",
+}
+
+// NetworkFs returns the type of the network filesystem the given path is on,
+// or an empty string if it's not on a network filesystem.
+func NetworkFs(path string) (string, error) {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", err
+	}
+
+	return networkFsMagic[int64(st.Type)], nil
+}
@@ -0,0 +1,47 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sysbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNetworkFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// /proc is never a network filesystem
+	if fsType, err := NetworkFs("/proc"); err != nil || fsType != "" {
+		t.Errorf("/proc: want no network filesystem, got %q (err: %v)", fsType, err)
+	}
+
+	if _, err := NetworkFs(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for a missing path")
+	}
+
+	if fsType, err := NetworkFs(dir); err != nil {
+		t.Error(err)
+	} else if fsType != "" {
+		t.Logf("%s is on network filesystem %s", dir, fsType)
+	}
+}
@@ -19,16 +19,19 @@ package sysbox
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 	"unsafe"
 
+	"github.com/moby/sys/mountinfo"
 	libutils "github.com/nestybox/sysbox-libs/utils"
+	"github.com/opencontainers/runc/libsysbox/idshift"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
-	"github.com/urfave/cli"
 )
 
 // The min supported kernel release is chosen based on whether it contains all kernel
@@ -81,7 +84,7 @@ func checkUnprivilegedUserns() error {
 		}
 
 		if val != 1 {
-			return fmt.Errorf("kernel is not configured to allow unprivileged users to create namespaces: %s: want 1, have %d",
+			return newError(ErrUsernsDisabled, "kernel is not configured to allow unprivileged users to create namespaces: %s: want 1, have %d",
 				path, val)
 		}
 	}
@@ -94,7 +97,7 @@ func checkUnprivilegedUserns() error {
 	}
 
 	if val == 0 {
-		return fmt.Errorf("kernel is not configured to allow unprivileged users to create namespaces: %s: want >= 1, have %d",
+		return newError(ErrUsernsDisabled, "kernel is not configured to allow unprivileged users to create namespaces: %s: want >= 1, have %d",
 			path, val)
 	}
 
@@ -144,7 +147,7 @@ func checkKernelVersion(distro string) error {
 	if !supported {
 		s := []string{strconv.Itoa(kmaj), strconv.Itoa(kmin)}
 		kver := strings.Join(s, ".")
-		return fmt.Errorf("%s kernel release %v is not supported; need >= %v", distro, rel, kver)
+		return newError(ErrKernelUnsupported, "%s kernel release %v is not supported; need >= %v", distro, rel, kver)
 	}
 
 	return nil
@@ -170,9 +173,22 @@ func needUidShiftOnRootfs(spec *specs.Spec) (bool, error) {
 		}
 	}
 
-	// find the rootfs owner
 	rootfs := spec.Root.Path
 
+	// If sysbox-runc shifted the rootfs ownership previously (see the idshift
+	// package), shifting is needed if that shift is incomplete or is for
+	// another id range.
+	marker, err := idshift.ReadMarker(rootfs)
+	if err != nil {
+		return false, err
+	}
+	if marker != nil {
+		shifted := marker.Done && marker.Shift.UidTo == hostUidMap && marker.Shift.GidTo == hostGidMap
+		return !shifted, nil
+	}
+
+	// find the rootfs owner
+
 	fi, err := os.Stat(rootfs)
 	if err != nil {
 		return false, err
@@ -196,6 +212,93 @@ func needUidShiftOnRootfs(spec *specs.Spec) (bool, error) {
 	return false, nil
 }
 
+// checkPremountedRootfs checks that the container's pre-mounted rootfs is a
+// mount point and that its ownership matches the container's ID mappings: the
+// rootfs must be owned by the container's root user, and the entries at its
+// top level by IDs within the container's ranges. Otherwise, the returned
+// error lists each mismatching path along with its owner and the expected one.
+func checkPremountedRootfs(spec *specs.Spec) error {
+	rootfs, err := filepath.Abs(spec.Root.Path)
+	if err != nil {
+		return err
+	}
+
+	mounted, err := mountinfo.Mounted(rootfs)
+	if err != nil {
+		return fmt.Errorf("failed to check pre-mounted rootfs %s: %v", rootfs, err)
+	}
+	if !mounted {
+		return fmt.Errorf("pre-mounted rootfs %s is not a mount point", rootfs)
+	}
+
+	uidMap, gidMap := spec.Linux.UIDMappings, spec.Linux.GIDMappings
+	rootUid, uidOk := hostIDOf(uidMap, 0)
+	rootGid, gidOk := hostIDOf(gidMap, 0)
+	if !uidOk || !gidOk {
+		return fmt.Errorf("the container's ID mappings lack the root user/group")
+	}
+
+	report := []string{}
+
+	fi, err := os.Lstat(rootfs)
+	if err != nil {
+		return err
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Uid != rootUid || st.Gid != rootGid {
+		report = append(report, fmt.Sprintf("  %s: owner %d:%d, expected %d:%d", rootfs, st.Uid, st.Gid, rootUid, rootGid))
+	}
+
+	entries, err := ioutil.ReadDir(rootfs)
+	if err != nil {
+		return err
+	}
+	for _, fi := range entries {
+		st := fi.Sys().(*syscall.Stat_t)
+		if !idMapped(uidMap, st.Uid) || !idMapped(gidMap, st.Gid) {
+			report = append(report, fmt.Sprintf("  %s: owner %d:%d, expected within %s:%s",
+				filepath.Join(rootfs, fi.Name()), st.Uid, st.Gid, idRanges(uidMap), idRanges(gidMap)))
+		}
+	}
+
+	if len(report) > 0 {
+		return fmt.Errorf("ownership of pre-mounted rootfs %s does not match the container's ID mappings "+
+			"(uids %s, gids %s); is it ID-mapped to them?\n%s",
+			rootfs, idRanges(uidMap), idRanges(gidMap), strings.Join(report, "\n"))
+	}
+	return nil
+}
+
+// hostIDOf returns the host ID the given container ID maps to, if any.
+func hostIDOf(mappings []specs.LinuxIDMapping, id uint32) (uint32, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id-m.ContainerID < m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return 0, false
+}
+
+// idMapped returns true if the given host ID is within the given mappings.
+func idMapped(mappings []specs.LinuxIDMapping, id uint32) bool {
+	for _, m := range mappings {
+		if id >= m.HostID && id-m.HostID < m.Size {
+			return true
+		}
+	}
+	return false
+}
+
+// idRanges returns the host ID ranges of the given mappings (e.g.,
+// "165536-231071").
+func idRanges(mappings []specs.LinuxIDMapping) string {
+	ranges := []string{}
+	for _, m := range mappings {
+		ranges = append(ranges, fmt.Sprintf("%d-%d", m.HostID, m.HostID+m.Size-1))
+	}
+	return strings.Join(ranges, ",")
+}
+
 func hostSupportsUidShifting() bool {
 
 	// For now Sysbox requires the kernel shiftfs module for UID shifting
@@ -217,39 +320,45 @@ func hostSupportsUidShifting() bool {
 // The first return value indicates if the host supports
 // uid shifting, and the second indicates if uid shifting is
 // required for the container's rootfs. If uid shifting is not
-// supported but is required for this container, the function
-// returns an error.
-func CheckUidShifting(spec *specs.Spec) (bool, bool, error) {
+// supported but is required for this container, the rootfs
+// ownership can only be shifted via chown, if enabled (see the
+// idshift package).
+//
+// A pre-mounted rootfs (i.e., one mounted and ID-mapped by an external
+// snapshotter) is never shifted; its ownership is verified instead (see
+// checkPremountedRootfs()).
+func CheckUidShifting(spec *specs.Spec, rootfsPremounted bool) (bool, bool, error) {
 
 	uidShiftSupported := hostSupportsUidShifting()
 
+	if rootfsPremounted {
+		if err := checkPremountedRootfs(spec); err != nil {
+			return false, false, err
+		}
+		return uidShiftSupported, false, nil
+	}
+
 	uidShiftRootfs, err := needUidShiftOnRootfs(spec)
 	if err != nil {
 		return false, false, fmt.Errorf("failed to check uid shifting requirement on rootfs: %s", err)
 	}
 
-	if !uidShiftSupported && uidShiftRootfs {
-		return false, false, fmt.Errorf("this container requires user-ID shifting but the kernel does not support it." +
-			" Upgrade your kernel to include the shiftfs module, or alternatively enable Linux user-namespace" +
-			" support in the the container manager (e.g., Docker userns-remap, CRI-O userns annotation, etc)." +
-			" Refer to the Sysbox troubleshooting guide for more info.")
-	}
-
 	return uidShiftSupported, uidShiftRootfs, nil
 }
 
 // CheckHostConfig checks if the host is configured appropriately to run a
-// container with sysbox
-func CheckHostConfig(context *cli.Context, spec *specs.Spec) error {
+// container with sysbox; the kernel version check is skipped if kernelCheck is
+// false.
+func CheckHostConfig(spec *specs.Spec, kernelCheck bool) error {
 
 	distro, err := libutils.GetDistro()
 	if err != nil {
 		return err
 	}
 
-	if !context.GlobalBool("no-kernel-check") {
+	if kernelCheck {
 		if err := checkKernelVersion(distro); err != nil {
-			return fmt.Errorf("kernel version check failed: %v", err)
+			return newError(ErrKernelUnsupported, "kernel version check failed: %w", err)
 		}
 	}
 
@@ -0,0 +1,127 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package syscont
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	utils "github.com/nestybox/sysbox-libs/utils"
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+)
+
+// ConfigfsPassthroughAllowed checks that the host's allowlist at the given
+// path allows the given container the configfs subtrees it requests to pass
+// through, if any (see ConfigfsAnnotation). Each line of the allowlist has the
+// form:
+//
+// <subtree> [<container-id pattern>]
+//
+// which allows the matching containers (all if no pattern is given; patterns
+// are matched as per filepath.Match()) to get the host's configfs subtree.
+// Blank lines and lines starting with '#' are ignored. Without an allowlist,
+// no container may get any.
+//
+// Note that the subtrees hold secrets readable by any user: e.g., the
+// DH-HMAC-CHAP keys of the NVMe-oF hosts (nvmet/hosts/*/dhchap_key), and the
+// CHAP credentials of the iSCSI targets (target/iscsi/*/*/acls/*/auth/). The
+// host must only allow a subtree to containers that may read them.
+func ConfigfsPassthroughAllowed(path, id string, annotations map[string]string) error {
+	mode, subtrees, err := GetConfigfs(annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if mode != ConfigfsPassthrough {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &sysbox.Error{
+				Code: sysbox.ErrInvalidSpec,
+				Err:  fmt.Errorf("annotation %s: configfs passthrough allowlist %s not found", ConfigfsAnnotation, path),
+			}
+		}
+		return err
+	}
+	defer f.Close()
+
+	allowed, err := configfsAllowedSubtrees(f, id)
+	if err != nil {
+		return &sysbox.Error{
+			Code: sysbox.ErrInvalidConfig,
+			Err:  fmt.Errorf("configfs passthrough allowlist %s: %w", path, err),
+		}
+	}
+
+	for _, st := range subtrees {
+		if !utils.StringSliceContains(allowed, st) {
+			return &sysbox.Error{
+				Code: sysbox.ErrInvalidSpec,
+				Err: fmt.Errorf("annotation %s: configfs subtree %s not allowed for container %s by %s",
+					ConfigfsAnnotation, st, id, path),
+			}
+		}
+	}
+	return nil
+}
+
+// configfsAllowedSubtrees returns the configfs subtrees that the given
+// allowlist allows the given container to get.
+func configfsAllowedSubtrees(r io.Reader, id string) ([]string, error) {
+	allowed := []string{}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: too many fields", n)
+		}
+		if !utils.StringSliceContains(configfsPassthroughSubtrees, fields[0]) {
+			return nil, fmt.Errorf("line %d: invalid subtree %q (must be one of: %s)",
+				n, fields[0], strings.Join(configfsPassthroughSubtrees, ", "))
+		}
+
+		if len(fields) == 2 {
+			match, err := filepath.Match(fields[1], id)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid pattern %q: %w", n, fields[1], err)
+			}
+			if !match {
+				continue
+			}
+		}
+
+		if !utils.StringSliceContains(allowed, fields[0]) {
+			allowed = append(allowed, fields[0])
+		}
+	}
+
+	return allowed, scanner.Err()
+}
@@ -0,0 +1,95 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package syscont
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigfsAllowedSubtrees(t *testing.T) {
+	allowlist := `
+# storage targets
+nvmet
+target   san-*
+nvmet    san-1
+`
+	tests := map[string][]string{
+		"san-1": {"nvmet", "target"},
+		"web":   {"nvmet"},
+	}
+	for id, want := range tests {
+		got, err := configfsAllowedSubtrees(strings.NewReader(allowlist), id)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %v, got %v (err %v)", id, want, got, err)
+		}
+	}
+
+	for _, bad := range []string{"nvmet san extra", "usb_gadget", "nvmet san-["} {
+		if _, err := configfsAllowedSubtrees(strings.NewReader(bad), "san"); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestConfigfsPassthroughAllowed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "configfs-passthrough.allow")
+	annotations := func(val string) map[string]string {
+		return map[string]string{ConfigfsAnnotation: val}
+	}
+
+	if err := ConfigfsPassthroughAllowed(path, "c1", nil); err != nil {
+		t.Errorf("unexpected error without passthrough nor allowlist: %v", err)
+	}
+	if err := ConfigfsPassthroughAllowed(path, "c1", annotations("dummy")); err != nil {
+		t.Errorf("unexpected error for the dummy mode without allowlist: %v", err)
+	}
+	if err := ConfigfsPassthroughAllowed(path, "c1", annotations("passthrough:nvmet")); err == nil {
+		t.Errorf("expected error without allowlist")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("nvmet\ntarget san-*\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id      string
+		val     string
+		wantErr bool
+	}{
+		{"c1", "passthrough:nvmet", false},
+		{"c1", "passthrough:nvmet,target", true},
+		{"san-1", "passthrough:nvmet,target", false},
+		{"c1", "passthrough:bogus", true},
+	}
+	for _, test := range tests {
+		err := ConfigfsPassthroughAllowed(path, test.id, annotations(test.val))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s, %q: want err = %v, got %v", test.id, test.val, test.wantErr, err)
+		}
+	}
+}
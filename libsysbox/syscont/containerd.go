@@ -0,0 +1,198 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package syscont
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// ContainerdSnapshottersAnnotation lists the snapshotters used by a containerd
+// within the sys container, so that their dirs under /var/lib/containerd are
+// backed by host dirs that sysbox-mgr manages (as volumes, see
+// VolumesAnnotation), rather than written to the container's rootfs. Its value
+// is a comma separated list of:
+//
+// "overlayfs": always backed (it's one of sysbox-mgr's special dirs).
+// "native": backed by a plain host dir.
+// "btrfs", "zfs": backed by a btrfs subvolume or zfs dataset, which requires
+// sysbox-mgr's data dir to be on that filesystem.
+//
+// If not set, the snapshotters are taken from the "snapshotter" settings in
+// the container's /etc/containerd/config.toml, and those that can't be backed
+// are skipped. Requires a sysbox-mgr client that supports volumes; with the
+// default gRPC client, no snapshotter can be backed.
+const ContainerdSnapshottersAnnotation = "io.nestybox.sysbox.containerd-snapshotters"
+
+type ContainerdSnapshotter string
+
+const (
+	ContainerdOverlayfs ContainerdSnapshotter = "overlayfs"
+	ContainerdNative    ContainerdSnapshotter = "native"
+	ContainerdBtrfs     ContainerdSnapshotter = "btrfs"
+	ContainerdZfs       ContainerdSnapshotter = "zfs"
+)
+
+// containerdSnapshotterStores are the backing stores of the containerd
+// snapshotters that aren't special dirs.
+var containerdSnapshotterStores = map[ContainerdSnapshotter]sysbox.BackingStore{
+	ContainerdNative: sysbox.BackingStoreDir,
+	ContainerdBtrfs:  sysbox.BackingStoreBtrfsSubvol,
+	ContainerdZfs:    sysbox.BackingStoreZfsDataset,
+}
+
+// containerdConfig is the containerd config file in the container's rootfs.
+const containerdConfig = "/etc/containerd/config.toml"
+
+var containerdSnapshotterRe = regexp.MustCompile(`^\s*snapshotter\s*=\s*"([^"]*)"`)
+
+// containerdSnapshotterDir returns the dir of the given containerd snapshotter.
+func containerdSnapshotterDir(s ContainerdSnapshotter) string {
+	return "/var/lib/containerd/io.containerd.snapshotter.v1." + string(s)
+}
+
+// GetContainerdSnapshotters returns the containerd snapshotters given by the
+// container's annotations; nil means they aren't given.
+func GetContainerdSnapshotters(annotations map[string]string) ([]ContainerdSnapshotter, error) {
+	val := strings.TrimSpace(annotations[ContainerdSnapshottersAnnotation])
+	if val == "" {
+		return nil, nil
+	}
+
+	snapshotters := []ContainerdSnapshotter{}
+	for _, name := range strings.Split(val, ",") {
+		s := ContainerdSnapshotter(strings.TrimSpace(name))
+		if _, ok := containerdSnapshotterStores[s]; !ok && s != ContainerdOverlayfs {
+			return nil, fmt.Errorf("annotation %s: invalid snapshotter %q (must be one of: %s, %s, %s, %s)",
+				ContainerdSnapshottersAnnotation, s, ContainerdOverlayfs, ContainerdNative, ContainerdBtrfs, ContainerdZfs)
+		}
+		snapshotters = append(snapshotters, s)
+	}
+	return snapshotters, nil
+}
+
+// containerdConfigSnapshotters returns the known snapshotters set in the
+// containerd config file under the given rootfs (if any).
+func containerdConfigSnapshotters(rootfs string) ([]ContainerdSnapshotter, error) {
+	f, err := os.Open(filepath.Join(rootfs, containerdConfig))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	snapshotters := []ContainerdSnapshotter{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		match := containerdSnapshotterRe.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		s := ContainerdSnapshotter(match[1])
+		if _, ok := containerdSnapshotterStores[s]; ok || s == ContainerdOverlayfs {
+			snapshotters = append(snapshotters, s)
+		}
+	}
+	return snapshotters, scanner.Err()
+}
+
+// cfgContainerdSnapshotters adds the volumes backing the dirs of the
+// container's containerd snapshotters (see ContainerdSnapshottersAnnotation)
+// to the given ones. Snapshotter dirs at the same path as a volume or a mount
+// of the spec are left to them.
+func cfgContainerdSnapshotters(mgr *sysbox.Mgr, spec *specs.Spec, vols []sysbox.MgrVolume) ([]sysbox.MgrVolume, error) {
+	snapshotters, err := GetContainerdSnapshotters(spec.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	explicit := snapshotters != nil
+	if !explicit {
+		snapshotters, err = containerdConfigSnapshotters(spec.Root.Path)
+		if err != nil {
+			logrus.Warnf("failed to read the container's containerd config: %v; not backing its snapshotter dirs", err)
+			return vols, nil
+		}
+	}
+
+	taken := map[string]bool{}
+	for _, v := range vols {
+		taken[v.Dest] = true
+	}
+	for _, m := range spec.Mounts {
+		taken[filepath.Clean(m.Destination)] = true
+	}
+
+	for _, s := range snapshotters {
+		store, ok := containerdSnapshotterStores[s]
+		dest := containerdSnapshotterDir(s)
+		if !ok || taken[dest] {
+			continue
+		}
+
+		if reason := containerdSnapshotterUnsupported(mgr, store); reason != "" {
+			if explicit {
+				return nil, fmt.Errorf("annotation %s: can't back snapshotter %q: %s", ContainerdSnapshottersAnnotation, s, reason)
+			}
+			logrus.Warnf("can't back the dir of the container's containerd snapshotter %q: %s", s, reason)
+			continue
+		}
+
+		vol := sysbox.MgrVolume{Dest: dest}
+		if store != sysbox.BackingStoreDir {
+			vol.BackingStore = store
+		}
+		vols = append(vols, vol)
+		taken[dest] = true
+	}
+
+	return vols, nil
+}
+
+// containerdSnapshotterUnsupported returns why sysbox-mgr can't back a
+// containerd snapshotter dir with the given store, or "" if it can.
+func containerdSnapshotterUnsupported(mgr *sysbox.Mgr, store sysbox.BackingStore) string {
+	if !mgr.HasVolumes() {
+		return "sysbox-mgr doesn't support volumes"
+	}
+	if store == sysbox.BackingStoreDir {
+		return ""
+	}
+	if mgr.DataDir == "" {
+		return "sysbox-mgr's data dir is unknown"
+	}
+	dataStore, err := sysbox.NativeBackingStore(mgr.DataDir)
+	if err != nil {
+		return err.Error()
+	}
+	if dataStore != store {
+		return fmt.Sprintf("sysbox-mgr's data dir %s doesn't support %s backing stores", mgr.DataDir, store)
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package syscont
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestGetContainerdSnapshotters(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    []ContainerdSnapshotter
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"native", []ContainerdSnapshotter{ContainerdNative}, false},
+		{"overlayfs, zfs", []ContainerdSnapshotter{ContainerdOverlayfs, ContainerdZfs}, false},
+		{"devmapper", nil, true},
+		{"native,", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetContainerdSnapshotters(map[string]string{ContainerdSnapshottersAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetContainerdSnapshotters(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetContainerdSnapshotters(%q): want %v, got %v", test.val, test.want, got)
+		}
+	}
+}
+
+func TestContainerdConfigSnapshotters(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "containerdConfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	got, err := containerdConfigSnapshotters(rootfs)
+	if err != nil || len(got) != 0 {
+		t.Errorf("without config: want no snapshotters, got %v (err %v)", got, err)
+	}
+
+	config := `version = 2
+[plugins."io.containerd.grpc.v1.cri".containerd]
+  snapshotter = "native"
+# snapshotter = "zfs"
+[plugins."io.containerd.grpc.v1.cri".containerd.untrusted]
+  snapshotter = "devmapper"
+`
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc/containerd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, containerdConfig), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = containerdConfigSnapshotters(rootfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ContainerdSnapshotter{ContainerdNative}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+// volumeProviderClient is a sysbox-mgr client that only supports volumes.
+type volumeProviderClient struct {
+	sysbox.MgrClient
+}
+
+func (volumeProviderClient) ReqVolumes(id, rootfs string, uid, gid uint32, shiftUids bool, vols []sysbox.MgrVolume) ([]specs.Mount, error) {
+	return nil, nil
+}
+
+func TestCfgContainerdSnapshotters(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "containerdSnapshotters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	mgr := sysbox.NewMgrWithClient("c1", volumeProviderClient{})
+	mgr.DataDir = dataDir
+
+	store, err := sysbox.NativeBackingStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store == sysbox.BackingStoreZfsDataset {
+		t.Skip("test requires a temp dir that's not on zfs")
+	}
+
+	nativeDir := containerdSnapshotterDir(ContainerdNative)
+
+	spec := &specs.Spec{
+		Root:        &specs.Root{Path: dataDir},
+		Annotations: map[string]string{ContainerdSnapshottersAnnotation: "overlayfs,native"},
+	}
+	vols, err := cfgContainerdSnapshotters(mgr, spec, []sysbox.MgrVolume{{Dest: "/data"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []sysbox.MgrVolume{{Dest: "/data"}, {Dest: nativeDir}}; !reflect.DeepEqual(vols, want) {
+		t.Errorf("want volumes %+v, got %+v", want, vols)
+	}
+
+	// the spec's mounts take precedence
+	spec.Mounts = []specs.Mount{{Destination: nativeDir + "/", Type: "bind", Source: "/snapshots"}}
+	vols, err = cfgContainerdSnapshotters(mgr, spec, nil)
+	if err != nil || len(vols) != 0 {
+		t.Errorf("with a spec mount: want no volumes, got %+v (err %v)", vols, err)
+	}
+
+	// the data dir isn't on zfs
+	spec.Annotations[ContainerdSnapshottersAnnotation] = "zfs"
+	if _, err := cfgContainerdSnapshotters(mgr, spec, nil); err == nil {
+		t.Errorf("zfs snapshotter: want error, got none")
+	}
+
+	// but that's not an error if the snapshotter comes from the config
+	delete(spec.Annotations, ContainerdSnapshottersAnnotation)
+	if err := os.MkdirAll(filepath.Join(dataDir, "etc/containerd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dataDir, containerdConfig), []byte(`snapshotter = "zfs"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vols, err = cfgContainerdSnapshotters(mgr, spec, nil)
+	if err != nil || len(vols) != 0 {
+		t.Errorf("zfs snapshotter in config: want no volumes, got %+v (err %v)", vols, err)
+	}
+}
@@ -0,0 +1,268 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package syscont_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ipcLib "github.com/nestybox/sysbox-ipc/sysboxMgrLib"
+	"github.com/opencontainers/runc/libsysbox/spectest"
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestConvertSpecCorpus(t *testing.T) {
+	fixtures, err := spectest.Corpus()
+	if err != nil {
+		t.Fatalf("failed to read spec corpus: %v", err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatalf("spec corpus at %s is empty", spectest.CorpusDir())
+	}
+
+	for _, fx := range fixtures {
+		fx := fx
+		t.Run(fx.Name, func(t *testing.T) {
+			spectest.Check(t, fx)
+		})
+	}
+}
+
+func TestConvertSpecReproducible(t *testing.T) {
+	fixtures, err := spectest.Corpus()
+	if err != nil {
+		t.Fatalf("failed to read spec corpus: %v", err)
+	}
+
+	for _, fx := range fixtures {
+		fx := fx
+		t.Run(fx.Name, func(t *testing.T) {
+			convert := func(reverse bool) *specs.Spec {
+				spec, err := spectest.LoadSpec(fx.Input)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the engine's mount order doesn't matter
+				if reverse {
+					for i, j := 0, len(spec.Mounts)-1; i < j; i, j = i+1, j-1 {
+						spec.Mounts[i], spec.Mounts[j] = spec.Mounts[j], spec.Mounts[i]
+					}
+				}
+				if err := syscont.ConvertSpecReproducible(spec); err != nil {
+					t.Fatal(err)
+				}
+				return spec
+			}
+
+			spec := convert(false)
+
+			diffs, err := spectest.Diff(spec, convert(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diffs) > 0 {
+				t.Errorf("reproducible spec depends on the mount order:\n%s", strings.Join(diffs, "\n"))
+			}
+
+			if len(spec.Linux.UIDMappings) != 0 || len(spec.Linux.GIDMappings) != 0 {
+				t.Errorf("reproducible spec has ID mappings")
+			}
+
+			placeholders := 0
+			for _, m := range spec.Mounts {
+				if strings.HasPrefix(m.Source, syscont.SysboxFsDir) {
+					t.Errorf("reproducible spec has sysbox-fs mount source %s", m.Source)
+				}
+				if strings.HasPrefix(m.Source, syscont.SysboxFsPlaceholder+"/") {
+					placeholders++
+				}
+			}
+			if placeholders == 0 {
+				t.Errorf("reproducible spec has no sysbox-fs mounts")
+			}
+
+			// converting the spec when creating the container resolves the
+			// placeholders
+			conv, err := spectest.Convert(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fsMounts := 0
+			for _, m := range conv.Mounts {
+				if strings.HasPrefix(m.Source, syscont.SysboxFsPlaceholder) {
+					t.Errorf("converted spec has sysbox-fs mount source %s", m.Source)
+				}
+				if strings.HasPrefix(m.Source, filepath.Join(syscont.SysboxFsDir, spectest.ContainerID)+"/") {
+					fsMounts++
+				}
+			}
+			if fsMounts != placeholders {
+				t.Errorf("converted spec has %d sysbox-fs mounts, want %d", fsMounts, placeholders)
+			}
+		})
+	}
+}
+
+func TestConvertSpecJSONReproducible(t *testing.T) {
+	fixtures, err := spectest.Corpus()
+	if err != nil {
+		t.Fatalf("failed to read spec corpus: %v", err)
+	}
+
+	for _, fx := range fixtures {
+		fx := fx
+		t.Run(fx.Name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(fx.Input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			orig := append([]byte{}, data...)
+
+			opts := syscont.ConvertOpts{Reproducible: true}
+			out, err := syscont.ConvertSpecJSON(data, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := syscont.ConvertSpecJSON(data, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, again) {
+				t.Errorf("conversion isn't deterministic")
+			}
+			if !bytes.Equal(data, orig) {
+				t.Errorf("conversion changed its input")
+			}
+
+			spec, err := spectest.LoadSpec(fx.Input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := syscont.ConvertSpecReproducible(spec); err != nil {
+				t.Fatal(err)
+			}
+			var got specs.Spec
+			if err := json.Unmarshal(out, &got); err != nil {
+				t.Fatal(err)
+			}
+			diffs, err := spectest.Diff(spec, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diffs) > 0 {
+				t.Errorf("differs from ConvertSpecReproducible():\n%s", strings.Join(diffs, "\n"))
+			}
+		})
+	}
+}
+
+// specialDirsClient is a fake sysbox-mgr client that backs the special dirs
+// with dirs under /var/lib/sysbox.
+type specialDirsClient struct {
+	sysbox.MgrClient
+}
+
+func (specialDirsClient) SubidAlloc(id string, size uint64) (uint32, uint32, error) {
+	return 165536, 165536, nil
+}
+
+func (specialDirsClient) ReqMounts(id, rootfs string, uid, gid uint32, shiftUids bool, reqList []ipcLib.MountReqInfo) ([]specs.Mount, error) {
+	mounts := []specs.Mount{}
+	for _, req := range reqList {
+		mounts = append(mounts, specs.Mount{
+			Destination: req.Dest,
+			Source:      filepath.Join("/var/lib/sysbox", id, req.Dest),
+			Type:        "bind",
+			Options:     []string{"rbind", "rprivate"},
+		})
+	}
+	return mounts, nil
+}
+
+func TestConvertSpecJSONFakeMgr(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "convertSpecJSON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	fixtures, err := spectest.Corpus()
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("failed to read spec corpus: %v", err)
+	}
+	spec, err := spectest.LoadSpec(fixtures[0].Input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Root.Path = rootfs
+	// the rootfs needs uid shifting, which mustn't depend on shiftfs (see
+	// spectest.Convert())
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	spec.Annotations[syscont.UidShiftBackendsAnnotation] = spectest.UidShiftBackends
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mgr *sysbox.Mgr
+	convert := func() []byte {
+		mgr = sysbox.NewMgrWithClient("c1", specialDirsClient{})
+		opts := syscont.ConvertOpts{
+			Mgr: mgr,
+			Fs:  sysbox.NewFs("c1", true),
+		}
+		out, err := syscont.ConvertSpecJSON(data, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	out := convert()
+	if !bytes.Equal(out, convert()) {
+		t.Errorf("conversion isn't deterministic")
+	}
+
+	var got specs.Spec
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, m := range got.Mounts {
+		if m.Destination == "/var/lib/docker" {
+			found = m.Source == "/var/lib/sysbox/c1/var/lib/docker"
+		}
+	}
+	if !found {
+		t.Errorf("converted spec lacks the fake sysbox-mgr's /var/lib/docker mount")
+	}
+	if !mgr.HasMount("/var/lib/docker") {
+		t.Errorf("the fake sysbox-mgr's /var/lib/docker mount isn't recorded in the Mgr")
+	}
+	if len(got.Linux.UIDMappings) == 0 || got.Linux.UIDMappings[0].HostID != 165536 {
+		t.Errorf("converted spec lacks the fake sysbox-mgr's uid mappings: %+v", got.Linux.UIDMappings)
+	}
+}
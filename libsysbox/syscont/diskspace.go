@@ -0,0 +1,205 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package syscont
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/docker/go-units"
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// diskSpaceMargin is the fraction of the estimated disk space that's required
+// on top of it, as the estimate ignores the filesystems' overhead (e.g., for
+// metadata).
+const diskSpaceMargin = 0.1
+
+// diskNeed is the disk space needed on a filesystem to create the container.
+type diskNeed struct {
+	path  string   // a path in the filesystem
+	bytes uint64   // space needed
+	avail uint64   // space available
+	uses  []string // what the space is needed for
+}
+
+// checkDiskSpace checks that the filesystems the container's creation writes
+// to have enough free space for it, so that it fails fast rather than leaving
+// costly partial state behind (e.g., a half-chowned rootfs). As it walks the
+// rootfs, it's only done on request (see sysbox.Mgr.DiskCheck). The space
+// needed is estimated as follows:
+//
+// - If the rootfs ownership is shifted by chown'ing it (chownRootfs) and the
+// rootfs is on overlayfs (where chown copies up the files to the upper dir),
+// the size of the rootfs.
+//
+// - For each special dir that sysbox-mgr sets up under its data dir (see
+// mgrSpecialDirs), the size of the rootfs contents at that dir (e.g., the
+// inner images preloaded in the rootfs' /var/lib/docker), which sysbox-mgr
+// copies into it.
+//
+// On shortfall, it returns an error (with code sysbox.ErrNoDiskSpace) telling
+// the space needed and available on each filesystem that falls short.
+func checkDiskSpace(spec *specs.Spec, mgrDataDir string, chownRootfs bool) error {
+	rootfs, err := filepath.Abs(spec.Root.Path)
+	if err != nil {
+		return err
+	}
+
+	needs := map[string]*diskNeed{}
+
+	add := func(path string, bytes uint64, use string) error {
+		if bytes == 0 {
+			return nil
+		}
+		var st unix.Statfs_t
+		if err := unix.Statfs(path, &st); err != nil {
+			return fmt.Errorf("failed to stat filesystem of %s: %w", path, err)
+		}
+		// overlayfs reports the fsid of its upper dir's filesystem, so that
+		// needs on the same filesystem add up
+		key := fmt.Sprintf("%v", st.Fsid)
+		if st.Fsid == (unix.Fsid{}) {
+			key = path
+		}
+		n, ok := needs[key]
+		if !ok {
+			n = &diskNeed{path: path, avail: st.Bavail * uint64(st.Bsize)}
+			needs[key] = n
+		}
+		n.bytes += bytes
+		n.uses = append(n.uses, use)
+		return nil
+	}
+
+	if chownRootfs {
+		var st unix.Statfs_t
+		if err := unix.Statfs(rootfs, &st); err != nil {
+			return fmt.Errorf("failed to stat filesystem of %s: %w", rootfs, err)
+		}
+		if st.Type == unix.OVERLAYFS_SUPER_MAGIC {
+			size, err := diskUsage(rootfs)
+			if err != nil {
+				return err
+			}
+			if err := add(rootfs, size, "rootfs ownership shifting"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := os.Stat(mgrDataDir); err == nil {
+		bindMounted := map[string]bool{}
+		for _, m := range spec.Mounts {
+			if m.Type == "bind" {
+				bindMounted[filepath.Clean(m.Destination)] = true
+			}
+		}
+		for dest := range mgrSpecialDirs {
+			if bindMounted[dest] {
+				continue
+			}
+			// the dir may be a symlink in the image; resolve it in the rootfs
+			path, err := securejoin.SecureJoin(rootfs, dest)
+			if err != nil {
+				return err
+			}
+			size, err := diskUsage(path)
+			if err != nil {
+				return err
+			}
+			if err := add(mgrDataDir, size, "special dir "+dest); err != nil {
+				return err
+			}
+		}
+	} else {
+		logrus.Debugf("sysbox-mgr data dir %s: %v; not checking its disk space", mgrDataDir, err)
+	}
+
+	shortfalls := []string{}
+	for _, n := range needs {
+		need := n.bytes + uint64(float64(n.bytes)*diskSpaceMargin)
+		logrus.Debugf("disk space on the filesystem of %s: need %s, available %s", n.path,
+			units.BytesSize(float64(need)), units.BytesSize(float64(n.avail)))
+		if need <= n.avail {
+			continue
+		}
+		sort.Strings(n.uses)
+		shortfalls = append(shortfalls, fmt.Sprintf("%s: need %s (for %s), available %s (short by %s)",
+			n.path, units.BytesSize(float64(need)), strings.Join(n.uses, ", "),
+			units.BytesSize(float64(n.avail)), units.BytesSize(float64(need-n.avail))))
+	}
+
+	if len(shortfalls) > 0 {
+		sort.Strings(shortfalls)
+		return &sysbox.Error{
+			Code: sysbox.ErrNoDiskSpace,
+			Err:  fmt.Errorf("not enough disk space to create the container: %s", strings.Join(shortfalls, "; ")),
+		}
+	}
+
+	return nil
+}
+
+// diskUsage returns the disk space used by the files under the given path (0
+// if it doesn't exist), counting hard-linked files once.
+func diskUsage(path string) (uint64, error) {
+	type inode struct {
+		dev uint64
+		ino uint64
+	}
+
+	var size uint64
+	seen := map[inode]bool{}
+
+	err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		if st.Nlink > 1 && !fi.IsDir() {
+			key := inode{uint64(st.Dev), st.Ino}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+		size += uint64(st.Blocks) * 512
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the disk usage of %s: %w", path, err)
+	}
+
+	return size, nil
+}
@@ -45,11 +45,11 @@ func Example() (*specs.Spec, error) {
 			Cwd:             "/",
 			NoNewPrivileges: true,
 			Capabilities: &specs.LinuxCapabilities{
-				Bounding:    linuxCaps,
-				Permitted:   linuxCaps,
-				Inheritable: linuxCaps,
-				Ambient:     linuxCaps,
-				Effective:   linuxCaps,
+				Bounding:    append([]string{}, linuxCaps...),
+				Permitted:   append([]string{}, linuxCaps...),
+				Inheritable: append([]string{}, linuxCaps...),
+				Ambient:     append([]string{}, linuxCaps...),
+				Effective:   append([]string{}, linuxCaps...),
 			},
 			Rlimits: []specs.POSIXRlimit{
 				{
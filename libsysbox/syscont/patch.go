@@ -0,0 +1,344 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package syscont
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// patchOp is a JSON patch operation (RFC 6902)
+type patchOp struct {
+	Op    string
+	Path  string
+	From  string
+	Value json.RawMessage
+
+	// hasValue is set if the operation has a "value" member; its value may be
+	// null (e.g., to clear a field with "replace").
+	hasValue bool
+}
+
+func (op *patchOp) UnmarshalJSON(b []byte) error {
+	var members map[string]json.RawMessage
+
+	if err := json.Unmarshal(b, &members); err != nil {
+		return err
+	}
+
+	for name, dst := range map[string]*string{"op": &op.Op, "path": &op.Path, "from": &op.From} {
+		if raw, ok := members[name]; ok {
+			if err := json.Unmarshal(raw, dst); err != nil {
+				return fmt.Errorf("invalid %q member: %v", name, err)
+			}
+		}
+	}
+
+	op.Value, op.hasValue = members["value"]
+	return nil
+}
+
+// ApplySpecPatch applies the given JSON patch (RFC 6902) to the container spec
+// and returns the patched spec. The patch is applied atomically: if any of its
+// operations fails, an error is returned and the given spec is not modified.
+func ApplySpecPatch(spec *specs.Spec, patch []byte) (*specs.Spec, error) {
+	var ops []patchOp
+
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("failed to parse spec patch: %v", err)
+	}
+
+	orig, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(orig, &doc); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		doc, err = applyPatchOp(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("spec patch operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var newSpec specs.Spec
+	if err := json.Unmarshal(patched, &newSpec); err != nil {
+		return nil, fmt.Errorf("patched spec is invalid: %v", err)
+	}
+
+	return &newSpec, nil
+}
+
+func applyPatchOp(doc interface{}, op patchOp) (interface{}, error) {
+	var err error
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if !op.hasValue {
+			return nil, fmt.Errorf("missing value")
+		}
+		var val interface{}
+		if err := json.Unmarshal(op.Value, &val); err != nil {
+			return nil, err
+		}
+
+		if op.Op == "add" {
+			return patchAdd(doc, op.Path, val)
+		}
+
+		if op.Op == "replace" {
+			if doc, _, err = patchRemove(doc, op.Path); err != nil {
+				return nil, err
+			}
+			return patchAdd(doc, op.Path, val)
+		}
+
+		cur, err := patchGet(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(cur, val) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+
+	case "remove":
+		doc, _, err = patchRemove(doc, op.Path)
+		return doc, err
+
+	case "move":
+		if op.Path == op.From {
+			return doc, nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("can't move %s into one of its children", op.From)
+		}
+		var val interface{}
+		if doc, val, err = patchRemove(doc, op.From); err != nil {
+			return nil, err
+		}
+		return patchAdd(doc, op.Path, val)
+
+	case "copy":
+		val, err := patchGet(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		// deep copy the value, so that later ops don't modify both locations
+		b, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		var valCopy interface{}
+		if err := json.Unmarshal(b, &valCopy); err != nil {
+			return nil, err
+		}
+		return patchAdd(doc, op.Path, valCopy)
+	}
+
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// parsePointer splits a JSON pointer (RFC 6901) into its reference tokens.
+func parsePointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, t := range tokens {
+		t = strings.Replace(t, "~1", "/", -1)
+		tokens[i] = strings.Replace(t, "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// arrayIndex returns the array index referenced by the given token; "-"
+// (i.e., past the last element) is only valid if allowEnd is set.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if idx > max {
+		return 0, fmt.Errorf("array index %d out of bounds", idx)
+	}
+	return idx, nil
+}
+
+func patchGet(doc interface{}, path string) (interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := doc
+	for _, t := range tokens {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			val, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("path %s not found", path)
+			}
+			cur = val
+		case []interface{}:
+			idx, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			cur = node[idx]
+		default:
+			return nil, fmt.Errorf("path %s not found", path)
+		}
+	}
+
+	return cur, nil
+}
+
+// patchAdd adds the value at the given path and returns the resulting document.
+func patchAdd(doc interface{}, path string, val interface{}) (interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return val, nil
+	}
+
+	parentPath := path[:strings.LastIndex(path, "/")]
+	parent, err := patchGet(doc, parentPath)
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = val
+		return doc, nil
+	case []interface{}:
+		idx, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[idx+1:], node[idx:])
+		node[idx] = val
+		// arrays may be reallocated by append, so update the reference to it
+		return patchSet(doc, parentPath, node)
+	}
+
+	return nil, fmt.Errorf("path %s not found", parentPath)
+}
+
+// patchRemove removes the value at the given path and returns the resulting
+// document along with the removed value.
+func patchRemove(doc interface{}, path string) (interface{}, interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("can't remove the whole spec")
+	}
+
+	parentPath := path[:strings.LastIndex(path, "/")]
+	parent, err := patchGet(doc, parentPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		val, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("path %s not found", path)
+		}
+		delete(node, last)
+		return doc, val, nil
+	case []interface{}:
+		idx, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		val := node[idx]
+		node = append(node[:idx], node[idx+1:]...)
+		doc, err = patchSet(doc, parentPath, node)
+		return doc, val, err
+	}
+
+	return nil, nil, fmt.Errorf("path %s not found", path)
+}
+
+// patchSet replaces the value at the given (existing) path.
+func patchSet(doc interface{}, path string, val interface{}) (interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return val, nil
+	}
+
+	parent, err := patchGet(doc, path[:strings.LastIndex(path, "/")])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = val
+	case []interface{}:
+		idx, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[idx] = val
+	}
+
+	return doc, nil
+}
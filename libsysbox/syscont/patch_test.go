@@ -0,0 +1,141 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package syscont
+
+import (
+	"reflect"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestApplySpecPatch(t *testing.T) {
+	spec := &specs.Spec{
+		Hostname: "syscont",
+		Process: &specs.Process{
+			Args: []string{"/bin/sh"},
+			Env:  []string{"A=1", "B=2"},
+		},
+		Mounts: []specs.Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+		},
+		Annotations: map[string]string{"a/b": "x"},
+	}
+
+	patch := []byte(`[
+		{"op": "test", "path": "/hostname", "value": "syscont"},
+		{"op": "replace", "path": "/hostname", "value": "patched"},
+		{"op": "add", "path": "/process/env/1", "value": "C=3"},
+		{"op": "add", "path": "/process/env/-", "value": "D=4"},
+		{"op": "remove", "path": "/process/env/0"},
+		{"op": "add", "path": "/mounts/-", "value": {"destination": "/data", "type": "bind", "source": "/data"}},
+		{"op": "copy", "from": "/annotations/a~1b", "path": "/annotations/c"},
+		{"op": "move", "from": "/annotations/a~1b", "path": "/annotations/d"}
+	]`)
+
+	patched, err := ApplySpecPatch(spec, patch)
+	if err != nil {
+		t.Fatalf("ApplySpecPatch failed: %v", err)
+	}
+
+	if patched.Hostname != "patched" {
+		t.Errorf("hostname not patched: got %q", patched.Hostname)
+	}
+
+	wantEnv := []string{"C=3", "B=2", "D=4"}
+	if !reflect.DeepEqual(patched.Process.Env, wantEnv) {
+		t.Errorf("env not patched: want %v, got %v", wantEnv, patched.Process.Env)
+	}
+
+	if len(patched.Mounts) != 2 || patched.Mounts[1].Destination != "/data" {
+		t.Errorf("mounts not patched: got %v", patched.Mounts)
+	}
+
+	wantAnnot := map[string]string{"c": "x", "d": "x"}
+	if !reflect.DeepEqual(patched.Annotations, wantAnnot) {
+		t.Errorf("annotations not patched: want %v, got %v", wantAnnot, patched.Annotations)
+	}
+
+	// the original spec must not be modified
+	if spec.Hostname != "syscont" || len(spec.Process.Env) != 2 || len(spec.Mounts) != 1 {
+		t.Errorf("original spec was modified: %+v", spec)
+	}
+}
+
+func TestApplySpecPatchNull(t *testing.T) {
+	spec := &specs.Spec{
+		Process: &specs.Process{
+			Args: []string{"/bin/sh"},
+		},
+		Linux: &specs.Linux{
+			Seccomp: &specs.LinuxSeccomp{DefaultAction: specs.ActErrno},
+		},
+	}
+
+	patch := []byte(`[
+		{"op": "test", "path": "/linux/seccomp/defaultAction", "value": "SCMP_ACT_ERRNO"},
+		{"op": "replace", "path": "/linux/seccomp", "value": null}
+	]`)
+
+	patched, err := ApplySpecPatch(spec, patch)
+	if err != nil {
+		t.Fatalf("ApplySpecPatch failed: %v", err)
+	}
+	if patched.Linux.Seccomp != nil {
+		t.Errorf("seccomp not cleared: got %+v", patched.Linux.Seccomp)
+	}
+	if spec.Linux.Seccomp == nil {
+		t.Errorf("original spec was modified: %+v", spec)
+	}
+}
+
+func TestApplySpecPatchErrors(t *testing.T) {
+	spec := &specs.Spec{
+		Hostname: "syscont",
+		Process: &specs.Process{
+			Args: []string{"/bin/sh"},
+		},
+	}
+
+	patches := []string{
+		`{"op": "add"}`,
+		`[{"op": "bogus", "path": "/hostname"}]`,
+		`[{"op": "add", "path": "hostname", "value": "x"}]`,
+		`[{"op": "add", "path": "/hostname"}]`,
+		`[{"op": "replace", "path": "/hostname"}]`,
+		`[{"op": "add", "path": 1, "value": "x"}]`,
+		`[{"op": "replace", "path": "/domainname", "value": "x"}]`,
+		`[{"op": "remove", "path": "/process/args/1"}]`,
+		`[{"op": "add", "path": "/process/args/01", "value": "x"}]`,
+		`[{"op": "test", "path": "/hostname", "value": "other"}]`,
+		`[{"op": "move", "from": "/process", "path": "/process/foo"}]`,
+		`[{"op": "replace", "path": "/hostname", "value": 1}]`,
+		`[{"op": "add", "path": "/hostname", "value": "x"}, {"op": "remove", "path": "/nothere"}]`,
+	}
+
+	for _, p := range patches {
+		if _, err := ApplySpecPatch(spec, []byte(p)); err == nil {
+			t.Errorf("expected error for patch %s", p)
+		}
+	}
+
+	if spec.Hostname != "syscont" {
+		t.Errorf("original spec was modified: %+v", spec)
+	}
+}
@@ -0,0 +1,147 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package syscont
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// PresetAnnotation applies named groups of sysctls to the sys container, as
+// needed by some workloads. Its value is a comma separated list of:
+//
+// "k8s-node": the sysctls a (nested) Kubernetes node's kubelet and kube-proxy
+// check or set.
+//
+// The namespaced sysctls of the presets are set in the container's namespaces
+// (as those in the spec), while the values of the non-namespaced ones are set
+// by sysbox-fs, as seen by the container only; a preset with such sysctls
+// requires a sysbox-fs client that supports them (see sysbox.FsSysctlSetter),
+// which the default gRPC client doesn't. The spec's sysctls take precedence,
+// then the presets' in the order given.
+const PresetAnnotation = "io.nestybox.sysbox.preset"
+
+// sysctlPreset is a named group of sysctls.
+type sysctlPreset struct {
+	// sysctls in the container's namespaces
+	sysctls map[string]string
+
+	// non-namespaced sysctls, set by sysbox-fs
+	fsSysctls map[string]string
+}
+
+// sysctlPresets are the presets selectable via PresetAnnotation.
+var sysctlPresets = map[string]sysctlPreset{
+	"k8s-node": {
+		sysctls: map[string]string{
+			"net.ipv4.ip_forward":              "1",
+			"net.ipv6.conf.all.forwarding":     "1",
+			"net.ipv4.conf.all.route_localnet": "1",
+		},
+		fsSysctls: map[string]string{
+			"fs.inotify.max_user_watches":   "524288",
+			"fs.inotify.max_user_instances": "512",
+			"kernel.keys.maxkeys":           "20000",
+			"kernel.keys.maxbytes":          "400000",
+			// checked by the kubelet's --protect-kernel-defaults
+			"vm.overcommit_memory": "1",
+			"vm.panic_on_oom":      "0",
+			"kernel.panic":         "10",
+			"kernel.panic_on_oops": "1",
+		},
+	},
+}
+
+// PresetNames returns the names of the sysctl presets, sorted.
+func PresetNames() []string {
+	names := []string{}
+	for name := range sysctlPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getPresets returns the sysctl presets given by the container's annotations,
+// in the order given.
+func getPresets(annotations map[string]string) ([]sysctlPreset, error) {
+	val := strings.TrimSpace(annotations[PresetAnnotation])
+	if val == "" {
+		return nil, nil
+	}
+
+	presets := []sysctlPreset{}
+	for _, name := range strings.Split(val, ",") {
+		name = strings.TrimSpace(name)
+		p, ok := sysctlPresets[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid value for annotation %s: unknown preset %q (must be one of: %s)",
+				PresetAnnotation, name, strings.Join(PresetNames(), ", "))
+		}
+		presets = append(presets, p)
+	}
+	return presets, nil
+}
+
+// cfgPresets sets the namespaced sysctls of the sys container's presets (see
+// PresetAnnotation) in its spec.
+func cfgPresets(spec *specs.Spec) error {
+	presets, err := getPresets(spec.Annotations)
+	if err != nil || len(presets) == 0 {
+		return err
+	}
+
+	if spec.Linux.Sysctl == nil {
+		spec.Linux.Sysctl = make(map[string]string)
+	}
+	for _, p := range presets {
+		for key, val := range p.sysctls {
+			if _, ok := spec.Linux.Sysctl[key]; !ok {
+				spec.Linux.Sysctl[key] = val
+			}
+		}
+	}
+	return nil
+}
+
+// GetFsSysctls returns the non-namespaced sysctls of the sys container's
+// presets (see PresetAnnotation), whose values are set by sysbox-fs; nil if
+// there are none.
+func GetFsSysctls(spec *specs.Spec) (map[string]string, error) {
+	presets, err := getPresets(spec.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	var sysctls map[string]string
+	for _, p := range presets {
+		for key, val := range p.fsSysctls {
+			if sysctls == nil {
+				sysctls = make(map[string]string)
+			}
+			if _, ok := sysctls[key]; !ok {
+				sysctls[key] = val
+			}
+		}
+	}
+	return sysctls, nil
+}
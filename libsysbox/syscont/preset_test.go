@@ -0,0 +1,99 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package syscont
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func presetSpec(preset string) *specs.Spec {
+	return &specs.Spec{
+		Annotations: map[string]string{PresetAnnotation: preset},
+		Linux: &specs.Linux{
+			Sysctl: map[string]string{"net.ipv4.ip_forward": "0"},
+		},
+	}
+}
+
+func TestGetPresets(t *testing.T) {
+	if p, err := getPresets(map[string]string{}); p != nil || err != nil {
+		t.Errorf("got presets %v, err %v without the annotation", p, err)
+	}
+	if _, err := getPresets(map[string]string{PresetAnnotation: "k8s-node,k8s"}); err == nil {
+		t.Errorf("expected error for an invalid preset")
+	}
+	for _, name := range PresetNames() {
+		if p, err := getPresets(map[string]string{PresetAnnotation: " " + name + " "}); err != nil || len(p) != 1 {
+			t.Errorf("preset %s: got %v, err %v", name, p, err)
+		}
+	}
+}
+
+func TestCfgPresets(t *testing.T) {
+	spec := presetSpec("k8s-node")
+	if err := cfgPresets(spec); err != nil {
+		t.Fatal(err)
+	}
+
+	// the spec's sysctls take precedence
+	want := map[string]string{
+		"net.ipv4.ip_forward":              "0",
+		"net.ipv6.conf.all.forwarding":     "1",
+		"net.ipv4.conf.all.route_localnet": "1",
+	}
+	if !reflect.DeepEqual(spec.Linux.Sysctl, want) {
+		t.Errorf("got sysctls %v, want %v", spec.Linux.Sysctl, want)
+	}
+
+	// the non-namespaced sysctls are left to sysbox-fs
+	for key := range sysctlPresets["k8s-node"].fsSysctls {
+		if _, ok := spec.Linux.Sysctl[key]; ok {
+			t.Errorf("non-namespaced sysctl %s set in the spec", key)
+		}
+	}
+
+	spec = presetSpec("")
+	spec.Linux.Sysctl = nil
+	if err := cfgPresets(spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Linux.Sysctl != nil {
+		t.Errorf("got sysctls %v without a preset", spec.Linux.Sysctl)
+	}
+}
+
+func TestGetFsSysctls(t *testing.T) {
+	sysctls, err := GetFsSysctls(presetSpec(""))
+	if sysctls != nil || err != nil {
+		t.Errorf("got sysctls %v, err %v without a preset", sysctls, err)
+	}
+
+	if _, err := GetFsSysctls(presetSpec("none")); err == nil {
+		t.Errorf("expected error for an invalid preset")
+	}
+
+	sysctls, err = GetFsSysctls(presetSpec("k8s-node"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sysctls["fs.inotify.max_user_watches"] != "524288" || sysctls["kernel.keys.maxkeys"] == "" {
+		t.Errorf("missing preset sysctls: %v", sysctls)
+	}
+}
@@ -0,0 +1,331 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package syscont
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	utils "github.com/nestybox/sysbox-libs/utils"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// ProfileAnnotation selects a workload profile for the sys container, i.e., the
+// config that a well-known workload needs to run in it, maintained here rather
+// than in each user's container config. Its value is one of:
+//
+// "kind": a Kubernetes-in-Docker node.
+// "k3d": a k3s node, as launched by k3d.
+// "minikube": a minikube node (docker driver).
+// "dind": a Docker engine.
+// "gitlab-runner": a GitLab runner with the Docker executor (i.e., a Docker
+// engine running CI jobs).
+//
+// See workloadProfiles for what each one sets up. The profile only supplies
+// defaults: the spec's own mounts, sysctls, devices and sysbox annotations take
+// precedence over the profile's.
+const ProfileAnnotation = "io.nestybox.sysbox.profile"
+
+// workloadProfile is the config a workload needs in a sys container.
+type workloadProfile struct {
+	// the workload runs systemd (as an arg to its entrypoint, so it's not
+	// detected from the init process; see systemdInit())
+	systemd bool
+
+	// sysbox annotations defaulted by the profile
+	annotations map[string]string
+
+	// mounts added unless the spec mounts something on their destination; bind
+	// mounts whose source is missing in the host are skipped
+	mounts []specs.Mount
+
+	// sysctls defaulted by the profile
+	sysctls map[string]string
+
+	// devices exposed (if present in the host)
+	devices []specs.LinuxDevice
+
+	// syscalls allowed by the container's seccomp profile
+	syscalls []string
+}
+
+var (
+	// kube-proxy, kubelet and the CNI plugins load or look for kernel modules
+	libModulesMount = specs.Mount{
+		Destination: "/lib/modules",
+		Source:      "/lib/modules",
+		Type:        "bind",
+		Options:     []string{"rbind", "ro", "rprivate"},
+	}
+
+	// k3s doesn't run systemd, but needs a tmpfs on /run (as the k3s image
+	// symlinks /var/run to it) for its containerd and CNI state
+	runTmpfsMount = specs.Mount{
+		Destination: "/run",
+		Source:      "tmpfs",
+		Type:        "tmpfs",
+		Options:     []string{"rw", "rprivate", "nosuid", "nodev", "mode=755"},
+	}
+
+	// rootless image builders (buildah, podman) in CI jobs use fuse-overlayfs
+	fuseDevice = specs.LinuxDevice{
+		Path:     "/dev/fuse",
+		Type:     "c",
+		Major:    10,
+		Minor:    229,
+		FileMode: fileModePtr(0666),
+	}
+
+	// the nodes forward the traffic of the pods and services
+	forwardingSysctls = map[string]string{
+		"net.ipv4.ip_forward":          "1",
+		"net.ipv6.conf.all.forwarding": "1",
+	}
+
+	// syscalls used (without a fallback on EPERM) by recent glibc, systemd,
+	// containerd and runc versions in the workloads' images
+	recentSyscalls = []string{
+		"clone3",
+		"close_range",
+		"pidfd_getfd",
+		"pidfd_open",
+		"pidfd_send_signal",
+	}
+)
+
+// workloadProfiles are the profiles selectable via ProfileAnnotation.
+var workloadProfiles = map[string]workloadProfile{
+	"kind": {
+		systemd:  true,
+		mounts:   []specs.Mount{libModulesMount},
+		sysctls:  forwardingSysctls,
+		syscalls: recentSyscalls,
+	},
+	"k3d": {
+		mounts:   []specs.Mount{libModulesMount, runTmpfsMount},
+		sysctls:  forwardingSysctls,
+		syscalls: recentSyscalls,
+	},
+	"minikube": {
+		systemd:  true,
+		mounts:   []specs.Mount{libModulesMount},
+		sysctls:  forwardingSysctls,
+		syscalls: recentSyscalls,
+	},
+	"dind": {
+		mounts:   []specs.Mount{libModulesMount},
+		sysctls:  forwardingSysctls,
+		syscalls: recentSyscalls,
+	},
+	"gitlab-runner": {
+		annotations: map[string]string{
+			// CI jobs (compilers, test runners) size their thread pools by
+			// the CPU count
+			CpuCoherenceAnnotation: string(CpuCoherenceEnv),
+		},
+		mounts:   []specs.Mount{libModulesMount},
+		sysctls:  forwardingSysctls,
+		devices:  []specs.LinuxDevice{fuseDevice},
+		syscalls: recentSyscalls,
+	},
+}
+
+func fileModePtr(mode os.FileMode) *os.FileMode {
+	return &mode
+}
+
+// ProfileNames returns the names of the workload profiles, sorted.
+func ProfileNames() []string {
+	names := []string{}
+	for name := range workloadProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getProfile returns the workload profile given by the container's
+// annotations, or nil if there's none.
+func getProfile(annotations map[string]string) (*workloadProfile, error) {
+	name := strings.TrimSpace(annotations[ProfileAnnotation])
+	if name == "" {
+		return nil, nil
+	}
+	p, ok := workloadProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid value for annotation %s: %q (must be one of: %s)",
+			ProfileAnnotation, name, strings.Join(ProfileNames(), ", "))
+	}
+	return &p, nil
+}
+
+// systemdContainer returns true if the sys container runs systemd, as told by
+// its init process or its workload profile.
+func systemdContainer(spec *specs.Spec) bool {
+	if systemdInit(spec.Process) {
+		return true
+	}
+	p, err := getProfile(spec.Annotations)
+	return err == nil && p != nil && p.systemd
+}
+
+// cfgProfile applies the sys container's workload profile (if any) to its
+// spec. It must be called before the rest of the spec conversion, as it sets
+// defaults for the sysbox annotations and adds mounts. The profile's bind
+// mounts and devices depend on the host, so they're only added if hostDeps is
+// true.
+func cfgProfile(spec *specs.Spec, hostDeps bool) error {
+	p, err := getProfile(spec.Annotations)
+	if err != nil || p == nil {
+		return err
+	}
+
+	for key, val := range p.annotations {
+		if _, ok := spec.Annotations[key]; !ok {
+			spec.Annotations[key] = val
+		}
+	}
+
+	if p.systemd {
+		cfgSystemdEnv(spec.Process)
+	}
+
+	mounted := map[string]bool{}
+	for _, m := range spec.Mounts {
+		mounted[filepath.Clean(m.Destination)] = true
+	}
+	for _, m := range p.mounts {
+		if mounted[m.Destination] {
+			continue
+		}
+		if m.Type == "bind" {
+			if !hostDeps {
+				continue
+			}
+			if _, err := os.Stat(m.Source); err != nil {
+				logrus.Debugf("profile %s: skipping mount of %s (%v)", spec.Annotations[ProfileAnnotation], m.Source, err)
+				continue
+			}
+		}
+		m.Options = append([]string{}, m.Options...)
+		spec.Mounts = append(spec.Mounts, m)
+	}
+
+	if len(p.sysctls) > 0 && spec.Linux.Sysctl == nil {
+		spec.Linux.Sysctl = make(map[string]string)
+	}
+	for key, val := range p.sysctls {
+		if _, ok := spec.Linux.Sysctl[key]; !ok {
+			spec.Linux.Sysctl[key] = val
+		}
+	}
+
+	if hostDeps {
+		cfgProfileDevices(spec, p.devices)
+	}
+
+	if spec.Linux.Seccomp != nil {
+		allowSyscalls(spec.Linux.Seccomp, p.syscalls)
+	}
+
+	return nil
+}
+
+// cfgProfileDevices exposes the given devices in the sys container, unless
+// absent in the host or already in the spec.
+func cfgProfileDevices(spec *specs.Spec, devices []specs.LinuxDevice) {
+	for _, d := range devices {
+		if _, err := os.Stat(d.Path); err != nil {
+			logrus.Debugf("profile %s: not exposing device %s (%v)", spec.Annotations[ProfileAnnotation], d.Path, err)
+			continue
+		}
+
+		found := false
+		for _, sd := range spec.Linux.Devices {
+			if sd.Path == d.Path {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		spec.Linux.Devices = append(spec.Linux.Devices, d)
+
+		if spec.Linux.Resources == nil {
+			spec.Linux.Resources = &specs.LinuxResources{}
+		}
+		major, minor := d.Major, d.Minor
+		spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices, specs.LinuxDeviceCgroup{
+			Allow:  true,
+			Type:   d.Type,
+			Major:  &major,
+			Minor:  &minor,
+			Access: "rwm",
+		})
+	}
+}
+
+// allowSyscalls makes the given seccomp profile allow the given syscalls:
+// they are added to its whitelist, or removed from its blacklist.
+func allowSyscalls(seccomp *specs.LinuxSeccomp, names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	whitelist := (seccomp.DefaultAction == specs.ActErrno ||
+		seccomp.DefaultAction == specs.ActKill)
+
+	if whitelist {
+		allowed := map[string]bool{}
+		for _, sc := range seccomp.Syscalls {
+			if sc.Action == specs.ActAllow && len(sc.Args) == 0 {
+				for _, name := range sc.Names {
+					allowed[name] = true
+				}
+			}
+		}
+		for _, name := range names {
+			if !allowed[name] {
+				seccomp.Syscalls = append(seccomp.Syscalls, specs.LinuxSyscall{
+					Names:  []string{name},
+					Action: specs.ActAllow,
+				})
+				allowed[name] = true
+			}
+		}
+		return
+	}
+
+	var syscalls []specs.LinuxSyscall
+	for _, sc := range seccomp.Syscalls {
+		if sc.Action != specs.ActAllow {
+			sc.Names = utils.StringSliceRemove(sc.Names, names)
+			if len(sc.Names) == 0 {
+				continue
+			}
+		}
+		syscalls = append(syscalls, sc)
+	}
+	seccomp.Syscalls = syscalls
+}
@@ -0,0 +1,138 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package syscont
+
+import (
+	"reflect"
+	"testing"
+
+	utils "github.com/nestybox/sysbox-libs/utils"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func profileSpec(profile string) *specs.Spec {
+	return &specs.Spec{
+		Annotations: map[string]string{ProfileAnnotation: profile},
+		Process:     &specs.Process{Args: []string{"/usr/local/bin/entrypoint", "/sbin/init"}},
+		Root:        &specs.Root{Path: "rootfs"},
+		Linux: &specs.Linux{
+			Sysctl: map[string]string{"net.ipv4.ip_forward": "0"},
+			Seccomp: &specs.LinuxSeccomp{
+				DefaultAction: specs.ActErrno,
+				Architectures: []specs.Arch{specs.ArchX86_64},
+				Syscalls: []specs.LinuxSyscall{
+					{Names: []string{"read", "close_range"}, Action: specs.ActAllow},
+				},
+			},
+		},
+	}
+}
+
+func TestGetProfile(t *testing.T) {
+	if p, err := getProfile(map[string]string{}); p != nil || err != nil {
+		t.Errorf("got profile %v, err %v without the annotation", p, err)
+	}
+	if _, err := getProfile(map[string]string{ProfileAnnotation: "k8s"}); err == nil {
+		t.Errorf("expected error for an invalid profile")
+	}
+	for _, name := range ProfileNames() {
+		if _, err := getProfile(map[string]string{ProfileAnnotation: name}); err != nil {
+			t.Errorf("profile %s: %v", name, err)
+		}
+	}
+}
+
+func TestCfgProfile(t *testing.T) {
+	spec := profileSpec("kind")
+	if err := cfgProfile(spec, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if !systemdContainer(spec) {
+		t.Errorf("kind profile not detected as running systemd")
+	}
+	if !utils.StringSliceContains(spec.Process.Env, "container=private-users") {
+		t.Errorf("systemd env not set: %v", spec.Process.Env)
+	}
+
+	// the spec's sysctls take precedence
+	want := map[string]string{"net.ipv4.ip_forward": "0", "net.ipv6.conf.all.forwarding": "1"}
+	if !reflect.DeepEqual(spec.Linux.Sysctl, want) {
+		t.Errorf("got sysctls %v, want %v", spec.Linux.Sysctl, want)
+	}
+
+	// the syscalls are added once to the whitelist
+	allowed := map[string]int{}
+	for _, sc := range spec.Linux.Seccomp.Syscalls {
+		for _, name := range sc.Names {
+			allowed[name]++
+		}
+	}
+	for _, name := range recentSyscalls {
+		if allowed[name] != 1 {
+			t.Errorf("syscall %s allowed %d times", name, allowed[name])
+		}
+	}
+
+	// the spec's mounts take precedence
+	spec = profileSpec("k3d")
+	spec.Mounts = []specs.Mount{{Destination: "/run/", Type: "bind", Source: "/somewhere"}}
+	if err := cfgProfile(spec, true); err != nil {
+		t.Fatal(err)
+	}
+	if systemdContainer(spec) {
+		t.Errorf("k3d profile detected as running systemd")
+	}
+	for _, m := range spec.Mounts {
+		if m.Type == "tmpfs" && m.Destination == "/run" {
+			t.Errorf("profile mount added over the spec's mount")
+		}
+	}
+
+	// annotations set in the spec take precedence
+	spec = profileSpec("gitlab-runner")
+	if err := cfgProfile(spec, true); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Annotations[CpuCoherenceAnnotation] != string(CpuCoherenceEnv) {
+		t.Errorf("profile annotation not set: %v", spec.Annotations)
+	}
+	spec = profileSpec("gitlab-runner")
+	spec.Annotations[CpuCoherenceAnnotation] = string(CpuCoherenceOff)
+	if err := cfgProfile(spec, true); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Annotations[CpuCoherenceAnnotation] != string(CpuCoherenceOff) {
+		t.Errorf("profile annotation overrides the spec's: %v", spec.Annotations)
+	}
+}
+
+func TestAllowSyscalls(t *testing.T) {
+	seccomp := &specs.LinuxSeccomp{
+		DefaultAction: specs.ActAllow,
+		Syscalls: []specs.LinuxSyscall{
+			{Names: []string{"clone3", "kexec_load"}, Action: specs.ActErrno},
+			{Names: []string{"pidfd_open"}, Action: specs.ActErrno},
+		},
+	}
+	allowSyscalls(seccomp, []string{"clone3", "pidfd_open"})
+
+	want := []specs.LinuxSyscall{{Names: []string{"kexec_load"}, Action: specs.ActErrno}}
+	if !reflect.DeepEqual(seccomp.Syscalls, want) {
+		t.Errorf("got syscalls %+v, want %+v", seccomp.Syscalls, want)
+	}
+}
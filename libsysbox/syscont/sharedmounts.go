@@ -0,0 +1,138 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package syscont
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+)
+
+// SharedMountsAllowed checks that the host's allowlist at the given path
+// allows the given container the shared mounts it requests (see
+// SharedMountsAnnotation). Each line of the allowlist has the form:
+//
+// <host dir> [<container-id pattern>]
+//
+// which allows the matching containers (all if no pattern is given; patterns
+// are matched as per filepath.Match()) to share the host dir, or any dir under
+// it. Blank lines and lines starting with '#' are ignored. Without an
+// allowlist, no container may have shared mounts.
+func SharedMountsAllowed(path, id string, annotations map[string]string) error {
+	mounts, err := GetSharedMounts(annotations)
+	if err != nil {
+		return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if len(mounts) == 0 {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &sysbox.Error{
+				Code: sysbox.ErrInvalidSpec,
+				Err:  fmt.Errorf("annotation %s: shared mounts allowlist %s not found", SharedMountsAnnotation, path),
+			}
+		}
+		return err
+	}
+	defer f.Close()
+
+	allowed, err := sharedMountsAllowedDirs(f, id)
+	if err != nil {
+		return &sysbox.Error{
+			Code: sysbox.ErrInvalidConfig,
+			Err:  fmt.Errorf("shared mounts allowlist %s: %w", path, err),
+		}
+	}
+
+	for _, m := range mounts {
+		// the source is checked with symlinks resolved, as that's what's
+		// mounted
+		src, err := filepath.EvalSymlinks(m.Source)
+		if err != nil {
+			return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: fmt.Errorf("annotation %s: %v", SharedMountsAnnotation, err)}
+		}
+		if !underAllowedDir(src, allowed) {
+			return &sysbox.Error{
+				Code: sysbox.ErrInvalidSpec,
+				Err: fmt.Errorf("annotation %s: shared mount source %s not allowed for container %s by %s",
+					SharedMountsAnnotation, m.Source, id, path),
+			}
+		}
+	}
+	return nil
+}
+
+// sharedMountsAllowedDirs returns the host dirs that the given allowlist
+// allows the given container to share.
+func sharedMountsAllowedDirs(r io.Reader, id string) ([]string, error) {
+	allowed := []string{}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: too many fields", n)
+		}
+		if !filepath.IsAbs(fields[0]) {
+			return nil, fmt.Errorf("line %d: %q is not an absolute path", n, fields[0])
+		}
+
+		if len(fields) == 2 {
+			match, err := filepath.Match(fields[1], id)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid pattern %q: %w", n, fields[1], err)
+			}
+			if !match {
+				continue
+			}
+		}
+
+		dir := filepath.Clean(fields[0])
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		allowed = append(allowed, dir)
+	}
+
+	return allowed, scanner.Err()
+}
+
+// underAllowedDir returns true if the given path is one of the given dirs, or
+// under one of them.
+func underAllowedDir(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || dir == "/" || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
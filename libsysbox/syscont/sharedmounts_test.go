@@ -0,0 +1,103 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package syscont
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSharedMountsAllowedDirs(t *testing.T) {
+	allowlist := `
+# caches
+/var/cache/gomod
+/var/cache/npm   build-*
+/var/lib/images/ ci
+`
+	tests := map[string][]string{
+		"build-1": {"/var/cache/gomod", "/var/cache/npm"},
+		"ci":      {"/var/cache/gomod", "/var/lib/images"},
+		"web":     {"/var/cache/gomod"},
+	}
+	for id, want := range tests {
+		got, err := sharedMountsAllowedDirs(strings.NewReader(allowlist), id)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %v, got %v (err %v)", id, want, got, err)
+		}
+	}
+
+	for _, bad := range []string{"/var/cache ci extra", "cache ci", "/var/cache ci-["} {
+		if _, err := sharedMountsAllowedDirs(strings.NewReader(bad), "ci"); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestSharedMountsAllowed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shared-mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "shared-mounts.allow")
+	cache := filepath.Join(dir, "cache")
+	other := filepath.Join(dir, "other")
+	for _, d := range []string{filepath.Join(cache, "gomod"), cache + "-x", other} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a symlink under an allowed dir to a dir outside of it
+	if err := os.Symlink(other, filepath.Join(cache, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	annotations := func(src string) map[string]string {
+		return map[string]string{SharedMountsAnnotation: src + ":/cache"}
+	}
+
+	if err := SharedMountsAllowed(path, "c1", nil); err != nil {
+		t.Errorf("unexpected error without shared mounts nor allowlist: %v", err)
+	}
+	if err := SharedMountsAllowed(path, "c1", annotations(cache)); err == nil {
+		t.Errorf("expected error without allowlist")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(cache+" c*\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SharedMountsAllowed(path, "c1", annotations(filepath.Join(cache, "gomod"))); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := SharedMountsAllowed(path, "d1", annotations(cache)); err == nil {
+		t.Errorf("expected error for a container without an entry")
+	}
+	if err := SharedMountsAllowed(path, "c1", annotations(other)); err == nil {
+		t.Errorf("expected error for a dir that is not allowed")
+	}
+	if err := SharedMountsAllowed(path, "c1", annotations(filepath.Join(cache, "link"))); err == nil {
+		t.Errorf("expected error for a symlink to a dir that is not allowed")
+	}
+	if err := SharedMountsAllowed(path, "c1", annotations(cache+"-x")); err == nil {
+		t.Errorf("expected error for a dir that only shares a prefix with an allowed one")
+	}
+}
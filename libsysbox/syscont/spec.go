@@ -19,17 +19,26 @@
 package syscont
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	mapset "github.com/deckarep/golang-set"
+	"github.com/docker/go-units"
 	ipcLib "github.com/nestybox/sysbox-ipc/sysboxMgrLib"
 	utils "github.com/nestybox/sysbox-libs/utils"
+	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libsysbox/sysbox"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
-	"github.com/urfave/cli"
 	"golang.org/x/sys/unix"
 )
 
@@ -45,9 +54,17 @@ const (
 )
 
 var (
+	// SysboxFsDir is the default sysbox-fs mountpoint; it's used when sysbox-fs
+	// does not report its own mountpoint.
 	SysboxFsDir string = "/var/lib/sysboxfs"
 )
 
+// SysboxFsPlaceholder stands for the container's sysbox-fs dir (i.e.,
+// <sysbox-fs mountpoint>/<container-id>) in the source of the sysbox-fs mounts
+// of reproducible specs (see ConvertSpecReproducible()); the mounts are
+// replaced with the container's own when it's created.
+const SysboxFsPlaceholder = "@sysbox-fs"
+
 // System container "must-have" mounts
 var sysboxMounts = []specs.Mount{
 	specs.Mount{
@@ -104,26 +121,28 @@ var sysboxMounts = []specs.Mount{
 	},
 }
 
-// system container mounts virtualized by sysbox-fs
+// system container mounts virtualized by sysbox-fs; the mount sources are
+// relative to the container's dir under the sysbox-fs mountpoint (see
+// cfgSysboxFsMounts()).
 var sysboxFsMounts = []specs.Mount{
 	//
 	// procfs mounts
 	//
 	specs.Mount{
 		Destination: "/proc/sys",
-		Source:      filepath.Join(SysboxFsDir, "proc/sys"),
+		Source:      "proc/sys",
 		Type:        "bind",
 		Options:     []string{"rbind", "rprivate"},
 	},
 	specs.Mount{
 		Destination: "/proc/swaps",
-		Source:      filepath.Join(SysboxFsDir, "proc/swaps"),
+		Source:      "proc/swaps",
 		Type:        "bind",
 		Options:     []string{"rbind", "rprivate"},
 	},
 	specs.Mount{
 		Destination: "/proc/uptime",
-		Source:      filepath.Join(SysboxFsDir, "proc/uptime"),
+		Source:      "proc/uptime",
 		Type:        "bind",
 		Options:     []string{"rbind", "rprivate"},
 	},
@@ -132,55 +151,55 @@ var sysboxFsMounts = []specs.Mount{
 
 	// specs.Mount{
 	// 	Destination: "/proc/cpuinfo",
-	// 	Source:      filepath.Join(SysboxFsDir, "proc/cpuinfo"),
+	// 	Source:      "proc/cpuinfo",
 	// 	Type:        "bind",
 	// 	Options:     []string{"rbind", "rprivate"},
 	// },
 	// specs.Mount{
 	// 	Destination: "/proc/cgroups",
-	// 	Source:      filepath.Join(SysboxFsDir, "proc/cgroups"),
+	// 	Source:      "proc/cgroups",
 	// 	Type:        "bind",
 	// 	Options:     []string{"rbind", "rprivate"},
 	// },
 	// specs.Mount{
 	// 	Destination: "/proc/devices",
-	// 	Source:      filepath.Join(SysboxFsDir, "proc/devices"),
+	// 	Source:      "proc/devices",
 	// 	Type:        "bind",
 	// 	Options:     []string{"rbind", "rprivate"},
 	// },
 	// specs.Mount{
 	// 	Destination: "/proc/diskstats",
-	// 	Source:      filepath.Join(SysboxFsDir, "proc/diskstats"),
+	// 	Source:      "proc/diskstats",
 	// 	Type:        "bind",
 	// 	Options:     []string{"rbind", "rprivate"},
 	// },
 	// specs.Mount{
 	// 	Destination: "/proc/loadavg",
-	// 	Source:      filepath.Join(SysboxFsDir, "proc/loadavg"),
+	// 	Source:      "proc/loadavg",
 	// 	Type:        "bind",
 	// 	Options:     []string{"rbind", "rprivate"},
 	// },
 	// specs.Mount{
 	// 	Destination: "/proc/meminfo",
-	// 	Source:      filepath.Join(SysboxFsDir, "proc/meminfo"),
+	// 	Source:      "proc/meminfo",
 	// 	Type:        "bind",
 	// 	Options:     []string{"rbind", "rprivate"},
 	// },
 	// specs.Mount{
 	// 	Destination: "/proc/pagetypeinfo",
-	// 	Source:      filepath.Join(SysboxFsDir, "proc/pagetypeinfo"),
+	// 	Source:      "proc/pagetypeinfo",
 	// 	Type:        "bind",
 	// 	Options:     []string{"rbind", "rprivate"},
 	// },
 	// specs.Mount{
 	// 	Destination: "/proc/partitions",
-	// 	Source:      filepath.Join(SysboxFsDir, "proc/partitions"),
+	// 	Source:      "proc/partitions",
 	// 	Type:        "bind",
 	// 	Options:     []string{"rbind", "rprivate"},
 	// },
 	// specs.Mount{
 	// 	Destination: "/proc/stat",
-	// 	Source:      filepath.Join(SysboxFsDir, "proc/stat"),
+	// 	Source:      "proc/stat",
 	// 	Type:        "bind",
 	// 	Options:     []string{"rbind", "rprivate"},
 	// },
@@ -190,13 +209,13 @@ var sysboxFsMounts = []specs.Mount{
 	//
 	specs.Mount{
 		Destination: "/sys/devices/virtual/dmi/id/product_uuid",
-		Source:      filepath.Join(SysboxFsDir, "/sys/devices/virtual/dmi/id/product_uuid"),
+		Source:      "sys/devices/virtual/dmi/id/product_uuid",
 		Type:        "bind",
 		Options:     []string{"rbind", "rprivate"},
 	},
 	specs.Mount{
 		Destination: "/sys/module/nf_conntrack/parameters/hashsize",
-		Source:      filepath.Join(SysboxFsDir, "sys/module/nf_conntrack/parameters/hashsize"),
+		Source:      "sys/module/nf_conntrack/parameters/hashsize",
 		Type:        "bind",
 		Options:     []string{"rbind", "rprivate"},
 	},
@@ -322,11 +341,6 @@ func cfgNamespaces(sysMgr *sysbox.Mgr, spec *specs.Spec) error {
 	var allNs = []string{"pid", "ipc", "uts", "mount", "network", "user", "cgroup"}
 	var reqNs = []string{"pid", "ipc", "uts", "mount", "network"}
 
-	allNsSet := mapset.NewSet()
-	for _, ns := range allNs {
-		allNsSet.Add(ns)
-	}
-
 	reqNsSet := mapset.NewSet()
 	for _, ns := range reqNs {
 		reqNsSet.Add(ns)
@@ -341,17 +355,30 @@ func cfgNamespaces(sysMgr *sysbox.Mgr, spec *specs.Spec) error {
 		return fmt.Errorf("container spec missing namespaces %v", reqNsSet.Difference(specNsSet))
 	}
 
-	addNsSet := allNsSet.Difference(specNsSet)
-	for ns := range addNsSet.Iter() {
-		str := fmt.Sprintf("%v", ns)
+	// add the missing namespaces in a fixed order, so that the resulting spec
+	// is deterministic
+	for _, ns := range allNs {
+		if specNsSet.Contains(ns) {
+			continue
+		}
 		newns := specs.LinuxNamespace{
-			Type: specs.LinuxNamespaceType(str),
+			Type: specs.LinuxNamespaceType(ns),
 			Path: "",
 		}
 		spec.Linux.Namespaces = append(spec.Linux.Namespaces, newns)
 		logrus.Debugf("added namespace %s to spec", ns)
 	}
 
+	// A cgroup ns given by path is joined rather than created (e.g., to share
+	// the cgroup ns of a peer container); check that it can be.
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.CgroupNamespace && ns.Path != "" {
+			if err := validateCgroupnsPath(ns.Path); err != nil {
+				return err
+			}
+		}
+	}
+
 	// Check if we have a sysbox-mgr override for the container's user-ns
 	if sysMgr.Enabled() {
 		if sysMgr.Config.Userns != "" {
@@ -371,6 +398,47 @@ func cfgNamespaces(sysMgr *sysbox.Mgr, spec *specs.Spec) error {
 	return nil
 }
 
+// ioctl to get the type of a namespace (see ioctl_ns(2))
+const nsGetNstype = 0xb703
+
+// validateCgroupnsPath checks that the given cgroup ns path can be joined by a
+// sys container. The sys container's cgroup ns must be rooted at a container's
+// cgroup (normally its own child cgroup, see cgroups.SyscontCgroupRoot), so the
+// cgroup ns of the runtime itself (typically the host's) can't be joined. Note
+// that the container's cgroup must also be within the root of the cgroup ns;
+// this is checked by the container's init process, as the cgroup ns root is not
+// visible from here.
+func validateCgroupnsPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cgroup namespace path: %v", err)
+	}
+	defer f.Close()
+
+	nsType, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), nsGetNstype, 0)
+	if errno != 0 {
+		return fmt.Errorf("cgroup namespace path %s is not a namespace: %v", path, errno)
+	}
+	if nsType != unix.CLONE_NEWCGROUP {
+		return fmt.Errorf("cgroup namespace path %s is not a cgroup namespace", path)
+	}
+
+	var st, ownSt unix.Stat_t
+
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+	if err := unix.Stat("/proc/self/ns/cgroup", &ownSt); err != nil {
+		return fmt.Errorf("failed to stat /proc/self/ns/cgroup: %v", err)
+	}
+	if st.Dev == ownSt.Dev && st.Ino == ownSt.Ino {
+		return fmt.Errorf("cgroup namespace path %s is the host's cgroup namespace; "+
+			"sys containers require a cgroup namespace rooted at a container's cgroup", path)
+	}
+
+	return nil
+}
+
 // allocIDMappings performs uid and gid allocation for the system container
 func allocIDMappings(sysMgr *sysbox.Mgr, spec *specs.Spec) error {
 	var uid, gid uint32
@@ -379,7 +447,7 @@ func allocIDMappings(sysMgr *sysbox.Mgr, spec *specs.Spec) error {
 	if sysMgr.Enabled() {
 		uid, gid, err = sysMgr.ReqSubid(IdRangeMin)
 		if err != nil {
-			return fmt.Errorf("subid allocation failed: %v", err)
+			return fmt.Errorf("subid allocation failed: %w", err)
 		}
 	} else {
 		uid = defaultUid
@@ -485,24 +553,137 @@ func cfgIDMappings(sysMgr *sysbox.Mgr, spec *specs.Spec) error {
 	return validateIDMappings(spec)
 }
 
-// cfgCapabilities sets the capabilities for the process in the system container
-func cfgCapabilities(p *specs.Process) {
+// CapsProfileAnnotation sets the capabilities of the sys container's
+// processes (its root processes get all of them; its other processes get them
+// in their bounding set only):
+//
+// "default": all capabilities.
+// "hardened": all capabilities except those that are no-ops in the
+// container's user-ns (as the kernel checks them against the initial user-ns)
+// or that expose host-wide facilities (see hardenedDroppedCaps).
+// "<cap>[,<cap>...]": the given capabilities only (e.g.,
+// "CAP_CHOWN,CAP_SETUID,CAP_SETGID"; the "CAP_" prefix is optional and case
+// is ignored).
+//
+// The profile applies to the container's init process and to the processes
+// exec'd in it; the capabilities in the container spec are ignored either way.
+const CapsProfileAnnotation = "io.nestybox.sysbox.caps-profile"
+
+// hardenedDroppedCaps are the capabilities dropped by the "hardened"
+// capabilities profile (see CapsProfileAnnotation).
+var hardenedDroppedCaps = []string{
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_MAC_ADMIN",
+	"CAP_MAC_OVERRIDE",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_CONTROL",
+}
+
+// HonorCapsAnnotation, when set to "true", makes sysbox keep the capabilities
+// given in the process spec of the sys container's init and exec processes,
+// rather than replacing them per the capabilities profile (see
+// CapsProfileAnnotation, which can't be set along with it). Sysbox only checks
+// that the capabilities it requires are present (see sysboxRequiredCaps).
+const HonorCapsAnnotation = "io.nestybox.sysbox.honor-caps"
+
+// sysboxRequiredCaps are the capabilities that the sys container's processes
+// must keep when their capabilities are honored (see HonorCapsAnnotation): in
+// the bounding set of all processes, and also in the effective and permitted
+// sets of root processes. Without them, the mounts that sysbox-fs emulates
+// within the sys container, and the user switches done by its init (e.g.,
+// systemd), fail.
+var sysboxRequiredCaps = []string{
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SYS_ADMIN",
+}
+
+// GetHonorCaps returns true if the container's annotations request that the
+// capabilities in its process spec be honored.
+func GetHonorCaps(annotations map[string]string) (bool, error) {
+	switch val := annotations[HonorCapsAnnotation]; val {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid value for annotation %s: %q (must be \"true\" or \"false\")", HonorCapsAnnotation, val)
+	}
+}
+
+// GetCapsProfile returns the capabilities given by the container's
+// capabilities profile (see CapsProfileAnnotation), in linuxCaps order; it
+// returns nil if the capabilities in the process spec are to be honored
+// instead (see HonorCapsAnnotation).
+func GetCapsProfile(annotations map[string]string) ([]string, error) {
+	honor, err := GetHonorCaps(annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	val := strings.TrimSpace(annotations[CapsProfileAnnotation])
+
+	if honor {
+		if val != "" {
+			return nil, fmt.Errorf("annotations %s and %s are mutually exclusive", HonorCapsAnnotation, CapsProfileAnnotation)
+		}
+		return nil, nil
+	}
+
+	switch val {
+	case "", "default":
+		return append([]string{}, linuxCaps...), nil
+	case "hardened":
+		return utils.StringSliceRemove(linuxCaps, hardenedDroppedCaps), nil
+	}
+
+	want := map[string]bool{}
+	for _, c := range strings.Split(val, ",") {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if !strings.HasPrefix(c, "CAP_") {
+			c = "CAP_" + c
+		}
+		if !utils.StringSliceContains(linuxCaps, c) {
+			return nil, fmt.Errorf("invalid value for annotation %s: unknown capability %q (must be \"default\", \"hardened\" or a list of capabilities)",
+				CapsProfileAnnotation, c)
+		}
+		want[c] = true
+	}
+
+	caps := []string{}
+	for _, c := range linuxCaps {
+		if want[c] {
+			caps = append(caps, c)
+		}
+	}
+	return caps, nil
+}
+
+// cfgCapabilities sets the capabilities for the process in the system
+// container, per the given capabilities profile (see GetCapsProfile())
+func cfgCapabilities(p *specs.Process, profile []string) {
 	caps := p.Capabilities
 	uid := p.User.UID
 
 	noCaps := []string{}
 
 	if uid == 0 {
-		// init processes owned by root have all capabilities
-		caps.Bounding = linuxCaps
-		caps.Effective = linuxCaps
-		caps.Inheritable = linuxCaps
-		caps.Permitted = linuxCaps
-		caps.Ambient = linuxCaps
+		// init processes owned by root have all capabilities of the profile
+		caps.Bounding = profile
+		caps.Effective = profile
+		caps.Inheritable = profile
+		caps.Permitted = profile
+		caps.Ambient = profile
 	} else {
 		// init processes owned by others have all caps disabled and the bounding caps all
 		// set (just as in a regular host)
-		caps.Bounding = linuxCaps
+		caps.Bounding = profile
 		caps.Effective = noCaps
 		caps.Inheritable = noCaps
 		caps.Permitted = noCaps
@@ -510,10 +691,41 @@ func cfgCapabilities(p *specs.Process) {
 	}
 }
 
+// validateHonoredCaps checks that the capabilities of the given process, which
+// are honored as given in its spec (see HonorCapsAnnotation), include those
+// sysbox requires.
+func validateHonoredCaps(p *specs.Process) error {
+	caps := p.Capabilities
+	if caps == nil {
+		caps = &specs.LinuxCapabilities{}
+	}
+
+	sets := map[string][]string{"bounding": caps.Bounding}
+	if p.User.UID == 0 {
+		sets["effective"] = caps.Effective
+		sets["permitted"] = caps.Permitted
+	}
+
+	for _, name := range []string{"bounding", "effective", "permitted"} {
+		set, ok := sets[name]
+		if !ok {
+			continue
+		}
+		for _, c := range sysboxRequiredCaps {
+			if !utils.StringSliceContains(set, c) {
+				return fmt.Errorf("capability %s is missing from the %s set of the process spec (required by sysbox when %s is set)",
+					c, name, HonorCapsAnnotation)
+			}
+		}
+	}
+
+	return nil
+}
+
 // cfgMaskedPaths removes from the container's config any masked paths for which
 // sysbox-fs will handle accesses.
 func cfgMaskedPaths(spec *specs.Spec) {
-	if systemdInit(spec.Process) {
+	if systemdContainer(spec) {
 		spec.Linux.MaskedPaths = utils.StringSliceRemove(spec.Linux.MaskedPaths, sysboxSystemdExposedPaths)
 	}
 	spec.Linux.MaskedPaths = utils.StringSliceRemove(spec.Linux.MaskedPaths, sysboxExposedPaths)
@@ -522,39 +734,316 @@ func cfgMaskedPaths(spec *specs.Spec) {
 // cfgReadonlyPaths removes from the container's config any read-only paths
 // that must be read-write in the system container
 func cfgReadonlyPaths(spec *specs.Spec) {
-	if systemdInit(spec.Process) {
+	if systemdContainer(spec) {
 		spec.Linux.ReadonlyPaths = utils.StringSliceRemove(spec.Linux.ReadonlyPaths, sysboxSystemdRwPaths)
 	}
 	spec.Linux.ReadonlyPaths = utils.StringSliceRemove(spec.Linux.ReadonlyPaths, sysboxRwPaths)
 }
 
+// cfgProcReadonlyMounts handles mounts of /proc or /proc/sys that the container
+// engine marks read-only in the spec. Mounting these read-only conflicts with
+// sysbox-fs (which virtualizes /proc/sys) and with the container's setup (e.g.,
+// networking setup writes to /proc/sys). Thus these mounts are set up
+// read-write, and the returned paths must be added to the "readonly" paths
+// list (after cfgReadonlyPaths(), which drops /proc and /proc/sys), so that
+// they are remounted read-only after the container setup completes.
+func cfgProcReadonlyMounts(spec *specs.Spec) []string {
+	var roPaths []string
+
+	for i, m := range spec.Mounts {
+		dest := filepath.Clean(m.Destination)
+		if dest != "/proc" && dest != "/proc/sys" {
+			continue
+		}
+		if !utils.StringSliceContains(m.Options, "ro") {
+			continue
+		}
+
+		spec.Mounts[i].Options = append(utils.StringSliceRemove(m.Options, []string{"ro"}), "rw")
+
+		roPaths = append(roPaths, dest)
+		if dest == "/proc" {
+			roPaths = append(roPaths, "/proc/sys")
+		}
+
+		logrus.Warnf("the container spec mounts %s read-only, which conflicts with the sys container setup; "+
+			"it will be made read-only after the container setup completes instead", dest)
+	}
+
+	return roPaths
+}
+
+// MountPropagationAnnotation sets the propagation of sys container mounts
+// (including those added by sysbox and sysbox-mgr, which are rprivate by
+// default), as a comma separated list of "<dest>=<propagation>" entries (e.g.,
+// "/var/lib/docker=rslave"). It applies to bind mounts only, e.g., so that
+// mounts done on the host under the mount's source (such as NFS automounts)
+// appear inside the container; other mounts (e.g., the sys container's sysfs at
+// /sys) share no mounts with the host, so their propagation can't be set. Only
+// private and slave propagation is allowed, so that mounts inside the sys
+// container never propagate to the host.
+const MountPropagationAnnotation = "io.nestybox.sysbox.mount-propagation"
+
+var mountPropagationOpts = []string{"private", "rprivate", "shared", "rshared", "slave", "rslave", "unbindable", "runbindable"}
+
+// GetMountPropagation returns the mount propagation, indexed by mount
+// destination, given by the container's annotations.
+func GetMountPropagation(annotations map[string]string) (map[string]string, error) {
+	val, ok := annotations[MountPropagationAnnotation]
+	if !ok || val == "" {
+		return nil, nil
+	}
+
+	propagation := make(map[string]string)
+
+	for _, entry := range strings.Split(val, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(kv) != 2 || !filepath.IsAbs(kv[0]) {
+			return nil, fmt.Errorf("annotation %s: invalid entry %q (must be <dest>=<propagation>, with an absolute dest)",
+				MountPropagationAnnotation, entry)
+		}
+
+		switch kv[1] {
+		case "private", "rprivate", "slave", "rslave":
+		default:
+			return nil, fmt.Errorf("annotation %s: invalid propagation %q for %s (must be private, rprivate, slave or rslave)",
+				MountPropagationAnnotation, kv[1], kv[0])
+		}
+
+		propagation[filepath.Clean(kv[0])] = kv[1]
+	}
+
+	return propagation, nil
+}
+
+// cfgMountPropagation sets the propagation of the container's mounts per the
+// container's annotations. It fails if the propagation is set for a mount other
+// than a bind mount.
+func cfgMountPropagation(spec *specs.Spec) error {
+	propagation, err := GetMountPropagation(spec.Annotations)
+	if err != nil {
+		return err
+	}
+
+	for dest, prop := range propagation {
+		found := false
+		for i, m := range spec.Mounts {
+			if filepath.Clean(m.Destination) != dest {
+				continue
+			}
+			if !isBindMount(m) {
+				return fmt.Errorf("annotation %s: mount at %s is not a bind mount (type %s); its propagation can't be set",
+					MountPropagationAnnotation, dest, m.Type)
+			}
+			opts := utils.StringSliceRemove(m.Options, mountPropagationOpts)
+			spec.Mounts[i].Options = append(opts, prop)
+			found = true
+		}
+
+		if !found {
+			logrus.Warnf("annotation %s: no mount at %s; ignoring it", MountPropagationAnnotation, dest)
+		}
+	}
+
+	return nil
+}
+
+// isBindMount returns true if the given spec mount is a bind mount.
+func isBindMount(m specs.Mount) bool {
+	return m.Type == "bind" || utils.StringSliceContains(m.Options, "bind") || utils.StringSliceContains(m.Options, "rbind")
+}
+
+// SharedMountsAnnotation adds read-only bind mounts of host dirs shared by many
+// sys containers (e.g., a warm Go or npm cache, or a shared container image
+// store), as a comma separated list of "<source>:<dest>" entries (e.g.,
+// "/var/cache/gomod:/root/go/pkg/mod"). As the containers mount the same host
+// files, they share the page cache for them, and no per-container copies are
+// needed. The host dirs are managed by the host (i.e., populated and updated
+// out-of-band): they must be allowed by the host's allowlist (see
+// SharedMountsAllowed()), and immutable from within the containers (see
+// sysbox.Mgr.VerifySharedMounts()).
+const SharedMountsAnnotation = "io.nestybox.sysbox.shared-mounts"
+
+// sharedMountOpts are the options of shared mounts.
+var sharedMountOpts = []string{"rbind", "ro", "nosuid", "nodev"}
+
+// GetSharedMounts returns the shared mounts given by the container's
+// annotations.
+func GetSharedMounts(annotations map[string]string) ([]specs.Mount, error) {
+	val, ok := annotations[SharedMountsAnnotation]
+	if !ok || val == "" {
+		return nil, nil
+	}
+
+	mounts := []specs.Mount{}
+	dests := make(map[string]bool)
+
+	for _, entry := range strings.Split(val, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(kv) != 2 || !filepath.IsAbs(kv[0]) || !filepath.IsAbs(kv[1]) {
+			return nil, fmt.Errorf("annotation %s: invalid entry %q (must be <source>:<dest>, with absolute paths)",
+				SharedMountsAnnotation, entry)
+		}
+
+		dest := filepath.Clean(kv[1])
+		if dest == "/" {
+			return nil, fmt.Errorf("annotation %s: invalid dest / for %s", SharedMountsAnnotation, kv[0])
+		}
+		if dests[dest] {
+			return nil, fmt.Errorf("annotation %s: dest %s given more than once", SharedMountsAnnotation, dest)
+		}
+		dests[dest] = true
+
+		mounts = append(mounts, specs.Mount{
+			Source:      filepath.Clean(kv[0]),
+			Destination: dest,
+			Type:        "bind",
+			Options:     append([]string{}, sharedMountOpts...),
+		})
+	}
+
+	return mounts, nil
+}
+
+// cfgSharedMounts adds the container's shared mounts (see
+// SharedMountsAnnotation) to its spec, once their sources are verified.
+func cfgSharedMounts(spec *specs.Spec, sysMgr *sysbox.Mgr) error {
+	mounts, err := GetSharedMounts(spec.Annotations)
+	if err != nil || len(mounts) == 0 {
+		return err
+	}
+
+	sources := []string{}
+	for _, m := range mounts {
+		for _, sm := range spec.Mounts {
+			if filepath.Clean(sm.Destination) == m.Destination {
+				return fmt.Errorf("annotation %s: the spec already has a mount at %s", SharedMountsAnnotation, m.Destination)
+			}
+		}
+		sources = append(sources, m.Source)
+	}
+
+	if err := sysMgr.VerifySharedMounts(sources); err != nil {
+		return err
+	}
+
+	spec.Mounts = append(spec.Mounts, mounts...)
+	return nil
+}
+
 // cfgMounts configures the system container mounts
 func cfgMounts(spec *specs.Spec, sysMgr *sysbox.Mgr, sysFs *sysbox.Fs, uidShiftRootfs bool) error {
 
-	cfgSysboxMounts(spec)
+	sysKernelMounts, err := GetSysKernelMounts(spec)
+	if err != nil {
+		return err
+	}
+
+	// the configfs mode overrides the dummy configfs mount selection
+	configfsMode, configfsSubtrees, err := GetConfigfs(spec.Annotations)
+	if err != nil {
+		return err
+	}
+	switch configfsMode {
+	case ConfigfsSysboxFs:
+		sysKernelMounts = utils.StringSliceRemove(sysKernelMounts, []string{configfsDir})
+	case ConfigfsPassthrough:
+		if !utils.StringSliceContains(sysKernelMounts, configfsDir) {
+			sysKernelMounts = append(sysKernelMounts, configfsDir)
+		}
+	}
+
+	// so does the kernel tracing mode, for the debugfs and tracefs mounts
+	tracingMode, err := GetKernelTracing(spec.Annotations)
+	if err != nil {
+		return err
+	}
+	if tracingMode != KernelTracingNone {
+		sysKernelMounts = utils.StringSliceRemove(sysKernelMounts, kernelTracingDirs)
+	}
+
+	cfgSysboxMounts(spec, sysKernelMounts)
+
+	// Drop the sysbox-fs mounts of a reproducible spec; they are added below
+	// if sysbox-fs is enabled.
+	mounts := []specs.Mount{}
+	for _, m := range spec.Mounts {
+		if !strings.HasPrefix(m.Source, SysboxFsPlaceholder+"/") {
+			mounts = append(mounts, m)
+		}
+	}
+	spec.Mounts = mounts
 
 	if sysFs.Enabled() {
 		cfgSysboxFsMounts(spec, sysFs)
 	}
 
+	if err := cfgConfigfs(spec, sysFs, configfsMode, configfsSubtrees); err != nil {
+		return err
+	}
+
+	if err := cfgKernelTracing(spec, tracingMode); err != nil {
+		return err
+	}
+
+	vols, err := GetVolumes(spec.Annotations)
+	if err != nil {
+		return err
+	}
+
+	etcFiles, err := GetEtcFilesCopy(spec.Annotations)
+	if err != nil {
+		return err
+	}
+
 	if sysMgr.Enabled() {
-		if err := sysMgrSetupMounts(sysMgr, spec, uidShiftRootfs); err != nil {
+		vols, err = cfgContainerdSnapshotters(sysMgr, spec, vols)
+		if err != nil {
+			return err
+		}
+		if err := sysMgrSetupMounts(sysMgr, spec, vols, uidShiftRootfs); err != nil {
 			return err
 		}
+		if len(etcFiles) > 0 {
+			if err := cfgEtcFilesCopy(sysMgr, spec, etcFiles); err != nil {
+				return err
+			}
+		}
 	}
 
-	if systemdInit(spec.Process) {
+	if systemdContainer(spec) {
 		cfgSystemdMounts(spec)
 	}
 
+	if err := cfgSharedMounts(spec, sysMgr); err != nil {
+		return err
+	}
+
+	if err := cfgMountPropagation(spec); err != nil {
+		return err
+	}
+
 	sortMounts(spec)
 
 	return nil
 }
 
 // cfgSysboxMounts adds Sysbox required mounts to the sys container's spec; if the spec
-// has conflicting mounts, these are replaced with Sysbox's mounts.
-func cfgSysboxMounts(spec *specs.Spec) {
+// has conflicting mounts, these are replaced with Sysbox's mounts. Of the dummy
+// mounts under /sys/kernel, only those at the given destinations are added
+// (see GetSysKernelMounts()).
+func cfgSysboxMounts(spec *specs.Spec, sysKernelMounts []string) {
+
+	// Note that we work on a copy of the sysboxMounts list (including the
+	// mount options), since it's shared by all callers.
+	sysMounts := []specs.Mount{}
+	for _, m := range copyMounts(sysboxMounts) {
+		if strings.HasPrefix(m.Destination, "/sys/kernel/") &&
+			!utils.StringSliceContains(sysKernelMounts, m.Destination) {
+			continue
+		}
+		sysMounts = append(sysMounts, m)
+	}
 
 	// Disallow mounts under the container's /sys/fs/cgroup/* (i.e., Sysbox sets those up)
 	var cgroupMounts = []specs.Mount{
@@ -568,49 +1057,60 @@ func cfgSysboxMounts(spec *specs.Spec) {
 	})
 
 	// Remove other conflicting mounts
-	spec.Mounts = utils.MountSliceRemove(spec.Mounts, sysboxMounts, func(m1, m2 specs.Mount) bool {
+	spec.Mounts = utils.MountSliceRemove(spec.Mounts, sysMounts, func(m1, m2 specs.Mount) bool {
 		return m1.Destination == m2.Destination
 	})
 
 	// If the container's rootfs is read-only, then sysbox mounts of /sys and
 	// below should also be read-only.
-	if spec.Root.Readonly {
-		tmpMounts := []specs.Mount{}
-		rwOpt := []string{"rw"}
-		for _, m := range sysboxMounts {
-			if strings.HasPrefix(m.Destination, "/sys") {
-				m.Options = utils.StringSliceRemove(m.Options, rwOpt)
-				m.Options = append(m.Options, "ro")
-			}
-			tmpMounts = append(tmpMounts, m)
+	rwOpt := []string{"rw"}
+	for i, m := range sysMounts {
+		if spec.Root.Readonly && strings.HasPrefix(m.Destination, "/sys") {
+			m.Options = utils.StringSliceRemove(m.Options, rwOpt)
+			sysMounts[i].Options = append(m.Options, "ro")
 		}
-		sysboxMounts = tmpMounts
 	}
 
 	// Add sysbox mounts
-	spec.Mounts = append(spec.Mounts, sysboxMounts...)
+	spec.Mounts = append(spec.Mounts, sysMounts...)
 }
 
-// cfgSysboxFsMounts adds the sysbox-fs mounts to the containers config.
-func cfgSysboxFsMounts(spec *specs.Spec, sysFs *sysbox.Fs) {
-	spec.Mounts = utils.MountSliceRemove(spec.Mounts, sysboxFsMounts, func(m1, m2 specs.Mount) bool {
-		return m1.Destination == m2.Destination
-	})
+// copyMounts returns a deep copy of the given mounts, so that changes to the
+// copy (including to the mount options) don't affect the given ones.
+func copyMounts(mounts []specs.Mount) []specs.Mount {
+	cp := make([]specs.Mount, len(mounts))
+	for i, m := range mounts {
+		m.Options = append([]string{}, m.Options...)
+		cp[i] = m
+	}
+	return cp
+}
 
-	// Adjust sysboxFsMounts path attending to container-id value.
-	cntrMountpoint := filepath.Join(sysFs.Mountpoint, sysFs.Id)
+// SysboxFsMounts returns the sysbox-fs mounts for the given container; it
+// returns a new list on each call, so the sysbox-fs mountpoint may differ
+// between containers (e.g., when using multiple sysbox-fs instances).
+func SysboxFsMounts(sysFs *sysbox.Fs) []specs.Mount {
+	mountpoint := sysFs.Mountpoint
+	if mountpoint == "" {
+		mountpoint = SysboxFsDir
+	}
+	cntrMountpoint := filepath.Join(mountpoint, sysFs.Id)
 
-	for i := range sysboxFsMounts {
-		sysboxFsMounts[i].Source =
-			strings.Replace(
-				sysboxFsMounts[i].Source,
-				SysboxFsDir,
-				cntrMountpoint,
-				1,
-			)
+	mounts := copyMounts(sysboxFsMounts)
+	for i := range mounts {
+		mounts[i].Source = filepath.Join(cntrMountpoint, mounts[i].Source)
 	}
 
-	SysboxFsDir = sysFs.Mountpoint
+	return mounts
+}
+
+// cfgSysboxFsMounts adds the sysbox-fs mounts to the containers config.
+func cfgSysboxFsMounts(spec *specs.Spec, sysFs *sysbox.Fs) {
+	fsMounts := SysboxFsMounts(sysFs)
+
+	spec.Mounts = utils.MountSliceRemove(spec.Mounts, fsMounts, func(m1, m2 specs.Mount) bool {
+		return m1.Destination == m2.Destination
+	})
 
 	// If the spec indicates a read-only rootfs, the sysbox-fs mounts should also
 	// be read-only. However, we don't mark them read-only here explicitly, so
@@ -621,12 +1121,12 @@ func cfgSysboxFsMounts(spec *specs.Spec, sysFs *sysbox.Fs) {
 	// remounted to read-only after the container setup completes, right before
 	// starting the container's init process.
 	if spec.Root.Readonly {
-		for _, m := range sysboxFsMounts {
+		for _, m := range fsMounts {
 			spec.Linux.ReadonlyPaths = append(spec.Linux.ReadonlyPaths, m.Destination)
 		}
 	}
 
-	spec.Mounts = append(spec.Mounts, sysboxFsMounts...)
+	spec.Mounts = append(spec.Mounts, fsMounts...)
 }
 
 // cfgSystemdMounts adds systemd related mounts to the spec
@@ -641,22 +1141,104 @@ func cfgSystemdMounts(spec *specs.Spec) {
 		return m1.Destination == m2.Destination && m1.Type != "tmpfs"
 	})
 
-	sysboxSystemdMounts = utils.MountSliceRemove(sysboxSystemdMounts, spec.Mounts, func(m1, m2 specs.Mount) bool {
+	// Note that we work on a copy of the sysboxSystemdMounts list (including
+	// the mount options), since it's shared by all callers.
+	systemdMounts := utils.MountSliceRemove(copyMounts(sysboxSystemdMounts), spec.Mounts, func(m1, m2 specs.Mount) bool {
 		return m1.Destination == m2.Destination && m2.Type == "tmpfs"
 	})
 
-	spec.Mounts = append(spec.Mounts, sysboxSystemdMounts...)
+	spec.Mounts = append(spec.Mounts, systemdMounts...)
+}
+
+// VolumesAnnotation sets up volumes in the sys container: dirs backed by host
+// dirs that sysbox-mgr manages (as with the special dirs, such as
+// /var/lib/docker), owned by the container's root user. Its value is a comma
+// separated list of <path>[:<size>][:persist], where:
+//
+// <path> is the absolute path of the volume in the container.
+// <size> is the max size of the volume's contents (e.g., "10G"), if the host
+// supports it; defaults to no limit.
+// "persist" keeps the volume's host dir when the container is removed, for
+// the next container with the same id; by default, it's removed along with
+// the container.
+//
+// A volume replaces the special dir at the same path (if any), but it can't
+// be at the same path as a mount of the spec. Requires a sysbox-mgr client
+// that supports volumes (see sysbox.VolumeProvider); the default gRPC client
+// doesn't, as sysbox-mgr doesn't export the request yet, so containers with
+// this annotation are rejected.
+const VolumesAnnotation = "io.nestybox.sysbox.volumes"
+
+// GetVolumes returns the volumes given by the container's annotations.
+func GetVolumes(annotations map[string]string) ([]sysbox.MgrVolume, error) {
+	val := strings.TrimSpace(annotations[VolumesAnnotation])
+	if val == "" {
+		return nil, nil
+	}
+
+	vols := []sysbox.MgrVolume{}
+	seen := map[string]bool{}
+
+	for _, entry := range strings.Split(val, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+
+		dest := fields[0]
+		if !filepath.IsAbs(dest) || filepath.Clean(dest) == "/" {
+			return nil, fmt.Errorf("annotation %s: invalid volume path %q (must be an absolute path other than /)", VolumesAnnotation, dest)
+		}
+		vol := sysbox.MgrVolume{Dest: filepath.Clean(dest)}
+		if seen[vol.Dest] {
+			return nil, fmt.Errorf("annotation %s: duplicate volume %s", VolumesAnnotation, vol.Dest)
+		}
+		seen[vol.Dest] = true
+
+		opts := fields[1:]
+		if len(opts) > 0 && opts[len(opts)-1] == "persist" {
+			vol.Persist = true
+			opts = opts[:len(opts)-1]
+		}
+		if len(opts) > 1 {
+			return nil, fmt.Errorf("annotation %s: invalid volume %q (must be <path>[:<size>][:persist])", VolumesAnnotation, entry)
+		}
+		if len(opts) == 1 {
+			size, err := units.RAMInBytes(opts[0])
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("annotation %s: invalid size %q of volume %s", VolumesAnnotation, opts[0], vol.Dest)
+			}
+			vol.Size = size
+		}
+
+		vols = append(vols, vol)
+	}
+
+	return vols, nil
+}
+
+// mgrSpecialDirs are the dirs in the sys container that are bind-mounted from
+// host dirs managed by sysbox-mgr (unless the spec bind-mounts them).
+var mgrSpecialDirs = map[string]ipcLib.MntKind{
+	"/var/lib/docker":      ipcLib.MntVarLibDocker,
+	"/var/lib/kubelet":     ipcLib.MntVarLibKubelet,
+	"/var/lib/rancher/k3s": ipcLib.MntVarLibK3s,
+	"/var/lib/containerd/io.containerd.snapshotter.v1.overlayfs": ipcLib.MntVarLibContainerdOvfs,
 }
 
 // sysMgrSetupMounts requests the sysbox-mgr to setup special sys container mounts.
-func sysMgrSetupMounts(mgr *sysbox.Mgr, spec *specs.Spec, uidShiftRootfs bool) error {
+func sysMgrSetupMounts(mgr *sysbox.Mgr, spec *specs.Spec, vols []sysbox.MgrVolume, uidShiftRootfs bool) error {
+
+	specialDir := map[string]ipcLib.MntKind{}
+	for dest, kind := range mgrSpecialDirs {
+		specialDir[dest] = kind
+	}
 
-	// These directories in the sys container are bind-mounted from host dirs managed by sysbox-mgr
-	specialDir := map[string]ipcLib.MntKind{
-		"/var/lib/docker":      ipcLib.MntVarLibDocker,
-		"/var/lib/kubelet":     ipcLib.MntVarLibKubelet,
-		"/var/lib/rancher/k3s": ipcLib.MntVarLibK3s,
-		"/var/lib/containerd/io.containerd.snapshotter.v1.overlayfs": ipcLib.MntVarLibContainerdOvfs,
+	// volumes replace the special dirs at the same path
+	for _, v := range vols {
+		for _, m := range spec.Mounts {
+			if filepath.Clean(m.Destination) == v.Dest {
+				return fmt.Errorf("annotation %s: the spec already has a mount at %s", VolumesAnnotation, v.Dest)
+			}
+		}
+		delete(specialDir, v.Dest)
 	}
 
 	uid := spec.Linux.UIDMappings[0].HostID
@@ -680,6 +1262,7 @@ func sysMgrSetupMounts(mgr *sysbox.Mgr, spec *specs.Spec, uidShiftRootfs bool) e
 
 			prepList = append(prepList, info)
 			delete(specialDir, m.Destination)
+			mgr.Mounts = append(mgr.Mounts, m.Destination)
 		}
 	}
 
@@ -699,6 +1282,7 @@ func sysMgrSetupMounts(mgr *sysbox.Mgr, spec *specs.Spec, uidShiftRootfs bool) e
 		}
 		reqList = append(reqList, info)
 	}
+	sort.Slice(reqList, func(i, j int) bool { return reqList[i].Dest < reqList[j].Dest })
 
 	// sysbox-mgr will setup host dirs to back the mounts in the
 	// request list; it will also send us any other mounts it needs.
@@ -712,6 +1296,14 @@ func sysMgrSetupMounts(mgr *sysbox.Mgr, spec *specs.Spec, uidShiftRootfs bool) e
 		return err
 	}
 
+	if len(vols) > 0 {
+		vm, err := mgr.ReqVolumes(rootPath, uid, gid, uidShiftRootfs, vols)
+		if err != nil {
+			return err
+		}
+		m = append(m, vm...)
+	}
+
 	// If any sysbox-mgr mounts conflict with any in the spec (i.e.,
 	// same dest), prioritize the spec ones
 	mounts := utils.MountSliceRemove(m, spec.Mounts, func(m1, m2 specs.Mount) bool {
@@ -731,26 +1323,97 @@ func sysMgrSetupMounts(mgr *sysbox.Mgr, spec *specs.Spec, uidShiftRootfs bool) e
 		mounts = tmpMounts
 	}
 
+	for _, m := range mounts {
+		mgr.Mounts = append(mgr.Mounts, m.Destination)
+	}
 	spec.Mounts = append(spec.Mounts, mounts...)
 
 	return nil
 }
 
-// checkSpec performs some basic checks on the system container's spec
-func checkSpec(spec *specs.Spec) error {
+// EtcFilesCopyAnnotation makes the given files, which container engines
+// bind-mount into containers owned by the host's root user (and thus not
+// writable in sys containers), writable by the sys container's root user:
+// they're bind-mounted from per-container copies owned by it, made by
+// sysbox-mgr when the container is created. Its value is a comma separated
+// list of "/etc/resolv.conf", "/etc/hosts" and "/etc/hostname".
+//
+// Only files bind-mounted by the spec are copied; changes to them made by the
+// container engine afterwards (e.g., as the container joins a network) are
+// not seen by the container. Requires a sysbox-mgr client that supports file
+// copies (see sysbox.FileCopier); the default gRPC client doesn't, so
+// containers with this annotation are rejected.
+const EtcFilesCopyAnnotation = "io.nestybox.sysbox.etc-files-copy"
+
+var etcFilesCopyable = []string{"/etc/resolv.conf", "/etc/hosts", "/etc/hostname"}
+
+// GetEtcFilesCopy returns the files given by the container's annotations to be
+// copied (see EtcFilesCopyAnnotation).
+func GetEtcFilesCopy(annotations map[string]string) ([]string, error) {
+	val := strings.TrimSpace(annotations[EtcFilesCopyAnnotation])
+	if val == "" {
+		return nil, nil
+	}
 
-	if spec.Root == nil || spec.Linux == nil {
-		return fmt.Errorf("not a linux container spec")
+	files := []string{}
+	for _, f := range strings.Split(val, ",") {
+		f = strings.TrimSpace(f)
+		if !utils.StringSliceContains(etcFilesCopyable, f) {
+			return nil, fmt.Errorf("invalid value for annotation %s: %q (must be one of: %s)",
+				EtcFilesCopyAnnotation, f, strings.Join(etcFilesCopyable, ", "))
+		}
+		if !utils.StringSliceContains(files, f) {
+			files = append(files, f)
+		}
 	}
+	return files, nil
+}
 
-	// Ensure the container's network ns is not shared with the host
-	for _, ns := range spec.Linux.Namespaces {
-		if ns.Type == specs.NetworkNamespace && ns.Path != "" {
-			var st1, st2 unix.Stat_t
+// cfgEtcFilesCopy replaces the sources of the spec's bind mounts of the given
+// files with per-container copies owned by the container's root user (see
+// EtcFilesCopyAnnotation).
+func cfgEtcFilesCopy(mgr *sysbox.Mgr, spec *specs.Spec, files []string) error {
+	idxs := []int{}
+	srcs := []string{}
+	for i, m := range spec.Mounts {
+		if m.Type == "bind" && utils.StringSliceContains(files, filepath.Clean(m.Destination)) {
+			idxs = append(idxs, i)
+			srcs = append(srcs, m.Source)
+		}
+	}
+	if len(srcs) == 0 {
+		logrus.Debugf("the spec bind-mounts none of %v; not copying them", files)
+		return nil
+	}
 
-			if err := unix.Stat("/proc/self/ns/net", &st1); err != nil {
-				return fmt.Errorf("unable to stat sysbox's network namespace: %s", err)
-			}
+	uid := spec.Linux.UIDMappings[0].HostID
+	gid := spec.Linux.GIDMappings[0].HostID
+
+	copies, err := mgr.CopyFiles(uid, gid, srcs)
+	if err != nil {
+		return err
+	}
+	for i, idx := range idxs {
+		spec.Mounts[idx].Source = copies[i]
+	}
+	return nil
+}
+
+// checkSpec performs some basic checks on the system container's spec
+func checkSpec(spec *specs.Spec) error {
+
+	if spec.Root == nil || spec.Linux == nil {
+		return fmt.Errorf("not a linux container spec")
+	}
+
+	// Ensure the container's network ns is not shared with the host
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.NetworkNamespace && ns.Path != "" {
+			var st1, st2 unix.Stat_t
+
+			if err := unix.Stat("/proc/self/ns/net", &st1); err != nil {
+				return fmt.Errorf("unable to stat sysbox's network namespace: %s", err)
+			}
 			if err := unix.Stat(ns.Path, &st2); err != nil {
 				return fmt.Errorf("unable to stat %q: %s", ns.Path, err)
 			}
@@ -766,16 +1429,71 @@ func checkSpec(spec *specs.Spec) error {
 	return nil
 }
 
-func cfgOomScoreAdj(spec *specs.Spec) {
+// MinOomScoreAdj is the lowest OOM score adjustment of a sys container: -1000
+// (i.e., OOM kills disabled) is not supported from within a user-ns.
+const MinOomScoreAdj = -999
+
+// OomScoreAdjMinAnnotation sets the floor of the sys container's OOM score
+// adjustment (between -999 and 1000): a lower oomScoreAdj in the spec is
+// raised to it, as is the adjustment the container would otherwise inherit
+// from sysbox-runc. Defaults to -999 (see MinOomScoreAdj), which e.g. lets
+// an orchestrator set a higher floor so its sys containers are OOM killed
+// before its own agents are.
+const OomScoreAdjMinAnnotation = "io.nestybox.sysbox.oom-score-adj-min"
+
+// GetOomScoreAdjMin returns the floor of the OOM score adjustment given by the
+// container's annotations, and whether it's set.
+func GetOomScoreAdjMin(annotations map[string]string) (int, bool, error) {
+	val, ok := annotations[OomScoreAdjMinAnnotation]
+	if !ok {
+		return MinOomScoreAdj, false, nil
+	}
+
+	min, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil || min < MinOomScoreAdj || min > 1000 {
+		return 0, false, fmt.Errorf("invalid value for annotation %s: %q (must be an integer between %d and 1000)",
+			OomScoreAdjMinAnnotation, val, MinOomScoreAdj)
+	}
+
+	return min, true, nil
+}
 
-	// For sys containers we don't allow -1000 for the OOM score value, as this
-	// is not supported from within a user-ns.
+// cfgOomScoreAdj raises the sys container's OOM score adjustment to the given
+// floor. If the spec doesn't set it, the container inherits sysbox-runc's
+// (per the OCI spec), which libcontainer raises to MinOomScoreAdj; so that
+// only needs doing here when the floor is set explicitly.
+func cfgOomScoreAdj(spec *specs.Spec, min int, explicit bool) error {
 
-	if spec.Process.OOMScoreAdj != nil {
-		if *spec.Process.OOMScoreAdj < -999 {
-			*spec.Process.OOMScoreAdj = -999
+	if spec.Process.OOMScoreAdj == nil {
+		if !explicit {
+			return nil
+		}
+		self, err := readSelfOomScoreAdj()
+		if err != nil {
+			return err
+		}
+		if self >= min {
+			return nil
 		}
+		spec.Process.OOMScoreAdj = &min
+		return nil
+	}
+
+	if *spec.Process.OOMScoreAdj < min {
+		logrus.Warnf("raising the container's oom score adjustment from %d to its floor %d (see annotation %s)",
+			*spec.Process.OOMScoreAdj, min, OomScoreAdjMinAnnotation)
+		*spec.Process.OOMScoreAdj = min
+	}
+
+	return nil
+}
+
+func readSelfOomScoreAdj() (int, error) {
+	data, err := ioutil.ReadFile("/proc/self/oom_score_adj")
+	if err != nil {
+		return 0, err
 	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
 }
 
 // cfgSeccomp configures the system container's seccomp settings.
@@ -840,11 +1558,17 @@ func cfgSeccomp(seccomp *specs.LinuxSeccomp) error {
 	}
 
 	if whitelist {
-		// add the diffset to the whitelist
+		// add the diffset to the whitelist (sorted, so that the resulting spec
+		// is deterministic)
+		names := []string{}
 		for syscallName := range diffSet.Iter() {
-			str := fmt.Sprintf("%v", syscallName)
+			names = append(names, fmt.Sprintf("%v", syscallName))
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
 			sc := specs.LinuxSyscall{
-				Names:  []string{str},
+				Names:  []string{name},
 				Action: specs.ActAllow,
 			}
 			seccomp.Syscalls = append(seccomp.Syscalls, sc)
@@ -926,57 +1650,1819 @@ func systemdInit(p *specs.Process) bool {
 	return p.Args[0] == "/sbin/init"
 }
 
-// Configure the container's process spec for system containers
-func ConvertProcessSpec(p *specs.Process) error {
+// NoNewPrivsAnnotation sets the sys container's noNewPrivileges policy:
+//
+// "preserve" (default): honor the noNewPrivileges setting of the process spec.
+// "clear": clear noNewPrivileges for the container's init and exec processes.
+// "clear-init": clear noNewPrivileges for the container's init process only.
+// "set": set noNewPrivileges for the container's init and exec processes.
+//
+// Clearing noNewPrivileges allows setuid binaries inside the sys container
+// (e.g., sudo, or those in nested containers) to work when the container
+// engine sets noNewPrivileges; setting it hardens containers whose workloads
+// don't need them. The "--no-new-privs" option of the exec command overrides
+// the policy for the exec'd process.
+const NoNewPrivsAnnotation = "io.nestybox.sysbox.no-new-privileges"
 
-	cfgCapabilities(p)
+type NoNewPrivsPolicy string
 
-	if err := cfgAppArmor(p); err != nil {
-		return fmt.Errorf("failed to configure AppArmor profile: %v", err)
+const (
+	NoNewPrivsPreserve  NoNewPrivsPolicy = "preserve"
+	NoNewPrivsClear     NoNewPrivsPolicy = "clear"
+	NoNewPrivsClearInit NoNewPrivsPolicy = "clear-init"
+	NoNewPrivsSet       NoNewPrivsPolicy = "set"
+)
+
+// GetNoNewPrivsPolicy returns the noNewPrivileges policy given by the
+// container's annotations.
+func GetNoNewPrivsPolicy(annotations map[string]string) (NoNewPrivsPolicy, error) {
+	val, ok := annotations[NoNewPrivsAnnotation]
+	if !ok {
+		return NoNewPrivsPreserve, nil
 	}
 
-	if systemdInit(p) {
-		cfgSystemdEnv(p)
+	policy := NoNewPrivsPolicy(val)
+	switch policy {
+	case NoNewPrivsPreserve, NoNewPrivsClear, NoNewPrivsClearInit, NoNewPrivsSet:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("invalid value for annotation %s: %q (must be %q, %q, %q or %q)",
+		NoNewPrivsAnnotation, val, NoNewPrivsPreserve, NoNewPrivsClear, NoNewPrivsClearInit, NoNewPrivsSet)
+}
+
+// cfgNoNewPrivs applies the noNewPrivileges policy to the given process; init
+// indicates if it's the container's init process (as opposed to an exec
+// process).
+func cfgNoNewPrivs(p *specs.Process, policy NoNewPrivsPolicy, init bool) {
+	procType := "exec"
+	if init {
+		procType = "init"
+	}
+
+	if policy == NoNewPrivsClear || (policy == NoNewPrivsClearInit && init) {
+		if p.NoNewPrivileges {
+			logrus.Warnf("clearing noNewPrivileges for the sys container's %s process (%s=%s); "+
+				"setuid/setgid binaries and file capabilities inside the container can now raise the process' privileges",
+				procType, NoNewPrivsAnnotation, policy)
+			p.NoNewPrivileges = false
+		}
+		return
+	}
+
+	if policy == NoNewPrivsSet && !p.NoNewPrivileges {
+		logrus.Debugf("setting noNewPrivileges for the sys container's %s process (%s=%s)",
+			procType, NoNewPrivsAnnotation, policy)
+		p.NoNewPrivileges = true
+	}
+
+	if p.NoNewPrivileges && p.User.UID != 0 {
+		logrus.Warnf("noNewPrivileges is set for the sys container's %s process; "+
+			"setuid binaries inside the container (e.g., sudo) won't work (see annotation %s)",
+			procType, NoNewPrivsAnnotation)
+	}
+}
+
+// ClockSettimeAnnotation sets how attempts to set the system clock from within
+// the sys container (e.g., by ntpd or chronyd) are handled:
+//
+// "deny" (default): the clock setting syscalls fail with EPERM.
+// "emulate": the clock setting syscalls are trapped (by sysbox-fs) and succeed
+// without changing the system clock; it requires a sysbox-fs with the
+// sysbox.FeatFsClock feature.
+//
+// The clock setting syscalls are clock_settime, settimeofday, and the clock
+// adjusting adjtimex and clock_adjtime (used by ntpd and chronyd). The latter
+// also read the clock's adjustment state, so with "deny" they are allowed by
+// seccomp, and the kernel denies the adjustments (the sys container lacks
+// CAP_SYS_TIME in the initial user-ns); with "emulate", sysbox-fs lets the
+// reads through.
+//
+// Either way, the system clock is never changed from within the sys container.
+// Allowing the syscalls is not an option: time namespaces only offset the
+// monotonic and boot clocks, not the realtime clock, which is host-wide.
+const ClockSettimeAnnotation = "io.nestybox.sysbox.clock-settime"
+
+type ClockSettimePolicy string
+
+const (
+	ClockSettimeDeny    ClockSettimePolicy = "deny"
+	ClockSettimeEmulate ClockSettimePolicy = "emulate"
+)
+
+// GetClockSettimePolicy returns the clock setting policy given by the
+// container's annotations.
+func GetClockSettimePolicy(annotations map[string]string) (ClockSettimePolicy, error) {
+	val, ok := annotations[ClockSettimeAnnotation]
+	if !ok {
+		return ClockSettimeDeny, nil
 	}
 
+	policy := ClockSettimePolicy(val)
+	switch policy {
+	case ClockSettimeDeny, ClockSettimeEmulate:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("invalid value for annotation %s: %q (must be %q or %q)",
+		ClockSettimeAnnotation, val, ClockSettimeDeny, ClockSettimeEmulate)
+}
+
+// checkClockSettimePolicy checks that the given clock setting policy can be
+// enforced with the given sysbox-fs.
+func checkClockSettimePolicy(policy ClockSettimePolicy, sysFs *sysbox.Fs) error {
+	if policy != ClockSettimeEmulate {
+		return nil
+	}
+	if !sysFs.Enabled() {
+		return fmt.Errorf("annotation %s: %q requires sysbox-fs", ClockSettimeAnnotation, policy)
+	}
+	if !sysFs.Caps.Has(sysbox.FeatFsClock) {
+		return fmt.Errorf("annotation %s: sysbox-fs %s lacks feature %q", ClockSettimeAnnotation, sysFs.Caps.Version, sysbox.FeatFsClock)
+	}
 	return nil
 }
 
-// ConvertSpec converts the given container spec to a system container spec.
-func ConvertSpec(context *cli.Context, sysMgr *sysbox.Mgr, sysFs *sysbox.Fs, spec *specs.Spec) (bool, bool, error) {
+// cfgClockSettime configures the sys container's seccomp settings for the
+// clock setting syscalls, per the given policy: they are denied with EPERM
+// (rather than per the profile's default action, which may be a less telling
+// errno or kill), or allowed so that they can be trapped (see
+// AddSyscallTraps()). The clock adjusting syscalls are allowed either way (see
+// ClockSettimeAnnotation). Without a seccomp profile, the kernel denies them
+// with EPERM (the sys container lacks CAP_SYS_TIME in the initial user-ns).
+func cfgClockSettime(seccomp *specs.LinuxSeccomp, policy ClockSettimePolicy) {
 
-	if err := checkSpec(spec); err != nil {
-		return false, false, fmt.Errorf("invalid or unsupported container spec: %v", err)
+	if seccomp == nil {
+		return
 	}
 
-	if err := cfgNamespaces(sysMgr, spec); err != nil {
-		return false, false, fmt.Errorf("invalid namespace config: %v", err)
+	var newSyscalls []specs.LinuxSyscall
+	for _, sc := range seccomp.Syscalls {
+		names := []string{}
+		for _, name := range sc.Names {
+			if !utils.StringSliceContains(syscontClockSyscalls, name) &&
+				!utils.StringSliceContains(syscontClockAdjSyscalls, name) {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			sc.Names = names
+			newSyscalls = append(newSyscalls, sc)
+		}
 	}
 
-	if err := cfgIDMappings(sysMgr, spec); err != nil {
-		return false, false, fmt.Errorf("invalid user/group ID config: %v", err)
+	sc := specs.LinuxSyscall{
+		Names:  append([]string{}, syscontClockSyscalls...),
+		Action: specs.ActAllow,
 	}
+	if policy == ClockSettimeDeny {
+		errno := uint(unix.EPERM)
+		sc.Action = specs.ActErrno
+		sc.ErrnoRet = &errno
+	}
+	adj := specs.LinuxSyscall{
+		Names:  append([]string{}, syscontClockAdjSyscalls...),
+		Action: specs.ActAllow,
+	}
+	seccomp.Syscalls = append(newSyscalls, sc, adj)
+}
 
-	// Must do this after cfgIDMappings()
-	uidShiftSupported, uidShiftRootfs, err := sysbox.CheckUidShifting(spec)
-	if err != nil {
-		return false, false, err
+// MountFastPathAnnotation lists (comma separated) the kinds of mount(2) calls
+// in the sys container that bypass syscall trapping, i.e., that the kernel
+// handles directly rather than via a round-trip to sysbox-fs. It speeds up
+// workloads that do many such mounts (e.g., nested image builds):
+//
+// "bind": bind mounts (MS_BIND, without MS_REMOUNT).
+// "propagation": mount propagation changes (e.g., MS_PRIVATE, MS_SHARED).
+//
+// Remounts and moves are always trapped, as sysbox-fs protects the sys
+// container's immutable mounts from them. The kinds are told apart by the
+// mount flags only, as seccomp can't inspect the pointer args (e.g., the mount
+// source or fstype); e.g., overlay mounts can't bypass trapping, as they can't
+// be told from procfs or sysfs mounts (which sysbox-fs must handle). Also,
+// bind mounts that bypass trapping get the kernel's behavior: non-recursive
+// bind mounts of (dirs under) /proc or /sys lack sysbox-fs' emulation.
+const MountFastPathAnnotation = "io.nestybox.sysbox.mount-fast-path"
+
+type MountFastPath string
+
+const (
+	MountFastPathBind        MountFastPath = "bind"
+	MountFastPathPropagation MountFastPath = "propagation"
+)
+
+var mountFastPaths = []string{string(MountFastPathBind), string(MountFastPathPropagation)}
+
+// GetMountFastPaths returns the kinds of mounts that bypass syscall trapping
+// per the container's annotations (none by default).
+func GetMountFastPaths(annotations map[string]string) ([]MountFastPath, error) {
+	val := strings.TrimSpace(annotations[MountFastPathAnnotation])
+	if val == "" {
+		return nil, nil
 	}
 
-	if err := cfgMounts(spec, sysMgr, sysFs, uidShiftRootfs); err != nil {
-		return false, false, fmt.Errorf("invalid mount config: %v", err)
+	names := []string{}
+	for _, name := range strings.Split(val, ",") {
+		name = strings.TrimSpace(name)
+		if !utils.StringSliceContains(mountFastPaths, name) {
+			return nil, fmt.Errorf("annotation %s: invalid mount kind %q (must be one of: %s)",
+				MountFastPathAnnotation, name, strings.Join(mountFastPaths, ", "))
+		}
+		if !utils.StringSliceContains(names, name) {
+			names = append(names, name)
+		}
 	}
 
-	cfgMaskedPaths(spec)
-	cfgReadonlyPaths(spec)
-	cfgOomScoreAdj(spec)
+	paths := []MountFastPath{}
+	for _, name := range names {
+		paths = append(paths, MountFastPath(name))
+	}
+	return paths, nil
+}
 
-	if err := cfgSeccomp(spec.Linux.Seccomp); err != nil {
-		return false, false, fmt.Errorf("failed to configure seccomp: %v", err)
+// ShareNsAnnotation makes the sys container join the ipc, uts and/or cgroup
+// namespace of a peer sys container, given as a comma separated list of
+// "<ns>=<peer-id>" entries (e.g., "ipc=sidecar1,uts=sidecar1"). The container
+// must also share the peer's user namespace (see libsysbox.PrepareSpec()).
+const ShareNsAnnotation = "io.nestybox.sysbox.share-ns"
+
+// GetSharedNamespaces returns the peer container IDs, indexed by namespace
+// type, whose namespaces the container joins per its annotations.
+func GetSharedNamespaces(annotations map[string]string) (map[specs.LinuxNamespaceType]string, error) {
+	val, ok := annotations[ShareNsAnnotation]
+	if !ok || val == "" {
+		return nil, nil
+	}
+
+	shared := make(map[specs.LinuxNamespaceType]string)
+
+	for _, entry := range strings.Split(val, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("annotation %s: invalid entry %q (must be <ns>=<peer-id>)", ShareNsAnnotation, entry)
+		}
+
+		nsType := specs.LinuxNamespaceType(kv[0])
+		if nsType != specs.IPCNamespace && nsType != specs.UTSNamespace && nsType != specs.CgroupNamespace {
+			return nil, fmt.Errorf("annotation %s: namespace %q can't be shared (must be %q, %q or %q)",
+				ShareNsAnnotation, kv[0], specs.IPCNamespace, specs.UTSNamespace, specs.CgroupNamespace)
+		}
+		if _, ok := shared[nsType]; ok {
+			return nil, fmt.Errorf("annotation %s: namespace %q given more than once", ShareNsAnnotation, kv[0])
+		}
+
+		shared[nsType] = kv[1]
+	}
+
+	return shared, nil
+}
+
+// RootfsCloneAnnotation, when set to "true", makes the sys container use an
+// overlayfs clone of its rootfs (see the rootfsclone package) rather than the
+// rootfs itself, so that many containers can be launched from the same bundle.
+const RootfsCloneAnnotation = "io.nestybox.sysbox.rootfs-clone"
+
+// GetRootfsClone returns true if the container's annotations request a clone
+// of its rootfs.
+func GetRootfsClone(annotations map[string]string) (bool, error) {
+	switch val := annotations[RootfsCloneAnnotation]; val {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid value for annotation %s: %q (must be \"true\" or \"false\")", RootfsCloneAnnotation, val)
+	}
+}
+
+// RootfsPremountedAnnotation, when set to "true", indicates that the sys
+// container's rootfs is already fully mounted and ID-mapped to the container's
+// user-ID range by an external snapshotter (e.g., containerd's idmapped
+// snapshots). Sysbox then leaves the rootfs ownership as is (i.e., it does not
+// shift it), but verifies that it matches the container's ID mappings.
+const RootfsPremountedAnnotation = "io.nestybox.sysbox.rootfs-premounted"
+
+// GetRootfsPremounted returns true if the container's annotations indicate a
+// pre-mounted rootfs.
+func GetRootfsPremounted(annotations map[string]string) (bool, error) {
+	switch val := annotations[RootfsPremountedAnnotation]; val {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid value for annotation %s: %q (must be \"true\" or \"false\")", RootfsPremountedAnnotation, val)
+	}
+}
+
+// UpperDirAnnotation selects the host dir (e.g., on local scratch storage)
+// where the writable layer of the sys container's rootfs is placed. It implies
+// a rootfs clone (see RootfsCloneAnnotation): the rootfs becomes the
+// overlayfs lower layer, and all writes to it (including the chown copies made
+// when shifting its ownership) go to the upper layer under this dir. This
+// keeps slow (e.g., network) rootfs storage from dominating the container's
+// write performance. The dir must be under one of the upper dirs allowed by the
+// host (see the --upper-dirs flag).
+const UpperDirAnnotation = "io.nestybox.sysbox.upper-dir"
+
+// GetUpperDir returns the dir given by the container's upper-dir annotation,
+// or an empty string if not set.
+func GetUpperDir(annotations map[string]string) (string, error) {
+	val := annotations[UpperDirAnnotation]
+	if val == "" {
+		return "", nil
+	}
+	if !filepath.IsAbs(val) {
+		return "", fmt.Errorf("invalid value for annotation %s: %q (must be an absolute path)", UpperDirAnnotation, val)
+	}
+	return filepath.Clean(val), nil
+}
+
+// TemplateAnnotation, when set to "true", makes the sys container a warm-start
+// template: it's fully set up when created (mounts, registration with the
+// sysbox daemons, cgroups), but its process is only executed once it's
+// activated (see the "activate" command), which stamps its identity (hostname
+// and machine ID) on it. Its user namespace, and thus its subid range, is set
+// up when it's created.
+const TemplateAnnotation = "io.nestybox.sysbox.template"
+
+// GetTemplate returns true if the container's annotations make it a template.
+func GetTemplate(annotations map[string]string) (bool, error) {
+	switch val := annotations[TemplateAnnotation]; val {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid value for annotation %s: %q (must be \"true\" or \"false\")", TemplateAnnotation, val)
+	}
+}
+
+// NotifyProxyAnnotation, when set to "true", gives the sys container's
+// payload a notify socket (NOTIFY_SOCKET, see sd_notify(3)), whose READY=1
+// notification marks the container as ready in its state (see the "state"
+// command): container engines learn that the payload (e.g., its systemd) is
+// ready, rather than just that its init process has been executed. It can't be
+// combined with a NOTIFY_SOCKET given to sysbox-runc.
+const NotifyProxyAnnotation = "io.nestybox.sysbox.notify-proxy"
+
+// GetNotifyProxy returns true if the container's annotations request the
+// notify proxy.
+func GetNotifyProxy(annotations map[string]string) (bool, error) {
+	switch val := annotations[NotifyProxyAnnotation]; val {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid value for annotation %s: %q (must be \"true\" or \"false\")", NotifyProxyAnnotation, val)
+	}
+}
+
+// SeedSnapshotAnnotation seeds the sys container's writable state from a
+// snapshot of another one (see the "snapshot" command), given as the absolute
+// path of the snapshot dir (a tar snapshot must be extracted first, preserving
+// ownership and xattrs). It implies a rootfs clone (see
+// RootfsCloneAnnotation), whose upper layer gets the snapshot's rootfs; if the
+// snapshot only holds the changes to the rootfs, the container's rootfs must
+// be that of the snapshotted container. The snapshot's dirs backed by
+// sysbox-mgr (e.g., /var/lib/docker) seed those of the container, unless the
+// spec mounts something else there. The snapshot dir must be under one of the
+// host's snapshot dirs (see the --snapshot-dirs flag), and its files are only
+// read from within it (symlinks aren't followed). Requires sysbox-mgr.
+const SeedSnapshotAnnotation = "io.nestybox.sysbox.seed-snapshot"
+
+// GetSeedSnapshot returns the snapshot dir given by the container's
+// seed-snapshot annotation, or an empty string if not set.
+func GetSeedSnapshot(annotations map[string]string) (string, error) {
+	val := annotations[SeedSnapshotAnnotation]
+	if val == "" {
+		return "", nil
+	}
+	if !filepath.IsAbs(val) {
+		return "", fmt.Errorf("invalid value for annotation %s: %q (must be an absolute path)", SeedSnapshotAnnotation, val)
+	}
+	return filepath.Clean(val), nil
+}
+
+// CoreDumpAnnotation enables the capture of the sys container's core dumps into
+// a host dir of the container, under the core dump base dir set on the host
+// (see the --core-dump-dir flag); the dir is mounted at CoreDumpMountpoint in
+// the container, and owned by the container's root user. Its value is "true",
+// or a comma separated list of "<key>=<value>" entries:
+//
+// "max-size": the max size of a core dump (e.g., "512M"); larger ones are
+// discarded. Defaults to 1G.
+// "max-total": the max total size of the core dumps in the dir; once reached,
+// further core dumps are discarded. Defaults to no limit.
+//
+// The core dumps are captured by the sysbox-runc core dump handler, which must
+// be set as the host's core_pattern (see the "coredump" command). The
+// container's /proc/sys/kernel/core_pattern shows where they are stored.
+const CoreDumpAnnotation = "io.nestybox.sysbox.core-dump"
+
+const (
+	// CoreDumpMountpoint is where the core dump dir is mounted in the container.
+	CoreDumpMountpoint = "/var/crash"
+
+	// CoreDumpPattern is the file name pattern of the core dumps (see core(5)).
+	CoreDumpPattern = "core.%e.%p.%t"
+
+	defaultCoreDumpMaxSize = 1 << 30
+)
+
+// GetCoreDump returns the core dump capture config given by the container's
+// annotations, or nil if not set.
+func GetCoreDump(annotations map[string]string) (*configs.CoreDump, error) {
+	val := annotations[CoreDumpAnnotation]
+	if val == "" {
+		return nil, nil
+	}
+
+	cd := &configs.CoreDump{
+		Pattern: filepath.Join(CoreDumpMountpoint, CoreDumpPattern),
+		MaxSize: defaultCoreDumpMaxSize,
+	}
+
+	if val == "true" {
+		return cd, nil
+	}
+
+	for _, entry := range strings.Split(val, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("annotation %s: invalid entry %q (must be <key>=<value>)", CoreDumpAnnotation, entry)
+		}
+
+		switch kv[0] {
+		case "dir":
+			// the core dump dir is chown'ed to the container's root user and
+			// mounted in it, so it can't be chosen by the spec
+			return nil, fmt.Errorf("annotation %s: the core dump dir can't be set by the spec (it's under the host's --core-dump-dir)",
+				CoreDumpAnnotation)
+		case "max-size", "max-total":
+			size, err := units.RAMInBytes(kv[1])
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("annotation %s: invalid %s %q", CoreDumpAnnotation, kv[0], kv[1])
+			}
+			if kv[0] == "max-size" {
+				cd.MaxSize = size
+			} else {
+				cd.MaxTotal = size
+			}
+		default:
+			return nil, fmt.Errorf("annotation %s: unknown key %q (must be \"max-size\" or \"max-total\")",
+				CoreDumpAnnotation, kv[0])
+		}
+	}
+
+	return cd, nil
+}
+
+// KernelModulesAnnotation restricts the sys container's view of the host's
+// kernel modules (i.e., /proc/modules and /sys/module), which tools in the
+// container read to check for kernel features. Its value is one of:
+//
+// "host" (the default): the host's kernel modules are shown.
+// "none": no kernel modules are shown (an empty, but valid, view).
+// A comma separated list of module names: only those modules are shown (if
+// loaded in the host); dashes in the names are equivalent to underscores, as
+// with modprobe.
+//
+// The restricted view is read-only, and reflects the modules loaded in the
+// host when the container starts.
+const KernelModulesAnnotation = "io.nestybox.sysbox.kernel-modules"
+
+// GetKernelModules returns the kernel modules view given by the container's
+// annotations, or nil if the host's view is shown.
+func GetKernelModules(annotations map[string]string) (*configs.KernelModules, error) {
+	val := strings.TrimSpace(annotations[KernelModulesAnnotation])
+
+	switch val {
+	case "", "host":
+		return nil, nil
+	case "none":
+		return &configs.KernelModules{}, nil
+	}
+
+	km := &configs.KernelModules{}
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(val, ",") {
+		name = strings.ReplaceAll(strings.TrimSpace(name), "-", "_")
+		if !validModuleName(name) {
+			return nil, fmt.Errorf("annotation %s: invalid module name %q", KernelModulesAnnotation, name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			km.Allowed = append(km.Allowed, name)
+		}
+	}
+
+	return km, nil
+}
+
+func validModuleName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// CfgKernelModuleMounts removes the spec mounts under /sys/module that belong
+// to modules hidden by the given kernel modules view (e.g., the sysbox-fs
+// mount of nf_conntrack's hashsize parameter).
+func CfgKernelModuleMounts(spec *specs.Spec, km *configs.KernelModules) {
+	if km == nil {
+		return
+	}
+
+	allowed := map[string]bool{}
+	for _, m := range km.Allowed {
+		allowed[m] = true
+	}
+
+	mounts := []specs.Mount{}
+	for _, m := range spec.Mounts {
+		dest := filepath.Clean(m.Destination)
+		if strings.HasPrefix(dest, "/sys/module/") {
+			mod := strings.SplitN(strings.TrimPrefix(dest, "/sys/module/"), "/", 2)[0]
+			if !allowed[mod] {
+				logrus.Debugf("removing mount %s (module %s is hidden)", m.Destination, mod)
+				continue
+			}
+		}
+		mounts = append(mounts, m)
+	}
+	spec.Mounts = mounts
+}
+
+// SysKernelMountsAnnotation selects the dummy (tmpfs) mounts that sysbox sets
+// up on the sys container's /sys/kernel/config, /sys/kernel/debug and
+// /sys/kernel/tracing, as it doesn't virtualize configfs, debugfs and tracefs.
+// Its value is one of:
+//
+// "auto" (default): all of them, unless the sys container is detected to run
+// sysbox itself (see nestedSysbox()), in which case none, as they would get in
+// the way of the mounts that the nested containers need there.
+// "all" or "none".
+// A comma separated list of "config", "debug" and "tracing".
+//
+// Where there's no dummy mount, the spec's mount (if any) is kept; otherwise
+// the dir is that of the container's sysfs.
+const SysKernelMountsAnnotation = "io.nestybox.sysbox.sys-kernel-mounts"
+
+// sysKernelMountNames are the names (i.e., the dirs under /sys/kernel) of the
+// dummy mounts selected via SysKernelMountsAnnotation.
+var sysKernelMountNames = []string{"config", "debug", "tracing"}
+
+// GetSysKernelMounts returns the destinations of the dummy mounts under
+// /sys/kernel that the sys container gets, per its annotations.
+func GetSysKernelMounts(spec *specs.Spec) ([]string, error) {
+	val := strings.TrimSpace(spec.Annotations[SysKernelMountsAnnotation])
+
+	names := []string{}
+	switch val {
+	case "", "auto":
+		if nestedSysbox(spec) {
+			logrus.Debugf("nested sysbox detected; skipping the dummy mounts under /sys/kernel")
+			return []string{}, nil
+		}
+		names = sysKernelMountNames
+	case "all":
+		names = sysKernelMountNames
+	case "none":
+	default:
+		for _, name := range strings.Split(val, ",") {
+			name = strings.TrimSpace(name)
+			if !utils.StringSliceContains(sysKernelMountNames, name) {
+				return nil, fmt.Errorf("annotation %s: invalid mount %q (must be one of: %s)",
+					SysKernelMountsAnnotation, name, strings.Join(sysKernelMountNames, ", "))
+			}
+			if !utils.StringSliceContains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	dests := []string{}
+	for _, name := range names {
+		dests = append(dests, filepath.Join("/sys/kernel", name))
+	}
+	return dests, nil
+}
+
+// nestedSysbox reports whether the sys container is expected to run sysbox
+// itself, i.e., whether it mounts a sysbox data dir (/var/lib/sysbox), or
+// sysbox-runc itself runs inside a sys container (so containers it creates
+// are nested ones).
+func nestedSysbox(spec *specs.Spec) bool {
+	for _, m := range spec.Mounts {
+		if filepath.Clean(m.Destination) == "/var/lib/sysbox" {
+			return true
+		}
+	}
+	return inSysContainer()
+}
+
+// inSysContainer reports whether sysbox-runc runs inside a sys container, as
+// told by the dummy tmpfs mount on its /sys/kernel/tracing (tracefs is never
+// a tmpfs otherwise). It's a variable so that tests can replace it.
+var inSysContainer = func() bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs("/sys/kernel/tracing", &st); err != nil {
+		return false
+	}
+	return st.Type == unix.TMPFS_MAGIC
+}
+
+// ConfigfsAnnotation selects how the sys container's /sys/kernel/config
+// (configfs, which isn't namespaced) is set up. Its value is one of:
+//
+// "dummy" (default): a dummy tmpfs, as selected by SysKernelMountsAnnotation.
+// "sysbox-fs": it's emulated by sysbox-fs, which exposes the parts of the
+// host's configfs that sys containers can safely use. Requires sysbox-fs
+// support.
+// "passthrough:<subtree>[,<subtree>...]": a dummy tmpfs, with the given
+// subtrees of the host's configfs (e.g., "nvmet" for NVMe-oF targets)
+// bind-mounted read-only on it, so that tools can inspect the host's
+// configuration; the subtrees must be among configfsPassthroughSubtrees, and
+// their modules must be loaded in the host. As the subtrees hold the targets'
+// authentication secrets, the host's allowlist must allow each of them to the
+// container (see ConfigfsPassthroughAllowed()).
+const ConfigfsAnnotation = "io.nestybox.sysbox.configfs"
+
+type ConfigfsMode string
+
+const (
+	ConfigfsDummy       ConfigfsMode = "dummy"
+	ConfigfsSysboxFs    ConfigfsMode = "sysbox-fs"
+	ConfigfsPassthrough ConfigfsMode = "passthrough"
+)
+
+const configfsDir = "/sys/kernel/config"
+
+// configfsPassthroughSubtrees are the subtrees of the host's configfs that may
+// be passed through to sys containers (if the host allows them): those of
+// storage targets.
+var configfsPassthroughSubtrees = []string{"nvmet", "target"}
+
+// GetConfigfs returns the configfs mode given by the container's annotations,
+// along with the subtrees passed through (if any).
+func GetConfigfs(annotations map[string]string) (ConfigfsMode, []string, error) {
+	val := strings.TrimSpace(annotations[ConfigfsAnnotation])
+
+	switch ConfigfsMode(val) {
+	case "", ConfigfsDummy:
+		return ConfigfsDummy, nil, nil
+	case ConfigfsSysboxFs:
+		return ConfigfsSysboxFs, nil, nil
+	}
+
+	prefix := string(ConfigfsPassthrough) + ":"
+	if !strings.HasPrefix(val, prefix) {
+		return "", nil, fmt.Errorf("invalid value for annotation %s: %q (must be %q, %q or \"%s<subtree>[,<subtree>...]\")",
+			ConfigfsAnnotation, val, ConfigfsDummy, ConfigfsSysboxFs, prefix)
+	}
+
+	subtrees := []string{}
+	for _, st := range strings.Split(strings.TrimPrefix(val, prefix), ",") {
+		st = strings.TrimSpace(st)
+		if !utils.StringSliceContains(configfsPassthroughSubtrees, st) {
+			return "", nil, fmt.Errorf("annotation %s: invalid subtree %q (must be one of: %s)",
+				ConfigfsAnnotation, st, strings.Join(configfsPassthroughSubtrees, ", "))
+		}
+		if !utils.StringSliceContains(subtrees, st) {
+			subtrees = append(subtrees, st)
+		}
+	}
+	return ConfigfsPassthrough, subtrees, nil
+}
+
+// cfgConfigfs sets up the sys container's configfs mounts for the given mode
+// (see ConfigfsAnnotation); the dummy mount (if any) must be set up already.
+func cfgConfigfs(spec *specs.Spec, sysFs *sysbox.Fs, mode ConfigfsMode, subtrees []string) error {
+	switch mode {
+	case ConfigfsSysboxFs:
+		if !sysFs.Enabled() {
+			return fmt.Errorf("annotation %s: mode %q requires sysbox-fs", ConfigfsAnnotation, mode)
+		}
+		if !sysFs.Caps.Has(sysbox.FeatFsConfigfs) {
+			return fmt.Errorf("annotation %s: sysbox-fs %s lacks feature %q", ConfigfsAnnotation, sysFs.Caps.Version, sysbox.FeatFsConfigfs)
+		}
+
+		mountpoint := sysFs.Mountpoint
+		if mountpoint == "" {
+			mountpoint = SysboxFsDir
+		}
+		m := specs.Mount{
+			Destination: configfsDir,
+			Source:      filepath.Join(mountpoint, sysFs.Id, configfsDir),
+			Type:        "bind",
+			Options:     []string{"rbind", "rprivate"},
+		}
+		spec.Mounts = utils.MountSliceRemove(spec.Mounts, []specs.Mount{m}, func(m1, m2 specs.Mount) bool {
+			return filepath.Clean(m1.Destination) == m2.Destination
+		})
+		// as with the other sysbox-fs mounts (see cfgSysboxFsMounts())
+		if spec.Root.Readonly && !utils.StringSliceContains(spec.Linux.ReadonlyPaths, configfsDir) {
+			spec.Linux.ReadonlyPaths = append(spec.Linux.ReadonlyPaths, configfsDir)
+		}
+		spec.Mounts = append(spec.Mounts, m)
+
+	case ConfigfsPassthrough:
+		for _, st := range subtrees {
+			dest := filepath.Join(configfsDir, st)
+			for _, m := range spec.Mounts {
+				if filepath.Clean(m.Destination) == dest {
+					return fmt.Errorf("annotation %s: the spec already has a mount at %s", ConfigfsAnnotation, dest)
+				}
+			}
+			spec.Mounts = append(spec.Mounts, specs.Mount{
+				Destination: dest,
+				Source:      dest,
+				Type:        "bind",
+				Options:     []string{"rbind", "ro", "rprivate", "nosuid", "noexec", "nodev"},
+			})
+		}
+	}
+
+	return nil
+}
+
+// MaxLifetimeAnnotation sets the max lifetime of the sys container (e.g.,
+// "72h"), counted from its creation; once it expires, the container is stopped
+// and deleted by the "reap" command. Defaults to no limit.
+const MaxLifetimeAnnotation = "io.nestybox.sysbox.max-lifetime"
+
+// GetMaxLifetime returns the max lifetime given by the container's
+// annotations, or 0 if not set.
+func GetMaxLifetime(annotations map[string]string) (time.Duration, error) {
+	val := annotations[MaxLifetimeAnnotation]
+	if val == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid value for annotation %s: %q (must be a positive duration, e.g., \"72h\")",
+			MaxLifetimeAnnotation, val)
+	}
+
+	return d, nil
+}
+
+// BootstrapTimeoutAnnotation bounds the bootstrap of the sys container's init
+// process (e.g., "30s"), from its start up to the exec of the container's
+// process (or, for a template, up to it being held). Once it expires, the init
+// process is killed and the create fails with diagnostics of where the
+// bootstrap hung (e.g., on a mount that never completes). Defaults to no
+// limit.
+const BootstrapTimeoutAnnotation = "io.nestybox.sysbox.bootstrap-timeout"
+
+// GetBootstrapTimeout returns the bootstrap timeout given by the container's
+// annotations, or 0 if not set.
+func GetBootstrapTimeout(annotations map[string]string) (time.Duration, error) {
+	val := annotations[BootstrapTimeoutAnnotation]
+	if val == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid value for annotation %s: %q (must be a positive duration, e.g., \"30s\")",
+			BootstrapTimeoutAnnotation, val)
+	}
+
+	return d, nil
+}
+
+// HostConfigsAnnotation mounts host configs needed for enterprise auth (e.g.,
+// logins via Kerberos or SSSD) in the sys container, at the same paths. Its
+// value is a comma separated list of:
+//
+// "ca": the CA certificates (see hostConfigPaths for the paths of each).
+// "krb5": the Kerberos config.
+// "sssd": the SSSD config.
+// "ldap": the OpenLDAP client config.
+//
+// The configs are mounted read-only from copies in the container's state dir,
+// owned by the container's root user (as some, e.g., sssd.conf, are readable by
+// the host's root only); symlinks are followed when copying. Host paths that
+// don't exist when the container starts, or on which the spec has a mount, are
+// skipped. The copies are refreshed with the "refresh-host-configs" command.
+const HostConfigsAnnotation = "io.nestybox.sysbox.host-configs"
+
+// hostConfigPaths are the host paths of each host config selectable via
+// HostConfigsAnnotation.
+var hostConfigPaths = map[string][]string{
+	"ca":   {"/etc/ssl/certs", "/etc/pki/tls/certs", "/etc/pki/ca-trust"},
+	"krb5": {"/etc/krb5.conf", "/etc/krb5.conf.d"},
+	"sssd": {"/etc/sssd"},
+	"ldap": {"/etc/ldap/ldap.conf", "/etc/openldap/ldap.conf"},
+}
+
+// GetHostConfigs returns the host configs given by the container's
+// annotations (see HostConfigsAnnotation), or nil if there are none; the
+// paths on which the spec has a mount are left out.
+func GetHostConfigs(spec *specs.Spec) (*configs.HostConfigs, error) {
+	val := strings.TrimSpace(spec.Annotations[HostConfigsAnnotation])
+	if val == "" {
+		return nil, nil
+	}
+
+	mounted := map[string]bool{}
+	for _, m := range spec.Mounts {
+		mounted[filepath.Clean(m.Destination)] = true
+	}
+
+	hc := &configs.HostConfigs{}
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(val, ",") {
+		name = strings.TrimSpace(name)
+		paths, ok := hostConfigPaths[name]
+		if !ok {
+			return nil, fmt.Errorf("annotation %s: invalid host config %q (must be \"ca\", \"krb5\", \"sssd\" or \"ldap\")",
+				HostConfigsAnnotation, name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		for _, p := range paths {
+			if mounted[p] {
+				logrus.Debugf("not mounting host config %s (the spec has a mount on it)", p)
+				continue
+			}
+			hc.Paths = append(hc.Paths, p)
+		}
+	}
+
+	return hc, nil
+}
+
+// SSHHostKeysAnnotation is the dir holding the SSH host keys of the sys
+// container (i.e., its ssh_host_*_key files, and their .pub files), e.g., for
+// an sshd started within it, relative to the SSH key store set on the host
+// (see the --ssh-keys-dir flag). They're copied to the container's /etc/ssh
+// before it starts, owned by the container's root user, with mode 0600 (0644
+// for the .pub files). Other files in the dir are ignored.
+const SSHHostKeysAnnotation = "io.nestybox.sysbox.ssh-host-keys"
+
+// SSHAuthorizedKeysAnnotation is a comma separated list of
+// "<file>[:<user>]", where each file is relative to the SSH key store set on
+// the host (see the --ssh-keys-dir flag): each file is copied to the
+// ~/.ssh/authorized_keys file of the given container user (root by default)
+// before the container starts, with mode 0600 (0700 for ~/.ssh, if created),
+// owned by the user and its primary group. Users are looked up in the
+// container's /etc/passwd.
+const SSHAuthorizedKeysAnnotation = "io.nestybox.sysbox.ssh-authorized-keys"
+
+// GetSSHKeys returns the SSH keys given by the container's annotations (see
+// SSHHostKeysAnnotation and SSHAuthorizedKeysAnnotation), or nil if there are
+// none.
+func GetSSHKeys(spec *specs.Spec) (*configs.SSHKeys, error) {
+	hostKeys := strings.TrimSpace(spec.Annotations[SSHHostKeysAnnotation])
+	authKeys := strings.TrimSpace(spec.Annotations[SSHAuthorizedKeysAnnotation])
+	if hostKeys == "" && authKeys == "" {
+		return nil, nil
+	}
+
+	keys := &configs.SSHKeys{}
+
+	if hostKeys != "" {
+		if !keyStorePath(hostKeys) {
+			return nil, fmt.Errorf("annotation %s: %q is not a path within the SSH key store",
+				SSHHostKeysAnnotation, hostKeys)
+		}
+		keys.HostKeysDir = filepath.Clean(hostKeys)
+	}
+
+	if authKeys == "" {
+		return keys, nil
+	}
+
+	users := map[string]bool{}
+	for _, entry := range strings.Split(authKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		path, user := entry, "root"
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			path, user = entry[:i], entry[i+1:]
+		}
+		if !keyStorePath(path) {
+			return nil, fmt.Errorf("annotation %s: %q is not a path within the SSH key store",
+				SSHAuthorizedKeysAnnotation, path)
+		}
+		if user == "" || strings.ContainsAny(user, "/ ") {
+			return nil, fmt.Errorf("annotation %s: invalid user %q",
+				SSHAuthorizedKeysAnnotation, user)
+		}
+		if users[user] {
+			return nil, fmt.Errorf("annotation %s: user %q given more than once",
+				SSHAuthorizedKeysAnnotation, user)
+		}
+		users[user] = true
+
+		keys.AuthorizedKeys = append(keys.AuthorizedKeys, configs.AuthorizedKeys{
+			Path: filepath.Clean(path),
+			User: user,
+		})
+	}
+
+	return keys, nil
+}
+
+// keyStorePath returns true if the given path is relative to the SSH key
+// store, and within it.
+func keyStorePath(path string) bool {
+	path = filepath.Clean(path)
+	return !filepath.IsAbs(path) && path != "." && path != ".." && !strings.HasPrefix(path, "../")
+}
+
+// NetworksAnnotation attaches the sys container to the networks of host
+// interfaces, for when it isn't set up by a container engine (e.g., when
+// sysbox-runc is used standalone). Its value is a JSON list of interfaces,
+// each with:
+//
+// "type": "macvlan" or "ipvlan".
+// "name": the interface's name in the container (e.g., "eth0").
+// "parent": the host interface it's attached to.
+// "mode": the macvlan ("bridge" (default), "vepa", "private" or "passthru") or
+// ipvlan ("l2" (default), "l3" or "l3s") mode.
+// "mac_address", "mtu", "txqueuelen": the interface's link attributes
+// (optional; ipvlan interfaces have their parent's MAC address).
+// "address", "ipv6_address": the interface's static addresses, in CIDR
+// notation (optional).
+// "gateway", "ipv6_gateway": the default gateways (optional).
+// "routes": a list of static routes via the interface, each with a
+// "destination" in CIDR notation, and an optional "gateway" and "source".
+//
+// For example: [{"type": "macvlan", "name": "eth0", "parent": "enp3s0",
+// "address": "192.168.1.50/24", "gateway": "192.168.1.1"}]. The container must
+// have a private network namespace.
+const NetworksAnnotation = "io.nestybox.sysbox.networks"
+
+// GetNetworks returns the networks given by the container's annotations (see
+// NetworksAnnotation), or nil if there are none.
+func GetNetworks(spec *specs.Spec) ([]*configs.Network, error) {
+	val := strings.TrimSpace(spec.Annotations[NetworksAnnotation])
+	if val == "" {
+		return nil, nil
+	}
+
+	var networks []*configs.Network
+	dec := json.NewDecoder(strings.NewReader(val))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&networks); err != nil {
+		return nil, fmt.Errorf("invalid value for annotation %s: %v", NetworksAnnotation, err)
+	}
+
+	if !privateNetNs(spec) {
+		return nil, fmt.Errorf("annotation %s requires a private network namespace", NetworksAnnotation)
+	}
+
+	names := map[string]bool{"lo": true}
+	for _, n := range networks {
+		if n.Type != "macvlan" && n.Type != "ipvlan" {
+			return nil, fmt.Errorf("annotation %s: invalid interface type %q (must be \"macvlan\" or \"ipvlan\")",
+				NetworksAnnotation, n.Type)
+		}
+		if n.Name == "" || n.Parent == "" {
+			return nil, fmt.Errorf("annotation %s: %s interfaces require a name and a parent", NetworksAnnotation, n.Type)
+		}
+		if names[n.Name] {
+			return nil, fmt.Errorf("annotation %s: interface name %q given more than once", NetworksAnnotation, n.Name)
+		}
+		names[n.Name] = true
+		if n.Bridge != "" || n.HostInterfaceName != "" || n.HairpinMode {
+			return nil, fmt.Errorf("annotation %s: interface %s: bridge, host_interface_name and hairpin_mode only apply to veth interfaces",
+				NetworksAnnotation, n.Name)
+		}
+	}
+
+	return networks, nil
+}
+
+// PortForwardsAnnotation forwards host TCP ports to the sys container, for when
+// it isn't done by a container engine (e.g., when sysbox-runc is used
+// standalone). Its value is a comma separated list of
+// "[<host-ip>:]<host-port>:<container-port>[/tcp]" (e.g., "8080:80" or
+// "127.0.0.1:2222:22"; IPv6 host IPs go in brackets). The host IP defaults to
+// all of the host's addresses.
+//
+// The ports are forwarded by a userspace proxy that connects to the container's
+// ports from within its network namespace (so they can be bound to the
+// container's loopback address), and exits along with the container's init
+// process. The container must have a private network namespace.
+const PortForwardsAnnotation = "io.nestybox.sysbox.port-forwards"
+
+// PortForward is a host TCP port forwarded to a sys container port.
+type PortForward struct {
+	HostIP        string
+	HostPort      uint16
+	ContainerPort uint16
+}
+
+// GetPortForwards returns the port forwards given by the container's
+// annotations (see PortForwardsAnnotation), or nil if there are none.
+func GetPortForwards(spec *specs.Spec) ([]PortForward, error) {
+	val := strings.TrimSpace(spec.Annotations[PortForwardsAnnotation])
+	if val == "" {
+		return nil, nil
+	}
+	if !privateNetNs(spec) {
+		return nil, fmt.Errorf("annotation %s requires a private network namespace", PortForwardsAnnotation)
+	}
+
+	var fwds []PortForward
+	hostAddrs := map[string]bool{}
+
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if i := strings.LastIndex(entry, "/"); i >= 0 {
+			if proto := entry[i+1:]; proto != "tcp" {
+				return nil, fmt.Errorf("annotation %s: unsupported protocol %q in %q (only tcp is)",
+					PortForwardsAnnotation, proto, entry)
+			}
+			entry = entry[:i]
+		}
+
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("annotation %s: invalid port forward %q (must be [<host-ip>:]<host-port>:<container-port>)",
+				PortForwardsAnnotation, entry)
+		}
+		host, cport := entry[:i], entry[i+1:]
+
+		var fwd PortForward
+		hport := host
+		if strings.Contains(host, ":") {
+			var err error
+			if fwd.HostIP, hport, err = net.SplitHostPort(host); err != nil || net.ParseIP(fwd.HostIP) == nil {
+				return nil, fmt.Errorf("annotation %s: invalid host address in %q", PortForwardsAnnotation, entry)
+			}
+		}
+
+		p, err := strconv.ParseUint(hport, 10, 16)
+		if err != nil || p == 0 {
+			return nil, fmt.Errorf("annotation %s: invalid host port in %q", PortForwardsAnnotation, entry)
+		}
+		fwd.HostPort = uint16(p)
+		if p, err = strconv.ParseUint(cport, 10, 16); err != nil || p == 0 {
+			return nil, fmt.Errorf("annotation %s: invalid container port in %q", PortForwardsAnnotation, entry)
+		}
+		fwd.ContainerPort = uint16(p)
+
+		addr := net.JoinHostPort(fwd.HostIP, hport)
+		if hostAddrs[addr] {
+			return nil, fmt.Errorf("annotation %s: host address %s given more than once", PortForwardsAnnotation, addr)
+		}
+		hostAddrs[addr] = true
+
+		fwds = append(fwds, fwd)
+	}
+
+	return fwds, nil
+}
+
+// NetStackAnnotation sets up the network stack of the sys container's network
+// namespace, before its init process starts:
+//
+// "default": the kernel's defaults.
+// "dual-stack": IPv4 and IPv6, with forwarding enabled for both (as for nested
+// Kubernetes nodes), and IPv6 router advertisements accepted despite it (see
+// dualStackSysctls).
+//
+// The sysctls set in the spec take precedence.
+const NetStackAnnotation = "io.nestybox.sysbox.net-stack"
+
+// dualStackSysctls are the sysctls of the "dual-stack" network stack.
+var dualStackSysctls = map[string]string{
+	"net.ipv6.conf.all.disable_ipv6":     "0",
+	"net.ipv6.conf.default.disable_ipv6": "0",
+	"net.ipv4.ip_forward":                "1",
+	"net.ipv6.conf.all.forwarding":       "1",
+	"net.ipv6.conf.default.forwarding":   "1",
+	"net.ipv6.conf.all.accept_ra":        "2",
+	"net.ipv6.conf.default.accept_ra":    "2",
+}
+
+// hostIPv6Dir is where the host's IPv6 sysctls are, absent if IPv6 is disabled
+// in the kernel (e.g., via the "ipv6.disable=1" boot option).
+var hostIPv6Dir = "/proc/sys/net/ipv6"
+
+// cfgNetStack sets up the sysctls of the sys container's network stack (see
+// NetStackAnnotation). If hostDeps is set, the host kernel is checked to
+// support it.
+func cfgNetStack(spec *specs.Spec, hostDeps bool) error {
+	val := spec.Annotations[NetStackAnnotation]
+	switch val {
+	case "", "default":
+		return nil
+	case "dual-stack":
+	default:
+		return fmt.Errorf("invalid value for annotation %s: %q (must be \"default\" or \"dual-stack\")",
+			NetStackAnnotation, val)
+	}
+
+	if hostDeps {
+		if _, err := os.Stat(hostIPv6Dir); err != nil {
+			return fmt.Errorf("annotation %s: %q requires IPv6, which is disabled in the host's kernel", NetStackAnnotation, val)
+		}
+	}
+
+	if spec.Linux.Sysctl == nil {
+		spec.Linux.Sysctl = make(map[string]string)
+	}
+	for key, val := range dualStackSysctls {
+		if _, ok := spec.Linux.Sysctl[key]; !ok {
+			spec.Linux.Sysctl[key] = val
+		}
+	}
+
+	return nil
+}
+
+// privateNetNs returns true if the given spec has a network namespace of its
+// own (i.e., not one it joins).
+func privateNetNs(spec *specs.Spec) bool {
+	if spec.Linux == nil {
+		return false
+	}
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.NetworkNamespace {
+			return ns.Path == ""
+		}
+	}
+	return false
+}
+
+// CpuCoherenceAnnotation sets how the CPU count seen by the sys container's
+// workloads is made coherent with its cgroup CPU limits:
+//
+// "off" (default): nothing is done.
+// "env": the container's init process environment defaults the common
+// thread-pool sizing knobs to the number of CPUs the container's limits allow
+// (i.e., its cpuset size, or its CPU quota rounded up, whichever is lower):
+// GOMAXPROCS, OMP_NUM_THREADS, and the JVM's -XX:ActiveProcessorCount (via
+// JAVA_TOOL_OPTIONS).
+//
+// Language runtimes size their thread pools from /proc/cpuinfo or the CPU
+// affinity mask, which don't reflect CPU quotas; with many such containers on a
+// host, the result is heavy CPU throttling. Knobs already set in the process
+// spec are honored, and nothing is set if the container has no CPU limits. As
+// with any env var, processes in the container are free to change them.
+const CpuCoherenceAnnotation = "io.nestybox.sysbox.cpu-coherence"
+
+type CpuCoherenceMode string
+
+const (
+	CpuCoherenceOff CpuCoherenceMode = "off"
+	CpuCoherenceEnv CpuCoherenceMode = "env"
+)
+
+// GetCpuCoherenceMode returns the CPU count coherence mode given by the
+// container's annotations.
+func GetCpuCoherenceMode(annotations map[string]string) (CpuCoherenceMode, error) {
+	val, ok := annotations[CpuCoherenceAnnotation]
+	if !ok {
+		return CpuCoherenceOff, nil
+	}
+
+	mode := CpuCoherenceMode(val)
+	switch mode {
+	case CpuCoherenceOff, CpuCoherenceEnv:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("invalid value for annotation %s: %q (must be %q or %q)",
+		CpuCoherenceAnnotation, val, CpuCoherenceOff, CpuCoherenceEnv)
+}
+
+// SignalMapAnnotation translates the signals that sysbox-runc forwards to the
+// sys container's init process when run in the foreground (e.g., by "run"
+// without "--detach"). Its value is a comma separated list of
+// <signal>=<signal> pairs (e.g., "SIGTERM=SIGRTMIN+3"), or "none". Defaults to
+// "SIGTERM=SIGRTMIN+3" for sys containers that run systemd, as systemd halts
+// on SIGRTMIN+3 (while it re-executes itself on SIGTERM), and to "none" for
+// others.
+const SignalMapAnnotation = "io.nestybox.sysbox.signal-map"
+
+const systemdSignalMap = "SIGTERM=SIGRTMIN+3"
+
+// GetSignalMap returns the signal map of the given sys container spec (see
+// SignalMapAnnotation); nil if the signals are forwarded as is.
+func GetSignalMap(spec *specs.Spec) (map[unix.Signal]unix.Signal, error) {
+	val, ok := spec.Annotations[SignalMapAnnotation]
+	if !ok {
+		if spec.Process == nil || !systemdContainer(spec) {
+			return nil, nil
+		}
+		val = systemdSignalMap
+	}
+	if val == "none" {
+		return nil, nil
+	}
+
+	sigMap := make(map[unix.Signal]unix.Signal)
+	for _, pair := range strings.Split(val, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid value for annotation %s: %q (must be a list of <signal>=<signal>, or \"none\")",
+				SignalMapAnnotation, val)
+		}
+		from, err := ParseSignal(kv[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for annotation %s: %v", SignalMapAnnotation, err)
+		}
+		to, err := ParseSignal(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for annotation %s: %v", SignalMapAnnotation, err)
+		}
+		if from == 0 || to == 0 {
+			return nil, fmt.Errorf("invalid value for annotation %s: %q (signal 0 can't be mapped)", SignalMapAnnotation, pair)
+		}
+		if from == unix.SIGCHLD || from == unix.SIGWINCH {
+			return nil, fmt.Errorf("invalid value for annotation %s: %s isn't forwarded", SignalMapAnnotation, kv[0])
+		}
+		sigMap[from] = to
+	}
+
+	return sigMap, nil
+}
+
+// SigRtMin and SigRtMax are the range of real-time signals available to
+// applications (glibc reserves the first two of the kernel's).
+const (
+	SigRtMin = 34
+	SigRtMax = 64
+)
+
+// ParseSignal parses the given signal name (with or without the "SIG" prefix,
+// and including "SIGRTMIN+<n>" and "SIGRTMAX-<n>") or number (0 being the null
+// signal, see kill(2)).
+func ParseSignal(val string) (unix.Signal, error) {
+	val = strings.TrimSpace(val)
+	if n, err := strconv.Atoi(val); err == nil {
+		if n < 0 || n > SigRtMax {
+			return 0, fmt.Errorf("invalid signal %q", val)
+		}
+		return unix.Signal(n), nil
+	}
+
+	name := strings.ToUpper(val)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	var base, off int
+	switch {
+	case strings.HasPrefix(name, "SIGRTMIN"):
+		base, name = SigRtMin, strings.TrimPrefix(name, "SIGRTMIN")
+	case strings.HasPrefix(name, "SIGRTMAX"):
+		base, name = SigRtMax, strings.TrimPrefix(name, "SIGRTMAX")
+	default:
+		sig := unix.SignalNum(name)
+		if sig == 0 {
+			return 0, fmt.Errorf("unknown signal %q", val)
+		}
+		return sig, nil
+	}
+
+	if name != "" {
+		var err error
+		if off, err = strconv.Atoi(name); err != nil || (name[0] != '+' && name[0] != '-') {
+			return 0, fmt.Errorf("unknown signal %q", val)
+		}
+	}
+	sig := base + off
+	if sig < SigRtMin || sig > SigRtMax {
+		return 0, fmt.Errorf("invalid signal %q (out of the real-time signal range)", val)
+	}
+	return unix.Signal(sig), nil
+}
+
+// FsNotifyQuotaAnnotation limits the inotify and fanotify resources of the
+// sys container's processes, which sysbox-fs accounts for per container (so
+// that a container can't exhaust the host's per-user limits, which all
+// containers share). Its value is a comma separated list of <key>=<value>
+// pairs, with keys:
+//
+// "inotify-watches": the max number of inotify watches.
+// "inotify-instances": the max number of inotify instances.
+// "fanotify-marks": the max number of fanotify marks.
+// "fanotify-groups": the max number of fanotify groups.
+//
+// Omitted keys (or a zero value) mean no per-container limit. The quota can be
+// changed at runtime with the "update" command. Requires a sysbox-fs client
+// that supports it (see sysbox.FsNotifyQuotaSetter); the default gRPC client
+// doesn't, so containers with this annotation are rejected.
+const FsNotifyQuotaAnnotation = "io.nestybox.sysbox.fsnotify-quota"
+
+// GetFsNotifyQuota returns the inotify and fanotify quota given by the
+// container's annotations, or nil if not set.
+func GetFsNotifyQuota(annotations map[string]string) (*configs.FsNotifyQuota, error) {
+	val := strings.TrimSpace(annotations[FsNotifyQuotaAnnotation])
+	if val == "" {
+		return nil, nil
+	}
+
+	q := &configs.FsNotifyQuota{}
+	fields := FsNotifyQuotaFields(q)
+
+	for _, entry := range strings.Split(val, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("annotation %s: invalid entry %q (must be <key>=<value>)", FsNotifyQuotaAnnotation, entry)
+		}
+		dest, ok := fields[kv[0]]
+		if !ok {
+			return nil, fmt.Errorf("annotation %s: unknown key %q (must be \"inotify-watches\", \"inotify-instances\", \"fanotify-marks\" or \"fanotify-groups\")",
+				FsNotifyQuotaAnnotation, kv[0])
+		}
+		n, err := strconv.ParseUint(kv[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("annotation %s: invalid %s %q", FsNotifyQuotaAnnotation, kv[0], kv[1])
+		}
+		*dest = n
+	}
+
+	return q, nil
+}
+
+// FsNotifyQuotaFields maps the keys of FsNotifyQuotaAnnotation (also the
+// names of the "update" command's options) to the fields of the given quota.
+func FsNotifyQuotaFields(q *configs.FsNotifyQuota) map[string]*uint64 {
+	return map[string]*uint64{
+		"inotify-watches":   &q.InotifyWatches,
+		"inotify-instances": &q.InotifyInstances,
+		"fanotify-marks":    &q.FanotifyMarks,
+		"fanotify-groups":   &q.FanotifyGroups,
+	}
+}
+
+// LogLevelAnnotation overrides the global log level (e.g., --debug) for the
+// sysbox-runc commands on the sys container (e.g., "debug", to debug a
+// container on a busy host). The level also applies to the container's init
+// and exec'd processes while sysbox-runc sets them up.
+const LogLevelAnnotation = "io.nestybox.sysbox.log-level"
+
+// LogFileAnnotation gives a file where the log records of the sysbox-runc
+// commands on the sys container are also written, in the global log format.
+// It's a file name in the container log dir set on the host (see the
+// --container-log-dir flag).
+const LogFileAnnotation = "io.nestybox.sysbox.log-file"
+
+// GetLogLevel returns the log level given by the container's annotations; the
+// returned bool is false if not set.
+func GetLogLevel(annotations map[string]string) (logrus.Level, bool, error) {
+	val := annotations[LogLevelAnnotation]
+	if val == "" {
+		return 0, false, nil
+	}
+	level, err := logrus.ParseLevel(val)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid value for annotation %s: %q (must be \"trace\", \"debug\", \"info\", \"warning\", \"error\", \"fatal\" or \"panic\")", LogLevelAnnotation, val)
+	}
+	return level, true, nil
+}
+
+// GetLogFile returns the log file given by the container's annotations, or an
+// empty string if not set.
+func GetLogFile(annotations map[string]string) (string, error) {
+	val := annotations[LogFileAnnotation]
+	if val == "" {
+		return "", nil
+	}
+	if strings.Contains(val, "/") || val == "." || val == ".." {
+		return "", fmt.Errorf("invalid value for annotation %s: %q (must be a file name in the container log dir)", LogFileAnnotation, val)
+	}
+	return val, nil
+}
+
+// cpusetSize returns the number of CPUs in the given cpuset list (e.g.,
+// "0-3,6").
+func cpusetSize(cpus string) (int, error) {
+	n := 0
+	for _, r := range strings.Split(cpus, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		lo, hi := r, r
+		if i := strings.Index(r, "-"); i >= 0 {
+			lo, hi = r[:i], r[i+1:]
+		}
+		first, err := strconv.ParseUint(lo, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpuset %q", cpus)
+		}
+		last, err := strconv.ParseUint(hi, 10, 32)
+		if err != nil || last < first {
+			return 0, fmt.Errorf("invalid cpuset %q", cpus)
+		}
+		n += int(last-first) + 1
+	}
+	return n, nil
+}
+
+// cpuLimit returns the number of CPUs the container's cgroup CPU limits allow,
+// capped to the host's CPU count; it returns 0 if the container has no CPU
+// limits.
+func cpuLimit(spec *specs.Spec, hostCpus int) (int, error) {
+	if spec.Linux == nil || spec.Linux.Resources == nil || spec.Linux.Resources.CPU == nil {
+		return 0, nil
+	}
+	cpu := spec.Linux.Resources.CPU
+
+	limit := 0
+
+	if cpu.Cpus != "" {
+		n, err := cpusetSize(cpu.Cpus)
+		if err != nil {
+			return 0, err
+		}
+		limit = n
+	}
+
+	if cpu.Quota != nil && *cpu.Quota > 0 {
+		period := uint64(100000) // the kernel's default
+		if cpu.Period != nil && *cpu.Period > 0 {
+			period = *cpu.Period
+		}
+		n := int((uint64(*cpu.Quota) + period - 1) / period)
+		if limit == 0 || n < limit {
+			limit = n
+		}
+	}
+
+	if limit > hostCpus {
+		limit = hostCpus
+	}
+
+	return limit, nil
+}
+
+// cfgCpuCoherenceEnv sets the thread-pool sizing env vars (see
+// CpuCoherenceAnnotation) of the given process to the given CPU count, unless
+// already set.
+func cfgCpuCoherenceEnv(p *specs.Process, cpus int) {
+	count := strconv.Itoa(cpus)
+	jvmOpt := "-XX:ActiveProcessorCount="
+
+	set := map[string]bool{}
+	for i, envVar := range p.Env {
+		name, val, err := utils.GetEnvVarInfo(envVar)
+		if err != nil {
+			continue
+		}
+		set[name] = true
+		// other JVM options may be set; add ours unless given
+		if name == "JAVA_TOOL_OPTIONS" && !strings.Contains(val, jvmOpt) {
+			if val != "" {
+				envVar += " "
+			}
+			p.Env[i] = envVar + jvmOpt + count
+		}
+	}
+
+	for _, v := range [][2]string{
+		{"GOMAXPROCS", count},
+		{"OMP_NUM_THREADS", count},
+		{"JAVA_TOOL_OPTIONS", jvmOpt + count},
+	} {
+		if !set[v[0]] {
+			p.Env = append(p.Env, v[0]+"="+v[1])
+		}
+	}
+}
+
+// cfgCpuCoherence applies the CPU count coherence mode to the container's init
+// process.
+func cfgCpuCoherence(spec *specs.Spec, mode CpuCoherenceMode) error {
+	if mode != CpuCoherenceEnv {
+		return nil
+	}
+
+	cpus, err := cpuLimit(spec, runtime.NumCPU())
+	if err != nil {
+		return err
+	}
+	if cpus == 0 {
+		logrus.Debugf("annotation %s: the container has no CPU limits; not setting its CPU count env vars", CpuCoherenceAnnotation)
+		return nil
+	}
+
+	cfgCpuCoherenceEnv(spec.Process, cpus)
+	return nil
+}
+
+// validateAmbientCaps checks that the ambient capabilities given in the
+// process spec are a subset of its permitted and inheritable capabilities, as
+// required by the kernel. Sysbox usually replaces the process capabilities
+// anyway (see cfgCapabilities()), but an inconsistent set indicates a
+// misconfigured spec that would otherwise fail in the container's init with a
+// far less obvious error once noNewPrivileges is cleared.
+func validateAmbientCaps(p *specs.Process) error {
+	caps := p.Capabilities
+	if caps == nil {
+		return nil
+	}
+	for _, c := range caps.Ambient {
+		if !utils.StringSliceContains(caps.Permitted, c) || !utils.StringSliceContains(caps.Inheritable, c) {
+			return fmt.Errorf("ambient capability %s is not in the permitted and inheritable sets", c)
+		}
+	}
+	return nil
+}
+
+// Configure the container's process spec for system containers, with the
+// given noNewPrivileges policy and capabilities profile (see
+// GetCapsProfile(); nil keeps the process' capabilities as is); init indicates
+// if it's the container's init process (as opposed to an exec process).
+func ConvertProcessSpec(p *specs.Process, policy NoNewPrivsPolicy, caps []string, init bool) error {
+
+	if err := validateAmbientCaps(p); err != nil {
+		return err
+	}
+
+	if caps == nil {
+		if err := validateHonoredCaps(p); err != nil {
+			return err
+		}
+	} else {
+		cfgCapabilities(p, caps)
+	}
+
+	cfgNoNewPrivs(p, policy, init)
+
+	if err := cfgAppArmor(p); err != nil {
+		return fmt.Errorf("failed to configure AppArmor profile: %v", err)
+	}
+
+	if systemdInit(p) {
+		cfgSystemdEnv(p)
+	}
+
+	return nil
+}
+
+// ConvertSpec converts the given container spec to a system container spec.
+func ConvertSpec(sysMgr *sysbox.Mgr, sysFs *sysbox.Fs, spec *specs.Spec) (bool, bool, error) {
+	return convertSpec(sysMgr, sysFs, spec, false)
+}
+
+// ConvertSpecReproducible converts the given container spec to a system
+// container spec that doesn't depend on the host or the container instance, so
+// that it's the same wherever it's converted (e.g., to keep it in git). The
+// spec leaves out what's only known when the container is created, which
+// sysbox-runc adds then (as it converts the spec again):
+//
+// - The sysbox-fs mounts' sources (see SysboxFsPlaceholder).
+// - The user-ID mappings, unless given in the spec.
+// - The sysbox-mgr mounts.
+// - The workload profile's bind mounts and devices (see ProfileAnnotation).
+// - The CPU count env vars (see CpuCoherenceAnnotation).
+//
+// Besides, the mounts are ordered by destination (rather than keeping the
+// order of the spec for the mounts that sysbox doesn't reorder).
+func ConvertSpecReproducible(spec *specs.Spec) error {
+	sysMgr := sysbox.NewMgr("", false)
+
+	sysFs := sysbox.NewFs("", true)
+	sysFs.Mountpoint = SysboxFsPlaceholder
+
+	_, _, err := convertSpec(sysMgr, sysFs, spec, true)
+	return err
+}
+
+// ConvertOpts are the options of a spec conversion by ConvertSpecJSON().
+type ConvertOpts struct {
+	// sysbox-mgr and sysbox-fs to convert the spec for; nil means disabled.
+	// Tests may give fakes (see sysbox.NewMgrWithClient() and
+	// sysbox.NewFsWithClient()).
+	Mgr *sysbox.Mgr
+	Fs  *sysbox.Fs
+
+	// Convert the spec as ConvertSpecReproducible() does; Mgr and Fs are
+	// ignored then.
+	Reproducible bool
+}
+
+// ConvertSpecJSON converts the given container spec (as in a config.json file)
+// to a system container spec, in the same format (indented JSON). It's meant
+// for regression tests of custom specs: the conversion has no effect other
+// than through the given Mgr and Fs, and its result only depends on its input
+// and options (and, unless Reproducible is set, on the host's kernel and
+// config, as when sysbox-runc creates a container).
+func ConvertSpecJSON(data []byte, opts ConvertOpts) ([]byte, error) {
+	var spec specs.Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse spec: %w", err)
+	}
+
+	if opts.Reproducible {
+		if err := ConvertSpecReproducible(&spec); err != nil {
+			return nil, err
+		}
+	} else {
+		sysMgr := opts.Mgr
+		if sysMgr == nil {
+			sysMgr = sysbox.NewMgr("", false)
+		}
+		sysFs := opts.Fs
+		if sysFs == nil {
+			sysFs = sysbox.NewFs("", false)
+		}
+		if _, _, err := ConvertSpec(sysMgr, sysFs, &spec); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := json.MarshalIndent(&spec, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// convertSpec converts the given container spec to a system container spec;
+// reproducible indicates a conversion by ConvertSpecReproducible().
+func convertSpec(sysMgr *sysbox.Mgr, sysFs *sysbox.Fs, spec *specs.Spec, reproducible bool) (bool, bool, error) {
+
+	if err := checkSpec(spec); err != nil {
+		return false, false, fmt.Errorf("invalid or unsupported container spec: %w", err)
+	}
+
+	// Must do this first, as the profile sets defaults for the rest
+	if err := cfgProfile(spec, !reproducible); err != nil {
+		return false, false, err
+	}
+
+	if err := cfgNetStack(spec, !reproducible); err != nil {
+		return false, false, err
+	}
+
+	if err := cfgPresets(spec); err != nil {
+		return false, false, err
+	}
+
+	allocIDs := len(spec.Linux.UIDMappings) == 0 && len(spec.Linux.GIDMappings) == 0
+
+	if err := cfgNamespaces(sysMgr, spec); err != nil {
+		return false, false, fmt.Errorf("invalid namespace config: %w", err)
+	}
+
+	if err := cfgIDMappings(sysMgr, spec); err != nil {
+		return false, false, fmt.Errorf("invalid user/group ID config: %w", err)
+	}
+
+	rootfsPremounted, err := GetRootfsPremounted(spec.Annotations)
+	if err != nil {
+		return false, false, err
+	}
+
+	// Must do this after cfgIDMappings(); the uid shifting only matters for
+	// the sysbox-mgr mounts, left out of reproducible specs (and the rootfs
+	// need not be there when converting these).
+	uidShiftBackends, err := GetUidShiftBackends(spec.Annotations)
+	if err != nil {
+		return false, false, err
+	}
+
+	var uidShiftSupported, uidShiftRootfs bool
+	var rootfsShiftBackend UidShiftBackend
+	if !reproducible {
+		uidShiftSupported, uidShiftRootfs, err = sysbox.CheckUidShifting(spec, rootfsPremounted)
+		if err != nil {
+			return false, false, err
+		}
+		// shiftfs may be disabled for the container
+		uidShiftSupported = uidShiftSupported && HasUidShiftBackend(uidShiftBackends, UidShiftShiftfs)
+		if uidShiftRootfs {
+			rootfsShiftBackend, err = RootfsUidShiftBackend(uidShiftBackends, uidShiftSupported)
+			if err != nil {
+				return false, false, err
+			}
+			// this is slower and modifies the rootfs, so let the user know
+			if rootfsShiftBackend == UidShiftChown {
+				logrus.Infof("the container's rootfs (%s) will be chown'ed to the container's user-ID range", spec.Root.Path)
+			}
+		}
+	}
+
+	// Must do this before cfgMounts(), as sysbox-mgr populates the special
+	// dirs when the mounts are requested.
+	if sysMgr.Enabled() && sysMgr.DataDir != "" && sysMgr.DiskCheck {
+		if err := checkDiskSpace(spec, sysMgr.DataDir, rootfsShiftBackend == UidShiftChown); err != nil {
+			return false, false, err
+		}
+	}
+
+	// Must do this before the sysbox mounts replace the spec's /proc mounts
+	procRoPaths := cfgProcReadonlyMounts(spec)
+
+	if err := cfgMounts(spec, sysMgr, sysFs, uidShiftRootfs); err != nil {
+		return false, false, fmt.Errorf("invalid mount config: %w", err)
+	}
+
+	if reproducible {
+		sortMountsByDest(spec)
+		sortMounts(spec)
+	}
+
+	cfgMaskedPaths(spec)
+	cfgReadonlyPaths(spec)
+
+	// the spec's read-only /proc mounts are made read-only after the setup
+	for _, path := range procRoPaths {
+		if !utils.StringSliceContains(spec.Linux.ReadonlyPaths, path) {
+			spec.Linux.ReadonlyPaths = append(spec.Linux.ReadonlyPaths, path)
+		}
+	}
+
+	oomScoreAdjMin, explicit, err := GetOomScoreAdjMin(spec.Annotations)
+	if err != nil {
+		return false, false, err
+	}
+	if err := cfgOomScoreAdj(spec, oomScoreAdjMin, explicit); err != nil {
+		return false, false, fmt.Errorf("failed to configure the oom score adjustment: %w", err)
+	}
+
+	if err := cfgSeccomp(spec.Linux.Seccomp); err != nil {
+		return false, false, fmt.Errorf("failed to configure seccomp: %w", err)
+	}
+
+	clockPolicy, err := GetClockSettimePolicy(spec.Annotations)
+	if err != nil {
+		return false, false, err
+	}
+	if err := checkClockSettimePolicy(clockPolicy, sysFs); err != nil {
+		return false, false, err
+	}
+	cfgClockSettime(spec.Linux.Seccomp, clockPolicy)
+
+	if _, err := GetMountFastPaths(spec.Annotations); err != nil {
+		return false, false, err
+	}
+
+	noNewPrivsPolicy, err := GetNoNewPrivsPolicy(spec.Annotations)
+	if err != nil {
+		return false, false, err
+	}
+
+	if _, err := GetMaxLifetime(spec.Annotations); err != nil {
+		return false, false, err
+	}
+
+	if _, err := GetBootstrapTimeout(spec.Annotations); err != nil {
+		return false, false, err
+	}
+
+	caps, err := GetCapsProfile(spec.Annotations)
+	if err != nil {
+		return false, false, err
+	}
+	if caps == nil {
+		logrus.Debugf("honoring the sys container's process capabilities (%s)", HonorCapsAnnotation)
+	} else if len(caps) != len(linuxCaps) {
+		logrus.Debugf("sys container capabilities limited by %s=%s",
+			CapsProfileAnnotation, spec.Annotations[CapsProfileAnnotation])
+	}
+
+	if err := ConvertProcessSpec(spec.Process, noNewPrivsPolicy, caps, true); err != nil {
+		return false, false, fmt.Errorf("failed to configure process spec: %w", err)
+	}
+
+	// Must do this after ConvertProcessSpec(), which sets the process caps.
+	tracingMode, err := GetKernelTracing(spec.Annotations)
+	if err != nil {
+		return false, false, err
+	}
+	if err := checkKernelTracingCaps(spec.Process, tracingMode); err != nil {
+		return false, false, err
+	}
+
+	cpuCoherence, err := GetCpuCoherenceMode(spec.Annotations)
+	if err != nil {
+		return false, false, err
+	}
+	if !reproducible {
+		if err := cfgCpuCoherence(spec, cpuCoherence); err != nil {
+			return false, false, fmt.Errorf("failed to configure CPU count coherence: %w", err)
+		}
 	}
 
-	if err := ConvertProcessSpec(spec.Process); err != nil {
-		return false, false, fmt.Errorf("failed to configure process spec: %v", err)
+	if reproducible && allocIDs {
+		spec.Linux.UIDMappings = nil
+		spec.Linux.GIDMappings = nil
 	}
 
 	return uidShiftSupported, uidShiftRootfs, nil
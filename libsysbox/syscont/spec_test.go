@@ -17,10 +17,21 @@
 package syscont
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	utils "github.com/nestybox/sysbox-libs/utils"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libsysbox/sysbox"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 func findSeccompSyscall(seccomp *specs.LinuxSeccomp, targetSyscalls []string) (allFound bool, notFound []string) {
@@ -337,6 +348,55 @@ func TestCfgSystemd(t *testing.T) {
 	}
 }
 
+func TestCfgSysboxFsMounts(t *testing.T) {
+
+	fs1 := sysbox.NewFs("c1", true)
+	fs1.Mountpoint = "/var/lib/sysboxfs-a"
+
+	fs2 := sysbox.NewFs("c2", true)
+	fs2.Mountpoint = "/var/lib/sysboxfs-b"
+
+	spec1 := new(specs.Spec)
+	spec1.Root = new(specs.Root)
+	spec1.Linux = new(specs.Linux)
+
+	spec2 := new(specs.Spec)
+	spec2.Root = &specs.Root{Readonly: true}
+	spec2.Linux = new(specs.Linux)
+
+	cfgSysboxFsMounts(spec1, fs1)
+	cfgSysboxFsMounts(spec2, fs2)
+
+	checkMounts := func(spec *specs.Spec, fs *sysbox.Fs) {
+		if len(spec.Mounts) != len(sysboxFsMounts) {
+			t.Fatalf("cfgSysboxFsMounts() failed: want %d mounts, got %v", len(sysboxFsMounts), spec.Mounts)
+		}
+		for i, m := range spec.Mounts {
+			want := filepath.Join(fs.Mountpoint, fs.Id, sysboxFsMounts[i].Source)
+			if m.Source != want {
+				t.Errorf("cfgSysboxFsMounts() failed: mount source: want %s, got %s", want, m.Source)
+			}
+		}
+	}
+
+	checkMounts(spec1, fs1)
+	checkMounts(spec2, fs2)
+
+	// the package-level mount list must not be modified
+	for _, m := range sysboxFsMounts {
+		if strings.HasPrefix(m.Source, "/") {
+			t.Errorf("cfgSysboxFsMounts() modified sysboxFsMounts: %v", m)
+		}
+	}
+
+	if len(spec1.Linux.ReadonlyPaths) != 0 {
+		t.Errorf("cfgSysboxFsMounts() failed: unexpected readonly paths %v", spec1.Linux.ReadonlyPaths)
+	}
+	if len(spec2.Linux.ReadonlyPaths) != len(sysboxFsMounts) {
+		t.Errorf("cfgSysboxFsMounts() failed: readonly paths: got %v", spec2.Linux.ReadonlyPaths)
+	}
+}
+
 func TestCfgSystemdOverride(t *testing.T) {
 
 	spec := new(specs.Spec)
@@ -520,3 +580,1692 @@ func TestValidateIDMappings(t *testing.T) {
 			want, spec.Linux.GIDMappings)
 	}
 }
+
+func TestCfgNoNewPrivs(t *testing.T) {
+
+	// Invalid policy
+	if _, err := GetNoNewPrivsPolicy(map[string]string{NoNewPrivsAnnotation: "bogus"}); err == nil {
+		t.Errorf("GetNoNewPrivsPolicy(): expected failure on invalid policy")
+	}
+
+	// Default policy
+	policy, err := GetNoNewPrivsPolicy(map[string]string{})
+	if err != nil || policy != NoNewPrivsPreserve {
+		t.Errorf("GetNoNewPrivsPolicy(): want %s, got %s (err = %v)", NoNewPrivsPreserve, policy, err)
+	}
+
+	tests := []struct {
+		policy NoNewPrivsPolicy
+		init   bool
+		want   bool
+	}{
+		{NoNewPrivsPreserve, true, true},
+		{NoNewPrivsPreserve, false, true},
+		{NoNewPrivsClear, true, false},
+		{NoNewPrivsClear, false, false},
+		{NoNewPrivsClearInit, true, false},
+		{NoNewPrivsClearInit, false, true},
+		{NoNewPrivsSet, true, true},
+		{NoNewPrivsSet, false, true},
+	}
+
+	for _, test := range tests {
+		p := &specs.Process{NoNewPrivileges: true}
+		cfgNoNewPrivs(p, test.policy, test.init)
+		if p.NoNewPrivileges != test.want {
+			t.Errorf("cfgNoNewPrivs(%s, init = %v): want noNewPrivileges = %v, got %v",
+				test.policy, test.init, test.want, p.NoNewPrivileges)
+		}
+	}
+
+	// Processes without noNewPrivileges
+	for _, policy := range []NoNewPrivsPolicy{NoNewPrivsPreserve, NoNewPrivsClear, NoNewPrivsClearInit, NoNewPrivsSet} {
+		for _, init := range []bool{true, false} {
+			p := &specs.Process{}
+			cfgNoNewPrivs(p, policy, init)
+			if want := policy == NoNewPrivsSet; p.NoNewPrivileges != want {
+				t.Errorf("cfgNoNewPrivs(%s, init = %v) on process without noNewPrivileges: want %v, got %v",
+					policy, init, want, p.NoNewPrivileges)
+			}
+		}
+	}
+}
+
+func TestCfgCapabilities(t *testing.T) {
+
+	for _, val := range []string{"CAP_CHOWN,CAP_BOGUS", "CAP_CHOWN,", "all"} {
+		if _, err := GetCapsProfile(map[string]string{CapsProfileAnnotation: val}); err == nil {
+			t.Errorf("GetCapsProfile(%q): expected failure on invalid profile", val)
+		}
+	}
+
+	caps, err := GetCapsProfile(map[string]string{})
+	if err != nil || !reflect.DeepEqual(caps, linuxCaps) {
+		t.Errorf("GetCapsProfile(): want all caps, got %v (err = %v)", caps, err)
+	}
+
+	caps, err = GetCapsProfile(map[string]string{CapsProfileAnnotation: "hardened"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caps) != len(linuxCaps)-len(hardenedDroppedCaps) ||
+		utils.StringSliceContains(caps, "CAP_SYS_MODULE") || !utils.StringSliceContains(caps, "CAP_SYS_ADMIN") {
+		t.Errorf("GetCapsProfile(hardened): unexpected caps %v", caps)
+	}
+
+	caps, err = GetCapsProfile(map[string]string{CapsProfileAnnotation: " sys_admin, CAP_CHOWN,cap_net_admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"CAP_CHOWN", "CAP_NET_ADMIN", "CAP_SYS_ADMIN"}
+	if !reflect.DeepEqual(caps, want) {
+		t.Errorf("GetCapsProfile(custom): want %v, got %v", want, caps)
+	}
+
+	// root processes get all the caps of the profile; others get them in
+	// their bounding set only
+	p := &specs.Process{Capabilities: &specs.LinuxCapabilities{}}
+	cfgCapabilities(p, want)
+	for _, set := range [][]string{p.Capabilities.Bounding, p.Capabilities.Effective, p.Capabilities.Permitted,
+		p.Capabilities.Inheritable, p.Capabilities.Ambient} {
+		if !reflect.DeepEqual(set, want) {
+			t.Errorf("cfgCapabilities(root): want %v, got %+v", want, p.Capabilities)
+			break
+		}
+	}
+
+	p = &specs.Process{User: specs.User{UID: 1000}, Capabilities: &specs.LinuxCapabilities{}}
+	cfgCapabilities(p, want)
+	if !reflect.DeepEqual(p.Capabilities.Bounding, want) || len(p.Capabilities.Effective) != 0 ||
+		len(p.Capabilities.Permitted) != 0 || len(p.Capabilities.Ambient) != 0 {
+		t.Errorf("cfgCapabilities(non-root): unexpected caps %+v", p.Capabilities)
+	}
+}
+
+func TestHonorCaps(t *testing.T) {
+
+	for _, ann := range []map[string]string{
+		{HonorCapsAnnotation: "yes"},
+		{HonorCapsAnnotation: "true", CapsProfileAnnotation: "hardened"},
+	} {
+		if _, err := GetCapsProfile(ann); err == nil {
+			t.Errorf("GetCapsProfile(%v): expected failure", ann)
+		}
+	}
+
+	caps, err := GetCapsProfile(map[string]string{HonorCapsAnnotation: "true"})
+	if err != nil || caps != nil {
+		t.Fatalf("GetCapsProfile(honor): want nil caps, got %v (err = %v)", caps, err)
+	}
+
+	// the spec's caps are kept
+	specCaps := []string{"CAP_CHOWN", "CAP_SETGID", "CAP_SETUID", "CAP_SYS_ADMIN"}
+	p := &specs.Process{
+		Args: []string{"/bin/sh"},
+		Capabilities: &specs.LinuxCapabilities{
+			Bounding:  specCaps,
+			Effective: specCaps,
+			Permitted: specCaps,
+		},
+	}
+	if err := ConvertProcessSpec(p, NoNewPrivsPreserve, caps, true); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Capabilities.Bounding, specCaps) || !reflect.DeepEqual(p.Capabilities.Effective, specCaps) ||
+		len(p.Capabilities.Inheritable) != 0 || len(p.Capabilities.Ambient) != 0 {
+		t.Errorf("ConvertProcessSpec(honor): caps changed: %+v", p.Capabilities)
+	}
+
+	// but must include those sysbox requires
+	p.Capabilities.Effective = []string{"CAP_CHOWN", "CAP_SETGID", "CAP_SETUID"}
+	if err := ConvertProcessSpec(p, NoNewPrivsPreserve, caps, true); err == nil {
+		t.Errorf("ConvertProcessSpec(honor): expected failure on missing CAP_SYS_ADMIN in the effective set")
+	}
+
+	// in the bounding set only, for non-root processes
+	p.User.UID = 1000
+	p.Capabilities.Effective = nil
+	p.Capabilities.Permitted = nil
+	if err := ConvertProcessSpec(p, NoNewPrivsPreserve, caps, false); err != nil {
+		t.Errorf("ConvertProcessSpec(honor, non-root): %v", err)
+	}
+	p.Capabilities.Bounding = []string{"CAP_SYS_ADMIN"}
+	if err := ConvertProcessSpec(p, NoNewPrivsPreserve, caps, false); err == nil {
+		t.Errorf("ConvertProcessSpec(honor, non-root): expected failure on missing bounding caps")
+	}
+
+	p.Capabilities = nil
+	if err := ConvertProcessSpec(p, NoNewPrivsPreserve, caps, false); err == nil {
+		t.Errorf("ConvertProcessSpec(honor): expected failure on process without caps")
+	}
+}
+
+func TestCfgClockSettime(t *testing.T) {
+
+	// Invalid policy
+	if _, err := GetClockSettimePolicy(map[string]string{ClockSettimeAnnotation: "allow"}); err == nil {
+		t.Errorf("GetClockSettimePolicy(): expected failure on invalid policy")
+	}
+
+	// Default policy
+	policy, err := GetClockSettimePolicy(map[string]string{})
+	if err != nil || policy != ClockSettimeDeny {
+		t.Errorf("GetClockSettimePolicy(): want %s, got %s (err = %v)", ClockSettimeDeny, policy, err)
+	}
+
+	// Emulation requires a sysbox-fs with the feature
+	sysFs := sysbox.NewFs("c1", true)
+	if err := checkClockSettimePolicy(ClockSettimeEmulate, sysFs); err != nil {
+		t.Errorf("checkClockSettimePolicy(): %v", err)
+	}
+	if err := checkClockSettimePolicy(ClockSettimeEmulate, sysbox.NewFs("c1", false)); err == nil {
+		t.Errorf("checkClockSettimePolicy(): expected failure without sysbox-fs")
+	}
+	sysFs.Caps = &sysbox.Capabilities{Version: "0.5.0", Features: []sysbox.Feature{sysbox.FeatFsSysctls}}
+	if err := checkClockSettimePolicy(ClockSettimeEmulate, sysFs); err == nil {
+		t.Errorf("checkClockSettimePolicy(): expected failure without feature %s", sysbox.FeatFsClock)
+	}
+	if err := checkClockSettimePolicy(ClockSettimeDeny, sysFs); err != nil {
+		t.Errorf("checkClockSettimePolicy(%s): %v", ClockSettimeDeny, err)
+	}
+
+	// No seccomp profile
+	cfgClockSettime(nil, ClockSettimeDeny)
+
+	for _, policy := range []ClockSettimePolicy{ClockSettimeDeny, ClockSettimeEmulate} {
+		seccomp := &specs.LinuxSeccomp{
+			DefaultAction: specs.ActErrno,
+			Architectures: []specs.Arch{specs.ArchX86_64},
+			Syscalls: []specs.LinuxSyscall{
+				{Names: []string{"read", "settimeofday"}, Action: specs.ActAllow},
+				{Names: []string{"clock_settime", "adjtimex"}, Action: specs.ActKill},
+			},
+		}
+
+		cfgClockSettime(seccomp, policy)
+
+		if len(seccomp.Syscalls) != 3 {
+			t.Fatalf("cfgClockSettime(%s): want 3 rules, got %v", policy, seccomp.Syscalls)
+		}
+		if !utils.StringSliceEqual(seccomp.Syscalls[0].Names, []string{"read"}) {
+			t.Errorf("cfgClockSettime(%s): clock syscalls not removed from rule %v", policy, seccomp.Syscalls[0])
+		}
+
+		sc := seccomp.Syscalls[1]
+		if !utils.StringSliceEqual(sc.Names, syscontClockSyscalls) {
+			t.Errorf("cfgClockSettime(%s): want rule for %v, got %v", policy, syscontClockSyscalls, sc.Names)
+		}
+		if policy == ClockSettimeDeny {
+			if sc.Action != specs.ActErrno || sc.ErrnoRet == nil || *sc.ErrnoRet != uint(unix.EPERM) {
+				t.Errorf("cfgClockSettime(%s): want EPERM rule, got %v", policy, sc)
+			}
+		} else if sc.Action != specs.ActAllow {
+			t.Errorf("cfgClockSettime(%s): want allow rule, got %v", policy, sc)
+		}
+
+		// the clock adjusting syscalls are allowed either way
+		sc = seccomp.Syscalls[2]
+		if !utils.StringSliceEqual(sc.Names, syscontClockAdjSyscalls) || sc.Action != specs.ActAllow {
+			t.Errorf("cfgClockSettime(%s): want allow rule for %v, got %v", policy, syscontClockAdjSyscalls, sc)
+		}
+
+		config := &configs.Config{}
+		if err := AddSyscallTraps(config, policy, nil); err != nil {
+			t.Fatalf("AddSyscallTraps(%s): %v", policy, err)
+		}
+		trapped := []string{}
+		for _, call := range config.SeccompNotif.Syscalls {
+			trapped = append(trapped, call.Name)
+		}
+		for _, name := range append(append([]string{}, syscontClockSyscalls...), syscontClockAdjSyscalls...) {
+			if utils.StringSliceContains(trapped, name) != (policy == ClockSettimeEmulate) {
+				t.Errorf("AddSyscallTraps(%s): unexpected trapping of %s (trapped: %v)", policy, name, trapped)
+			}
+		}
+	}
+}
+
+func TestValidateAmbientCaps(t *testing.T) {
+	p := &specs.Process{
+		Capabilities: &specs.LinuxCapabilities{
+			Permitted:   []string{"CAP_NET_ADMIN", "CAP_SYS_ADMIN"},
+			Inheritable: []string{"CAP_NET_ADMIN"},
+			Ambient:     []string{"CAP_NET_ADMIN"},
+		},
+	}
+
+	if err := validateAmbientCaps(p); err != nil {
+		t.Errorf("validateAmbientCaps(): expected pass but it failed: %v", err)
+	}
+
+	p.Capabilities.Ambient = append(p.Capabilities.Ambient, "CAP_SYS_ADMIN")
+	if err := validateAmbientCaps(p); err == nil {
+		t.Errorf("validateAmbientCaps(): expected failure on ambient cap not in inheritable set")
+	}
+}
+
+func TestGetSharedNamespaces(t *testing.T) {
+	shared, err := GetSharedNamespaces(map[string]string{})
+	if err != nil || shared != nil {
+		t.Errorf("GetSharedNamespaces(): want no shared namespaces, got %v (err = %v)", shared, err)
+	}
+
+	shared, err = GetSharedNamespaces(map[string]string{ShareNsAnnotation: "ipc=peer1, uts=peer2"})
+	if err != nil {
+		t.Fatalf("GetSharedNamespaces(): expected pass but it failed: %v", err)
+	}
+	if shared[specs.IPCNamespace] != "peer1" || shared[specs.UTSNamespace] != "peer2" || len(shared) != 2 {
+		t.Errorf("GetSharedNamespaces(): unexpected result %v", shared)
+	}
+
+	shared, err = GetSharedNamespaces(map[string]string{ShareNsAnnotation: "cgroup=peer1"})
+	if err != nil || shared[specs.CgroupNamespace] != "peer1" || len(shared) != 1 {
+		t.Errorf("GetSharedNamespaces(): unexpected result %v (err = %v)", shared, err)
+	}
+
+	invalid := []string{
+		"ipc",
+		"ipc=",
+		"network=peer1",
+		"user=peer1",
+		"cgroup=peer1,cgroup=peer2",
+		"ipc=peer1,ipc=peer2",
+	}
+
+	for _, val := range invalid {
+		if _, err := GetSharedNamespaces(map[string]string{ShareNsAnnotation: val}); err == nil {
+			t.Errorf("GetSharedNamespaces(%q): expected failure", val)
+		}
+	}
+}
+
+func TestGetRootfsClone(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"false", false, false},
+		{"true", true, false},
+		{"yes", false, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetRootfsClone(map[string]string{RootfsCloneAnnotation: test.val})
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("GetRootfsClone(%q): want %v (err = %v), got %v (err = %v)", test.val, test.want, test.wantErr, got, err)
+		}
+	}
+}
+
+func TestGetRootfsPremounted(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"false", false, false},
+		{"true", true, false},
+		{"1", false, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetRootfsPremounted(map[string]string{RootfsPremountedAnnotation: test.val})
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("GetRootfsPremounted(%q): want %v (err = %v), got %v (err = %v)", test.val, test.want, test.wantErr, got, err)
+		}
+	}
+}
+
+func TestGetSharedMounts(t *testing.T) {
+	mounts, err := GetSharedMounts(map[string]string{})
+	if err != nil || mounts != nil {
+		t.Errorf("GetSharedMounts(): want no mounts, got %v (err = %v)", mounts, err)
+	}
+
+	mounts, err = GetSharedMounts(map[string]string{SharedMountsAnnotation: "/var/cache/gomod:/root/go/pkg/mod, /var/cache/npm/:/root/.npm"})
+	if err != nil || len(mounts) != 2 {
+		t.Fatalf("GetSharedMounts(): want 2 mounts, got %v (err = %v)", mounts, err)
+	}
+	if mounts[1].Source != "/var/cache/npm" || mounts[1].Destination != "/root/.npm" || mounts[1].Type != "bind" ||
+		!utils.StringSliceEqual(mounts[1].Options, sharedMountOpts) {
+		t.Errorf("GetSharedMounts(): unexpected mount %v", mounts[1])
+	}
+
+	invalid := []string{
+		"/var/cache/gomod",
+		"var/cache/gomod:/root/go/pkg/mod",
+		"/var/cache/gomod:root/go/pkg/mod",
+		"/var/cache/gomod:/",
+		"/var/cache/a:/cache,/var/cache/b:/cache/",
+	}
+
+	for _, val := range invalid {
+		if _, err := GetSharedMounts(map[string]string{SharedMountsAnnotation: val}); err == nil {
+			t.Errorf("GetSharedMounts(%q): expected failure", val)
+		}
+	}
+}
+
+func TestCfgSharedMounts(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "sharedmount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sysMgr := sysbox.NewMgr("c1", false)
+	spec := &specs.Spec{
+		Annotations: map[string]string{SharedMountsAnnotation: dir + ":/cache"},
+		Mounts:      []specs.Mount{{Destination: "/proc", Type: "proc", Source: "proc"}},
+	}
+
+	// writable by others
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfgSharedMounts(spec, sysMgr); err == nil {
+		t.Errorf("cfgSharedMounts(): expected failure on writable source")
+	}
+
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfgSharedMounts(spec, sysMgr); err != nil {
+		t.Fatalf("cfgSharedMounts(): expected pass but it failed: %v", err)
+	}
+	if len(spec.Mounts) != 2 || spec.Mounts[1].Source != dir || spec.Mounts[1].Destination != "/cache" {
+		t.Errorf("cfgSharedMounts(): unexpected mounts %v", spec.Mounts)
+	}
+
+	// conflicts with a spec mount
+	if err := cfgSharedMounts(spec, sysMgr); err == nil {
+		t.Errorf("cfgSharedMounts(): expected failure on conflicting mount")
+	}
+}
+
+func TestGetUpperDir(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"/mnt/scratch", "/mnt/scratch", false},
+		{"/mnt/scratch/", "/mnt/scratch", false},
+		{"mnt/scratch", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := GetUpperDir(map[string]string{UpperDirAnnotation: test.val})
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("GetUpperDir(%q): want %q (err = %v), got %q (err = %v)", test.val, test.want, test.wantErr, got, err)
+		}
+	}
+}
+
+func TestGetTemplate(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"false", false, false},
+		{"true", true, false},
+		{"on", false, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetTemplate(map[string]string{TemplateAnnotation: test.val})
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("GetTemplate(%q): want %v (err = %v), got %v (err = %v)", test.val, test.want, test.wantErr, got, err)
+		}
+	}
+}
+
+func TestGetNotifyProxy(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"false", false, false},
+		{"true", true, false},
+		{"yes", false, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetNotifyProxy(map[string]string{NotifyProxyAnnotation: test.val})
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("GetNotifyProxy(%q): want %v (err = %v), got %v (err = %v)", test.val, test.want, test.wantErr, got, err)
+		}
+	}
+}
+
+func TestGetCoreDump(t *testing.T) {
+	pattern := "/var/crash/core.%e.%p.%t"
+
+	tests := []struct {
+		val     string
+		want    *configs.CoreDump
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"true", &configs.CoreDump{Pattern: pattern, MaxSize: 1 << 30}, false},
+		{"max-size=512M", &configs.CoreDump{Pattern: pattern, MaxSize: 512 << 20}, false},
+		{"max-size=512M, max-total=2g", &configs.CoreDump{Pattern: pattern, MaxSize: 512 << 20, MaxTotal: 2 << 30}, false},
+		{"dir=/etc", nil, true},
+		{"dir=/var/lib/sysbox-runc/coredump/web", nil, true},
+		{"max-size=lots", nil, true},
+		{"max-size=0", nil, true},
+		{"compress=true", nil, true},
+		{"max-size", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetCoreDump(map[string]string{CoreDumpAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetCoreDump(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+			t.Errorf("GetCoreDump(%q): want %+v, got %+v", test.val, test.want, got)
+		}
+	}
+}
+
+func TestGetFsNotifyQuota(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    *configs.FsNotifyQuota
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"inotify-watches=524288", &configs.FsNotifyQuota{InotifyWatches: 524288}, false},
+		{"inotify-watches=524288, inotify-instances=512,fanotify-marks=8192,fanotify-groups=16",
+			&configs.FsNotifyQuota{InotifyWatches: 524288, InotifyInstances: 512, FanotifyMarks: 8192, FanotifyGroups: 16}, false},
+		{"inotify-watches=0", &configs.FsNotifyQuota{}, false},
+		{"inotify-watches=-1", nil, true},
+		{"inotify-watches=lots", nil, true},
+		{"inotify-queued-events=16384", nil, true},
+		{"inotify-watches", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetFsNotifyQuota(map[string]string{FsNotifyQuotaAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetFsNotifyQuota(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetFsNotifyQuota(%q): want %+v, got %+v", test.val, test.want, got)
+		}
+	}
+}
+
+func TestGetLogLevel(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    logrus.Level
+		wantSet bool
+		wantErr bool
+	}{
+		{"", 0, false, false},
+		{"debug", logrus.DebugLevel, true, false},
+		{"warning", logrus.WarnLevel, true, false},
+		{"error", logrus.ErrorLevel, true, false},
+		{"verbose", 0, false, true},
+	}
+
+	for _, test := range tests {
+		got, set, err := GetLogLevel(map[string]string{LogLevelAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetLogLevel(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if got != test.want || set != test.wantSet {
+			t.Errorf("GetLogLevel(%q): want %v (set %v), got %v (set %v)", test.val, test.want, test.wantSet, got, set)
+		}
+	}
+}
+
+func TestGetLogFile(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"c1.log", "c1.log", false},
+		{"/var/log/sysbox/c1.log", "", true},
+		{"../c1.log", "", true},
+		{"logs/c1.log", "", true},
+		{"..", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := GetLogFile(map[string]string{LogFileAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetLogFile(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("GetLogFile(%q): want %q, got %q", test.val, test.want, got)
+		}
+	}
+}
+
+func TestGetVolumes(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    []sysbox.MgrVolume
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"/var/lib/buildkit", []sysbox.MgrVolume{{Dest: "/var/lib/buildkit"}}, false},
+		{"/data/:10G:persist, /cache:512m,/var/lib/etcd:persist", []sysbox.MgrVolume{
+			{Dest: "/data", Size: 10 << 30, Persist: true},
+			{Dest: "/cache", Size: 512 << 20},
+			{Dest: "/var/lib/etcd", Persist: true},
+		}, false},
+		{"data", nil, true},
+		{"/", nil, true},
+		{"/data,/data/", nil, true},
+		{"/data:lots", nil, true},
+		{"/data:0", nil, true},
+		{"/data:persist:10G", nil, true},
+		{"/data:1G:2G", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetVolumes(map[string]string{VolumesAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetVolumes(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetVolumes(%q): want %+v, got %+v", test.val, test.want, got)
+		}
+	}
+}
+
+func TestGetEtcFilesCopy(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"/etc/resolv.conf", []string{"/etc/resolv.conf"}, false},
+		{"/etc/hosts, /etc/resolv.conf,/etc/hosts", []string{"/etc/hosts", "/etc/resolv.conf"}, false},
+		{"/etc/passwd", nil, true},
+		{"/etc/hosts,", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetEtcFilesCopy(map[string]string{EtcFilesCopyAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetEtcFilesCopy(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetEtcFilesCopy(%q): want %v, got %v", test.val, test.want, got)
+		}
+	}
+}
+
+// fileCopierClient is a sysbox-mgr client that only supports file copies.
+type fileCopierClient struct {
+	sysbox.MgrClient
+	uid, gid uint32
+}
+
+func (c *fileCopierClient) CopyFiles(id string, uid, gid uint32, srcs []string) ([]string, error) {
+	c.uid, c.gid = uid, gid
+	copies := []string{}
+	for _, src := range srcs {
+		copies = append(copies, filepath.Join("/var/lib/sysbox/files", id, filepath.Base(src)))
+	}
+	return copies, nil
+}
+
+func TestCfgEtcFilesCopy(t *testing.T) {
+	client := &fileCopierClient{}
+	mgr := sysbox.NewMgrWithClient("c1", client)
+
+	spec := &specs.Spec{
+		Mounts: []specs.Mount{
+			{Destination: "/etc/resolv.conf", Type: "bind", Source: "/engine/c1/resolv.conf", Options: []string{"rbind", "ro"}},
+			{Destination: "/etc/hosts", Type: "bind", Source: "/engine/c1/hosts"},
+			{Destination: "/etc/hostname", Type: "bind", Source: "/engine/c1/hostname"},
+		},
+		Linux: &specs.Linux{
+			UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 165536, Size: 65536}},
+			GIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 165536, Size: 65536}},
+		},
+	}
+
+	if err := cfgEtcFilesCopy(mgr, spec, []string{"/etc/resolv.conf", "/etc/hosts"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/var/lib/sysbox/files/c1/resolv.conf", "/var/lib/sysbox/files/c1/hosts", "/engine/c1/hostname"}
+	for i, m := range spec.Mounts {
+		if m.Source != want[i] {
+			t.Errorf("mount at %s: want source %s, got %s", m.Destination, want[i], m.Source)
+		}
+	}
+	if !reflect.DeepEqual(spec.Mounts[0].Options, []string{"rbind", "ro"}) {
+		t.Errorf("mount options changed: %v", spec.Mounts[0].Options)
+	}
+	if client.uid != 165536 || client.gid != 165536 {
+		t.Errorf("copies owned by %d:%d, want 165536:165536", client.uid, client.gid)
+	}
+}
+
+func TestGetKernelModules(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    *configs.KernelModules
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"host", nil, false},
+		{"none", &configs.KernelModules{}, false},
+		{"overlay", &configs.KernelModules{Allowed: []string{"overlay"}}, false},
+		{"nf-conntrack, overlay,nf_conntrack", &configs.KernelModules{Allowed: []string{"nf_conntrack", "overlay"}}, false},
+		{"overlay,", nil, true},
+		{"../overlay", nil, true},
+		{"br netfilter", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetKernelModules(map[string]string{KernelModulesAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetKernelModules(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetKernelModules(%q): want %+v, got %+v", test.val, test.want, got)
+		}
+	}
+}
+
+func TestCfgKernelModuleMounts(t *testing.T) {
+	spec := new(specs.Spec)
+	spec.Mounts = []specs.Mount{
+		{Destination: "/sys/module/nf_conntrack/parameters/hashsize", Type: "bind", Source: "sys/module/nf_conntrack/parameters/hashsize"},
+		{Destination: "/sys/module/overlay/parameters/", Type: "bind", Source: "/tmp/overlay"},
+		{Destination: "/sys/modules", Type: "bind", Source: "/tmp/modules"},
+	}
+
+	CfgKernelModuleMounts(spec, &configs.KernelModules{Allowed: []string{"overlay"}})
+
+	want := []string{"/sys/module/overlay/parameters/", "/sys/modules"}
+	got := []string{}
+	for _, m := range spec.Mounts {
+		got = append(got, m.Destination)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CfgKernelModuleMounts: want mounts %v, got %v", want, got)
+	}
+}
+
+func TestGetSysKernelMounts(t *testing.T) {
+	inSysContainerOrig := inSysContainer
+	defer func() { inSysContainer = inSysContainerOrig }()
+
+	all := []string{"/sys/kernel/config", "/sys/kernel/debug", "/sys/kernel/tracing"}
+
+	tests := []struct {
+		val     string
+		nested  bool
+		want    []string
+		wantErr bool
+	}{
+		{"", false, all, false},
+		{"auto", false, all, false},
+		{"", true, []string{}, false},
+		{"all", true, all, false},
+		{"none", false, []string{}, false},
+		{"tracing, debug,tracing", false, []string{"/sys/kernel/tracing", "/sys/kernel/debug"}, false},
+		{"debugfs", false, nil, true},
+		{"debug,", false, nil, true},
+	}
+
+	for _, test := range tests {
+		nested := test.nested
+		inSysContainer = func() bool { return nested }
+
+		spec := new(specs.Spec)
+		spec.Annotations = map[string]string{SysKernelMountsAnnotation: test.val}
+
+		got, err := GetSysKernelMounts(spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetSysKernelMounts(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetSysKernelMounts(%q): want %v, got %v", test.val, test.want, got)
+		}
+	}
+
+	// a sys container that mounts a sysbox data dir runs sysbox itself
+	inSysContainer = func() bool { return false }
+	spec := new(specs.Spec)
+	spec.Mounts = []specs.Mount{{Destination: "/var/lib/sysbox/", Type: "bind", Source: "/vol"}}
+
+	got, err := GetSysKernelMounts(spec)
+	if err != nil || len(got) != 0 {
+		t.Errorf("GetSysKernelMounts (nested): want no mounts, got %v (err = %v)", got, err)
+	}
+}
+
+func TestCfgSysboxMountsSysKernel(t *testing.T) {
+	spec := new(specs.Spec)
+	spec.Root = &specs.Root{Path: "rootfs"}
+	spec.Mounts = []specs.Mount{
+		{Destination: "/sys/kernel/debug", Type: "bind", Source: "/sys/kernel/debug"},
+		{Destination: "/sys/kernel/tracing", Type: "bind", Source: "/sys/kernel/tracing"},
+	}
+
+	cfgSysboxMounts(spec, []string{"/sys/kernel/tracing"})
+
+	for _, m := range spec.Mounts {
+		switch m.Destination {
+		case "/sys/kernel/config":
+			t.Errorf("cfgSysboxMounts: unexpected dummy mount at %s", m.Destination)
+		case "/sys/kernel/debug":
+			if m.Type != "bind" {
+				t.Errorf("cfgSysboxMounts: want the spec's mount at %s, got %+v", m.Destination, m)
+			}
+		case "/sys/kernel/tracing":
+			if m.Type != "tmpfs" {
+				t.Errorf("cfgSysboxMounts: want a dummy mount at %s, got %+v", m.Destination, m)
+			}
+		}
+	}
+}
+
+func TestSysboxMountsNotShared(t *testing.T) {
+	wantSysbox := copyMounts(sysboxMounts)
+	wantSystemd := copyMounts(sysboxSystemdMounts)
+	wantCaps := append([]string{}, linuxCaps...)
+
+	for _, readonly := range []bool{true, false} {
+		spec := new(specs.Spec)
+		spec.Root = &specs.Root{Path: "rootfs", Readonly: readonly}
+
+		cfgSysboxMounts(spec, []string{"/sys/kernel/config", "/sys/kernel/debug", "/sys/kernel/tracing"})
+		cfgSystemdMounts(spec)
+
+		// changes to the spec's mounts must not leak into the next spec
+		for i := range spec.Mounts {
+			for j := range spec.Mounts[i].Options {
+				spec.Mounts[i].Options[j] = "changed"
+			}
+		}
+	}
+
+	caps, err := GetCapsProfile(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps[0] = "changed"
+
+	if !reflect.DeepEqual(sysboxMounts, wantSysbox) {
+		t.Errorf("sysboxMounts modified: %v", sysboxMounts)
+	}
+	if !reflect.DeepEqual(sysboxSystemdMounts, wantSystemd) {
+		t.Errorf("sysboxSystemdMounts modified: %v", sysboxSystemdMounts)
+	}
+	if !reflect.DeepEqual(linuxCaps, wantCaps) {
+		t.Errorf("linuxCaps modified: %v", linuxCaps)
+	}
+}
+
+func TestGetConfigfs(t *testing.T) {
+	tests := []struct {
+		val          string
+		wantMode     ConfigfsMode
+		wantSubtrees []string
+		wantErr      bool
+	}{
+		{"", ConfigfsDummy, nil, false},
+		{"dummy", ConfigfsDummy, nil, false},
+		{"sysbox-fs", ConfigfsSysboxFs, nil, false},
+		{"passthrough:nvmet", ConfigfsPassthrough, []string{"nvmet"}, false},
+		{"passthrough:target, nvmet,target", ConfigfsPassthrough, []string{"target", "nvmet"}, false},
+		{"passthrough", "", nil, true},
+		{"passthrough:", "", nil, true},
+		{"passthrough:usb_gadget", "", nil, true},
+		{"host", "", nil, true},
+	}
+
+	for _, test := range tests {
+		mode, subtrees, err := GetConfigfs(map[string]string{ConfigfsAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetConfigfs(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if mode != test.wantMode || !reflect.DeepEqual(subtrees, test.wantSubtrees) {
+			t.Errorf("GetConfigfs(%q): want %v %v, got %v %v", test.val, test.wantMode, test.wantSubtrees, mode, subtrees)
+		}
+	}
+}
+
+func TestCfgConfigfs(t *testing.T) {
+	newSpec := func() *specs.Spec {
+		return &specs.Spec{
+			Root:  &specs.Root{Path: "rootfs", Readonly: true},
+			Linux: &specs.Linux{},
+			Mounts: []specs.Mount{
+				{Destination: "/sys/kernel/config/", Type: "bind", Source: "/sys/kernel/config"},
+			},
+		}
+	}
+
+	// sysbox-fs
+	spec := newSpec()
+	sysFs := sysbox.NewFs("c1", true)
+	sysFs.Mountpoint = "/var/lib/sysboxfs"
+	if err := cfgConfigfs(spec, sysFs, ConfigfsSysboxFs, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []specs.Mount{{
+		Destination: "/sys/kernel/config",
+		Source:      "/var/lib/sysboxfs/c1/sys/kernel/config",
+		Type:        "bind",
+		Options:     []string{"rbind", "rprivate"},
+	}}
+	if !reflect.DeepEqual(spec.Mounts, want) {
+		t.Errorf("sysbox-fs mode: want mounts %+v, got %+v", want, spec.Mounts)
+	}
+	if !reflect.DeepEqual(spec.Linux.ReadonlyPaths, []string{"/sys/kernel/config"}) {
+		t.Errorf("sysbox-fs mode: want configfs read-only, got read-only paths %v", spec.Linux.ReadonlyPaths)
+	}
+
+	if err := cfgConfigfs(newSpec(), sysbox.NewFs("c1", false), ConfigfsSysboxFs, nil); err == nil {
+		t.Errorf("sysbox-fs mode: expected error without sysbox-fs")
+	}
+
+	sysFs.Caps = &sysbox.Capabilities{Version: "0.5.0"}
+	if err := cfgConfigfs(newSpec(), sysFs, ConfigfsSysboxFs, nil); err == nil {
+		t.Errorf("sysbox-fs mode: expected error without sysbox-fs support")
+	}
+
+	// passthrough
+	spec = newSpec()
+	spec.Mounts = nil
+	if err := cfgConfigfs(spec, sysFs, ConfigfsPassthrough, []string{"nvmet"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Mounts) != 1 || spec.Mounts[0].Source != "/sys/kernel/config/nvmet" ||
+		!utils.StringSliceContains(spec.Mounts[0].Options, "ro") {
+		t.Errorf("passthrough mode: want a read-only bind mount of nvmet, got %+v", spec.Mounts)
+	}
+
+	spec.Mounts = []specs.Mount{{Destination: "/sys/kernel/config/nvmet", Type: "tmpfs", Source: "tmpfs"}}
+	if err := cfgConfigfs(spec, sysFs, ConfigfsPassthrough, []string{"nvmet"}); err == nil {
+		t.Errorf("passthrough mode: expected error for a conflicting spec mount")
+	}
+}
+
+func TestGetMaxLifetime(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"72h", 72 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"0s", 0, true},
+		{"-1h", 0, true},
+		{"3d", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetMaxLifetime(map[string]string{MaxLifetimeAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetMaxLifetime(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("GetMaxLifetime(%q): want %v, got %v", test.val, test.want, got)
+		}
+	}
+}
+
+func TestGetBootstrapTimeout(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"30s", 30 * time.Second, false},
+		{"2m", 2 * time.Minute, false},
+		{"0", 0, true},
+		{"-5s", 0, true},
+		{"30", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := GetBootstrapTimeout(map[string]string{BootstrapTimeoutAnnotation: test.val})
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetBootstrapTimeout(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("GetBootstrapTimeout(%q): want %v, got %v", test.val, test.want, got)
+		}
+	}
+}
+
+func TestGetOomScoreAdjMin(t *testing.T) {
+	tests := []struct {
+		val      string // "" if the annotation isn't set
+		want     int
+		explicit bool
+		wantErr  bool
+	}{
+		{"", MinOomScoreAdj, false, false},
+		{"-500", -500, true, false},
+		{" 0 ", 0, true, false},
+		{"1000", 1000, true, false},
+		{"-1000", 0, false, true},
+		{"1001", 0, false, true},
+		{"low", 0, false, true},
+	}
+
+	for _, test := range tests {
+		annotations := map[string]string{}
+		if test.val != "" {
+			annotations[OomScoreAdjMinAnnotation] = test.val
+		}
+		got, explicit, err := GetOomScoreAdjMin(annotations)
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetOomScoreAdjMin(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if got != test.want || explicit != test.explicit {
+			t.Errorf("GetOomScoreAdjMin(%q): want %d (explicit %v), got %d (explicit %v)", test.val, test.want, test.explicit, got, explicit)
+		}
+	}
+}
+
+func TestCfgOomScoreAdj(t *testing.T) {
+	adj := func(v int) *int { return &v }
+
+	tests := []struct {
+		adj      *int
+		min      int
+		explicit bool
+		want     *int
+	}{
+		{adj(-1000), MinOomScoreAdj, false, adj(-999)},
+		{adj(-500), MinOomScoreAdj, false, adj(-500)},
+		{adj(-500), 100, true, adj(100)},
+		{adj(200), 100, true, adj(200)},
+		{nil, MinOomScoreAdj, false, nil},
+	}
+
+	for _, test := range tests {
+		spec := &specs.Spec{Process: &specs.Process{OOMScoreAdj: test.adj}}
+		if err := cfgOomScoreAdj(spec, test.min, test.explicit); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(spec.Process.OOMScoreAdj, test.want) {
+			t.Errorf("cfgOomScoreAdj(%v, %d): want %v, got %v", test.adj, test.min, test.want, spec.Process.OOMScoreAdj)
+		}
+	}
+
+	// the inherited adjustment is raised to an explicit floor (at most 1000)
+	spec := &specs.Spec{Process: &specs.Process{}}
+	if err := cfgOomScoreAdj(spec, 1000, true); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Process.OOMScoreAdj == nil || *spec.Process.OOMScoreAdj != 1000 {
+		t.Errorf("cfgOomScoreAdj(nil, 1000): want 1000, got %v", spec.Process.OOMScoreAdj)
+	}
+}
+
+func TestGetSignalMap(t *testing.T) {
+	tests := []struct {
+		init    string
+		val     string // "" if the annotation isn't set
+		want    map[unix.Signal]unix.Signal
+		wantErr bool
+	}{
+		{"/bin/sh", "", nil, false},
+		{"/sbin/init", "", map[unix.Signal]unix.Signal{unix.SIGTERM: 37}, false},
+		{"/sbin/init", "none", nil, false},
+		{"/bin/sh", "SIGTERM=SIGRTMIN+3, int=SIGRTMAX-1",
+			map[unix.Signal]unix.Signal{unix.SIGTERM: 37, unix.SIGINT: 63}, false},
+		{"/bin/sh", "15=SIGUSR1", map[unix.Signal]unix.Signal{unix.SIGTERM: unix.SIGUSR1}, false},
+		{"/bin/sh", "SIGTERM", nil, true},
+		{"/bin/sh", "SIGTERM=SIGFOO", nil, true},
+		{"/bin/sh", "SIGTERM=SIGRTMIN+31", nil, true},
+		{"/bin/sh", "SIGTERM=SIGRTMIN3", nil, true},
+		{"/bin/sh", "SIGCHLD=SIGTERM", nil, true},
+		{"/bin/sh", "SIGTERM=0", nil, true},
+	}
+
+	for _, test := range tests {
+		spec := &specs.Spec{
+			Process:     &specs.Process{Args: []string{test.init}},
+			Annotations: map[string]string{},
+		}
+		if test.val != "" {
+			spec.Annotations[SignalMapAnnotation] = test.val
+		}
+		got, err := GetSignalMap(spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetSignalMap(%s, %q): want err = %v, got %v", test.init, test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetSignalMap(%s, %q): want %v, got %v", test.init, test.val, test.want, got)
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    unix.Signal
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"9", unix.SIGKILL, false},
+		{"kill", unix.SIGKILL, false},
+		{"SIGTERM", unix.SIGTERM, false},
+		{"RTMIN+3", 37, false},
+		{"SIGRTMIN", 34, false},
+		{"SIGRTMAX-2", 62, false},
+		{"-1", 0, true},
+		{"65", 0, true},
+		{"SIGRTMAX+1", 0, true},
+		{"SIGRTMIN-1", 0, true},
+		{"SIGFOO", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseSignal(test.val)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseSignal(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseSignal(%q): want %d, got %d", test.val, test.want, got)
+		}
+	}
+}
+
+func TestGetHostConfigs(t *testing.T) {
+	tests := []struct {
+		val     string
+		mounts  []string
+		want    *configs.HostConfigs
+		wantErr bool
+	}{
+		{"", nil, nil, false},
+		{"krb5", nil, &configs.HostConfigs{Paths: []string{"/etc/krb5.conf", "/etc/krb5.conf.d"}}, false},
+		{" sssd, krb5,sssd", []string{"/etc/krb5.conf/"}, &configs.HostConfigs{Paths: []string{"/etc/sssd", "/etc/krb5.conf.d"}}, false},
+		{"sssd", []string{"/etc/sssd"}, &configs.HostConfigs{}, false},
+		{"krb5,", nil, nil, true},
+		{"passwd", nil, nil, true},
+	}
+
+	for _, test := range tests {
+		spec := &specs.Spec{Annotations: map[string]string{HostConfigsAnnotation: test.val}}
+		for _, m := range test.mounts {
+			spec.Mounts = append(spec.Mounts, specs.Mount{Destination: m, Type: "bind", Source: "/tmp"})
+		}
+		got, err := GetHostConfigs(spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetHostConfigs(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetHostConfigs(%q): want %+v, got %+v", test.val, test.want, got)
+		}
+	}
+}
+
+func TestGetSSHKeys(t *testing.T) {
+	tests := []struct {
+		hostKeys string
+		authKeys string
+		want     *configs.SSHKeys
+		wantErr  bool
+	}{
+		{"", "", nil, false},
+		{"c1/", "", &configs.SSHKeys{HostKeysDir: "c1"}, false},
+		{"", " users/root.pub, users/dev.pub:dev", &configs.SSHKeys{
+			AuthorizedKeys: []configs.AuthorizedKeys{
+				{Path: "users/root.pub", User: "root"},
+				{Path: "users/dev.pub", User: "dev"},
+			},
+		}, false},
+		{"c1/../c2", "", &configs.SSHKeys{HostKeysDir: "c2"}, false},
+		{"/etc/ssh", "", nil, true},
+		{"../ssh", "", nil, true},
+		{".", "", nil, true},
+		{"", "/root/.ssh/id_rsa", nil, true},
+		{"", "users/../../shadow", nil, true},
+		{"", "users/dev.pub:", nil, true},
+		{"", "users/a.pub:dev,users/b.pub:dev", nil, true},
+	}
+
+	for _, test := range tests {
+		spec := &specs.Spec{Annotations: map[string]string{
+			SSHHostKeysAnnotation:       test.hostKeys,
+			SSHAuthorizedKeysAnnotation: test.authKeys,
+		}}
+		got, err := GetSSHKeys(spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetSSHKeys(%q, %q): want err = %v, got %v", test.hostKeys, test.authKeys, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetSSHKeys(%q, %q): want %+v, got %+v", test.hostKeys, test.authKeys, test.want, got)
+		}
+	}
+}
+
+func TestGetNetworks(t *testing.T) {
+	tests := []struct {
+		val     string
+		netNs   string // path of the network ns; "-" if none
+		want    []*configs.Network
+		wantErr bool
+	}{
+		{"", "", nil, false},
+		{`[{"type": "macvlan", "name": "eth0", "parent": "enp3s0", "address": "192.168.1.50/24",
+			"gateway": "192.168.1.1", "routes": [{"destination": "10.0.0.0/8"}]},
+		   {"type": "ipvlan", "name": "eth1", "parent": "enp4s0", "mode": "l3"}]`, "",
+			[]*configs.Network{
+				{
+					Type:    "macvlan",
+					Name:    "eth0",
+					Parent:  "enp3s0",
+					Address: "192.168.1.50/24",
+					Gateway: "192.168.1.1",
+					Routes:  []*configs.Route{{Destination: "10.0.0.0/8"}},
+				},
+				{Type: "ipvlan", Name: "eth1", Parent: "enp4s0", Mode: "l3"},
+			}, false},
+		{`[{"type": "macvlan", "name": "eth0", "parent": "enp3s0"}]`, "/proc/1/ns/net", nil, true},
+		{`[{"type": "macvlan", "name": "eth0", "parent": "enp3s0"}]`, "-", nil, true},
+		{`[{"type": "veth", "name": "eth0", "parent": "enp3s0"}]`, "", nil, true},
+		{`[{"type": "macvlan", "name": "eth0"}]`, "", nil, true},
+		{`[{"type": "macvlan", "name": "lo", "parent": "enp3s0"}]`, "", nil, true},
+		{`[{"type": "macvlan", "name": "eth0", "parent": "enp3s0", "bridge": "br0"}]`, "", nil, true},
+		{`[{"type": "macvlan", "name": "eth0", "parent": "enp3s0", "foo": 1}]`, "", nil, true},
+		{`{"type": "macvlan"}`, "", nil, true},
+	}
+
+	for _, test := range tests {
+		spec := &specs.Spec{
+			Linux:       &specs.Linux{},
+			Annotations: map[string]string{NetworksAnnotation: test.val},
+		}
+		if test.netNs != "-" {
+			spec.Linux.Namespaces = []specs.LinuxNamespace{{Type: specs.NetworkNamespace, Path: test.netNs}}
+		}
+		got, err := GetNetworks(spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetNetworks(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetNetworks(%q): want %+v, got %+v", test.val, test.want, got)
+		}
+	}
+}
+
+func TestGetPortForwards(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    []PortForward
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"8080:80, 127.0.0.1:2222:22/tcp,[::1]:8443:443", []PortForward{
+			{HostPort: 8080, ContainerPort: 80},
+			{HostIP: "127.0.0.1", HostPort: 2222, ContainerPort: 22},
+			{HostIP: "::1", HostPort: 8443, ContainerPort: 443},
+		}, false},
+		{"80", nil, true},
+		{"8080:80/udp", nil, true},
+		{"0:80", nil, true},
+		{"8080:70000", nil, true},
+		{"foo:8080:80", nil, true},
+		{"8080:80,8080:81", nil, true},
+		{"127.0.0.1:8080:80,8080:81", []PortForward{
+			{HostIP: "127.0.0.1", HostPort: 8080, ContainerPort: 80},
+			{HostPort: 8080, ContainerPort: 81},
+		}, false},
+	}
+
+	for _, test := range tests {
+		spec := &specs.Spec{
+			Linux: &specs.Linux{
+				Namespaces: []specs.LinuxNamespace{{Type: specs.NetworkNamespace}},
+			},
+			Annotations: map[string]string{PortForwardsAnnotation: test.val},
+		}
+		got, err := GetPortForwards(spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetPortForwards(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetPortForwards(%q): want %+v, got %+v", test.val, test.want, got)
+		}
+	}
+
+	spec := &specs.Spec{
+		Linux:       &specs.Linux{},
+		Annotations: map[string]string{PortForwardsAnnotation: "8080:80"},
+	}
+	if _, err := GetPortForwards(spec); err == nil {
+		t.Errorf("GetPortForwards(): want err for a container without a private network namespace")
+	}
+}
+
+func TestCfgNetStack(t *testing.T) {
+	spec := &specs.Spec{
+		Linux: &specs.Linux{
+			Sysctl: map[string]string{"net.ipv6.conf.all.accept_ra": "0"},
+		},
+		Annotations: map[string]string{NetStackAnnotation: "dual-stack"},
+	}
+	if err := cfgNetStack(spec, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Linux.Sysctl) != len(dualStackSysctls) {
+		t.Errorf("got sysctls %v, want %v", spec.Linux.Sysctl, dualStackSysctls)
+	}
+	if spec.Linux.Sysctl["net.ipv6.conf.all.forwarding"] != "1" {
+		t.Errorf("net.ipv6.conf.all.forwarding not set")
+	}
+	// the spec's sysctls take precedence
+	if spec.Linux.Sysctl["net.ipv6.conf.all.accept_ra"] != "0" {
+		t.Errorf("net.ipv6.conf.all.accept_ra overridden")
+	}
+
+	// the host's kernel must support IPv6
+	savedDir := hostIPv6Dir
+	defer func() { hostIPv6Dir = savedDir }()
+	dir, err := ioutil.TempDir("", "cfgNetStack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hostIPv6Dir = filepath.Join(dir, "ipv6")
+	spec = &specs.Spec{
+		Linux:       &specs.Linux{},
+		Annotations: map[string]string{NetStackAnnotation: "dual-stack"},
+	}
+	if err := cfgNetStack(spec, true); err == nil {
+		t.Errorf("want err for a host with IPv6 disabled")
+	}
+	if err := cfgNetStack(spec, false); err != nil {
+		t.Errorf("got err %v with no host checks", err)
+	}
+
+	spec = &specs.Spec{Linux: &specs.Linux{}, Annotations: map[string]string{}}
+	if err := cfgNetStack(spec, true); err != nil || spec.Linux.Sysctl != nil {
+		t.Errorf("default network stack: got err %v, sysctls %v", err, spec.Linux.Sysctl)
+	}
+
+	spec.Annotations[NetStackAnnotation] = "ipv6-only"
+	if err := cfgNetStack(spec, false); err == nil {
+		t.Errorf("want err for an invalid network stack")
+	}
+}
+
+func TestCfgProcReadonlyMounts(t *testing.T) {
+	spec := new(specs.Spec)
+	spec.Linux = new(specs.Linux)
+	spec.Linux.ReadonlyPaths = []string{"/some/path"}
+	spec.Mounts = []specs.Mount{
+		{Destination: "/proc/sys", Type: "bind", Source: "/proc/sys", Options: []string{"rbind", "ro"}},
+		{Destination: "/data", Type: "bind", Source: "/data", Options: []string{"rbind", "ro"}},
+	}
+
+	roPaths := cfgProcReadonlyMounts(spec)
+
+	if utils.StringSliceContains(spec.Mounts[0].Options, "ro") {
+		t.Errorf("cfgProcReadonlyMounts: /proc/sys mount still read-only: %v", spec.Mounts[0].Options)
+	}
+	if !utils.StringSliceContains(spec.Mounts[1].Options, "ro") {
+		t.Errorf("cfgProcReadonlyMounts: unexpected change to mount %v", spec.Mounts[1])
+	}
+
+	want := []string{"/proc/sys"}
+	if !utils.StringSliceEqual(roPaths, want) {
+		t.Errorf("cfgProcReadonlyMounts: got read-only paths %v, want %v", roPaths, want)
+	}
+	// the caller adds them
+	want = []string{"/some/path"}
+	if !utils.StringSliceEqual(spec.Linux.ReadonlyPaths, want) {
+		t.Errorf("cfgProcReadonlyMounts: unexpected change to read-only paths %v", spec.Linux.ReadonlyPaths)
+	}
+
+	// a read-only /proc makes /proc/sys read-only too
+	spec.Mounts = []specs.Mount{
+		{Destination: "/proc", Type: "proc", Source: "proc", Options: []string{"ro", "nosuid"}},
+	}
+	roPaths = cfgProcReadonlyMounts(spec)
+	want = []string{"/proc", "/proc/sys"}
+	if !utils.StringSliceEqual(roPaths, want) {
+		t.Errorf("cfgProcReadonlyMounts: got read-only paths %v, want %v", roPaths, want)
+	}
+}
+
+func TestCfgMountPropagation(t *testing.T) {
+	spec := new(specs.Spec)
+	spec.Annotations = map[string]string{
+		MountPropagationAnnotation: "/var/lib/docker=rslave, /mnt/nfs/=slave, /nonexistent=private",
+	}
+	spec.Mounts = []specs.Mount{
+		{Destination: "/var/lib/docker", Type: "bind", Source: "/vol", Options: []string{"rbind", "rprivate"}},
+		{Destination: "/mnt/nfs", Source: "/mnt/nfs", Options: []string{"bind", "nosuid"}},
+		{Destination: "/data", Type: "bind", Source: "/data", Options: []string{"rbind", "rprivate"}},
+	}
+
+	if err := cfgMountPropagation(spec); err != nil {
+		t.Fatalf("cfgMountPropagation(): expected pass but it failed: %v", err)
+	}
+
+	want := [][]string{
+		{"rbind", "rslave"},
+		{"bind", "nosuid", "slave"},
+		{"rbind", "rprivate"},
+	}
+	for i, m := range spec.Mounts {
+		if !utils.StringSliceEqual(m.Options, want[i]) {
+			t.Errorf("cfgMountPropagation(): mount %s: got options %v, want %v", m.Destination, m.Options, want[i])
+		}
+	}
+
+	invalid := []string{
+		"/var/lib/docker=rshared",
+		"/var/lib/docker=shared",
+		"var/lib/docker=rslave",
+		"/var/lib/docker",
+	}
+	for _, val := range invalid {
+		if _, err := GetMountPropagation(map[string]string{MountPropagationAnnotation: val}); err == nil {
+			t.Errorf("GetMountPropagation(%q): expected failure", val)
+		}
+	}
+	// the propagation of non-bind mounts can't be set
+	spec.Annotations[MountPropagationAnnotation] = "/sys=rslave"
+	spec.Mounts = []specs.Mount{
+		{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"noexec", "nosuid", "nodev"}},
+	}
+	if err := cfgMountPropagation(spec); err == nil {
+		t.Errorf("cfgMountPropagation(): expected failure for sysfs mount")
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskUsage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, make([]byte, 64*1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	size, err := diskUsage(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size == 0 {
+		t.Fatalf("diskUsage(%s): want > 0, got 0", file)
+	}
+
+	// hard links are counted once
+	if err := os.Link(file, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	dirSize, err := diskUsage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirSize >= 2*size {
+		t.Errorf("diskUsage(%s): hard link counted twice (%d bytes for a %d bytes file)", dir, dirSize, size)
+	}
+
+	size, err = diskUsage(filepath.Join(dir, "none"))
+	if err != nil || size != 0 {
+		t.Errorf("diskUsage() of a non-existent path: want 0, nil; got %d, %v", size, err)
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkDiskSpace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	dataDir := filepath.Join(dir, "data")
+	if err := os.MkdirAll(filepath.Join(rootfs, "var/lib/docker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "var/lib/docker/file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := new(specs.Spec)
+	spec.Root = &specs.Root{Path: rootfs}
+
+	// a missing data dir is not checked
+	if err := checkDiskSpace(spec, dataDir, true); err != nil {
+		t.Errorf("checkDiskSpace() with missing data dir: %v", err)
+	}
+
+	if err := os.Mkdir(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkDiskSpace(spec, dataDir, true); err != nil {
+		t.Errorf("checkDiskSpace(): %v", err)
+	}
+}
+
+func TestGetMountFastPaths(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    []MountFastPath
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"bind", []MountFastPath{MountFastPathBind}, false},
+		{"propagation, bind,propagation", []MountFastPath{MountFastPathPropagation, MountFastPathBind}, false},
+		{"overlay", nil, true},
+		{"bind,", nil, true},
+	}
+
+	for _, test := range tests {
+		annotations := map[string]string{MountFastPathAnnotation: test.val}
+		got, err := GetMountFastPaths(annotations)
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetMountFastPaths(%q): want err = %v, got %v", test.val, test.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetMountFastPaths(%q): want %v, got %v", test.val, test.want, got)
+		}
+	}
+}
+
+func TestAddSyscallTrapsMountFastPaths(t *testing.T) {
+
+	// trapped evaluates the mount rules (ORed) for the given mount flags
+	trapped := func(config *configs.Config, flags uint64) bool {
+		for _, call := range config.SeccompNotif.Syscalls {
+			if call.Name != "mount" {
+				continue
+			}
+			match := true
+			for _, arg := range call.Args {
+				if arg.Index != 3 || arg.Op != configs.MaskEqualTo {
+					t.Fatalf("unexpected mount rule arg %+v", arg)
+				}
+				match = match && flags&arg.Value == arg.ValueTwo
+			}
+			if match {
+				return true
+			}
+		}
+		return false
+	}
+
+	tests := []struct {
+		fastPaths []MountFastPath
+		bypassed  []uint64
+		trapped   []uint64
+	}{
+		{
+			nil,
+			nil,
+			[]uint64{0, unix.MS_BIND, unix.MS_PRIVATE},
+		},
+		{
+			[]MountFastPath{MountFastPathBind},
+			[]uint64{unix.MS_BIND, unix.MS_BIND | unix.MS_REC, unix.MS_BIND | unix.MS_PRIVATE},
+			[]uint64{0, unix.MS_RDONLY, unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY, unix.MS_PRIVATE, unix.MS_MOVE},
+		},
+		{
+			[]MountFastPath{MountFastPathPropagation},
+			[]uint64{unix.MS_PRIVATE, unix.MS_SHARED | unix.MS_REC, unix.MS_SLAVE},
+			[]uint64{0, unix.MS_BIND, unix.MS_BIND | unix.MS_PRIVATE, unix.MS_REMOUNT | unix.MS_SHARED},
+		},
+		{
+			[]MountFastPath{MountFastPathBind, MountFastPathPropagation},
+			[]uint64{unix.MS_BIND, unix.MS_UNBINDABLE | unix.MS_REC},
+			[]uint64{0, unix.MS_NOSUID, unix.MS_MOVE, unix.MS_BIND | unix.MS_REMOUNT},
+		},
+	}
+
+	for _, test := range tests {
+		config := &configs.Config{}
+		if err := AddSyscallTraps(config, ClockSettimeDeny, test.fastPaths); err != nil {
+			t.Fatalf("AddSyscallTraps(%v): %v", test.fastPaths, err)
+		}
+		for _, flags := range test.bypassed {
+			if trapped(config, flags) {
+				t.Errorf("AddSyscallTraps(%v): mount with flags %#x trapped", test.fastPaths, flags)
+			}
+		}
+		for _, flags := range test.trapped {
+			if !trapped(config, flags) {
+				t.Errorf("AddSyscallTraps(%v): mount with flags %#x not trapped", test.fastPaths, flags)
+			}
+		}
+	}
+}
+
+func TestCpuLimit(t *testing.T) {
+	quota := int64(150000)
+	period := uint64(100000)
+	bigQuota := int64(1600000)
+
+	tests := []struct {
+		cpu  *specs.LinuxCPU
+		want int
+	}{
+		{nil, 0},
+		{&specs.LinuxCPU{}, 0},
+		{&specs.LinuxCPU{Cpus: "0-3,6"}, 5},
+		{&specs.LinuxCPU{Quota: &quota, Period: &period}, 2},
+		{&specs.LinuxCPU{Quota: &quota}, 2},
+		{&specs.LinuxCPU{Cpus: "2", Quota: &quota, Period: &period}, 1},
+		{&specs.LinuxCPU{Cpus: "0-7", Quota: &quota, Period: &period}, 2},
+		{&specs.LinuxCPU{Quota: &bigQuota, Period: &period}, 8},
+	}
+
+	for _, test := range tests {
+		spec := &specs.Spec{Linux: &specs.Linux{Resources: &specs.LinuxResources{CPU: test.cpu}}}
+		got, err := cpuLimit(spec, 8)
+		if err != nil {
+			t.Errorf("cpuLimit(%+v): %v", test.cpu, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("cpuLimit(%+v) = %d, want %d", test.cpu, got, test.want)
+		}
+	}
+
+	for _, cpus := range []string{"a", "3-1", "0-", "-1"} {
+		spec := &specs.Spec{Linux: &specs.Linux{Resources: &specs.LinuxResources{CPU: &specs.LinuxCPU{Cpus: cpus}}}}
+		if _, err := cpuLimit(spec, 8); err == nil {
+			t.Errorf("cpuLimit(%q): expected error", cpus)
+		}
+	}
+}
+
+func TestCfgCpuCoherence(t *testing.T) {
+	if _, err := GetCpuCoherenceMode(map[string]string{CpuCoherenceAnnotation: "on"}); err == nil {
+		t.Errorf("expected error for invalid %s", CpuCoherenceAnnotation)
+	}
+
+	cpus := "0-1"
+	spec := &specs.Spec{
+		Annotations: map[string]string{CpuCoherenceAnnotation: "env"},
+		Process: &specs.Process{
+			Env: []string{"PATH=/bin", "OMP_NUM_THREADS=4", "JAVA_TOOL_OPTIONS=-Xmx1g"},
+		},
+		Linux: &specs.Linux{Resources: &specs.LinuxResources{CPU: &specs.LinuxCPU{Cpus: cpus}}},
+	}
+
+	mode, err := GetCpuCoherenceMode(spec.Annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfgCpuCoherence(spec, mode); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"PATH=/bin",
+		"OMP_NUM_THREADS=4",
+		"JAVA_TOOL_OPTIONS=-Xmx1g -XX:ActiveProcessorCount=2",
+		"GOMAXPROCS=2",
+	}
+	if runtime.NumCPU() < 2 {
+		want[2] = "JAVA_TOOL_OPTIONS=-Xmx1g -XX:ActiveProcessorCount=1"
+		want[3] = "GOMAXPROCS=1"
+	}
+	if !reflect.DeepEqual(spec.Process.Env, want) {
+		t.Errorf("got env %v, want %v", spec.Process.Env, want)
+	}
+
+	// no CPU limits
+	spec.Linux.Resources.CPU = nil
+	spec.Process.Env = []string{"PATH=/bin"}
+	if err := cfgCpuCoherence(spec, mode); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(spec.Process.Env, []string{"PATH=/bin"}) {
+		t.Errorf("got env %v with no CPU limits", spec.Process.Env)
+	}
+}
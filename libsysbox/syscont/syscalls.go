@@ -22,6 +22,7 @@ import (
 	"fmt"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"golang.org/x/sys/unix"
 )
 
 // List of syscalls allowed inside a system container
@@ -377,17 +378,46 @@ var syscontSyscallTrapList = []string{
 	"fchownat",
 }
 
+// List of syscalls that set the system clock (see ClockSettimeAnnotation);
+// they are trapped & emulated with the "emulate" policy.
+var syscontClockSyscalls = []string{
+	"clock_settime",
+	"settimeofday",
+}
+
+// List of syscalls that adjust (or read the adjustment of) the system clock;
+// they are trapped & emulated with the "emulate" policy too, but never denied.
+var syscontClockAdjSyscalls = []string{
+	"adjtimex",
+	"clock_adjtime",
+}
+
+// Mount flags that tell the kinds of mounts that may bypass syscall trapping
+// (see MountFastPathAnnotation).
+const mountPropagationFlags = unix.MS_SHARED | unix.MS_PRIVATE | unix.MS_SLAVE | unix.MS_UNBINDABLE
+
 // AddSyscallTraps modifies the given libcontainer config to add seccomp notification
-// actions for syscall trapping
-func AddSyscallTraps(config *configs.Config) error {
+// actions for syscall trapping; the clock setting syscalls are trapped per the
+// given policy, and the given kinds of mounts are not trapped.
+func AddSyscallTraps(config *configs.Config, clockPolicy ClockSettimePolicy, mountFastPaths []MountFastPath) error {
 
 	if config.SeccompNotif != nil {
 		return fmt.Errorf("conflicting seccomp notification config found.")
 	}
 
-	if len(syscontSyscallTrapList) > 0 {
+	trapList := syscontSyscallTrapList
+	if clockPolicy == ClockSettimeEmulate {
+		trapList = append(append([]string{}, trapList...), syscontClockSyscalls...)
+		trapList = append(trapList, syscontClockAdjSyscalls...)
+	}
+
+	if len(trapList) > 0 {
 		list := []*configs.Syscall{}
-		for _, call := range syscontSyscallTrapList {
+		for _, call := range trapList {
+			if call == "mount" && len(mountFastPaths) > 0 {
+				list = append(list, mountTrapRules(mountFastPaths)...)
+				continue
+			}
 			s := &configs.Syscall{
 				Name:   call,
 				Action: configs.Notify,
@@ -404,3 +434,44 @@ func AddSyscallTraps(config *configs.Config) error {
 
 	return nil
 }
+
+// mountTrapRules returns the seccomp rules that trap the mount syscall except
+// for the given kinds of mounts. The rules are ORed, and trap the mount when
+// its flags have none of the flags of the kinds that bypass trapping, or any
+// of the flags of the kinds that don't (the kernel handles remounts first,
+// then bind mounts, then propagation changes, then moves).
+func mountTrapRules(fastPaths []MountFastPath) []*configs.Syscall {
+	var bypass uint64
+	trap := []uint64{unix.MS_REMOUNT, unix.MS_MOVE}
+
+	bind := false
+	for _, fp := range fastPaths {
+		switch fp {
+		case MountFastPathBind:
+			bypass |= unix.MS_BIND
+			bind = true
+		case MountFastPathPropagation:
+			bypass |= mountPropagationFlags
+		}
+	}
+	if !bind {
+		trap = append(trap, unix.MS_BIND)
+	}
+
+	// mount(source, target, fstype, flags, data)
+	const flagsArg = 3
+
+	rules := []*configs.Syscall{{
+		Name:   "mount",
+		Action: configs.Notify,
+		Args:   []*configs.Arg{{Index: flagsArg, Value: bypass, ValueTwo: 0, Op: configs.MaskEqualTo}},
+	}}
+	for _, flag := range trap {
+		rules = append(rules, &configs.Syscall{
+			Name:   "mount",
+			Action: configs.Notify,
+			Args:   []*configs.Arg{{Index: flagsArg, Value: flag, ValueTwo: flag, Op: configs.MaskEqualTo}},
+		})
+	}
+	return rules
+}
@@ -0,0 +1,200 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package syscont
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	utils "github.com/nestybox/sysbox-libs/utils"
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// KernelTracingAnnotation gives the sys container the host's debugfs and
+// tracefs (on its /sys/kernel/debug and /sys/kernel/tracing), instead of the
+// dummy tmpfs mounts, for tracing tools such as perf and bpftrace. As these
+// aren't namespaced, the container sees (and in "rw" mode, may change) the
+// host's kernel tracing state, so it's meant for trusted containers only: the
+// container must be allowed in the host's allowlist (see
+// KernelTracingAllowed()). Its value is one of:
+//
+// "none" (default): no passthrough.
+// "ro": read-only bind mounts of the host's debugfs and tracefs.
+// "rw": read-write bind mounts; the container's process must have
+// CAP_SYS_ADMIN in its bounding set. Note that writes are still subject to
+// the host's permissions on the files, as the container's root user is an
+// unprivileged user in the host.
+//
+// With passthrough, a sysbox-runc within the container no longer detects that
+// it runs in a sys container (see inSysContainer()).
+const KernelTracingAnnotation = "io.nestybox.sysbox.kernel-tracing"
+
+type KernelTracingMode string
+
+const (
+	KernelTracingNone KernelTracingMode = "none"
+	KernelTracingRo   KernelTracingMode = "ro"
+	KernelTracingRw   KernelTracingMode = "rw"
+)
+
+// kernelTracingDirs are the dirs passed through from the host in the kernel
+// tracing modes.
+var kernelTracingDirs = []string{"/sys/kernel/debug", "/sys/kernel/tracing"}
+
+// GetKernelTracing returns the kernel tracing mode given by the container's
+// annotations.
+func GetKernelTracing(annotations map[string]string) (KernelTracingMode, error) {
+	val := KernelTracingMode(strings.TrimSpace(annotations[KernelTracingAnnotation]))
+
+	switch val {
+	case "":
+		return KernelTracingNone, nil
+	case KernelTracingNone, KernelTracingRo, KernelTracingRw:
+		return val, nil
+	}
+
+	return "", fmt.Errorf("invalid value for annotation %s: %q (must be %q, %q or %q)",
+		KernelTracingAnnotation, val, KernelTracingNone, KernelTracingRo, KernelTracingRw)
+}
+
+// cfgKernelTracing adds the bind mounts of the host's debugfs and tracefs for
+// the given mode (see KernelTracingAnnotation); the dummy mounts at these must
+// have been left out.
+func cfgKernelTracing(spec *specs.Spec, mode KernelTracingMode) error {
+	if mode == KernelTracingNone {
+		return nil
+	}
+
+	for _, dir := range kernelTracingDirs {
+		for _, m := range spec.Mounts {
+			if filepath.Clean(m.Destination) == dir {
+				return fmt.Errorf("annotation %s: the spec already has a mount at %s", KernelTracingAnnotation, dir)
+			}
+		}
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: dir,
+			Source:      dir,
+			Type:        "bind",
+			Options:     []string{"rbind", string(mode), "rprivate", "nosuid", "noexec", "nodev"},
+		})
+	}
+
+	return nil
+}
+
+// checkKernelTracingCaps checks that the sys container's process has the
+// capabilities the given kernel tracing mode requires; the process spec must
+// have been converted already.
+func checkKernelTracingCaps(p *specs.Process, mode KernelTracingMode) error {
+	if mode != KernelTracingRw {
+		return nil
+	}
+	if p.Capabilities == nil || !utils.StringSliceContains(p.Capabilities.Bounding, "CAP_SYS_ADMIN") {
+		return fmt.Errorf("annotation %s: mode %q requires CAP_SYS_ADMIN in the process' bounding set",
+			KernelTracingAnnotation, mode)
+	}
+	return nil
+}
+
+// KernelTracingAllowed checks that the host's allowlist at the given path
+// allows the given container the kernel tracing mode it requests (see
+// KernelTracingAnnotation). Each line of the allowlist has the form:
+//
+// <container-id pattern> [ro|rw]
+//
+// where the pattern is matched as per filepath.Match(), and the mode is the
+// most the matching containers may request ("ro" if not given). Blank lines
+// and lines starting with '#' are ignored. Without an allowlist, no container
+// is allowed.
+func KernelTracingAllowed(path, id string, mode KernelTracingMode) error {
+	if mode == KernelTracingNone {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &sysbox.Error{
+				Code: sysbox.ErrInvalidSpec,
+				Err:  fmt.Errorf("annotation %s: kernel tracing allowlist %s not found", KernelTracingAnnotation, path),
+			}
+		}
+		return err
+	}
+	defer f.Close()
+
+	allowed, err := kernelTracingAllowedMode(f, id)
+	if err != nil {
+		return &sysbox.Error{
+			Code: sysbox.ErrInvalidConfig,
+			Err:  fmt.Errorf("kernel tracing allowlist %s: %w", path, err),
+		}
+	}
+
+	if allowed == KernelTracingNone || (mode == KernelTracingRw && allowed != KernelTracingRw) {
+		return &sysbox.Error{
+			Code: sysbox.ErrInvalidSpec,
+			Err: fmt.Errorf("annotation %s: mode %q not allowed for container %s by %s",
+				KernelTracingAnnotation, mode, id, path),
+		}
+	}
+	return nil
+}
+
+// kernelTracingAllowedMode returns the most permissive kernel tracing mode
+// that the given allowlist allows the given container.
+func kernelTracingAllowedMode(r io.Reader, id string) (KernelTracingMode, error) {
+	allowed := KernelTracingNone
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return "", fmt.Errorf("line %d: too many fields", n)
+		}
+
+		mode := KernelTracingRo
+		if len(fields) == 2 {
+			mode = KernelTracingMode(fields[1])
+			if mode != KernelTracingRo && mode != KernelTracingRw {
+				return "", fmt.Errorf("line %d: invalid mode %q (must be %q or %q)", n, mode, KernelTracingRo, KernelTracingRw)
+			}
+		}
+
+		match, err := filepath.Match(fields[0], id)
+		if err != nil {
+			return "", fmt.Errorf("line %d: invalid pattern %q: %w", n, fields[0], err)
+		}
+		if match && allowed != KernelTracingRw {
+			allowed = mode
+		}
+	}
+
+	return allowed, scanner.Err()
+}
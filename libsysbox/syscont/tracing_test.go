@@ -0,0 +1,155 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package syscont
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestGetKernelTracing(t *testing.T) {
+	tests := map[string]KernelTracingMode{
+		"":     KernelTracingNone,
+		"none": KernelTracingNone,
+		" ro ": KernelTracingRo,
+		"rw":   KernelTracingRw,
+	}
+	for val, want := range tests {
+		got, err := GetKernelTracing(map[string]string{KernelTracingAnnotation: val})
+		if err != nil || got != want {
+			t.Errorf("%q: want %q, got %q (err %v)", val, want, got, err)
+		}
+	}
+
+	if _, err := GetKernelTracing(map[string]string{KernelTracingAnnotation: "all"}); err == nil {
+		t.Errorf("expected error for an invalid mode")
+	}
+}
+
+func TestCfgKernelTracing(t *testing.T) {
+	spec := &specs.Spec{}
+	if err := cfgKernelTracing(spec, KernelTracingNone); err != nil || len(spec.Mounts) != 0 {
+		t.Errorf("none: want no mounts, got %+v (err %v)", spec.Mounts, err)
+	}
+
+	if err := cfgKernelTracing(spec, KernelTracingRo); err != nil {
+		t.Fatal(err)
+	}
+	want := []specs.Mount{
+		{
+			Destination: "/sys/kernel/debug",
+			Source:      "/sys/kernel/debug",
+			Type:        "bind",
+			Options:     []string{"rbind", "ro", "rprivate", "nosuid", "noexec", "nodev"},
+		},
+		{
+			Destination: "/sys/kernel/tracing",
+			Source:      "/sys/kernel/tracing",
+			Type:        "bind",
+			Options:     []string{"rbind", "ro", "rprivate", "nosuid", "noexec", "nodev"},
+		},
+	}
+	if !reflect.DeepEqual(spec.Mounts, want) {
+		t.Errorf("ro: want mounts %+v, got %+v", want, spec.Mounts)
+	}
+
+	spec = &specs.Spec{
+		Mounts: []specs.Mount{{Destination: "/sys/kernel/tracing/", Type: "tmpfs", Source: "tmpfs"}},
+	}
+	if err := cfgKernelTracing(spec, KernelTracingRw); err == nil {
+		t.Errorf("expected error for a conflicting spec mount")
+	}
+}
+
+func TestCheckKernelTracingCaps(t *testing.T) {
+	p := &specs.Process{
+		Capabilities: &specs.LinuxCapabilities{Bounding: []string{"CAP_CHOWN"}},
+	}
+	if err := checkKernelTracingCaps(p, KernelTracingRo); err != nil {
+		t.Errorf("ro: unexpected error: %v", err)
+	}
+	if err := checkKernelTracingCaps(p, KernelTracingRw); err == nil {
+		t.Errorf("rw: expected error without CAP_SYS_ADMIN")
+	}
+	p.Capabilities.Bounding = append(p.Capabilities.Bounding, "CAP_SYS_ADMIN")
+	if err := checkKernelTracingCaps(p, KernelTracingRw); err != nil {
+		t.Errorf("rw: unexpected error: %v", err)
+	}
+}
+
+func TestKernelTracingAllowedMode(t *testing.T) {
+	allowlist := `
+# tracing hosts
+perf-*
+perf-rw-* rw
+bpf ro
+`
+	tests := map[string]KernelTracingMode{
+		"perf-1":    KernelTracingRo,
+		"perf-rw-1": KernelTracingRw,
+		"bpf":       KernelTracingRo,
+		"bpf-1":     KernelTracingNone,
+	}
+	for id, want := range tests {
+		got, err := kernelTracingAllowedMode(strings.NewReader(allowlist), id)
+		if err != nil || got != want {
+			t.Errorf("%s: want %q, got %q (err %v)", id, want, got, err)
+		}
+	}
+
+	for _, bad := range []string{"perf-* rw extra", "perf-* none", "perf-[ ro"} {
+		if _, err := kernelTracingAllowedMode(strings.NewReader(bad), "perf-1"); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestKernelTracingAllowed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernel-tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kernel-tracing.allow")
+
+	if err := KernelTracingAllowed(path, "c1", KernelTracingNone); err != nil {
+		t.Errorf("none: unexpected error without allowlist: %v", err)
+	}
+	if err := KernelTracingAllowed(path, "c1", KernelTracingRo); err == nil {
+		t.Errorf("ro: expected error without allowlist")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("c*\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := KernelTracingAllowed(path, "c1", KernelTracingRo); err != nil {
+		t.Errorf("ro: unexpected error: %v", err)
+	}
+	if err := KernelTracingAllowed(path, "c1", KernelTracingRw); err == nil {
+		t.Errorf("rw: expected error with a ro entry")
+	}
+	if err := KernelTracingAllowed(path, "d1", KernelTracingRo); err == nil {
+		t.Errorf("ro: expected error without an entry")
+	}
+}
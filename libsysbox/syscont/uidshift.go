@@ -0,0 +1,169 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +build linux
+
+package syscont
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// UidShiftBackendsAnnotation selects the backends that may be used to shift
+// the ownership of the sys container's rootfs and bind mount sources to its
+// user-ID range, for filesystems that misbehave with some of them. Its value
+// is a comma separated list of:
+//
+// "shiftfs": shiftfs mounts (rootfs and bind mounts; needs the shiftfs module).
+// "idmapped-mount": ID-mapped mounts (bind mounts on network filesystems).
+// "chown": chown'ing the files (rootfs only).
+//
+// or "none". Backends not listed are disabled, and the rootfs uses the first
+// listed one that's available (e.g., "chown,shiftfs" forces chown'ing it).
+// If the rootfs must be shifted but no backend is available, the container
+// isn't created; bind mounts are left unshifted instead. Defaults to
+// "shiftfs,idmapped-mount": chown'ing is slow and modifies the rootfs, so it
+// must be enabled explicitly (here, or by the host). A host may restrict the
+// backends further (see ApplyUidShiftBackends()).
+const UidShiftBackendsAnnotation = "io.nestybox.sysbox.uid-shift-backends"
+
+type UidShiftBackend string
+
+const (
+	UidShiftShiftfs UidShiftBackend = "shiftfs"
+	UidShiftIdmap   UidShiftBackend = "idmapped-mount"
+	UidShiftChown   UidShiftBackend = "chown"
+)
+
+// uidShiftBackends are all the uid shifting backends.
+var uidShiftBackends = []UidShiftBackend{UidShiftShiftfs, UidShiftIdmap, UidShiftChown}
+
+// defaultUidShiftBackends are the uid shifting backends used unless others
+// are given, in order.
+var defaultUidShiftBackends = []UidShiftBackend{UidShiftShiftfs, UidShiftIdmap}
+
+// ParseUidShiftBackends parses a list of uid shifting backends, as given in
+// UidShiftBackendsAnnotation; an empty list means the default ones.
+func ParseUidShiftBackends(val string) ([]UidShiftBackend, error) {
+	val = strings.TrimSpace(val)
+	switch val {
+	case "":
+		return append([]UidShiftBackend{}, defaultUidShiftBackends...), nil
+	case "none":
+		return []UidShiftBackend{}, nil
+	}
+
+	backends := []UidShiftBackend{}
+	for _, b := range strings.Split(val, ",") {
+		b := UidShiftBackend(strings.TrimSpace(b))
+		if !HasUidShiftBackend(uidShiftBackends, b) {
+			return nil, fmt.Errorf("invalid uid shifting backend %q (must be one of: %s, %s, %s)",
+				b, UidShiftShiftfs, UidShiftIdmap, UidShiftChown)
+		}
+		if !HasUidShiftBackend(backends, b) {
+			backends = append(backends, b)
+		}
+	}
+	return backends, nil
+}
+
+// GetUidShiftBackends returns the uid shifting backends given by the
+// container's annotations.
+func GetUidShiftBackends(annotations map[string]string) ([]UidShiftBackend, error) {
+	backends, err := ParseUidShiftBackends(annotations[UidShiftBackendsAnnotation])
+	if err != nil {
+		return nil, fmt.Errorf("annotation %s: %w", UidShiftBackendsAnnotation, err)
+	}
+	return backends, nil
+}
+
+// ApplyUidShiftBackends restricts the uid shifting backends of the sys
+// container to the given ones, allowed by the host: it fails if the spec
+// requests others, and otherwise sets the spec's annotation to the allowed
+// backends (if it has none).
+func ApplyUidShiftBackends(spec *specs.Spec, allowed []UidShiftBackend) error {
+	backends, err := GetUidShiftBackends(spec.Annotations)
+	if err != nil {
+		return err
+	}
+
+	if spec.Annotations[UidShiftBackendsAnnotation] == "" {
+		backends = allowed
+	}
+	for _, b := range backends {
+		if !HasUidShiftBackend(allowed, b) {
+			return fmt.Errorf("annotation %s: uid shifting backend %q is disabled in this host",
+				UidShiftBackendsAnnotation, b)
+		}
+	}
+
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	spec.Annotations[UidShiftBackendsAnnotation] = formatUidShiftBackends(backends)
+	return nil
+}
+
+// RootfsUidShiftBackend returns the backend that shifts the ownership of the
+// sys container's rootfs, the first among the given ones that's available
+// (chown'ing always is, shiftfs if shiftfsSupported).
+func RootfsUidShiftBackend(backends []UidShiftBackend, shiftfsSupported bool) (UidShiftBackend, error) {
+	for _, b := range backends {
+		if b == UidShiftChown || (b == UidShiftShiftfs && shiftfsSupported) {
+			return b, nil
+		}
+	}
+	if !shiftfsSupported && HasUidShiftBackend(backends, UidShiftShiftfs) {
+		return "", &sysbox.Error{
+			Code: sysbox.ErrShiftfsMissing,
+			Err: fmt.Errorf("this container requires user-ID shifting but the kernel does not support it." +
+				" Upgrade your kernel to include the shiftfs module, or alternatively enable Linux user-namespace" +
+				" support in the the container manager (e.g., Docker userns-remap, CRI-O userns annotation, etc)," +
+				" or enable chown'ing the rootfs (see annotation " + UidShiftBackendsAnnotation + ")." +
+				" Refer to the Sysbox troubleshooting guide for more info."),
+		}
+	}
+	return "", fmt.Errorf("the container's rootfs needs uid shifting, but none of the enabled backends (%s) is available (see annotation %s)",
+		formatUidShiftBackends(backends), UidShiftBackendsAnnotation)
+}
+
+// HasUidShiftBackend reports whether the given backend is among the given
+// ones.
+func HasUidShiftBackend(backends []UidShiftBackend, b UidShiftBackend) bool {
+	for _, bb := range backends {
+		if bb == b {
+			return true
+		}
+	}
+	return false
+}
+
+// formatUidShiftBackends formats the given backends as in
+// UidShiftBackendsAnnotation.
+func formatUidShiftBackends(backends []UidShiftBackend) string {
+	if len(backends) == 0 {
+		return "none"
+	}
+	names := []string{}
+	for _, b := range backends {
+		names = append(names, string(b))
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,109 @@
+//
+// Copyright 2019-2020 Nestybox, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package syscont
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestParseUidShiftBackends(t *testing.T) {
+	tests := map[string][]UidShiftBackend{
+		"":                      {UidShiftShiftfs, UidShiftIdmap},
+		"none":                  {},
+		"chown, shiftfs":        {UidShiftChown, UidShiftShiftfs},
+		"idmapped-mount,chown":  {UidShiftIdmap, UidShiftChown},
+		"shiftfs,shiftfs,chown": {UidShiftShiftfs, UidShiftChown},
+	}
+	for val, want := range tests {
+		got, err := ParseUidShiftBackends(val)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: want %v, got %v (err %v)", val, want, got, err)
+		}
+	}
+
+	for _, val := range []string{"idmap", "shiftfs,", "none,chown"} {
+		if _, err := ParseUidShiftBackends(val); err == nil {
+			t.Errorf("%q: expected error", val)
+		}
+	}
+}
+
+func TestApplyUidShiftBackends(t *testing.T) {
+	allowed := []UidShiftBackend{UidShiftShiftfs, UidShiftChown}
+
+	// the host's backends apply by default
+	spec := &specs.Spec{}
+	if err := ApplyUidShiftBackends(spec, allowed); err != nil {
+		t.Fatal(err)
+	}
+	if got := spec.Annotations[UidShiftBackendsAnnotation]; got != "shiftfs,chown" {
+		t.Errorf("want annotation %q, got %q", "shiftfs,chown", got)
+	}
+
+	// the container's are kept, in its order
+	spec = &specs.Spec{Annotations: map[string]string{UidShiftBackendsAnnotation: "chown,shiftfs"}}
+	if err := ApplyUidShiftBackends(spec, allowed); err != nil {
+		t.Fatal(err)
+	}
+	if got := spec.Annotations[UidShiftBackendsAnnotation]; got != "chown,shiftfs" {
+		t.Errorf("want annotation %q, got %q", "chown,shiftfs", got)
+	}
+
+	spec = &specs.Spec{Annotations: map[string]string{UidShiftBackendsAnnotation: "idmapped-mount"}}
+	if err := ApplyUidShiftBackends(spec, allowed); err == nil {
+		t.Errorf("expected error for a backend disabled in the host")
+	}
+
+	spec = &specs.Spec{}
+	if err := ApplyUidShiftBackends(spec, []UidShiftBackend{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := spec.Annotations[UidShiftBackendsAnnotation]; got != "none" {
+		t.Errorf("want annotation %q, got %q", "none", got)
+	}
+}
+
+func TestRootfsUidShiftBackend(t *testing.T) {
+	tests := []struct {
+		backends []UidShiftBackend
+		shiftfs  bool
+		want     UidShiftBackend
+	}{
+		{[]UidShiftBackend{UidShiftShiftfs, UidShiftIdmap, UidShiftChown}, true, UidShiftShiftfs},
+		{[]UidShiftBackend{UidShiftShiftfs, UidShiftIdmap, UidShiftChown}, false, UidShiftChown},
+		{[]UidShiftBackend{UidShiftIdmap, UidShiftChown, UidShiftShiftfs}, true, UidShiftChown},
+	}
+	for _, test := range tests {
+		got, err := RootfsUidShiftBackend(test.backends, test.shiftfs)
+		if err != nil || got != test.want {
+			t.Errorf("%v (shiftfs %v): want %q, got %q (err %v)", test.backends, test.shiftfs, test.want, got, err)
+		}
+	}
+
+	// chown'ing must be enabled explicitly
+	_, err := RootfsUidShiftBackend([]UidShiftBackend{UidShiftShiftfs, UidShiftIdmap}, false)
+	if sysbox.GetErrorCode(err) != sysbox.ErrShiftfsMissing {
+		t.Errorf("want error %s without shiftfs, got %v", sysbox.ErrShiftfsMissing, err)
+	}
+	if _, err := RootfsUidShiftBackend([]UidShiftBackend{UidShiftIdmap}, true); err == nil {
+		t.Errorf("expected error without an available backend")
+	}
+}
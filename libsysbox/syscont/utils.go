@@ -24,6 +24,15 @@ import (
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
+// sortMountsByDest sorts the mounts in the given spec by destination, keeping
+// the order of the mounts on the same destination (as each one stacks on the
+// previous ones). Mounts on a dir thus come before those under it.
+func sortMountsByDest(spec *specs.Spec) {
+	sort.SliceStable(spec.Mounts, func(i, j int) bool {
+		return spec.Mounts[i].Destination < spec.Mounts[j].Destination
+	})
+}
+
 // sortMounts sorts the sys container mounts in the given spec.
 func sortMounts(spec *specs.Spec) {
 
@@ -3,8 +3,8 @@
 package main
 
 import (
-	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -12,39 +12,14 @@ import (
 	"text/tabwriter"
 	"time"
 
-	"encoding/json"
-
 	"github.com/opencontainers/runc/libcontainer"
 	"github.com/opencontainers/runc/libcontainer/user"
 	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/opencontainers/runc/types"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
-const formatOptions = `table or json`
-
-// containerState represents the platform agnostic pieces relating to a
-// running container's status and state
-type containerState struct {
-	// Version is the OCI version for the container
-	Version string `json:"ociVersion"`
-	// ID is the container ID
-	ID string `json:"id"`
-	// InitProcessPid is the init process id in the parent namespace
-	InitProcessPid int `json:"pid"`
-	// Status is the current status of the container, running, paused, ...
-	Status string `json:"status"`
-	// Bundle is the path on the filesystem to the bundle
-	Bundle string `json:"bundle"`
-	// Rootfs is a path to a directory containing the container's root filesystem.
-	Rootfs string `json:"rootfs"`
-	// Created is the unix timestamp for the creation time of the container in UTC
-	Created time.Time `json:"created"`
-	// Annotations is the user defined annotations added to the config.
-	Annotations map[string]string `json:"annotations,omitempty"`
-	// The owner of the state directory (the owner of the container).
-	Owner string `json:"owner"`
-}
-
 var listCommand = cli.Command{
 	Name:  "list",
 	Usage: "lists containers started by sysbox-runc with the given root",
@@ -59,27 +34,56 @@ To list containers created via the default "--root":
 
 EXAMPLE 2:
 To list containers created using a non-default value for "--root":
-       # sysbox-runc --root value list`,
+       # sysbox-runc --root value list
+
+EXAMPLE 3:
+To list the containers of all state roots used on the host (e.g., by multiple
+container engines or tenants):
+       # sysbox-runc list --all-roots`,
 	Flags: []cli.Flag{
-		cli.StringFlag{
-			Name:  "format, f",
-			Value: "table",
-			Usage: `select one of: ` + formatOptions,
-		},
+		outputFlag(outputTable, outputJSON, outputYAML),
+		formatFlag,
 		cli.BoolFlag{
 			Name:  "quiet, q",
 			Usage: "display only container IDs",
 		},
+		cli.BoolFlag{
+			Name:  "all-roots",
+			Usage: "list the containers of all state roots containers were created in (not just --root)",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 0, exactArgs); err != nil {
 			return err
 		}
-		s, err := getContainers(context)
+		format, err := outputFormat(context, outputTable, outputJSON, outputYAML)
 		if err != nil {
 			return err
 		}
 
+		var s []types.ContainerState
+		if context.Bool("all-roots") {
+			roots, err := knownRoots(context.GlobalString("root"))
+			if err != nil {
+				return err
+			}
+			for _, root := range roots {
+				rs, err := getContainers(context, root)
+				if err != nil {
+					return err
+				}
+				for i := range rs {
+					rs[i].Root = root
+				}
+				s = append(s, rs...)
+			}
+		} else {
+			s, err = getContainers(context, context.GlobalString("root"))
+			if err != nil {
+				return err
+			}
+		}
+
 		if context.Bool("quiet") {
 			for _, item := range s {
 				fmt.Println(item.ID)
@@ -87,40 +91,61 @@ To list containers created using a non-default value for "--root":
 			return nil
 		}
 
-		switch context.String("format") {
-		case "table":
-			w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-			fmt.Fprint(w, "ID\tPID\tSTATUS\tBUNDLE\tCREATED\tOWNER\n")
-			for _, item := range s {
-				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n",
-					item.ID,
-					item.InitProcessPid,
-					item.Status,
-					item.Bundle,
-					item.Created.Format(time.RFC3339Nano),
-					item.Owner)
-			}
-			if err := w.Flush(); err != nil {
-				return err
+		if format == outputTable {
+			if context.Bool("all-roots") {
+				return writeContainerTableWithRoot(os.Stdout, s)
 			}
-		case "json":
-			if err := json.NewEncoder(os.Stdout).Encode(s); err != nil {
-				return err
-			}
-		default:
-			return errors.New("invalid format option")
+			return writeContainerTable(os.Stdout, s)
 		}
-		return nil
+		return writeOutput(os.Stdout, format, s)
 	},
 }
 
-func getContainers(context *cli.Context) ([]containerState, error) {
-	factory, err := loadFactory(context, nil, nil)
+// writeContainerTable writes the given containers' state as a table.
+func writeContainerTable(out io.Writer, s []types.ContainerState) error {
+	w := tabwriter.NewWriter(out, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tPID\tSTATUS\tBUNDLE\tCREATED\tOWNER\n")
+	for _, item := range s {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n",
+			item.ID,
+			item.InitProcessPid,
+			item.Status,
+			item.Bundle,
+			item.Created.Format(time.RFC3339Nano),
+			item.Owner)
+	}
+	return w.Flush()
+}
+
+// writeContainerTableWithRoot is like writeContainerTable, with a column for
+// the containers' state root.
+func writeContainerTableWithRoot(out io.Writer, s []types.ContainerState) error {
+	w := tabwriter.NewWriter(out, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tPID\tSTATUS\tBUNDLE\tCREATED\tOWNER\tROOT\n")
+	for _, item := range s {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			item.ID,
+			item.InitProcessPid,
+			item.Status,
+			item.Bundle,
+			item.Created.Format(time.RFC3339Nano),
+			item.Owner,
+			item.Root)
+	}
+	return w.Flush()
+}
+
+// getContainers returns the state of the containers in the given state root.
+func getContainers(context *cli.Context, root string) ([]types.ContainerState, error) {
+	absRoot, err := filepath.Abs(root)
 	if err != nil {
 		return nil, err
 	}
-	root := context.GlobalString("root")
-	absRoot, err := filepath.Abs(root)
+	opts, err := factoryOpts(context)
+	if err != nil {
+		return nil, err
+	}
+	factory, err := libcontainer.New(absRoot, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +154,14 @@ func getContainers(context *cli.Context) ([]containerState, error) {
 		fatal(err)
 	}
 
-	var s []containerState
+	// sysbox-runc: the containers with a valid entry in the state index (if
+	// any) aren't loaded
+	index, err := libcontainer.ReadStateIndex(absRoot)
+	if err != nil {
+		logrus.Warnf("failed to read the state index of %s: %v", absRoot, err)
+	}
+
+	var s []types.ContainerState
 	for _, item := range list {
 		if item.IsDir() {
 			// This cast is safe on Linux.
@@ -139,6 +171,28 @@ func getContainers(context *cli.Context) ([]containerState, error) {
 				owner.Name = fmt.Sprintf("#%d", stat.Uid)
 			}
 
+			if entry, ok := index[item.Name()]; ok {
+				if containerStatus, valid := entry.Status(absRoot); valid {
+					pid := entry.InitProcessPid
+					if containerStatus == libcontainer.Stopped {
+						pid = 0
+					}
+					bundle, annotations := utils.Annotations(entry.Labels)
+					s = append(s, types.ContainerState{
+						Version:        entry.Version,
+						ID:             entry.ID,
+						InitProcessPid: pid,
+						Status:         containerStatus.String(),
+						Bundle:         bundle,
+						Rootfs:         entry.Rootfs,
+						Created:        entry.Created,
+						Annotations:    annotations,
+						Owner:          owner.Name,
+					})
+					continue
+				}
+			}
+
 			container, err := factory.Load(item.Name())
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "load container %s: %v\n", item.Name(), err)
@@ -159,7 +213,7 @@ func getContainers(context *cli.Context) ([]containerState, error) {
 				pid = 0
 			}
 			bundle, annotations := utils.Annotations(state.Config.Labels)
-			s = append(s, containerState{
+			s = append(s, types.ContainerState{
 				Version:        state.BaseState.Config.Version,
 				ID:             state.BaseState.ID,
 				InitProcessPid: pid,
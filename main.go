@@ -6,6 +6,8 @@ import (
 	"os"
 
 	"github.com/opencontainers/runc/libcontainer/logs"
+	"github.com/opencontainers/runc/libsysbox"
+	"github.com/opencontainers/runc/libsysbox/extension"
 	"github.com/opencontainers/runtime-spec/specs-go"
 
 	"github.com/sirupsen/logrus"
@@ -58,6 +60,8 @@ func main() {
 		}
 	}
 
+	defaultRoot = root
+
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
 			Name:  "debug",
@@ -68,15 +72,29 @@ func main() {
 			Value: "",
 			Usage: "set the log file path where internal debug information is written",
 		},
+		cli.StringFlag{
+			Name:  "container-log-dir",
+			Value: defaultContainerLogDir,
+			Usage: "dir of the containers' log files (see the io.nestybox.sysbox.log-file annotation)",
+		},
 		cli.StringFlag{
 			Name:  "log-format",
 			Value: "text",
-			Usage: "set the format used by logs ('text' (default), or 'json')",
+			Usage: "set the format used by logs ('text' (default), or 'json'); with 'json', errors carry a stable error code",
 		},
 		cli.StringFlag{
 			Name:  "root",
 			Value: root,
-			Usage: "root directory for storage of container state (this should be located in tmpfs)",
+			Usage: "root directory for storage of container state (see --state-backend)",
+		},
+		cli.StringFlag{
+			Name:  "state-backend",
+			Value: stateBackendAuto,
+			Usage: "where the container state is kept unless --root is given: 'tmpfs' (" + root + "), 'dir' (" + persistentRoot + ", which survives reboots), or 'auto' (tmpfs, unless it's read-only)",
+		},
+		cli.BoolFlag{
+			Name:  "state-index",
+			Usage: "keep an index of the containers in the state root, so that they're listed without loading each of their states",
 		},
 		cli.BoolFlag{
 			Name:  "no-sysbox-fs",
@@ -90,6 +108,73 @@ func main() {
 			Name:  "no-kernel-check",
 			Usage: "do not check kernel compatibility; meant for testing and debugging.",
 		},
+		cli.BoolFlag{
+			Name:  "disk-check",
+			Usage: "check for enough disk space for the container's rootfs and sysbox-mgr mounts before creating it",
+		},
+		cli.StringFlag{
+			Name:  "audit-log",
+			Usage: "record the changes made to the spec of each container created to the given sink: file:<path>, syslog or journald (see docs/audit.md)",
+		},
+		cli.StringFlag{
+			Name:  "extensions-dir",
+			Value: extension.DefaultDir,
+			Usage: "dir of the extensions run at the system container's extension points (see docs/extensions.md)",
+		},
+		cli.StringFlag{
+			Name:  "sysbox-hooks-dir",
+			Value: libsysbox.DefaultSysboxHooksDir,
+			Usage: "dir of the hooks run with the system container's sysbox state (see runc-delete(8))",
+		},
+		cli.StringFlag{
+			Name:  "uid-shift-backends",
+			Usage: "uid shifting backends containers may use, in order of preference: a comma separated list of shiftfs, idmapped-mount and chown, or none (default: all; containers that don't select theirs use them in that order, except chown, which is only used if enabled explicitly)",
+		},
+		cli.StringFlag{
+			Name:  "idmap-fuse-helper",
+			Usage: "FUSE program (bindfs) that uid-shifts the bind mounts whose filesystem doesn't support id-mapped mounts (e.g., NFS, CIFS); by default they're not uid-shifted",
+		},
+		cli.StringFlag{
+			Name:  "kernel-tracing-allowlist",
+			Value: libsysbox.DefaultKernelTracingAllowlist,
+			Usage: "file listing the containers that may get the host's debugfs and tracefs (see the io.nestybox.sysbox.kernel-tracing annotation)",
+		},
+		cli.StringFlag{
+			Name:  "shared-mounts-allowlist",
+			Value: libsysbox.DefaultSharedMountsAllowlist,
+			Usage: "file listing the host dirs containers may share (see the io.nestybox.sysbox.shared-mounts annotation)",
+		},
+		cli.StringFlag{
+			Name:  "configfs-passthrough-allowlist",
+			Value: libsysbox.DefaultConfigfsAllowlist,
+			Usage: "file listing the configfs subtrees containers may get from the host (see the io.nestybox.sysbox.configfs annotation)",
+		},
+		cli.StringFlag{
+			Name:  "core-dump-dir",
+			Value: libsysbox.DefaultCoreDumpDir,
+			Usage: "dir under which the core dumps of the containers that capture them are stored, in a dir per container (see the io.nestybox.sysbox.core-dump annotation)",
+		},
+		cli.StringFlag{
+			Name:  "upper-dirs",
+			Usage: "comma separated list of host dirs under which containers may place the writable layer of their rootfs (see the io.nestybox.sysbox.upper-dir annotation); none by default",
+		},
+		cli.StringFlag{
+			Name:  "snapshot-dirs",
+			Usage: "comma separated list of host dirs holding the snapshots containers may be seeded from (see the io.nestybox.sysbox.seed-snapshot annotation); none by default",
+		},
+		cli.StringFlag{
+			Name:  "ssh-keys-dir",
+			Value: libsysbox.DefaultSSHKeysDir,
+			Usage: "dir the SSH keys copied into containers are taken from (see the io.nestybox.sysbox.ssh-host-keys and io.nestybox.sysbox.ssh-authorized-keys annotations)",
+		},
+		cli.BoolFlag{
+			Name:  "seccomp-keeper",
+			Usage: "hold the seccomp notification fds of the containers' processes in a helper process per container, so that they can be reconnected to sysbox-fs if it restarts (see fs-reconnect); while sysbox-fs is down, their trapped syscalls then block rather than fail",
+		},
+		cli.BoolFlag{
+			Name:  "subreaper",
+			Usage: "reap the orphaned descendants of the processes sysbox-runc launches (e.g., when not run by a shim); their exit statuses are logged at debug level",
+		},
 		cli.BoolFlag{
 			Name:   "cpu-profiling",
 			Usage:  "enable cpu-profiling data collection; profile data is stored in the cwd of the process invoking sysbox-runc. Ignore the 'cannot set cpu profile rate' message (it's expected).",
@@ -107,20 +192,37 @@ func main() {
 	}
 
 	app.Commands = []cli.Command{
+		activateCommand,
+		cleanupCommand,
+		convertSpecCommand,
+		coreDumpCommand,
 		createCommand,
 		deleteCommand,
 		eventsCommand,
 		execCommand,
+		fsReconnectCommand,
 		initCommand,
 		killCommand,
 		listCommand,
+		notifyProxyCommand,
 		pauseCommand,
+		portProxyCommand,
 		psCommand,
+		reapCommand,
+		refreshHostConfigsCommand,
 		resumeCommand,
 		runCommand,
+		runtimeClassCommand,
+		schemaCommand,
+		selftestCommand,
+		snapshotCommand,
 		specCommand,
+		specConvertCommand,
+		specDiffCommand,
 		startCommand,
 		stateCommand,
+		stdioLoggerCommand,
+		umountCommand,
 		updateCommand,
 	}
 
@@ -141,7 +243,18 @@ func main() {
 		if err := reviseRootDir(context); err != nil {
 			return err
 		}
-		return logs.ConfigureLogging(createLogConfig(context))
+		if err := logs.ConfigureLogging(createLogConfig(context)); err != nil {
+			return err
+		}
+		if err := resolveStateBackend(context); err != nil {
+			return err
+		}
+		return setupSubreaper(context)
+	}
+
+	app.After = func(context *cli.Context) error {
+		reapOrphans(context)
+		return nil
 	}
 
 	// If the command returns an error, cli takes upon itself to print
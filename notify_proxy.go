@@ -0,0 +1,224 @@
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// notifyProxyDir is the dir of the notify proxy's socket, in the
+	// container's state dir (and mounted in the container).
+	notifyProxyDir = "notify-proxy"
+
+	// notifyProxyReadyFile is the file, in the container's state dir, that
+	// records when its payload notified its readiness.
+	notifyProxyReadyFile = "ready"
+
+	// notifyProxyPollInterval is how often the notify proxy checks whether
+	// the container's init process is still alive.
+	notifyProxyPollInterval = time.Second
+)
+
+// notifyProxy marks the container as ready once its payload notifies so (see
+// syscont.NotifyProxyAnnotation): the payload's notify socket is read by a
+// helper process (see notifyProxyCommand), which records the time of the
+// READY=1 notification in the container's state dir. The helper outlives
+// sysbox-runc (e.g., between the create and start commands), and exits once
+// the payload is ready or the container's init process exits.
+//
+// Unlike the NOTIFY_SOCKET support (see notifySocket), it's meant for
+// container engines, which learn of the readiness via the state command.
+type notifyProxy struct {
+	socketPath string
+	readyPath  string
+	logPath    string
+	logFormat  string
+}
+
+// newNotifyProxy returns the notify proxy of the container with the given id
+// and spec, or nil if the spec doesn't request it.
+func newNotifyProxy(context *cli.Context, spec *specs.Spec, id string) (*notifyProxy, error) {
+	enabled, err := syscont.GetNotifyProxy(spec.Annotations)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	if os.Getenv("NOTIFY_SOCKET") != "" {
+		return nil, fmt.Errorf("annotation %s can't be combined with a NOTIFY_SOCKET", syscont.NotifyProxyAnnotation)
+	}
+
+	root := filepath.Join(context.GlobalString("root"), id)
+	return &notifyProxy{
+		socketPath: filepath.Join(root, notifyProxyDir, "notify.sock"),
+		readyPath:  filepath.Join(root, notifyProxyReadyFile),
+		logPath:    context.GlobalString("log"),
+		logFormat:  context.GlobalString("log-format"),
+	}, nil
+}
+
+// setupSpec mounts the proxy's socket dir in the container, and points its
+// payload to the socket.
+func (p *notifyProxy) setupSpec(spec *specs.Spec) {
+	pathInContainer := filepath.Join("/run/notify", filepath.Base(p.socketPath))
+	spec.Mounts = append(spec.Mounts, specs.Mount{
+		Destination: filepath.Dir(pathInContainer),
+		Source:      filepath.Dir(p.socketPath),
+		Options:     []string{"bind", "nosuid", "noexec", "nodev", "ro"},
+	})
+	spec.Process.Env = append(spec.Process.Env, "NOTIFY_SOCKET="+pathInContainer)
+}
+
+// setupSocketDirectory creates the proxy's socket dir; the container's state
+// dir must have been created.
+func (p *notifyProxy) setupSocketDirectory() error {
+	return os.Mkdir(filepath.Dir(p.socketPath), 0755)
+}
+
+// start starts the proxy's helper process for the given container, whose init
+// process must have been created. The socket is bound here (and handed over to
+// the helper), so that errors are reported to the caller.
+func (p *notifyProxy) start(container libcontainer.Container) error {
+	state, err := container.State()
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: p.socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	// the container's processes (whatever their uid) send to it
+	if err := os.Chmod(p.socketPath, 0777); err != nil {
+		conn.Close()
+		return err
+	}
+	f, err := conn.File()
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	args := []string{}
+	if p.logPath != "" {
+		args = append(args, "--log", p.logPath, "--log-format", p.logFormat)
+	}
+	args = append(args, "notify-proxy", "--pid", strconv.Itoa(state.InitProcessPid), "--ready", p.readyPath)
+
+	// the helper gets the socket as fd 3
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Args[0] = os.Args[0]
+	cmd.ExtraFiles = []*os.File{f}
+	// the helper must not get the signals sent to sysbox-runc's process group
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start notify proxy: %w", err)
+	}
+	go cmd.Wait()
+
+	return nil
+}
+
+var notifyProxyCommand = cli.Command{
+	Name:   "notify-proxy",
+	Usage:  `mark a container as ready once its payload notifies so (do not call it outside of sysbox-runc)`,
+	Hidden: true,
+	Flags: []cli.Flag{
+		cli.IntFlag{Name: "pid"},
+		cli.StringFlag{Name: "ready"},
+	},
+	Action: func(context *cli.Context) error {
+		f := os.NewFile(3, "notify socket")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		conn, ok := c.(*net.UnixConn)
+		if !ok {
+			return errors.New("the notify socket is not a unix socket")
+		}
+		defer conn.Close()
+
+		// the container's init process is gone once its pid no longer refers
+		// to a process in the container's user ns
+		path := fmt.Sprintf("/proc/%d/ns/user", context.Int("pid"))
+		var nsStat unix.Stat_t
+		if err := unix.Stat(path, &nsStat); err != nil {
+			return nil
+		}
+
+		buf := make([]byte, 4096)
+		for {
+			conn.SetReadDeadline(time.Now().Add(notifyProxyPollInterval))
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+					return err
+				}
+				var st unix.Stat_t
+				if err := unix.Stat(path, &st); err != nil || st.Ino != nsStat.Ino || st.Dev != nsStat.Dev {
+					return nil
+				}
+				continue
+			}
+			if notifiesReady(buf[:n]) {
+				return markReady(context.String("ready"), time.Now())
+			}
+		}
+	},
+}
+
+// notifiesReady returns true if the given sd_notify(3) message notifies the
+// sender's readiness.
+func notifiesReady(msg []byte) bool {
+	for _, line := range bytes.Split(msg, []byte{'\n'}) {
+		if string(line) == "READY=1" {
+			return true
+		}
+	}
+	return false
+}
+
+// markReady records the given time as that of the container's readiness, in
+// the given file.
+func markReady(path string, t time.Time) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(t.UTC().Format(time.RFC3339Nano)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// notifyProxyReady returns when the payload of the container with the given
+// state dir notified its readiness via the notify proxy, or nil if it hasn't.
+func notifyProxyReady(stateDir string) (*time.Time, error) {
+	data, err := ioutil.ReadFile(filepath.Join(stateDir, notifyProxyReadyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ready time in %s: %w", filepath.Join(stateDir, notifyProxyReadyFile), err)
+	}
+	return &t, nil
+}
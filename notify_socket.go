@@ -13,6 +13,7 @@ import (
 
 	"github.com/opencontainers/runc/libcontainer"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
@@ -20,6 +21,12 @@ type notifySocket struct {
 	socket     *net.UnixConn
 	host       string
 	socketPath string
+
+	// sysbox-runc: whether the notifications sent after the container is
+	// ready are proxied to the host too (see proxy()), and the watchdog
+	// interval the host expects them within (if any)
+	proxying     bool
+	watchdogUsec string
 }
 
 func newNotifySocket(context *cli.Context, notifySocketHost string, id string) *notifySocket {
@@ -36,6 +43,12 @@ func newNotifySocket(context *cli.Context, notifySocketHost string, id string) *
 		socketPath: socketPath,
 	}
 
+	// sysbox-runc: the host's watchdog, if it's meant for us (see
+	// sd_watchdog_enabled(3))
+	if pid := os.Getenv("WATCHDOG_PID"); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+		notifySocket.watchdogUsec = os.Getenv("WATCHDOG_USEC")
+	}
+
 	return notifySocket
 }
 
@@ -54,6 +67,17 @@ func (s *notifySocket) setupSpec(context *cli.Context, spec *specs.Spec) error {
 	}
 	spec.Mounts = append(spec.Mounts, mount)
 	spec.Process.Env = append(spec.Process.Env, "NOTIFY_SOCKET="+pathInContainer)
+
+	// sysbox-runc: the payload keeps the host's watchdog alive, which is only
+	// possible while we proxy its notifications
+	if s.watchdogUsec != "" {
+		if !s.proxying {
+			logrus.Warnf("the container's notifications are only proxied to the host until it's ready; " +
+				"run it in the foreground for its payload to keep the host's watchdog alive")
+			return nil
+		}
+		spec.Process.Env = append(spec.Process.Env, "WATCHDOG_USEC="+s.watchdogUsec)
+	}
 	return nil
 }
 
@@ -170,3 +194,65 @@ func (n *notifySocket) run(pid1 int) error {
 		}
 	}
 }
+
+// sysbox-runc: notifyProxyVars are the sd_notify(3) variables proxied to the
+// host once the container is ready. MAINPID is not (the host sees the
+// container's init, or us, as the main pid), nor are file descriptors.
+var notifyProxyVars = []string{
+	"READY=",
+	"RELOADING=",
+	"STOPPING=",
+	"STATUS=",
+	"ERRNO=",
+	"WATCHDOG=",
+	"WATCHDOG_USEC=",
+	"EXTEND_TIMEOUT_USEC=",
+	"MONOTONIC_USEC=",
+}
+
+// sysbox-runc: proxy forwards the notifications of the container's payload
+// (e.g., its watchdog keep-alives and status updates) to the host, once it's
+// ready (see run()); it returns when the socket is closed.
+func (n *notifySocket) proxy() {
+	if n.socket == nil || !n.proxying {
+		return
+	}
+	notifySocketHostAddr := net.UnixAddr{Name: n.host, Net: "unixgram"}
+	client, err := net.DialUnix("unixgram", nil, &notifySocketHostAddr)
+	if err != nil {
+		logrus.Warnf("proxying the container's notifications: %v", err)
+		return
+	}
+	defer client.Close()
+
+	for {
+		buf := make([]byte, 4096)
+		r, err := n.socket.Read(buf)
+		if err != nil {
+			return
+		}
+		msg := filterNotifyMessage(buf[:r])
+		if len(msg) == 0 {
+			continue
+		}
+		if _, err := client.Write(msg); err != nil {
+			logrus.Debugf("proxying the container's notifications: %v", err)
+		}
+	}
+}
+
+// filterNotifyMessage returns the lines of the given sd_notify(3) message that
+// set one of the notifyProxyVars.
+func filterNotifyMessage(msg []byte) []byte {
+	var out bytes.Buffer
+	for _, line := range bytes.Split(msg, []byte{'\n'}) {
+		for _, v := range notifyProxyVars {
+			if bytes.HasPrefix(line, []byte(v)) {
+				out.Write(line)
+				out.WriteByte('\n')
+				break
+			}
+		}
+	}
+	return out.Bytes()
+}
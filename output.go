@@ -0,0 +1,190 @@
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// Output formats of the commands that report information (see their --output
+// flag). The JSON and YAML output conforms to the command's output schema (see
+// the schema command).
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// outputFlag returns the --output flag of a command that supports the given
+// output formats; the first one is the default.
+func outputFlag(formats ...string) cli.StringFlag {
+	return cli.StringFlag{
+		Name:  "output, o",
+		Value: formats[0],
+		Usage: "select one of: " + strings.Join(formats, ", "),
+	}
+}
+
+// formatFlag is the --format flag, superseded by --output (kept for
+// compatibility).
+var formatFlag = cli.StringFlag{
+	Name:  "format, f",
+	Usage: "deprecated; same as --output",
+}
+
+// outputFormat returns the output format selected via the command's --output
+// (or --format) flag; it must be one of the given formats.
+func outputFormat(context *cli.Context, formats ...string) (string, error) {
+	format := context.String("output")
+	if context.IsSet("format") {
+		if context.IsSet("output") {
+			return "", errors.New("--format and --output are mutually exclusive")
+		}
+		format = context.String("format")
+	}
+
+	for _, f := range formats {
+		if f == format {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("invalid output format %q (must be one of: %s)", format, strings.Join(formats, ", "))
+}
+
+// writeOutput writes v to w in the given output format (JSON or YAML).
+func writeOutput(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case outputJSON:
+		return json.NewEncoder(w).Encode(v)
+	case outputYAML:
+		return writeYAML(w, v)
+	}
+	return fmt.Errorf("output format %q not supported", format)
+}
+
+// writeYAML writes v to w as a YAML document. The document has the structure
+// of v's JSON encoding (i.e., field names and omitted fields are per the JSON
+// struct tags); object keys are sorted.
+func writeYAML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var g interface{}
+	if err := dec.Decode(&g); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	yamlNode(&b, g, "")
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// yamlNode writes the YAML of the given (generic JSON) value, in block style
+// with the given indentation, followed by a newline. Scalars are written as
+// is, except for strings, which are always quoted.
+func yamlNode(b *bytes.Buffer, v interface{}, indent string) {
+	switch val := v.(type) {
+
+	case map[string]interface{}:
+		if len(val) == 0 {
+			b.WriteString(indent + "{}\n")
+			return
+		}
+		keys := []string{}
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteString(indent + yamlKey(k) + ":")
+			yamlChild(b, val[k], indent)
+		}
+
+	case []interface{}:
+		if len(val) == 0 {
+			b.WriteString(indent + "[]\n")
+			return
+		}
+		for _, item := range val {
+			// the item's first line goes after the "- " marker
+			var ib bytes.Buffer
+			yamlNode(&ib, item, indent+"  ")
+			b.WriteString(indent + "- ")
+			b.Write(ib.Bytes()[len(indent)+2:])
+		}
+
+	default:
+		b.WriteString(indent + yamlScalar(val) + "\n")
+	}
+}
+
+// yamlChild writes the value of a mapping entry, after its key.
+func yamlChild(b *bytes.Buffer, v interface{}, indent string) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if len(val) > 0 {
+			b.WriteString("\n")
+			yamlNode(b, val, indent+"  ")
+			return
+		}
+	case []interface{}:
+		if len(val) > 0 {
+			b.WriteString("\n")
+			yamlNode(b, val, indent+"  ")
+			return
+		}
+	}
+	b.WriteString(" ")
+	yamlNode(b, v, "")
+}
+
+var plainYamlKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_./-]*$`)
+
+// yamlKey returns the given mapping key, quoted unless it's safe as a plain
+// scalar (i.e., it can't be mistaken for a non-string value).
+func yamlKey(k string) string {
+	switch strings.ToLower(k) {
+	case "y", "n", "yes", "no", "on", "off", "true", "false", "null":
+		return yamlScalar(k)
+	}
+	if plainYamlKey.MatchString(k) {
+		return k
+	}
+	return yamlScalar(k)
+}
+
+func yamlScalar(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		if val {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return val.String()
+	case string:
+		// JSON strings are valid YAML double-quoted scalars
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		enc.Encode(val)
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,220 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// portProxy forwards host TCP ports to the container (see
+// syscont.PortForwardsAnnotation): the host ports are listened on by a helper
+// process (see portProxyCommand), which connects to the container's ports from
+// within its network namespace. The helper outlives sysbox-runc (e.g., when the
+// container is detached), and exits along with the container's init process.
+//
+// It's meant for standalone usage of sysbox-runc, as otherwise the container
+// engine sets up the container's networking.
+type portProxy struct {
+	fwds      []syscont.PortForward
+	logPath   string
+	logFormat string
+}
+
+// newPortProxy returns the port proxy for the given port forwards, or nil if
+// there are none.
+func newPortProxy(context *cli.Context, fwds []syscont.PortForward) *portProxy {
+	if len(fwds) == 0 {
+		return nil
+	}
+	return &portProxy{
+		fwds:      fwds,
+		logPath:   context.GlobalString("log"),
+		logFormat: context.GlobalString("log-format"),
+	}
+}
+
+// start starts the proxy's helper process for the given container, whose init
+// process must have been created. The host ports are listened on here (and
+// handed over to the helper), so that errors are reported to the caller.
+func (p *portProxy) start(container libcontainer.Container) error {
+	state, err := container.State()
+	if err != nil {
+		return err
+	}
+	pid := state.InitProcessPid
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	args := []string{}
+	if p.logPath != "" {
+		args = append(args, "--log", p.logPath, "--log-format", p.logFormat)
+	}
+	args = append(args, "port-proxy", "--pid", strconv.Itoa(pid))
+
+	// the helper gets the container's network ns as fd 3, and the listeners
+	// as fds 4 and up
+	ns, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		return err
+	}
+	files = append(files, ns)
+
+	for _, fwd := range p.fwds {
+		addr := net.JoinHostPort(fwd.HostIP, strconv.Itoa(int(fwd.HostPort)))
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s for port forwarding: %w", addr, err)
+		}
+		f, err := l.(*net.TCPListener).File()
+		l.Close()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		args = append(args, "--forward", fmt.Sprintf("%d:%d", 2+len(files), fwd.ContainerPort))
+	}
+
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Args[0] = os.Args[0]
+	cmd.ExtraFiles = files
+	// the helper must not get the signals sent to sysbox-runc's process group
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start port proxy: %w", err)
+	}
+	go cmd.Wait()
+
+	return nil
+}
+
+// portProxyPollInterval is how often the port proxy checks whether the
+// container's init process is still alive.
+const portProxyPollInterval = time.Second
+
+var portProxyCommand = cli.Command{
+	Name:   "port-proxy",
+	Usage:  `forward host ports to a container (do not call it outside of sysbox-runc)`,
+	Hidden: true,
+	Flags: []cli.Flag{
+		cli.IntFlag{Name: "pid"},
+		cli.StringSliceFlag{Name: "forward"},
+	},
+	Action: func(context *cli.Context) error {
+		ns := os.NewFile(3, "netns")
+		var nsStat unix.Stat_t
+		if err := unix.Fstat(int(ns.Fd()), &nsStat); err != nil {
+			return err
+		}
+
+		for _, fwd := range context.StringSlice("forward") {
+			kv := strings.SplitN(fwd, ":", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid forward %q", fwd)
+			}
+			fd, err := strconv.Atoi(kv[0])
+			if err != nil {
+				return fmt.Errorf("invalid forward %q", fwd)
+			}
+			l, err := net.FileListener(os.NewFile(uintptr(fd), "listener"))
+			if err != nil {
+				return err
+			}
+			go proxyPort(l, ns, kv[1])
+		}
+
+		// the container's init process is gone once its pid no longer refers
+		// to a process in the container's network ns
+		path := fmt.Sprintf("/proc/%d/ns/net", context.Int("pid"))
+		for {
+			time.Sleep(portProxyPollInterval)
+			var st unix.Stat_t
+			if err := unix.Stat(path, &st); err != nil || st.Ino != nsStat.Ino || st.Dev != nsStat.Dev {
+				return nil
+			}
+		}
+	},
+}
+
+// proxyPort forwards the connections accepted by the given listener to the
+// given port of the loopback address of the given network ns.
+func proxyPort(l net.Listener, ns *os.File, port string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			logrus.Errorf("port proxy: accepting on %s: %v", l.Addr(), err)
+			return
+		}
+		go func() {
+			defer conn.Close()
+			cconn, err := dialInNetNs(ns, net.JoinHostPort("127.0.0.1", port))
+			if err != nil {
+				logrus.Warnf("port proxy: connecting to container port %s: %v", port, err)
+				return
+			}
+			defer cconn.Close()
+			proxyConn(conn, cconn)
+		}()
+	}
+}
+
+// dialInNetNs connects to the given TCP address from within the given network
+// ns. The socket is created by a thread that enters the ns, and is discarded
+// once done (as it's left locked).
+func dialInNetNs(ns *os.File, addr string) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+			ch <- result{err: fmt.Errorf("entering the container's network ns: %w", err)}
+			return
+		}
+		conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+		ch <- result{conn, err}
+	}()
+
+	r := <-ch
+	return r.conn, r.err
+}
+
+// proxyConn copies data between the given connections until both directions
+// are done.
+func proxyConn(a, b net.Conn) {
+	var wg sync.WaitGroup
+	cp := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		// propagate the EOF
+		if c, ok := dst.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+	}
+	wg.Add(2)
+	go cp(a, b)
+	go cp(b, a)
+	wg.Wait()
+}
@@ -3,7 +3,7 @@
 package main
 
 import (
-	"encoding/json"
+	"bufio"
 	"errors"
 	"fmt"
 	"os"
@@ -11,6 +11,8 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/types"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
@@ -20,16 +22,21 @@ var psCommand = cli.Command{
 	Usage:     "ps displays the processes running inside a container",
 	ArgsUsage: `<container-id> [ps options]`,
 	Flags: []cli.Flag{
-		cli.StringFlag{
-			Name:  "format, f",
-			Value: "table",
-			Usage: `select one of: ` + formatOptions,
+		outputFlag(outputTable, outputJSON, outputYAML),
+		formatFlag,
+		cli.BoolFlag{
+			Name:  "nested",
+			Usage: "show the nesting level of each process' pid namespace (e.g., for processes of containers within the system container) and its pid in that namespace",
 		},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 1, minArgs); err != nil {
 			return err
 		}
+		format, err := outputFormat(context, outputTable, outputJSON, outputYAML)
+		if err != nil {
+			return err
+		}
 		rootlessCg, err := shouldUseRootlessCgroupManager(context)
 		if err != nil {
 			return err
@@ -48,12 +55,25 @@ var psCommand = cli.Command{
 			return err
 		}
 
-		switch context.String("format") {
-		case "table":
-		case "json":
-			return json.NewEncoder(os.Stdout).Encode(pids)
-		default:
-			return errors.New("invalid format option")
+		var nested map[int]types.NestedProcess
+		if context.Bool("nested") {
+			nested, err = nestedProcesses(container, pids)
+			if err != nil {
+				return err
+			}
+		}
+
+		if format != outputTable {
+			if nested != nil {
+				procs := []types.NestedProcess{}
+				for _, pid := range pids {
+					if p, ok := nested[pid]; ok {
+						procs = append(procs, p)
+					}
+				}
+				return writeOutput(os.Stdout, format, procs)
+			}
+			return writeOutput(os.Stdout, format, pids)
 		}
 
 		// [1:] is to remove command name, ex:
@@ -77,7 +97,11 @@ var psCommand = cli.Command{
 			return err
 		}
 
-		fmt.Println(lines[0])
+		if nested != nil {
+			fmt.Printf("%-5s %-7s %s\n", "LEVEL", "NSPID", lines[0])
+		} else {
+			fmt.Println(lines[0])
+		}
 		for _, line := range lines[1:] {
 			if len(line) == 0 {
 				continue
@@ -88,6 +112,13 @@ var psCommand = cli.Command{
 				return fmt.Errorf("unexpected pid '%s': %s", fields[pidIndex], err)
 			}
 
+			if nested != nil {
+				if np, ok := nested[p]; ok {
+					fmt.Printf("%-5d %-7d %s\n", np.Level, np.NsPid, line)
+				}
+				continue
+			}
+
 			for _, pid := range pids {
 				if pid == p {
 					fmt.Println(line)
@@ -100,6 +131,69 @@ var psCommand = cli.Command{
 	SkipArgReorder: true,
 }
 
+// nestedProcesses returns the given processes of the container, along with the
+// nesting of their pid namespaces relative to the container's (per the host's
+// procfs, as the container shares the host's kernel). Processes that exit in
+// the meantime are skipped.
+func nestedProcesses(container libcontainer.Container, pids []int) (map[int]types.NestedProcess, error) {
+	state, err := container.State()
+	if err != nil {
+		return nil, err
+	}
+	initNsPids, err := nsPids(state.InitProcessPid)
+	if err != nil {
+		return nil, fmt.Errorf("getting the pid namespaces of the container's init process: %w", err)
+	}
+
+	procs := make(map[int]types.NestedProcess, len(pids))
+	for _, pid := range pids {
+		ns, err := nsPids(pid)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		procs[pid] = types.NestedProcess{
+			Pid:   pid,
+			NsPid: ns[len(ns)-1],
+			Level: len(ns) - len(initNsPids),
+		}
+	}
+	return procs, nil
+}
+
+// nsPids returns the pids of the given process in each of the pid namespaces
+// it belongs to, from the host's inward (per the NSpid field of its status).
+func nsPids(pid int) ([]int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "NSpid:" {
+			continue
+		}
+		ns := []int{}
+		for _, field := range fields[1:] {
+			p, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected NSpid entry %q for pid %d", field, pid)
+			}
+			ns = append(ns, p)
+		}
+		return ns, nil
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no NSpid entry for pid %d (kernel too old?)", pid)
+}
+
 func getPidIndex(title string) (int, error) {
 	titles := strings.Fields(title)
 
@@ -0,0 +1,146 @@
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+var reapCommand = cli.Command{
+	Name:  "reap",
+	Usage: "stop and delete the system containers whose max lifetime has expired",
+	ArgsUsage: `
+
+EXAMPLE:
+To reap the expired containers every 10 minutes, run this command from a
+systemd timer unit (or cron job):
+
+       # sysbox-runc reap --timeout 30s`,
+	Description: `The reap command stops and deletes the system containers whose max lifetime
+(see the "io.nestybox.sysbox.max-lifetime" annotation) has expired, and prints
+their IDs. The lifetime is counted from the container's creation.
+
+Running containers are stopped gracefully: they are sent the stop signal, and
+killed if they don't stop within the given timeout.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "signal, s",
+			Value: "SIGTERM",
+			Usage: "signal sent to the containers to stop them (e.g., SIGRTMIN+3 for systemd)",
+		},
+		cli.DurationFlag{
+			Name:  "timeout, t",
+			Value: 10 * time.Second,
+			Usage: "time to wait for the containers to stop before killing them",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+		sig, err := syscont.ParseSignal(context.String("signal"))
+		if err != nil {
+			return err
+		}
+
+		containers, err := expiredContainers(context)
+		if err != nil {
+			return err
+		}
+
+		var reapErr error
+		for _, c := range containers {
+			if err := reapContainer(c, sig, context.Duration("timeout")); err != nil {
+				logrus.Errorf("failed to reap container %s: %v", c.ID(), err)
+				reapErr = errors.New("failed to reap some containers")
+				continue
+			}
+			fmt.Println(c.ID())
+		}
+		return reapErr
+	},
+}
+
+// expiredContainers returns the containers whose max lifetime has expired.
+func expiredContainers(context *cli.Context) ([]libcontainer.Container, error) {
+	factory, err := loadFactory(context)
+	if err != nil {
+		return nil, err
+	}
+	absRoot, err := filepath.Abs(context.GlobalString("root"))
+	if err != nil {
+		return nil, err
+	}
+	list, err := ioutil.ReadDir(absRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	containers := []libcontainer.Container{}
+	for _, item := range list {
+		if !item.IsDir() {
+			continue
+		}
+		container, err := factory.Load(item.Name())
+		if err != nil {
+			continue
+		}
+		state, err := container.State()
+		if err != nil {
+			continue
+		}
+		_, annotations := utils.Annotations(state.Config.Labels)
+		lifetime, err := syscont.GetMaxLifetime(annotations)
+		if err != nil {
+			logrus.Warnf("container %s: %v", item.Name(), err)
+			continue
+		}
+		if lifetime == 0 || time.Since(state.BaseState.Created) < lifetime {
+			continue
+		}
+		logrus.Infof("container %s exceeded its max lifetime (%s)", item.Name(), lifetime)
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// reapContainer stops (gracefully, within the given timeout) and deletes the
+// given container.
+func reapContainer(container libcontainer.Container, sig unix.Signal, timeout time.Duration) error {
+	status, err := container.Status()
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case libcontainer.Stopped:
+		return container.Destroy()
+	case libcontainer.Created:
+		return killContainer(container)
+	case libcontainer.Paused:
+		if err := container.Resume(); err != nil {
+			return err
+		}
+	}
+
+	if signalAndWait(container, sig, timeout) {
+		return container.Destroy()
+	}
+	logrus.Infof("container %s didn't stop within %s; killing it", container.ID(), timeout)
+	return killContainer(container)
+}
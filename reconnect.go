@@ -0,0 +1,133 @@
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var fsReconnectCommand = cli.Command{
+	Name:  "fs-reconnect",
+	Usage: "reconnect system containers to sysbox-fs after it restarts",
+	ArgsUsage: `[<container-id>...]
+
+Where "<container-id>" is the name for the instance of the container.
+
+EXAMPLE:
+To reconnect all containers once sysbox-fs is restarted (e.g., upgraded), run
+this command from sysbox-fs' systemd unit (as an ExecStartPost= command):
+
+       # sysbox-runc fs-reconnect --all`,
+	Description: `The fs-reconnect command re-registers the given system containers with
+sysbox-fs, and re-sends it the seccomp notification fds of the containers'
+processes, and prints their IDs. When sysbox-fs restarts, it loses its state and
+the fds; until the containers are reconnected, their syscalls trapped by
+sysbox-fs fail or hang.
+
+The fds are held (for this purpose) by a helper process per container, started
+at container creation if the global --seccomp-keeper option is given; other
+containers can't be reconnected (and are skipped with --all).`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "all, a",
+			Usage: "reconnect all running, created and paused containers that have a seccomp fd keeper",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		var containers []libcontainer.Container
+		var err error
+
+		if context.Bool("all") {
+			if err := checkArgs(context, 0, exactArgs); err != nil {
+				return err
+			}
+			containers, err = liveContainers(context)
+		} else {
+			if err := checkArgs(context, 1, minArgs); err != nil {
+				return err
+			}
+			containers, err = loadContainers(context, context.Args())
+		}
+		if err != nil {
+			return err
+		}
+
+		var reconnectErr error
+		for _, c := range containers {
+			if context.Bool("all") {
+				if !c.Config().SeccompKeeper {
+					logrus.Infof("container %s has no seccomp fd keeper; not reconnecting it", c.ID())
+					continue
+				}
+			}
+			if err := c.ReconnectSysboxFs(); err != nil {
+				logrus.Errorf("failed to reconnect container %s to sysbox-fs: %v", c.ID(), err)
+				reconnectErr = errors.New("failed to reconnect some containers")
+				continue
+			}
+			fmt.Println(c.ID())
+		}
+		return reconnectErr
+	},
+}
+
+// loadContainers returns the containers with the given IDs.
+func loadContainers(context *cli.Context, ids []string) ([]libcontainer.Container, error) {
+	factory, err := loadFactory(context)
+	if err != nil {
+		return nil, err
+	}
+	containers := []libcontainer.Container{}
+	for _, id := range ids {
+		container, err := factory.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// liveContainers returns the containers that are running, created or paused.
+func liveContainers(context *cli.Context) ([]libcontainer.Container, error) {
+	factory, err := loadFactory(context)
+	if err != nil {
+		return nil, err
+	}
+	absRoot, err := filepath.Abs(context.GlobalString("root"))
+	if err != nil {
+		return nil, err
+	}
+	list, err := ioutil.ReadDir(absRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	containers := []libcontainer.Container{}
+	for _, item := range list {
+		if !item.IsDir() {
+			continue
+		}
+		container, err := factory.Load(item.Name())
+		if err != nil {
+			continue
+		}
+		status, err := container.Status()
+		if err != nil || status == libcontainer.Stopped {
+			continue
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/opencontainers/runc/libcontainer"
 	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/opencontainers/runc/libsysbox"
+	"github.com/opencontainers/runc/libsysbox/audit"
 	"github.com/opencontainers/runc/libsysbox/sysbox"
 	"github.com/opencontainers/runc/libsysbox/syscont"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
@@ -96,15 +98,26 @@ using the sysbox-runc checkpoint command.`,
 			Usage: "use userfaultfd to lazily restore memory pages",
 		},
 	},
-	Action: func(context *cli.Context) error {
+	Action: func(context *cli.Context) (err error) {
 		var (
-			err               error
 			spec              *specs.Spec
+			digest            string
 			uidShiftSupported bool
 			uidShiftRootfs    bool
 			status            int
 		)
 
+		// a panic is turned into an error, reported along with a crash record;
+		// the deferred cleanups below recover panics themselves, so that they
+		// undo the phase's work as on any other error
+		phase := phaseSetup
+		defer func() {
+			if r := recover(); r != nil {
+				err = sysbox.NewPanicError(phase, r)
+			}
+			reportCrash(context, digest, err)
+		}()
+
 		if err = checkArgs(context, 1, exactArgs); err != nil {
 			return err
 		}
@@ -117,10 +130,31 @@ using the sysbox-runc checkpoint command.`,
 		if err != nil {
 			return err
 		}
+		digest = specHash(spec)
+
+		if err = setupContainerLogging(context, context.Args().First(), spec.Annotations); err != nil {
+			return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+		}
+
+		sysOpts, err := sysboxCreateOpts(context)
+		if err != nil {
+			return err
+		}
+		sysMgr := sysOpts.Mgr
+		sysFs := sysOpts.Fs
+
+		phase = phasePrepare
+
+		var origSpec *specs.Spec
+		if sysOpts.Audit != nil {
+			if origSpec, err = audit.CopySpec(spec); err != nil {
+				return err
+			}
+		}
 
-		id := context.Args().First()
-		sysMgr := sysbox.NewMgr(id, !context.GlobalBool("no-sysbox-mgr"))
-		sysFs := sysbox.NewFs(id, !context.GlobalBool("no-sysbox-fs"))
+		if err = sysOpts.Extensions.RunPreConvertSpec(sysOpts.ID, spec); err != nil {
+			return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+		}
 
 		// register with sysMgr (registration with sysFs occurs later (within libcontainer))
 		if sysMgr.Enabled() {
@@ -128,6 +162,9 @@ using the sysbox-runc checkpoint command.`,
 				return err
 			}
 			defer func() {
+				if r := recover(); r != nil {
+					err = sysbox.NewPanicError(phase, r)
+				}
 				if err != nil {
 					sysMgr.Unregister()
 				}
@@ -141,16 +178,27 @@ using the sysbox-runc checkpoint command.`,
 			}
 		}
 
-		uidShiftSupported, uidShiftRootfs, err = syscont.ConvertSpec(context, sysMgr, sysFs, spec)
+		uidShiftSupported, uidShiftRootfs, err = syscont.ConvertSpec(sysMgr, sysFs, spec)
 		if err != nil {
-			return fmt.Errorf("error in the container spec: %v", err)
+			return &sysbox.Error{
+				Code: sysbox.ErrInvalidSpec,
+				Err:  fmt.Errorf("error in the container spec: %w", err),
+			}
+		}
+
+		if sysOpts.Audit != nil {
+			if err = libsysbox.RecordSpecChanges(sysOpts.Audit, sysOpts.ID, origSpec, spec); err != nil {
+				return err
+			}
 		}
 
+		phase = phaseStart
 		options := criuOptions(context)
 		if err = setEmptyNsMask(context, options); err != nil {
 			return err
 		}
-		status, err = startContainer(context, spec, CT_ACT_RESTORE, options, uidShiftSupported, uidShiftRootfs, sysMgr, sysFs)
+		sysCont := libsysbox.NewSysContainer(sysOpts, uidShiftSupported, uidShiftRootfs)
+		status, err = startContainer(context, spec, CT_ACT_RESTORE, options, sysCont)
 		if err != nil {
 			sysFs.Unregister()
 			return err
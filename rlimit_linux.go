@@ -1,7 +1,14 @@
 package main
 
-import "fmt"
-import "golang.org/x/sys/unix"
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
 
 var rlimitMap = map[string]int{
 	"RLIMIT_CPU":        unix.RLIMIT_CPU,
@@ -29,3 +36,49 @@ func strToRlimit(key string) (int, error) {
 	}
 	return rl, nil
 }
+
+// parseRlimit parses an rlimit given as <type>=<soft>[:<hard>], where the type
+// is an rlimit name with or without the "RLIMIT_" prefix (e.g., "nofile" or
+// "RLIMIT_NOFILE"), and the limits are numbers or "unlimited"; the hard limit
+// defaults to the soft one.
+func parseRlimit(val string) (specs.POSIXRlimit, error) {
+	var rlimit specs.POSIXRlimit
+
+	kv := strings.SplitN(val, "=", 2)
+	if len(kv) != 2 {
+		return rlimit, fmt.Errorf("invalid rlimit %q (format: <type>=<soft>[:<hard>])", val)
+	}
+
+	rlimit.Type = strings.ToUpper(strings.TrimSpace(kv[0]))
+	if !strings.HasPrefix(rlimit.Type, "RLIMIT_") {
+		rlimit.Type = "RLIMIT_" + rlimit.Type
+	}
+	if _, err := strToRlimit(rlimit.Type); err != nil {
+		return rlimit, err
+	}
+
+	limits := strings.SplitN(kv[1], ":", 2)
+	var err error
+	if rlimit.Soft, err = parseRlimitValue(limits[0]); err != nil {
+		return rlimit, fmt.Errorf("invalid rlimit %q: %v", val, err)
+	}
+	rlimit.Hard = rlimit.Soft
+	if len(limits) == 2 {
+		if rlimit.Hard, err = parseRlimitValue(limits[1]); err != nil {
+			return rlimit, fmt.Errorf("invalid rlimit %q: %v", val, err)
+		}
+	}
+	if rlimit.Soft > rlimit.Hard {
+		return rlimit, fmt.Errorf("invalid rlimit %q: the soft limit exceeds the hard one", val)
+	}
+
+	return rlimit, nil
+}
+
+func parseRlimitValue(val string) (uint64, error) {
+	val = strings.TrimSpace(val)
+	if val == "unlimited" {
+		return math.MaxUint64, nil
+	}
+	return strconv.ParseUint(val, 10, 64)
+}
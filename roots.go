@@ -0,0 +1,142 @@
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/runc/libsysbox"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// defaultRoot is the default state root (i.e., that of the --root flag).
+var defaultRoot string
+
+// knownRootsFile is the file (in the default state root) where the state
+// roots of the containers created are recorded, so that they can be listed
+// together (see "list --all-roots"). It's a file, so it's skipped when the
+// default root is scanned for containers.
+const knownRootsFile = "roots"
+
+// canonicalRoot returns the given state root as an absolute path with
+// symlinks resolved (if it exists), so that a root relocated through a
+// symlink is recognized as the same root.
+func canonicalRoot(root string) (string, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved, nil
+	}
+	return abs, nil
+}
+
+// containerKey returns the ID under which the given container (in the state
+// root given via --root) is registered with sysbox-mgr and sysbox-fs (see
+// libsysbox.ContainerKey()).
+func containerKey(id, root string) (string, error) {
+	root, err := canonicalRoot(root)
+	if err != nil {
+		return "", err
+	}
+	def, err := canonicalRoot(defaultRoot)
+	if err != nil {
+		return "", err
+	}
+	if root == def {
+		root = ""
+	}
+	return libsysbox.ContainerKey(id, root), nil
+}
+
+// registerRoot records the given state root in the known roots file.
+func registerRoot(root string) error {
+	root, err := canonicalRoot(root)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(defaultRoot, 0711); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(defaultRoot, knownRootsFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// concurrent sysbox-runc instances may register roots
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+
+	roots, err := readRoots(f)
+	if err != nil {
+		return err
+	}
+	for _, r := range roots {
+		if r == root {
+			return nil
+		}
+	}
+	_, err = f.WriteString(root + "\n")
+	return err
+}
+
+// knownRoots returns the default state root, the given one, and the others
+// recorded in the known roots file, that still exist.
+func knownRoots(root string) ([]string, error) {
+	def, err := canonicalRoot(defaultRoot)
+	if err != nil {
+		return nil, err
+	}
+	root, err = canonicalRoot(root)
+	if err != nil {
+		return nil, err
+	}
+	recorded := []string{def, root}
+
+	f, err := os.Open(filepath.Join(defaultRoot, knownRootsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer f.Close()
+		rs, err := readRoots(f)
+		if err != nil {
+			return nil, err
+		}
+		recorded = append(recorded, rs...)
+	}
+
+	roots := []string{}
+	seen := map[string]bool{}
+	for _, r := range recorded {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		if fi, err := os.Stat(r); err != nil || !fi.IsDir() {
+			logrus.Debugf("skipping state root %s: no longer exists", r)
+			continue
+		}
+		roots = append(roots, r)
+	}
+	return roots, nil
+}
+
+func readRoots(f *os.File) ([]string, error) {
+	roots := []string{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			roots = append(roots, line)
+		}
+	}
+	return roots, s.Err()
+}
@@ -3,11 +3,10 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
+	"github.com/opencontainers/runc/libsysbox"
 	"github.com/opencontainers/runc/libsysbox/sysbox"
-	"github.com/opencontainers/runc/libsysbox/syscont"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -29,8 +28,15 @@ filesystem.
 The specification file includes an args parameter. The args parameter is used
 to specify command(s) that get run when the container is started. To change the
 command(s) that get executed on start, edit the args parameter of the spec. See
-"runc spec --help" for more explanation.`,
-	Flags: []cli.Flag{
+"runc spec --help" for more explanation.
+
+When run with a NOTIFY_SOCKET (e.g., as a systemd service of Type=notify), the
+container's payload (e.g., its systemd) gets a notify socket of its own, whose
+readiness notification is forwarded to the host's. Unless detached, its later
+notifications (its watchdog keep-alives, status, etc.) are forwarded too, and
+it's given the host's WATCHDOG_USEC (for a service with WatchdogSec=), so that
+the host can supervise the payload.`,
+	Flags: append([]cli.Flag{
 		cli.StringFlag{
 			Name:  "bundle, b",
 			Value: "",
@@ -66,17 +72,51 @@ command(s) that get executed on start, edit the args parameter of the spec. See
 			Name:  "preserve-fds",
 			Usage: "Pass N additional file descriptors to the container (stdio + $LISTEN_FDS + N in total)",
 		},
-	},
-	Action: func(context *cli.Context) error {
+		cli.BoolFlag{
+			Name:  "rootfs-clone",
+			Usage: "run the container on an overlayfs clone of the bundle's rootfs, so that many containers can be launched from the same bundle",
+		},
+		cli.StringFlag{
+			Name:  "uid-shift",
+			Value: "",
+			Usage: "uid shifting backends the container may use, in order of preference: a comma separated list of shiftfs, idmapped-mount and chown, or none",
+		},
+		cli.StringFlag{
+			Name:  "share-ipc",
+			Value: "",
+			Usage: "id of a peer system container whose ipc namespace (and user namespace) the container joins",
+		},
+		cli.StringFlag{
+			Name:  "share-uts",
+			Value: "",
+			Usage: "id of a peer system container whose uts namespace (and user namespace) the container joins",
+		},
+		cli.StringFlag{
+			Name:  "share-cgroup",
+			Value: "",
+			Usage: "id of a peer system container whose cgroup namespace (and user namespace) the container joins",
+		},
+	}, stdioFlags...),
+	Action: func(context *cli.Context) (err error) {
 		var (
-			err               error
-			spec              *specs.Spec
-			uidShiftSupported bool
-			uidShiftRootfs    bool
-			status            int
-			profiler          interface{ Stop() }
+			spec     *specs.Spec
+			digest   string
+			sysCont  *libsysbox.SysContainer
+			status   int
+			profiler interface{ Stop() }
 		)
 
+		// a panic is turned into an error, reported along with a crash record;
+		// the deferred cleanups below recover panics themselves, so that they
+		// undo the phase's work as on any other error
+		phase := phaseSetup
+		defer func() {
+			if r := recover(); r != nil {
+				err = sysbox.NewPanicError(phase, r)
+			}
+			reportCrash(context, digest, err)
+		}()
+
 		// Enable profiler if requested to do so
 		profiler, err = runProfiler(context)
 		if err != nil {
@@ -101,52 +141,39 @@ command(s) that get executed on start, edit the args parameter of the spec. See
 		if err != nil {
 			return err
 		}
+		digest = specHash(spec)
 
-		if err = sysbox.CheckHostConfig(context, spec); err != nil {
-			return err
+		if err = setupContainerLogging(context, context.Args().First(), spec.Annotations); err != nil {
+			return &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
 		}
 
-		id := context.Args().First()
-		sysMgr := sysbox.NewMgr(id, !context.GlobalBool("no-sysbox-mgr"))
-		sysFs := sysbox.NewFs(id, !context.GlobalBool("no-sysbox-fs"))
-
-		// register with sysMgr
-		if sysMgr.Enabled() {
-			if err = sysMgr.Register(spec); err != nil {
-				return err
-			}
-			defer func() {
-				if err != nil {
-					sysMgr.Unregister()
-				}
-			}()
+		if err = setSharedNamespaces(context, spec); err != nil {
+			return err
 		}
+		setRootfsClone(context, spec)
+		setUidShiftBackends(context, spec)
 
-		// Get sysbox-fs related configs
-		if sysFs.Enabled() {
-			if err = sysFs.GetConfig(); err != nil {
-				return err
-			}
+		sysOpts, err := sysboxCreateOpts(context)
+		if err != nil {
+			return err
 		}
 
-		uidShiftSupported, uidShiftRootfs, err = syscont.ConvertSpec(context, sysMgr, sysFs, spec)
+		phase = phasePrepare
+		sysCont, err = libsysbox.PrepareSpec(spec, sysOpts)
 		if err != nil {
-			return fmt.Errorf("error in the container spec: %v", err)
+			return err
 		}
-
-		// pre-register with sysFs
-		if sysFs.Enabled() {
-			if err = sysFs.PreRegister(spec.Linux.Namespaces); err != nil {
-				return err
+		phase = phaseStart
+		defer func() {
+			if r := recover(); r != nil {
+				err = sysbox.NewPanicError(phase, r)
 			}
-			defer func() {
-				if err != nil {
-					sysFs.Unregister()
-				}
-			}()
-		}
+			if err != nil {
+				sysCont.Cleanup()
+			}
+		}()
 
-		status, err = startContainer(context, spec, CT_ACT_RUN, nil, uidShiftSupported, uidShiftRootfs, sysMgr, sysFs)
+		status, err = startContainer(context, spec, CT_ACT_RUN, nil, sysCont)
 		if err == nil {
 
 			// note: defer func() to stop profiler won't execute on os.Exit(); must explicitly stop it.
@@ -0,0 +1,419 @@
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/urfave/cli"
+)
+
+const runcV2ShimType = "io.containerd.runc.v2"
+
+var runtimeClassCommand = cli.Command{
+	Name:  "runtimeclass",
+	Usage: "check the kubelet and containerd configuration for running sysbox-runc as a Kubernetes RuntimeClass",
+	ArgsUsage: `
+
+EXAMPLE:
+To check the configuration of a node with the default kubelet and containerd
+config file locations:
+
+       # sysbox-runc runtimeclass`,
+	Description: `The runtimeclass command inspects the given kubelet and containerd config
+files and reports whether containerd has a runtime handler for this sysbox-runc
+binary, and whether the handler's cgroup driver matches the kubelet's. It then
+prints the containerd runtime stanza and the Kubernetes RuntimeClass object
+needed to run pods with sysbox-runc.
+
+The command fails if any problems are found.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "kubelet-config",
+			Value: "/var/lib/kubelet/config.yaml",
+			Usage: "path to the kubelet config file (KubeletConfiguration)",
+		},
+		cli.StringFlag{
+			Name:  "containerd-config",
+			Value: "/etc/containerd/config.toml",
+			Usage: "path to the containerd config file",
+		},
+		cli.StringFlag{
+			Name:  "handler",
+			Value: "sysbox-runc",
+			Usage: "name of the containerd runtime handler (and RuntimeClass) for sysbox-runc",
+		},
+		cli.StringFlag{
+			Name:  "binary",
+			Usage: "path to the sysbox-runc binary used by containerd (defaults to this binary)",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+
+		binary := context.String("binary")
+		if binary == "" {
+			var err error
+			if binary, err = os.Executable(); err != nil {
+				return err
+			}
+		}
+
+		report := checkRuntimeClass(context.String("kubelet-config"), context.String("containerd-config"),
+			context.String("handler"), binary)
+		report.print()
+
+		if report.problems > 0 {
+			return &sysbox.Error{
+				Code: sysbox.ErrInvalidConfig,
+				Err:  fmt.Errorf("found %d problem(s) in the kubelet / containerd configuration", report.problems),
+			}
+		}
+		return nil
+	},
+}
+
+// RuntimeClass handlers must be DNS labels
+var handlerRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+type runtimeClassReport struct {
+	lines         []string
+	problems      int
+	handler       string
+	binary        string
+	runtimesTable []string
+	systemdCgroup bool
+}
+
+func (r *runtimeClassReport) ok(format string, a ...interface{}) {
+	r.lines = append(r.lines, "[ok]    "+fmt.Sprintf(format, a...))
+}
+
+func (r *runtimeClassReport) problem(format string, a ...interface{}) {
+	r.lines = append(r.lines, "[error] "+fmt.Sprintf(format, a...))
+	r.problems++
+}
+
+func (r *runtimeClassReport) print() {
+	fmt.Printf("sysbox-runc %s runtime class check:\n\n", version)
+	for _, l := range r.lines {
+		fmt.Println(l)
+	}
+
+	handler := strconv.Quote(r.handler)
+	table := tomlTableName(append(r.runtimesTable, r.handler))
+
+	fmt.Printf("\ncontainerd runtime handler:\n\n")
+	fmt.Printf("[%s]\n", table)
+	fmt.Printf("  runtime_type = %q\n", runcV2ShimType)
+	fmt.Printf("  [%s.options]\n", table)
+	fmt.Printf("    BinaryName = %q\n", r.binary)
+	fmt.Printf("    SystemdCgroup = %v\n", r.systemdCgroup)
+
+	fmt.Printf("\nKubernetes RuntimeClass:\n\n")
+	fmt.Printf("apiVersion: node.k8s.io/v1\n")
+	fmt.Printf("kind: RuntimeClass\n")
+	fmt.Printf("metadata:\n")
+	fmt.Printf("  name: %s\n", handler)
+	fmt.Printf("handler: %s\n", handler)
+}
+
+func checkRuntimeClass(kubeletConfig, containerdConfig, handler, binary string) *runtimeClassReport {
+	r := &runtimeClassReport{
+		handler: handler,
+		binary:  binary,
+	}
+
+	if handlerRegexp.MatchString(handler) {
+		r.ok("runtime handler name: %s", handler)
+	} else {
+		r.problem("runtime handler name %q is not a valid DNS label (as required by Kubernetes)", handler)
+	}
+
+	if abs, err := filepath.Abs(binary); err == nil {
+		r.binary = abs
+	}
+	if fi, err := os.Stat(r.binary); err != nil {
+		r.problem("sysbox-runc binary: %v", err)
+	} else if !fi.Mode().IsRegular() || fi.Mode()&0111 == 0 {
+		r.problem("sysbox-runc binary %s is not an executable file", r.binary)
+	} else {
+		r.ok("sysbox-runc binary: %s", r.binary)
+	}
+
+	// kubelet cgroup driver
+	cgroupDriver, err := kubeletCgroupDriver(kubeletConfig)
+	if err != nil {
+		r.problem("kubelet config: %v", err)
+	} else {
+		r.ok("kubelet cgroup driver: %s", cgroupDriver)
+	}
+	r.systemdCgroup = cgroupDriver == "systemd"
+
+	// containerd runtime handler
+	data, err := ioutil.ReadFile(containerdConfig)
+	if err != nil {
+		r.problem("containerd config: %v", err)
+		r.runtimesTable = containerdRuntimesTable(2)
+		return r
+	}
+
+	tables, err := parseToml(data)
+	if err != nil {
+		r.problem("containerd config %s: %v", containerdConfig, err)
+		r.runtimesTable = containerdRuntimesTable(2)
+		return r
+	}
+
+	cfgVersion := 1
+	if v, ok := tables[""]["version"]; ok {
+		if cfgVersion, err = strconv.Atoi(v); err != nil {
+			r.problem("containerd config %s: invalid version %q", containerdConfig, v)
+			cfgVersion = 2
+		}
+	}
+	r.runtimesTable = containerdRuntimesTable(cfgVersion)
+
+	rtTable := tomlTableKey(append(r.runtimesTable, handler))
+	rt, ok := tables[rtTable]
+	if !ok {
+		r.problem("containerd runtime handler %q is not configured in %s", handler, containerdConfig)
+		return r
+	}
+	r.ok("containerd runtime handler %q is configured", handler)
+
+	if rtType, _ := tomlString(rt["runtime_type"]); rtType != runcV2ShimType {
+		r.problem("containerd runtime handler %q: runtime_type is %q; must be %q", handler, rtType, runcV2ShimType)
+	} else {
+		r.ok("containerd runtime handler %q: runtime_type = %q", handler, rtType)
+	}
+
+	opts := tables[tomlTableKey(append(r.runtimesTable, handler, "options"))]
+
+	binName, _ := tomlString(opts["BinaryName"])
+	if binName == "" {
+		r.problem("containerd runtime handler %q: BinaryName is not set (containerd would use runc)", handler)
+	} else if !sameFile(binName, r.binary) {
+		r.problem("containerd runtime handler %q: BinaryName is %s; expected %s", handler, binName, r.binary)
+	} else {
+		r.ok("containerd runtime handler %q: BinaryName = %s", handler, binName)
+	}
+
+	systemdCgroup := opts["SystemdCgroup"] == "true"
+	if systemdCgroup != r.systemdCgroup {
+		r.problem("containerd runtime handler %q: SystemdCgroup = %v, but the kubelet uses the %s cgroup driver",
+			handler, systemdCgroup, cgroupDriver)
+	} else {
+		r.ok("containerd runtime handler %q: SystemdCgroup = %v", handler, systemdCgroup)
+	}
+
+	return r
+}
+
+// containerdRuntimesTable returns the containerd config table holding the
+// runtime handlers, for the given config file version.
+func containerdRuntimesTable(cfgVersion int) []string {
+	switch cfgVersion {
+	case 1:
+		return []string{"plugins", "cri", "containerd", "runtimes"}
+	case 2:
+		return []string{"plugins", "io.containerd.grpc.v1.cri", "containerd", "runtimes"}
+	default:
+		return []string{"plugins", "io.containerd.cri.v1.runtime", "containerd", "runtimes"}
+	}
+}
+
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fa, fb)
+}
+
+// kubeletCgroupDriver returns the cgroup driver set in the given kubelet
+// config file (which defaults to "cgroupfs").
+func kubeletCgroupDriver(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "cgroupfs", err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "cgroupDriver:") {
+			continue
+		}
+		val := strings.TrimSpace(strings.TrimPrefix(line, "cgroupDriver:"))
+		if i := strings.Index(val, "#"); i >= 0 {
+			val = strings.TrimSpace(val[:i])
+		}
+		val = strings.Trim(val, `"'`)
+		if val != "systemd" && val != "cgroupfs" {
+			return "cgroupfs", fmt.Errorf("%s: invalid cgroupDriver %q", path, val)
+		}
+		return val, nil
+	}
+
+	return "cgroupfs", scanner.Err()
+}
+
+// parseToml does a minimal parse of a TOML document, enough to read the
+// containerd config: it returns the scalar values (unparsed) of each table,
+// indexed by table key (see tomlTableKey()) and value name. Arrays and inline
+// tables are skipped.
+func parseToml(data []byte) (map[string]map[string]string, error) {
+	tables := map[string]map[string]string{"": {}}
+	table := []string{}
+	skipDepth := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		// skip multi-line arrays
+		if skipDepth > 0 {
+			skipDepth += strings.Count(line, "[") - strings.Count(line, "]")
+			continue
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.LastIndex(line, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: invalid table header", lineNum)
+			}
+			name := strings.Trim(line[:end+1], "[]")
+			keys, err := splitTomlKey(name)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNum, err)
+			}
+			table = keys
+			if _, ok := tables[tomlTableKey(table)]; !ok {
+				tables[tomlTableKey(table)] = map[string]string{}
+			}
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNum)
+		}
+		keys, err := splitTomlKey(strings.TrimSpace(line[:eq]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		val := strings.TrimSpace(line[eq+1:])
+
+		if strings.HasPrefix(val, "[") || strings.HasPrefix(val, "{") {
+			skipDepth = strings.Count(val, "[") - strings.Count(val, "]")
+			continue
+		}
+
+		if strings.HasPrefix(val, `"`) || strings.HasPrefix(val, "'") {
+			end := strings.Index(val[1:], val[:1])
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", lineNum)
+			}
+			val = val[:end+2]
+		} else if i := strings.Index(val, "#"); i >= 0 {
+			val = strings.TrimSpace(val[:i])
+		}
+
+		// dotted keys define values in sub-tables
+		tkey := tomlTableKey(append(table[:len(table):len(table)], keys[:len(keys)-1]...))
+		if _, ok := tables[tkey]; !ok {
+			tables[tkey] = map[string]string{}
+		}
+		tables[tkey][keys[len(keys)-1]] = val
+	}
+
+	return tables, scanner.Err()
+}
+
+// splitTomlKey splits a (possibly dotted and quoted) TOML key into its parts.
+func splitTomlKey(key string) ([]string, error) {
+	parts := []string{}
+	for {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid key")
+		}
+
+		var part string
+		if key[0] == '"' || key[0] == '\'' {
+			end := strings.IndexByte(key[1:], key[0])
+			if end < 0 {
+				return nil, fmt.Errorf("invalid key %s", key)
+			}
+			part = key[1 : end+1]
+			key = strings.TrimSpace(key[end+2:])
+		} else {
+			end := strings.IndexByte(key, '.')
+			if end < 0 {
+				end = len(key)
+			}
+			part = strings.TrimSpace(key[:end])
+			key = key[end:]
+		}
+		parts = append(parts, part)
+
+		if key == "" {
+			return parts, nil
+		}
+		if key[0] != '.' {
+			return nil, fmt.Errorf("invalid key %s", key)
+		}
+		key = key[1:]
+	}
+}
+
+func tomlTableKey(parts []string) string {
+	return strings.Join(parts, "/")
+}
+
+// tomlTableName returns the TOML name for the given table, quoting its parts
+// as needed.
+func tomlTableName(parts []string) string {
+	quoted := make([]string, len(parts))
+	for i, p := range parts {
+		if strings.ContainsAny(p, ". ") {
+			p = strconv.Quote(p)
+		}
+		quoted[i] = p
+	}
+	return strings.Join(quoted, ".")
+}
+
+func tomlString(raw string) (string, bool) {
+	if len(raw) < 2 {
+		return "", false
+	}
+	if raw[0] == '\'' && raw[len(raw)-1] == '\'' {
+		return raw[1 : len(raw)-1], true
+	}
+	s, err := strconv.Unquote(raw)
+	if err != nil {
+		return "", false
+	}
+	return s, true
+}
@@ -0,0 +1,52 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/opencontainers/runc/types/schema"
+	"github.com/urfave/cli"
+)
+
+var schemaCommand = cli.Command{
+	Name:  "schema",
+	Usage: "output the JSON schema of a command's output",
+	ArgsUsage: `[<name>]
+
+Where "<name>" is the name of an output schema; if omitted, the available
+schemas are listed.
+
+EXAMPLE:
+To get the schema of the list command's output (with --output json or yaml):
+
+       # sysbox-runc schema list`,
+	Description: `The schema command outputs the JSON schema (draft-07) of the JSON and YAML
+output of the state, list, ps and events commands. The schemas are versioned;
+within a version, the output only changes in backward compatible ways (fields
+may be added, but are never removed, renamed, or changed in type or meaning),
+so consumers must ignore unknown fields.`,
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, maxArgs); err != nil {
+			return err
+		}
+
+		if context.NArg() == 0 {
+			w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+			fmt.Fprint(w, "NAME\tVERSION\tDESCRIPTION\n")
+			for _, name := range schema.Names() {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", name, schema.Version, schema.Title(name))
+			}
+			return w.Flush()
+		}
+
+		data, err := schema.Get(context.Args().First())
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	},
+}
@@ -0,0 +1,239 @@
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var selftestCommand = cli.Command{
+	Name:  "selftest",
+	Usage: "check that concurrent sysbox-runc operations don't interfere with each other",
+	ArgsUsage: `
+
+EXAMPLE:
+To check that 32 system containers can be created concurrently from a bundle
+(whose process has no terminal):
+
+       # sysbox-runc selftest --parallel 32 --bundle /mybundle`,
+	Description: `The selftest command stress-tests sysbox-runc's concurrency safety, as when
+a host creates many system containers at once:
+
+    - Spec conversion: the example spec (see the spec command) is converted
+      concurrently by the given number of workers, in several variants, and
+      each result is checked against a serial conversion.
+
+    - Container creation (with --bundle): containers are created concurrently
+      from the given bundle (one per worker, with IDs "selftest-<pid>-<n>"),
+      and then deleted. Besides, the workers concurrently create a container
+      with the same ID, of which exactly one must succeed.
+
+The container creation runs sysbox-runc with the --root of this command, and
+otherwise default options; sysbox-mgr and sysbox-fs must be running. The
+command fails if any check fails.`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "parallel, p",
+			Value: 8,
+			Usage: "number of concurrent workers",
+		},
+		cli.IntFlag{
+			Name:  "iterations, n",
+			Value: 10,
+			Usage: "number of spec conversions per worker",
+		},
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Usage: "bundle of the containers created concurrently; if not given, no containers are created",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+		parallel := context.Int("parallel")
+		if parallel < 1 {
+			return fmt.Errorf("invalid number of workers %d", parallel)
+		}
+
+		failed := false
+		if err := selftestSpecConversion(parallel, context.Int("iterations")); err != nil {
+			logrus.Errorf("spec conversion: %v", err)
+			failed = true
+		} else {
+			fmt.Printf("spec conversion: ok (%d workers)\n", parallel)
+		}
+
+		if bundle := context.String("bundle"); bundle != "" {
+			if err := selftestCreate(context, bundle, parallel); err != nil {
+				logrus.Errorf("container creation: %v", err)
+				failed = true
+			} else {
+				fmt.Printf("container creation: ok (%d workers)\n", parallel)
+			}
+		}
+
+		if failed {
+			return errors.New("selftest failed")
+		}
+		return nil
+	},
+}
+
+// selftestSpecVariants returns the example spec in the variants converted by
+// the selftest, which take different paths through the conversion.
+func selftestSpecVariants(rootfs string) ([][]byte, error) {
+	variants := [][]byte{}
+	for i := 0; i < 3; i++ {
+		spec, err := syscont.Example()
+		if err != nil {
+			return nil, err
+		}
+		spec.Root.Path = rootfs
+		switch i {
+		case 1:
+			spec.Root.Readonly = true
+		case 2:
+			spec.Process.Args = []string{"/sbin/init"}
+			spec.Annotations = map[string]string{syscont.PresetAnnotation: "k8s-node"}
+		}
+		data, err := json.Marshal(spec)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, data)
+	}
+	return variants, nil
+}
+
+// selftestSpecConversion converts the spec variants concurrently and checks the
+// results against serial conversions.
+func selftestSpecConversion(parallel, iterations int) error {
+	rootfs, err := ioutil.TempDir("", "sysbox-selftest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(rootfs)
+
+	variants, err := selftestSpecVariants(rootfs)
+	if err != nil {
+		return err
+	}
+
+	opts := syscont.ConvertOpts{Reproducible: true}
+	want := [][]byte{}
+	for _, v := range variants {
+		out, err := syscont.ConvertSpecJSON(v, opts)
+		if err != nil {
+			return err
+		}
+		want = append(want, out)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, parallel)
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < iterations; n++ {
+				// each worker goes through the variants in a different order
+				i := (w + n) % len(variants)
+				out, err := syscont.ConvertSpecJSON(variants[i], opts)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(out, want[i]) {
+					errs <- fmt.Errorf("worker %d: concurrent conversion of spec variant %d differs from the serial one", w, i)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// selftestCreate creates containers from the given bundle concurrently (see
+// selftestCommand).
+func selftestCreate(context *cli.Context, bundle string, parallel int) error {
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return err
+	}
+
+	ids := []string{}
+	for w := 0; w < parallel; w++ {
+		ids = append(ids, fmt.Sprintf("selftest-%d-%d", os.Getpid(), w))
+	}
+	sameID := fmt.Sprintf("selftest-%d-same", os.Getpid())
+
+	run := func(args ...string) error {
+		args = append([]string{"--root", context.GlobalString("root")}, args...)
+		cmd := exec.Command("/proc/self/exe", args...)
+		cmd.Args[0] = os.Args[0]
+		return cmd.Run()
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	created := 0
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(2)
+		go func(id string) {
+			defer wg.Done()
+			if err := run("create", "--bundle", bundle, id); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("failed to create container %s: %v", id, err))
+				mu.Unlock()
+			}
+		}(id)
+		go func() {
+			defer wg.Done()
+			if err := run("create", "--bundle", bundle, sameID); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		errs = append(errs, fmt.Errorf("%d concurrent creations of container %s succeeded, want 1", created, sameID))
+	}
+
+	for _, id := range append(ids, sameID) {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			run("delete", "--force", id)
+		}(id)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, err := range errs[1:] {
+		logrus.Error(err)
+	}
+	return errs[0]
+}
@@ -8,7 +8,6 @@ import (
 
 	"github.com/opencontainers/runc/libcontainer"
 	"github.com/opencontainers/runc/libcontainer/system"
-	"github.com/opencontainers/runc/libcontainer/utils"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
@@ -20,7 +19,9 @@ const signalBufferSize = 2048
 // while still forwarding all other signals to the process.
 // If notifySocket is present, use it to read systemd notifications from the container and
 // forward them to notifySocketHost.
-func newSignalHandler(enableSubreaper bool, notifySocket *notifySocket) *signalHandler {
+// sysbox-runc: forwarded signals are translated per sigMap (see
+// syscont.SignalMapAnnotation), if not nil.
+func newSignalHandler(enableSubreaper bool, notifySocket *notifySocket, sigMap map[unix.Signal]unix.Signal) *signalHandler {
 	if enableSubreaper {
 		// set us as the subreaper before registering the signal handler for the container
 		if err := system.SetSubreaper(1); err != nil {
@@ -35,6 +36,7 @@ func newSignalHandler(enableSubreaper bool, notifySocket *notifySocket) *signalH
 	return &signalHandler{
 		signals:      s,
 		notifySocket: notifySocket,
+		sigMap:       sigMap,
 	}
 }
 
@@ -48,6 +50,7 @@ type exit struct {
 type signalHandler struct {
 	signals      chan os.Signal
 	notifySocket *notifySocket
+	sigMap       map[unix.Signal]unix.Signal
 }
 
 // forward handles the main signal event loop forwarding, resizing, or reaping depending
@@ -70,7 +73,9 @@ func (h *signalHandler) forward(process *libcontainer.Process, tty *tty, detach
 			return 0, nil
 		}
 		h.notifySocket.run(os.Getpid())
-		go h.notifySocket.run(0)
+		// sysbox-runc: keep proxying the payload's notifications (e.g., its
+		// watchdog keep-alives) while we wait for it
+		go h.notifySocket.proxy()
 	}
 
 	// Perform the initial tty resize. Always ignore errors resizing because
@@ -88,10 +93,14 @@ func (h *signalHandler) forward(process *libcontainer.Process, tty *tty, detach
 				logrus.Error(err)
 			}
 			for _, e := range exits {
+				msg := "process exited"
+				if e.pid != pid1 {
+					msg = "reaped orphaned process"
+				}
 				logrus.WithFields(logrus.Fields{
 					"pid":    e.pid,
 					"status": e.status,
-				}).Debug("process exited")
+				}).Debug(msg)
 				if e.pid == pid1 {
 					// call Wait() on the process even though we already have the exit
 					// status because we must ensure that any of the go specific process
@@ -100,9 +109,17 @@ func (h *signalHandler) forward(process *libcontainer.Process, tty *tty, detach
 					return e.status, nil
 				}
 			}
+		case unix.SIGURG:
+			// sysbox-runc: SIGURG is used by the Go runtime to preempt
+			// goroutines, so most are spurious; don't forward them.
 		default:
-			logrus.Debugf("sending signal to process %s", s)
-			if err := unix.Kill(pid1, s.(unix.Signal)); err != nil {
+			sig := s.(unix.Signal)
+			if to, ok := h.sigMap[sig]; ok {
+				logrus.Debugf("translating signal %s to %s", sig, to)
+				sig = to
+			}
+			logrus.Debugf("sending signal to process %s", sig)
+			if err := unix.Kill(pid1, sig); err != nil {
 				logrus.Error(err)
 			}
 		}
@@ -112,25 +129,6 @@ func (h *signalHandler) forward(process *libcontainer.Process, tty *tty, detach
 
 // reap runs wait4 in a loop until we have finished processing any existing exits
 // then returns all exits to the main event loop for further processing.
-func (h *signalHandler) reap() (exits []exit, err error) {
-	var (
-		ws  unix.WaitStatus
-		rus unix.Rusage
-	)
-	for {
-		pid, err := unix.Wait4(-1, &ws, unix.WNOHANG, &rus)
-		if err != nil {
-			if err == unix.ECHILD {
-				return exits, nil
-			}
-			return nil, err
-		}
-		if pid <= 0 {
-			return exits, nil
-		}
-		exits = append(exits, exit{
-			pid:    pid,
-			status: utils.ExitStatus(ws),
-		})
-	}
+func (h *signalHandler) reap() ([]exit, error) {
+	return reapExits()
 }
@@ -0,0 +1,158 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libsysbox/rootfsclone"
+	"github.com/opencontainers/runc/libsysbox/snapshot"
+	"github.com/opencontainers/runc/libsysbox/sysbox"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var snapshotCommand = cli.Command{
+	Name:  "snapshot",
+	Usage: "capture the writable state of a container, to seed new containers with",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container to be
+snapshotted.
+
+EXAMPLE:
+To snapshot a CI runner whose docker image cache is warm, and seed new runners
+from it:
+
+       # sysbox-runc snapshot --output /snapshots/runner runner1
+
+and set the "io.nestybox.sysbox.seed-snapshot" annotation of the new runners
+to "/snapshots/runner".`,
+	Description: `The snapshot command captures the writable state of a container: the changes
+to its rootfs (or its whole rootfs, if it isn't a rootfs clone), and the dirs
+sysbox-mgr backs for it (e.g., its /var/lib/docker or /var/lib/kubelet). The
+container is paused meanwhile (unless it's paused or stopped already).
+
+The snapshot is a dir, or a tar archive if the output ends in ".tar" (or is "-",
+for stdout). Its files have the ownership seen inside the container, so that
+it can seed containers with other ID mappings (see the
+"io.nestybox.sysbox.seed-snapshot" annotation).`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "path of the snapshot dir (which must not exist) or tar archive",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		output := context.String("output")
+		if output == "" {
+			return fmt.Errorf("--output is required")
+		}
+
+		container, err := getContainer(context)
+		if err != nil {
+			return err
+		}
+		return snapshotContainer(container, output)
+	},
+}
+
+func snapshotContainer(container libcontainer.Container, output string) (err error) {
+	status, err := container.Status()
+	if err != nil {
+		return err
+	}
+	state, err := container.State()
+	if err != nil {
+		return err
+	}
+	src, err := snapshotSource(container.ID(), container.Config(), &state.SysMgr)
+	if err != nil {
+		return err
+	}
+
+	// the container's writable state must not change while it's captured
+	if status == libcontainer.Running || status == libcontainer.Created {
+		if err := container.Pause(); err != nil {
+			return err
+		}
+		defer func() {
+			if rerr := container.Resume(); rerr != nil {
+				if err == nil {
+					err = rerr
+				} else {
+					logrus.Warn(rerr)
+				}
+			}
+		}()
+	}
+
+	switch {
+	case output == "-":
+		return snapshot.CaptureTar(src, os.Stdout)
+	case strings.HasSuffix(output, ".tar"):
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if err := snapshot.CaptureTar(src, f); err != nil {
+			f.Close()
+			os.Remove(output)
+			return err
+		}
+		return f.Close()
+	default:
+		return snapshot.CaptureDir(src, output)
+	}
+}
+
+// snapshotSource returns the writable state of the container with the given
+// config: its rootfs (or the upper layer of its rootfs clone), and the dirs
+// bind-mounted from sysbox-mgr's data dir.
+func snapshotSource(id string, config configs.Config, mgr *sysbox.Mgr) (snapshot.Source, error) {
+	src := snapshot.Source{
+		ID:      id,
+		Rootfs:  config.Rootfs,
+		Volumes: map[string]string{},
+	}
+
+	if config.RootfsClone != "" {
+		src.Rootfs = rootfsclone.Upper(config.RootfsClone)
+		src.RootfsDelta = true
+	}
+
+	if len(config.UidMappings) > 0 && len(config.GidMappings) > 0 {
+		src.IDs = snapshot.IDMap{
+			UidHost: uint32(config.UidMappings[0].HostID),
+			UidSize: uint32(config.UidMappings[0].Size),
+			GidHost: uint32(config.GidMappings[0].HostID),
+			GidSize: uint32(config.GidMappings[0].Size),
+		}
+	}
+
+	if !mgr.Enabled() || mgr.DataDir == "" {
+		return src, nil
+	}
+	for _, m := range config.Mounts {
+		if m.Device != "bind" || !strings.HasPrefix(m.Source, mgr.DataDir+"/") {
+			continue
+		}
+		// e.g., per-container copies of /etc files
+		fi, err := os.Stat(m.Source)
+		if err != nil {
+			return src, err
+		}
+		if fi.IsDir() {
+			src.Volumes[m.Destination] = m.Source
+		}
+	}
+
+	return src, nil
+}
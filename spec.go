@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/specconv"
 	"github.com/opencontainers/runc/libsysbox/syscont"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -168,6 +169,29 @@ func loadSpec(cPath string) (spec *specs.Spec, err error) {
 	return spec, validateProcessSpec(spec.Process)
 }
 
+// sysbox-runc: loadProcessSched loads the scheduling attributes of the process
+// in the JSON file at the given path; if isSpec is set, the file is a
+// container spec rather than a process one.
+func loadProcessSched(path string, isSpec bool) (*specconv.ProcessSched, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isSpec {
+		var spec struct {
+			Process json.RawMessage `json:"process"`
+		}
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return nil, err
+		}
+		if spec.Process == nil {
+			return &specconv.ProcessSched{}, nil
+		}
+		data = spec.Process
+	}
+	return specconv.ParseProcessSched(data)
+}
+
 func createLibContainerRlimit(rlimit specs.POSIXRlimit) (configs.Rlimit, error) {
 	rl, err := strToRlimit(rlimit.Type)
 	if err != nil {
@@ -0,0 +1,72 @@
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/opencontainers/runc/libsysbox/spectest"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	"github.com/urfave/cli"
+)
+
+var specConvertCommand = cli.Command{
+	Name:  "spec-convert",
+	Usage: "convert a container specification to a reproducible sys container specification",
+	ArgsUsage: `<spec>
+
+Where "<spec>" is the path to an OCI specification file (config.json).
+
+EXAMPLE:
+To convert the spec of a bundle kept in git:
+
+       # sysbox-runc spec-convert --output converted.json config.json`,
+	Description: `The spec-convert command converts the given container spec to a sys
+container spec, the same way sysbox-runc does when creating a container,
+except that the result doesn't depend on the host or on the container
+instance, so it's the same wherever it's converted (and can be kept in git
+and compared across environments with spec-diff). Specifically, it leaves out:
+
+    - The sources of the sysbox-fs mounts (which hold the container's ID),
+      given as "` + syscont.SysboxFsPlaceholder + `/<path>" instead.
+    - The user-ID mappings, unless given in the spec.
+    - The sysbox-mgr mounts.
+    - The workload profile's bind mounts and devices.
+    - The CPU count env vars (see the cpu-coherence annotation).
+
+These are added when the container is created from the converted spec. Also,
+the mounts are ordered by destination, so that the order in which the
+container engine lists them doesn't matter.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "write the converted spec to the given file rather than stdout",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+
+		path := context.Args().First()
+		spec, err := spectest.LoadSpec(path)
+		if err != nil {
+			return err
+		}
+		if err := syscont.ConvertSpecReproducible(spec); err != nil {
+			return fmt.Errorf("failed to convert spec %s: %v", path, err)
+		}
+
+		if output := context.String("output"); output != "" {
+			return spectest.WriteSpec(output, spec)
+		}
+
+		data, err := json.MarshalIndent(spec, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	},
+}
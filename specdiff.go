@@ -0,0 +1,78 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/opencontainers/runc/libsysbox/spectest"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+)
+
+var specDiffCommand = cli.Command{
+	Name:  "spec-diff",
+	Usage: "compare two sys container specifications",
+	ArgsUsage: `<spec1> <spec2>
+
+Where "<spec1>" and "<spec2>" are paths to OCI specification files (config.json).
+
+EXAMPLE:
+To check how a change to the sysbox-runc spec conversion affects a spec
+generated by a container engine:
+
+       # sysbox-runc spec-diff converted.json new-converted.json`,
+	Description: `The spec-diff command compares two sys container specs, as converted by
+sysbox-runc from the specs generated by container engines, and prints their
+differences, one per line:
+
+    - <field>: <value>               field only in <spec1>
+    + <field>: <value>               field only in <spec2>
+    ~ <field>: <value1> -> <value2>  field with different values
+
+Fields are given as jq paths; list elements are compared by position.
+
+With --convert, the given specs are first converted to sys container specs,
+the same way sysbox-runc does when creating a container (except that no
+sysbox-mgr mounts are added).
+
+The command fails if the specs differ.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "convert",
+			Usage: "convert the given specs to sys container specs before comparing them",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 2, exactArgs); err != nil {
+			return err
+		}
+
+		var loaded [2]*specs.Spec
+		for i, path := range []string{context.Args().Get(0), context.Args().Get(1)} {
+			spec, err := spectest.LoadSpec(path)
+			if err != nil {
+				return err
+			}
+			if context.Bool("convert") {
+				if spec, err = spectest.Convert(spec); err != nil {
+					return fmt.Errorf("failed to convert spec %s: %v", path, err)
+				}
+			}
+			loaded[i] = spec
+		}
+
+		diffs, err := spectest.Diff(loaded[0], loaded[1])
+		if err != nil {
+			return err
+		}
+		for _, d := range diffs {
+			fmt.Println(d)
+		}
+
+		if len(diffs) > 0 {
+			return fmt.Errorf("specs differ (%d difference(s))", len(diffs))
+		}
+		return nil
+	},
+}
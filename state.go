@@ -5,9 +5,12 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 
 	"github.com/opencontainers/runc/libcontainer"
 	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/opencontainers/runc/libsysbox/syscont"
+	"github.com/opencontainers/runc/types"
 	"github.com/urfave/cli"
 )
 
@@ -19,10 +22,17 @@ var stateCommand = cli.Command{
 Where "<container-id>" is your name for the instance of the container.`,
 	Description: `The state command outputs current state information for the
 instance of a container.`,
+	Flags: []cli.Flag{
+		outputFlag(outputJSON, outputYAML, outputTable),
+	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 1, exactArgs); err != nil {
 			return err
 		}
+		format, err := outputFormat(context, outputJSON, outputYAML, outputTable)
+		if err != nil {
+			return err
+		}
 		container, err := getContainer(context)
 		if err != nil {
 			return err
@@ -40,7 +50,7 @@ instance of a container.`,
 			pid = 0
 		}
 		bundle, annotations := utils.Annotations(state.Config.Labels)
-		cs := containerState{
+		cs := types.ContainerState{
 			Version:        state.BaseState.Config.Version,
 			ID:             state.BaseState.ID,
 			InitProcessPid: pid,
@@ -50,6 +60,29 @@ instance of a container.`,
 			Created:        state.BaseState.Created,
 			Annotations:    annotations,
 		}
+		for _, d := range state.Config.UidShiftReport {
+			cs.UidShift = append(cs.UidShift, types.UidShift{
+				Source:      d.Source,
+				Destination: d.Destination,
+				Method:      string(d.Method),
+				Reason:      d.Reason,
+			})
+		}
+		if proxy, _ := syscont.GetNotifyProxy(annotations); proxy {
+			root, err := filepath.Abs(context.GlobalString("root"))
+			if err != nil {
+				return err
+			}
+			if cs.Ready, err = notifyProxyReady(filepath.Join(root, cs.ID)); err != nil {
+				return err
+			}
+		}
+		switch format {
+		case outputTable:
+			return writeContainerTable(os.Stdout, []types.ContainerState{cs})
+		case outputYAML:
+			return writeOutput(os.Stdout, format, cs)
+		}
 		data, err := json.MarshalIndent(cs, "", "  ")
 		if err != nil {
 			return err
@@ -0,0 +1,94 @@
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// State backends, i.e., where the container state is kept by default (see the
+// --state-backend flag); an explicit --root overrides them.
+const (
+	// stateBackendTmpfs keeps the state in the default root (under /run, or
+	// $XDG_RUNTIME_DIR), which is lost on reboot.
+	stateBackendTmpfs = "tmpfs"
+
+	// stateBackendDir keeps the state in a dir that doesn't depend on a tmpfs
+	// (persistentRoot), e.g., for hosts with a read-only /run or stateless
+	// images. It survives reboots: the containers of previous boots are
+	// listed as stopped, and their leftovers are torn down by the cleanup
+	// command.
+	stateBackendDir = "dir"
+
+	// stateBackendAuto is stateBackendTmpfs, unless the default root is on a
+	// read-only filesystem, in which case it's stateBackendDir.
+	stateBackendAuto = "auto"
+)
+
+// persistentRoot is the default state root of the "dir" state backend.
+const persistentRoot = "/var/lib/sysbox-runc/state"
+
+// resolveStateBackend sets the state root given by the --state-backend flag,
+// unless --root is given. The default root (see defaultRoot) follows the
+// backend, so that the containers in it keep being registered with sysbox-mgr
+// and sysbox-fs by their ID alone (see containerKey()).
+func resolveStateBackend(context *cli.Context) error {
+	// the init command runs in the container's namespaces, and has no use
+	// for the state root
+	if context.Args().First() == initCommand.Name {
+		return nil
+	}
+	backend := context.GlobalString("state-backend")
+
+	var root string
+	switch backend {
+	case stateBackendTmpfs:
+		return nil
+	case stateBackendDir:
+		root = persistentRoot
+	case stateBackendAuto:
+		if !readOnlyFs(defaultRoot) {
+			return nil
+		}
+		logrus.Debugf("state root %s is on a read-only filesystem; using %s", defaultRoot, persistentRoot)
+		root = persistentRoot
+	default:
+		return fmt.Errorf("invalid state backend %q (must be %s, %s or %s)",
+			backend, stateBackendTmpfs, stateBackendDir, stateBackendAuto)
+	}
+
+	defaultRoot = root
+	if context.IsSet("root") {
+		return nil
+	}
+	if err := os.MkdirAll(root, 0711); err != nil {
+		return fmt.Errorf("failed to create the state root %s: %w", root, err)
+	}
+	return context.GlobalSet("root", root)
+}
+
+// readOnlyFs returns true if the given path (or, if it doesn't exist, its
+// closest existing ancestor) is on a read-only filesystem.
+func readOnlyFs(path string) bool {
+	for {
+		err := unix.Access(path, unix.W_OK)
+		if err == nil {
+			return false
+		}
+		if errors.Is(err, unix.EROFS) {
+			return true
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, unix.ENOENT) || parent == path {
+			return false
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,396 @@
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/docker/go-units"
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// stdioFlags are the flags of the commands that create containers (create,
+// run) to select a runtime-managed backend for the container's stdio.
+var stdioFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "stdio-file",
+		Usage: "append the container's stdout and stderr to the given file (rotated per --stdio-file-max-size); requires a spec with no terminal",
+	},
+	cli.StringFlag{
+		Name:  "stdio-file-max-size",
+		Value: "10M",
+		Usage: "size at which the stdio file is rotated (0 means never)",
+	},
+	cli.IntFlag{
+		Name:  "stdio-file-max-files",
+		Value: 5,
+		Usage: "number of rotated stdio files kept (as <file>.1, <file>.2, ...)",
+	},
+	cli.StringFlag{
+		Name:  "stdio-socket",
+		Usage: "serve the container's stdout and stderr on an AF_UNIX socket at the given path, copied to every client connected; requires a spec with no terminal",
+	},
+}
+
+// stdioBackend is a runtime-managed backend of the container's stdio: the
+// container's stdout and stderr are written to a pipe, copied by a helper
+// process (see stdioLoggerCommand) to a rotating file and/or to the clients
+// of a unix socket. The helper outlives sysbox-runc (e.g., when the container
+// is detached), and exits when the container's processes close the pipe.
+//
+// It's meant for standalone usage of sysbox-runc, as otherwise the container
+// engine captures the container's stdio.
+type stdioBackend struct {
+	file      string
+	maxSize   int64
+	maxFiles  int
+	socket    string
+	logPath   string
+	logFormat string
+}
+
+// newStdioBackend returns the stdio backend selected via the command's flags,
+// or nil if there's none.
+func newStdioBackend(context *cli.Context) (*stdioBackend, error) {
+	file := context.String("stdio-file")
+	socket := context.String("stdio-socket")
+	if file == "" && socket == "" {
+		return nil, nil
+	}
+
+	maxSize, err := units.RAMInBytes(context.String("stdio-file-max-size"))
+	if err != nil || maxSize < 0 {
+		return nil, fmt.Errorf("invalid --stdio-file-max-size %q", context.String("stdio-file-max-size"))
+	}
+	maxFiles := context.Int("stdio-file-max-files")
+	if maxFiles < 0 {
+		return nil, fmt.Errorf("invalid --stdio-file-max-files %d", maxFiles)
+	}
+
+	return &stdioBackend{
+		file:      file,
+		maxSize:   maxSize,
+		maxFiles:  maxFiles,
+		socket:    socket,
+		logPath:   context.GlobalString("log"),
+		logFormat: context.GlobalString("log-format"),
+	}, nil
+}
+
+// setup sets the given process' stdout and stderr to a pipe whose reader is
+// the backend's helper process (started here). The file and socket are
+// created here (and handed over to the helper), so that errors are reported
+// before the container starts.
+func (b *stdioBackend) setup(process *libcontainer.Process, rootuid, rootgid int) (*tty, error) {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	args := []string{}
+	if b.logPath != "" {
+		args = append(args, "--log", b.logPath, "--log-format", b.logFormat)
+	}
+	args = append(args, "stdio-logger")
+
+	// the helper gets the file and socket as fds 3 and 4 (if any)
+	if b.file != "" {
+		f, err := os.OpenFile(b.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		args = append(args,
+			"--file", b.file,
+			"--file-fd", strconv.Itoa(2+len(files)),
+			"--max-size", strconv.FormatInt(b.maxSize, 10),
+			"--max-files", strconv.Itoa(b.maxFiles))
+	}
+
+	if b.socket != "" {
+		if err := os.Remove(b.socket); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		l, err := net.ListenUnix("unix", &net.UnixAddr{Name: b.socket, Net: "unix"})
+		if err != nil {
+			return nil, err
+		}
+		// the helper removes the socket on exit
+		l.SetUnlinkOnClose(false)
+		f, err := l.File()
+		l.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		args = append(args, "--socket", b.socket, "--socket-fd", strconv.Itoa(2+len(files)))
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// the container's processes may reopen their stdio (e.g., via /dev/stdout)
+	if err := unix.Fchown(int(w.Fd()), rootuid, rootgid); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to chown stdio pipe: %w", err)
+	}
+
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Args[0] = os.Args[0]
+	cmd.Stdin = r
+	cmd.ExtraFiles = files
+	// the helper must not get the signals sent to sysbox-runc's process group
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to start stdio logger: %w", err)
+	}
+	go cmd.Wait()
+
+	process.Stdin = nil
+	process.Stdout = w
+	process.Stderr = w
+
+	return &tty{postStart: []io.Closer{w}}, nil
+}
+
+var stdioLoggerCommand = cli.Command{
+	Name:   "stdio-logger",
+	Usage:  `copy a container's stdio to its stdio file and socket (do not call it outside of sysbox-runc)`,
+	Hidden: true,
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "file"},
+		cli.IntFlag{Name: "file-fd"},
+		cli.Int64Flag{Name: "max-size"},
+		cli.IntFlag{Name: "max-files"},
+		cli.StringFlag{Name: "socket"},
+		cli.IntFlag{Name: "socket-fd"},
+	},
+	Action: func(context *cli.Context) error {
+		var writers []io.Writer
+
+		if fd := context.Int("file-fd"); fd != 0 {
+			f := os.NewFile(uintptr(fd), context.String("file"))
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			rf := &rotatingFile{
+				path:     context.String("file"),
+				maxSize:  context.Int64("max-size"),
+				maxFiles: context.Int("max-files"),
+				f:        f,
+				size:     fi.Size(),
+			}
+			defer rf.Close()
+			writers = append(writers, rf)
+		}
+
+		if fd := context.Int("socket-fd"); fd != 0 {
+			f := os.NewFile(uintptr(fd), context.String("socket"))
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			fo := newFanout(l)
+			defer func() {
+				fo.Close()
+				os.Remove(context.String("socket"))
+			}()
+			writers = append(writers, fo)
+		}
+
+		if len(writers) == 0 {
+			return errors.New("no stdio file or socket given")
+		}
+
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				for _, w := range writers {
+					if _, werr := w.Write(buf[:n]); werr != nil {
+						logrus.Warnf("stdio logger: %v", werr)
+					}
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	},
+}
+
+// rotatingFile is a file that, when it would exceed the max size, is rotated:
+// it's renamed to <path>.1 (and <path>.1 to <path>.2, and so on, dropping the
+// one beyond the max number of files), and a new file is started.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+
+	if r.maxFiles == 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		for i := r.maxFiles - 1; i > 0; i-- {
+			old := fmt.Sprintf("%s.%d", r.path, i)
+			if err := os.Rename(old, fmt.Sprintf("%s.%d", r.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.size = 0
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	return r.f.Close()
+}
+
+// fanoutQueueLen is the number of writes queued for a fanout client; a client
+// that falls further behind is disconnected.
+const fanoutQueueLen = 256
+
+// fanout copies what's written to it to every client connected to its
+// listener (from the time they connect).
+type fanout struct {
+	l       net.Listener
+	mu      sync.Mutex
+	clients map[net.Conn]chan []byte
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func newFanout(l net.Listener) *fanout {
+	fo := &fanout{
+		l:       l,
+		clients: map[net.Conn]chan []byte{},
+	}
+	go fo.accept()
+	return fo
+}
+
+func (fo *fanout) accept() {
+	for {
+		conn, err := fo.l.Accept()
+		if err != nil {
+			return
+		}
+		ch := make(chan []byte, fanoutQueueLen)
+		fo.mu.Lock()
+		if fo.closed {
+			fo.mu.Unlock()
+			conn.Close()
+			return
+		}
+		fo.clients[conn] = ch
+		fo.wg.Add(1)
+		fo.mu.Unlock()
+
+		go fo.serve(conn, ch)
+	}
+}
+
+func (fo *fanout) serve(conn net.Conn, ch chan []byte) {
+	defer fo.wg.Done()
+	defer conn.Close()
+	for data := range ch {
+		if _, err := conn.Write(data); err != nil {
+			fo.drop(conn)
+			// drain the queue until it's closed
+			for range ch {
+			}
+			return
+		}
+	}
+}
+
+// drop disconnects the given client (its queue is closed).
+func (fo *fanout) drop(conn net.Conn) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	if ch, ok := fo.clients[conn]; ok {
+		delete(fo.clients, conn)
+		close(ch)
+	}
+}
+
+func (fo *fanout) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	for conn, ch := range fo.clients {
+		select {
+		case ch <- data:
+		default:
+			logrus.Warnf("stdio logger: dropping slow client of %s", fo.l.Addr())
+			delete(fo.clients, conn)
+			close(ch)
+		}
+	}
+	return len(p), nil
+}
+
+// Close stops accepting clients and disconnects the connected ones, once
+// they've been sent what was written.
+func (fo *fanout) Close() error {
+	err := fo.l.Close()
+	fo.mu.Lock()
+	fo.closed = true
+	for conn, ch := range fo.clients {
+		delete(fo.clients, conn)
+		close(ch)
+	}
+	fo.mu.Unlock()
+	fo.wg.Wait()
+	return err
+}
@@ -0,0 +1,77 @@
+// +build linux
+
+package main
+
+import (
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// sysbox-runc: with the global --subreaper option, sysbox-runc is the child
+// subreaper (see prctl(2)) of all the processes it launches (the container's
+// bootstrap, hooks, helper processes, etc.) for the whole command, rather than
+// only while it forwards signals to the container's process (see
+// newSignalHandler()). This is meant for when sysbox-runc is used without a
+// shim: orphaned descendants are reparented to it and reaped, rather than left
+// to whatever reaper is above it (which may not reap them at all, e.g., when
+// it's the pid 1 of a container).
+
+// setupSubreaper makes sysbox-runc a child subreaper if the --subreaper option
+// is given.
+func setupSubreaper(context *cli.Context) error {
+	if !context.GlobalBool("subreaper") {
+		return nil
+	}
+	return system.SetSubreaper(1)
+}
+
+// reapOrphans reaps the orphaned processes that have exited, if the
+// --subreaper option is given; it's called before sysbox-runc exits.
+func reapOrphans(context *cli.Context) {
+	if !context.GlobalBool("subreaper") {
+		return
+	}
+	exits, err := reapExits()
+	for _, e := range exits {
+		logrus.WithFields(logrus.Fields{
+			"pid":    e.pid,
+			"status": e.status,
+		}).Debug("reaped orphaned process")
+	}
+	if err != nil {
+		logrus.Warnf("reaping orphaned processes: %v", err)
+	}
+}
+
+// reapExits runs wait4 in a loop until there are no more exited children, and
+// returns their exits. On error, it returns the exits reaped so far along with
+// the error, so that they aren't lost.
+func reapExits() ([]exit, error) {
+	var (
+		exits []exit
+		ws    unix.WaitStatus
+		rus   unix.Rusage
+	)
+	for {
+		pid, err := unix.Wait4(-1, &ws, unix.WNOHANG, &rus)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			if err == unix.ECHILD {
+				return exits, nil
+			}
+			return exits, err
+		}
+		if pid <= 0 {
+			return exits, nil
+		}
+		exits = append(exits, exit{
+			pid:    pid,
+			status: utils.ExitStatus(ws),
+		})
+	}
+}
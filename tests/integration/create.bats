@@ -81,3 +81,39 @@ function teardown() {
 
 	testcontainer test_busybox running
 }
+
+@test "runc create --spec-patch" {
+	cat >"$BUSYBOX_BUNDLE/patch.json" <<-EOF2
+		[
+			{"op": "replace", "path": "/hostname", "value": "patched"},
+			{"op": "add", "path": "/process/env/-", "value": "PATCHED=1"}
+		]
+	EOF2
+
+	runc create --spec-patch "$BUSYBOX_BUNDLE/patch.json" --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	testcontainer test_busybox created
+
+	runc start test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox hostname
+	[ "$status" -eq 0 ]
+	[[ "${output}" == "patched" ]]
+
+	runc exec test_busybox sh -c 'echo $PATCHED'
+	[ "$status" -eq 0 ]
+	[[ "${output}" == "1" ]]
+
+	# the bundle's config must not be modified
+	[[ "$(jq -r '.hostname' "$BUSYBOX_BUNDLE/config.json")" != "patched" ]]
+}
+
+@test "runc create --spec-patch with invalid patch" {
+	echo '[{"op": "remove", "path": "/nothere"}]' >"$BUSYBOX_BUNDLE/patch.json"
+
+	runc create --spec-patch "$BUSYBOX_BUNDLE/patch.json" --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"failed to apply spec patch"* ]]
+}
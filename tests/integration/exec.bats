@@ -124,6 +124,61 @@ function teardown() {
 	[[ ${output} == "1000 100 65534" ]]
 }
 
+@test "runc exec --as-admin and --as-user" {
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec --as-admin test_busybox sh -c 'id -u; grep CapEff /proc/self/status'
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" == "0" ]]
+	[[ "${lines[1]}" != *"0000000000000000" ]]
+
+	# the user is resolved per the container's /etc/passwd, and gets no caps
+	runc exec --as-user daemon test_busybox sh -c 'id -u; grep CapEff /proc/self/status'
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" == "1" ]]
+	[[ "${lines[1]}" == *"0000000000000000" ]]
+
+	# unmapped users are rejected
+	runc exec --as-user 2000000000 test_busybox true
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"not mapped by the container's user namespace"* ]]
+
+	runc exec --as-admin --user 1000 test_busybox true
+	[ "$status" -ne 0 ]
+}
+
+@test "runc exec --cgroup" {
+	requires root cgroups_v2
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	# by default, the process joins the cgroup of the container's init
+	runc exec test_busybox cat /proc/1/cgroup
+	[ "$status" -eq 0 ]
+	init_cgroup="$output"
+
+	runc exec test_busybox cat /proc/self/cgroup
+	[ "$status" -eq 0 ]
+	[[ "$output" == "$init_cgroup" ]]
+
+	runc exec test_busybox mkdir /sys/fs/cgroup/sub
+	[ "$status" -eq 0 ]
+
+	runc exec --cgroup /sub test_busybox cat /proc/self/cgroup
+	[ "$status" -eq 0 ]
+	[[ "$output" == "0::"*"/sub" ]]
+
+	# the cgroup must exist
+	runc exec --cgroup /nonexistent test_busybox true
+	[ "$status" -ne 0 ]
+
+	runc exec --cgroup memory:/sub test_busybox true
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"unknown cgroup controller"* ]]
+}
+
 @test "runc exec --preserve-fds" {
 
 	# run busybox detached
@@ -136,3 +191,41 @@ function teardown() {
 	output=$(__runc exec --preserve-fds=2 test_busybox cat /proc/self/fd/4)
 	[[ "${output}" == "hello" ]]
 }
+
+@test "runc exec --rlimit" {
+
+	update_config '.process.rlimits = [
+		{"type": "RLIMIT_NOFILE", "hard": 1024, "soft": 1024},
+		{"type": "RLIMIT_NPROC", "hard": 4096, "soft": 2048}
+	]'
+
+	# run busybox detached
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox sh -c 'ulimit -n'
+	[ "$status" -eq 0 ]
+	[[ "${output}" == "1024" ]]
+
+	# only the given rlimit is overridden
+	runc exec --rlimit nofile=512:2048 test_busybox sh -c 'ulimit -Sn; ulimit -Hn; ulimit -Su'
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" == "512" ]]
+	[[ "${lines[1]}" == "2048" ]]
+	[[ "${lines[2]}" == "2048" ]]
+
+	runc exec --rlimit nofile=2048:1024 test_busybox true
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"the soft limit exceeds the hard one"* ]]
+}
+
+@test "runc exec --user with stdio reopened" {
+	# run busybox detached
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	# the process' stdio is owned by its (mapped) user, so it can reopen it
+	runc exec --user 1000:1000 test_busybox sh -c 'echo hello > /proc/self/fd/1'
+	[ "$status" -eq 0 ]
+	[[ "${output}" == "hello" ]]
+}
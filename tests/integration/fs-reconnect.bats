@@ -0,0 +1,59 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "fs-reconnect" {
+	runc --seccomp-keeper run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	testcontainer test_busybox running
+
+	# without sysbox-fs (see RUNC_FLAGS), there's nothing to reconnect
+	runc fs-reconnect test_busybox
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"test_busybox"* ]]
+
+	runc fs-reconnect --all
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"test_busybox"* ]]
+
+	runc kill test_busybox KILL
+	[ "$status" -eq 0 ]
+	wait_for_container 10 1 test_busybox stopped
+
+	# stopped containers can't be reconnected, nor are they with --all
+	runc fs-reconnect test_busybox
+	[ "$status" -ne 0 ]
+
+	runc fs-reconnect --all
+	[ "$status" -eq 0 ]
+	[[ "${output}" != *"test_busybox"* ]]
+}
+
+@test "fs-reconnect --all skips containers without seccomp fd keeper" {
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	testcontainer test_busybox running
+
+	runc fs-reconnect --all
+	[ "$status" -eq 0 ]
+	[[ "${output}" != *"test_busybox"* ]]
+}
+
+@test "fs-reconnect with no container" {
+	runc fs-reconnect
+	[ "$status" -ne 0 ]
+
+	runc fs-reconnect --all test_busybox
+	[ "$status" -ne 0 ]
+}
@@ -31,3 +31,76 @@ function teardown() {
 	runc delete test_busybox
 	[ "$status" -eq 0 ]
 }
+
+@test "kill --graceful" {
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	testcontainer test_busybox running
+
+	# busybox's sh ignores SIGRTMIN+3, so it's killed once the timeout expires
+	runc kill --graceful --timeout 1s test_busybox
+	[ "$status" -eq 0 ]
+
+	testcontainer test_busybox stopped
+
+	# it's a no-op for a stopped container
+	runc kill --graceful test_busybox
+	[ "$status" -eq 0 ]
+
+	runc delete test_busybox
+	[ "$status" -eq 0 ]
+}
+
+@test "kill real-time signal" {
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	testcontainer test_busybox running
+
+	runc kill test_busybox SIGRTMIN+65
+	[ "$status" -ne 0 ]
+
+	# busybox's sh ignores SIGRTMIN+3
+	runc kill test_busybox SIGRTMIN+3
+	[ "$status" -eq 0 ]
+
+	testcontainer test_busybox running
+
+	runc kill test_busybox KILL
+	[ "$status" -eq 0 ]
+	retry 10 1 eval "__runc state test_busybox | grep -q 'stopped'"
+
+	runc delete test_busybox
+	[ "$status" -eq 0 ]
+}
+
+@test "kill --cgroup" {
+	requires root cgroups_v2
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox mkdir /sys/fs/cgroup/sub
+	[ "$status" -eq 0 ]
+
+	runc exec -d --cgroup /sub test_busybox sleep 1000
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox pgrep sleep
+	[ "$status" -eq 0 ]
+
+	runc kill --cgroup /sub test_busybox KILL
+	[ "$status" -eq 0 ]
+
+	retry 10 1 eval "! __runc exec test_busybox pgrep sleep"
+
+	# the rest of the container is left running
+	testcontainer test_busybox running
+
+	runc kill --cgroup /nonexistent test_busybox KILL
+	[ "$status" -ne 0 ]
+
+	runc kill --cgroup /sub --all test_busybox KILL
+	[ "$status" -ne 0 ]
+}
@@ -54,3 +54,65 @@ function teardown() {
 	[[ "${lines[0]}" == *[,][\{]"\"ociVersion\""[:]"\""*[0-9][\.]*[0-9][\.]*[0-9]*"\""[,]"\"id\""[:]"\"test_box2\""[,]"\"pid\""[:]*[0-9][,]"\"status\""[:]*"\"running\""[,]"\"bundle\""[:]*$BUSYBOX_BUNDLE*[,]"\"rootfs\""[:]"\""*"\""[,]"\"created\""[:]*[0-9]*[\}]* ]]
 	[[ "${lines[0]}" == *[,][\{]"\"ociVersion\""[:]"\""*[0-9][\.]*[0-9][\.]*[0-9]*"\""[,]"\"id\""[:]"\"test_box3\""[,]"\"pid\""[:]*[0-9][,]"\"status\""[:]*"\"running\""[,]"\"bundle\""[:]*$BUSYBOX_BUNDLE*[,]"\"rootfs\""[:]"\""*"\""[,]"\"created\""[:]*[0-9]*[\}][\]] ]]
 }
+
+@test "list --output" {
+	ROOT=$HELLO_BUNDLE runc run -d --console-socket "$CONSOLE_SOCKET" test_box1
+	[ "$status" -eq 0 ]
+
+	ROOT=$HELLO_BUNDLE runc list -o json
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" == [\[][\{]"\"ociVersion\""*"\"id\""[:]"\"test_box1\""* ]]
+
+	ROOT=$HELLO_BUNDLE runc list -o yaml
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"id: \"test_box1\""* ]]
+	[[ "$output" == *"status: \"running\""* ]]
+
+	ROOT=$HELLO_BUNDLE runc list -o bogus
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"invalid output format"* ]]
+
+	ROOT=$HELLO_BUNDLE runc list --format json --output json
+	[ "$status" -ne 0 ]
+}
+
+@test "schema" {
+	runc schema
+	[ "$status" -eq 0 ]
+	[[ ${lines[0]} =~ NAME\ +VERSION\ +DESCRIPTION ]]
+	for name in events list ps state; do
+		[[ "$output" == *"$name"*"v1"* ]]
+	done
+
+	runc schema list
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"\"\$id\": \"sysbox-runc/v1/list\""* ]]
+
+	runc schema bogus
+	[ "$status" -ne 0 ]
+}
+
+@test "list --all-roots" {
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	ROOT=$HELLO_BUNDLE runc run -d --console-socket "$CONSOLE_SOCKET" test_box1
+	[ "$status" -eq 0 ]
+
+	# each root lists its own containers only
+	runc list -q
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"test_busybox"* ]]
+	[[ "${output}" != *"test_box1"* ]]
+
+	runc list --all-roots
+	[ "$status" -eq 0 ]
+	[[ ${lines[0]} =~ ID\ +PID\ +STATUS\ +BUNDLE\ +CREATED\ +OWNER\ +ROOT ]]
+	[[ "${output}" == *"test_busybox"*"running"*"$(readlink -f $ROOT)"* ]]
+	[[ "${output}" == *"test_box1"*"running"*"$(readlink -f $HELLO_BUNDLE)"* ]]
+
+	ROOT=$HELLO_BUNDLE runc list --all-roots --output json
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"\"root\":\"$(readlink -f $ROOT)\""* ]]
+	[[ "${output}" == *"\"root\":\"$(readlink -f $HELLO_BUNDLE)\""* ]]
+}
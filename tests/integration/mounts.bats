@@ -58,6 +58,36 @@ function teardown() {
 	rm -rf /mnt/test-dir
 }
 
+@test "runc run [shared mounts]" {
+	mkdir -p /mnt/shared-cache
+	echo "cached" >/mnt/shared-cache/test-file
+	chmod 0777 /mnt/shared-cache
+
+	update_config ' .annotations += {"io.nestybox.sysbox.shared-mounts": "/mnt/shared-cache:/cache"}
+						 | .process.args = ["sh", "-c", "cat /cache/test-file && ! touch /cache/new-file"]'
+
+	# the source must be allowed by the host
+	ALLOWLIST=$(mktemp)
+	runc --shared-mounts-allowlist "$ALLOWLIST" run test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"not allowed for container test_busybox"* ]]
+
+	echo "/mnt/shared-cache test_*" >"$ALLOWLIST"
+
+	# the source must not be writable from within the container
+	runc --shared-mounts-allowlist "$ALLOWLIST" run test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"must not be writable by group or others"* ]]
+
+	chmod 0755 /mnt/shared-cache
+
+	runc --shared-mounts-allowlist "$ALLOWLIST" run test_busybox
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" =~ 'cached' ]]
+
+	rm -rf /mnt/shared-cache "$ALLOWLIST"
+}
+
 @test "runc run [ro tmpfs mount]" {
 	update_config ' .mounts += [{
 											source: "tmpfs",
@@ -67,6 +67,33 @@ function teardown() {
 	[[ "${lines[1]}" =~ [0-9]+ ]]
 }
 
+@test "ps --nested" {
+	# ps is not supported, it requires cgroups
+	requires root
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	testcontainer test_busybox running
+
+	# a process in a pid namespace nested within the container's
+	runc exec -d test_busybox unshare -p -f sleep 1000
+	[ "$status" -eq 0 ]
+
+	runc ps --nested test_busybox
+	[ "$status" -eq 0 ]
+	[[ ${lines[0]} =~ LEVEL\ +NSPID\ +UID\ +PID ]]
+
+	runc ps --nested -o json test_busybox
+	[ "$status" -eq 0 ]
+
+	# the container's init is at level 0, with pid 1
+	[[ $(echo "$output" | jq '[.[] | select(.level == 0 and .nspid == 1)] | length') -eq 1 ]]
+
+	# the nested sleep is at level 1, with pid 1 in its namespace
+	[[ $(echo "$output" | jq '[.[] | select(.level == 1 and .nspid == 1)] | length') -eq 1 ]]
+}
+
 @test "ps after the container stopped" {
 	# ps requires cgroups
 	[[ "$ROOTLESS" -ne 0 ]] && requires rootless_cgroup
@@ -0,0 +1,42 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_running_container test_reap_expired
+	teardown_running_container test_reap_alive
+	teardown_busybox
+}
+
+@test "runc reap" {
+	update_config '.annotations += {"io.nestybox.sysbox.max-lifetime": "2s"}'
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_reap_expired
+	[ "$status" -eq 0 ]
+
+	update_config '.annotations += {"io.nestybox.sysbox.max-lifetime": "1h"}'
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_reap_alive
+	[ "$status" -eq 0 ]
+
+	sleep 3
+
+	runc reap --timeout 5s
+	[ "$status" -eq 0 ]
+	[[ "$output" == "test_reap_expired" ]]
+
+	runc state test_reap_expired
+	[ "$status" -ne 0 ]
+
+	testcontainer test_reap_alive running
+}
+
+@test "runc reap [invalid lifetime]" {
+	update_config '.annotations += {"io.nestybox.sysbox.max-lifetime": "3d"}'
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_reap_expired
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"io.nestybox.sysbox.max-lifetime"* ]]
+}
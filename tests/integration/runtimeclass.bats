@@ -0,0 +1,79 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	CFG_DIR=$(mktemp -d)
+
+	cat >"$CFG_DIR/config.yaml" <<EOT
+apiVersion: kubelet.config.k8s.io/v1beta1
+kind: KubeletConfiguration
+cgroupDriver: systemd
+EOT
+}
+
+function teardown() {
+	rm -rf "$CFG_DIR"
+}
+
+@test "runtimeclass with valid config" {
+	cat >"$CFG_DIR/config.toml" <<EOT
+version = 2
+[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.sysbox-runc]
+  runtime_type = "io.containerd.runc.v2"
+  [plugins."io.containerd.grpc.v1.cri".containerd.runtimes.sysbox-runc.options]
+    BinaryName = "$(readlink -f $RUNC)"
+    SystemdCgroup = true
+EOT
+
+	runc runtimeclass --kubelet-config "$CFG_DIR/config.yaml" --containerd-config "$CFG_DIR/config.toml"
+	[ "$status" -eq 0 ]
+	[[ "${output}" != *"[error]"* ]]
+	[[ "${output}" == *"handler: \"sysbox-runc\""* ]]
+}
+
+@test "runtimeclass with missing handler" {
+	cat >"$CFG_DIR/config.toml" <<EOT
+version = 2
+[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runc]
+  runtime_type = "io.containerd.runc.v2"
+EOT
+
+	runc runtimeclass --kubelet-config "$CFG_DIR/config.yaml" --containerd-config "$CFG_DIR/config.toml"
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"runtime handler \"sysbox-runc\" is not configured"* ]]
+
+	# the recommended stanza is printed anyway
+	[[ "${output}" == *"[plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.sysbox-runc]"* ]]
+	[[ "${output}" == *"SystemdCgroup = true"* ]]
+}
+
+@test "runtimeclass with cgroup driver mismatch" {
+	cat >"$CFG_DIR/config.toml" <<EOT
+version = 2
+[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.sysbox-runc]
+  runtime_type = "io.containerd.runc.v2"
+  [plugins."io.containerd.grpc.v1.cri".containerd.runtimes.sysbox-runc.options]
+    BinaryName = "$(readlink -f $RUNC)"
+    SystemdCgroup = false
+EOT
+
+	runc runtimeclass --kubelet-config "$CFG_DIR/config.yaml" --containerd-config "$CFG_DIR/config.toml"
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"SystemdCgroup = false, but the kubelet uses the systemd cgroup driver"* ]]
+}
+
+@test "runtimeclass with wrong binary" {
+	cat >"$CFG_DIR/config.toml" <<EOT
+version = 2
+[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.sysbox-runc]
+  runtime_type = "io.containerd.runc.v2"
+  [plugins."io.containerd.grpc.v1.cri".containerd.runtimes.sysbox-runc.options]
+    BinaryName = "/usr/bin/runc"
+    SystemdCgroup = true
+EOT
+
+	runc runtimeclass --kubelet-config "$CFG_DIR/config.yaml" --containerd-config "$CFG_DIR/config.toml"
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"BinaryName is /usr/bin/runc"* ]]
+}
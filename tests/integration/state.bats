@@ -64,3 +64,14 @@ function teardown() {
 	# test state of busybox is back to running
 	testcontainer test_busybox running
 }
+
+@test "state with json error output" {
+	runc --log-format json state test_busybox
+	[ "$status" -ne 0 ]
+	echo "$output" | jq -e '.code == "SYSBOX_ERR_CONTAINER_NOT_FOUND"'
+	echo "$output" | jq -e '.level == "error"'
+
+	runc --log-format json state
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"SYSBOX_ERR_INVALID_ARGS"* ]]
+}
@@ -0,0 +1,63 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+	update_config '.process.terminal = false'
+}
+
+function teardown() {
+	teardown_busybox
+	rm -f "$BATS_TMPDIR"/stdio.log* "$BATS_TMPDIR"/stdio.sock
+}
+
+@test "run --stdio-file" {
+	update_config '.process.args = ["sh", "-c", "echo out; echo err >&2"]'
+
+	runc run --stdio-file "$BATS_TMPDIR/stdio.log" test_busybox
+	[ "$status" -eq 0 ]
+
+	retry 10 1 grep -q err "$BATS_TMPDIR/stdio.log"
+	grep -q out "$BATS_TMPDIR/stdio.log"
+}
+
+@test "run --stdio-file rotation" {
+	update_config '.process.args = ["sh", "-c", "for i in $(seq 100); do echo 0123456789012345678901234567890123456789; sleep 0.01; done"]'
+
+	runc run --stdio-file "$BATS_TMPDIR/stdio.log" --stdio-file-max-size 1k --stdio-file-max-files 2 test_busybox
+	[ "$status" -eq 0 ]
+
+	retry 10 1 [ -e "$BATS_TMPDIR/stdio.log.2" ]
+	[ ! -e "$BATS_TMPDIR/stdio.log.3" ]
+	[ "$(stat -c %s "$BATS_TMPDIR/stdio.log.1")" -le 1024 ]
+}
+
+@test "run --stdio-socket" {
+	requires root
+	command -v socat >/dev/null || skip "requires socat"
+
+	update_config '.process.args = ["sh", "-c", "while true; do echo tick; sleep 0.1; done"]'
+
+	runc run -d --stdio-socket "$BATS_TMPDIR/stdio.sock" --stdio-file "$BATS_TMPDIR/stdio.log" test_busybox
+	[ "$status" -eq 0 ]
+
+	run timeout 2 socat -u UNIX-CONNECT:"$BATS_TMPDIR/stdio.sock" -
+	[[ "$output" == *"tick"* ]]
+	grep -q tick "$BATS_TMPDIR/stdio.log"
+
+	runc kill test_busybox KILL
+	[ "$status" -eq 0 ]
+
+	# the socket is removed once the container's processes exit
+	retry 10 1 [ ! -e "$BATS_TMPDIR/stdio.sock" ]
+}
+
+@test "run --stdio-file with a terminal" {
+	update_config '.process.terminal = true'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" --stdio-file "$BATS_TMPDIR/stdio.log" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"tty"* ]]
+}
@@ -0,0 +1,45 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+	AUDIT_DIR=$(mktemp -d /tmp/sysbox-runc-audit.XXXXXX)
+}
+
+function teardown() {
+	teardown_running_container test_audit
+	teardown_busybox
+	rm -rf "$AUDIT_DIR"
+}
+
+@test "syscont: audit log to file" {
+
+	runc --audit-log "file:$AUDIT_DIR/audit.log" run -d --console-socket "$CONSOLE_SOCKET" test_audit
+	[ "$status" -eq 0 ]
+
+	[ "$(stat -c %a "$AUDIT_DIR/audit.log")" = "600" ]
+	[ "$(wc -l <"$AUDIT_DIR/audit.log")" -eq 1 ]
+
+	run jq -r '.id' "$AUDIT_DIR/audit.log"
+	[[ "$output" == "test_audit" ]]
+
+	# the conversion adds the user namespace and the sys container's mounts
+	run jq -e '.changes | map(select(.path | startswith(".linux.namespaces"))) | length > 0' "$AUDIT_DIR/audit.log"
+	[ "$status" -eq 0 ]
+
+	run jq -e '.summary.mountsAdded | length > 0' "$AUDIT_DIR/audit.log"
+	[ "$status" -eq 0 ]
+}
+
+@test "syscont: invalid audit sink" {
+
+	runc --audit-log "file:relative.log" run -d --console-socket "$CONSOLE_SOCKET" test_audit
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"invalid audit sink"* ]]
+
+	runc --audit-log "file:/nonexistent/dir/audit.log" run -d --console-socket "$CONSOLE_SOCKET" test_audit
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"audit sink"* ]]
+}
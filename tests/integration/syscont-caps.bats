@@ -98,3 +98,80 @@ function teardown() {
 # - exec into sys container as user 1000 with --cap=CAP_SYS_ADMIN; verify root has CAP_SYS_ADMIN only
 
 # TODO: Verify specs without capabilities object are handled correctly
+
+# The "hardened" caps profile drops the caps that are no-ops or host-wide
+@test "syscont: hardened caps profile" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.caps-profile": "hardened"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	for capType in CapInh CapPrm CapEff CapBnd CapAmb; do
+		runc exec test_busybox grep "$capType" /proc/1/status
+		[ "$status" -eq 0 ]
+		[[ "${output}" == *"00000020bbecfdff"* ]]
+
+		runc exec test_busybox grep "$capType" /proc/self/status
+		[ "$status" -eq 0 ]
+		[[ "${output}" == *"00000020bbecfdff"* ]]
+	done
+}
+
+# A caps profile may list the caps explicitly
+@test "syscont: custom caps profile" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.caps-profile": "chown,CAP_SETUID,cap_setgid"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	for capType in CapInh CapPrm CapEff CapBnd CapAmb; do
+		runc exec test_busybox grep "$capType" /proc/1/status
+		[ "$status" -eq 0 ]
+		[[ "${output}" == *"00000000000000c1"* ]]
+	done
+}
+
+@test "syscont: invalid caps profile" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.caps-profile": "CAP_BOGUS"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"invalid value for annotation io.nestybox.sysbox.caps-profile"* ]]
+}
+
+# The process caps in the spec are kept when honor-caps is set
+@test "syscont: honor caps" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.honor-caps": "true"}
+		| .process.capabilities = {
+			"bounding": ["CAP_CHOWN", "CAP_SETGID", "CAP_SETUID", "CAP_SYS_ADMIN"],
+			"effective": ["CAP_CHOWN", "CAP_SETGID", "CAP_SETUID", "CAP_SYS_ADMIN"],
+			"permitted": ["CAP_CHOWN", "CAP_SETGID", "CAP_SETUID", "CAP_SYS_ADMIN"]
+		}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	for capType in CapPrm CapEff CapBnd; do
+		runc exec test_busybox grep "$capType" /proc/1/status
+		[ "$status" -eq 0 ]
+		[[ "${output}" == *"00000000002000c1"* ]]
+	done
+
+	runc exec test_busybox grep CapAmb /proc/1/status
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"0000000000000000"* ]]
+}
+
+@test "syscont: honor caps without sysbox required caps" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.honor-caps": "true"}
+		| .process.capabilities = {"bounding": ["CAP_CHOWN"], "effective": ["CAP_CHOWN"], "permitted": ["CAP_CHOWN"]}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"required by sysbox when io.nestybox.sysbox.honor-caps is set"* ]]
+}
@@ -77,3 +77,86 @@ function teardown() {
 		[ "$status" -eq 0 ]
 	done
 }
+
+# Verify the pids reserve for sysbox-runc's helper processes (cgroup v1 only)
+@test "syscont: pids reserve" {
+	requires root cgroups_v1
+
+	set_cgroups_path "$BUSYBOX_BUNDLE"
+	update_config '.linux.resources.pids |= {"limit": 64}
+		| .annotations += {"io.nestybox.sysbox.pids-reserve": "8"}' "$BUSYBOX_BUNDLE"
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	# the sys container's cgroup root gets the pids limit minus the reserve
+	check_cgroup_value "pids.max" 64
+	[ "$(cat $CGROUP_PIDS/syscont-cgroup-root/pids.max)" = "56" ]
+
+	# exec'd processes end up in the sys container's cgroup root
+	runc exec test_busybox sh -c 'grep pids /proc/self/cgroup | cut -d":" -f3'
+	[ "$status" -eq 0 ]
+	[ "${lines[0]}" = "/" ]
+
+	# updates are mirrored in the sys container's cgroup root
+	runc update test_busybox --pids-limit 128
+	[ "$status" -eq 0 ]
+	check_cgroup_value "pids.max" 128
+	[ "$(cat $CGROUP_PIDS/syscont-cgroup-root/pids.max)" = "120" ]
+
+	runc update test_busybox --pids-limit -1
+	[ "$status" -eq 0 ]
+	[ "$(cat $CGROUP_PIDS/syscont-cgroup-root/pids.max)" = "max" ]
+
+	# the limit can't be lowered below the reserve
+	runc update test_busybox --pids-limit 8
+	[ "$status" -ne 0 ]
+}
+
+# Verify there's no pids reserve unless requested (cgroup v1 only)
+@test "syscont: no pids reserve by default" {
+	requires root cgroups_v1
+
+	set_cgroups_path "$BUSYBOX_BUNDLE"
+	update_config '.linux.resources.pids |= {"limit": 64}' "$BUSYBOX_BUNDLE"
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	# the workloads get the whole pids limit
+	check_cgroup_value "pids.max" 64
+	[ "$(cat $CGROUP_PIDS/syscont-cgroup-root/pids.max)" = "max" ]
+}
+
+# Verify the policy for attaching the device filter (cgroup v2 only)
+@test "syscont: devices policy" {
+	requires root cgroups_v2
+
+	update_config '.annotations += {"io.nestybox.sysbox.devices-policy": "bogus"}' "$BUSYBOX_BUNDLE"
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+
+	for policy in multi exclusive; do
+		update_config '.annotations += {"io.nestybox.sysbox.devices-policy": "'$policy'"}' "$BUSYBOX_BUNDLE"
+
+		runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+		[ "$status" -eq 0 ]
+
+		runc exec test_busybox sh -c 'echo hi > /dev/null && head -c 1 /dev/zero | wc -c'
+		[ "$status" -eq 0 ]
+		[ "${lines[0]}" = "1" ]
+
+		# updates replace the device filter; device access is unaffected
+		runc update test_busybox --pids-limit 100
+		[ "$status" -eq 0 ]
+		runc update test_busybox --pids-limit 200
+		[ "$status" -eq 0 ]
+
+		runc exec test_busybox sh -c 'echo hi > /dev/null && head -c 1 /dev/zero | wc -c'
+		[ "$status" -eq 0 ]
+		[ "${lines[0]}" = "1" ]
+
+		runc delete -f test_busybox
+		[ "$status" -eq 0 ]
+	done
+}
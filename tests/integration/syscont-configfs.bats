@@ -0,0 +1,56 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+	ALLOWLIST="$(mktemp)"
+}
+
+function teardown() {
+	teardown_busybox
+	rm -f "$ALLOWLIST"
+}
+
+@test "syscont: configfs passthrough" {
+
+	[ -d /sys/kernel/config/nvmet ] || skip "needs the nvmet module"
+
+	echo "nvmet test_*" > "$ALLOWLIST"
+	update_config '.annotations += {"io.nestybox.sysbox.configfs": "passthrough:nvmet"}'
+
+	runc --configfs-passthrough-allowlist "$ALLOWLIST" run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox ls /sys/kernel/config/nvmet/subsystems
+	[ "$status" -eq 0 ]
+
+	# the host's configfs is read-only in the container
+	runc exec test_busybox mkdir /sys/kernel/config/nvmet/subsystems/sysbox-test
+	[ "$status" -ne 0 ]
+}
+
+@test "syscont: configfs passthrough not allowed" {
+
+	echo "target test_*" > "$ALLOWLIST"
+	update_config '.annotations += {"io.nestybox.sysbox.configfs": "passthrough:nvmet"}'
+
+	runc --configfs-passthrough-allowlist "$ALLOWLIST" run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"configfs subtree nvmet not allowed"* ]]
+
+	# no allowlist
+	runc --configfs-passthrough-allowlist /nonexistent run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"allowlist /nonexistent not found"* ]]
+}
+
+@test "syscont: invalid configfs mode" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.configfs": "passthrough:usb_gadget"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"invalid subtree \"usb_gadget\""* ]]
+}
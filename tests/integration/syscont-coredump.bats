@@ -0,0 +1,71 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+
+	CORE_DIR=$(mktemp -d /var/tmp/sysbox-cores.XXXXXX)
+	HOST_CORE_PATTERN=$(cat /proc/sys/kernel/core_pattern)
+	echo "|$(readlink -f "$RUNC") --root $ROOT coredump %P %p %s %t %e" > /proc/sys/kernel/core_pattern
+}
+
+function teardown() {
+	echo "$HOST_CORE_PATTERN" > /proc/sys/kernel/core_pattern
+	teardown_running_container test_coredump
+	teardown_busybox
+	rm -rf "$CORE_DIR"
+}
+
+@test "syscont: core dump capture" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.core-dump": "dir='"$CORE_DIR"',max-size=64M"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_coredump
+	[ "$status" -eq 0 ]
+
+	# the container's core_pattern shows where its core dumps are stored
+	runc exec test_coredump cat /proc/sys/kernel/core_pattern
+	[ "$status" -eq 0 ]
+	[[ "$output" == "/var/crash/core.%e.%p.%t" ]]
+
+	# the core dump dir is owned by the container's root user
+	runc exec test_coredump stat -c "%u:%g" /var/crash
+	[ "$status" -eq 0 ]
+	[[ "$output" == "0:0" ]]
+
+	runc exec test_coredump sh -c 'ulimit -c unlimited; sh -c "kill -SEGV \$\$"'
+
+	# the core dump is stored in the container's dir, owned by the dumping user
+	runc exec test_coredump sh -c 'ls /var/crash/core.sh.*'
+	[ "$status" -eq 0 ]
+
+	run sh -c "stat -c '%u:%g' $CORE_DIR/core.sh.*"
+	[ "$status" -eq 0 ]
+	[[ "$output" == "$UID_MAP:$GID_MAP" ]]
+}
+
+@test "syscont: core dump size limit" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.core-dump": "dir='"$CORE_DIR"',max-size=4k"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_coredump
+	[ "$status" -eq 0 ]
+
+	runc exec test_coredump sh -c 'ulimit -c unlimited; sh -c "kill -SEGV \$\$"'
+
+	# core dumps larger than max-size are discarded
+	run sh -c "ls $CORE_DIR"
+	[ "$status" -eq 0 ]
+	[ -z "$output" ]
+}
+
+@test "syscont: core dump annotation" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.core-dump": "max-size=64M"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_coredump
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"io.nestybox.sysbox.core-dump: missing dir"* ]]
+}
@@ -0,0 +1,73 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_running_container test_cpucoh
+	teardown_busybox
+}
+
+function init_env() {
+	runc exec test_cpucoh sh -c "tr '\0' '\n' < /proc/1/environ"
+}
+
+@test "syscont: cpu coherence env" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.cpu-coherence": "env"}
+		| .linux.resources.cpu = {"quota": 150000, "period": 100000}
+		| .process.env += ["JAVA_TOOL_OPTIONS=-Xmx64m"]'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_cpucoh
+	[ "$status" -eq 0 ]
+
+	ncpus=$(nproc)
+	[ "$ncpus" -lt 2 ] && want=$ncpus || want=2
+
+	init_env
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"GOMAXPROCS=$want"* ]]
+	[[ "$output" == *"OMP_NUM_THREADS=$want"* ]]
+	[[ "$output" == *"JAVA_TOOL_OPTIONS=-Xmx64m -XX:ActiveProcessorCount=$want"* ]]
+}
+
+@test "syscont: cpu coherence env honors process env" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.cpu-coherence": "env"}
+		| .linux.resources.cpu = {"quota": 100000, "period": 100000}
+		| .process.env += ["GOMAXPROCS=7"]'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_cpucoh
+	[ "$status" -eq 0 ]
+
+	init_env
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"GOMAXPROCS=7"* ]]
+	[[ "$output" == *"OMP_NUM_THREADS=1"* ]]
+}
+
+@test "syscont: cpu coherence off" {
+
+	update_config '.linux.resources.cpu = {"quota": 100000, "period": 100000}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_cpucoh
+	[ "$status" -eq 0 ]
+
+	init_env
+	[ "$status" -eq 0 ]
+	[[ "$output" != *"GOMAXPROCS"* ]]
+	[[ "$output" != *"OMP_NUM_THREADS"* ]]
+}
+
+@test "syscont: cpu coherence invalid" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.cpu-coherence": "on"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_cpucoh
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"io.nestybox.sysbox.cpu-coherence"* ]]
+}
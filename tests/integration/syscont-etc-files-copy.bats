@@ -0,0 +1,30 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "syscont: invalid etc files copy" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.etc-files-copy": "/etc/resolv.conf,/etc/passwd"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"invalid value for annotation io.nestybox.sysbox.etc-files-copy: \"/etc/passwd\""* ]]
+}
+
+@test "syscont: etc files copy without bind mounts" {
+
+	# nothing to copy, as the spec bind-mounts none of the files
+	update_config '.annotations += {"io.nestybox.sysbox.etc-files-copy": "/etc/resolv.conf,/etc/hosts"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+}
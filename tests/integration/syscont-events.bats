@@ -0,0 +1,84 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+	SOCK_DIR=$(mktemp -d /tmp/sysbox-runc-events.XXXXXX)
+}
+
+function teardown() {
+	teardown_running_container test_events
+	teardown_busybox
+	rm -rf "$SOCK_DIR"
+}
+
+function run_events_container() {
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_events
+	[ "$status" -eq 0 ]
+}
+
+@test "events --filter thresholds" {
+	requires root
+	run_events_container
+
+	runc events --stats --filter 'pids>=1' test_events
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" == *'"type":"stats"'* ]]
+
+	runc events --stats --filter 'pids>100000' test_events
+	[ "$status" -eq 0 ]
+	[ -z "$output" ]
+
+	runc events --stats --filter type=oom test_events
+	[ "$status" -eq 0 ]
+	[ -z "$output" ]
+
+	runc events --stats --filter 'cpu>1' test_events
+	[ "$status" -ne 0 ]
+
+	runc events --stats --filter 'memory=1M' test_events
+	[ "$status" -ne 0 ]
+}
+
+@test "events --filter cgroup" {
+	requires root
+	run_events_container
+
+	runc exec test_events sh -c "mkdir /sys/fs/cgroup/memory/inner 2>/dev/null || mkdir /sys/fs/cgroup/inner"
+	[ "$status" -eq 0 ]
+
+	runc events --stats --filter 'cgroup=/inn*' test_events
+	[ "$status" -eq 0 ]
+	[ "${#lines[@]}" -eq 1 ]
+	[[ "${lines[0]}" == *'"cgroup":"/inner"'* ]]
+
+	runc events --stats --filter 'cgroup=/,/inner' test_events
+	[ "$status" -eq 0 ]
+	[ "${#lines[@]}" -eq 2 ]
+}
+
+@test "events --watch --listen" {
+	requires root
+
+	__runc events --watch --interval 1s --filter type=start,exit --listen "$SOCK_DIR/events.sock" &
+	pid=$!
+	retry 10 0.5 test -S "$SOCK_DIR/events.sock"
+
+	exec 3< <(timeout 10 socat -u UNIX-CONNECT:"$SOCK_DIR/events.sock" -)
+
+	run_events_container
+	runc kill test_events KILL
+	[ "$status" -eq 0 ]
+
+	read -t 5 -u 3 line
+	[[ "$line" == *'"type":"start","id":"test_events"'* ]]
+	read -t 5 -u 3 line
+	[[ "$line" == *'"type":"exit","id":"test_events"'* ]]
+	exec 3<&-
+
+	kill -TERM $pid
+	wait $pid
+	[ ! -e "$SOCK_DIR/events.sock" ]
+}
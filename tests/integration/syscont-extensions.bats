@@ -0,0 +1,60 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+	EXT_DIR=$(mktemp -d /tmp/sysbox-runc-extensions.XXXXXX)
+	EXT_LOG="$EXT_DIR/log"
+}
+
+function teardown() {
+	teardown_running_container test_extensions
+	teardown_busybox
+	rm -rf "$EXT_DIR"
+}
+
+function add_extension() {
+	local point=$1 name=$2 script=$3
+
+	mkdir -p "$EXT_DIR/$point"
+	printf '#!/bin/sh\n%s\n' "$script" >"$EXT_DIR/$point/$name"
+	chmod +x "$EXT_DIR/$point/$name"
+}
+
+@test "syscont: extensions run at their extension points" {
+
+	add_extension pre-convert-spec 10-hostname "sed 's/\"hostname\":\"[^\"]*\"/\"hostname\":\"from-ext\"/'"
+	add_extension post-mount-setup 10-log "cat >>$EXT_LOG; echo >>$EXT_LOG"
+
+	runc --extensions-dir "$EXT_DIR" run -d --console-socket "$CONSOLE_SOCKET" test_extensions
+	[ "$status" -eq 0 ]
+
+	testcontainer test_extensions running
+
+	runc exec test_extensions hostname
+	[ "$status" -eq 0 ]
+	[[ "$output" == "from-ext" ]]
+
+	run grep -c '"point":"post-mount-setup","id":"test_extensions"' "$EXT_LOG"
+	[ "$status" -eq 0 ]
+	[[ "$output" == "1" ]]
+}
+
+@test "syscont: failing extension fails creation" {
+
+	add_extension post-mount-setup 10-fail "echo 'not allowed here' >&2; exit 1"
+
+	runc --extensions-dir "$EXT_DIR" run -d --console-socket "$CONSOLE_SOCKET" test_extensions
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"not allowed here"* ]]
+}
+
+@test "syscont: missing extensions dir" {
+
+	runc --extensions-dir /nonexistent run -d --console-socket "$CONSOLE_SOCKET" test_extensions
+	[ "$status" -eq 0 ]
+
+	testcontainer test_extensions running
+}
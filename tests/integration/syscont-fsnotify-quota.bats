@@ -0,0 +1,35 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "syscont: invalid fsnotify quota" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.fsnotify-quota": "inotify-watches=524288,inotify-queued-events=16384"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"unknown key \"inotify-queued-events\""* ]]
+}
+
+@test "syscont: update fsnotify quota with an invalid value" {
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc update --inotify-watches lots test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"invalid value for inotify-watches"* ]]
+
+	# the container's config is left as is
+	runc update --pids-limit 100 test_busybox
+	[ "$status" -eq 0 ]
+}
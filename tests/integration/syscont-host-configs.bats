@@ -0,0 +1,96 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+
+	# the tests run in a test container, so the host's krb5 config is ours
+	[ -e /etc/krb5.conf ] && mv /etc/krb5.conf /etc/krb5.conf.orig
+	[ -e /etc/krb5.conf.d ] && mv /etc/krb5.conf.d /etc/krb5.conf.d.orig
+
+	printf '[libdefaults]\n\tdefault_realm = EXAMPLE.COM\n' >/etc/krb5.conf
+	chmod 0600 /etc/krb5.conf
+	mkdir /etc/krb5.conf.d
+	echo "# a" >/etc/krb5.conf.d/a.conf
+}
+
+function teardown() {
+	teardown_running_container test_hostcfg
+	teardown_busybox
+
+	rm -rf /etc/krb5.conf /etc/krb5.conf.d
+	[ -e /etc/krb5.conf.orig ] && mv /etc/krb5.conf.orig /etc/krb5.conf
+	[ -e /etc/krb5.conf.d.orig ] && mv /etc/krb5.conf.d.orig /etc/krb5.conf.d
+	true
+}
+
+@test "syscont: host configs mounted" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.host-configs": "krb5"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_hostcfg
+	[ "$status" -eq 0 ]
+
+	runc exec test_hostcfg cat /etc/krb5.conf
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"default_realm = EXAMPLE.COM"* ]]
+
+	runc exec test_hostcfg cat /etc/krb5.conf.d/a.conf
+	[ "$status" -eq 0 ]
+	[[ "$output" == "# a" ]]
+
+	# owned by the container's root (though only readable by the host's root)
+	runc exec test_hostcfg stat -c "%u:%g %a" /etc/krb5.conf
+	[ "$status" -eq 0 ]
+	[[ "$output" == "0:0 600" ]]
+
+	# read-only
+	runc exec test_hostcfg sh -c "echo foo > /etc/krb5.conf"
+	[ "$status" -ne 0 ]
+	runc exec test_hostcfg touch /etc/krb5.conf.d/b.conf
+	[ "$status" -ne 0 ]
+}
+
+@test "syscont: host configs refresh" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.host-configs": "krb5"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_hostcfg
+	[ "$status" -eq 0 ]
+
+	printf '[libdefaults]\n\tdefault_realm = CORP.EXAMPLE.COM\n' >/etc/krb5.conf
+	rm /etc/krb5.conf.d/a.conf
+	echo "# b" >/etc/krb5.conf.d/b.conf
+
+	# not refreshed until asked to
+	runc exec test_hostcfg cat /etc/krb5.conf
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"default_realm = EXAMPLE.COM"* ]]
+
+	runc refresh-host-configs test_hostcfg
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"test_hostcfg"* ]]
+
+	runc exec test_hostcfg cat /etc/krb5.conf
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"default_realm = CORP.EXAMPLE.COM"* ]]
+
+	runc exec test_hostcfg ls /etc/krb5.conf.d
+	[ "$status" -eq 0 ]
+	[[ "$output" == "b.conf" ]]
+
+	runc refresh-host-configs --all
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"test_hostcfg"* ]]
+}
+
+@test "syscont: host configs annotation" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.host-configs": "krb5,passwd"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_hostcfg
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"io.nestybox.sysbox.host-configs: invalid host config \"passwd\""* ]]
+}
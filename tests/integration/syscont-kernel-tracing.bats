@@ -0,0 +1,57 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+	ALLOWLIST="$(mktemp)"
+}
+
+function teardown() {
+	teardown_busybox
+	rm -f "$ALLOWLIST"
+}
+
+@test "syscont: kernel tracing passthrough" {
+
+	[ -d /sys/kernel/tracing/events ] || skip "needs tracefs"
+
+	echo "test_*" > "$ALLOWLIST"
+	update_config '.annotations += {"io.nestybox.sysbox.kernel-tracing": "ro"}'
+
+	runc --kernel-tracing-allowlist "$ALLOWLIST" run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	# the host's tracefs, rather than the dummy tmpfs
+	runc exec test_busybox ls /sys/kernel/tracing/events
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox sh -c "echo 1 > /sys/kernel/tracing/tracing_on"
+	[ "$status" -ne 0 ]
+}
+
+@test "syscont: kernel tracing passthrough not allowed" {
+
+	# no entry for the container
+	echo "other_*" > "$ALLOWLIST"
+	echo "# test_busybox rw" >> "$ALLOWLIST"
+	update_config '.annotations += {"io.nestybox.sysbox.kernel-tracing": "ro"}'
+
+	runc --kernel-tracing-allowlist "$ALLOWLIST" run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"not allowed for container test_busybox"* ]]
+
+	# rw needs an rw entry
+	echo "test_busybox" > "$ALLOWLIST"
+	update_config '.annotations += {"io.nestybox.sysbox.kernel-tracing": "rw"}'
+
+	runc --kernel-tracing-allowlist "$ALLOWLIST" run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"not allowed for container test_busybox"* ]]
+
+	# no allowlist
+	runc --kernel-tracing-allowlist /nonexistent run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"allowlist /nonexistent not found"* ]]
+}
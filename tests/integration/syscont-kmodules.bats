@@ -0,0 +1,67 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_running_container test_kmodules
+	teardown_busybox
+}
+
+@test "syscont: kernel modules empty view" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.kernel-modules": "none"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_kmodules
+	[ "$status" -eq 0 ]
+
+	runc exec test_kmodules cat /proc/modules
+	[ "$status" -eq 0 ]
+	[ -z "$output" ]
+
+	runc exec test_kmodules ls /sys/module
+	[ "$status" -eq 0 ]
+	[ -z "$output" ]
+
+	# the view is read-only
+	runc exec test_kmodules mkdir /sys/module/foo
+	[ "$status" -ne 0 ]
+}
+
+@test "syscont: kernel modules filtered view" {
+
+	# pick a module loaded in the host
+	mod=$(awk 'NR==1 {print $1}' /proc/modules)
+	[ -n "$mod" ] || skip "no kernel modules loaded"
+
+	update_config '.annotations += {"io.nestybox.sysbox.kernel-modules": "'"$mod"',no_such_module"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_kmodules
+	[ "$status" -eq 0 ]
+
+	runc exec test_kmodules cat /proc/modules
+	[ "$status" -eq 0 ]
+	[ "${#lines[@]}" -eq 1 ]
+	[[ "${lines[0]}" == "$mod "* ]]
+
+	runc exec test_kmodules ls /sys/module
+	[ "$status" -eq 0 ]
+	[[ "$output" == "$mod" ]]
+
+	runc exec test_kmodules ls /sys/module/$mod
+	[ "$status" -eq 0 ]
+	[ -n "$output" ]
+}
+
+@test "syscont: kernel modules annotation" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.kernel-modules": "../overlay"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_kmodules
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"io.nestybox.sysbox.kernel-modules: invalid module name"* ]]
+}
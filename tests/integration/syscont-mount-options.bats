@@ -0,0 +1,83 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_running_container test_mntopts
+	teardown_busybox
+	rm -rf /tmp/mntopts
+}
+
+# kernel_ge <major> <minor>: the kernel is at least the given version
+function kernel_ge() {
+	local major minor
+	IFS=. read -r major minor _ <<<"$(uname -r)"
+	[ "$major" -gt "$1" ] || { [ "$major" -eq "$1" ] && [ "$minor" -ge "$2" ]; }
+}
+
+@test "syscont: mount option nosymfollow" {
+	kernel_ge 5 10 || skip "needs Linux 5.10+"
+
+	update_config '.mounts += [{"destination": "/mnt", "type": "tmpfs", "source": "tmpfs", "options": ["nosymfollow", "noatime"]}]'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_mntopts
+	[ "$status" -eq 0 ]
+
+	runc exec test_mntopts sh -c "grep ' /mnt ' /proc/self/mountinfo"
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"nosymfollow"* ]]
+	[[ "$output" == *"noatime"* ]]
+
+	# symlinks are not followed
+	runc exec test_mntopts sh -c "echo foo > /mnt/f && ln -s /mnt/f /mnt/l && cat /mnt/l"
+	[ "$status" -ne 0 ]
+}
+
+@test "syscont: recursive mount options" {
+	kernel_ge 5 12 || skip "needs Linux 5.12+"
+
+	mkdir -p /tmp/mntopts/sub
+	mount -t tmpfs tmpfs /tmp/mntopts/sub
+
+	update_config '.mounts += [{"destination": "/mnt", "type": "bind", "source": "/tmp/mntopts", "options": ["rbind", "rro", "rnosuid"]}]'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_mntopts
+	umount /tmp/mntopts/sub
+	[ "$status" -eq 0 ]
+
+	# read-only, including the submount
+	runc exec test_mntopts touch /mnt/f
+	[ "$status" -ne 0 ]
+	runc exec test_mntopts touch /mnt/sub/f
+	[ "$status" -ne 0 ]
+
+	runc exec test_mntopts sh -c "grep ' /mnt/sub ' /proc/self/mountinfo"
+	[ "$status" -eq 0 ]
+	[[ "$output" == *" ro,nosuid"* ]]
+
+	# and on a filesystem mount
+	teardown_running_container test_mntopts
+	update_config '.mounts |= map(if .destination == "/mnt" then {"destination": "/mnt", "type": "tmpfs", "source": "tmpfs", "options": ["rnoexec", "rnoatime", "size=1m"]} else . end)'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_mntopts
+	[ "$status" -eq 0 ]
+
+	runc exec test_mntopts sh -c "grep ' /mnt ' /proc/self/mountinfo"
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"noexec"* ]]
+	[[ "$output" == *"noatime"* ]]
+}
+
+@test "syscont: unsupported mount options" {
+
+	update_config '.mounts += [{"destination": "/mnt", "type": "tmpfs", "source": "tmpfs", "options": ["rro", "remount"]}]'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_mntopts
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"recursive mount options on /mnt can't be combined with remount"* ]]
+}
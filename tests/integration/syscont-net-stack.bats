@@ -0,0 +1,39 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "syscont: dual-stack network" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.net-stack": "dual-stack"}
+		| .linux.sysctl += {"net.ipv6.conf.default.accept_ra": "1"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox cat /proc/sys/net/ipv6/conf/all/forwarding /proc/sys/net/ipv4/ip_forward \
+		/proc/sys/net/ipv6/conf/all/accept_ra /proc/sys/net/ipv6/conf/default/accept_ra
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" == "1" ]]
+	[[ "${lines[1]}" == "1" ]]
+	[[ "${lines[2]}" == "2" ]]
+	# the spec's sysctls take precedence
+	[[ "${lines[3]}" == "1" ]]
+}
+
+@test "syscont: invalid network stack" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.net-stack": "ipv6-only"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"must be \"default\" or \"dual-stack\""* ]]
+}
@@ -0,0 +1,57 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+	ip link add sbxtest0 type dummy
+	ip link set sbxtest0 up
+}
+
+function teardown() {
+	teardown_busybox
+	ip link del sbxtest0 || true
+}
+
+@test "syscont: macvlan interface" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.networks":
+		"[{\"type\": \"macvlan\", \"name\": \"eth0\", \"parent\": \"sbxtest0\", \"mac_address\": \"02:42:ac:11:00:32\", \"address\": \"192.168.250.50/24\", \"gateway\": \"192.168.250.1\", \"routes\": [{\"destination\": \"10.250.0.0/16\"}]}]"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox ip addr show eth0
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"02:42:ac:11:00:32"* ]]
+	[[ "${output}" == *"inet 192.168.250.50/24"* ]]
+
+	runc exec test_busybox ip route
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"default via 192.168.250.1 dev eth0"* ]]
+	[[ "${output}" == *"10.250.0.0/16 dev eth0"* ]]
+}
+
+@test "syscont: ipvlan interface" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.networks":
+		"[{\"type\": \"ipvlan\", \"name\": \"eth0\", \"parent\": \"sbxtest0\", \"mode\": \"l3\", \"address\": \"192.168.251.50/24\"}]"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox ip addr show eth0
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"inet 192.168.251.50/24"* ]]
+}
+
+@test "syscont: invalid network interface" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.networks":
+		"[{\"type\": \"macvlan\", \"name\": \"eth0\"}]"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"require a name and a parent"* ]]
+}
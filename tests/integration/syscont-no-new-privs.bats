@@ -0,0 +1,83 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "syscont: no-new-privileges preserved by default" {
+
+	update_config '.process.noNewPrivileges = true'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox grep NoNewPrivs /proc/1/status
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"1"* ]]
+}
+
+@test "syscont: no-new-privileges cleared" {
+
+	update_config '.process.noNewPrivileges = true
+		| .annotations += {"io.nestybox.sysbox.no-new-privileges": "clear"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox grep NoNewPrivs /proc/1/status
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"0"* ]]
+
+	runc exec test_busybox grep NoNewPrivs /proc/self/status
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"0"* ]]
+
+	# an explicit --no-new-privs overrides the policy
+	runc exec --no-new-privs test_busybox grep NoNewPrivs /proc/self/status
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"1"* ]]
+}
+
+@test "syscont: no-new-privileges set" {
+
+	update_config '.process.noNewPrivileges = false
+		| .annotations += {"io.nestybox.sysbox.no-new-privileges": "set"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox grep NoNewPrivs /proc/1/status
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"1"* ]]
+
+	runc exec test_busybox grep NoNewPrivs /proc/self/status
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"1"* ]]
+
+	runc exec --no-new-privs=false test_busybox grep NoNewPrivs /proc/self/status
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"0"* ]]
+}
+
+# A non-root process without CAP_SYS_ADMIN and without no-new-privileges
+# gets its seccomp filters before dropping its caps
+@test "syscont: non-root process without no-new-privileges" {
+
+	update_config '.process.noNewPrivileges = false
+		| .process.user = {"uid": 1000, "gid": 1000}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox grep -E "NoNewPrivs|Seccomp:" /proc/self/status
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"NoNewPrivs:"*"0"* ]]
+	[[ "${output}" == *"Seccomp:"*"2"* ]]
+}
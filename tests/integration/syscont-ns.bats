@@ -8,6 +8,7 @@ function setup() {
 }
 
 function teardown() {
+	teardown_running_container test_peer
 	teardown_busybox
 }
 
@@ -39,3 +40,60 @@ function teardown() {
 
 	# TODO: test that nsenter(2) also works
 }
+
+@test "syscont: share ipc and uts ns with a peer" {
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox hostname peer-host
+	[ "$status" -eq 0 ]
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" --share-ipc test_busybox --share-uts test_busybox test_peer
+	[ "$status" -eq 0 ]
+
+	# the peer shares the ipc, uts and user namespaces of test_busybox
+	for nsType in ipc uts user; do
+		runc exec test_busybox readlink /proc/1/ns/$nsType
+		[ "$status" -eq 0 ]
+		ns1="$output"
+
+		runc exec test_peer readlink /proc/1/ns/$nsType
+		[ "$status" -eq 0 ]
+		[ "$output" == "$ns1" ]
+	done
+
+	runc exec test_peer hostname
+	[ "$status" -eq 0 ]
+	[[ "$output" == "peer-host" ]]
+}
+
+@test "syscont: share ns with a missing peer" {
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" --share-ipc no_such_container test_busybox
+	[ "$status" -ne 0 ]
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" --share-uts test_busybox test_busybox
+	[ "$status" -ne 0 ]
+}
+
+@test "syscont: share cgroup ns with a peer outside its cgroup" {
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	# the peer's cgroup ns is rooted at its cgroup, which doesn't contain the
+	# new container's cgroup
+	runc run -d --console-socket "$CONSOLE_SOCKET" --share-cgroup test_busybox test_peer
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"outside the root of the cgroup namespace"* ]]
+}
+
+@test "syscont: join the host's cgroup ns" {
+
+	update_config '.linux.namespaces |= map(select(.type != "cgroup")) + [{"type": "cgroup", "path": "/proc/self/ns/cgroup"}]'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"host's cgroup namespace"* ]]
+}
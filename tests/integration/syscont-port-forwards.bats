@@ -0,0 +1,37 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "syscont: port forwarding" {
+
+	update_config '.process.args = ["sh", "-c", "while true; do echo hello | nc -l -p 80 127.0.0.1; done"]
+		| .annotations += {"io.nestybox.sysbox.port-forwards": "127.0.0.1:18080:80"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	retry 10 1 eval "nc -w 2 127.0.0.1 18080 </dev/null | grep -q hello"
+
+	# the proxy exits along with the container
+	runc delete --force test_busybox
+	[ "$status" -eq 0 ]
+	retry 10 1 eval "! nc -z 127.0.0.1 18080"
+}
+
+@test "syscont: invalid port forwarding" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.port-forwards": "18080:80/udp"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"only tcp is"* ]]
+}
@@ -0,0 +1,32 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "syscont: k8s-node preset" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.preset": "k8s-node"}'
+
+	# the preset's non-namespaced sysctls are set by sysbox-fs (disabled in
+	# these tests; its default client can't set them anyway)
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"the non-namespaced sysctls of the presets require sysbox-fs"* ]]
+}
+
+@test "syscont: invalid preset" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.preset": "k8s-node,k8s-master"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"unknown preset \"k8s-master\""* ]]
+}
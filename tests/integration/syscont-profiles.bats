@@ -0,0 +1,115 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_running_container test_profile
+	teardown_busybox
+}
+
+function run_profile() {
+	update_config '.annotations += {"io.nestybox.sysbox.profile": "'"$1"'"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_profile
+	[ "$status" -eq 0 ]
+}
+
+function mount_type() {
+	runc exec test_profile sh -c "awk '\$2 == \"$1\" { print \$3 }' /proc/mounts | tail -1"
+}
+
+function check_common() {
+	runc exec test_profile cat /proc/sys/net/ipv4/ip_forward
+	[ "$status" -eq 0 ]
+	[[ "$output" == "1" ]]
+
+	if [ -d /lib/modules ]; then
+		runc exec test_profile sh -c "grep ' /lib/modules ' /proc/mounts"
+		[ "$status" -eq 0 ]
+		[[ "$output" == *" ro,"* ]]
+	fi
+}
+
+@test "syscont: kind profile" {
+
+	run_profile kind
+	check_common
+
+	# systemd support, though the init process is not /sbin/init
+	mount_type /run
+	[[ "$output" == "tmpfs" ]]
+
+	runc exec test_profile sh -c "tr '\0' '\n' < /proc/1/environ"
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"container=private-users"* ]]
+}
+
+@test "syscont: minikube profile" {
+
+	run_profile minikube
+	check_common
+
+	mount_type /run
+	[[ "$output" == "tmpfs" ]]
+}
+
+@test "syscont: k3d profile" {
+
+	run_profile k3d
+	check_common
+
+	mount_type /run
+	[[ "$output" == "tmpfs" ]]
+
+	runc exec test_profile sh -c "tr '\0' '\n' < /proc/1/environ"
+	[ "$status" -eq 0 ]
+	[[ "$output" != *"container=private-users"* ]]
+}
+
+@test "syscont: dind profile" {
+
+	run_profile dind
+	check_common
+}
+
+@test "syscont: gitlab-runner profile" {
+
+	update_config '.linux.resources.cpu = {"quota": 100000, "period": 100000}'
+
+	run_profile gitlab-runner
+	check_common
+
+	if [ -c /dev/fuse ]; then
+		runc exec test_profile test -c /dev/fuse
+		[ "$status" -eq 0 ]
+	fi
+
+	runc exec test_profile sh -c "tr '\0' '\n' < /proc/1/environ"
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"GOMAXPROCS=1"* ]]
+}
+
+@test "syscont: profile defaults don't override the spec" {
+
+	update_config '.linux.sysctl = {"net.ipv4.ip_forward": "0"}'
+
+	run_profile dind
+
+	runc exec test_profile cat /proc/sys/net/ipv4/ip_forward
+	[ "$status" -eq 0 ]
+	[[ "$output" == "0" ]]
+}
+
+@test "syscont: invalid profile" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.profile": "k8s"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_profile
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"io.nestybox.sysbox.profile"* ]]
+}
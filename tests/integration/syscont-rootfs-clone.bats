@@ -0,0 +1,85 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_running_container test_clone1
+	teardown_running_container test_clone2
+	teardown_busybox
+}
+
+@test "syscont: rootfs clone" {
+
+	for c in test_clone1 test_clone2; do
+		runc run -d --console-socket "$CONSOLE_SOCKET" --rootfs-clone $c
+		[ "$status" -eq 0 ]
+
+		runc exec $c sh -c "echo $c > /clone"
+		[ "$status" -eq 0 ]
+	done
+
+	# each clone sees its own changes; the bundle's rootfs is untouched
+	for c in test_clone1 test_clone2; do
+		runc exec $c cat /clone
+		[ "$status" -eq 0 ]
+		[[ "$output" == "$c" ]]
+	done
+
+	[ ! -e "$BUSYBOX_BUNDLE/rootfs/clone" ]
+
+	# the clone is removed with the container
+	runc delete -f test_clone1
+	[ "$status" -eq 0 ]
+	[ ! -e /var/lib/sysbox-runc/rootfs-clone/test_clone1 ]
+}
+
+@test "syscont: rootfs upper dir" {
+
+	upper=$(mktemp -d /var/tmp/sysbox-upper.XXXXXX)
+	update_config '.annotations += {"io.nestybox.sysbox.upper-dir": "'"$upper"'"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_clone1
+	[ "$status" -eq 0 ]
+
+	runc exec test_clone1 sh -c "echo data > /upper"
+	[ "$status" -eq 0 ]
+
+	# the container's writes land in the upper dir, not in the bundle's rootfs
+	[ -e "$upper/test_clone1/upper/upper" ]
+	[ ! -e "$BUSYBOX_BUNDLE/rootfs/upper" ]
+
+	runc delete -f test_clone1
+	[ "$status" -eq 0 ]
+	[ ! -e "$upper/test_clone1" ]
+
+	rmdir "$upper"
+}
+
+@test "syscont: pre-mounted rootfs" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.rootfs-premounted": "true"}'
+
+	# the rootfs must be a mount point
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_clone1
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"is not a mount point"* ]]
+
+	# and be ID-mapped to the container's user-ID range; the error reports the
+	# mismatching ownership
+	mount --bind "$BUSYBOX_BUNDLE/rootfs" "$BUSYBOX_BUNDLE/rootfs"
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_clone1
+	umount "$BUSYBOX_BUNDLE/rootfs"
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"does not match the container's ID mappings"* ]]
+	[[ "$output" == *"$BUSYBOX_BUNDLE/rootfs: owner 0:0, expected"* ]]
+
+	# a pre-mounted rootfs can't be cloned
+	runc run -d --console-socket "$CONSOLE_SOCKET" --rootfs-clone test_clone1
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"not supported with a pre-mounted rootfs"* ]]
+}
@@ -0,0 +1,67 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "syscont: process scheduler and I/O priority" {
+
+	update_config '.process.scheduler = {"policy": "SCHED_RR", "priority": 10}
+		| .process.ioPriority = {"class": "IOPRIO_CLASS_BE", "priority": 1}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	pid=$(__runc state test_busybox | jq '.pid')
+
+	run chrt -p "$pid"
+	[ "$status" -eq 0 ]
+	[[ "${output}" == *"SCHED_RR"* ]]
+	[[ "${output}" == *"priority: 10"* ]]
+
+	run ionice -p "$pid"
+	[ "$status" -eq 0 ]
+	[[ "${output}" == "best-effort: prio 1" ]]
+}
+
+@test "syscont: exec process scheduler overrides the container's" {
+
+	update_config '.process.scheduler = {"policy": "SCHED_OTHER", "nice": 5}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	# the nice value is field 19 of /proc/<pid>/stat
+	runc exec test_busybox sh -c 'cut -d" " -f19 /proc/self/stat'
+	[ "$status" -eq 0 ]
+	[[ "${output}" == "5" ]]
+
+	cat > "$BATS_TMPDIR/process.json" <<-EOF
+	{
+		"args": ["sh", "-c", "cut -d' ' -f19 /proc/self/stat"],
+		"cwd": "/",
+		"user": {"uid": 0, "gid": 0},
+		"scheduler": {"policy": "SCHED_BATCH", "nice": -3}
+	}
+	EOF
+
+	runc exec --process "$BATS_TMPDIR/process.json" test_busybox
+	[ "$status" -eq 0 ]
+	[[ "${output}" == "-3" ]]
+}
+
+@test "syscont: invalid process scheduler" {
+
+	update_config '.process.scheduler = {"policy": "SCHED_FIFO"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"invalid scheduler priority"* ]]
+}
@@ -0,0 +1,39 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "syscont: signal map translates forwarded signals" {
+
+	update_config '.process.terminal = false
+		| .process.args = ["sh", "-c", "trap \"echo got-usr1; exit 0\" USR1; while true; do sleep 0.1; done"]
+		| .annotations += {"io.nestybox.sysbox.signal-map": "SIGTERM=SIGUSR1"}'
+
+	__runc run test_busybox >"$BATS_TMPDIR/signal-map.out" 2>&1 &
+	runc_pid=$!
+
+	wait_for_container 15 1 test_busybox running
+
+	kill -TERM "$runc_pid"
+	wait "$runc_pid"
+
+	run cat "$BATS_TMPDIR/signal-map.out"
+	[[ "${output}" == *"got-usr1"* ]]
+}
+
+@test "syscont: invalid signal map" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.signal-map": "SIGTERM=SIGFOO"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"unknown signal"* ]]
+}
@@ -0,0 +1,67 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+
+	SSH_KEYS=$(mktemp -d)
+	echo "private" >"$SSH_KEYS/ssh_host_ed25519_key"
+	echo "public" >"$SSH_KEYS/ssh_host_ed25519_key.pub"
+	echo "ssh-ed25519 AAAA test" >"$SSH_KEYS/authorized_keys"
+}
+
+function teardown() {
+	teardown_running_container test_sshkeys
+	teardown_busybox
+	rm -rf "$SSH_KEYS"
+}
+
+@test "syscont: ssh keys copied" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.ssh-host-keys": "'"$SSH_KEYS"'"}
+		| .annotations += {"io.nestybox.sysbox.ssh-authorized-keys": "'"$SSH_KEYS"'/authorized_keys:root"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_sshkeys
+	[ "$status" -eq 0 ]
+
+	runc exec test_sshkeys cat /etc/ssh/ssh_host_ed25519_key
+	[ "$status" -eq 0 ]
+	[[ "$output" == "private" ]]
+
+	# owned by the container's root, whatever its host uid
+	runc exec test_sshkeys stat -c "%u:%g %a" /etc/ssh/ssh_host_ed25519_key
+	[ "$status" -eq 0 ]
+	[[ "$output" == "0:0 600" ]]
+
+	runc exec test_sshkeys stat -c "%u:%g %a" /etc/ssh/ssh_host_ed25519_key.pub
+	[ "$status" -eq 0 ]
+	[[ "$output" == "0:0 644" ]]
+
+	runc exec test_sshkeys cat /root/.ssh/authorized_keys
+	[ "$status" -eq 0 ]
+	[[ "$output" == "ssh-ed25519 AAAA test" ]]
+
+	runc exec test_sshkeys stat -c "%u:%g %a" /root/.ssh /root/.ssh/authorized_keys
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" == "0:0 700" ]]
+	[[ "${lines[1]}" == "0:0 600" ]]
+}
+
+@test "syscont: ssh keys errors" {
+
+	# unknown user
+	update_config '.annotations += {"io.nestybox.sysbox.ssh-authorized-keys": "'"$SSH_KEYS"'/authorized_keys:nosuchuser"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_sshkeys
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"user not found"* ]]
+
+	# relative path
+	update_config '.annotations["io.nestybox.sysbox.ssh-authorized-keys"] = "authorized_keys"'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_sshkeys
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"io.nestybox.sysbox.ssh-authorized-keys: \"authorized_keys\" is not an absolute path"* ]]
+}
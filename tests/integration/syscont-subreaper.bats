@@ -0,0 +1,44 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+	LOG=$(mktemp /tmp/sysbox-runc-subreaper.XXXXXX)
+}
+
+function teardown() {
+	teardown_running_container test_subreaper
+	teardown_busybox
+	rm -f "$LOG"
+}
+
+# the prestart hook leaves an orphan that exits while the container is created
+function add_orphan_hook() {
+	update_config '.hooks |= . + {"prestart": [{"path": "/bin/sh", "args": ["/bin/sh", "-c", "(sleep 0.1 >/dev/null 2>&1 &); sleep 0.5"]}]}'
+}
+
+@test "syscont: subreaper reaps orphans" {
+
+	add_orphan_hook
+
+	runc --subreaper --debug --log "$LOG" run -d --console-socket "$CONSOLE_SOCKET" test_subreaper
+	[ "$status" -eq 0 ]
+
+	testcontainer test_subreaper running
+
+	grep -q "reaped orphaned process" "$LOG"
+}
+
+@test "syscont: orphans not reaped without subreaper" {
+
+	add_orphan_hook
+
+	runc --debug --log "$LOG" run -d --console-socket "$CONSOLE_SOCKET" test_subreaper
+	[ "$status" -eq 0 ]
+
+	testcontainer test_subreaper running
+
+	! grep -q "reaped orphaned process" "$LOG"
+}
@@ -0,0 +1,49 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_running_container test_syskernel
+	teardown_busybox
+}
+
+@test "syscont: sys kernel dummy mounts" {
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_syskernel
+	[ "$status" -eq 0 ]
+
+	for dir in config debug tracing; do
+		runc exec test_syskernel sh -c "mount | grep \"on /sys/kernel/$dir type tmpfs\""
+		[ "$status" -eq 0 ]
+	done
+}
+
+@test "syscont: sys kernel dummy mounts selected" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.sys-kernel-mounts": "debug"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_syskernel
+	[ "$status" -eq 0 ]
+
+	runc exec test_syskernel sh -c "mount | grep \"on /sys/kernel/debug type tmpfs\""
+	[ "$status" -eq 0 ]
+
+	for dir in config tracing; do
+		runc exec test_syskernel sh -c "mount | grep \"on /sys/kernel/$dir type tmpfs\""
+		[ "$status" -ne 0 ]
+	done
+}
+
+@test "syscont: sys kernel dummy mounts invalid" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.sys-kernel-mounts": "securityfs"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_syskernel
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"sys-kernel-mounts"* ]]
+}
@@ -0,0 +1,48 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+	HOOKS_DIR=$(mktemp -d /tmp/sysbox-runc-hooks.XXXXXX)
+	HOOKS_LOG="$HOOKS_DIR/log"
+}
+
+function teardown() {
+	teardown_running_container test_sysbox_hooks
+	teardown_busybox
+	rm -rf "$HOOKS_DIR"
+}
+
+@test "syscont: sysbox poststop hooks run on delete with the sysbox state" {
+
+	mkdir -p "$HOOKS_DIR/poststop"
+	printf '#!/bin/sh\ncat >>%s\n' "$HOOKS_LOG" >"$HOOKS_DIR/poststop/10-log"
+	chmod +x "$HOOKS_DIR/poststop/10-log"
+
+	runc --sysbox-hooks-dir "$HOOKS_DIR" run -d --console-socket "$CONSOLE_SOCKET" test_sysbox_hooks
+	[ "$status" -eq 0 ]
+
+	testcontainer test_sysbox_hooks running
+
+	runc delete --force test_sysbox_hooks
+	[ "$status" -eq 0 ]
+
+	run grep -c '"id":"test_sysbox_hooks".*"sysbox":{' "$HOOKS_LOG"
+	[ "$status" -eq 0 ]
+	[[ "$output" == "1" ]]
+}
+
+@test "syscont: sysbox poststop hooks aren't taken from the spec" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.poststop-hooks": "[{\"path\": \"/bin/touch\", \"args\": [\"touch\", \"'"$HOOKS_LOG"'\"]}]"}'
+
+	runc --sysbox-hooks-dir "$HOOKS_DIR" run -d --console-socket "$CONSOLE_SOCKET" test_sysbox_hooks
+	[ "$status" -eq 0 ]
+
+	runc delete --force test_sysbox_hooks
+	[ "$status" -eq 0 ]
+
+	[ ! -e "$HOOKS_LOG" ]
+}
@@ -31,3 +31,45 @@ function teardown() {
 	runc exec test_busybox sh -c "rmdir /root/test"
 	[ "$status" -eq 0 ]
 }
+
+@test "syscont: syscall: clock_settime policy" {
+	# the default policy denies setting the system clock with EPERM
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox sh -c 'date -s "$(date "+%Y-%m-%d %H:%M:%S")"'
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"Operation not permitted"* ]]
+
+	runc delete -f test_busybox
+	[ "$status" -eq 0 ]
+
+	# emulation requires sysbox-fs (disabled in these tests)
+	update_config '.annotations += {"io.nestybox.sysbox.clock-settime": "emulate"}' "$BUSYBOX_BUNDLE"
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"requires sysbox-fs"* ]]
+
+	update_config '.annotations += {"io.nestybox.sysbox.clock-settime": "allow"}' "$BUSYBOX_BUNDLE"
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+}
+
+@test "syscont: syscall: mount fast path" {
+	# without sysbox-fs (disabled in these tests), nothing is trapped, so the
+	# fast path has no effect; bind mounts and propagation changes work either way
+	update_config '.annotations += {"io.nestybox.sysbox.mount-fast-path": "bind,propagation"}' "$BUSYBOX_BUNDLE"
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox sh -c "mkdir /root/test && mount --bind /root/test /mnt && mount --make-private /mnt"
+	[ "$status" -eq 0 ]
+
+	runc delete -f test_busybox
+	[ "$status" -eq 0 ]
+
+	update_config '.annotations += {"io.nestybox.sysbox.mount-fast-path": "overlay"}' "$BUSYBOX_BUNDLE"
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"mount-fast-path"* ]]
+}
@@ -0,0 +1,40 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "syscont: uid shift backends" {
+
+	runc run -d --uid-shift chown --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc state test_busybox
+	[ "$status" -eq 0 ]
+
+	# the rootfs isn't shifted via shiftfs, even if supported
+	echo "$output" | jq -e '.uidShift | map(select(.destination == "/")) | .[0].method | IN("none", "chown")'
+}
+
+@test "syscont: uid shift backend disabled in the host" {
+
+	runc --uid-shift-backends shiftfs,chown run -d --uid-shift idmapped-mount --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"uid shifting backend \"idmapped-mount\" is disabled in this host"* ]]
+}
+
+@test "syscont: invalid uid shift backend" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.uid-shift-backends": "idmap"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"invalid uid shifting backend \"idmap\""* ]]
+}
@@ -0,0 +1,30 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+	BIND_SRC="$(mktemp -d)"
+}
+
+function teardown() {
+	teardown_busybox
+	rm -rf "$BIND_SRC"
+}
+
+@test "syscont: uid shift report" {
+
+	update_config '.mounts += [{"source": "'"$BIND_SRC"'", "destination": "/mnt/bind", "type": "bind", "options": ["rbind"]}]'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc state test_busybox
+	[ "$status" -eq 0 ]
+
+	# the rootfs and the bind mount each have a decision
+	echo "$output" | jq -e '.uidShift | map(select(.destination == "/")) | length == 1'
+	echo "$output" | jq -e '.uidShift | map(select(.destination == "/mnt/bind")) | length == 1'
+	echo "$output" | jq -e '.uidShift | all(.method | IN("none", "shiftfs", "idmapped-mount", "fuse", "chown"))'
+}
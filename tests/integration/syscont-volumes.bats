@@ -0,0 +1,31 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "syscont: invalid volume" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.volumes": "/data:lots"}'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"invalid size \"lots\" of volume /data"* ]]
+}
+
+@test "syscont: volume over a spec mount" {
+
+	update_config '.annotations += {"io.nestybox.sysbox.volumes": "/data:1G"}
+		| .mounts += [{"destination": "/data", "type": "tmpfs", "source": "tmpfs", "options": ["size=1m"]}]'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -ne 0 ]
+	[[ "${output}" == *"the spec already has a mount at /data"* ]]
+}
@@ -143,6 +143,22 @@ function teardown() {
 	[[ ${lines[1]} =~ 5 ]]
 }
 
+@test "runc exec -d [tty owner] (mapped uid)" {
+	requires root
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	testcontainer test_busybox running
+
+	runc exec -d -t --user 1000:100 --pid-file pid.txt --console-socket "$CONSOLE_SOCKET" test_busybox sleep 60
+	[ "$status" -eq 0 ]
+
+	# the console is owned by the host uid the process' user maps to
+	owner=$(stat -L -c %u "/proc/$(cat pid.txt)/fd/0")
+	[ "$owner" -eq $((UID_MAP + 1000)) ]
+}
+
 @test "runc exec [tty consolesize]" {
 	# allow writing to filesystem
 	update_config '(.. | select(.readonly? != null)) .readonly |= false'
@@ -0,0 +1,63 @@
+#!/usr/bin/env bats
+
+load helpers
+
+function setup() {
+	teardown_busybox
+	setup_busybox
+}
+
+function teardown() {
+	teardown_busybox
+}
+
+@test "umount bind mount" {
+	mkdir -p /mnt/test-dir
+	touch /mnt/test-dir/test-file
+
+	update_config ' .mounts |= . + [{
+												 source: "/mnt/test-dir",
+												 destination: "/mnt/test-dir",
+												 options: ["bind"]
+											 }]'
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox ls /mnt/test-dir/test-file
+	[ "$status" -eq 0 ]
+
+	runc umount test_busybox /mnt/test-dir
+	[ "$status" -eq 0 ]
+
+	runc exec test_busybox ls /mnt/test-dir/test-file
+	[ "$status" -ne 0 ]
+
+	# the mount is no longer tracked by the runtime
+	runc umount test_busybox /mnt/test-dir
+	[ "$status" -ne 0 ]
+
+	runc kill test_busybox KILL
+	[ "$status" -eq 0 ]
+
+	retry 10 1 eval "__runc state test_busybox | grep -q 'stopped'"
+
+	runc delete test_busybox
+	[ "$status" -eq 0 ]
+
+	rm -rf /mnt/test-dir
+}
+
+@test "umount of sysbox mounts is rejected" {
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_busybox
+	[ "$status" -eq 0 ]
+
+	runc umount test_busybox /proc/sys
+	[ "$status" -ne 0 ]
+
+	runc umount test_busybox /sys
+	[ "$status" -ne 0 ]
+
+	runc umount test_busybox relative/path
+	[ "$status" -ne 0 ]
+}
@@ -399,6 +399,131 @@ EOF
 	check_systemd_value "TasksMax" 10
 }
 
+@test "update cgroup v2 memory QoS settings" {
+	[[ "$ROOTLESS" -ne 0 ]] && requires rootless_cgroup
+	requires cgroups_v2
+
+	update_config '.linux.resources.unified |= {"memory.high": "33554432", "memory.swap.max": "0"}' "${BUSYBOX_BUNDLE}"
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_update
+	[ "$status" -eq 0 ]
+
+	# the settings apply to the sys container's cgroup and its child cgroup
+	check_cgroup_value "memory.high" 33554432
+	check_cgroup_value "memory.swap.max" 0
+	[ "$(cat $CGROUP_PATH/init.scope/memory.high)" = "33554432" ]
+	[ "$(cat $CGROUP_PATH/init.scope/memory.swap.max)" = "0" ]
+
+	runc update test_update --memory-high 64M --memory-swap-max max
+	[ "$status" -eq 0 ]
+	check_cgroup_value "memory.high" 67108864
+	check_cgroup_value "memory.swap.max" max
+	[ "$(cat $CGROUP_PATH/init.scope/memory.high)" = "67108864" ]
+	[ "$(cat $CGROUP_PATH/init.scope/memory.swap.max)" = "max" ]
+
+	runc update -r - test_update <<EOF
+{
+  "unified": {
+    "memory.high": "max"
+  }
+}
+EOF
+	[ "$status" -eq 0 ]
+	check_cgroup_value "memory.high" max
+	[ "$(cat $CGROUP_PATH/init.scope/memory.high)" = "max" ]
+
+	runc update test_update --memory-high bogus
+	[ "$status" -ne 0 ]
+}
+
+@test "update blkio throttling" {
+	[[ "$ROOTLESS" -ne 0 ]] && requires rootless_cgroup
+
+	# pick a (non-virtual) block device
+	dev=""
+	for f in /sys/block/*/dev; do
+		case "$f" in
+		*/loop* | */ram* | */zram*) continue ;;
+		esac
+		dev=$(cat "$f")
+		break
+	done
+	[ -n "$dev" ] || skip "no block device"
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_update
+	[ "$status" -eq 0 ]
+
+	runc update test_update --blkio-read-bps "$dev:1048576" --blkio-write-iops "$dev:100"
+	[ "$status" -eq 0 ]
+
+	# the throttling applies to the sys container's cgroup and its child cgroup
+	if [ "$CGROUP_UNIFIED" = "yes" ]; then
+		for path in "$CGROUP_PATH" "$CGROUP_PATH/init.scope"; do
+			grep -q "^$dev rbps=1048576 wbps=max riops=max wiops=100$" "$path/io.max"
+		done
+	else
+		for path in "$CGROUP_BLKIO" "$CGROUP_BLKIO/syscont-cgroup-root"; do
+			grep -q "^$dev 1048576$" "$path/blkio.throttle.read_bps_device"
+			grep -q "^$dev 100$" "$path/blkio.throttle.write_iops_device"
+		done
+	fi
+
+	# a zero rate removes the limit
+	runc update test_update --blkio-read-bps "$dev:0" --blkio-write-iops "$dev:0"
+	[ "$status" -eq 0 ]
+
+	if [ "$CGROUP_UNIFIED" = "yes" ]; then
+		! grep -q "^$dev " "$CGROUP_PATH/io.max"
+		! grep -q "^$dev " "$CGROUP_PATH/init.scope/io.max"
+	else
+		! grep -q "^$dev " "$CGROUP_BLKIO/syscont-cgroup-root/blkio.throttle.read_bps_device"
+		! grep -q "^$dev " "$CGROUP_BLKIO/syscont-cgroup-root/blkio.throttle.write_iops_device"
+	fi
+
+	runc update test_update --blkio-read-bps "bogus"
+	[ "$status" -ne 0 ]
+}
+
+@test "update cpu burst and idle" {
+	[[ "$ROOTLESS" -ne 0 ]] && requires rootless_cgroup
+
+	runc run -d --console-socket "$CONSOLE_SOCKET" test_update
+	[ "$status" -eq 0 ]
+
+	if [ "$CGROUP_UNIFIED" = "yes" ]; then
+		paths="$CGROUP_PATH $CGROUP_PATH/init.scope"
+		burst_file="cpu.max.burst"
+	else
+		paths="$CGROUP_CPU $CGROUP_CPU/syscont-cgroup-root"
+		burst_file="cpu.cfs_burst_us"
+	fi
+	[ -e "${paths%% *}/$burst_file" ] || skip "cpu burst not supported by the kernel"
+
+	# the settings apply to the sys container's cgroup and its child cgroup
+	runc update test_update --cpu-burst 20000
+	[ "$status" -eq 0 ]
+	for path in $paths; do
+		[ "$(cat "$path/$burst_file")" = "20000" ]
+	done
+
+	if [ -e "${paths%% *}/cpu.idle" ]; then
+		runc update test_update --cpu-idle 1
+		[ "$status" -eq 0 ]
+		for path in $paths; do
+			[ "$(cat "$path/cpu.idle")" = "1" ]
+		done
+
+		runc update test_update --cpu-idle 0
+		[ "$status" -eq 0 ]
+		for path in $paths; do
+			[ "$(cat "$path/cpu.idle")" = "0" ]
+		done
+	fi
+
+	runc update test_update --cpu-idle 2
+	[ "$status" -ne 0 ]
+}
+
 @test "update cpuset parameters via resources.CPU" {
 	[[ "$ROOTLESS" -ne 0 ]] && requires rootless_cgroup
 	requires smp
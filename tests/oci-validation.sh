@@ -0,0 +1,238 @@
+#!/bin/bash
+#
+# Copyright 2019-2020 Nestybox, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    https://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+
+# oci-validation.sh -- Runs the OCI runtime validation suite (from
+# opencontainers/runtime-tools) against sysbox-runc, and checks its results
+# against the list of expected deviations (tests/oci-validation.deviations).
+#
+# sysbox-runc deviates from the OCI runtime spec on purpose in a few places
+# (e.g., it always uses the user and cgroup namespaces, and gives root in the
+# container all capabilities). Each such deviation must be listed, with its
+# reason, in the deviations file. The script fails if a test fails without
+# being listed, so that new non-conformances are caught; tests listed that
+# pass are reported as stale deviations (and fail the script with --strict).
+#
+# Usage: oci-validation.sh [-s] [-o <report>] [-d <deviations>] [<test> ...]
+#
+#   -s  strict: stale deviations are an error
+#   -o  write a report of every test result (as TSV) to the given file
+#   -d  use the given deviations file
+#
+# Without tests given, all the suite's tests are run.
+#
+# Environment:
+#
+#   RUNTIME_TOOLS_DIR   runtime-tools source dir (cloned and built if unset)
+#   RUNTIME_TOOLS_REF   runtime-tools version cloned (if RUNTIME_TOOLS_DIR is unset)
+#   SYSBOX_RUNC         sysbox-runc binary (defaults to the one built in the repo)
+#   SYSBOX_RUNC_FLAGS   global flags passed to sysbox-runc (e.g., "--no-sysbox-fs")
+
+ROOT="$(readlink -f "$(dirname "${BASH_SOURCE}")/..")"
+
+RUNTIME_TOOLS_REPO="https://github.com/opencontainers/runtime-tools.git"
+RUNTIME_TOOLS_REF="${RUNTIME_TOOLS_REF:-v0.9.0}"
+SYSBOX_RUNC="${SYSBOX_RUNC:-${ROOT}/sysbox-runc}"
+
+deviations="${ROOT}/tests/oci-validation.deviations"
+report=""
+strict=0
+
+function usage() {
+	sed -n 's/^# \{0,1\}//; /^Usage:/,/^Environment:/p' "${BASH_SOURCE}" | sed '$d'
+	exit 2
+}
+
+while getopts "so:d:h" opt; do
+	case "$opt" in
+	s) strict=1 ;;
+	o) report="$(readlink -f "$OPTARG")" ;;
+	d) deviations="$(readlink -f "$OPTARG")" ;;
+	*) usage ;;
+	esac
+done
+shift $((OPTIND - 1))
+
+[ -x "$SYSBOX_RUNC" ] || {
+	echo "sysbox-runc binary $SYSBOX_RUNC not found (build it, or set SYSBOX_RUNC)" >&2
+	exit 2
+}
+[ -f "$deviations" ] || {
+	echo "deviations file $deviations not found" >&2
+	exit 2
+}
+
+workdir="$(mktemp -d)"
+trap 'rm -rf "$workdir"' EXIT
+
+# Get and build the runtime-tools validation suite.
+suite="runtime-tools $RUNTIME_TOOLS_DIR"
+if [ -z "$RUNTIME_TOOLS_DIR" ]; then
+	suite="runtime-tools $RUNTIME_TOOLS_REF"
+	RUNTIME_TOOLS_DIR="$workdir/runtime-tools"
+	git clone -q --depth 1 --branch "$RUNTIME_TOOLS_REF" "$RUNTIME_TOOLS_REPO" "$RUNTIME_TOOLS_DIR" || exit 2
+fi
+make -C "$RUNTIME_TOOLS_DIR" runtimetest validation-executables >/dev/null || {
+	echo "failed to build the runtime-tools validation suite in $RUNTIME_TOOLS_DIR" >&2
+	exit 2
+}
+
+# The suite invokes $RUNTIME with the OCI runtime CLI only; the wrapper adds
+# sysbox-runc's global flags (with its own state root, so that the tests'
+# containers don't mix with others).
+cat >"$workdir/runtime" <<EOF
+#!/bin/bash
+exec "$SYSBOX_RUNC" --root "$workdir/state" $SYSBOX_RUNC_FLAGS "\$@"
+EOF
+chmod +x "$workdir/runtime"
+
+# Load the deviations: one per line, as "<test glob>: <description glob>  # <reason>".
+dev_tests=()
+dev_patterns=()
+dev_reasons=()
+while IFS= read -r line; do
+	[[ "$line" =~ ^[[:space:]]*(#|$) ]] && continue
+	if ! [[ "$line" =~ ^([^:]+):[[:space:]]*(.*[^[:space:]])[[:space:]]+#[[:space:]]*(.+)$ ]]; then
+		echo "invalid deviation (must be \"<test glob>: <description glob>  # <reason>\"): $line" >&2
+		exit 2
+	fi
+	dev_tests+=("${BASH_REMATCH[1]}")
+	dev_patterns+=("${BASH_REMATCH[2]}")
+	dev_reasons+=("${BASH_REMATCH[3]}")
+done <"$deviations"
+
+# deviation <test> <description> -- prints the index of the deviation matching
+# the given test result, if any.
+function deviation() {
+	local i
+	for i in "${!dev_tests[@]}"; do
+		# shellcheck disable=SC2053
+		if [[ "$1" == ${dev_tests[$i]} && "$2" == ${dev_patterns[$i]} ]]; then
+			echo "$i"
+			return 0
+		fi
+	done
+	return 1
+}
+
+passed=0
+skipped=0
+expected=0
+failed=0
+stale=0
+failures=()
+stales=()
+
+[ -n "$report" ] && printf "test\tresult\tdescription\treason\n" >"$report"
+
+# record <test> <result> <description> [<reason>]
+function record() {
+	[ -n "$report" ] && printf "%s\t%s\t%s\t%s\n" "$1" "$2" "$3" "$4" >>"$report"
+}
+
+cd "$RUNTIME_TOOLS_DIR" || exit 2
+
+if [ $# -eq 0 ]; then
+	mapfile -t tests < <(find validation -name '*.t' | sort)
+else
+	tests=()
+	for t in "$@"; do
+		f="$(find validation -name "${t}.t" | head -1)"
+		[ -n "$f" ] || {
+			echo "no validation test named $t" >&2
+			exit 2
+		}
+		tests+=("$f")
+	done
+fi
+
+for t in "${tests[@]}"; do
+	name="$(basename "$t" .t)"
+	out="$(RUNTIME="$workdir/runtime" timeout 300 "$t" 2>&1)"
+	rc=$?
+
+	results=0
+	while IFS= read -r line; do
+		[[ "$line" =~ ^(not\ )?ok\ [0-9]+(\ -)?\ ?(.*)$ ]] || continue
+		results=$((results + 1))
+		notok="${BASH_REMATCH[1]}"
+		desc="${BASH_REMATCH[3]}"
+
+		if [[ "$desc" =~ \#\ (SKIP|skip) ]]; then
+			skipped=$((skipped + 1))
+			record "$name" skip "$desc"
+			continue
+		fi
+		desc="${desc%% # TODO*}"
+
+		if i="$(deviation "$name" "$desc")"; then
+			if [ -n "$notok" ]; then
+				expected=$((expected + 1))
+				record "$name" deviation "$desc" "${dev_reasons[$i]}"
+			else
+				stale=$((stale + 1))
+				stales+=("$name: $desc")
+				record "$name" stale "$desc" "${dev_reasons[$i]}"
+			fi
+		elif [ -n "$notok" ]; then
+			failed=$((failed + 1))
+			failures+=("$name: $desc")
+			record "$name" fail "$desc"
+		else
+			passed=$((passed + 1))
+			record "$name" pass "$desc"
+		fi
+	done <<<"$out"
+
+	# A test that exits with an error before reporting any result (e.g., as the
+	# container failed to start) counts as a single failure of its own.
+	if [ $results -eq 0 ] && [ $rc -ne 0 ]; then
+		desc="test failed to run (exit status $rc)"
+		if i="$(deviation "$name" "$desc")"; then
+			expected=$((expected + 1))
+			record "$name" deviation "$desc" "${dev_reasons[$i]}"
+		else
+			failed=$((failed + 1))
+			failures+=("$name: $desc")
+			record "$name" fail "$desc"
+			echo "--- $name output:" >&2
+			echo "$out" >&2
+		fi
+	fi
+done
+
+echo "OCI runtime validation of $("$SYSBOX_RUNC" --version | head -1) ($suite):"
+echo "  passed:              $passed"
+echo "  skipped:             $skipped"
+echo "  expected deviations: $expected"
+echo "  stale deviations:    $stale"
+echo "  unexpected failures: $failed"
+
+if [ ${#stales[@]} -gt 0 ]; then
+	echo
+	echo "Stale deviations (listed as expected to fail, but passed):"
+	printf "  %s\n" "${stales[@]}"
+fi
+
+if [ ${#failures[@]} -gt 0 ]; then
+	echo
+	echo "Unexpected failures (fix them, or list them in $deviations with their reason):"
+	printf "  %s\n" "${failures[@]}"
+fi
+
+if [ $failed -gt 0 ] || { [ $strict -eq 1 ] && [ $stale -gt 0 ]; }; then
+	exit 1
+fi
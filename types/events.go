@@ -4,9 +4,23 @@ import "github.com/opencontainers/runc/libcontainer/intelrdt"
 
 // Event struct for encoding the event data to json.
 type Event struct {
-	Type string      `json:"type"`
-	ID   string      `json:"id"`
-	Data interface{} `json:"data,omitempty"`
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	// sysbox-runc: the container's inner cgroup the event is about (if not
+	// about the container as a whole), relative to its cgroup root
+	Cgroup string      `json:"cgroup,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+	// sysbox-runc: the oom kills the event reports (for "oom-kill" events)
+	OOMKill *OOMKill `json:"oom_kill,omitempty"`
+}
+
+// sysbox-runc: OOMKill reports the processes OOM killed in a cgroup since its
+// previous report.
+type OOMKill struct {
+	// processes killed since the previous report
+	Count uint64 `json:"count"`
+	// processes killed since the cgroup was created
+	Total uint64 `json:"total"`
 }
 
 // stats is the runc specific stats structure for stability when encoding and decoding stats.
@@ -19,6 +33,33 @@ type Stats struct {
 	Hugetlb           map[string]Hugetlb  `json:"hugetlb"`
 	IntelRdt          IntelRdt            `json:"intel_rdt"`
 	NetworkInterfaces []*NetworkInterface `json:"network_interfaces"`
+	// sysbox-runc: pressure stall information of the cgroup (cgroup v2 only)
+	Pressure *Pressure `json:"pressure,omitempty"`
+	// sysbox-runc: pressure stall information of the container's child cgroup
+	// (its cgroup root, as seen inside it; cgroup v2 only)
+	ChildPressure *Pressure `json:"child_pressure,omitempty"`
+}
+
+// sysbox-runc: PSIData is a line ("some" or "full") of pressure stall
+// information.
+type PSIData struct {
+	// Units: percentage of time stalled.
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	// Units: microseconds.
+	Total uint64 `json:"total"`
+}
+
+type PSI struct {
+	Some PSIData `json:"some"`
+	Full PSIData `json:"full"`
+}
+
+type Pressure struct {
+	CPU    *PSI `json:"cpu,omitempty"`
+	Memory *PSI `json:"memory,omitempty"`
+	IO     *PSI `json:"io,omitempty"`
 }
 
 type Hugetlb struct {
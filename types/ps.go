@@ -0,0 +1,14 @@
+package types
+
+// NestedProcess represents a process of a container, along with the nesting
+// of its pid namespace (as output by the ps command with --nested).
+type NestedProcess struct {
+	// Pid is the process id in the host's pid namespace
+	Pid int `json:"pid"`
+	// NsPid is the process id in the process' own (innermost) pid namespace
+	NsPid int `json:"nspid"`
+	// Level is the nesting level of the process' pid namespace, relative to
+	// the container's (0 for the container's own pid namespace, 1 for a pid
+	// namespace created within it, and so on)
+	Level int `json:"level"`
+}
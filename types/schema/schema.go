@@ -0,0 +1,178 @@
+// Package schema provides the JSON schemas of sysbox-runc's machine-readable
+// command output (i.e., the JSON and YAML output selected via the commands'
+// --output flag).
+//
+// The schemas are versioned (see Version). Within a version, the output of a
+// command only changes in backward compatible ways: fields may be added (so
+// consumers must ignore unknown fields), but are never removed, renamed, or
+// changed in type or meaning. Any other change requires a new version. The
+// schemas are generated from the Go types of the output, and checked against
+// golden files (see testdata/), so changes to the output are caught by the
+// unit tests.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/runc/types"
+)
+
+// Version is the version of the output schemas.
+const Version = "v1"
+
+// event is the output of the events command: a stream of types.Event, where
+// only "stats" events carry data, and only "oom-kill" events the oom kills
+// (and, for those of an inner cgroup, its path). The "start" and "exit" events
+// are only output with --watch.
+type event struct {
+	Type    string         `json:"type" schema:"enum=stats|oom|oom-kill|start|exit"`
+	ID      string         `json:"id"`
+	Cgroup  string         `json:"cgroup,omitempty"`
+	Data    *types.Stats   `json:"data,omitempty"`
+	OOMKill *types.OOMKill `json:"oom_kill,omitempty"`
+}
+
+type output struct {
+	title string
+	typ   reflect.Type
+}
+
+var outputs = map[string]output{
+	"state":     {"container state (state command)", reflect.TypeOf(types.ContainerState{})},
+	"list":      {"container list (list command)", reflect.TypeOf([]types.ContainerState{})},
+	"ps":        {"container process IDs (ps command)", reflect.TypeOf([]int{})},
+	"ps-nested": {"container processes with their pid namespace nesting (ps command with --nested)", reflect.TypeOf([]types.NestedProcess{})},
+	"events":    {"container event (events command; one per event)", reflect.TypeOf(event{})},
+}
+
+// Names returns the names of the output schemas, sorted.
+func Names() []string {
+	names := []string{}
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Title returns a short description of the given output schema.
+func Title(name string) string {
+	return outputs[name].title
+}
+
+// Get returns the given output schema (as indented JSON).
+func Get(name string) ([]byte, error) {
+	out, ok := outputs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown output schema %q (must be one of: %s)", name, strings.Join(Names(), ", "))
+	}
+
+	s := typeSchema(out.typ)
+	s["$schema"] = "http://json-schema.org/draft-07/schema#"
+	s["$id"] = fmt.Sprintf("sysbox-runc/%s/%s", Version, name)
+	s["title"] = out.title
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// typeSchema returns the JSON schema of the given type, per its encoding by
+// encoding/json.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(typeSchema(t.Elem()))
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return nullable(map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())})
+	case reflect.Map:
+		return nullable(map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())})
+	case reflect.Struct:
+		return structSchema(t)
+	}
+
+	// any value (e.g., interface{})
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		omitEmpty := false
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			opts := strings.Split(tag, ",")
+			if opts[0] == "-" {
+				continue
+			}
+			if opts[0] != "" {
+				name = opts[0]
+			}
+			for _, opt := range opts[1:] {
+				if opt == "omitempty" {
+					omitEmpty = true
+				}
+			}
+		}
+
+		s := typeSchema(f.Type)
+		if tag, ok := f.Tag.Lookup("schema"); ok && strings.HasPrefix(tag, "enum=") {
+			s["enum"] = strings.Split(strings.TrimPrefix(tag, "enum="), "|")
+		}
+		props[name] = s
+
+		// encoding/json never omits structs
+		if !omitEmpty || f.Type.Kind() == reflect.Struct {
+			required = append(required, name)
+		}
+	}
+
+	s := map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
+
+// nullable returns the given schema, extended to allow null (e.g., for nil
+// pointers, slices, and maps).
+func nullable(s map[string]interface{}) map[string]interface{} {
+	if typ, ok := s["type"].(string); ok {
+		s["type"] = []string{typ, "null"}
+	}
+	return s
+}
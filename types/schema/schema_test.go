@@ -0,0 +1,83 @@
+package schema
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Set to a non-empty value to rewrite the golden files.
+const updateEnv = "SYSBOX_SCHEMA_UPDATE"
+
+// TestSchemaStability checks the output schemas against their golden files;
+// a mismatch means the output of a command changed. Changes must be backward
+// compatible within a schema version (see the package doc).
+func TestSchemaStability(t *testing.T) {
+	for _, name := range Names() {
+		got, err := Get(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		golden := filepath.Join("testdata", Version, name+".json")
+
+		if os.Getenv(updateEnv) != "" {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatalf("%s: failed to update golden file: %v", name, err)
+			}
+			continue
+		}
+
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: schema differs from golden file %s (set %s=1 to update it):\n%s", name, golden, updateEnv, got)
+		}
+	}
+}
+
+func TestGetUnknown(t *testing.T) {
+	if _, err := Get("bogus"); err == nil {
+		t.Fatal("expected error for unknown schema")
+	}
+}
+
+func TestStructSchema(t *testing.T) {
+	type inner struct {
+		A int `json:"a"`
+	}
+	type sample struct {
+		Name     string            `json:"name"`
+		Count    uint64            `json:"count,omitempty"`
+		Inner    inner             `json:"inner,omitempty"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Skipped  string            `json:"-"`
+		Untagged bool
+		private  int
+	}
+
+	s := typeSchema(reflect.TypeOf(sample{}))
+
+	props := s["properties"].(map[string]interface{})
+	if len(props) != 5 {
+		t.Errorf("expected 5 properties; got %v", props)
+	}
+	if _, ok := props["Untagged"]; !ok {
+		t.Errorf("expected property for untagged field; got %v", props)
+	}
+
+	want := []string{"Untagged", "inner", "name"}
+	if !reflect.DeepEqual(s["required"], want) {
+		t.Errorf("expected required fields %v; got %v", want, s["required"])
+	}
+
+	labels := props["labels"].(map[string]interface{})
+	if !reflect.DeepEqual(labels["type"], []string{"object", "null"}) {
+		t.Errorf("expected nullable object for map field; got %v", labels)
+	}
+}
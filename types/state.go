@@ -0,0 +1,48 @@
+package types
+
+import "time"
+
+// ContainerState represents the platform agnostic pieces relating to a
+// running container's status and state (as output by the state and list
+// commands).
+type ContainerState struct {
+	// Version is the OCI version for the container
+	Version string `json:"ociVersion"`
+	// ID is the container ID
+	ID string `json:"id"`
+	// InitProcessPid is the init process id in the parent namespace
+	InitProcessPid int `json:"pid"`
+	// Status is the current status of the container, running, paused, ...
+	Status string `json:"status"`
+	// Bundle is the path on the filesystem to the bundle
+	Bundle string `json:"bundle"`
+	// Rootfs is a path to a directory containing the container's root filesystem.
+	Rootfs string `json:"rootfs"`
+	// Created is the unix timestamp for the creation time of the container in UTC
+	Created time.Time `json:"created"`
+	// Annotations is the user defined annotations added to the config.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// The owner of the state directory (the owner of the container).
+	Owner string `json:"owner"`
+	// Root is the state root of the container (only set when listing the
+	// containers of all state roots).
+	Root string `json:"root,omitempty"`
+	// UidShift describes how the ownership of the container's rootfs and bind
+	// mount sources is shifted to its user-ID range (only set by the state
+	// command).
+	UidShift []UidShift `json:"uidShift,omitempty"`
+	// Ready is when the container's payload notified its readiness via the
+	// notify proxy (only set by the state command, for containers with the
+	// io.nestybox.sysbox.notify-proxy annotation, once ready).
+	Ready *time.Time `json:"ready,omitempty"`
+}
+
+// UidShift describes the uid shifting of a container's rootfs (whose
+// destination is "/") or of a bind mount source.
+type UidShift struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Method      string `json:"method" schema:"enum=none|shiftfs|idmapped-mount|fuse|chown"`
+	// Reason explains the method (e.g., why there's no uid shifting).
+	Reason string `json:"reason,omitempty"`
+}
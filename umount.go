@@ -0,0 +1,60 @@
+// +build linux
+
+package main
+
+import (
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+var umountCommand = cli.Command{
+	Name:  "umount",
+	Usage: "umount removes a mount from a running system container",
+	ArgsUsage: `<container-id> <path>
+
+Where "<container-id>" is the name for the instance of the container and
+"<path>" is the absolute path (inside the container) of the mount to remove.
+
+EXAMPLE:
+For example, if the container id is "ubuntu01" and it has a bind mount on
+"/mnt/data", the following will unmount it:
+
+       # sysbox-runc umount ubuntu01 /mnt/data`,
+	Description: `The umount command unmounts the given path inside the container's mount
+namespace and removes the mount from the container's configuration, such that
+it's no longer tracked by sysbox-runc.
+
+Mounts required by sysbox (i.e., under /proc, /sys, and /dev) can't be removed.
+Those set up by sysbox-mgr (e.g., the one on /var/lib/docker) are released with
+it once removed; this requires a sysbox-mgr client that supports it (the default
+gRPC client doesn't), or else they're kept until the container is removed.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "lazy, l",
+			Usage: "detach the mount (and any submounts) now, and clean up references to it once it's no longer busy",
+		},
+		cli.BoolFlag{
+			Name:  "force, f",
+			Usage: "force the unmount even if the mount is busy (only honored by some filesystems, e.g., NFS)",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 2, exactArgs); err != nil {
+			return err
+		}
+		container, err := getContainer(context)
+		if err != nil {
+			return err
+		}
+
+		flags := 0
+		if context.Bool("lazy") {
+			flags |= unix.MNT_DETACH
+		}
+		if context.Bool("force") {
+			flags |= unix.MNT_FORCE
+		}
+
+		return container.Unmount(context.Args().Get(1), flags)
+	},
+}
@@ -8,12 +8,15 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 
 	"github.com/docker/go-units"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/intelrdt"
+	"github.com/opencontainers/runc/libcontainer/specconv"
+	"github.com/opencontainers/runc/libsysbox/syscont"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/urfave/cli"
 )
@@ -52,7 +55,18 @@ The accepted format is as follow (unchanged values can be omitted):
     "mems": ""
   },
   "blockIO": {
-    "weight": 0
+    "weight": 0,
+    "throttleReadBpsDevice": [{"major": 8, "minor": 0, "rate": 0}],
+    "throttleWriteBpsDevice": [],
+    "throttleReadIOPSDevice": [],
+    "throttleWriteIOPSDevice": []
+  },
+  "unified": {
+    "memory.high": "max",
+    "memory.swap.max": "max",
+    "memory.zswap.max": "max",
+    "cpu.max.burst": "0",
+    "cpu.idle": "0"
   }
 }
 
@@ -65,6 +79,22 @@ other options are ignored.
 			Name:  "blkio-weight",
 			Usage: "Specifies per cgroup weight, range is from 10 to 1000",
 		},
+		cli.StringSliceFlag{
+			Name:  "blkio-read-bps",
+			Usage: "Limit read rate from a device, as <major>:<minor>:<bytes per second>; a rate of 0 removes the limit",
+		},
+		cli.StringSliceFlag{
+			Name:  "blkio-write-bps",
+			Usage: "Limit write rate to a device, as <major>:<minor>:<bytes per second>; a rate of 0 removes the limit",
+		},
+		cli.StringSliceFlag{
+			Name:  "blkio-read-iops",
+			Usage: "Limit read rate from a device, as <major>:<minor>:<IO per second>; a rate of 0 removes the limit",
+		},
+		cli.StringSliceFlag{
+			Name:  "blkio-write-iops",
+			Usage: "Limit write rate to a device, as <major>:<minor>:<IO per second>; a rate of 0 removes the limit",
+		},
 		cli.StringFlag{
 			Name:  "cpu-period",
 			Usage: "CPU CFS period to be used for hardcapping (in usecs). 0 to use system default",
@@ -77,6 +107,14 @@ other options are ignored.
 			Name:  "cpu-share",
 			Usage: "CPU shares (relative weight vs. other containers)",
 		},
+		cli.StringFlag{
+			Name:  "cpu-burst",
+			Usage: "CPU CFS burst (in usecs); CPU time that can be accumulated while below the quota, to exceed it later",
+		},
+		cli.StringFlag{
+			Name:  "cpu-idle",
+			Usage: "Set to 1 to schedule the container's CPU usage as idle (SCHED_IDLE), or 0 to undo it",
+		},
 		cli.StringFlag{
 			Name:  "cpu-rt-period",
 			Usage: "CPU realtime period to be used for hardcapping (in usecs). 0 to use system default",
@@ -113,6 +151,18 @@ other options are ignored.
 			Name:  "memory-swap",
 			Usage: "Total memory usage (memory + swap); set '-1' to enable unlimited swap",
 		},
+		cli.StringFlag{
+			Name:  "memory-high",
+			Usage: "Memory usage throttle limit (in bytes); set '-1' or 'max' to disable (cgroup v2 only)",
+		},
+		cli.StringFlag{
+			Name:  "memory-swap-max",
+			Usage: "Swap usage limit, excluding memory (in bytes); set '-1' or 'max' for unlimited swap (cgroup v2 only)",
+		},
+		cli.StringFlag{
+			Name:  "memory-zswap-max",
+			Usage: "Zswap pool size limit (in bytes); set '-1' or 'max' for no limit (cgroup v2 only)",
+		},
 		cli.IntFlag{
 			Name:  "pids-limit",
 			Usage: "Maximum number of pids allowed in the container",
@@ -125,6 +175,22 @@ other options are ignored.
 			Name:  "mem-bw-schema",
 			Usage: "The string of Intel RDT/MBA memory bandwidth schema",
 		},
+		cli.StringFlag{
+			Name:  "inotify-watches",
+			Usage: "Maximum number of inotify watches of the container's processes; 0 for no per-container limit (requires a sysbox-fs client with fsnotify quota support, which the default one lacks)",
+		},
+		cli.StringFlag{
+			Name:  "inotify-instances",
+			Usage: "Maximum number of inotify instances of the container's processes; 0 for no per-container limit (requires a sysbox-fs client with fsnotify quota support, which the default one lacks)",
+		},
+		cli.StringFlag{
+			Name:  "fanotify-marks",
+			Usage: "Maximum number of fanotify marks of the container's processes; 0 for no per-container limit (requires a sysbox-fs client with fsnotify quota support, which the default one lacks)",
+		},
+		cli.StringFlag{
+			Name:  "fanotify-groups",
+			Usage: "Maximum number of fanotify groups of the container's processes; 0 for no per-container limit (requires a sysbox-fs client with fsnotify quota support, which the default one lacks)",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 1, exactArgs); err != nil {
@@ -184,6 +250,24 @@ other options are ignored.
 			if val := context.Int("blkio-weight"); val != 0 {
 				r.BlockIO.Weight = u16Ptr(uint16(val))
 			}
+			for _, pair := range []struct {
+				opt   string
+				bytes bool
+				dest  *[]specs.LinuxThrottleDevice
+			}{
+				{"blkio-read-bps", true, &r.BlockIO.ThrottleReadBpsDevice},
+				{"blkio-write-bps", true, &r.BlockIO.ThrottleWriteBpsDevice},
+				{"blkio-read-iops", false, &r.BlockIO.ThrottleReadIOPSDevice},
+				{"blkio-write-iops", false, &r.BlockIO.ThrottleWriteIOPSDevice},
+			} {
+				for _, val := range context.StringSlice(pair.opt) {
+					td, err := parseThrottleDevice(val, pair.bytes)
+					if err != nil {
+						return fmt.Errorf("invalid value for %s: %s", pair.opt, err)
+					}
+					*pair.dest = append(*pair.dest, td)
+				}
+			}
 			if val := context.String("cpuset-cpus"); val != "" {
 				r.CPU.Cpus = val
 			}
@@ -254,6 +338,20 @@ other options are ignored.
 		// Update the values
 		config.Cgroups.Resources.BlkioWeight = *r.BlockIO.Weight
 
+		// sysbox-runc: blkio throttling; it's applied to the sys container's
+		// child cgroup too. Limits for devices not given are left as is.
+		for _, pair := range []struct {
+			update []specs.LinuxThrottleDevice
+			dest   *[]*configs.ThrottleDevice
+		}{
+			{r.BlockIO.ThrottleReadBpsDevice, &config.Cgroups.Resources.BlkioThrottleReadBpsDevice},
+			{r.BlockIO.ThrottleWriteBpsDevice, &config.Cgroups.Resources.BlkioThrottleWriteBpsDevice},
+			{r.BlockIO.ThrottleReadIOPSDevice, &config.Cgroups.Resources.BlkioThrottleReadIOPSDevice},
+			{r.BlockIO.ThrottleWriteIOPSDevice, &config.Cgroups.Resources.BlkioThrottleWriteIOPSDevice},
+		} {
+			*pair.dest = updateThrottleDevices(*pair.dest, pair.update)
+		}
+
 		// Seting CPU quota and period independently does not make much sense,
 		// but historically runc allowed it and this needs to be supported
 		// to not break compatibility.
@@ -296,6 +394,87 @@ other options are ignored.
 		config.Cgroups.Resources.PidsLimit = r.Pids.Limit
 		config.Cgroups.Resources.Unified = r.Unified
 
+		// sysbox-runc: memory QoS settings (cgroup v2); these are applied to the
+		// sys container's child cgroup too
+		if err := specconv.MemoryQoSFromUnified(config.Cgroups.Resources); err != nil {
+			return err
+		}
+		if context.String("resources") == "" {
+			for _, pair := range []struct {
+				opt  string
+				dest **int64
+			}{
+				{"memory-high", &config.Cgroups.Resources.MemoryHigh},
+				{"memory-swap-max", &config.Cgroups.Resources.MemorySwapMax},
+				{"memory-zswap-max", &config.Cgroups.Resources.MemoryZswapMax},
+			} {
+				val := context.String(pair.opt)
+				if val == "" {
+					continue
+				}
+				if !cgroups.IsCgroup2UnifiedMode() {
+					return fmt.Errorf("%s requires cgroup v2", pair.opt)
+				}
+				v := int64(-1)
+				if val != "-1" && val != "max" {
+					v, err = units.RAMInBytes(val)
+					if err != nil {
+						return fmt.Errorf("invalid value for %s: %s", pair.opt, err)
+					}
+				}
+				*pair.dest = &v
+			}
+		}
+
+		// sysbox-runc: cpu burst and idle settings; these are applied to the
+		// sys container's child cgroup too
+		if err := specconv.CPUFromUnified(config.Cgroups.Resources); err != nil {
+			return err
+		}
+		if context.String("resources") == "" {
+			if val := context.String("cpu-burst"); val != "" {
+				burst, err := strconv.ParseUint(val, 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid value for cpu-burst: %s", err)
+				}
+				config.Cgroups.Resources.CpuBurst = &burst
+			}
+			switch val := context.String("cpu-idle"); val {
+			case "":
+			case "0", "1":
+				idle, _ := strconv.ParseInt(val, 10, 64)
+				config.Cgroups.Resources.CPUIdle = &idle
+			default:
+				return fmt.Errorf("invalid value for cpu-idle: %q (must be 0 or 1)", val)
+			}
+		}
+
+		// sysbox-runc: inotify/fanotify quota (see syscont.FsNotifyQuotaAnnotation)
+		if context.String("resources") == "" {
+			var quota configs.FsNotifyQuota
+			if config.FsNotifyQuota != nil {
+				quota = *config.FsNotifyQuota
+			}
+			quotaSet := false
+			for opt, dest := range syscont.FsNotifyQuotaFields(&quota) {
+				if val := context.String(opt); val != "" {
+					n, err := strconv.ParseUint(val, 10, 64)
+					if err != nil {
+						return fmt.Errorf("invalid value for %s: %s", opt, err)
+					}
+					*dest = n
+					quotaSet = true
+				}
+			}
+			if quotaSet {
+				if quota == (configs.FsNotifyQuota{}) {
+					config.FsNotifyQuota = nil
+				} else {
+					config.FsNotifyQuota = &quota
+				}
+			}
+		}
+
 		// Update Intel RDT
 		l3CacheSchema := context.String("l3-cache-schema")
 		memBwSchema := context.String("mem-bw-schema")
@@ -330,3 +509,60 @@ other options are ignored.
 		return container.Set(config)
 	},
 }
+
+// parseThrottleDevice parses a blkio throttling flag value
+// ("<major>:<minor>:<rate>"); bytes indicates if the rate is in bytes per
+// second (and may thus have a unit suffix, e.g., "10MB").
+func parseThrottleDevice(val string, bytes bool) (specs.LinuxThrottleDevice, error) {
+	td := specs.LinuxThrottleDevice{}
+
+	fields := strings.Split(val, ":")
+	if len(fields) != 3 {
+		return td, fmt.Errorf("%q must be <major>:<minor>:<rate>", val)
+	}
+	major, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return td, fmt.Errorf("invalid major number %q", fields[0])
+	}
+	minor, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return td, fmt.Errorf("invalid minor number %q", fields[1])
+	}
+
+	var rate uint64
+	if bytes {
+		var v int64
+		v, err = units.RAMInBytes(fields[2])
+		if err == nil && v < 0 {
+			err = fmt.Errorf("negative rate")
+		}
+		rate = uint64(v)
+	} else {
+		rate, err = strconv.ParseUint(fields[2], 10, 64)
+	}
+	if err != nil {
+		return td, fmt.Errorf("invalid rate %q", fields[2])
+	}
+
+	td.Major, td.Minor, td.Rate = major, minor, rate
+	return td, nil
+}
+
+// updateThrottleDevices returns the given blkio throttling config, updated
+// with the given throttle devices (per device); devices with a zero rate are
+// kept, so that their limit is removed when the config is applied.
+func updateThrottleDevices(cur []*configs.ThrottleDevice, update []specs.LinuxThrottleDevice) []*configs.ThrottleDevice {
+	for _, u := range update {
+		found := false
+		for _, td := range cur {
+			if td.Major == u.Major && td.Minor == u.Minor {
+				td.Rate = u.Rate
+				found = true
+			}
+		}
+		if !found {
+			cur = append(cur, configs.NewThrottleDevice(u.Major, u.Minor, u.Rate))
+		}
+	}
+	return cur
+}
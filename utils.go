@@ -1,12 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libsysbox/sysbox"
 	"github.com/opencontainers/runtime-spec/specs-go"
 
 	"github.com/sirupsen/logrus"
@@ -40,7 +43,7 @@ func checkArgs(context *cli.Context, expected, checkType int) error {
 	if err != nil {
 		fmt.Printf("Incorrect Usage.\n\n")
 		cli.ShowCommandHelp(context, cmdName)
-		return err
+		return &sysbox.Error{Code: sysbox.ErrInvalidArgs, Err: err}
 	}
 	return nil
 }
@@ -50,9 +53,59 @@ func logrusToStderr() bool {
 	return ok && l.Fd() == os.Stderr.Fd()
 }
 
+func logFormatJSON() bool {
+	_, ok := logrus.StandardLogger().Formatter.(*logrus.JSONFormatter)
+	return ok
+}
+
+// errorCode returns the stable error code (reported to container engines)
+// for the given error.
+func errorCode(err error) sysbox.ErrorCode {
+	if code := sysbox.GetErrorCode(err); code != sysbox.ErrUnknown {
+		return code
+	}
+
+	var lerr libcontainer.Error
+	if errors.As(err, &lerr) {
+		switch lerr.Code() {
+		case libcontainer.ContainerNotExists:
+			return sysbox.ErrContainerNotFound
+		case libcontainer.IdInUse:
+			return sysbox.ErrContainerIdInUse
+		case libcontainer.InvalidIdFormat, libcontainer.ConfigInvalid:
+			return sysbox.ErrInvalidConfig
+		case libcontainer.ContainerPaused, libcontainer.ContainerNotStopped,
+			libcontainer.ContainerNotRunning, libcontainer.ContainerNotPaused:
+			return sysbox.ErrContainerBadState
+		case libcontainer.SystemError:
+			return sysbox.ErrSystem
+		}
+	}
+
+	return sysbox.ErrUnknown
+}
+
 // fatal prints the error's details if it is a libcontainer specific error type
-// then exits the program with an exit status of 1.
+// then exits the program with an exit status of 1. With "--log-format json",
+// the error is reported as a JSON object carrying a stable error code.
 func fatal(err error) {
+	if logFormatJSON() {
+		fields := logrus.Fields{"code": errorCode(err)}
+		// tell callers when a retry may succeed (e.g., sysbox-mgr was
+		// restarting)
+		if sysbox.IsTemporary(err) {
+			fields["temporary"] = true
+		}
+		logrus.WithFields(fields).Error(err)
+		if !logrusToStderr() {
+			l := logrus.New()
+			l.Out = os.Stderr
+			l.Formatter = logrus.StandardLogger().Formatter
+			l.WithFields(fields).Error(err)
+		}
+		os.Exit(1)
+	}
+
 	// make sure the error is written to the logger
 	logrus.Error(err)
 	if !logrusToStderr() {
@@ -4,18 +4,23 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/nestybox/sysbox-libs/dockerUtils"
 	"github.com/opencontainers/runc/libcontainer"
 	"github.com/opencontainers/runc/libcontainer/cgroups/systemd"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/specconv"
 	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/opencontainers/runc/libsysbox"
+	"github.com/opencontainers/runc/libsysbox/audit"
+	"github.com/opencontainers/runc/libsysbox/extension"
 	"github.com/opencontainers/runc/libsysbox/sysbox"
 	"github.com/opencontainers/runc/libsysbox/syscont"
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -30,13 +35,28 @@ import (
 
 var errEmptyID = errors.New("container id cannot be empty")
 
-// loadFactory returns the configured factory instance for execing containers.
-func loadFactory(context *cli.Context, sysMgr *sysbox.Mgr, sysFs *sysbox.Fs) (libcontainer.Factory, error) {
-	root := context.GlobalString("root")
-	abs, err := filepath.Abs(root)
+// loadFactory returns the configured factory instance for execing containers;
+// the given options are applied after those of the command line.
+func loadFactory(context *cli.Context, extraOpts ...func(*libcontainer.LinuxFactory) error) (libcontainer.Factory, error) {
+	root, err := factoryRoot(context)
 	if err != nil {
 		return nil, err
 	}
+	opts, err := factoryOpts(context)
+	if err != nil {
+		return nil, err
+	}
+	return libcontainer.New(root, append(opts, extraOpts...)...)
+}
+
+// factoryRoot returns the absolute path of the container state directory.
+func factoryRoot(context *cli.Context) (string, error) {
+	return filepath.Abs(context.GlobalString("root"))
+}
+
+// factoryOpts returns the libcontainer factory options configured via the
+// command line.
+func factoryOpts(context *cli.Context) ([]func(*libcontainer.LinuxFactory) error, error) {
 
 	// We default to cgroupfs, and can only use systemd if the system is a
 	// systemd box.
@@ -72,26 +92,141 @@ func loadFactory(context *cli.Context, sysMgr *sysbox.Mgr, sysFs *sysbox.Fs) (li
 		newgidmap = ""
 	}
 
-	return libcontainer.New(abs, cgroupManager, intelRdtManager,
+	opts := []func(*libcontainer.LinuxFactory) error{
+		cgroupManager,
+		intelRdtManager,
 		libcontainer.CriuPath(context.GlobalString("criu")),
 		libcontainer.NewuidmapPath(newuidmap),
 		libcontainer.NewgidmapPath(newgidmap),
-		libcontainer.SysFs(sysFs),
-		libcontainer.SysMgr(sysMgr))
+	}
+	if context.GlobalBool("state-index") {
+		opts = append(opts, libcontainer.StateIndex)
+	}
+	return opts, nil
+}
+
+// sysboxCreateOpts returns the libsysbox options for creating the system
+// container given in the command line.
+func sysboxCreateOpts(context *cli.Context) (libsysbox.CreateOpts, error) {
+	id := context.Args().First()
+
+	root, err := factoryRoot(context)
+	if err != nil {
+		return libsysbox.CreateOpts{}, err
+	}
+	fOpts, err := factoryOpts(context)
+	if err != nil {
+		return libsysbox.CreateOpts{}, err
+	}
+	rootlessCg, err := shouldUseRootlessCgroupManager(context)
+	if err != nil {
+		return libsysbox.CreateOpts{}, err
+	}
+	key, err := containerKey(id, root)
+	if err != nil {
+		return libsysbox.CreateOpts{}, err
+	}
+	if err := registerRoot(root); err != nil {
+		logrus.Warnf("failed to record state root %s: %v", root, err)
+	}
+	exts, err := extension.Load(context.GlobalString("extensions-dir"), extension.DefaultTimeout)
+	if err != nil {
+		return libsysbox.CreateOpts{}, &sysbox.Error{Code: sysbox.ErrInvalidConfig, Err: err}
+	}
+	// the spec types don't carry the process scheduling attributes, so get
+	// them from the spec file itself (setupSpec() changed to the bundle dir)
+	sched, err := loadProcessSched(specConfig, true)
+	if err != nil {
+		return libsysbox.CreateOpts{}, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	var uidShiftBackends []syscont.UidShiftBackend
+	if s := context.GlobalString("uid-shift-backends"); s != "" {
+		if uidShiftBackends, err = syscont.ParseUidShiftBackends(s); err != nil {
+			return libsysbox.CreateOpts{}, &sysbox.Error{Code: sysbox.ErrInvalidArgs, Err: err}
+		}
+	}
+	var upperDirs []string
+	if s := context.GlobalString("upper-dirs"); s != "" {
+		for _, dir := range strings.Split(s, ",") {
+			dir = strings.TrimSpace(dir)
+			if !filepath.IsAbs(dir) {
+				return libsysbox.CreateOpts{}, &sysbox.Error{Code: sysbox.ErrInvalidArgs, Err: fmt.Errorf("upper dir %q is not an absolute path", dir)}
+			}
+			upperDirs = append(upperDirs, dir)
+		}
+	}
+	var snapshotDirs []string
+	if s := context.GlobalString("snapshot-dirs"); s != "" {
+		for _, dir := range strings.Split(s, ",") {
+			dir = strings.TrimSpace(dir)
+			if !filepath.IsAbs(dir) {
+				return libsysbox.CreateOpts{}, &sysbox.Error{Code: sysbox.ErrInvalidArgs, Err: fmt.Errorf("snapshot dir %q is not an absolute path", dir)}
+			}
+			snapshotDirs = append(snapshotDirs, dir)
+		}
+	}
+	var auditSink audit.Sink
+	if s := context.GlobalString("audit-log"); s != "" {
+		if auditSink, err = audit.NewSink(s); err != nil {
+			return libsysbox.CreateOpts{}, &sysbox.Error{Code: sysbox.ErrInvalidArgs, Err: err}
+		}
+	}
+
+	return libsysbox.CreateOpts{
+		ID:                     id,
+		Root:                   root,
+		Mgr:                    sysbox.NewMgr(key, !context.GlobalBool("no-sysbox-mgr")),
+		Fs:                     sysbox.NewFs(key, !context.GlobalBool("no-sysbox-fs")),
+		NoKernelCheck:          context.GlobalBool("no-kernel-check"),
+		DiskCheck:              context.GlobalBool("disk-check"),
+		KernelTracingAllowlist: context.GlobalString("kernel-tracing-allowlist"),
+		SharedMountsAllowlist:  context.GlobalString("shared-mounts-allowlist"),
+		ConfigfsAllowlist:      context.GlobalString("configfs-passthrough-allowlist"),
+		CoreDumpDir:            context.GlobalString("core-dump-dir"),
+		UpperDirs:              upperDirs,
+		SnapshotDirs:           snapshotDirs,
+		SSHKeysDir:             context.GlobalString("ssh-keys-dir"),
+		UidShiftBackends:       uidShiftBackends,
+		IdmapFuseHelper:        context.GlobalString("idmap-fuse-helper"),
+		SeccompKeeper:          context.GlobalBool("seccomp-keeper"),
+		UseSystemdCgroup:       context.GlobalBool("systemd-cgroup"),
+		RootlessCgroups:        rootlessCg,
+		NoPivotRoot:            context.Bool("no-pivot"),
+		NoNewKeyring:           context.Bool("no-new-keyring"),
+		Extensions:             exts,
+		SysboxHooksDir:         context.GlobalString("sysbox-hooks-dir"),
+		Audit:                  auditSink,
+		Scheduler:              sched.Scheduler,
+		IOPriority:             sched.IOPriority,
+		FactoryOpts:            fOpts,
+	}, nil
 }
 
 // getContainer returns the specified container instance by loading it from state
-// with the default factory.
-func getContainer(context *cli.Context) (libcontainer.Container, error) {
+// with the default factory (plus the given options).
+func getContainer(context *cli.Context, extraOpts ...func(*libcontainer.LinuxFactory) error) (libcontainer.Container, error) {
 	id := context.Args().First()
 	if id == "" {
 		return nil, errEmptyID
 	}
-	factory, err := loadFactory(context, nil, nil)
+	factory, err := loadFactory(context, extraOpts...)
 	if err != nil {
 		return nil, err
 	}
-	return factory.Load(id)
+	container, err := factory.Load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// sysbox-runc: the container's annotations were validated when it was
+	// created
+	config := container.Config()
+	_, annotations := utils.Annotations(config.Labels)
+	if err := setupContainerLogging(context, id, annotations); err != nil {
+		logrus.Warn(err)
+	}
+
+	return container, nil
 }
 
 func getDefaultImagePath(context *cli.Context) string {
@@ -161,6 +296,15 @@ func setupIO(process *libcontainer.Process, rootuid, rootgid int, createTTY, det
 			if err := t.initHostConsole(); err != nil {
 				return nil, err
 			}
+			// sysbox-runc: create the pty with the size of the host console,
+			// rather than resizing it once the process has started; otherwise
+			// exec'd processes that query the size right away (e.g., shells
+			// of systemd-based containers) may see a stale one, as setns
+			// processes start running before the resize.
+			if ws, err := t.hostConsole.Size(); err == nil && ws.Width != 0 && ws.Height != 0 {
+				process.ConsoleWidth = ws.Width
+				process.ConsoleHeight = ws.Height
+			}
 			parent, child, err := utils.NewSockPair("console")
 			if err != nil {
 				return nil, err
@@ -254,84 +398,27 @@ func createPidFile(path string, process *libcontainer.Process) error {
 	return os.Rename(tmpName, path)
 }
 
-// sysMgrGetFsState inquires sysbox-mgr for special files/dirs to
-// to be added to container's rootfs.
-func sysMgrGetFsState(mgr *sysbox.Mgr, config *configs.Config) error {
-
-	state, err := mgr.ReqFsState(config.Rootfs)
-	if err != nil {
-		return err
-	}
-
-	config.FsState = state
-
-	return nil
-}
-
-func createContainer(context *cli.Context,
-	id string,
-	spec *specs.Spec,
-	uidShiftSupported, uidShiftRootfs, switchDockerDns bool,
-	sysMgr *sysbox.Mgr,
-	sysFs *sysbox.Fs) (libcontainer.Container, error) {
-
-	rootlessCg, err := shouldUseRootlessCgroupManager(context)
-	if err != nil {
-		return nil, err
-	}
-
-	config, err := specconv.CreateLibcontainerConfig(&specconv.CreateOpts{
-		CgroupName:        id,
-		UseSystemdCgroup:  context.GlobalBool("systemd-cgroup"),
-		NoPivotRoot:       context.Bool("no-pivot"),
-		NoNewKeyring:      context.Bool("no-new-keyring"),
-		Spec:              spec,
-		RootlessEUID:      os.Geteuid() != 0,
-		RootlessCgroups:   rootlessCg,
-		UidShiftSupported: uidShiftSupported,
-		UidShiftRootfs:    uidShiftRootfs,
-		SwitchDockerDns:   switchDockerDns,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	// sysbox-runc: For container's proper operation, collect from sysbox-mgr
-	// fsState to be added to container's rootfs.
-	if sysMgr.Enabled() {
-		if err := sysMgrGetFsState(sysMgr, config); err != nil {
-			return nil, err
-		}
-	}
-
-	// sysbox-runc: setup sys container syscall trapping
-	if sysFs.Enabled() {
-		if err := syscont.AddSyscallTraps(config); err != nil {
-			return nil, err
-		}
-	}
-
-	factory, err := loadFactory(context, sysMgr, sysFs)
-	if err != nil {
-		return nil, err
-	}
-	return factory.Create(id, config)
-}
-
 type runner struct {
-	init            bool
-	enableSubreaper bool
-	shouldDestroy   bool
-	detach          bool
-	listenFDs       []*os.File
-	preserveFDs     int
-	pidFile         string
-	consoleSocket   string
-	container       libcontainer.Container
-	action          CtAct
-	notifySocket    *notifySocket
-	criuOpts        *libcontainer.CriuOpts
-	logLevel        string
+	init             bool
+	enableSubreaper  bool
+	shouldDestroy    bool
+	detach           bool
+	listenFDs        []*os.File
+	preserveFDs      int
+	pidFile          string
+	consoleSocket    string
+	container        libcontainer.Container
+	action           CtAct
+	notifySocket     *notifySocket
+	criuOpts         *libcontainer.CriuOpts
+	logLevel         string
+	subCgroupPaths   map[string]string
+	stdio            *stdioBackend
+	procSched        *specconv.ProcessSched
+	sigMap           map[unix.Signal]unix.Signal
+	portProxy        *portProxy
+	notifyProxy      *notifyProxy
+	bootstrapTimeout time.Duration
 }
 
 func (r *runner) run(config *specs.Process) (int, error) {
@@ -348,6 +435,12 @@ func (r *runner) run(config *specs.Process) (int, error) {
 	if err != nil {
 		return -1, err
 	}
+	process.SubCgroupPaths = r.subCgroupPaths
+	process.BootstrapTimeout = r.bootstrapTimeout
+	if r.procSched != nil {
+		process.Scheduler = r.procSched.Scheduler
+		process.IOPriority = r.procSched.IOPriority
+	}
 	if len(r.listenFDs) > 0 {
 		process.Env = append(process.Env, "LISTEN_FDS="+strconv.Itoa(len(r.listenFDs)), "LISTEN_PID=1")
 		process.ExtraFiles = append(process.ExtraFiles, r.listenFDs...)
@@ -360,11 +453,10 @@ func (r *runner) run(config *specs.Process) (int, error) {
 		}
 		process.ExtraFiles = append(process.ExtraFiles, os.NewFile(uintptr(i), "PreserveFD:"+strconv.Itoa(i)))
 	}
-	rootuid, err := r.container.Config().HostRootUID()
-	if err != nil {
-		return -1, err
-	}
-	rootgid, err := r.container.Config().HostRootGID()
+	// sysbox-runc: the stdio of exec'd processes is owned by their user, so
+	// that they can access it when not running as root (e.g., when reopening
+	// /dev/stdout)
+	rootuid, rootgid, err := stdioOwner(r.container.Config(), config, r.init)
 	if err != nil {
 		return -1, err
 	}
@@ -374,8 +466,13 @@ func (r *runner) run(config *specs.Process) (int, error) {
 	// Setting up IO is a two stage process. We need to modify process to deal
 	// with detaching containers, and then we get a tty after the container has
 	// started.
-	handler := newSignalHandler(r.enableSubreaper, r.notifySocket)
-	tty, err := setupIO(process, rootuid, rootgid, config.Terminal, detach, r.consoleSocket)
+	handler := newSignalHandler(r.enableSubreaper, r.notifySocket, r.sigMap)
+	var tty *tty
+	if r.stdio != nil {
+		tty, err = r.stdio.setup(process, rootuid, rootgid)
+	} else {
+		tty, err = setupIO(process, rootuid, rootgid, config.Terminal, detach, r.consoleSocket)
+	}
 	if err != nil {
 		return -1, err
 	}
@@ -408,6 +505,18 @@ func (r *runner) run(config *specs.Process) (int, error) {
 			return -1, err
 		}
 	}
+	if r.portProxy != nil {
+		if err = r.portProxy.start(r.container); err != nil {
+			r.terminate(process)
+			return -1, err
+		}
+	}
+	if r.notifyProxy != nil {
+		if err = r.notifyProxy.start(r.container); err != nil {
+			r.terminate(process)
+			return -1, err
+		}
+	}
 	status, err := handler.forward(process, tty, detach)
 	if err != nil {
 		r.terminate(process)
@@ -421,6 +530,33 @@ func (r *runner) run(config *specs.Process) (int, error) {
 	return status, err
 }
 
+// sysbox-runc: stdioOwner returns the host uid and gid that own the stdio of
+// the given process: those its user is mapped to for exec'd processes, and the
+// container's root user ones otherwise.
+func stdioOwner(config configs.Config, p *specs.Process, init bool) (int, int, error) {
+	if init || (p.User.UID == 0 && p.User.GID == 0) {
+		uid, err := config.HostRootUID()
+		if err != nil {
+			return -1, -1, err
+		}
+		gid, err := config.HostRootGID()
+		if err != nil {
+			return -1, -1, err
+		}
+		return uid, gid, nil
+	}
+
+	uid, err := config.HostUID(int(p.User.UID))
+	if err != nil {
+		return -1, -1, fmt.Errorf("mapping the uid %d of the process: %v", p.User.UID, err)
+	}
+	gid, err := config.HostGID(int(p.User.GID))
+	if err != nil {
+		return -1, -1, fmt.Errorf("mapping the gid %d of the process: %v", p.User.GID, err)
+	}
+	return uid, gid, nil
+}
+
 func (r *runner) destroy() {
 	if r.shouldDestroy {
 		destroy(r.container)
@@ -441,9 +577,133 @@ func (r *runner) checkTerminal(config *specs.Process) error {
 	if (!detach || !config.Terminal) && r.consoleSocket != "" {
 		return errors.New("cannot use console socket if runc will not detach or allocate tty")
 	}
+	if r.stdio != nil && config.Terminal {
+		return errors.New("cannot use a stdio file or socket if the container allocates a tty")
+	}
+	return nil
+}
+
+// patchSpec applies the JSON patch (RFC 6902) in the given file to the spec.
+func patchSpec(spec *specs.Spec, patchFile string) (*specs.Spec, error) {
+	patch, err := ioutil.ReadFile(patchFile)
+	if err != nil {
+		return nil, err
+	}
+	spec, err = syscont.ApplySpecPatch(spec, patch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply spec patch %s: %v", patchFile, err)
+	}
+	return spec, validateProcessSpec(spec.Process)
+}
+
+// setSharedNamespaces adds the peer namespaces given by the --share-ipc and
+// --share-uts flags to the spec's share-ns annotation (the flags take
+// precedence over the annotation).
+func setSharedNamespaces(context *cli.Context, spec *specs.Spec) error {
+	shared, err := syscont.GetSharedNamespaces(spec.Annotations)
+	if err != nil {
+		return err
+	}
+
+	flags := map[specs.LinuxNamespaceType]string{
+		specs.IPCNamespace:    context.String("share-ipc"),
+		specs.UTSNamespace:    context.String("share-uts"),
+		specs.CgroupNamespace: context.String("share-cgroup"),
+	}
+
+	updated := false
+	for nsType, peerId := range flags {
+		if peerId == "" {
+			continue
+		}
+		if shared == nil {
+			shared = make(map[specs.LinuxNamespaceType]string)
+		}
+		shared[nsType] = peerId
+		updated = true
+	}
+
+	if !updated {
+		return nil
+	}
+
+	entries := []string{}
+	for _, nsType := range []specs.LinuxNamespaceType{specs.IPCNamespace, specs.UTSNamespace, specs.CgroupNamespace} {
+		if peerId, ok := shared[nsType]; ok {
+			entries = append(entries, string(nsType)+"="+peerId)
+		}
+	}
+
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	spec.Annotations[syscont.ShareNsAnnotation] = strings.Join(entries, ",")
+
 	return nil
 }
 
+// setRootfsClone sets the spec's rootfs-clone annotation if the --rootfs-clone
+// flag is given.
+func setRootfsClone(context *cli.Context, spec *specs.Spec) {
+	if !context.Bool("rootfs-clone") {
+		return
+	}
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	spec.Annotations[syscont.RootfsCloneAnnotation] = "true"
+}
+
+// setTemplate sets the spec's template annotation if the --template flag is
+// given.
+func setTemplate(context *cli.Context, spec *specs.Spec) {
+	if !context.Bool("template") {
+		return
+	}
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	spec.Annotations[syscont.TemplateAnnotation] = "true"
+}
+
+// setNotifyProxy sets the spec's notify proxy annotation if the --notify-proxy
+// flag is given.
+func setNotifyProxy(context *cli.Context, spec *specs.Spec) {
+	if !context.Bool("notify-proxy") {
+		return
+	}
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	spec.Annotations[syscont.NotifyProxyAnnotation] = "true"
+}
+
+// setBootstrapTimeout sets the spec's bootstrap timeout annotation if the
+// --bootstrap-timeout flag is given.
+func setBootstrapTimeout(context *cli.Context, spec *specs.Spec) {
+	timeout := context.Duration("bootstrap-timeout")
+	if timeout <= 0 {
+		return
+	}
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	spec.Annotations[syscont.BootstrapTimeoutAnnotation] = timeout.String()
+}
+
+// setUidShiftBackends sets the spec's uid shifting backends annotation if the
+// --uid-shift flag is given.
+func setUidShiftBackends(context *cli.Context, spec *specs.Spec) {
+	val := context.String("uid-shift")
+	if val == "" {
+		return
+	}
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	spec.Annotations[syscont.UidShiftBackendsAnnotation] = val
+}
+
 func validateProcessSpec(spec *specs.Process) error {
 	if spec == nil {
 		return errors.New("process property must not be empty")
@@ -482,32 +742,59 @@ func startContainer(context *cli.Context,
 	spec *specs.Spec,
 	action CtAct,
 	criuOpts *libcontainer.CriuOpts,
-	uidShiftSupported, uidShiftRootfs bool,
-	sysMgr *sysbox.Mgr,
-	sysFs *sysbox.Fs) (int, error) {
+	sysCont *libsysbox.SysContainer) (status int, err error) {
 
 	id := context.Args().First()
 	if id == "" {
 		return -1, errEmptyID
 	}
 
-	switchDockerDns := false
-	if sysMgr.Enabled() && sysMgr.Config.AliasDns {
-		var err error
-		switchDockerDns, err = dockerUtils.ContainerIsDocker(id, spec.Root.Path)
-		if err != nil {
-			return -1, err
+	// sysbox-runc: a panic is turned into an error, after destroying the
+	// container (if created) as on any other failure of the runner
+	var container libcontainer.Container
+	defer func() {
+		if r := recover(); r != nil {
+			phase := phaseCreate
+			if container != nil {
+				phase = phaseStart
+				destroy(container)
+			}
+			status, err = -1, sysbox.NewPanicError(phase, r)
 		}
-	}
+	}()
 
 	notifySocket := newNotifySocket(context, os.Getenv("NOTIFY_SOCKET"), id)
 	if notifySocket != nil {
+		// sysbox-runc: only a run in the foreground outlives the container's
+		// readiness, to proxy its later notifications
+		notifySocket.proxying = action == CT_ACT_RUN && !context.Bool("detach")
 		if err := notifySocket.setupSpec(context, spec); err != nil {
 			return -1, err
 		}
 	}
 
-	container, err := createContainer(context, id, spec, uidShiftSupported, uidShiftRootfs, switchDockerDns, sysMgr, sysFs)
+	notifyProxy, err := newNotifyProxy(context, spec, id)
+	if err != nil {
+		return -1, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	if notifyProxy != nil {
+		notifyProxy.setupSpec(spec)
+	}
+
+	sigMap, err := syscont.GetSignalMap(spec)
+	if err != nil {
+		return -1, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	portFwds, err := syscont.GetPortForwards(spec)
+	if err != nil {
+		return -1, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+	bootstrapTimeout, err := syscont.GetBootstrapTimeout(spec.Annotations)
+	if err != nil {
+		return -1, &sysbox.Error{Code: sysbox.ErrInvalidSpec, Err: err}
+	}
+
+	container, err = sysCont.NewContainer(spec)
 	if err != nil {
 		return -1, err
 	}
@@ -522,6 +809,11 @@ func startContainer(context *cli.Context,
 			}
 		}
 	}
+	if notifyProxy != nil {
+		if err := notifyProxy.setupSocketDirectory(); err != nil {
+			return -1, err
+		}
+	}
 
 	// Support on-demand socket activation by passing file descriptors into the container init process.
 	listenFDs := []*os.File{}
@@ -529,25 +821,34 @@ func startContainer(context *cli.Context,
 		listenFDs = activation.Files(false)
 	}
 
-	logLevel := "info"
-	if context.GlobalBool("debug") {
-		logLevel = "debug"
+	// sysbox-runc: the container's log level may override the global one (see
+	// setupContainerLogging())
+	logLevel := logrus.GetLevel().String()
+
+	stdio, err := newStdioBackend(context)
+	if err != nil {
+		return -1, err
 	}
 
 	r := &runner{
-		enableSubreaper: !context.Bool("no-subreaper"),
-		shouldDestroy:   true,
-		container:       container,
-		listenFDs:       listenFDs,
-		notifySocket:    notifySocket,
-		consoleSocket:   context.String("console-socket"),
-		detach:          context.Bool("detach"),
-		pidFile:         context.String("pid-file"),
-		preserveFDs:     context.Int("preserve-fds"),
-		action:          action,
-		criuOpts:        criuOpts,
-		init:            true,
-		logLevel:        logLevel,
+		enableSubreaper:  !context.Bool("no-subreaper"),
+		shouldDestroy:    true,
+		container:        container,
+		listenFDs:        listenFDs,
+		notifySocket:     notifySocket,
+		consoleSocket:    context.String("console-socket"),
+		detach:           context.Bool("detach"),
+		pidFile:          context.String("pid-file"),
+		preserveFDs:      context.Int("preserve-fds"),
+		action:           action,
+		criuOpts:         criuOpts,
+		init:             true,
+		logLevel:         logLevel,
+		stdio:            stdio,
+		sigMap:           sigMap,
+		portProxy:        newPortProxy(context, portFwds),
+		notifyProxy:      notifyProxy,
+		bootstrapTimeout: bootstrapTimeout,
 	}
 	return r.run(spec.Process)
 }